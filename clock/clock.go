@@ -0,0 +1,63 @@
+// Package clock 抽象时间来源，代理管理器的过期、周期和探测逻辑通过它读取时间
+// 默认使用 Real，测试中可以用 Fake 手动推进时间，不需要真的等待
+package clock
+
+import "time"
+
+// Clock 时间来源
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer 对应 time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker 对应 time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real 使用系统时间的 Clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)           { time.Sleep(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// OrReal 在 c 为 nil 时返回 Real
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
@@ -0,0 +1,159 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake 只在调用 Advance/Set 时前进的 Clock，用于测试
+// 到期的定时器按到期时间顺序触发，Sleep 阻塞到时间被推进过截止时间
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter 一个未触发的定时器、打点器或 Sleep
+type fakeWaiter struct {
+	when   time.Time
+	period time.Duration // 打点器的间隔，定时器为 0
+	ch     chan time.Time
+}
+
+// NewFake 创建从 now 开始的 Fake，now 为零值时从一个固定时间开始
+func NewFake(now time.Time) *Fake {
+	if now.IsZero() {
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now 返回当前的假时间
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since 返回从 t 到当前假时间的间隔
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Sleep 阻塞到假时间被推进 d，d 不大于 0 时立即返回
+func (f *Fake) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-f.NewTimer(d).C()
+}
+
+// NewTimer 创建在假时间推进 d 后触发的定时器
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, w: &fakeWaiter{ch: make(chan time.Time, 1)}}
+	f.schedule(t.w, d)
+	return t
+}
+
+// NewTicker 创建每推进 d 触发一次的打点器，和 time.Ticker 一样来不及接收的触发会被丢弃
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTicker{f: f, w: &fakeWaiter{period: d, ch: make(chan time.Time, 1)}}
+	f.schedule(t.w, d)
+	return t
+}
+
+// Advance 把假时间推进 d，依次触发期间到期的定时器和打点器
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set 把假时间设置为 t，t 早于当前时间时不触发任何定时器
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].when.Before(f.waiters[j].when) })
+		if len(f.waiters) == 0 || f.waiters[0].when.After(t) {
+			break
+		}
+		w := f.waiters[0]
+		f.now = w.when
+		select {
+		case w.ch <- w.when:
+		default:
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = t
+}
+
+// Waiters 返回未触发的定时器、打点器和 Sleep 的数量
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil 阻塞到至少有 n 个未触发的定时器、打点器或 Sleep
+// 测试在 Advance 之前调用，确保后台 goroutine 已经开始等待
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) schedule(w *fakeWaiter, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.when = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// remove 取消等待，返回是否仍在等待
+func (f *Fake) remove(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.w.ch }
+func (t *fakeTimer) Stop() bool          { return t.f.remove(t.w) }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.f.remove(t.w)
+	t.f.schedule(t.w, d)
+	return active
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+func (t *fakeTicker) Stop()               { t.f.remove(t.w) }
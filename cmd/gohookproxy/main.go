@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ba0gu0/GoHookProxy/config"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `Usage:
+  gohookproxy check <config.yaml>     验证配置文件并输出生效的完整配置
+  gohookproxy migrate <config.yaml>   按兼容规则加载配置，输出标注当前版本的配置
+  gohookproxy schema                  输出配置的 JSON Schema
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "check":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = check(os.Args[2])
+	case "migrate":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = migrate(os.Args[2])
+	case "schema":
+		err = schema()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// check 验证配置文件，并输出合并默认值后的配置
+func check(path string) error {
+	cfg, warnings, err := config.LoadWithWarnings(path)
+	printWarnings(warnings)
+	if err != nil {
+		return err
+	}
+
+	// 不输出密码和密钥
+	out, err := yaml.Marshal(cfg.WithDefaults().Redacted())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "# %s: OK\n%s", path, out)
+	return nil
+}
+
+// migrate 按兼容规则加载配置文件并输出标注当前版本的配置
+func migrate(path string) error {
+	cfg, warnings, err := config.LoadWithWarnings(path)
+	printWarnings(warnings)
+	if err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, string(out))
+	return nil
+}
+
+// printWarnings 输出兼容和弃用警告
+func printWarnings(warnings []config.Warning) {
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+}
+
+// schema 输出 JSON Schema
+func schema() error {
+	out, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(out))
+	return nil
+}
@@ -1,8 +1,21 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/proxy/vmess"
 )
 
 // Default values
@@ -10,6 +23,9 @@ const (
 	DefaultIdleTimeout = time.Minute * 5
 	DefaultKeepAlive   = time.Minute * 5
 
+	// 不经过代理的直连拨号的超时
+	DefaultDirectDialTimeout = time.Second * 30
+
 	// HTTP proxy defaults
 	DefaultHTTPTimeout    = time.Second * 30
 	DefaultHTTPKeepAlive  = time.Second * 30
@@ -25,72 +41,1344 @@ const (
 	DefaultSOCKSUser      = ""
 	DefaultSOCKSPass      = ""
 
+	// VMess defaults
+	DefaultVMessTimeout   = time.Second * 30
+	DefaultVMessKeepAlive = time.Second * 30
+	DefaultVMessSecurity  = "auto"
+
+	// SSH defaults
+	DefaultSSHTimeout   = time.Second * 30
+	DefaultSSHKeepAlive = time.Second * 30
+
+	// Tor 控制端口请求的超时
+	DefaultTorControlTimeout = time.Second * 10
+
+	// WebSocket defaults
+	DefaultWSTimeout   = time.Second * 30
+	DefaultWSKeepAlive = time.Second * 30
+	DefaultWSPath      = "/?target={target}"
+
+	// gRPC defaults
+	DefaultGRPCTimeout   = time.Second * 30
+	DefaultGRPCKeepAlive = time.Second * 30
+
+	// Hysteria2 defaults，接收窗口与官方客户端相同
+	DefaultHysteria2Timeout             = time.Second * 10
+	DefaultHysteria2KeepAlive           = time.Second * 10
+	DefaultHysteria2IdleTimeout         = time.Second * 30
+	DefaultHysteria2StreamReceiveWindow = 8 << 20
+	DefaultHysteria2ConnReceiveWindow   = 20 << 20
+
+	// WireGuard 隧道接口的 MTU
+	DefaultWireGuardMTU = 1420
+
+	// UDP 关联空闲保活间隔，低于常见服务器 60 秒的空闲回收时间
+	DefaultSOCKSUDPKeepAlive = time.Second * 30
+	// UDP 关联收发的最大数据报负载，与以太网 MTU 相当
+	DefaultSOCKSMaxDatagramSize = 1500
+	// UDP 数据报负载的理论上限
+	MaxDatagramSize = 65507
+
+	// 自适应握手超时的下限
+	DefaultMinHandshakeTimeout = time.Second
+
+	// 握手中发现的代理能力的缓存时间
+	DefaultCapabilityTTL = time.Minute * 10
+	// 代理按策略拒绝目标(HTTP 403/451、SOCKS5 规则禁止)后，到同一目标的拨号直接失败的时间
+	DefaultNegativeCacheTTL = time.Second * 5
+
 	// Hook defaults
 	DefaultHookUDP       = false
 	DefaultDNSHook       = false
 	DefaultTLSHook       = false
+	DefaultExcludeSelf   = false
+	DefaultBypassPrivate = true
+	DefaultSelfPipe      = false
+	DefaultSelfTest      = false
 	DefaultMetricsEnable = false // 默认关闭指标收集
+
+	// Disable 等待正在进行的拦截拨号结束的时间
+	DefaultDisableTimeout = time.Second * 5
+
+	// 启动时代理不可用的处理方式及 direct_until_healthy 的探测间隔
+	DefaultStartupPolicy        = StartupLazy
+	DefaultStartupProbeInterval = time.Second * 5
+
+	// 按标签统计时最多跟踪的标签组合数
+	DefaultMetricsMaxLabelSets = 100
+	// 拨号阶段延迟的采样率，1 表示每次拨号都记录
+	DefaultMetricsSampleRate = 1
+
+	// SLO 的默认消耗速率告警阈值(1 小时内消耗 2% 的 30 天预算)、最少样本数和按目标跟踪的目标数
+	DefaultSLOBurnRateThreshold = 14.4
+	DefaultSLOMinSamples        = 10
+	DefaultSLOMaxDestinations   = 100
+
+	// 推送 OTLP 指标的间隔，与 OpenTelemetry SDK 的默认值相同
+	DefaultOTLPInterval = time.Minute
+
+	// 健康检查的探测间隔
+	DefaultHealthCheckInterval = time.Second * 30
+)
+
+// 预取解析器的缓存时间、过期前开始刷新的提前量、跟踪的主机名数和后台刷新的超时
+const (
+	DefaultPrefetchTTL          = time.Minute
+	DefaultPrefetchRefreshAhead = time.Second * 15
+	DefaultPrefetchMaxHosts     = 256
+	DefaultPrefetchTimeout      = time.Second * 5
+)
+
+// DefaultSLOWindows SLO 默认的滑动窗口，所有窗口的消耗速率都超过阈值时才认为预算有风险
+var DefaultSLOWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// StartupPolicy 启用 hook 时代理不可用的处理方式
+type StartupPolicy string
+
+const (
+	StartupLazy               StartupPolicy = "lazy"                 // 照常启用，拨号时返回错误
+	StartupFailFast           StartupPolicy = "fail_fast"            // Enable 时探测代理，不可用则返回错误
+	StartupDirectUntilHealthy StartupPolicy = "direct_until_healthy" // 先直连，探测通过后开始走代理
 )
 
 // ProxyType 代理类型
 type ProxyType string
 
 const (
-	Direct  ProxyType = "direct"
-	HTTP    ProxyType = "http"
-	HTTPS   ProxyType = "https"
-	HTTP2   ProxyType = "http2"
-	SOCKS4  ProxyType = "socks4"
+	Direct ProxyType = "direct"
+	HTTP   ProxyType = "http"
+	HTTPS  ProxyType = "https"
+	HTTP2  ProxyType = "http2"
+	HTTP3  ProxyType = "http3" // QUIC 上的 HTTP/3 CONNECT，代理端口为 UDP 端口
+	// SOCKS4 只能发送 IPv4 地址，目标主机名在本地解析
+	SOCKS4 ProxyType = "socks4"
+	// SOCKS4A 目标主机名始终按 SOCKS4a 扩展交给代理解析，hook 阻止走代理的主机名在本地解析
 	SOCKS4A ProxyType = "socks4a"
 	SOCKS5  ProxyType = "socks5"
+	// SOCKS5H 目标主机名始终交给代理解析，hook 阻止走代理的主机名在本地解析
+	SOCKS5H ProxyType = "socks5h"
+	// SOCKS5S TLS 上的 SOCKS5，等同于 SOCKS5 加 SOCKSConfig.TLS
+	SOCKS5S ProxyType = "socks5s"
+	// VMESS V2Ray 的 VMess 协议(AEAD 请求头)，需要 VMessConfig.UUID
+	VMESS ProxyType = "vmess"
+	// SSH 通过 SSH 跳板机的 direct-tcpip 通道连接目标，需要 SSHConfig
+	SSH ProxyType = "ssh"
+	// TOR 通过 Tor 的 SOCKS 端口连接，主机名由 Tor 解析，可以用 TorConfig 切换线路
+	TOR ProxyType = "tor"
+	// WIREGUARD 通过进程内的用户态 WireGuard 接口连接，代理地址为对端的 UDP endpoint，需要 -tags wireguard 构建
+	WIREGUARD ProxyType = "wireguard"
+	// WS 隧道数据以二进制帧承载在 WebSocket 连接上，目标地址写入请求路径，需要 WSConfig
+	WS ProxyType = "ws"
+	// WSS TLS 上的 WebSocket 隧道
+	WSS ProxyType = "wss"
+	// GRPC 隧道数据承载在 gRPC 双向流上，与 gun 传输兼容，需要 GRPCConfig
+	GRPC ProxyType = "grpc"
+	// HYSTERIA2 通过 QUIC 上的 Hysteria 2 协议连接，适合高延迟和丢包的链路，支持 TCP 和 UDP，需要 Hysteria2Config
+	HYSTERIA2 ProxyType = "hysteria2"
+
+	// Auto 按 Discovery 的偏好探测本地 sidecar 代理
+	Auto ProxyType = "auto"
 )
 
 type Config struct {
-	IdleTimeout time.Duration
-	KeepAlive   time.Duration
+	Version int `json:"version" yaml:"version"` // 配置格式版本，0 表示没有标注版本的旧配置
+
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	KeepAlive   time.Duration `json:"keep_alive" yaml:"keep_alive"`
+
+	// 直连拨号(未启用代理、规则直连、hook 直连和 FallbackDirect)建立连接的超时，ctx 的截止时间更早时以 ctx 为准，0 表示只受 ctx 限制
+	DirectDialTimeout time.Duration `json:"direct_dial_timeout" yaml:"direct_dial_timeout"`
 
 	// Proxy configurations
-	HTTPConfig  *HTTPConfig
-	SOCKSConfig *SOCKSConfig
+	HTTPConfig  *HTTPConfig  `json:"http" yaml:"http"`
+	SOCKSConfig *SOCKSConfig `json:"socks" yaml:"socks"`
+	VMessConfig *VMessConfig `json:"vmess" yaml:"vmess"`
+	SSHConfig   *SSHConfig   `json:"ssh" yaml:"ssh"`
+	TorConfig   *TorConfig   `json:"tor" yaml:"tor"`
+	WGConfig    *WGConfig    `json:"wireguard" yaml:"wireguard"`
+	WSConfig    *WSConfig    `json:"websocket" yaml:"websocket"`
+	GRPCConfig  *GRPCConfig  `json:"grpc" yaml:"grpc"`
+
+	Hysteria2Config *Hysteria2Config `json:"hysteria2" yaml:"hysteria2"`
+
+	// 到代理的 TCP 连接使用的混淆传输插件，在代理协议和 TLS 之下，为 nil 时不使用
+	Transport *TransportConfig `json:"transport" yaml:"transport"`
 
 	// Proxy settings
-	HookUDP   bool
-	ProxyType ProxyType
-	ProxyIP   string
-	ProxyPort int
-	Enable    bool
+	HookUDP   bool      `json:"hook_udp" yaml:"hook_udp"`
+	ProxyType ProxyType `json:"proxy_type" yaml:"proxy_type"`
+	ProxyIP   string    `json:"proxy_ip" yaml:"proxy_ip"`
+	ProxyPort int       `json:"proxy_port" yaml:"proxy_port"`
+	Enable    bool      `json:"enable" yaml:"enable"`
+
+	// 握手中发现的代理能力(UDP ASSOCIATE、无认证、IPv6 地址、h2 CONNECT)按代理地址缓存的时间，0 表示不缓存
+	CapabilityTTL time.Duration `json:"capability_ttl" yaml:"capability_ttl"`
+
+	// 代理按策略拒绝目标后缓存该失败的时间，期间到同一目标的拨号直接返回 ErrRecentFailure，0 表示不缓存
+	NegativeCacheTTL time.Duration `json:"negative_cache_ttl" yaml:"negative_cache_ttl"`
+
+	// 启用 hook 时代理不可用的处理方式，为空时为 lazy
+	StartupPolicy        StartupPolicy `json:"startup_policy" yaml:"startup_policy"`
+	StartupProbeInterval time.Duration `json:"startup_probe_interval" yaml:"startup_probe_interval"`
+
+	// 代理不可达或握手失败时改为直连目标，代理按策略拒绝的目标不直连；不能与 tor 一起使用
+	FallbackDirect bool `json:"fallback_direct" yaml:"fallback_direct"`
+
+	// ProxyType 为 auto 时按顺序探测的候选，可以是内置名称(envoy、tor、clash 等)
+	// 或 socks5://127.0.0.1:1080 这样的地址，为空时使用内置列表
+	Discovery []string `json:"discovery" yaml:"discovery"`
+
+	// 发往本进程监听端口的连接(自连接)直连，不经过代理
+	ExcludeSelf bool `json:"exclude_self" yaml:"exclude_self"`
+	// hook 拦截的到 localhost、回环地址、RFC1918 私有网段、链路本地地址和 *.local 的连接直连，见 rules.PrivateBypass
+	// 晚于 BypassList 和 Rules 检查，规则可以让其中的目标经过代理；显式调用 DialContext 时仍然走代理
+	BypassPrivate bool `json:"bypass_private" yaml:"bypass_private"`
+	// 发往 proxy.WrapListener 包装的本进程监听器的连接走内存管道
+	SelfPipe bool `json:"self_pipe" yaml:"self_pipe"`
 
 	// Hook settings
-	DNSHook       bool
-	TLSHook       bool
-	MetricsEnable bool
+	DNSHook       bool `json:"dns_hook" yaml:"dns_hook"`
+	TLSHook       bool `json:"tls_hook" yaml:"tls_hook"`
+	MetricsEnable bool `json:"metrics_enable" yaml:"metrics_enable"`
+	// Enable 时通过进程内监听器验证 hook 确实生效
+	SelfTest bool `json:"self_test" yaml:"self_test"`
+	// 目标为 IP 且没有命中路由规则的 TCP 连接推迟到客户端发送 TLS ClientHello 后，按其中的 SNI 匹配路由规则
+	SNIRouting bool `json:"sni_routing" yaml:"sni_routing"`
+	// 应用拨号管理器为它解析的 IP 时还原为主机名，代理收到主机名，规则按主机名匹配，解析选择的 IP 作为提示交给拨号器
+	ResolvedHints bool `json:"resolved_hints" yaml:"resolved_hints"`
+	// Disable 等待正在进行的拦截拨号结束的最长时间，0 表示不等待
+	DisableTimeout time.Duration `json:"disable_timeout" yaml:"disable_timeout"`
+	// 按标签统计的组合数上限，超出的组合汇总统计，0 时为 DefaultMetricsMaxLabelSets，负数表示不限制
+	MetricsMaxLabelSets int `json:"metrics_max_label_sets" yaml:"metrics_max_label_sets"`
+	// 每 N 次拨号记录 1 次拨号阶段延迟，连接数和字节数等计数器不采样，0 和 1 表示全部记录
+	MetricsSampleRate int `json:"metrics_sample_rate" yaml:"metrics_sample_rate"`
+
+	// 按目标地址覆盖最终一跳的 TLS 设置，需要启用 TLSHook
+	TLSRules []TLSRule `json:"tls_rules" yaml:"tls_rules"`
+
+	// 按连接标签(proxy.WithLabels)限制连接数和流量
+	Quotas []Quota `json:"quotas" yaml:"quotas"`
+
+	// 不经过代理的目标，先于 Rules 检查，格式见 ParseBypassEntry，如 localhost、*.corp.local、10.0.0.0/8、:8080
+	BypassList []string `json:"bypass_list" yaml:"bypass_list"`
+
+	// hook 拦截的拨号在调用栈中有这些包(导入路径，包含子包)的函数时直连，如不应经过代理的遥测 SDK
+	// 只检查拨号所在 goroutine 的调用栈，http.Transport 等在自己的 goroutine 中拨号的库看不到发起请求的包
+	BypassPackages []string `json:"bypass_packages" yaml:"bypass_packages"`
+
+	// 按目标主机的路由规则，按顺序匹配，第一个命中的生效
+	Rules []Rule `json:"rules" yaml:"rules"`
+
+	// 额外的 BypassList 条目和路由规则所在的 YAML/JSON 文件，格式见 RuleSet
+	// 文件变化时重新加载并替换编译后的规则，不需要 UpdateConfig 或重新启用 hook；内容无效时继续使用上次的规则
+	RulesFile string `json:"rules_file" yaml:"rules_file"`
+
+	// 代理自动配置(PAC)脚本的地址或本地文件，二者只能设置一个
+	// 没有命中 BypassList 和 Rules 的 TCP 目标由脚本的 FindProxyForURL 决定直连或经过哪个代理
+	PACURL  string `json:"pac_url" yaml:"pac_url"`
+	PACFile string `json:"pac_file" yaml:"pac_file"`
+
+	// IPv6-only 网络上直连的 IPv4 目标按 NAT64 前缀转换为 IPv6 地址，为 nil 时不转换
+	NAT64 *NAT64Config `json:"nat64" yaml:"nat64"`
+
+	// 主机名解析到多个地址时按各地址的历史拨号结果选择，为 nil 时按解析器返回的顺序使用第一个
+	AddrSelection *AddrSelectionConfig `json:"addr_selection" yaml:"addr_selection"`
+
+	// 按优先级类别排队拨号和共享限速，为 nil 时不调度
+	Scheduler *SchedulerConfig `json:"scheduler" yaml:"scheduler"`
+
+	// 多路径竞速拨号，为 nil 时只走代理
+	Race *RaceConfig `json:"race" yaml:"race"`
+
+	// UDP 流量使用的代理，为 nil 时与 TCP 使用同一个代理
+	// 许多 HTTP 代理不能转发 UDP，可以让 TCP 走 HTTP 代理、UDP 走 SOCKS5 代理
+	UDPProxy *UDPProxyConfig `json:"udp_proxy" yaml:"udp_proxy"`
+
+	// 命名的代理，规则的 Proxy 或 ProxyManager.Use 按名称选择，未选择时使用 ProxyType/ProxyIP/ProxyPort 配置的代理
+	Proxies []NamedProxy `json:"proxies" yaml:"proxies"`
+
+	// 在主代理和命名代理之间分配走代理的 TCP 拨号，为 nil 时只使用主代理
+	Balance *BalanceConfig `json:"balance" yaml:"balance"`
+
+	// 定期探测主代理和命名代理，负载均衡和故障转移只使用可用的代理，为 nil 时不探测
+	HealthCheck *HealthCheckConfig `json:"health_check" yaml:"health_check"`
+
+	// 代理出站的成功率和延迟 SLO，为 nil 时不跟踪
+	SLO *SLOConfig `json:"slo" yaml:"slo"`
+
+	// 定期把指标以 OTLP/HTTP 推送到 OpenTelemetry Collector，需要启用 MetricsEnable，为 nil 时不推送
+	OTLP *OTLPConfig `json:"otlp" yaml:"otlp"`
+
+	// 按名称关闭或打开功能，如 {"race": false}，没有设置的使用默认值，见 Features
+	Features map[Feature]bool `json:"features" yaml:"features"`
+}
+
+// TransportConfig 传输插件配置，插件通过 transport.Register 按名称注册
+type TransportConfig struct {
+	Name    string            `json:"name" yaml:"name"`       // 注册的插件名称，如 obfs4
+	Options map[string]string `json:"options" yaml:"options"` // 传给插件的选项，含义由插件定义
+}
+
+// validate 验证插件已经注册
+func (t *TransportConfig) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, ok := transport.Lookup(t.Name); !ok {
+		return fmt.Errorf("plugin %q is not registered", t.Name)
+	}
+	return nil
+}
+
+// OTLPConfig OTLP 指标推送配置
+type OTLPConfig struct {
+	Endpoint string            `json:"endpoint" yaml:"endpoint"` // 完整的接收地址，如 http://collector:4318/v1/metrics
+	Headers  map[string]string `json:"headers" yaml:"headers"`   // 附加的请求头，如认证令牌
+	Resource map[string]string `json:"resource" yaml:"resource"` // 资源属性，如 service.name，未设置时使用进程名
+	Interval time.Duration     `json:"interval" yaml:"interval"` // 推送间隔，0 表示默认值
+}
+
+// validate 验证接收地址和推送间隔
+func (o *OTLPConfig) validate() error {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint: %q", o.Endpoint)
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("invalid interval: %v", o.Interval)
+	}
+	return nil
+}
+
+// SLOConfig 代理拨号的 SLO 目标，按代理和目标主机在滑动窗口内统计
+type SLOConfig struct {
+	SuccessTarget    float64       `json:"success_target" yaml:"success_target"`       // 拨号成功率目标，如 0.999，0 表示不跟踪
+	LatencyTarget    time.Duration `json:"latency_target" yaml:"latency_target"`       // 拨号延迟阈值，0 表示不跟踪延迟
+	LatencyObjective float64       `json:"latency_objective" yaml:"latency_objective"` // 延迟不超过阈值的拨号比例目标，如 0.99
+
+	Windows           []time.Duration `json:"windows" yaml:"windows"`                         // 滑动窗口，为空时为 5m 和 1h
+	BurnRateThreshold float64         `json:"burn_rate_threshold" yaml:"burn_rate_threshold"` // 所有窗口的消耗速率都达到该值时触发回调，0 表示默认值
+	MinSamples        int             `json:"min_samples" yaml:"min_samples"`                 // 窗口内样本少于该值时不计算消耗速率，0 表示默认值
+
+	PerDestination  bool `json:"per_destination" yaml:"per_destination"`   // 是否同时按目标主机跟踪
+	MaxDestinations int  `json:"max_destinations" yaml:"max_destinations"` // 按目标跟踪的主机数上限，超出的汇总统计，0 表示默认值
+}
+
+// validate 验证 SLO 目标和窗口
+func (s *SLOConfig) validate() error {
+	if s.SuccessTarget < 0 || s.SuccessTarget >= 1 {
+		return fmt.Errorf("success target must be in [0, 1): %v", s.SuccessTarget)
+	}
+	if s.LatencyTarget < 0 {
+		return fmt.Errorf("latency target cannot be negative: %v", s.LatencyTarget)
+	}
+	if s.LatencyTarget > 0 && (s.LatencyObjective <= 0 || s.LatencyObjective >= 1) {
+		return fmt.Errorf("latency objective must be in (0, 1): %v", s.LatencyObjective)
+	}
+	if s.SuccessTarget == 0 && s.LatencyTarget == 0 {
+		return fmt.Errorf("no objective configured")
+	}
+	for _, w := range s.Windows {
+		if w <= 0 {
+			return fmt.Errorf("invalid window: %v", w)
+		}
+	}
+	if s.BurnRateThreshold < 0 {
+		return fmt.Errorf("burn rate threshold cannot be negative: %v", s.BurnRateThreshold)
+	}
+	if s.MinSamples < 0 || s.MaxDestinations < 0 {
+		return fmt.Errorf("min samples and max destinations cannot be negative")
+	}
+	return nil
+}
+
+// RaceMode 竞速的第二条路径
+type RaceMode string
+
+const (
+	RaceDirect RaceMode = "direct" // 代理与直连竞速
+	RaceProxy  RaceMode = "proxy"  // 代理与备用代理竞速
+)
+
+// RaceConfig 多路径竞速拨号配置，两条路径同时拨号，使用先建立的连接并关闭另一条
+type RaceConfig struct {
+	Mode  RaceMode      `json:"mode" yaml:"mode"`
+	Delay time.Duration `json:"delay" yaml:"delay"` // 第二条路径晚于代理启动的时间，代理在此之前失败时立即启动
+
+	// 只对匹配的目标竞速，支持 *.example.com 通配子域名，为空时对所有 TCP 代理目标竞速
+	// direct 模式下命中 proxy 规则的目标不会竞速
+	Patterns []string `json:"patterns" yaml:"patterns"`
+
+	// proxy 模式下的备用代理，HTTP/SOCKS 设置沿用主配置
+	ProxyType ProxyType `json:"proxy_type" yaml:"proxy_type"`
+	ProxyIP   string    `json:"proxy_ip" yaml:"proxy_ip"`
+	ProxyPort int       `json:"proxy_port" yaml:"proxy_port"`
+}
+
+// ProxyConfig 返回备用代理的配置，基于 base 替换代理地址
+func (r *RaceConfig) ProxyConfig(base *Config) *Config {
+	cfg := base.clone()
+	cfg.Enable = true
+	cfg.ProxyType = r.ProxyType
+	cfg.ProxyIP = r.ProxyIP
+	cfg.ProxyPort = r.ProxyPort
+	cfg.Race = nil
+	return cfg
+}
+
+// validate 验证竞速参数
+func (r *RaceConfig) validate(base *Config) error {
+	if r.Delay < 0 {
+		return fmt.Errorf("delay cannot be negative")
+	}
+	switch r.Mode {
+	case RaceDirect:
+		return nil
+	case RaceProxy:
+		if r.ProxyType == Direct || r.ProxyType == Auto {
+			return fmt.Errorf("unsupported proxy type: %s", r.ProxyType)
+		}
+		return r.ProxyConfig(base).Validate()
+	default:
+		return fmt.Errorf("unsupported mode: %q", r.Mode)
+	}
+}
+
+// UDPProxyConfig UDP 流量单独使用的代理，HTTP/SOCKS 等设置沿用主配置，传输插件只用于主代理
+type UDPProxyConfig struct {
+	ProxyType ProxyType `json:"proxy_type" yaml:"proxy_type"`
+	ProxyIP   string    `json:"proxy_ip" yaml:"proxy_ip"`
+	ProxyPort int       `json:"proxy_port" yaml:"proxy_port"`
+}
+
+// ProxyConfig 返回 UDP 代理的配置，基于 base 替换代理地址
+func (u *UDPProxyConfig) ProxyConfig(base *Config) *Config {
+	cfg := base.clone()
+	cfg.Enable = true
+	cfg.HookUDP = true
+	cfg.ProxyType = u.ProxyType
+	cfg.ProxyIP = u.ProxyIP
+	cfg.ProxyPort = u.ProxyPort
+	cfg.Race = nil
+	cfg.UDPProxy = nil
+	cfg.Transport = nil
+	return cfg
+}
+
+// validate 验证 UDP 代理，代理类型需要能够转发 UDP
+func (u *UDPProxyConfig) validate(base *Config) error {
+	switch u.ProxyType {
+	case Direct, Auto, SOCKS4, SOCKS4A:
+		return fmt.Errorf("unsupported proxy type: %s", u.ProxyType)
+	}
+	return u.ProxyConfig(base).Validate()
+}
+
+// NamedProxy 命名的代理，HTTP/SOCKS 等协议设置沿用主配置，传输插件只用于主代理
+type NamedProxy struct {
+	Name      string    `json:"name" yaml:"name"`
+	ProxyType ProxyType `json:"proxy_type" yaml:"proxy_type"`
+	ProxyIP   string    `json:"proxy_ip" yaml:"proxy_ip"`
+	ProxyPort int       `json:"proxy_port" yaml:"proxy_port"`
+	Weight    int       `json:"weight" yaml:"weight"` // weighted 均衡时的权重，0 表示 1
+}
+
+// ProxyConfig 返回连接该代理使用的配置
+func (p *NamedProxy) ProxyConfig(base *Config) *Config {
+	cfg := base.clone()
+	cfg.Enable = true
+	cfg.ProxyType = p.ProxyType
+	cfg.ProxyIP = p.ProxyIP
+	cfg.ProxyPort = p.ProxyPort
+	cfg.Race = nil
+	cfg.UDPProxy = nil
+	cfg.Proxies = nil
+	cfg.Balance = nil
+	cfg.HealthCheck = nil
+	cfg.Rules = nil
+	cfg.Transport = nil
+	return cfg
+}
+
+// validate 验证命名代理
+func (p *NamedProxy) validate(base *Config) error {
+	if p.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if p.Weight < 0 {
+		return fmt.Errorf("weight cannot be negative")
+	}
+	switch p.ProxyType {
+	case Direct, Auto:
+		return fmt.Errorf("unsupported proxy type: %s", p.ProxyType)
+	}
+	return p.ProxyConfig(base).Validate()
+}
+
+// NamedProxy 返回名称为 name 的命名代理，没有时返回 nil
+func (c *Config) NamedProxy(name string) *NamedProxy {
+	for i := range c.Proxies {
+		if c.Proxies[i].Name == name {
+			return &c.Proxies[i]
+		}
+	}
+	return nil
+}
+
+// BalanceStrategy 负载均衡选择上游代理的方式
+type BalanceStrategy string
+
+const (
+	BalanceRoundRobin BalanceStrategy = "round-robin" // 依次使用各代理
+	BalanceLeastConn  BalanceStrategy = "least-conn"  // 使用当前活动连接最少的代理，相同时依次使用
+	BalanceWeighted   BalanceStrategy = "weighted"    // 按权重的比例平滑地分配拨号
+	BalanceLatency    BalanceStrategy = "latency"     // 使用拨号耗时 EWMA 最低的代理，最近失败的代理暂不使用
+)
+
+// BalanceConfig 负载均衡配置，主代理总是参与均衡
+// 规则指定了命名代理或 ProxyManager.Use 选择了命名代理时不均衡
+type BalanceConfig struct {
+	Strategy      BalanceStrategy `json:"strategy" yaml:"strategy"`             // 为空时为 round-robin
+	PrimaryWeight int             `json:"primary_weight" yaml:"primary_weight"` // weighted 策略中主代理的权重，0 表示 1
+
+	// 参与均衡的命名代理(Proxies 中的名称)，为空时使用所有命名代理
+	Proxies []string `json:"proxies" yaml:"proxies"`
+}
+
+// Members 返回参与均衡的命名代理名称，不含主代理
+func (b *BalanceConfig) Members(c *Config) []string {
+	if len(b.Proxies) > 0 {
+		return b.Proxies
+	}
+	names := make([]string, len(c.Proxies))
+	for i := range c.Proxies {
+		names[i] = c.Proxies[i].Name
+	}
+	return names
+}
+
+// validate 验证负载均衡参数，成员必须是 Proxies 中的名称
+func (b *BalanceConfig) validate(c *Config) error {
+	switch b.Strategy {
+	case "", BalanceRoundRobin, BalanceLeastConn, BalanceWeighted, BalanceLatency:
+	default:
+		return fmt.Errorf("unsupported strategy: %q", b.Strategy)
+	}
+	if b.PrimaryWeight < 0 {
+		return fmt.Errorf("primary weight cannot be negative")
+	}
+	members := b.Members(c)
+	if len(members) == 0 {
+		return fmt.Errorf("at least one named proxy is required")
+	}
+	seen := make(map[string]bool, len(members))
+	for _, name := range members {
+		if c.NamedProxy(name) == nil {
+			return fmt.Errorf("unknown proxy %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate proxy %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// HealthCheckConfig 主动健康检查配置，探测连接代理并完成代理协议的握手，设置 URL 时再通过代理请求该地址
+// 连续 Fall 次探测失败的代理标记为不可用，之后连续 Rise 次探测成功时恢复；还没有探测结果的代理按可用处理
+type HealthCheckConfig struct {
+	Interval time.Duration `json:"interval" yaml:"interval"` // 探测间隔，0 表示默认值
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`   // 单次探测的超时，0 时使用代理类型的握手超时
+	URL      string        `json:"url" yaml:"url"`           // 握手后通过代理请求的 http 地址，返回 2xx 或 3xx 时成功，为空时只检查握手
+	Fall     int           `json:"fall" yaml:"fall"`         // 标记为不可用需要的连续失败次数，0 表示 1
+	Rise     int           `json:"rise" yaml:"rise"`         // 恢复为可用需要的连续成功次数，0 表示 1
+
+	// 拨号使用的代理(主代理、规则或 Use 选择的命名代理)不可用时，改用主代理和 Proxies 中第一个可用的代理
+	Failover bool `json:"failover" yaml:"failover"`
+}
+
+// validate 验证健康检查参数
+func (h *HealthCheckConfig) validate() error {
+	if h.Interval < 0 || h.Timeout < 0 {
+		return fmt.Errorf("interval and timeout cannot be negative")
+	}
+	if h.Fall < 0 || h.Rise < 0 {
+		return fmt.Errorf("fall and rise cannot be negative")
+	}
+	if h.URL != "" {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url: expected an http or https url, got %q", h.URL)
+		}
+	}
+	return nil
+}
+
+// Rule 按目标匹配的路由规则
+type Rule struct {
+	Type    RuleType `json:"type" yaml:"type"`       // 匹配方式，为空时为 domain
+	Pattern string   `json:"pattern" yaml:"pattern"` // 匹配的目标，含义由 Type 决定，final 规则不需要
+	Action  string   `json:"action" yaml:"action"`   // proxy、direct 或 reject，为空时为 proxy
+	User    string   `json:"user" yaml:"user"`       // 访问该目标时使用的代理用户名，为空时使用全局凭证
+	Pass    string   `json:"pass" yaml:"pass"`       // 访问该目标时使用的代理密码
+	DSCP    string   `json:"dscp" yaml:"dscp"`       // 走代理时到代理的连接使用的 DSCP，如 cs1、af41、ef 或 0-63 的数值
+	Proxy   string   `json:"proxy" yaml:"proxy"`     // 走代理时使用的命名代理(Proxies 中的名称)，为空时使用当前选择的代理
+
+	// 流量上限，用于按流量计费的出口，0 表示不限制
+	MaxConnBytes  int64 `json:"max_conn_bytes" yaml:"max_conn_bytes"`   // 单个连接收发的字节数，超出后关闭连接
+	MaxDailyBytes int64 `json:"max_daily_bytes" yaml:"max_daily_bytes"` // 每个目标主机每天收发的字节数，超出后关闭连接并拒绝新的拨号
+
+	Priority Priority `json:"priority" yaml:"priority"` // 连接的优先级类别，为空时为 interactive
+
+	Tuning SocketTuning `json:"tuning" yaml:"tuning"` // 连接的套接字调优预设，为空时使用系统默认
+}
+
+// RuleType 路由规则的匹配方式
+type RuleType string
+
+const (
+	RuleDomain        RuleType = "domain"         // 主机名等于 Pattern，*.example.com 匹配所有子域名
+	RuleDomainSuffix  RuleType = "domain-suffix"  // 主机名等于 Pattern 或是它的子域名
+	RuleDomainKeyword RuleType = "domain-keyword" // 主机名包含 Pattern
+	RuleDomainRegex   RuleType = "domain-regex"   // 小写的主机名匹配正则表达式 Pattern(RE2 语法)，需要完整匹配时写 ^ 和 $
+	RuleIPCIDR        RuleType = "ip-cidr"        // 目标为 Pattern 网段内的 IP 字面量，不解析主机名
+	RuleDstPort       RuleType = "dst-port"       // 目标端口在 Pattern 中，可以是 443、8000-8999 或以 / 分隔的列表 22/3306/8000-8999
+	RuleFinal         RuleType = "final"          // 匹配所有目标，之后的规则不再生效
+)
+
+// 规则动作
+const (
+	ActionProxy  = "proxy"  // 通过代理连接
+	ActionDirect = "direct" // 直接连接
+	ActionReject = "reject" // 拒绝连接，拨号返回 ErrRuleRejected
+)
+
+// ParseRule 解析 Clash 风格的规则行，如 DOMAIN-SUFFIX,corp.local,DIRECT 或 FINAL,PROXY
+// 类型和动作不区分大小写，MATCH 等同于 FINAL，IP-CIDR6 等同于 IP-CIDR，其后的 no-resolve 等选项忽略；
+// DOMAIN-REGEX 的动作是最后一个字段，表达式可以包含逗号，如 DOMAIN-REGEX,^ad[0-9]{1,3}\.,REJECT
+func ParseRule(line string) (Rule, error) {
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	var r Rule
+	switch typ := strings.ToUpper(fields[0]); typ {
+	case "FINAL", "MATCH":
+		if len(fields) != 2 {
+			return Rule{}, fmt.Errorf("invalid rule %q: expected %s,ACTION", line, typ)
+		}
+		r = Rule{Type: RuleFinal, Action: strings.ToLower(fields[1])}
+	case "DOMAIN", "DOMAIN-SUFFIX", "DOMAIN-KEYWORD", "IP-CIDR", "IP-CIDR6", "DST-PORT":
+		if len(fields) < 3 {
+			return Rule{}, fmt.Errorf("invalid rule %q: expected %s,PATTERN,ACTION", line, typ)
+		}
+		if typ == "IP-CIDR6" {
+			typ = "IP-CIDR"
+		}
+		r = Rule{Type: RuleType(strings.ToLower(typ)), Pattern: fields[1], Action: strings.ToLower(fields[2])}
+	case "DOMAIN-REGEX":
+		if len(fields) < 3 {
+			return Rule{}, fmt.Errorf("invalid rule %q: expected %s,PATTERN,ACTION", line, typ)
+		}
+		// 表达式本身可能含有逗号，按原样取第一个和最后一个逗号之间的内容
+		_, rest, _ := strings.Cut(line, ",")
+		i := strings.LastIndex(rest, ",")
+		r = Rule{Type: RuleDomainRegex, Pattern: strings.TrimSpace(rest[:i]), Action: strings.ToLower(fields[len(fields)-1])}
+	default:
+		return Rule{}, fmt.Errorf("invalid rule %q: unsupported type %q", line, fields[0])
+	}
+	if err := r.validate(); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: %w", line, err)
+	}
+	return r, nil
+}
+
+// ParsePortRange 解析 443 或 8000-8999 形式的端口范围
+func ParsePortRange(s string) (lo, hi int, err error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(first); err != nil || lo < 1 || lo > 65535 {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(last); err != nil || hi < lo || hi > 65535 {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return lo, hi, nil
+}
+
+// PortRange 端口范围，包含 Lo 和 Hi
+type PortRange struct {
+	Lo, Hi int
+}
+
+// Contains 判断端口是否在范围内
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Lo && port <= r.Hi
+}
+
+// ParsePortList 解析以 / 分隔的端口和端口范围，如 443、22/3306 或 80/443/8000-8999
+// 与 Clash 的 DST-PORT 写法一致，规则行以逗号分隔字段，列表不能使用逗号
+func ParsePortList(s string) ([]PortRange, error) {
+	var list []PortRange
+	for _, item := range strings.Split(s, "/") {
+		lo, hi, err := ParsePortRange(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, PortRange{Lo: lo, Hi: hi})
+	}
+	return list, nil
+}
+
+// validate 按匹配方式验证规则
+func (r Rule) validate() error {
+	switch r.Type {
+	case "", RuleDomain, RuleDomainSuffix, RuleDomainKeyword:
+		if r.Pattern == "" {
+			return fmt.Errorf("pattern cannot be empty")
+		}
+	case RuleDomainRegex:
+		if r.Pattern == "" {
+			return fmt.Errorf("pattern cannot be empty")
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", r.Pattern, err)
+		}
+	case RuleIPCIDR:
+		if _, err := netip.ParsePrefix(r.Pattern); err != nil {
+			if _, err := netip.ParseAddr(r.Pattern); err != nil {
+				return fmt.Errorf("invalid cidr %q", r.Pattern)
+			}
+		}
+	case RuleDstPort:
+		if _, err := ParsePortList(r.Pattern); err != nil {
+			return err
+		}
+	case RuleFinal:
+		if r.Pattern != "" {
+			return fmt.Errorf("final rule takes no pattern")
+		}
+	default:
+		return fmt.Errorf("unsupported type: %q", r.Type)
+	}
+	switch r.Action {
+	case "", ActionProxy, ActionDirect, ActionReject:
+	default:
+		return fmt.Errorf("unsupported action: %q", r.Action)
+	}
+	if r.Proxy != "" && r.Action != "" && r.Action != ActionProxy {
+		return fmt.Errorf("proxy %q requires the proxy action, got %q", r.Proxy, r.Action)
+	}
+	if r.DSCP != "" {
+		if _, err := ParseDSCP(r.DSCP); err != nil {
+			return err
+		}
+	}
+	switch r.Tuning {
+	case "", TuningLowLatency, TuningThroughput:
+	default:
+		return fmt.Errorf("unsupported tuning: %q", r.Tuning)
+	}
+	return nil
+}
+
+// validateBypassList 检查 BypassList 条目
+func validateBypassList(entries []string) error {
+	for _, entry := range entries {
+		if _, err := ParseBypassEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRules 检查路由规则
+func validateRules(rules []Rule) error {
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		if r.MaxConnBytes < 0 || r.MaxDailyBytes < 0 {
+			return fmt.Errorf("rule %d: byte caps cannot be negative", i)
+		}
+		switch r.Priority {
+		case "", PriorityInteractive, PriorityBulk:
+		default:
+			return fmt.Errorf("rule %d: unsupported priority: %q", i, r.Priority)
+		}
+	}
+	return nil
+}
+
+// SocketTuning 套接字调优预设，设置在到代理(或直连目标)的 TCP 连接上
+type SocketTuning string
+
+const (
+	TuningLowLatency SocketTuning = "low-latency" // 开启 TCP_NODELAY，缩小发送缓冲区，小的写入立即发出，用于 gRPC 等 RPC
+	TuningThroughput SocketTuning = "throughput"  // 关闭 TCP_NODELAY 合并小的写入，增大收发缓冲区，用于批量传输
+)
+
+// Priority 连接的优先级类别，决定 Scheduler 中排队拨号和共享限速的先后
+type Priority string
+
+const (
+	PriorityInteractive Priority = "interactive" // 交互式请求，优先于 bulk
+	PriorityBulk        Priority = "bulk"        // 备份、同步等批量传输，只使用 interactive 剩下的拨号名额和带宽
+)
+
+// AddrSelectionConfig 按地址统计拨号延迟和失败，在本地解析得到的多个地址中选择表现最好的
+// 直连拨号和 SOCKS4 这样在本地解析目标的代理都会使用和更新这些统计
+type AddrSelectionConfig struct {
+	ExploreInterval time.Duration `json:"explore_interval" yaml:"explore_interval"` // 地址的统计超过这个时间没有更新时重新拨号测量，0 表示 5 分钟
+	MaxAddrs        int           `json:"max_addrs" yaml:"max_addrs"`               // 保存统计的地址数上限，超出时淘汰最久没有更新的，0 表示 4096
+}
+
+// SchedulerConfig 经过代理的连接的调度配置，用于带宽或并发受限的代理
+type SchedulerConfig struct {
+	MaxConcurrentDials int   `json:"max_concurrent_dials" yaml:"max_concurrent_dials"` // 同时进行的代理拨号数上限，超出的拨号排队，interactive 先于 bulk，0 表示不限制
+	Rate               int64 `json:"rate" yaml:"rate"`                                 // 所有经过代理的连接共享的收发速率(字节/秒)，bulk 只使用 interactive 剩下的部分，0 表示不限制
+	Burst              int64 `json:"burst" yaml:"burst"`                               // 限速允许的突发字节数，0 表示与 Rate 相同
+}
+
+// validate 验证调度参数
+func (s *SchedulerConfig) validate() error {
+	if s.MaxConcurrentDials < 0 || s.Rate < 0 || s.Burst < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	return nil
+}
+
+// NAT64WellKnownPrefix RFC 6052 的 NAT64 知名前缀，只能转换公网 IPv4 地址
+var NAT64WellKnownPrefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// NAT64Config NAT64/DNS64 设置
+type NAT64Config struct {
+	// NAT64 前缀，如 64:ff9b::/96，长度为 32、40、48、56、64 或 96；为空时按 RFC 7050 解析 ipv4only.arpa 发现
+	Prefix string `json:"prefix" yaml:"prefix"`
+}
+
+// ParseNAT64Prefix 解析 NAT64 前缀，检查 RFC 6052 允许的长度，/96 前缀的第 64-71 位必须为 0
+func ParseNAT64Prefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !p.Addr().Is6() || p.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("nat64 prefix must be IPv6: %s", s)
+	}
+	switch p.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return netip.Prefix{}, fmt.Errorf("nat64 prefix length must be 32, 40, 48, 56, 64 or 96: %s", s)
+	}
+	p = p.Masked()
+	if p.Addr().As16()[8] != 0 {
+		return netip.Prefix{}, fmt.Errorf("nat64 prefix bits 64-71 must be zero: %s", s)
+	}
+	return p, nil
+}
+
+// BypassEntry 解析后的 BypassList 条目，命中的目标不经过代理
+type BypassEntry struct {
+	Host   string       // 主机名，*.example.com 匹配所有子域名，* 匹配所有主机，为空时不按主机名匹配
+	Prefix netip.Prefix // IP 网段，无效时不按 IP 匹配
+	Port   int          // 目标端口，0 表示任意端口
+}
+
+// String 返回条目的规范形式
+func (b BypassEntry) String() string {
+	host := b.Host
+	if b.Prefix.IsValid() {
+		host = b.Prefix.String()
+	}
+	if b.Port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(b.Port))
+}
+
+// ParseBypassEntry 解析绕过条目，接受主机名(example.com)、通配后缀(*.corp.local 或 .corp.local)、
+// IP 或网段(10.0.0.0/8、::1)，以及可选的端口(*.corp.local:8443、[::1]:8080)，只有端口(:8080)时匹配所有主机
+func ParseBypassEntry(s string) (BypassEntry, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return BypassEntry{}, fmt.Errorf("bypass entry cannot be empty")
+	}
+	if b, ok := parseBypassHost(s); ok {
+		return b, nil
+	}
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return BypassEntry{}, fmt.Errorf("invalid bypass entry: %q", s)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return BypassEntry{}, fmt.Errorf("invalid port in bypass entry: %q", s)
+	}
+	b := BypassEntry{Port: port}
+	if host != "" {
+		var ok bool
+		if b, ok = parseBypassHost(host); !ok {
+			return BypassEntry{}, fmt.Errorf("invalid bypass entry: %q", s)
+		}
+		b.Port = port
+	}
+	return b, nil
+}
+
+// parseBypassHost 解析不带端口的条目
+func parseBypassHost(s string) (BypassEntry, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return BypassEntry{Prefix: p.Masked()}, true
+	}
+	if ip := hostport.ParseIP(s); ip != nil {
+		addr, _ := netip.AddrFromSlice(ip)
+		addr = addr.Unmap()
+		return BypassEntry{Prefix: netip.PrefixFrom(addr, addr.BitLen())}, true
+	}
+	if s == "*" {
+		return BypassEntry{Host: s}, true
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(s, "*"), ".")
+	if name == "" || strings.ContainsAny(name, "*:/[] ") {
+		return BypassEntry{}, false
+	}
+	if name != s {
+		name = "*." + name
+	}
+	return BypassEntry{Host: strings.ToLower(name)}, true
+}
+
+// ParseDSCP 解析 DSCP 名称(cs0-cs7、af11-af43、ef、le)或 0-63 的数值，不区分大小写
+func ParseDSCP(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case name == "ef":
+		return 46, nil
+	case name == "le":
+		return 1, nil
+	case len(name) == 3 && strings.HasPrefix(name, "cs") && name[2] >= '0' && name[2] <= '7':
+		return int(name[2]-'0') * 8, nil
+	case len(name) == 4 && strings.HasPrefix(name, "af") && name[2] >= '1' && name[2] <= '4' && name[3] >= '1' && name[3] <= '3':
+		// AFxy = 8x + 2y
+		return int(name[2]-'0')*8 + int(name[3]-'0')*2, nil
+	}
+	v, err := strconv.Atoi(name)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid dscp: %q", s)
+	}
+	return v, nil
+}
+
+// QuotaAction 超出配额时的处理方式
+type QuotaAction string
+
+const (
+	QuotaWarn     QuotaAction = "warn"     // 只通知，不限制
+	QuotaThrottle QuotaAction = "throttle" // 连接数超出时等待空位，流量超出时限速
+	QuotaBlock    QuotaAction = "block"    // 拒绝新连接，流量超出时中断读写
+)
+
+// Quota 按标签值计算的配额
+type Quota struct {
+	Label        string        `json:"label" yaml:"label"`                 // 标签名，如 tenant
+	Value        string        `json:"value" yaml:"value"`                 // 标签值，为空时对该标签的每个值分别计算
+	MaxConns     int           `json:"max_conns" yaml:"max_conns"`         // 并发连接数上限，0 表示不限制
+	MaxBytes     int64         `json:"max_bytes" yaml:"max_bytes"`         // 每个周期的收发字节上限，0 表示不限制
+	Period       time.Duration `json:"period" yaml:"period"`               // 流量配额的统计周期，0 表示不重置
+	Action       QuotaAction   `json:"action" yaml:"action"`               // 超出配额时的处理方式
+	ThrottleRate int64         `json:"throttle_rate" yaml:"throttle_rate"` // throttle 模式下超出流量配额后的速率(字节/秒)
+}
+
+// TLSRule 按目标主机匹配的 TLS 覆盖规则
+type TLSRule struct {
+	Pattern      string   `json:"pattern" yaml:"pattern"`             // 目标主机，支持 *.example.com 通配子域名
+	RootCAFile   string   `json:"root_ca_file" yaml:"root_ca_file"`   // 自定义根证书 PEM 文件，用于内部服务
+	PinnedSHA256 []string `json:"pinned_sha256" yaml:"pinned_sha256"` // 证书公钥 SHA256 指纹(base64)，任意一个匹配即通过
+	SkipVerify   bool     `json:"skip_verify" yaml:"skip_verify"`     // 跳过证书链验证，仍会检查指纹
 }
 
 type HTTPConfig struct {
-	Timeout       time.Duration
-	KeepAlive     time.Duration
-	User          string
-	Pass          string
-	TLSMinVersion uint16
-	SkipVerify    bool
-	CertFile      string
-	KeyFile       string
+	Timeout       time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive     time.Duration `json:"keep_alive" yaml:"keep_alive"`
+	User          string        `json:"user" yaml:"user"`
+	Pass          string        `json:"pass" yaml:"pass"`
+	TLSMinVersion uint16        `json:"tls_min_version" yaml:"tls_min_version"`
+	SkipVerify    bool          `json:"skip_verify" yaml:"skip_verify"`
+	CertFile      string        `json:"cert_file" yaml:"cert_file"`
+	KeyFile       string        `json:"key_file" yaml:"key_file"`
+
+	// 代理证书的验证选项，用于 HTTPS/HTTP2/HTTP3 代理本身的 TLS 连接
+	RootCAFile        string   `json:"root_ca_file" yaml:"root_ca_file"`               // 验证代理证书的根证书 PEM 文件，为空时使用系统根证书
+	PinnedSHA256      []string `json:"pinned_sha256" yaml:"pinned_sha256"`             // 代理证书公钥 SHA256 指纹(base64)，任意一个匹配即通过，SkipVerify 时仍会检查
+	RequireOCSPStaple bool     `json:"require_ocsp_staple" yaml:"require_ocsp_staple"` // 要求代理装订由签发者签名、状态为 good 且在有效期内的 OCSP 响应
+
+	// 与代理 TLS 握手时提供的 ALPN 协议，为空时按代理类型使用 DefaultNextProtos
+	NextProtos []string `json:"next_protos" yaml:"next_protos"`
+
+	// Negotiate 认证使用的代理服务主体名，为空时为 HTTP/<代理主机名>
+	NegotiateSPN string `json:"negotiate_spn" yaml:"negotiate_spn"`
+
+	// 以 absolute-form 请求(GET http://host/path)转发而不是 CONNECT 的目标端口，通常为 80
+	// 用于只允许 CONNECT 到 443 的代理，只适用于 HTTP 和 HTTPS 代理上的明文 HTTP 流量
+	ForwardPorts []int `json:"forward_ports" yaml:"forward_ports"`
+
+	// 根据代理 RTT 估计握手超时，上限为 Timeout
+	AdaptiveTimeout     bool          `json:"adaptive_timeout" yaml:"adaptive_timeout"`
+	MinHandshakeTimeout time.Duration `json:"min_handshake_timeout" yaml:"min_handshake_timeout"`
 
 	// HTTP2 特定配置
-	MaxConcurrentStreams uint32 // 最大并发流数
-	InitialWindowSize    uint32 // 初始窗口大小
-	MaxFrameSize         uint32 // 最大帧大小
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams" yaml:"max_concurrent_streams"` // 最大并发流数
+	InitialWindowSize    uint32 `json:"initial_window_size" yaml:"initial_window_size"`       // 初始窗口大小
+	MaxFrameSize         uint32 `json:"max_frame_size" yaml:"max_frame_size"`                 // 最大帧大小
+	AutoTuneWindow       bool   `json:"auto_tune_window" yaml:"auto_tune_window"`             // 根据观测到的 BDP 自动调整流窗口
+
+	// HTTP3 特定配置
+	Enable0RTT bool `json:"enable_0rtt" yaml:"enable_0rtt"` // QUIC 会话断开后用缓存的会话票据以 0-RTT 重连，CONNECT 随首包发出
 }
 
 // SOCKSConfig 统一的SOCKS配置结构
 type SOCKSConfig struct {
-	EnableUDP  bool
-	Timeout    time.Duration
-	KeepAlive  time.Duration
-	MaxRetries int
-	RetryDelay time.Duration
-	User       string // SOCKS5 专用
-	Pass       string // SOCKS5 专用
+	EnableUDP  bool          `json:"enable_udp" yaml:"enable_udp"` // 已弃用，使用 Config.HookUDP
+	Timeout    time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive  time.Duration `json:"keep_alive" yaml:"keep_alive"`
+	MaxRetries int           `json:"max_retries" yaml:"max_retries"`
+	RetryDelay time.Duration `json:"retry_delay" yaml:"retry_delay"`
+	User       string        `json:"user" yaml:"user"` // SOCKS5 专用
+	Pass       string        `json:"pass" yaml:"pass"` // SOCKS5 专用
+
+	// 根据代理 RTT 估计握手超时，上限为 Timeout
+	AdaptiveTimeout     bool          `json:"adaptive_timeout" yaml:"adaptive_timeout"`
+	MinHandshakeTimeout time.Duration `json:"min_handshake_timeout" yaml:"min_handshake_timeout"`
+
+	// UDP 关联空闲超过该间隔时向中继发送零长度数据报，0 表示不发送
+	UDPKeepAlive time.Duration `json:"udp_keep_alive" yaml:"udp_keep_alive"`
+	// UDP 关联收发的最大数据报负载(不含 SOCKS5 头)，超过的数据报被丢弃，0 表示默认值
+	MaxDatagramSize int `json:"max_datagram_size" yaml:"max_datagram_size"`
+
+	// 以 TLS 连接代理，socks5s 总是使用 TLS。TCP 连接的握手和数据都在 TLS 中，UDP 关联的数据报仍是明文
+	TLS           bool   `json:"tls" yaml:"tls"`
+	TLSMinVersion uint16 `json:"tls_min_version" yaml:"tls_min_version"`
+	SkipVerify    bool   `json:"skip_verify" yaml:"skip_verify"`
+	ServerName    string `json:"server_name" yaml:"server_name"` // SNI 和校验证书的主机名，为空时使用代理地址
+	CertFile      string `json:"cert_file" yaml:"cert_file"`     // 客户端证书
+	KeyFile       string `json:"key_file" yaml:"key_file"`
+}
+
+// VMessConfig VMess 代理配置
+type VMessConfig struct {
+	UUID      string        `json:"uuid" yaml:"uuid"`         // 用户 ID
+	Security  string        `json:"security" yaml:"security"` // 数据加密方式: auto、aes-128-gcm 或 none，auto 使用 aes-128-gcm
+	Timeout   time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive time.Duration `json:"keep_alive" yaml:"keep_alive"`
+}
+
+// DefaultVMessConfig 返回默认 VMess 配置，UUID 需要另外设置
+func DefaultVMessConfig() *VMessConfig {
+	return &VMessConfig{
+		Security:  DefaultVMessSecurity,
+		Timeout:   DefaultVMessTimeout,
+		KeepAlive: DefaultVMessKeepAlive,
+	}
+}
+
+// validate 验证 VMess 的用户 ID 和加密方式
+func (v *VMessConfig) validate() error {
+	if v == nil {
+		return fmt.Errorf("vmess config cannot be empty")
+	}
+	if _, err := vmess.ParseUUID(v.UUID); err != nil {
+		return fmt.Errorf("invalid vmess uuid: %q", v.UUID)
+	}
+	if _, err := vmess.ParseSecurity(v.Security); err != nil {
+		return fmt.Errorf("unsupported vmess security: %q", v.Security)
+	}
+	return nil
+}
+
+// SSHConfig SSH 跳板机配置，Password 和 KeyFile 至少设置一个
+// 主机密钥按 KnownHostsFile、HostKeySHA256 的顺序校验，都为空时需要显式设置 InsecureIgnoreHostKey
+type SSHConfig struct {
+	User          string `json:"user" yaml:"user"`
+	Password      string `json:"password" yaml:"password"`
+	KeyFile       string `json:"key_file" yaml:"key_file"`             // 私钥文件，PEM 或 OpenSSH 格式
+	KeyPassphrase string `json:"key_passphrase" yaml:"key_passphrase"` // 私钥的密码
+
+	KnownHostsFile        string `json:"known_hosts_file" yaml:"known_hosts_file"`                 // OpenSSH known_hosts 文件
+	HostKeySHA256         string `json:"host_key_sha256" yaml:"host_key_sha256"`                   // 主机密钥指纹，与 ssh-keygen -l 的输出相同，如 SHA256:...
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key" yaml:"insecure_ignore_host_key"` // 不校验主机密钥
+
+	Timeout   time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive time.Duration `json:"keep_alive" yaml:"keep_alive"` // 发送 keepalive@openssh.com 的间隔，0 表示不发送
+}
+
+// DefaultSSHConfig 返回默认 SSH 配置，用户和认证方式需要另外设置
+func DefaultSSHConfig() *SSHConfig {
+	return &SSHConfig{
+		Timeout:   DefaultSSHTimeout,
+		KeepAlive: DefaultSSHKeepAlive,
+	}
+}
+
+// validate 验证 SSH 的用户、认证方式和主机密钥校验方式
+func (s *SSHConfig) validate() error {
+	if s == nil {
+		return fmt.Errorf("ssh config cannot be empty")
+	}
+	if s.User == "" {
+		return fmt.Errorf("ssh user cannot be empty")
+	}
+	if s.Password == "" && s.KeyFile == "" {
+		return fmt.Errorf("ssh requires password or key_file")
+	}
+	if s.KnownHostsFile == "" && s.HostKeySHA256 == "" && !s.InsecureIgnoreHostKey {
+		return fmt.Errorf("ssh requires known_hosts_file, host_key_sha256 or insecure_ignore_host_key")
+	}
+	return nil
+}
+
+// TorConfig Tor 线路控制配置，SOCKS 超时和重试沿用 SOCKSConfig
+// ControlAddr 为空时不连接控制端口，RotateCircuit 只更换流隔离凭证
+type TorConfig struct {
+	ControlAddr     string `json:"control_addr" yaml:"control_addr"`         // 控制端口地址，如 127.0.0.1:9051 或 unix:/var/run/tor/control
+	ControlPassword string `json:"control_password" yaml:"control_password"` // HashedControlPassword 对应的明文密码
+	CookieFile      string `json:"cookie_file" yaml:"cookie_file"`           // CookieAuthentication 的 cookie 文件，设置密码时忽略
+
+	// 按目标主机隔离线路，不同目标的连接使用不同的 SOCKS 凭证，由 Tor 分配到不同线路
+	IsolateDestination bool `json:"isolate_destination" yaml:"isolate_destination"`
+
+	Timeout time.Duration `json:"timeout" yaml:"timeout"` // 控制端口请求的超时
+}
+
+// DefaultTorConfig 返回默认 Tor 配置，不连接控制端口
+func DefaultTorConfig() *TorConfig {
+	return &TorConfig{
+		Timeout: DefaultTorControlTimeout,
+	}
+}
+
+// validate 验证控制端口地址
+func (t *TorConfig) validate() error {
+	if t == nil || t.ControlAddr == "" {
+		return nil
+	}
+	if path, ok := UnixSocketPath(t.ControlAddr); ok {
+		if path == "" {
+			return fmt.Errorf("tor control socket path cannot be empty")
+		}
+		return nil
+	}
+	if _, _, err := hostport.Split(t.ControlAddr); err != nil {
+		return fmt.Errorf("invalid tor control address: %q", t.ControlAddr)
+	}
+	return nil
+}
+
+// WSConfig WebSocket 隧道配置，每个连接建立一个 WebSocket，对端为 ProxyIP:ProxyPort
+// Path 中的 {host}、{port} 和 {target} 替换为转义后的目标主机、端口和 host:port，不含占位符时由服务器决定目标
+type WSConfig struct {
+	Path    string            `json:"path" yaml:"path"`       // 握手请求的路径和查询参数，为空时为 /?target={target}
+	Host    string            `json:"host" yaml:"host"`       // Host 头和 TLS SNI，为空时使用代理地址，用于 CDN 或反向代理后的服务器
+	Headers map[string]string `json:"headers" yaml:"headers"` // 握手请求的附加头，如 Origin、Cookie
+	User    string            `json:"user" yaml:"user"`       // 设置时发送 Basic 认证的 Authorization 头
+	Pass    string            `json:"pass" yaml:"pass"`
+
+	SkipVerify bool          `json:"skip_verify" yaml:"skip_verify"` // wss 不校验服务器证书
+	Timeout    time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive  time.Duration `json:"keep_alive" yaml:"keep_alive"`
+}
+
+// DefaultWSConfig 返回默认 WebSocket 配置，目标地址写入 target 查询参数
+func DefaultWSConfig() *WSConfig {
+	return &WSConfig{
+		Path:      DefaultWSPath,
+		Timeout:   DefaultWSTimeout,
+		KeepAlive: DefaultWSKeepAlive,
+	}
+}
+
+// validate 验证请求路径和附加头
+func (w *WSConfig) validate() error {
+	if w == nil {
+		return fmt.Errorf("websocket config cannot be empty")
+	}
+	if w.Path != "" && !strings.HasPrefix(w.Path, "/") {
+		return fmt.Errorf("websocket path must start with /: %q", w.Path)
+	}
+	for name := range w.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("invalid websocket header: %q", name)
+		}
+	}
+	return nil
+}
+
+// GRPCConfig gRPC 隧道配置，每个连接是 /ServiceName/Tun 上的一个双向流，所有流共用一个 HTTP/2 连接
+// 目标地址通过 tunnel-target 元数据发送，主机名由服务器解析
+type GRPCConfig struct {
+	ServiceName string            `json:"service_name" yaml:"service_name"` // 服务名，为空时为 GunService
+	Authority   string            `json:"authority" yaml:"authority"`       // :authority 和 TLS SNI，为空时使用代理地址
+	Metadata    map[string]string `json:"metadata" yaml:"metadata"`         // 每个流附带的元数据，如 authorization
+	User        string            `json:"user" yaml:"user"`                 // 设置时发送 Basic 认证的 authorization 元数据
+	Pass        string            `json:"pass" yaml:"pass"`
+
+	Plaintext  bool          `json:"plaintext" yaml:"plaintext"`     // 不使用 TLS，以 h2c 连接
+	SkipVerify bool          `json:"skip_verify" yaml:"skip_verify"` // 不校验服务器证书
+	Timeout    time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive  time.Duration `json:"keep_alive" yaml:"keep_alive"` // 连接空闲超过该间隔时发送 HTTP/2 PING，0 表示不发送
+}
+
+// DefaultGRPCConfig 返回默认 gRPC 配置，使用 TLS 和 GunService 服务名
+func DefaultGRPCConfig() *GRPCConfig {
+	return &GRPCConfig{
+		Timeout:   DefaultGRPCTimeout,
+		KeepAlive: DefaultGRPCKeepAlive,
+	}
+}
+
+// validate 验证服务名和元数据
+func (g *GRPCConfig) validate() error {
+	if g == nil {
+		return fmt.Errorf("grpc config cannot be empty")
+	}
+	if strings.ContainsAny(g.ServiceName, "/ ") {
+		return fmt.Errorf("invalid grpc service name: %q", g.ServiceName)
+	}
+	for key := range g.Metadata {
+		if key == "" || key != strings.ToLower(key) || strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") {
+			return fmt.Errorf("invalid grpc metadata key: %q", key)
+		}
+	}
+	return nil
+}
+
+// Hysteria2Config Hysteria 2 配置，所有连接共用一个 QUIC 连接，TCP 连接是其上的流，UDP 数据报以 QUIC 数据报传输
+// quic-go 不支持替换拥塞控制，上行使用 quic-go 的默认算法；DownMbps 告知服务器下行带宽，由服务器的 Brutal 算法按该速率发送
+type Hysteria2Config struct {
+	Password   string `json:"password" yaml:"password"`       // 认证密码，userpass 认证时为 用户名:密码
+	SNI        string `json:"sni" yaml:"sni"`                 // TLS SNI，为空时使用代理地址
+	SkipVerify bool   `json:"skip_verify" yaml:"skip_verify"` // 不校验服务器证书
+	DownMbps   int    `json:"down_mbps" yaml:"down_mbps"`     // 下行带宽(Mbps)，0 表示由服务器探测
+
+	StreamReceiveWindow uint64 `json:"stream_receive_window" yaml:"stream_receive_window"` // 每个流的接收窗口(字节)
+	ConnReceiveWindow   uint64 `json:"conn_receive_window" yaml:"conn_receive_window"`     // 整个连接的接收窗口(字节)
+
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`           // QUIC 握手和认证超时
+	KeepAlive   time.Duration `json:"keep_alive" yaml:"keep_alive"`     // QUIC 保活间隔，0 表示不发送
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"` // 连接空闲超过该时间后断开
+}
+
+// DefaultHysteria2Config 返回默认 Hysteria2 配置，密码需要另外设置
+func DefaultHysteria2Config() *Hysteria2Config {
+	return &Hysteria2Config{
+		StreamReceiveWindow: DefaultHysteria2StreamReceiveWindow,
+		ConnReceiveWindow:   DefaultHysteria2ConnReceiveWindow,
+		Timeout:             DefaultHysteria2Timeout,
+		KeepAlive:           DefaultHysteria2KeepAlive,
+		IdleTimeout:         DefaultHysteria2IdleTimeout,
+	}
+}
+
+// validate 验证密码、带宽和接收窗口
+func (h *Hysteria2Config) validate() error {
+	if h == nil {
+		return fmt.Errorf("hysteria2 config cannot be empty")
+	}
+	if h.Password == "" {
+		return fmt.Errorf("hysteria2 password cannot be empty")
+	}
+	if h.DownMbps < 0 {
+		return fmt.Errorf("invalid hysteria2 down bandwidth: %d", h.DownMbps)
+	}
+	if h.StreamReceiveWindow > 0 && h.ConnReceiveWindow > 0 && h.StreamReceiveWindow > h.ConnReceiveWindow {
+		return fmt.Errorf("hysteria2 stream receive window cannot exceed connection receive window")
+	}
+	return nil
+}
+
+// WGConfig WireGuard 隧道配置，对端 endpoint 为 ProxyIP:ProxyPort
+// 密钥为 wg genkey/wg pubkey 输出的 base64 格式
+type WGConfig struct {
+	PrivateKey   string   `json:"private_key" yaml:"private_key"`     // 本端私钥
+	Address      []string `json:"address" yaml:"address"`             // 隧道接口地址，如 10.0.0.2/32，可以同时有 IPv4 和 IPv6
+	DNS          []string `json:"dns" yaml:"dns"`                     // 经隧道查询的 DNS 服务器，为空时在本地解析主机名
+	MTU          int      `json:"mtu" yaml:"mtu"`                     // 隧道接口 MTU，0 表示默认值
+	PublicKey    string   `json:"public_key" yaml:"public_key"`       // 对端公钥
+	PresharedKey string   `json:"preshared_key" yaml:"preshared_key"` // 可选的预共享密钥
+	AllowedIPs   []string `json:"allowed_ips" yaml:"allowed_ips"`     // 经隧道访问的网段，为空时为 0.0.0.0/0 和 ::/0
+
+	PersistentKeepalive time.Duration `json:"persistent_keepalive" yaml:"persistent_keepalive"` // 对端在 NAT 后时的保活间隔，0 表示不发送
+}
+
+// DefaultWGConfig 返回默认 WireGuard 配置，密钥和地址需要另外设置
+func DefaultWGConfig() *WGConfig {
+	return &WGConfig{
+		MTU: DefaultWireGuardMTU,
+	}
+}
+
+// validate 验证密钥、接口地址和网段
+func (w *WGConfig) validate() error {
+	if w == nil {
+		return fmt.Errorf("wireguard config cannot be empty")
+	}
+	if _, err := ParseWireGuardKey(w.PrivateKey); err != nil {
+		return fmt.Errorf("invalid wireguard private key: %w", err)
+	}
+	if _, err := ParseWireGuardKey(w.PublicKey); err != nil {
+		return fmt.Errorf("invalid wireguard public key: %w", err)
+	}
+	if w.PresharedKey != "" {
+		if _, err := ParseWireGuardKey(w.PresharedKey); err != nil {
+			return fmt.Errorf("invalid wireguard preshared key: %w", err)
+		}
+	}
+	if len(w.Address) == 0 {
+		return fmt.Errorf("wireguard address cannot be empty")
+	}
+	for _, a := range w.Address {
+		if _, err := ParseWireGuardAddr(a); err != nil {
+			return fmt.Errorf("invalid wireguard address: %q", a)
+		}
+	}
+	for _, d := range w.DNS {
+		if _, err := netip.ParseAddr(d); err != nil {
+			return fmt.Errorf("invalid wireguard dns server: %q", d)
+		}
+	}
+	for _, p := range w.AllowedIPs {
+		if _, err := netip.ParsePrefix(p); err != nil {
+			return fmt.Errorf("invalid wireguard allowed ip: %q", p)
+		}
+	}
+	if w.MTU != 0 && (w.MTU < 576 || w.MTU > 65535) {
+		return fmt.Errorf("invalid wireguard mtu: %d", w.MTU)
+	}
+	if w.PersistentKeepalive < 0 || w.PersistentKeepalive > 65535*time.Second {
+		return fmt.Errorf("invalid wireguard persistent keepalive: %v", w.PersistentKeepalive)
+	}
+	return nil
+}
+
+// ParseWireGuardKey 解析 base64 格式的 32 字节 WireGuard 密钥
+func ParseWireGuardKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// ParseWireGuardAddr 解析隧道接口地址，接受 10.0.0.2 或 10.0.0.2/32
+func ParseWireGuardAddr(s string) (netip.Addr, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Addr(), nil
+	}
+	return netip.ParseAddr(s)
 }
 
 // DefaultSOCKSConfig 返回默认SOCKS配置
@@ -103,7 +1391,49 @@ func DefaultSOCKSConfig() *SOCKSConfig {
 		Pass:       DefaultSOCKSPass,
 		MaxRetries: 3,
 		RetryDelay: time.Second * 5,
+
+		MinHandshakeTimeout: DefaultMinHandshakeTimeout,
+		UDPKeepAlive:        DefaultSOCKSUDPKeepAlive,
+		MaxDatagramSize:     DefaultSOCKSMaxDatagramSize,
+	}
+}
+
+// UsesTLS 判断 proxyType 的 SOCKS 代理是否以 TLS 连接
+func (s *SOCKSConfig) UsesTLS(proxyType ProxyType) bool {
+	return proxyType == SOCKS5S || (s != nil && s.TLS)
+}
+
+// validateSOCKS4 SOCKS4/4a 只有以 NUL 结尾的 USERID，没有密码认证
+func (s *SOCKSConfig) validateSOCKS4() error {
+	if s == nil {
+		return nil
+	}
+	if s.Pass != "" {
+		return fmt.Errorf("socks4 does not support password authentication, pass must be empty")
 	}
+	if strings.ContainsRune(s.User, 0) {
+		return fmt.Errorf("socks4 user id cannot contain NUL")
+	}
+	return nil
+}
+
+// validateTLS 验证 TLS 设置，Unix 域套接字没有可用作 SNI 的主机名，需要 ServerName 或 SkipVerify
+func (s *SOCKSConfig) validateTLS(proxyType ProxyType, unix bool) error {
+	if !s.UsesTLS(proxyType) {
+		return nil
+	}
+	var skipVerify bool
+	var serverName, certFile, keyFile string
+	if s != nil {
+		skipVerify, serverName, certFile, keyFile = s.SkipVerify, s.ServerName, s.CertFile, s.KeyFile
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("socks tls cert_file and key_file must be set together")
+	}
+	if unix && serverName == "" && !skipVerify {
+		return fmt.Errorf("socks tls over unix socket requires server_name or skip_verify")
+	}
+	return nil
 }
 
 func DefaultHTTPConfig() *HTTPConfig {
@@ -115,35 +1445,244 @@ func DefaultHTTPConfig() *HTTPConfig {
 		KeyFile:    DefaultHTTPKeyFile,
 		User:       DefaultHTTPUser,
 		Pass:       DefaultHTTPPass,
+
+		MinHandshakeTimeout: DefaultMinHandshakeTimeout,
 	}
 }
 
 func DefaultConfig() *Config {
 	return &Config{
+		Version:     CurrentVersion,
 		IdleTimeout: DefaultIdleTimeout,
 		KeepAlive:   DefaultKeepAlive,
+
+		DirectDialTimeout: DefaultDirectDialTimeout,
+
 		HTTPConfig:  DefaultHTTPConfig(),
 		SOCKSConfig: DefaultSOCKSConfig(), // 使用新的默认配置
+		VMessConfig: DefaultVMessConfig(),
+		SSHConfig:   DefaultSSHConfig(),
+		TorConfig:   DefaultTorConfig(),
+		WGConfig:    DefaultWGConfig(),
+		WSConfig:    DefaultWSConfig(),
+		GRPCConfig:  DefaultGRPCConfig(),
+
+		Hysteria2Config: DefaultHysteria2Config(),
 
 		HookUDP:       DefaultHookUDP,
 		ProxyType:     Direct,
 		ProxyIP:       "",
 		ProxyPort:     0,
 		Enable:        false,
+		ExcludeSelf:   DefaultExcludeSelf,
+		BypassPrivate: DefaultBypassPrivate,
+		SelfPipe:      DefaultSelfPipe,
 		DNSHook:       DefaultDNSHook,
 		TLSHook:       DefaultTLSHook,
+		SelfTest:      DefaultSelfTest,
 		MetricsEnable: DefaultMetricsEnable, // 默认关闭
+
+		MetricsMaxLabelSets: DefaultMetricsMaxLabelSets,
+		MetricsSampleRate:   DefaultMetricsSampleRate,
+		CapabilityTTL:       DefaultCapabilityTTL,
+		NegativeCacheTTL:    DefaultNegativeCacheTTL,
+
+		StartupPolicy:        DefaultStartupPolicy,
+		StartupProbeInterval: DefaultStartupProbeInterval,
+
+		DisableTimeout: DefaultDisableTimeout,
+	}
+}
+
+// UnixSocketPrefix ProxyIP 为 Unix 域套接字路径时使用的前缀，如 unix:/var/run/tor/socks
+const UnixSocketPrefix = "unix:"
+
+// UnixSocketPath 返回 unix: 前缀后的套接字路径
+func UnixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixSocketPrefix) {
+		return "", false
 	}
+	return strings.TrimPrefix(addr, UnixSocketPrefix), true
 }
 
-// GetProxyAddr 返回完整的代理地址
+// GetProxyAddr 返回完整的代理地址，Unix 域套接字时返回 unix:路径
 func (c *Config) GetProxyAddr() string {
-	return fmt.Sprintf("%s:%d", c.ProxyIP, c.ProxyPort)
+	if _, ok := UnixSocketPath(c.ProxyIP); ok {
+		return c.ProxyIP
+	}
+	return hostport.Join(c.ProxyIP, c.ProxyPort)
+}
+
+// RemoteDNS 目标主机名是否只能由代理解析
+func (c *Config) RemoteDNS() bool {
+	return c.Enable && (c.ProxyType == SOCKS4A || c.ProxyType == SOCKS5H || c.ProxyType == TOR)
 }
 
 // Validate 验证代理配置
 func (c *Config) Validate() error {
-	if !c.Enable {
+	// hook 的各部分相互独立，DNSHook 和 TLSHook 不需要启用代理；这里只拒绝不会生效的组合
+	if c.SelfTest && !c.Enable {
+		return fmt.Errorf("self_test requires enable: it verifies the dial hook, which is only installed when the proxy is enabled")
+	}
+	if len(c.TLSRules) > 0 && !c.TLSHook {
+		return fmt.Errorf("tls_rules require tls_hook")
+	}
+
+	for i, rule := range c.TLSRules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("tls rule %d: pattern cannot be empty", i)
+		}
+		for _, pin := range rule.PinnedSHA256 {
+			if sum, err := base64.StdEncoding.DecodeString(pin); err != nil || len(sum) != sha256.Size {
+				return fmt.Errorf("tls rule %d: invalid pin %q", i, pin)
+			}
+		}
+	}
+
+	for i, q := range c.Quotas {
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("quota %d: %w", i, err)
+		}
+	}
+
+	if err := c.validateFeatures(); err != nil {
+		return err
+	}
+
+	if s := c.AddrSelection; s != nil && (s.ExploreInterval < 0 || s.MaxAddrs < 0) {
+		return fmt.Errorf("addr selection: explore interval and max addrs cannot be negative")
+	}
+
+	if err := validateBypassList(c.BypassList); err != nil {
+		return err
+	}
+
+	for _, pkg := range c.BypassPackages {
+		if pkg == "" || strings.ContainsAny(pkg, " \t") || strings.HasSuffix(pkg, "/") {
+			return fmt.Errorf("invalid bypass package: %q", pkg)
+		}
+	}
+
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+
+	if c.PACURL != "" {
+		if c.PACFile != "" {
+			return fmt.Errorf("pac_url and pac_file cannot both be set")
+		}
+		if u, err := url.Parse(c.PACURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("pac_url: expected an http or https url, got %q", c.PACURL)
+		}
+	}
+
+	if c.NAT64 != nil && c.NAT64.Prefix != "" {
+		if _, err := ParseNAT64Prefix(c.NAT64.Prefix); err != nil {
+			return fmt.Errorf("nat64: %w", err)
+		}
+	}
+
+	if c.Scheduler != nil {
+		if err := c.Scheduler.validate(); err != nil {
+			return fmt.Errorf("scheduler: %w", err)
+		}
+	}
+
+	if c.Race != nil {
+		if err := c.Race.validate(c); err != nil {
+			return fmt.Errorf("race: %w", err)
+		}
+	}
+	if c.UDPProxy != nil {
+		if err := c.UDPProxy.validate(c); err != nil {
+			return fmt.Errorf("udp proxy: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.Proxies))
+	for i := range c.Proxies {
+		p := &c.Proxies[i]
+		if err := p.validate(c); err != nil {
+			return fmt.Errorf("proxy %d: %w", i, err)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("proxy %d: duplicate name %q", i, p.Name)
+		}
+		names[p.Name] = true
+	}
+	for i, r := range c.Rules {
+		if r.Proxy != "" && !names[r.Proxy] {
+			return fmt.Errorf("rule %d: unknown proxy %q", i, r.Proxy)
+		}
+	}
+	if c.Balance != nil {
+		if err := c.Balance.validate(c); err != nil {
+			return fmt.Errorf("balance: %w", err)
+		}
+	}
+	if c.HealthCheck != nil {
+		if err := c.HealthCheck.validate(); err != nil {
+			return fmt.Errorf("health_check: %w", err)
+		}
+	}
+
+	if c.SLO != nil {
+		if err := c.SLO.validate(); err != nil {
+			return fmt.Errorf("slo: %w", err)
+		}
+	}
+
+	if c.Transport != nil {
+		if err := c.Transport.validate(); err != nil {
+			return fmt.Errorf("transport: %w", err)
+		}
+	}
+
+	if c.OTLP != nil {
+		if err := c.OTLP.validate(); err != nil {
+			return fmt.Errorf("otlp: %w", err)
+		}
+	}
+
+	if c.DirectDialTimeout < 0 {
+		return fmt.Errorf("direct dial timeout cannot be negative: %v", c.DirectDialTimeout)
+	}
+	if c.CapabilityTTL < 0 {
+		return fmt.Errorf("capability ttl cannot be negative: %v", c.CapabilityTTL)
+	}
+	if c.NegativeCacheTTL < 0 {
+		return fmt.Errorf("negative cache ttl cannot be negative: %v", c.NegativeCacheTTL)
+	}
+
+	switch c.StartupPolicy {
+	case "", StartupLazy, StartupFailFast, StartupDirectUntilHealthy:
+	default:
+		return fmt.Errorf("unsupported startup policy: %q", c.StartupPolicy)
+	}
+	if c.FallbackDirect && c.ProxyType == TOR {
+		return fmt.Errorf("fallback direct cannot be used with %s, it would bypass tor", TOR)
+	}
+	if c.DisableTimeout < 0 {
+		return fmt.Errorf("invalid disable timeout: %v", c.DisableTimeout)
+	}
+	if c.StartupProbeInterval < 0 {
+		return fmt.Errorf("invalid startup probe interval: %v", c.StartupProbeInterval)
+	}
+
+	if c.SOCKSConfig != nil && (c.SOCKSConfig.MaxDatagramSize < 0 || c.SOCKSConfig.MaxDatagramSize > MaxDatagramSize) {
+		return fmt.Errorf("invalid socks max datagram size: %d", c.SOCKSConfig.MaxDatagramSize)
+	}
+
+	if c.MetricsSampleRate < 0 {
+		return fmt.Errorf("invalid metrics sample rate: %d", c.MetricsSampleRate)
+	}
+
+	if !c.Enable || c.ProxyType == Auto {
+		return nil
+	}
+
+	// 只有 PAC 脚本时启用 direct，默认直连，由脚本选择代理
+	if c.ProxyType == Direct && (c.PACURL != "" || c.PACFile != "") {
 		return nil
 	}
 
@@ -152,15 +1691,189 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("proxy IP cannot be empty")
 	}
 
+	// Unix 域套接字只支持 SOCKS 代理，不需要端口
+	if path, ok := UnixSocketPath(c.ProxyIP); ok {
+		if path == "" {
+			return fmt.Errorf("unix socket path cannot be empty")
+		}
+		switch c.ProxyType {
+		case SOCKS4, SOCKS4A:
+			if err := c.SOCKSConfig.validateSOCKS4(); err != nil {
+				return err
+			}
+			return c.SOCKSConfig.validateTLS(c.ProxyType, true)
+		case SOCKS5, SOCKS5H, SOCKS5S:
+			return c.SOCKSConfig.validateTLS(c.ProxyType, true)
+		case TOR:
+			return c.TorConfig.validate()
+		default:
+			return fmt.Errorf("unix socket is not supported for proxy type: %s", c.ProxyType)
+		}
+	}
+
 	// 验证端口
 	if c.ProxyPort <= 0 || c.ProxyPort > 65535 {
 		return fmt.Errorf("invalid proxy port: %d", c.ProxyPort)
 	}
 
 	switch c.ProxyType {
-	case HTTP, HTTPS, HTTP2, SOCKS4, SOCKS4A, SOCKS5:
-		return nil
+	case SOCKS4, SOCKS4A:
+		if err := c.SOCKSConfig.validateSOCKS4(); err != nil {
+			return err
+		}
+		return c.SOCKSConfig.validateTLS(c.ProxyType, false)
+	case SOCKS5, SOCKS5H, SOCKS5S:
+		return c.SOCKSConfig.validateTLS(c.ProxyType, false)
+	case HTTP:
+		return c.HTTPConfig.validateForwardPorts()
+	case HTTPS:
+		if err := c.HTTPConfig.validateForwardPorts(); err != nil {
+			return err
+		}
+		if err := c.HTTPConfig.validatePeer(); err != nil {
+			return err
+		}
+		return c.HTTPConfig.validateNextProtos(HTTPS)
+	case HTTP2:
+		if err := c.HTTPConfig.validateHTTP2(); err != nil {
+			return err
+		}
+		if err := c.HTTPConfig.validatePeer(); err != nil {
+			return err
+		}
+		return c.HTTPConfig.validateNextProtos(HTTP2)
+	case HTTP3:
+		if err := c.HTTPConfig.validatePeer(); err != nil {
+			return err
+		}
+		return c.HTTPConfig.validateNextProtos(HTTP3)
+	case VMESS:
+		return c.VMessConfig.validate()
+	case SSH:
+		return c.SSHConfig.validate()
+	case TOR:
+		return c.TorConfig.validate()
+	case WIREGUARD:
+		return c.WGConfig.validate()
+	case WS, WSS:
+		return c.WSConfig.validate()
+	case GRPC:
+		return c.GRPCConfig.validate()
+	case HYSTERIA2:
+		return c.Hysteria2Config.validate()
 	default:
 		return fmt.Errorf("unsupported proxy type: %s", c.ProxyType)
 	}
 }
+
+// validate 验证配额参数
+func (q Quota) validate() error {
+	if q.Label == "" {
+		return fmt.Errorf("label cannot be empty")
+	}
+	if q.MaxConns < 0 || q.MaxBytes < 0 || q.Period < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	switch q.Action {
+	case QuotaWarn, QuotaBlock:
+	case QuotaThrottle:
+		if q.MaxBytes > 0 && q.ThrottleRate <= 0 {
+			return fmt.Errorf("throttle rate must be positive")
+		}
+	default:
+		return fmt.Errorf("unsupported action: %q", q.Action)
+	}
+	return nil
+}
+
+// validateForwardPorts 验证按 absolute-form 转发的端口
+func (h *HTTPConfig) validateForwardPorts() error {
+	if h == nil {
+		return nil
+	}
+	for _, port := range h.ForwardPorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid forward port: %d", port)
+		}
+	}
+	return nil
+}
+
+// validatePeer 验证代理证书指纹，要求 OCSP 装订时必须验证证书链或固定指纹
+// 跳过验证且没有指纹时签发者取自代理自己发送的证书，OCSP 响应不能证明任何事
+func (h *HTTPConfig) validatePeer() error {
+	if h == nil {
+		return nil
+	}
+	for _, pin := range h.PinnedSHA256 {
+		if sum, err := base64.StdEncoding.DecodeString(pin); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("invalid pin %q", pin)
+		}
+	}
+	if h.RequireOCSPStaple && h.SkipVerify && len(h.PinnedSHA256) == 0 {
+		return fmt.Errorf("require_ocsp_staple needs skip_verify disabled or pinned_sha256")
+	}
+	return nil
+}
+
+// DefaultNextProtos 返回代理类型默认的 ALPN 协议
+// https 只提供 http/1.1，避免代理协商出 h2 后收到 HTTP/1.1 的 CONNECT；http2 同时提供 http/1.1，不支持 h2 的代理能完成握手后回退
+func DefaultNextProtos(proxyType ProxyType) []string {
+	switch proxyType {
+	case HTTPS:
+		return []string{"http/1.1"}
+	case HTTP2:
+		return []string{"h2", "http/1.1"}
+	case HTTP3:
+		return []string{"h3"}
+	default:
+		return nil
+	}
+}
+
+// validateNextProtos 验证 ALPN 协议列表与代理类型匹配
+func (h *HTTPConfig) validateNextProtos(proxyType ProxyType) error {
+	if h == nil || len(h.NextProtos) == 0 {
+		return nil
+	}
+	for _, proto := range h.NextProtos {
+		if proto == "" || len(proto) > 255 {
+			return fmt.Errorf("invalid alpn protocol: %q", proto)
+		}
+	}
+	switch proxyType {
+	case HTTPS:
+		// CONNECT 以 HTTP/1.1 发送，需要 h2 时使用 http2 代理类型
+		if slices.Contains(h.NextProtos, "h2") {
+			return fmt.Errorf("alpn protocol h2 requires proxy type %s", HTTP2)
+		}
+	case HTTP2:
+		if !slices.Contains(h.NextProtos, "h2") {
+			return fmt.Errorf("alpn protocols must include h2 for proxy type %s", HTTP2)
+		}
+	case HTTP3:
+		if !slices.Contains(h.NextProtos, "h3") {
+			return fmt.Errorf("alpn protocols must include h3 for proxy type %s", HTTP3)
+		}
+	}
+	return nil
+}
+
+// validateHTTP2 验证 HTTP2 流控参数
+func (h *HTTPConfig) validateHTTP2() error {
+	if h == nil {
+		return nil
+	}
+
+	// 帧大小必须在 16KB 到 16MB 之间 (RFC 7540 6.5.2)
+	if h.MaxFrameSize != 0 && (h.MaxFrameSize < 1<<14 || h.MaxFrameSize > 1<<24-1) {
+		return fmt.Errorf("invalid http2 max frame size: %d", h.MaxFrameSize)
+	}
+
+	// 窗口大小不能超过 2^31-1 (RFC 7540 6.9.1)
+	if h.InitialWindowSize > 1<<31-1 {
+		return fmt.Errorf("invalid http2 initial window size: %d", h.InitialWindowSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import "fmt"
+
+// Feature 可以单独关闭的功能，用于在生产环境中不重新编译就停用有风险的功能
+type Feature string
+
+const (
+	FeatureSNIRouting      Feature = "sni_routing"      // 按 TLS SNI 路由，对应 SNIRouting
+	FeatureFakeIP          Feature = "fake_ip"          // 假 IP 解析，对应 NewFakeIPResolver
+	FeatureRace            Feature = "race"             // 竞速拨号，对应 Race
+	FeatureNAT64           Feature = "nat64"            // NAT64 地址转换，对应 NAT64
+	FeatureAddrSelection   Feature = "addr_selection"   // 按历史拨号结果选择地址，对应 AddrSelection
+	FeaturePAC             Feature = "pac"              // 按 PAC 脚本路由，对应 PACURL 和 PACFile
+	FeatureScheduler       Feature = "scheduler"        // 按优先级调度，对应 Scheduler
+	FeatureNegativeCache   Feature = "negative_cache"   // 代理拒绝目标的负缓存，对应 NegativeCacheTTL
+	FeatureCapabilityCache Feature = "capability_cache" // 代理能力缓存，对应 CapabilityTTL
+	FeatureSelfPipe        Feature = "self_pipe"        // 本进程监听器的内存管道，对应 SelfPipe
+	FeatureResolvedHints   Feature = "resolved_hints"   // 把解析得到的 IP 还原为主机名，对应 ResolvedHints
+	FeatureBalance         Feature = "balance"          // 在多个代理之间负载均衡，对应 Balance
+	FeatureHealthCheck     Feature = "health_check"     // 定期探测代理的可用性，对应 HealthCheck
+)
+
+// FeatureInfo 功能开关的说明和默认值
+type FeatureInfo struct {
+	Name        Feature `json:"name"`
+	Description string  `json:"description"`
+	Default     bool    `json:"default"`
+}
+
+// featureRegistry 所有功能开关，默认都打开，功能是否生效仍取决于对应的配置
+var featureRegistry = []FeatureInfo{
+	{FeatureSNIRouting, "defer dials to IP destinations and route them by the TLS SNI", true},
+	{FeatureFakeIP, "hand out fake IPs and map them back to hostnames", true},
+	{FeatureRace, "race the proxy against a direct or alternate proxy dial", true},
+	{FeatureNAT64, "translate direct IPv4 destinations through the NAT64 prefix", true},
+	{FeatureAddrSelection, "prefer the resolved address with the best dial history", true},
+	{FeaturePAC, "route destinations by the PAC script's FindProxyForURL", true},
+	{FeatureScheduler, "queue proxy dials and share bandwidth by priority", true},
+	{FeatureNegativeCache, "cache destinations the proxy refused by policy", true},
+	{FeatureCapabilityCache, "cache capabilities learned in proxy handshakes", true},
+	{FeatureSelfPipe, "connect to wrapped in-process listeners through memory pipes", true},
+	{FeatureResolvedHints, "map resolved IPs back to their hostnames and pass the IP as a dial hint", true},
+	{FeatureBalance, "spread proxied dials across the main and named proxies", true},
+	{FeatureHealthCheck, "probe the main and named proxies and avoid the ones that are down", true},
+}
+
+// Features 返回所有功能开关及其默认值
+func Features() []FeatureInfo {
+	return append([]FeatureInfo(nil), featureRegistry...)
+}
+
+// lookupFeature 返回功能开关的注册信息
+func lookupFeature(f Feature) (FeatureInfo, bool) {
+	for _, info := range featureRegistry {
+		if info.Name == f {
+			return info, true
+		}
+	}
+	return FeatureInfo{}, false
+}
+
+// FeatureEnabled 判断功能开关是否打开，Features 中没有设置时使用默认值，未知的功能返回 false
+func (c *Config) FeatureEnabled(f Feature) bool {
+	info, ok := lookupFeature(f)
+	if !ok {
+		return false
+	}
+	if c == nil {
+		return info.Default
+	}
+	if enabled, ok := c.Features[f]; ok {
+		return enabled
+	}
+	return info.Default
+}
+
+// validateFeatures 检查 Features 中的功能名
+func (c *Config) validateFeatures() error {
+	for f := range c.Features {
+		if _, ok := lookupFeature(f); !ok {
+			return fmt.Errorf("features: unknown feature %q", f)
+		}
+	}
+	return nil
+}
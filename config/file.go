@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load 从 YAML/JSON 文件加载配置，未设置的字段使用默认值，没有 version 字段的旧配置按兼容规则加载
+func Load(path string) (*Config, error) {
+	cfg, _, err := LoadWithWarnings(path)
+	return cfg, err
+}
+
+// LoadWithWarnings 与 Load 相同，同时返回兼容和弃用警告
+func LoadWithWarnings(path string) (*Config, []Warning, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read config: %w", err)
+	}
+	return ParseWithWarnings(data)
+}
+
+// Parse 解析 YAML/JSON 配置内容，未设置的字段使用默认值，没有 version 字段的旧配置按兼容规则加载
+func Parse(data []byte) (*Config, error) {
+	cfg, _, err := ParseWithWarnings(data)
+	return cfg, err
+}
+
+// ParseWithWarnings 与 Parse 相同，同时返回兼容和弃用警告
+func ParseWithWarnings(data []byte) (*Config, []Warning, error) {
+	version, err := detectVersion(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := DefaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, nil, fmt.Errorf("parse config: %w", err)
+	}
+	cfg.Version = version
+
+	cfg, warnings, err := Migrate(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, warnings, err
+	}
+	return cfg, warnings, nil
+}
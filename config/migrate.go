@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion 当前配置格式版本
+// 规则、命名代理、负载均衡和 PAC 都是扁平配置上新增的可选字段，格式没有结构变化，仍为版本 1；
+// 没有 version 字段的文件(版本 0)按兼容规则加载，见 Migrate
+const CurrentVersion = 1
+
+// Warning 配置兼容或弃用警告
+type Warning struct {
+	Field   string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+// Migrate 检查配置中已弃用的字段，没有 version 字段的旧配置按兼容规则调整，
+// 返回标注为当前版本的新配置和警告，不修改传入的配置
+func Migrate(old *Config) (*Config, []Warning, error) {
+	if old == nil {
+		return nil, nil, fmt.Errorf("config is nil")
+	}
+	if old.Version > CurrentVersion || old.Version < 0 {
+		return nil, nil, fmt.Errorf("unsupported config version %d (current %d)", old.Version, CurrentVersion)
+	}
+
+	cfg := old.clone()
+	warnings := deprecations(cfg)
+	if cfg.Version == 0 {
+		warnings = append(warnings, migrateLegacy(cfg)...)
+	}
+	cfg.Version = CurrentVersion
+	return cfg, warnings, nil
+}
+
+// deprecations 报告仍然生效的弃用字段
+func deprecations(c *Config) []Warning {
+	var warnings []Warning
+	if c.SOCKSConfig != nil && c.SOCKSConfig.EnableUDP && !c.HookUDP {
+		warnings = append(warnings, Warning{
+			Field:   "socks.enable_udp",
+			Message: "deprecated, still allows UDP on explicit dials through the SOCKS proxy; hook_udp also intercepts UDP dials",
+		})
+	}
+	return warnings
+}
+
+// migrateLegacy 调整没有 version 字段的旧配置中已不再接受的写法
+func migrateLegacy(c *Config) []Warning {
+	var warnings []Warning
+
+	// 只有 PAC 脚本时 direct 是有效的启用方式，由脚本选择代理
+	if c.Enable && c.ProxyType == Direct && c.PACURL == "" && c.PACFile == "" {
+		c.Enable = false
+		warnings = append(warnings, Warning{
+			Field:   "proxy_type",
+			Message: "enable with proxy_type direct requires pac_url or pac_file; enable set to false",
+		})
+	}
+
+	return warnings
+}
+
+// clone 复制配置，子配置和切片不与原配置共享
+func (c *Config) clone() *Config {
+	cfg := *c
+	if c.HTTPConfig != nil {
+		http := *c.HTTPConfig
+		http.ForwardPorts = append([]int(nil), c.HTTPConfig.ForwardPorts...)
+		http.NextProtos = append([]string(nil), c.HTTPConfig.NextProtos...)
+		http.PinnedSHA256 = append([]string(nil), c.HTTPConfig.PinnedSHA256...)
+		cfg.HTTPConfig = &http
+	}
+	if c.SOCKSConfig != nil {
+		socks := *c.SOCKSConfig
+		cfg.SOCKSConfig = &socks
+	}
+	if c.VMessConfig != nil {
+		vmess := *c.VMessConfig
+		cfg.VMessConfig = &vmess
+	}
+	if c.SSHConfig != nil {
+		ssh := *c.SSHConfig
+		cfg.SSHConfig = &ssh
+	}
+	if c.TorConfig != nil {
+		tor := *c.TorConfig
+		cfg.TorConfig = &tor
+	}
+	if c.WGConfig != nil {
+		wg := *c.WGConfig
+		wg.Address = append([]string(nil), c.WGConfig.Address...)
+		wg.DNS = append([]string(nil), c.WGConfig.DNS...)
+		wg.AllowedIPs = append([]string(nil), c.WGConfig.AllowedIPs...)
+		cfg.WGConfig = &wg
+	}
+	if c.WSConfig != nil {
+		ws := *c.WSConfig
+		ws.Headers = maps.Clone(c.WSConfig.Headers)
+		cfg.WSConfig = &ws
+	}
+	if c.GRPCConfig != nil {
+		grpc := *c.GRPCConfig
+		grpc.Metadata = maps.Clone(c.GRPCConfig.Metadata)
+		cfg.GRPCConfig = &grpc
+	}
+	if c.Hysteria2Config != nil {
+		hysteria2 := *c.Hysteria2Config
+		cfg.Hysteria2Config = &hysteria2
+	}
+	if c.Transport != nil {
+		transport := *c.Transport
+		transport.Options = maps.Clone(c.Transport.Options)
+		cfg.Transport = &transport
+	}
+	cfg.TLSRules = append([]TLSRule(nil), c.TLSRules...)
+	cfg.Quotas = append([]Quota(nil), c.Quotas...)
+	cfg.Discovery = append([]string(nil), c.Discovery...)
+	cfg.BypassList = append([]string(nil), c.BypassList...)
+	cfg.BypassPackages = append([]string(nil), c.BypassPackages...)
+	cfg.Rules = append([]Rule(nil), c.Rules...)
+	cfg.Proxies = append([]NamedProxy(nil), c.Proxies...)
+	if c.SLO != nil {
+		slo := *c.SLO
+		slo.Windows = append([]time.Duration(nil), c.SLO.Windows...)
+		cfg.SLO = &slo
+	}
+	if c.OTLP != nil {
+		otlp := *c.OTLP
+		otlp.Headers = maps.Clone(c.OTLP.Headers)
+		otlp.Resource = maps.Clone(c.OTLP.Resource)
+		cfg.OTLP = &otlp
+	}
+	if c.NAT64 != nil {
+		nat64 := *c.NAT64
+		cfg.NAT64 = &nat64
+	}
+	if c.AddrSelection != nil {
+		selection := *c.AddrSelection
+		cfg.AddrSelection = &selection
+	}
+	if c.Scheduler != nil {
+		scheduler := *c.Scheduler
+		cfg.Scheduler = &scheduler
+	}
+	cfg.Features = maps.Clone(c.Features)
+	if c.Race != nil {
+		race := *c.Race
+		race.Patterns = append([]string(nil), c.Race.Patterns...)
+		cfg.Race = &race
+	}
+	if c.Balance != nil {
+		balance := *c.Balance
+		balance.Proxies = append([]string(nil), c.Balance.Proxies...)
+		cfg.Balance = &balance
+	}
+	if c.HealthCheck != nil {
+		health := *c.HealthCheck
+		cfg.HealthCheck = &health
+	}
+	if c.UDPProxy != nil {
+		udp := *c.UDPProxy
+		cfg.UDPProxy = &udp
+	}
+	return &cfg
+}
+
+// detectVersion 读取配置内容中的版本号，没有 version 字段时为 0
+func detectVersion(data []byte) (int, error) {
+	var v struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return 0, fmt.Errorf("parse config: %w", err)
+	}
+	return v.Version, nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/grpc"
+)
+
+// RedactedSecret 脱敏后敏感字段的取值
+const RedactedSecret = "******"
+
+// Redacted 返回去掉密码、私钥等敏感字段的副本，用于日志和问题报告
+// 已设置的敏感字段替换为 RedactedSecret，未设置的保持为空
+func (c *Config) Redacted() *Config {
+	cfg := c.clone()
+	if cfg.HTTPConfig != nil {
+		redact(&cfg.HTTPConfig.Pass)
+	}
+	if cfg.SOCKSConfig != nil {
+		redact(&cfg.SOCKSConfig.Pass)
+	}
+	if cfg.VMessConfig != nil {
+		redact(&cfg.VMessConfig.UUID)
+	}
+	if cfg.SSHConfig != nil {
+		redact(&cfg.SSHConfig.Password)
+		redact(&cfg.SSHConfig.KeyPassphrase)
+	}
+	if cfg.TorConfig != nil {
+		redact(&cfg.TorConfig.ControlPassword)
+	}
+	if cfg.WGConfig != nil {
+		redact(&cfg.WGConfig.PrivateKey)
+		redact(&cfg.WGConfig.PresharedKey)
+	}
+	if cfg.WSConfig != nil {
+		redact(&cfg.WSConfig.Pass)
+		for name, value := range cfg.WSConfig.Headers {
+			if sensitiveHeader(name) {
+				redact(&value)
+				cfg.WSConfig.Headers[name] = value
+			}
+		}
+	}
+	if cfg.GRPCConfig != nil {
+		redact(&cfg.GRPCConfig.Pass)
+		for key, value := range cfg.GRPCConfig.Metadata {
+			if sensitiveHeader(key) {
+				redact(&value)
+				cfg.GRPCConfig.Metadata[key] = value
+			}
+		}
+	}
+	if cfg.Hysteria2Config != nil {
+		redact(&cfg.Hysteria2Config.Password)
+	}
+	if cfg.Transport != nil {
+		// 插件选项的含义由插件定义，可能包含密钥，全部脱敏
+		for key, value := range cfg.Transport.Options {
+			redact(&value)
+			cfg.Transport.Options[key] = value
+		}
+	}
+	for i := range cfg.Rules {
+		redact(&cfg.Rules[i].Pass)
+	}
+	if cfg.OTLP != nil {
+		for name, value := range cfg.OTLP.Headers {
+			if sensitiveHeader(name) {
+				redact(&value)
+				cfg.OTLP.Headers[name] = value
+			}
+		}
+	}
+	return cfg
+}
+
+func redact(s *string) {
+	if *s != "" {
+		*s = RedactedSecret
+	}
+}
+
+// sensitiveHeader 判断请求头是否携带凭证
+func sensitiveHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
+	}
+	return false
+}
+
+// WithDefaults 返回补全默认值的副本，未设置的字段按运行时实际使用的值填写
+// 为 nil 的代理子配置使用默认配置，为空的启动策略、探测间隔、SLO 参数、OTLP 推送间隔和健康检查间隔使用默认值
+func (c *Config) WithDefaults() *Config {
+	cfg := c.clone()
+	if cfg.HTTPConfig == nil {
+		cfg.HTTPConfig = DefaultHTTPConfig()
+	}
+	if len(cfg.HTTPConfig.NextProtos) == 0 {
+		cfg.HTTPConfig.NextProtos = DefaultNextProtos(cfg.ProxyType)
+	}
+	if cfg.SOCKSConfig == nil {
+		cfg.SOCKSConfig = DefaultSOCKSConfig()
+	}
+	if cfg.VMessConfig == nil {
+		cfg.VMessConfig = DefaultVMessConfig()
+	}
+	if cfg.SSHConfig == nil {
+		cfg.SSHConfig = DefaultSSHConfig()
+	}
+	if cfg.TorConfig == nil {
+		cfg.TorConfig = DefaultTorConfig()
+	}
+	if cfg.WGConfig == nil {
+		cfg.WGConfig = DefaultWGConfig()
+	}
+	if cfg.WSConfig == nil {
+		cfg.WSConfig = DefaultWSConfig()
+	} else if cfg.WSConfig.Path == "" {
+		cfg.WSConfig.Path = DefaultWSPath
+	}
+	if cfg.GRPCConfig == nil {
+		cfg.GRPCConfig = DefaultGRPCConfig()
+	}
+	if cfg.GRPCConfig.ServiceName == "" {
+		cfg.GRPCConfig.ServiceName = grpc.DefaultServiceName
+	}
+	if cfg.Hysteria2Config == nil {
+		cfg.Hysteria2Config = DefaultHysteria2Config()
+	}
+	if cfg.StartupPolicy == "" {
+		cfg.StartupPolicy = DefaultStartupPolicy
+	}
+	if cfg.StartupProbeInterval == 0 {
+		cfg.StartupProbeInterval = DefaultStartupProbeInterval
+	}
+	if cfg.MetricsSampleRate == 0 {
+		cfg.MetricsSampleRate = DefaultMetricsSampleRate
+	}
+	if slo := cfg.SLO; slo != nil {
+		if len(slo.Windows) == 0 {
+			slo.Windows = append([]time.Duration(nil), DefaultSLOWindows...)
+		}
+		if slo.BurnRateThreshold == 0 {
+			slo.BurnRateThreshold = DefaultSLOBurnRateThreshold
+		}
+		if slo.MinSamples == 0 {
+			slo.MinSamples = DefaultSLOMinSamples
+		}
+		if slo.MaxDestinations == 0 {
+			slo.MaxDestinations = DefaultSLOMaxDestinations
+		}
+	}
+	if cfg.OTLP != nil && cfg.OTLP.Interval == 0 {
+		cfg.OTLP.Interval = DefaultOTLPInterval
+	}
+	if cfg.HealthCheck != nil && cfg.HealthCheck.Interval == 0 {
+		cfg.HealthCheck.Interval = DefaultHealthCheckInterval
+	}
+	return cfg
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleSet RulesFile 的内容，运维人员可以在进程运行时修改
+//
+//	bypass_list: ["*.corp.local", "10.0.0.0/8"]
+//	rules:
+//	  - {pattern: "*.vendor.com", action: direct}
+type RuleSet struct {
+	BypassList []string `json:"bypass_list" yaml:"bypass_list"`
+	Rules      []Rule   `json:"rules" yaml:"rules"`
+}
+
+// LoadRuleSet 读取并验证规则文件
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules file: %w", err)
+	}
+	s, err := ParseRuleSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseRuleSet 解析 YAML/JSON 格式的规则文件内容，未知字段和无效的条目返回错误，空内容得到空的规则集
+func ParseRuleSet(data []byte) (*RuleSet, error) {
+	s := &RuleSet{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parse rules file: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate 检查条目和规则，规则与 Config.Rules 的要求相同
+func (s *RuleSet) Validate() error {
+	if err := validateBypassList(s.BypassList); err != nil {
+		return err
+	}
+	return validateRules(s.Rules)
+}
+
+// Apply 返回加入了规则集的配置副本: BypassList 追加在配置的条目之后，Rules 放在配置的规则之前，先于它们匹配
+// s 为 nil 时返回 c
+func (s *RuleSet) Apply(c *Config) *Config {
+	if s == nil || c == nil {
+		return c
+	}
+	merged := *c
+	merged.BypassList = append(append([]string(nil), c.BypassList...), s.BypassList...)
+	merged.Rules = append(append([]Rule(nil), s.Rules...), c.Rules...)
+	return &merged
+}
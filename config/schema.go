@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema 返回完整配置的 JSON Schema
+func Schema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "GoHookProxy configuration"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// schemaFor 根据字段类型和 json 标签生成 Schema
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == durationType:
+		// YAML 中使用 "30s" 这样的字符串，JSON 中也可以使用纳秒整数
+		return map[string]interface{}{
+			"type":    []string{"string", "integer"},
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	case t == reflect.TypeOf(ProxyType("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []ProxyType{Direct, HTTP, HTTPS, HTTP2, HTTP3, SOCKS4, SOCKS4A, SOCKS5, SOCKS5H, SOCKS5S, VMESS, SSH, TOR, WIREGUARD, WS, WSS, GRPC, HYSTERIA2, Auto},
+		}
+	case t == reflect.TypeOf(QuotaAction("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []QuotaAction{QuotaWarn, QuotaThrottle, QuotaBlock},
+		}
+	case t == reflect.TypeOf(StartupPolicy("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []StartupPolicy{StartupLazy, StartupFailFast, StartupDirectUntilHealthy},
+		}
+	case t == reflect.TypeOf(Priority("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []Priority{PriorityInteractive, PriorityBulk},
+		}
+	case t == reflect.TypeOf(SocketTuning("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []SocketTuning{"", TuningLowLatency, TuningThroughput},
+		}
+	case t == reflect.TypeOf(RuleType("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []RuleType{"", RuleDomain, RuleDomainSuffix, RuleDomainKeyword, RuleDomainRegex, RuleIPCIDR, RuleDstPort, RuleFinal},
+		}
+	case t == reflect.TypeOf(BalanceStrategy("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []BalanceStrategy{"", BalanceRoundRobin, BalanceLeastConn, BalanceWeighted, BalanceLatency},
+		}
+	case t == reflect.TypeOf(RaceMode("")):
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []RaceMode{RaceDirect, RaceProxy},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			properties[name] = schemaFor(field.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaFor(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaFor(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/sysproxy"
+)
+
+// FromSystem 读取操作系统的代理设置(见 sysproxy.Read)并生成验证过的配置，没有设置代理时返回未启用代理的默认配置
+func FromSystem() (*Config, error) {
+	s, err := sysproxy.Read()
+	if err != nil {
+		return nil, err
+	}
+	return FromSettings(s)
+}
+
+// FromEnvironment 按 curl 和 pip 的约定从 http_proxy、https_proxy、all_proxy 和 no_proxy 生成验证过的配置(见 sysproxy.FromEnv)
+// 代理地址中的 user:pass 写入凭证；no_proxy 中的 example.com 和 .example.com 都匹配该域名及其子域名，* 表示所有目标都不经过代理
+func FromEnvironment() (*Config, error) {
+	s, err := sysproxy.FromEnv(nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromSettings(s, envBypass)
+}
+
+// FromSettings 把系统代理设置转换为配置
+// 只能使用一个代理，依次选择 HTTPS、HTTP 和 SOCKS 代理；自动配置脚本设置到 PACURL(file:// 地址设置到 PACFile)；
+// 绕过列表中 BypassList 能表示的条目被保留，如 10.* 转换为 10.0.0.0/8，<local> 等无法表示的条目被忽略
+func FromSettings(s *sysproxy.Settings) (*Config, error) {
+	return fromSettings(s, systemBypass)
+}
+
+// fromSettings 转换设置，bypass 把绕过列表转换为 BypassList 条目
+func fromSettings(s *sysproxy.Settings, bypass func([]string) []string) (*Config, error) {
+	cfg := DefaultConfig()
+	if s == nil {
+		return cfg, nil
+	}
+	if u := s.Proxy(); u != nil {
+		if err := cfg.SetProxyURL(u); err != nil {
+			return nil, err
+		}
+	}
+	if s.PACURL != "" {
+		u, err := url.Parse(s.PACURL)
+		if err != nil {
+			return nil, fmt.Errorf("pac url: %w", err)
+		}
+		if u.Scheme == "file" {
+			cfg.PACFile = u.Path
+		} else {
+			cfg.PACURL = s.PACURL
+		}
+		// 只有脚本时默认直连，由脚本选择代理
+		cfg.Enable = true
+	}
+	cfg.BypassList = bypass(s.Bypass)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetProxyURL 按代理地址设置 ProxyType、ProxyIP、ProxyPort 和凭证并启用代理
+// 支持 http、https、socks4、socks4a、socks5(socks 等价)和 socks5h，没有端口时使用协议的默认端口
+func (c *Config) SetProxyURL(u *url.URL) error {
+	var proxyType ProxyType
+	var defaultPort int
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		proxyType, defaultPort = HTTP, 80
+	case "https":
+		proxyType, defaultPort = HTTPS, 443
+	case "socks4":
+		proxyType, defaultPort = SOCKS4, 1080
+	case "socks4a":
+		proxyType, defaultPort = SOCKS4A, 1080
+	case "socks", "socks5":
+		proxyType, defaultPort = SOCKS5, 1080
+	case "socks5h":
+		proxyType, defaultPort = SOCKS5H, 1080
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+
+	port := defaultPort
+	if p := u.Port(); p != "" {
+		var err error
+		if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid proxy port: %q", p)
+		}
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("proxy url has no host: %q", u.Redacted())
+	}
+
+	c.Enable = true
+	c.ProxyType = proxyType
+	c.ProxyIP = u.Hostname()
+	c.ProxyPort = port
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		switch proxyType {
+		case HTTP, HTTPS:
+			if c.HTTPConfig == nil {
+				c.HTTPConfig = DefaultHTTPConfig()
+			}
+			c.HTTPConfig.User, c.HTTPConfig.Pass = u.User.Username(), pass
+		default:
+			if c.SOCKSConfig == nil {
+				c.SOCKSConfig = DefaultSOCKSConfig()
+			}
+			c.SOCKSConfig.User, c.SOCKSConfig.Pass = u.User.Username(), pass
+		}
+	}
+	return nil
+}
+
+// systemBypass 把系统绕过列表转换为 BypassList 条目，无法表示的条目被忽略
+func systemBypass(entries []string) []string {
+	var list []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		// <local> 表示不含点的主机名，BypassList 无法表示
+		if strings.ContainsAny(entry, "<>") {
+			continue
+		}
+		if p, ok := wildcardPrefix(entry); ok {
+			entry = p
+		}
+		if _, err := ParseBypassEntry(entry); err == nil {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// envBypass 把 no_proxy 转换为 BypassList 条目，域名同时匹配自身和子域名，无法表示的条目被忽略
+func envBypass(entries []string) []string {
+	var list []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" {
+			return []string{"*"}
+		}
+		host, port := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host, port = h, p
+		}
+		candidates := []string{host}
+		if _, err := netip.ParsePrefix(host); err != nil && hostport.ParseIP(host) == nil {
+			name := strings.TrimPrefix(strings.TrimPrefix(host, "*"), ".")
+			candidates = []string{name, "*." + name}
+		}
+		for _, c := range candidates {
+			if port != "" {
+				c = net.JoinHostPort(c, port)
+			}
+			if _, err := ParseBypassEntry(c); err == nil && !slices.Contains(list, c) {
+				list = append(list, c)
+			}
+		}
+	}
+	return list
+}
+
+// wildcardPrefix 把 Windows 的 10.* 和 macOS 的 169.254/16 这类简写转换为网段
+func wildcardPrefix(s string) (string, bool) {
+	addr, bits, hasBits := strings.Cut(s, "/")
+	wildcard := false
+	for strings.HasSuffix(addr, ".*") {
+		addr, wildcard = strings.TrimSuffix(addr, ".*"), true
+	}
+	octets := strings.Split(addr, ".")
+	if len(octets) > 4 || hasBits == wildcard {
+		return "", false
+	}
+	var ip [4]byte
+	for i, o := range octets {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 || n > 255 {
+			return "", false
+		}
+		ip[i] = byte(n)
+	}
+	prefix := netip.PrefixFrom(netip.AddrFrom4(ip), 8*len(octets))
+	if hasBits {
+		n, err := strconv.Atoi(bits)
+		if err != nil || n < 0 || n > 32 {
+			return "", false
+		}
+		prefix = netip.PrefixFrom(prefix.Addr(), n)
+	}
+	return prefix.Masked().String(), true
+}
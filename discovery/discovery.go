@@ -0,0 +1,219 @@
+// Package discovery 探测本地常见的 sidecar 代理并按偏好选择可用的一个
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+)
+
+// DefaultProbeTimeout 单个候选的默认探测超时
+const DefaultProbeTimeout = 500 * time.Millisecond
+
+// Candidate 候选的本地代理
+type Candidate struct {
+	Name      string
+	ProxyType C.ProxyType
+	ProxyIP   string // 可以是 unix:/path
+	ProxyPort int
+}
+
+func (c Candidate) String() string {
+	return fmt.Sprintf("%s (%s://%s)", c.Name, c.ProxyType, c.Addr())
+}
+
+// Addr 返回候选的地址，Unix 域套接字时为 unix:路径
+func (c Candidate) Addr() string {
+	if _, ok := C.UnixSocketPath(c.ProxyIP); ok {
+		return c.ProxyIP
+	}
+	return hostport.Join(c.ProxyIP, c.ProxyPort)
+}
+
+// Apply 将候选写入配置并启用代理
+func (c Candidate) Apply(cfg *C.Config) {
+	cfg.Enable = true
+	cfg.ProxyType = c.ProxyType
+	cfg.ProxyIP = c.ProxyIP
+	cfg.ProxyPort = c.ProxyPort
+}
+
+// DefaultCandidates 默认的候选列表，按偏好排序
+var DefaultCandidates = []Candidate{
+	{Name: "envoy", ProxyType: C.HTTP, ProxyIP: "127.0.0.1", ProxyPort: 15001},
+	{Name: "tor", ProxyType: C.SOCKS5, ProxyIP: "127.0.0.1", ProxyPort: 9050},
+	{Name: "tor-unix", ProxyType: C.SOCKS5, ProxyIP: "unix:/var/run/tor/socks"},
+	{Name: "clash", ProxyType: C.SOCKS5, ProxyIP: "127.0.0.1", ProxyPort: 7890},
+	{Name: "docker-host", ProxyType: C.SOCKS5, ProxyIP: "host.docker.internal", ProxyPort: 1080},
+}
+
+// ParseCandidate 解析候选，可以是 DefaultCandidates 中的名称，
+// 也可以是 socks5://127.0.0.1:1080、http://127.0.0.1:3128、socks5://unix:/path 这样的地址
+func ParseCandidate(s string) (Candidate, error) {
+	for _, c := range DefaultCandidates {
+		if c.Name == s {
+			return c, nil
+		}
+	}
+
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return Candidate{}, fmt.Errorf("unknown discovery candidate: %q", s)
+	}
+	c := Candidate{Name: s, ProxyType: C.ProxyType(scheme)}
+	switch c.ProxyType {
+	case C.HTTP, C.SOCKS4, C.SOCKS4A, C.SOCKS5, C.SOCKS5H:
+	default:
+		return Candidate{}, fmt.Errorf("unsupported discovery proxy type: %q", scheme)
+	}
+
+	if _, ok := C.UnixSocketPath(rest); ok {
+		c.ProxyIP = rest
+		return c, nil
+	}
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		return Candidate{}, fmt.Errorf("invalid discovery candidate %q: %w", s, err)
+	}
+	c.ProxyIP = host
+	if c.ProxyPort, err = strconv.Atoi(port); err != nil {
+		return Candidate{}, fmt.Errorf("invalid discovery candidate %q: %w", s, err)
+	}
+	return c, nil
+}
+
+// Discover 并发探测候选，返回按偏好顺序第一个可用的候选
+// 排在前面的候选都失败后立即返回，不等待后面仍在探测的候选
+func Discover(ctx context.Context, candidates []Candidate, timeout time.Duration) (Candidate, error) {
+	if len(candidates) == 0 {
+		candidates = DefaultCandidates
+	}
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 返回后中止其余探测
+
+	type result struct {
+		index int
+		err   error
+	}
+	done := make(chan result, len(candidates))
+	for i, c := range candidates {
+		go func() {
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			done <- result{i, Probe(probeCtx, c)}
+		}()
+	}
+
+	results := make([]error, len(candidates))
+	finished := make([]bool, len(candidates))
+	next := 0 // 偏好顺序中第一个尚未确定失败的候选
+	for next < len(candidates) {
+		select {
+		case r := <-done:
+			// 总体期限结束导致的失败不能说明候选不可用，不能让位于偏好靠后的候选；
+			// 探测连接的期限与 ctx 相同，可能先于 ctx 结束
+			if err := expired(ctx); r.err != nil && err != nil {
+				return Candidate{}, errors.WrapError(errors.ErrProxyNotFound, err.Error())
+			}
+			results[r.index], finished[r.index] = r.err, true
+		case <-ctx.Done():
+			return Candidate{}, errors.WrapError(errors.ErrProxyNotFound, ctx.Err().Error())
+		}
+		for next < len(candidates) && finished[next] {
+			if results[next] == nil {
+				return candidates[next], nil
+			}
+			next++
+		}
+	}
+	return Candidate{}, errors.ErrProxyNotFound
+}
+
+// expired 返回 ctx 结束的原因，已到达期限但 ctx 还没有结束时为 context.DeadlineExceeded
+func expired(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// Probe 检查候选是否在监听并且使用对应的代理协议
+func Probe(ctx context.Context, c Candidate) error {
+	var d net.Dialer
+	network, addr := "tcp", c.Addr()
+	if path, ok := C.UnixSocketPath(c.ProxyIP); ok {
+		network, addr = "unix", path
+	}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch c.ProxyType {
+	case C.SOCKS5, C.SOCKS5H, C.TOR:
+		return probeSOCKS5(conn)
+	case C.HTTP:
+		return probeHTTP(conn)
+	default:
+		// SOCKS4 没有不发起连接的握手，TLS 上的 SOCKS5 需要先完成 TLS 握手，只检查端口可连接
+		return nil
+	}
+}
+
+// probeSOCKS5 发送方法协商，检查响应版本号
+func probeSOCKS5(conn net.Conn) error {
+	if _, err := conn.Write([]byte{socks.Version5, 1, byte(socks.MethodNoAuth)}); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != socks.Version5 {
+		return errors.ErrSOCKSVersionNotSupported
+	}
+	return nil
+}
+
+// probeHTTP 发送指向无效地址的 CONNECT，任何 HTTP 响应都说明是 HTTP 代理
+func probeHTTP(conn net.Conn) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: "probe.invalid:443"},
+		Host:   "probe.invalid:443",
+		Header: make(http.Header),
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return errors.WrapError(errors.ErrProxyProtocol, err.Error())
+	}
+	resp.Body.Close()
+	return nil
+}
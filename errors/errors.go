@@ -7,10 +7,23 @@ import (
 
 var (
 	// 基础错误
-	ErrInvalidConfig    = errors.New("invalid proxy configuration")
-	ErrUnsupportedProxy = errors.New("unsupported proxy type")
-	ErrHookFailed       = errors.New("failed to hook network operations")
-	ErrProxyDialFailed  = errors.New("proxy dial failed")
+	ErrInvalidConfig       = errors.New("invalid proxy configuration")
+	ErrUnsupportedProxy    = errors.New("unsupported proxy type")
+	ErrHookFailed          = errors.New("failed to hook network operations")
+	ErrProxyDialFailed     = errors.New("proxy dial failed")
+	ErrProxyNotFound       = errors.New("no working proxy discovered")
+	ErrLocalDNSBlocked     = errors.New("local DNS resolution blocked, hostname is resolved by the proxy")
+	ErrFakeIPExhausted     = errors.New("fake ip pool exhausted")
+	ErrDoHQuery            = errors.New("dns over https query failed")
+	ErrHookDrainTimeout    = errors.New("timed out waiting for in-flight dials before disabling hook")
+	ErrRecentFailure       = errors.New("destination failed recently through this proxy, not retrying yet")
+	ErrNAT64PrefixNotFound = errors.New("nat64 prefix not found, ipv4only.arpa has no synthesized address")
+	ErrRuleRejected        = errors.New("connection rejected by routing rule")
+	ErrPACFetch            = errors.New("failed to fetch pac script")
+	ErrPACScript           = errors.New("pac script evaluation failed")
+	ErrManagerShutdown     = errors.New("proxy manager is shut down")
+	ErrRulesFile           = errors.New("invalid rules file")
+	ErrUnknownProxy        = errors.New("unknown named proxy")
 
 	// 代理特定错误
 	ErrHTTPProxyAuth    = errors.New("http proxy authentication failed")
@@ -18,6 +31,7 @@ var (
 	ErrSOCKS4AAuth      = errors.New("socks4a authentication failed")
 	ErrProxyProtocol    = errors.New("proxy protocol error")
 	ErrProxyNegotiation = errors.New("proxy negotiation failed")
+	ErrProxyForbidden   = errors.New("proxy refused the destination by policy")
 
 	// 连接错误
 	ErrConnectionTimeout = errors.New("connection timeout")
@@ -25,17 +39,23 @@ var (
 	ErrConnectionClosed  = errors.New("connection closed unexpectedly")
 
 	// TLS 错误
-	ErrTLSHandshake   = errors.New("TLS handshake failed")
-	ErrCertValidation = errors.New("certificate validation failed")
-	ErrTLSConfig      = errors.New("TLS configuration is missing")
+	ErrTLSHandshake      = errors.New("TLS handshake failed")
+	ErrCertValidation    = errors.New("certificate validation failed")
+	ErrTLSConfig         = errors.New("TLS configuration is missing")
+	ErrCertPinMismatch   = errors.New("certificate public key does not match any pin")
+	ErrOCSPStapleMissing = errors.New("no OCSP response stapled in the TLS handshake")
+	ErrOCSPStapleInvalid = errors.New("stapled OCSP response is invalid")
+	ErrCertRevoked       = errors.New("certificate has been revoked")
 
 	// Context 错误
 	ErrContextCanceled         = errors.New("operation canceled by context")
 	ErrContextDeadlineExceeded = errors.New("operation deadline exceeded")
 
 	// 资源错误
-	ErrPoolExhausted = errors.New("connection pool exhausted")
-	ErrResourceLimit = errors.New("resource limit exceeded")
+	ErrPoolExhausted   = errors.New("connection pool exhausted")
+	ErrResourceLimit   = errors.New("resource limit exceeded")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+	ErrByteCapExceeded = errors.New("byte cap exceeded")
 
 	// SOCKS 特定错误
 	ErrSOCKSVersionNotSupported     = errors.New("socks: unsupported protocol version")
@@ -53,12 +73,17 @@ var (
 	// SOCKS5 特定错误
 	ErrSOCKS5NoAcceptableMethods     = errors.New("socks5 no acceptable auth methods")
 	ErrSOCKS5GeneralFailure          = errors.New("socks5 general server failure")
+	ErrSOCKS5NotAllowed              = errors.New("socks5 connection not allowed by ruleset")
 	ErrSOCKS5NetworkUnreachable      = errors.New("socks5 network unreachable")
 	ErrSOCKS5HostUnreachable         = errors.New("socks5 host unreachable")
 	ErrSOCKS5ConnectionRefused       = errors.New("socks5 connection refused")
 	ErrSOCKS5TTLExpired              = errors.New("socks5 ttl expired")
 	ErrSOCKS5CommandNotSupported     = errors.New("socks5 command not supported")
 	ErrSOCKS5AddressTypeNotSupported = errors.New("socks5 address type not supported")
+	ErrSOCKS5DatagramTooLarge        = errors.New("socks5 udp datagram too large")
+	ErrSOCKS5HostnameTooLong         = errors.New("socks5 hostname too long")
+	ErrSOCKS5CredentialsTooLong      = errors.New("socks5 username or password longer than 255 bytes")
+	ErrSOCKS5NoTarget                = errors.New("socks5 udp association has no default target, use WriteTo")
 
 	// SOCKS特定错误
 	ErrSOCKSAuthMethodNotSupported = errors.New("socks: authentication method not supported")
@@ -70,6 +95,64 @@ var (
 	ErrSOCKSConnectFailed    = errors.New("socks: connect to target failed")
 	ErrSOCKSConnectTimeout   = errors.New("socks: connect timeout")
 	ErrSOCKSProxyUnreachable = errors.New("socks: proxy server unreachable")
+
+	// VMess 特定错误
+	ErrVMessInvalidUUID            = errors.New("vmess: invalid uuid")
+	ErrVMessSecurityNotSupported   = errors.New("vmess: unsupported security type")
+	ErrVMessNetworkNotSupported    = errors.New("vmess: unsupported network type")
+	ErrVMessAuthFailed             = errors.New("vmess: header authentication failed")
+	ErrVMessHostnameTooLong        = errors.New("vmess: hostname too long")
+	ErrVMessAddressTypeUnsupported = errors.New("vmess: unsupported address type")
+	ErrVMessPacketTooLarge         = errors.New("vmess: udp packet too large")
+	ErrVMessProxyUnreachable       = errors.New("vmess: proxy server unreachable")
+
+	// SSH 特定错误
+	ErrSSHNetworkNotSupported = errors.New("ssh: unsupported network type")
+	ErrSSHProxyUnreachable    = errors.New("ssh: server unreachable")
+	ErrSSHHandshakeFailed     = errors.New("ssh: handshake failed")
+	ErrSSHHostKeyMismatch     = errors.New("ssh: host key fingerprint mismatch")
+	ErrSSHConnectFailed       = errors.New("ssh: connect to target failed")
+
+	// Tor 特定错误
+	ErrTorNotConfigured = errors.New("tor: proxy type is not tor")
+	ErrTorControl       = errors.New("tor: control port request failed")
+
+	// WireGuard 特定错误
+	ErrWireGuardNotBuilt = errors.New("wireguard: support not built, rebuild with -tags wireguard")
+	ErrWireGuardSetup    = errors.New("wireguard: tunnel setup failed")
+	ErrWireGuardNoRoute  = errors.New("wireguard: target is outside allowed ips")
+
+	ErrWireGuardNetworkNotSupported = errors.New("wireguard: unsupported network type")
+
+	// WebSocket 特定错误
+	ErrWSNetworkNotSupported = errors.New("websocket: unsupported network type")
+	ErrWSProxyUnreachable    = errors.New("websocket: server unreachable")
+	ErrWSHandshakeFailed     = errors.New("websocket: handshake failed")
+
+	// gRPC 特定错误
+	ErrGRPCNetworkNotSupported = errors.New("grpc: unsupported network type")
+	ErrGRPCProxyUnreachable    = errors.New("grpc: server unreachable")
+	ErrGRPCStreamRejected      = errors.New("grpc: tunnel stream rejected")
+	ErrGRPCAuth                = errors.New("grpc: authentication failed")
+	ErrGRPCMessage             = errors.New("grpc: malformed message")
+
+	// Hysteria2 特定错误
+	ErrHysteria2ProxyUnreachable = errors.New("hysteria2: server unreachable")
+	ErrHysteria2Auth             = errors.New("hysteria2: authentication failed")
+	ErrHysteria2Rejected         = errors.New("hysteria2: connection rejected by server")
+	ErrHysteria2UDPDisabled      = errors.New("hysteria2: udp relay disabled by server")
+	ErrHysteria2Message          = errors.New("hysteria2: malformed message")
+	ErrHysteria2NoTarget         = errors.New("hysteria2: write without target, use WriteTo")
+
+	// CONNECT-UDP 特定错误
+	ErrConnectUDPUnsupported = errors.New("connect-udp: proxy does not support udp proxying")
+	ErrConnectUDPMessage     = errors.New("connect-udp: malformed datagram")
+	ErrConnectUDPNoTarget    = errors.New("connect-udp: write without target, use WriteTo")
+
+	// 传输插件错误
+	ErrTransportNotRegistered = errors.New("transport: plugin not registered")
+	ErrTransportUnsupported   = errors.New("transport: proxy type does not support transport plugins")
+	ErrTransportHandshake     = errors.New("transport: plugin handshake failed")
 )
 
 // WrapError 包装错误信息
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"github.com/ba0gu0/GoHookProxy/config"
 	"github.com/ba0gu0/GoHookProxy/hook"
 	"github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/report"
 )
 
 func main() {
@@ -50,18 +52,11 @@ func main() {
 	}
 	defer h.Disable()
 
-	// Start metrics reporting
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		for range ticker.C {
-			metrics := pm.GetMetrics()
-			log.Printf("Active connections: %d", metrics.ActiveConnections)
-			log.Printf("Total connections: %d", metrics.TotalConnections)
-			log.Printf("Failed connections: %d", metrics.FailedConnections)
-			log.Printf("Average duration: %v",
-				metrics.ConnectionDuration/time.Duration(metrics.TotalConnections))
-		}
-	}()
+	// 定期输出指标
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reporter := report.New(pm.GetMetrics, report.Logfmt, report.Stderr())
+	go reporter.Run(ctx)
 
 	// 运行测试
 	if err := runTests(); err != nil {
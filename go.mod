@@ -1,10 +1,28 @@
 module github.com/ba0gu0/GoHookProxy
 
-go 1.23.3
+go 1.24.0
+
+require github.com/agiledragon/gomonkey/v2 v2.12.0
+
+require (
+	github.com/quic-go/quic-go v0.59.1
+	golang.org/x/net v0.49.0
+	golang.zx2c4.com/wireguard v0.0.0-20250521234502-f333402bd9cb
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/agiledragon/gomonkey/v2 v2.12.0
-	golang.org/x/net v0.32.0
+	github.com/google/btree v1.1.2 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+	golang.org/x/time v0.7.0 // indirect
+	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
+	gvisor.dev/gvisor v0.0.0-20250503011706-39ed1f5ac29c // indirect
 )
 
-require golang.org/x/text v0.21.0 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/crypto v0.48.0
+	golang.org/x/sys v0.41.0
+)
@@ -0,0 +1,241 @@
+// Package gohookproxy GoHookProxy 的稳定入口
+// 只暴露接口和构造函数，proxy、hook、rules 和 metrics 包内部重构(如连接池接线、规则引擎)时，依赖本包的代码不需要修改；
+// 需要细粒度控制时仍可以直接使用这些包，但它们的 API 可能随版本变化
+package gohookproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/health"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// Config 代理配置，与配置文件的格式相同，见 config.Config
+type Config = config.Config
+
+// Metrics 指标快照，见 metrics.Metrics
+type Metrics = metrics.Metrics
+
+// Recorder 接收拨号、失败、字节数和活动连接数的指标接口，见 metrics.Recorder
+type Recorder = metrics.Recorder
+
+// Status Enable 替换了哪些标准库函数，见 hook.Status
+type Status = hook.Status
+
+// HealthStatus 一个代理的健康状态，见 health.Status
+type HealthStatus = health.Status
+
+// DefaultConfig 返回未启用代理的默认配置
+func DefaultConfig() *Config {
+	return config.DefaultConfig()
+}
+
+// LoadConfig 从 YAML/JSON 文件加载配置，未设置的字段使用默认值，旧版本配置会自动迁移
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// Dialer 按配置拨号，直连或经过代理
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// MetricsSource 提供指标快照，指标未启用时返回空的快照
+type MetricsSource interface {
+	GetMetrics() *Metrics
+}
+
+// Action 路由动作
+type Action string
+
+const (
+	ActionProxy  Action = "proxy"  // 通过代理连接
+	ActionDirect Action = "direct" // 直接连接
+	ActionReject Action = "reject" // 拒绝连接
+)
+
+// Rule 路由规则
+type Rule interface {
+	// Match 判断规则是否匹配目标地址 host:port
+	Match(addr string) bool
+	// Action 命中时的路由动作
+	Action() Action
+	// String 规则的描述，与决策原因中的写法相同
+	String() string
+}
+
+// Decision 路由决策及其原因
+type Decision struct {
+	Action Action
+	Reason string
+	Rule   Rule // 命中的规则，未命中规则时为 nil
+}
+
+func (d Decision) String() string {
+	return string(d.Action) + " (" + d.Reason + ")"
+}
+
+// Manager 代理管理器，拨号按路由规则直连或经过代理，Enable 后进程内的标准库拨号也经过它
+type Manager interface {
+	Dialer
+	MetricsSource
+
+	// ListenPacket 返回按路由规则收发 UDP 的连接
+	ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+	// Explain 返回连接的路由决策及原因，不拨号
+	Explain(network, addr string) Decision
+	// UpdateConfig 在运行时替换配置，配置无效时保持原配置并返回错误，cfg 为 nil 时使用默认配置
+	UpdateConfig(cfg *Config) error
+	// Transport 返回按路由规则拨号的 http.Transport，不需要 Enable
+	Transport() *http.Transport
+	// SetRecorder 把拨号指标交给 r 而不是内置的收集器，nil 时恢复内置的收集器
+	SetRecorder(r Recorder)
+	// ReloadRules 立即重新加载配置的 RulesFile，内容无效时继续使用原来的规则并返回错误
+	ReloadRules() error
+	// Use 选择走代理的连接默认使用的 Config.Proxies 中的代理，name 为空时恢复为主代理
+	Use(name string) error
+	// Health 返回 Config.HealthCheck 探测的主代理(名称为空)和命名代理的状态，未配置时返回 nil
+	Health() []HealthStatus
+
+	// Enable 替换标准库的拨号函数，进程内的连接都经过管理器；nohook 构建下只准备 DialContext 和 Transport
+	Enable() error
+	// Disable 还原标准库函数，等待正在进行的拨号结束
+	Disable() error
+	// Status 返回 Enable 替换了哪些函数: 拨号只在 Config.Enable 时替换，DNSHook 和 TLSHook 与它无关
+	Status() Status
+	// Shutdown 还原标准库函数并关闭管理器: 做最后一次指标导出、关闭到代理的会话，之后的拨号返回错误
+	// 返回所有未能正常停止的部分的错误
+	Shutdown(ctx context.Context) error
+}
+
+// New 根据配置创建管理器，cfg 为 nil 时使用默认配置
+func New(cfg *Config) (Manager, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	pm, err := proxy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &manager{pm: pm, hook: hook.New(pm)}, nil
+}
+
+// NewDialer 根据配置创建拨号器，不修改标准库，适合只在自己的客户端中使用代理
+func NewDialer(cfg *Config) (Dialer, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	pm, err := proxy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+// ParseRule 解析 Clash 风格的规则行，如 DOMAIN-SUFFIX,corp.local,DIRECT，写法见 config.ParseRule
+func ParseRule(line string) (Rule, error) {
+	r, err := config.ParseRule(line)
+	if err != nil {
+		return nil, err
+	}
+	engine := rules.FromConfig(&config.Config{Rules: []config.Rule{r}})
+	return rule{engine.Rules[0]}, nil
+}
+
+// manager 用 proxy.ProxyManager 和 hook.Hook 实现 Manager
+type manager struct {
+	pm   *proxy.ProxyManager
+	hook *hook.Hook
+}
+
+func (m *manager) Dial(network, addr string) (net.Conn, error) {
+	return m.hook.DialContext(context.Background(), network, addr)
+}
+
+func (m *manager) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return m.hook.DialContext(ctx, network, addr)
+}
+
+func (m *manager) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	return m.pm.ListenPacket(ctx, network)
+}
+
+func (m *manager) GetMetrics() *Metrics {
+	return m.pm.GetMetrics()
+}
+
+func (m *manager) Explain(network, addr string) Decision {
+	d := m.pm.Explain(network, addr)
+	decision := Decision{Action: Action(d.Action), Reason: d.Reason}
+	if d.Rule != nil {
+		decision.Rule = rule{*d.Rule}
+	}
+	return decision
+}
+
+func (m *manager) UpdateConfig(cfg *Config) error {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	return m.pm.UpdateConfig(cfg)
+}
+
+func (m *manager) Transport() *http.Transport {
+	return m.hook.Transport()
+}
+
+func (m *manager) Enable() error {
+	return m.hook.Enable()
+}
+
+func (m *manager) SetRecorder(r Recorder) {
+	m.pm.SetRecorder(r)
+}
+
+func (m *manager) ReloadRules() error {
+	return m.pm.ReloadRules()
+}
+
+func (m *manager) Disable() error {
+	return m.hook.Disable()
+}
+
+func (m *manager) Use(name string) error {
+	return m.pm.Use(name)
+}
+
+func (m *manager) Health() []HealthStatus {
+	return m.pm.Health()
+}
+
+func (m *manager) Status() Status {
+	return m.hook.Status()
+}
+
+func (m *manager) Shutdown(ctx context.Context) error {
+	return m.hook.Shutdown(ctx)
+}
+
+// rule 用 rules.Rule 实现 Rule
+type rule struct {
+	r rules.Rule
+}
+
+func (r rule) Match(addr string) bool {
+	return r.r.MatchAddr(addr)
+}
+
+func (r rule) Action() Action {
+	return Action(r.r.Action)
+}
+
+func (r rule) String() string {
+	return r.r.String()
+}
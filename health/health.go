@@ -0,0 +1,251 @@
+// Package health 定期探测上游代理，按连续失败和成功的次数把代理标记为不可用或可用
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+// State 代理的健康状态
+type State int32
+
+const (
+	Unknown State = iota // 还没有探测结果，按可用处理
+	Up                   // 可用
+	Down                 // 连续 Fall 次探测失败
+)
+
+func (s State) String() string {
+	switch s {
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText 以 String 的形式编码，用于 JSON 输出
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Probe 探测一次代理，返回 nil 表示代理可用
+type Probe func(ctx context.Context) error
+
+// Target 探测的目标
+type Target struct {
+	Name  string // Proxies 中的名称，主代理为空
+	Probe Probe
+}
+
+// Status 一个代理的健康状态
+type Status struct {
+	Name      string        `json:"name"`
+	State     State         `json:"state"`
+	Since     time.Time     `json:"since"`           // 进入当前状态的时间，Unknown 时为零值
+	LastCheck time.Time     `json:"last_check"`      // 最近一次探测结束的时间
+	Latency   time.Duration `json:"latency"`         // 最近一次成功探测的耗时
+	Error     string        `json:"error,omitempty"` // 最近一次探测的错误，成功时为空
+	Failures  int           `json:"failures"`        // 连续失败的次数
+	Successes int           `json:"successes"`       // 连续成功的次数
+}
+
+// Checker 按 HealthCheckConfig 定期探测所有目标，所有方法对 nil Checker 都可以调用
+type Checker struct {
+	config   C.HealthCheckConfig
+	targets  []Target
+	clock    clock.Clock
+	onChange func(Status)
+
+	mu     sync.Mutex
+	status map[string]*Status
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewChecker 创建探测 targets 的 Checker，onChange 在状态变化时于探测的 goroutine 中调用，可以为 nil
+// 调用 Start 后开始探测
+func NewChecker(config *C.HealthCheckConfig, targets []Target, clk clock.Clock, onChange func(Status)) *Checker {
+	c := &Checker{
+		config:   *config,
+		targets:  targets,
+		clock:    clock.OrReal(clk),
+		onChange: onChange,
+		status:   make(map[string]*Status, len(targets)),
+	}
+	for _, t := range targets {
+		c.status[t.Name] = &Status{Name: t.Name}
+	}
+	return c
+}
+
+// Start 在后台立即探测一次，之后按 Interval 探测，直到 Stop
+func (c *Checker) Start() {
+	if c == nil || c.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.run(ctx)
+}
+
+// Stop 停止探测并等待正在进行的探测结束，可以重复调用
+func (c *Checker) Stop() {
+	if c == nil || c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// run 探测的循环
+func (c *Checker) run(ctx context.Context) {
+	defer close(c.done)
+	interval := c.config.Interval
+	if interval <= 0 {
+		interval = C.DefaultHealthCheckInterval
+	}
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.CheckNow(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.CheckNow(ctx)
+		}
+	}
+}
+
+// CheckNow 并发探测所有目标一次并等待结果，ctx 结束时中止的探测不计入状态
+func (c *Checker) CheckNow(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, t := range c.targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			c.check(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// check 探测一个目标并更新它的状态
+func (c *Checker) check(ctx context.Context, t Target) {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	start := c.clock.Now()
+	err := t.Probe(ctx)
+	if err != nil && ctx.Err() == context.Canceled {
+		return
+	}
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	s := c.status[t.Name]
+	prev := s.State
+	s.LastCheck = now
+	if err != nil {
+		s.Error = err.Error()
+		s.Failures++
+		s.Successes = 0
+		if s.State != Down && s.Failures >= atLeastOne(c.config.Fall) {
+			s.State, s.Since = Down, now
+		}
+	} else {
+		s.Error = ""
+		s.Latency = now.Sub(start)
+		s.Successes++
+		s.Failures = 0
+		// 还没有探测结果的代理第一次成功即为可用
+		if s.State == Unknown || s.State == Down && s.Successes >= atLeastOne(c.config.Rise) {
+			s.State, s.Since = Up, now
+		}
+	}
+	changed, status := s.State != prev, *s
+	c.mu.Unlock()
+
+	if changed && c.onChange != nil {
+		c.onChange(status)
+	}
+}
+
+// atLeastOne 把未设置的次数按 1 处理
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Up 判断代理是否可用，不可用只表示连续 Fall 次探测失败；没有探测结果和不在探测目标中的代理返回 true
+func (c *Checker) Up(name string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.status[name]
+	return !ok || s.State != Down
+}
+
+// Status 返回所有目标的状态，顺序与创建时的 targets 相同
+func (c *Checker) Status() []Status {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := make([]Status, len(c.targets))
+	for i, t := range c.targets {
+		status[i] = *c.status[t.Name]
+	}
+	return status
+}
+
+// URLProbe 通过 dial 建立的连接请求 url，返回 2xx 或 3xx 时成功，不跟随重定向
+func URLProbe(dial func(ctx context.Context, network, addr string) (net.Conn, error), url string) Probe {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("health check url: unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
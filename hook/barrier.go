@@ -0,0 +1,88 @@
+package hook
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// dialBarrier 协调 Disable 与正在进行的拦截拨号
+// Disable 期间新的拨号直连，Disable 等待已开始的拨号结束后才还原补丁
+type dialBarrier struct {
+	mu       sync.Mutex
+	inflight int
+	draining bool
+	idle     chan struct{} // inflight 归零时关闭
+}
+
+// enter 登记一次拦截拨号，Disable 进行中时返回 false，调用方应直连
+func (b *dialBarrier) enter() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.draining {
+		return false
+	}
+	b.inflight++
+	return true
+}
+
+// leave 结束 enter 登记的拨号
+func (b *dialBarrier) leave() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inflight--
+	if b.inflight == 0 && b.idle != nil {
+		close(b.idle)
+		b.idle = nil
+	}
+}
+
+// drain 拒绝新的登记并等待已登记的拨号结束，超过 timeout 返回 ErrHookDrainTimeout
+// timeout 为 0 时不等待
+func (b *dialBarrier) drain(clk clock.Clock, timeout time.Duration) error {
+	b.mu.Lock()
+	b.draining = true
+	if b.inflight == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.idle == nil {
+		b.idle = make(chan struct{})
+	}
+	idle, n := b.idle, b.inflight
+	b.mu.Unlock()
+
+	if timeout <= 0 {
+		return fmt.Errorf("%w: %d dials in flight", E.ErrHookDrainTimeout, n)
+	}
+	timer := clk.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return nil
+	case <-timer.C():
+		return fmt.Errorf("%w: %d dials still in flight after %v", E.ErrHookDrainTimeout, b.inFlight(), timeout)
+	}
+}
+
+// reopen 结束 drain，之后的拨号重新按规则路由
+func (b *dialBarrier) reopen() {
+	b.mu.Lock()
+	b.draining = false
+	b.mu.Unlock()
+}
+
+// inFlight 返回正在进行的拦截拨号数
+func (b *dialBarrier) inFlight() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inflight
+}
+
+// InFlight 返回正在进行的拦截拨号数
+func (h *Hook) InFlight() int {
+	return h.barrier.inFlight()
+}
@@ -0,0 +1,44 @@
+package hook
+
+import (
+	"runtime"
+	"strings"
+)
+
+// maxCallerDepth 检查调用栈的最大深度
+const maxCallerDepth = 64
+
+// callerBypassed 判断拨号所在 goroutine 的调用栈中是否有 BypassPackages 中的包，只在 DialContext 中调用
+func (h *Hook) callerBypassed() bool {
+	pkgs := h.proxyManager.Config.BypassPackages
+	if len(pkgs) == 0 {
+		return false
+	}
+	var pcs [maxCallerDepth]uintptr
+	// 跳过 runtime.Callers、callerBypassed 和 DialContext
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if pkg := funcPackage(frame.Function); pkg != "" {
+			for _, p := range pkgs {
+				if pkg == p || strings.HasPrefix(pkg, p+"/") {
+					return true
+				}
+			}
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// funcPackage 返回完整函数名中的包导入路径，如 github.com/a/b.(*T).M 返回 github.com/a/b
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
@@ -0,0 +1,84 @@
+package hook
+
+import (
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PlatformCapabilities 当前平台和构建支持的功能，嵌入的应用可以据此调整配置，而不是在运行时才失败
+type PlatformCapabilities struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Patching 能否在运行时替换标准库函数，nohook 构建或 gomonkey 不支持的平台为 false，此时需要显式使用 h.DialContext/h.Transport
+	Patching bool `json:"patching"`
+	// PatchingNote Patching 为 false 的原因，或可能让补丁不生效的构建设置
+	PatchingNote string `json:"patching_note,omitempty"`
+
+	TCPFastOpen   bool `json:"tcp_fast_open"`  // 内核允许客户端使用 TCP Fast Open
+	SOMark        bool `json:"so_mark"`        // 当前进程可以设置 SO_MARK(仅 Linux，需要 CAP_NET_ADMIN 或 CAP_NET_RAW)
+	Splice        bool `json:"splice"`         // TCP 连接之间转发数据时由内核零拷贝(Linux splice)
+	EBPF          bool `json:"ebpf"`           // 当前进程可以创建 eBPF 对象(仅 Linux，需要 CAP_BPF 或 root)
+	DSCP          bool `json:"dscp"`           // 路由规则的 DSCP 能设置到到代理的连接上
+	SelfDetection bool `json:"self_detection"` // ExcludeSelf 能识别本进程的监听端口
+}
+
+// patchPlatforms gomonkey 支持的系统和架构
+var patchPlatforms = struct{ os, arch []string }{
+	os:   []string{"linux", "darwin", "windows"},
+	arch: []string{"386", "amd64", "arm64", "loong64"},
+}
+
+var capabilities = sync.OnceValue(probeCapabilities)
+
+// Capabilities 返回当前平台和构建支持的功能，探测结果在第一次调用后缓存
+func Capabilities() PlatformCapabilities {
+	return capabilities()
+}
+
+func probeCapabilities() PlatformCapabilities {
+	c := PlatformCapabilities{
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Splice:        runtime.GOOS == "linux",
+		SelfDetection: runtime.GOOS == "linux",
+	}
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+		c.DSCP = true
+	}
+
+	switch {
+	case !Patched:
+		c.PatchingNote = "built with the nohook tag"
+	case !slices.Contains(patchPlatforms.os, runtime.GOOS) || !slices.Contains(patchPlatforms.arch, runtime.GOARCH):
+		c.PatchingNote = "runtime patching is not supported on " + runtime.GOOS + "/" + runtime.GOARCH
+	default:
+		c.Patching = true
+		if !inliningDisabled() {
+			c.PatchingNote = "inlined callers may bypass the patches, build with -gcflags=all=-l"
+		}
+	}
+
+	c.TCPFastOpen = probeTCPFastOpen()
+	c.SOMark = probeSOMark()
+	c.EBPF = probeEBPF()
+	return c
+}
+
+// inliningDisabled 根据构建信息判断是否以 -gcflags=all=-l 构建，没有构建信息时返回 false
+func inliningDisabled() bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return false
+	}
+	for _, s := range info.Settings {
+		if s.Key == "-gcflags" && strings.Contains(s.Value, "-l") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package hook
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// probeTCPFastOpen 读取 net.ipv4.tcp_fastopen，第 0 位表示允许客户端使用
+func probeTCPFastOpen() bool {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/tcp_fastopen")
+	if err != nil {
+		return false
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && v&1 != 0
+}
+
+// probeSOMark 在临时套接字上设置 SO_MARK，没有权限时内核返回 EPERM
+func probeSOMark() bool {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, 0) == nil
+}
+
+// probeEBPF 创建一个只有一项的数组 map，成功说明内核支持且当前进程有权限
+func probeEBPF() bool {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		_          [12]uint32 // 其余字段必须为零
+	}{mapType: unix.BPF_MAP_TYPE_ARRAY, keySize: 4, valueSize: 4, maxEntries: 1}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_MAP_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return false
+	}
+	unix.Close(int(fd))
+	return true
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package hook
+
+import "runtime"
+
+// probeTCPFastOpen macOS 10.11 和 Windows 10 之后默认支持客户端 TCP Fast Open
+func probeTCPFastOpen() bool {
+	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+}
+
+// probeSOMark SO_MARK 只存在于 Linux
+func probeSOMark() bool {
+	return false
+}
+
+// probeEBPF eBPF 只在 Linux 上使用
+func probeEBPF() bool {
+	return false
+}
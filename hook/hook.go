@@ -1,3 +1,5 @@
+//go:build !nohook
+
 package hook
 
 import (
@@ -6,24 +8,46 @@ import (
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"crypto/tls"
-	"crypto/x509"
-	"errors"
 
 	"github.com/agiledragon/gomonkey/v2"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
 	"github.com/ba0gu0/GoHookProxy/proxy"
 )
 
+// Patched 当前构建是否在运行时替换标准库函数
+const Patched = true
+
 type Hook struct {
 	proxyManager *proxy.ProxyManager
-	patcher      *gomonkey.Patches
+	patcher      *gomonkey.Patches // hook 应用的补丁，Disable 时全部还原
 	enabled      bool
 	mu           sync.Mutex
 
 	dnsCache sync.Map
 	dnsTTL   time.Duration
+
+	tlsRules []*tlsRule
+
+	// 自检使用的本地监听地址和命中次数
+	probeAddr atomic.Value
+	probeHits int32
+
+	// 停止 direct_until_healthy 的后台探测
+	cancelStartup context.CancelFunc
+
+	// Disable 等待正在进行的拦截拨号结束
+	barrier dialBarrier
+
+	// 恢复 net.DefaultResolver 原来的 PreferGo 设置
+	restoreResolver func()
+
+	// Enable 替换的函数，见 Status
+	status atomic.Pointer[Status]
 }
 
 func New(pm *proxy.ProxyManager) *Hook {
@@ -34,65 +58,21 @@ func New(pm *proxy.ProxyManager) *Hook {
 	}
 }
 
-func directDialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	// 支持 TCP 和 UDP
-	switch network {
-	case "tcp", "tcp4", "tcp6":
-		addr, err := net.ResolveTCPAddr(network, address)
-		if err != nil {
-			return nil, err
-		}
-		conn, err := net.DialTCP(network, nil, addr)
-		if err != nil {
-			return nil, err
-		}
-
-		go func() {
-			<-ctx.Done()
-			conn.Close()
-		}()
-
-		return conn, nil
-
-	case "udp", "udp4", "udp6":
-		addr, err := net.ResolveUDPAddr(network, address)
-		if err != nil {
-			return nil, err
-		}
-		conn, err := net.DialUDP(network, nil, addr)
-		if err != nil {
-			return nil, err
-		}
-
-		go func() {
-			<-ctx.Done()
-			conn.Close()
-		}()
-
-		return conn, nil
-
-	case "unix", "unixpacket", "unixgram":
-		addr, err := net.ResolveUnixAddr(network, address)
-		if err != nil {
-			return nil, err
-		}
-		conn, err := net.DialUnix(network, nil, addr)
-		if err != nil {
-			return nil, err
-		}
-
-		go func() {
-			<-ctx.Done()
-			conn.Close()
-		}()
-
-		return conn, nil
-
-	default:
-		return nil, fmt.Errorf("不支持的网络类型: %s", network)
+// NewWithPatches 创建使用调用方补丁集合的 hook，集合交给 hook，Disable 时 Reset 其中的全部补丁，包括调用方的补丁
+// 只需与调用方的补丁共存时使用 New: hook 的补丁记录在自己的集合中，Disable 只还原 hook 自己的补丁，
+// 调用方先替换过的同一函数在 Disable 后恢复为调用方的替换函数
+func NewWithPatches(pm *proxy.ProxyManager, patches *gomonkey.Patches) *Hook {
+	h := New(pm)
+	if patches != nil {
+		h.patcher = patches
 	}
+	return h
 }
 
+// Enable 按配置替换标准库函数，各部分相互独立，结果见 Status:
+// Config.Enable 时替换拨号，DNSHook 时由管理器的 Resolver 解析 net.ResolveIPAddr，
+// socks4a、socks5h 和 tor 时阻止走代理的主机名在本地解析，TLSHook 时替换 tls.Config.Clone。
+// 没有需要替换的函数时同样成功；任何一步失败时还原已替换的函数并返回错误
 func (h *Hook) Enable() error {
 	// h.mu.Lock()
 	// defer h.mu.Unlock()
@@ -104,37 +84,59 @@ func (h *Hook) Enable() error {
 	if h.proxyManager == nil {
 		return nil
 	}
+	if h.proxyManager.IsShutdown() {
+		return E.ErrManagerShutdown
+	}
+	config := h.proxyManager.Config
+	if config == nil {
+		return E.WrapError(E.ErrInvalidConfig, "proxy manager has no config")
+	}
+
+	status := &Status{}
+	if err := h.enable(config, status); err != nil {
+		h.patcher.Reset()
+		h.stopStartup()
+		h.restorePreferGo()
+		h.tlsRules = nil
+		return err
+	}
+	h.enabled = true
+	h.setStatus(status)
+	return nil
+}
 
-	if h.proxyManager.Config.Enable {
-		// 使用传入的 patcher 进行 hook
+// enable 按 config 应用补丁并记录到 status，失败时由 Enable 还原
+func (h *Hook) enable(config *C.Config, status *Status) error {
+	if config.Enable {
+		if err := h.startup(); err != nil {
+			return err
+		}
+
+		// 只替换客户端的 DialContext，服务端 Accept 得到的连接不经过这里
 		patcher := h.patcher.ApplyMethod(reflect.TypeOf(&net.Dialer{}), "DialContext",
 			func(d *net.Dialer, ctx context.Context, network, addr string) (net.Conn, error) {
-				start := time.Now()
-				defer func() {
-					if h.proxyManager.Config.MetricsEnable && h.proxyManager.Metrics != nil {
-						h.proxyManager.Metrics.RecordLatency(time.Since(start))
-					}
-				}()
-
-				if h.proxyManager.ShouldProxy(network, addr) {
-					return h.proxyManager.DialContext(ctx, network, addr)
-				}
-				return directDialContext(ctx, network, addr)
+				return h.DialContext(ctx, network, addr)
 			})
 
 		if patcher == nil {
-			h.patcher.Reset()
 			return fmt.Errorf("failed to hook DialContext")
 		}
-		h.enabled = true
+		status.Dial = true
+
+		if config.SelfTest {
+			if err := h.selfTest(); err != nil {
+				return err
+			}
+		}
 	}
 
-	if h.proxyManager.Config.DNSHook {
+	// 远程解析同样替换 net.ResolveIPAddr，不走代理的主机名也由管理器的 Resolver 解析，DNSHook 不再单独替换
+	if config.DNSHook && !config.RemoteDNS() {
 
 		// Hook DNS解析
+		// 替换函数内不能调用原函数，通过 ProxyManager 的 Resolver 解析
 		patcher := h.patcher.ApplyFunc(net.ResolveIPAddr, func(network, address string) (*net.IPAddr, error) {
-			// 实际解析
-			ipAddr, err := net.ResolveIPAddr(network, address)
+			ips, err := h.lookupIPAddr(network, address)
 			if err != nil {
 				// 只在启用指标收集时记录错误
 				if h.proxyManager.Config.MetricsEnable && h.proxyManager.Metrics != nil {
@@ -143,40 +145,58 @@ func (h *Hook) Enable() error {
 				return nil, err
 			}
 
-			return ipAddr, nil
+			return &net.IPAddr{IP: ips[0].IP, Zone: ips[0].Zone}, nil
 		})
 
 		if patcher == nil {
-			h.patcher.Reset()
 			return fmt.Errorf("failed to hook ResolveIPAddr")
 		}
-		h.enabled = true
+		h.preferGoResolver()
+	}
+	status.DNS = config.DNSHook
+
+	if config.RemoteDNS() {
+		if err := h.hookRemoteDNS(); err != nil {
+			return err
+		}
+		h.preferGoResolver()
+		status.RemoteDNS = true
 	}
 
-	if h.proxyManager.Config.TLSHook {
+	if config.TLSHook {
+		rules, err := compileTLSRules(config.TLSRules)
+		if err != nil {
+			return err
+		}
+		h.tlsRules = rules
 
 		// Hook TLS配置
 		patcher := h.patcher.ApplyMethod(reflect.TypeOf(&tls.Config{}), "Clone",
 			func(c *tls.Config) *tls.Config {
-				clone := c.Clone()
+				clone := cloneTLSConfig(c)
+				if clone == nil {
+					return nil
+				}
 
 				// 注入自定义验证
 				if clone.VerifyPeerCertificate == nil {
 					clone.VerifyPeerCertificate = h.verifyPeerCertificate
 				}
+				h.applyTLSRules(clone)
 				return clone
 			})
 
 		if patcher == nil {
-			h.patcher.Reset()
 			return fmt.Errorf("failed to hook TLS Clone")
 		}
-		h.enabled = true
+		status.TLS = true
 	}
 
 	return nil
 }
 
+// Disable 还原补丁，先等待正在进行的拦截拨号结束，最多等待 DisableTimeout
+// 等待期间新的拨号直连；超时后仍然还原补丁，并返回 ErrHookDrainTimeout
 func (h *Hook) Disable() error {
 	// h.mu.Lock()
 	// defer h.mu.Unlock()
@@ -184,38 +204,16 @@ func (h *Hook) Disable() error {
 	if !h.enabled {
 		return nil
 	}
+	var timeout time.Duration
+	if config := h.proxyManager.Config; config != nil {
+		timeout = config.DisableTimeout
+	}
+	err := h.barrier.drain(h.proxyManager.Clock(), timeout)
 	h.patcher.Reset()
+	h.stopStartup()
+	h.restorePreferGo()
 	h.enabled = false
-	return nil
-}
-
-type dnsCacheEntry struct {
-	ipAddr    *net.IPAddr
-	timestamp time.Time
-}
-
-// 自定义证书验证
-func (h *Hook) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-	// 在这里添加自定义的证书验证逻辑
-	if len(rawCerts) == 0 {
-		return errors.New("no certificates provided")
-	}
-
-	cert, err := x509.ParseCertificate(rawCerts[0])
-	if err != nil {
-		return err
-	}
-
-	// 检查证书是否过期
-	now := time.Now()
-	if now.Before(cert.NotBefore) {
-		return fmt.Errorf("certificate not valid before %v", cert.NotBefore)
-	}
-	if now.After(cert.NotAfter) {
-		return fmt.Errorf("certificate expired on %v", cert.NotAfter)
-	}
-
-	// 可以添加更多自定义验证...
-
-	return nil
+	h.setStatus(nil)
+	h.barrier.reopen()
+	return err
 }
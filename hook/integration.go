@@ -0,0 +1,217 @@
+package hook
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DialContext 按 hook 的路由规则拨号
+// 补丁生效时所有 net.Dialer 拨号都会经过这里，nohook 构建下可以显式使用
+func (h *Hook) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	defer func() {
+		if h.proxyManager.Config.MetricsEnable && h.proxyManager.Metrics != nil {
+			h.proxyManager.Metrics.RecordLatency(time.Since(start))
+		}
+	}()
+
+	// Disable 进行中时直连，不再进入可能被还原的代理路径
+	if !h.barrier.enter() {
+		return h.directDialContext(ctx, network, addr)
+	}
+	defer h.barrier.leave()
+
+	if h.isProbe(addr) {
+		atomic.AddInt32(&h.probeHits, 1)
+		return h.directDialContext(ctx, network, addr)
+	}
+	if l := h.proxyManager.SelfListener(network, addr); l != nil {
+		return l.DialContext(ctx)
+	}
+	// 由 BypassPackages 中的包发起的拨号直连
+	if h.callerBypassed() {
+		return h.directDialContext(ctx, network, addr)
+	}
+	decision := h.proxyManager.ExplainContext(ctx, network, addr)
+	if h.sniRoutable(network, addr, decision) {
+		return h.newSNIConn(ctx, network, addr, decision), nil
+	}
+	return h.dialDecision(ctx, network, addr, decision)
+}
+
+// Shutdown 停止 hook 并关闭代理管理器，适合服务按顺序关闭时调用
+// 先 Disable 还原补丁(最多等待 DisableTimeout)，再调用 ProxyManager.Shutdown 做最后一次指标导出、调用 OnShutdown 注册的函数并关闭会话；
+// 返回所有未能正常停止的部分的错误
+func (h *Hook) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := h.Disable(); err != nil {
+		errs = append(errs, fmt.Errorf("disable hook: %w", err))
+	}
+	if err := h.proxyManager.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Transport 返回使用 hook 路由规则拨号的 http.Transport
+func (h *Hook) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = h.DialContext
+	transport.TLSClientConfig = h.TLSConfig(transport.TLSClientConfig)
+	return transport
+}
+
+// TLSConfig 返回应用了 TLS 规则和证书检查的配置副本，c 为 nil 时使用空配置
+// 需要启用 TLSHook，否则原样返回副本
+func (h *Hook) TLSConfig(c *tls.Config) *tls.Config {
+	if c == nil {
+		c = &tls.Config{}
+	}
+	clone := cloneTLSConfig(c)
+	if !h.proxyManager.Config.TLSHook {
+		return clone
+	}
+	if clone.VerifyPeerCertificate == nil {
+		clone.VerifyPeerCertificate = h.verifyPeerCertificate
+	}
+	h.applyTLSRules(clone)
+	return clone
+}
+
+// startup 按 StartupPolicy 检查代理，返回错误时不应替换任何函数
+func (h *Hook) startup() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := h.proxyManager.Startup(ctx); err != nil {
+		cancel()
+		return err
+	}
+	h.cancelStartup = cancel
+	return nil
+}
+
+// stopStartup 停止启动阶段的后台探测
+func (h *Hook) stopStartup() {
+	if h.cancelStartup != nil {
+		h.cancelStartup()
+		h.cancelStartup = nil
+	}
+}
+
+// directDialContext 直连目标，ctx 结束时关闭连接
+func (h *Hook) directDialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// 支持 TCP 和 UDP
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		conn, err := h.proxyManager.DialDirect(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return closeOnCancel(ctx, conn), nil
+
+	case "unix", "unixpacket", "unixgram":
+		addr, err := net.ResolveUnixAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialUnix(network, nil, addr)
+		if err != nil {
+			return nil, err
+		}
+		return closeOnCancel(ctx, conn), nil
+
+	default:
+		return nil, fmt.Errorf("不支持的网络类型: %s", network)
+	}
+}
+
+// ctxConn ctx 结束时被关闭的连接，Close 时取消对 ctx 的登记
+// 长期存在的 ctx 上拨出的连接关闭后不会留下 goroutine 或回调
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+func (c *ctxConn) Unwrap() net.Conn { return c.Conn }
+
+// SyscallConn 返回底层套接字，供设置 DSCP 等套接字选项
+func (c *ctxConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, syscall.EINVAL
+	}
+	return sc.SyscallConn()
+}
+
+// ctxPacketConn UDP 连接的 ctxConn，保留 net.PacketConn 接口
+// Go 解析器按连接是否实现 PacketConn 决定 DNS 报文是否带 TCP 的长度前缀
+type ctxPacketConn struct {
+	*net.UDPConn
+	stop func() bool
+}
+
+func (c *ctxPacketConn) Close() error {
+	c.stop()
+	return c.UDPConn.Close()
+}
+
+func (c *ctxPacketConn) Unwrap() net.Conn { return c.UDPConn }
+
+// closeOnCancel 在 ctx 结束时关闭 conn，ctx 永远不会结束时原样返回 conn
+func closeOnCancel(ctx context.Context, conn net.Conn) net.Conn {
+	if ctx.Done() == nil {
+		return conn
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	if udp, ok := conn.(*net.UDPConn); ok {
+		return &ctxPacketConn{UDPConn: udp, stop: stop}
+	}
+	return &ctxConn{Conn: conn, stop: stop}
+}
+
+type dnsCacheEntry struct {
+	ipAddr    *net.IPAddr
+	timestamp time.Time
+}
+
+// 自定义证书验证
+func (h *Hook) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	// 在这里添加自定义的证书验证逻辑
+	if len(rawCerts) == 0 {
+		return errors.New("no certificates provided")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	// 检查证书是否过期，按代理管理器的时间来源判断
+	now := h.proxyManager.Clock().Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate not valid before %v", cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate expired on %v", cert.NotAfter)
+	}
+
+	// 可以添加更多自定义验证...
+
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build nohook
+
+package hook
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// Patched 当前构建是否在运行时替换标准库函数
+// nohook 构建下不修改任何函数，需要显式使用 DialContext、Transport 和 TLSConfig
+const Patched = false
+
+type Hook struct {
+	proxyManager *proxy.ProxyManager
+	enabled      bool
+	mu           sync.Mutex
+
+	dnsCache sync.Map
+	dnsTTL   time.Duration
+
+	tlsRules []*tlsRule
+
+	// 自检使用的本地监听地址和命中次数
+	probeAddr atomic.Value
+	probeHits int32
+
+	// 停止 direct_until_healthy 的后台探测
+	cancelStartup context.CancelFunc
+
+	// Disable 等待正在进行的拦截拨号结束
+	barrier dialBarrier
+
+	// Enable 的结果，见 Status
+	status atomic.Pointer[Status]
+}
+
+func New(pm *proxy.ProxyManager) *Hook {
+	return &Hook{
+		proxyManager: pm,
+		dnsTTL:       5 * time.Minute,
+	}
+}
+
+// Enable 加载 TLS 规则供 TLSConfig 使用，不替换任何函数；Config.Enable 时按 StartupPolicy 检查代理
+func (h *Hook) Enable() error {
+	if h.enabled || h.proxyManager == nil {
+		return nil
+	}
+	if h.proxyManager.IsShutdown() {
+		return E.ErrManagerShutdown
+	}
+	config := h.proxyManager.Config
+	if config == nil {
+		return E.WrapError(E.ErrInvalidConfig, "proxy manager has no config")
+	}
+
+	if config.Enable {
+		if err := h.startup(); err != nil {
+			return err
+		}
+	}
+
+	status := &Status{}
+	if config.TLSHook {
+		rules, err := compileTLSRules(config.TLSRules)
+		if err != nil {
+			h.stopStartup()
+			return err
+		}
+		h.tlsRules = rules
+		status.TLS = true
+	}
+	h.enabled = true
+	h.setStatus(status)
+	return nil
+}
+
+// Disable 等待正在进行的 DialContext 结束，最多等待 DisableTimeout，等待期间新的拨号直连
+func (h *Hook) Disable() error {
+	var err error
+	if h.enabled {
+		var timeout time.Duration
+		if config := h.proxyManager.Config; config != nil {
+			timeout = config.DisableTimeout
+		}
+		err = h.barrier.drain(h.proxyManager.Clock(), timeout)
+	}
+	h.stopStartup()
+	h.enabled = false
+	h.setStatus(nil)
+	h.barrier.reopen()
+	return err
+}
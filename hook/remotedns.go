@@ -0,0 +1,151 @@
+//go:build !nohook
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// hookRemoteDNS socks4a、socks5h 和 tor 模式下替换 net.Resolve*，走代理的主机名不在本地解析
+// IP 字面量和直连的主机名仍然正常解析，替换函数内不能调用原函数，改用 ProxyManager 的 Resolver
+func (h *Hook) hookRemoteDNS() error {
+	if h.patcher.ApplyFunc(net.ResolveIPAddr, func(network, address string) (*net.IPAddr, error) {
+		ips, err := h.resolveLocal("tcp", network, address, "0")
+		if err != nil {
+			return nil, err
+		}
+		return &net.IPAddr{IP: ips[0].IP, Zone: ips[0].Zone}, nil
+	}) == nil {
+		return fmt.Errorf("failed to hook ResolveIPAddr")
+	}
+
+	if h.patcher.ApplyFunc(net.ResolveTCPAddr, func(network, address string) (*net.TCPAddr, error) {
+		host, port, err := splitResolveAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := h.resolveLocal(network, network, host, strconv.Itoa(port))
+		if err != nil {
+			return nil, err
+		}
+		return &net.TCPAddr{IP: ips[0].IP, Port: port, Zone: ips[0].Zone}, nil
+	}) == nil {
+		return fmt.Errorf("failed to hook ResolveTCPAddr")
+	}
+
+	if h.patcher.ApplyFunc(net.ResolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		host, port, err := splitResolveAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := h.resolveLocal(network, network, host, strconv.Itoa(port))
+		if err != nil {
+			return nil, err
+		}
+		return &net.UDPAddr{IP: ips[0].IP, Port: port, Zone: ips[0].Zone}, nil
+	}) == nil {
+		return fmt.Errorf("failed to hook ResolveUDPAddr")
+	}
+	return nil
+}
+
+// preferGoResolver 让 net.DefaultResolver 使用 Go 解析器，Disable 时恢复原设置
+// cgo 解析器直接调用系统库，查询不经过被替换的 net.Dialer，两种解析器对 hosts、search 等的处理也不同
+// 统一使用 Go 解析器后 DNS 查询在各平台上都经过 hook
+func (h *Hook) preferGoResolver() {
+	if h.restoreResolver != nil {
+		return
+	}
+	prev := net.DefaultResolver.PreferGo
+	net.DefaultResolver.PreferGo = true
+	h.restoreResolver = func() { net.DefaultResolver.PreferGo = prev }
+}
+
+// restorePreferGo 恢复 net.DefaultResolver 原来的 PreferGo 设置
+func (h *Hook) restorePreferGo() {
+	if h.restoreResolver != nil {
+		h.restoreResolver()
+		h.restoreResolver = nil
+	}
+}
+
+// resolveLocal 解析主机名，规则决定走代理的主机名返回 ErrLocalDNSBlocked
+// routeNetwork 用于路由判断，network 决定返回的地址族
+func (h *Hook) resolveLocal(routeNetwork, network, host, port string) ([]net.IPAddr, error) {
+	if host == "" {
+		return []net.IPAddr{{}}, nil
+	}
+	if ip, zone, ok := parseIPZone(host); ok {
+		return []net.IPAddr{{IP: ip, Zone: zone}}, nil
+	}
+	// 假 IP 解析器分配的地址在拨号时还原为主机名，可以交给调用方
+	_, fakeIP := h.proxyManager.Resolver().(proxy.ReverseResolver)
+	if !fakeIP && h.proxyManager.ShouldProxy(routeNetwork, net.JoinHostPort(host, port)) {
+		return nil, &net.DNSError{Err: errors.ErrLocalDNSBlocked.Error(), Name: host, UnwrapErr: errors.ErrLocalDNSBlocked}
+	}
+	return h.lookupIPAddr(network, host)
+}
+
+// lookupIPAddr 用 ProxyManager 的 Resolver 解析主机名，返回符合 network 地址族的第一个地址
+func (h *Hook) lookupIPAddr(network, host string) ([]net.IPAddr, error) {
+	if host == "" {
+		return []net.IPAddr{{}}, nil
+	}
+	if ip, zone, ok := parseIPZone(host); ok {
+		return []net.IPAddr{{IP: ip, Zone: zone}}, nil
+	}
+	ips, err := h.proxyManager.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if matchFamily(network, ip.IP) {
+			return []net.IPAddr{ip}, nil
+		}
+	}
+	return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+}
+
+// splitResolveAddr 拆分 host:port，端口可以是服务名
+func splitResolveAddr(network, address string) (string, int, error) {
+	if address == "" {
+		return "", 0, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := net.LookupPort(network, portStr)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
+
+// parseIPZone 解析 IP 字面量，支持 IPv6 zone
+func parseIPZone(host string) (net.IP, string, bool) {
+	addr, zone, _ := strings.Cut(host, "%")
+	ip := net.ParseIP(addr)
+	return ip, zone, ip != nil
+}
+
+// matchFamily 判断 IP 是否符合 network 要求的地址族
+// ResolveIPAddr 的 network 可以带协议，如 ip4:icmp
+func matchFamily(network string, ip net.IP) bool {
+	network, _, _ = strings.Cut(network, ":")
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return ip.To4() != nil
+	case strings.HasSuffix(network, "6"):
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
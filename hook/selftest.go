@@ -0,0 +1,83 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// selfTestTimeout 自检拨号的超时时间
+const selfTestTimeout = 2 * time.Second
+
+// isProbe 判断是否为自检连接，自检连接直连本地监听器，不经过代理
+func (h *Hook) isProbe(addr string) bool {
+	probe, _ := h.probeAddr.Load().(string)
+	return probe != "" && probe == addr
+}
+
+// selfTest 通过进程内监听器验证 DialContext 补丁确实生效并记录了指标
+func (h *Hook) selfTest() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return E.WrapError(E.ErrHookFailed, fmt.Sprintf("self-test: listen: %v", err))
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().String()
+	h.probeAddr.Store(addr)
+	defer h.probeAddr.Store("")
+
+	checks := []struct {
+		name string
+		dial func() (net.Conn, error)
+	}{
+		{"net.Dialer.DialContext", func() (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+			defer cancel()
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}},
+		{"net.Dial", func() (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, selfTestTimeout)
+		}},
+	}
+
+	pm := h.proxyManager
+	metricsEnabled := pm.Config.MetricsEnable && pm.Metrics != nil
+	var before time.Duration
+	if metricsEnabled {
+		before = pm.Metrics.GetMetrics().ConnectionDuration
+	}
+
+	for _, check := range checks {
+		atomic.StoreInt32(&h.probeHits, 0)
+		conn, err := check.dial()
+		if err != nil {
+			return E.WrapError(E.ErrHookFailed, fmt.Sprintf("self-test: dial via %s: %v", check.name, err))
+		}
+		conn.Close()
+
+		if atomic.LoadInt32(&h.probeHits) == 0 {
+			return E.WrapError(E.ErrHookFailed, fmt.Sprintf(
+				"self-test: dial via %s was not intercepted, the DialContext patch did not take effect "+
+					"(usually caused by inlining; build with -gcflags=all=-l)", check.name))
+		}
+	}
+
+	if metricsEnabled && pm.Metrics.GetMetrics().ConnectionDuration <= before {
+		return E.WrapError(E.ErrHookFailed, "self-test: hooked dial was intercepted but no metrics were recorded")
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+const (
+	// sniffTimeout 连接建立后客户端没有写入时，等待这么久后按目标 IP 的路由拨号，用于服务端先发送数据的协议
+	sniffTimeout = 300 * time.Millisecond
+	// maxTLSRecord TLS 记录头和最大记录长度之和，超过时不再缓存 ClientHello
+	maxTLSRecord = 5 + 16384 + 2048
+)
+
+var errSNICaptured = errors.New("sni captured")
+
+// sniRoutable 判断是否推迟拨号按 SNI 路由: 启用了 SNIRouting 的 TCP 连接，目标为 IP 且没有命中规则
+// 因 hook 未启用、代理地址等原因确定的路由不受 SNI 影响
+func (h *Hook) sniRoutable(network, addr string, decision rules.Decision) bool {
+	config := h.proxyManager.Config
+	if !config.SNIRouting || !config.FeatureEnabled(C.FeatureSNIRouting) || rules.IsUDPNetwork(network) || decision.Rule != nil || decision.Reason != "default" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+// dialDecision 按路由决策拨号并记录决策
+func (h *Hook) dialDecision(ctx context.Context, network, addr string, decision rules.Decision) (net.Conn, error) {
+	if h.proxyManager.Metrics != nil {
+		h.proxyManager.Metrics.RecordDecision(string(decision.Action))
+	}
+	switch decision.Action {
+	case rules.Proxy:
+		return h.proxyManager.DialContext(ctx, network, addr)
+	case rules.Reject:
+		return nil, E.WrapError(E.ErrRuleRejected, decision.Reason)
+	}
+	conn, err := h.directDialContext(ctx, network, addr)
+	if err == nil && decision.Rule != nil {
+		proxy.ApplySocketTuning(conn, decision.Rule.Tuning)
+	}
+	return conn, err
+}
+
+// sniConn 推迟到客户端发送 ClientHello 后才拨号的连接
+// 第一次写入的数据是完整的 TLS 记录时按其中的 SNI 重新匹配路由规则，否则按目标 IP 的路由；
+// 拨号仍然使用原来的目标 IP，SNI 只影响走代理还是直连
+type sniConn struct {
+	h        *Hook
+	ctx      context.Context
+	network  string
+	addr     string
+	fallback rules.Decision // 按目标 IP 的路由
+
+	mu        sync.Mutex
+	started   bool
+	buf       []byte // 拨号前写入的数据
+	deadlines [3]time.Time
+
+	once   sync.Once
+	dialed chan struct{} // 拨号完成或连接关闭后关闭
+	conn   net.Conn
+	err    error
+}
+
+func (h *Hook) newSNIConn(ctx context.Context, network, addr string, fallback rules.Decision) *sniConn {
+	return &sniConn{
+		h:        h,
+		ctx:      ctx,
+		network:  network,
+		addr:     addr,
+		fallback: fallback,
+		dialed:   make(chan struct{}),
+	}
+}
+
+// start 按 host 的路由拨号并发送缓存的数据，host 为空时按目标 IP 的路由，只执行一次
+func (c *sniConn) start(host string) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.started = true
+		buf := c.buf
+		c.buf = nil
+		c.mu.Unlock()
+
+		decision := c.fallback
+		if host != "" {
+			_, port, _ := net.SplitHostPort(c.addr)
+			decision = c.h.proxyManager.ExplainContext(c.ctx, c.network, net.JoinHostPort(host, port))
+		}
+		conn, err := c.h.dialDecision(c.ctx, c.network, c.addr, decision)
+		if err == nil {
+			c.mu.Lock()
+			c.applyDeadlines(conn)
+			c.conn = conn
+			c.mu.Unlock()
+			if len(buf) > 0 {
+				_, err = conn.Write(buf)
+			}
+		}
+		c.err = err
+		close(c.dialed)
+	})
+}
+
+// wait 等待拨号完成
+func (c *sniConn) wait() error {
+	<-c.dialed
+	return c.err
+}
+
+func (c *sniConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.started {
+		c.mu.Unlock()
+		if err := c.wait(); err != nil {
+			return 0, err
+		}
+		return c.conn.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	host, ok := sniffServerName(c.buf)
+	c.mu.Unlock()
+	if !ok {
+		return len(b), nil
+	}
+	c.start(host)
+	if err := c.wait(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read 在客户端写入前调用时最多等待 sniffTimeout，之后按目标 IP 的路由拨号
+func (c *sniConn) Read(b []byte) (int, error) {
+	timer := time.NewTimer(sniffTimeout)
+	select {
+	case <-c.dialed:
+	case <-timer.C:
+		c.start("")
+	}
+	timer.Stop()
+	if err := c.wait(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *sniConn) Close() error {
+	c.once.Do(func() {
+		c.err = net.ErrClosed
+		close(c.dialed)
+	})
+	<-c.dialed
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// LocalAddr 拨号前返回空地址
+func (c *sniConn) LocalAddr() net.Addr {
+	select {
+	case <-c.dialed:
+		if c.conn != nil {
+			return c.conn.LocalAddr()
+		}
+	default:
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *sniConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.addr)
+	return addr
+}
+
+func (c *sniConn) SetDeadline(t time.Time) error {
+	return c.setDeadline(0, t)
+}
+
+func (c *sniConn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(1, t)
+}
+
+func (c *sniConn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(2, t)
+}
+
+// setDeadline 拨号前记录截止时间，拨号后设置到连接上
+func (c *sniConn) setDeadline(i int, t time.Time) error {
+	c.mu.Lock()
+	c.deadlines[i] = t
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	switch i {
+	case 1:
+		return conn.SetReadDeadline(t)
+	case 2:
+		return conn.SetWriteDeadline(t)
+	}
+	return conn.SetDeadline(t)
+}
+
+// applyDeadlines 把拨号前记录的截止时间设置到 conn，调用方持有 mu
+func (c *sniConn) applyDeadlines(conn net.Conn) {
+	if t := c.deadlines[0]; !t.IsZero() {
+		conn.SetDeadline(t)
+	}
+	if t := c.deadlines[1]; !t.IsZero() {
+		conn.SetReadDeadline(t)
+	}
+	if t := c.deadlines[2]; !t.IsZero() {
+		conn.SetWriteDeadline(t)
+	}
+}
+
+// Unwrap 返回拨号后的连接，拨号前为 nil
+func (c *sniConn) Unwrap() net.Conn {
+	select {
+	case <-c.dialed:
+		return c.conn
+	default:
+		return nil
+	}
+}
+
+// sniffServerName 从客户端最先写入的数据中取出 SNI
+// ok 为 false 表示还需要更多数据；数据不是 TLS 握手、超过最大记录长度或没有 SNI 时 host 为空
+func sniffServerName(b []byte) (host string, ok bool) {
+	if len(b) == 0 {
+		return "", false
+	}
+	if b[0] != 0x16 {
+		return "", true
+	}
+	if len(b) < 5 {
+		return "", false
+	}
+	n := 5 + int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < n {
+		return "", n > maxTLSRecord
+	}
+	return clientHelloServerName(b[:n]), true
+}
+
+// clientHelloServerName 用 crypto/tls 解析 ClientHello，取得 SNI 后中止握手
+func clientHelloServerName(record []byte) string {
+	var name string
+	tls.Server(&helloConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errSNICaptured
+		},
+	}).Handshake()
+	return name
+}
+
+// helloConn 只读的连接，解析 ClientHello 时不会向外写入任何数据
+type helloConn struct {
+	r io.Reader
+}
+
+func (c *helloConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c *helloConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *helloConn) Close() error                       { return nil }
+func (c *helloConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *helloConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c *helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *helloConn) SetWriteDeadline(t time.Time) error { return nil }
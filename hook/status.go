@@ -0,0 +1,31 @@
+package hook
+
+// Status hook 当前替换了哪些函数，由 Enable 按当时的配置决定，之后 UpdateConfig 不会改变它
+//
+// 各部分相互独立: Config.Enable 只决定是否替换拨号，DNSHook 和 TLSHook 在未启用代理时同样生效。
+// nohook 构建下不替换任何函数，Dial、DNS 和 RemoteDNS 总是 false，TLS 表示 TLSConfig 和 Transport 使用 TLSRules
+type Status struct {
+	Enabled   bool // 已调用 Enable 且没有 Disable
+	Patched   bool // 当前构建在运行时替换标准库函数，同 Patched 常量
+	Dial      bool // net.Dialer.DialContext 经过代理管理器(Config.Enable)
+	DNS       bool // net.ResolveIPAddr 由代理管理器的 Resolver 解析(DNSHook)
+	RemoteDNS bool // 走代理的主机名不在本地解析(socks4a、socks5h 和 tor)
+	TLS       bool // tls.Config.Clone 注入证书验证并应用 TLSRules(TLSHook)
+}
+
+// Status 返回 hook 当前的状态，未 Enable 时只有 Patched 可能为 true
+func (h *Hook) Status() Status {
+	if s := h.status.Load(); s != nil {
+		return *s
+	}
+	return Status{Patched: Patched}
+}
+
+// setStatus 记录 Enable 的结果，s 为 nil 表示已还原
+func (h *Hook) setStatus(s *Status) {
+	if s != nil {
+		s.Enabled = true
+		s.Patched = Patched
+	}
+	h.status.Store(s)
+}
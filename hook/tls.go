@@ -0,0 +1,291 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// tlsRule 编译后的目标 TLS 规则
+type tlsRule struct {
+	pattern    string
+	rootCAs    *x509.CertPool
+	roots      []*x509.Certificate // rootCAs 中的证书，用于合并到配置的根证书
+	pins       map[string]bool
+	skipVerify bool
+}
+
+// compileTLSRules 加载规则中的证书文件和指纹
+func compileTLSRules(tlsRules []C.TLSRule) ([]*tlsRule, error) {
+	compiled := make([]*tlsRule, 0, len(tlsRules))
+	for _, r := range tlsRules {
+		rule := &tlsRule{
+			pattern:    strings.ToLower(r.Pattern),
+			skipVerify: r.SkipVerify,
+		}
+
+		if r.RootCAFile != "" {
+			data, err := os.ReadFile(r.RootCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("load root CA for %s: %w", r.Pattern, err)
+			}
+			rule.roots, err = parseCertificates(data)
+			if err != nil {
+				return nil, fmt.Errorf("load root CA for %s: %w", r.Pattern, err)
+			}
+			if len(rule.roots) == 0 {
+				return nil, fmt.Errorf("no certificates found in %s", r.RootCAFile)
+			}
+			rule.rootCAs = x509.NewCertPool()
+			for _, cert := range rule.roots {
+				rule.rootCAs.AddCert(cert)
+			}
+		}
+
+		if len(r.PinnedSHA256) > 0 {
+			rule.pins = make(map[string]bool, len(r.PinnedSHA256))
+			for _, pin := range r.PinnedSHA256 {
+				rule.pins[pin] = true
+			}
+		}
+
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// parseCertificates 解析 PEM 中的所有证书，忽略其他类型的块
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// matchTLSRule 查找第一个匹配目标主机的规则
+func (h *Hook) matchTLSRule(serverName string) *tlsRule {
+	for _, rule := range h.tlsRules {
+		if rules.MatchHost(rule.pattern, serverName) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// cloneTLSConfig 复制 tls.Config 的导出字段
+// 不能在 Clone 的 hook 中调用 Clone 本身，否则会无限递归
+func cloneTLSConfig(c *tls.Config) *tls.Config {
+	if c == nil {
+		return nil
+	}
+	clone := &tls.Config{}
+	src := reflect.ValueOf(c).Elem()
+	dst := reflect.ValueOf(clone).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if src.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return clone
+}
+
+// applyTLSRules 注入按目标地址生效的证书验证
+// 创建时已知 ServerName 的配置只在匹配规则时修改，其他配置保留标准库的证书验证
+func (h *Hook) applyTLSRules(clone *tls.Config) {
+	if len(h.tlsRules) == 0 || isServerConfig(clone) {
+		return
+	}
+	if clone.ServerName != "" {
+		if rule := h.matchTLSRule(clone.ServerName); rule != nil {
+			rule.apply(clone)
+		}
+		return
+	}
+	h.applyDeferredTLSRules(clone)
+}
+
+// isServerConfig 判断是否为服务端配置，服务端配置不做处理
+// 通过 Certificates 提供客户端证书的配置需要设置 ServerName 或改用 GetClientCertificate
+func isServerConfig(c *tls.Config) bool {
+	return c.ClientAuth != tls.NoClientCert || c.GetCertificate != nil || c.GetConfigForClient != nil ||
+		len(c.Certificates) > 0 && c.ServerName == ""
+}
+
+// apply 把规则应用到目标主机已知的配置，证书链仍由标准库验证
+func (r *tlsRule) apply(c *tls.Config) {
+	if r.rootCAs != nil {
+		c.RootCAs = r.rootCAs
+	}
+	if r.skipVerify {
+		c.InsecureSkipVerify = true
+	}
+	if len(r.pins) == 0 {
+		return
+	}
+	next := c.VerifyConnection
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := r.checkPins(cs, c.ServerName); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// applyDeferredTLSRules 处理创建时还没有 ServerName 的配置
+// http.Transport 在 Clone 之后才设置 ServerName，只能在握手后按最终的目标主机检查
+func (h *Hook) applyDeferredTLSRules(clone *tls.Config) {
+	var extra []*x509.Certificate
+	for _, rule := range h.tlsRules {
+		// 跳过验证只能由 VerifyConnection 接管证书链验证
+		if rule.skipVerify {
+			h.takeOverVerification(clone)
+			return
+		}
+		extra = append(extra, rule.roots...)
+	}
+
+	insecure := clone.InsecureSkipVerify
+	base := clone.RootCAs
+	restrict := len(extra) > 0 && !insecure
+	if restrict {
+		// 标准库用合并后的根证书验证，握手后再把规则的根证书限制在匹配的主机上
+		pool := x509.NewCertPool()
+		if base != nil {
+			pool = base.Clone()
+		} else if system, err := x509.SystemCertPool(); err == nil {
+			pool = system
+		}
+		for _, cert := range extra {
+			pool.AddCert(cert)
+		}
+		clone.RootCAs = pool
+	}
+
+	next := clone.VerifyConnection
+	clone.VerifyConnection = func(cs tls.ConnectionState) error {
+		serverName := targetServerName(cs, clone)
+		rule := h.matchTLSRule(serverName)
+		if restrict && len(cs.PeerCertificates) > 0 {
+			roots := base
+			if rule != nil && rule.rootCAs != nil {
+				roots = rule.rootCAs
+			}
+			if err := verifyChain(cs, serverName, roots); err != nil {
+				return err
+			}
+		}
+		if rule != nil {
+			if err := rule.checkPins(cs, serverName); err != nil {
+				return err
+			}
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// takeOverVerification 由 VerifyConnection 接管证书链验证，此时才能拿到最终的 ServerName
+// 只用于还不知道目标主机且存在跳过验证的规则时，此时 VerifiedChains 为空
+func (h *Hook) takeOverVerification(clone *tls.Config) {
+	insecure := clone.InsecureSkipVerify
+	roots := clone.RootCAs
+	next := clone.VerifyConnection
+
+	clone.InsecureSkipVerify = true
+	clone.VerifyConnection = func(cs tls.ConnectionState) error {
+		serverName := targetServerName(cs, clone)
+		if len(cs.PeerCertificates) > 0 {
+			if err := verifyConnection(cs, serverName, h.matchTLSRule(serverName), insecure, roots); err != nil {
+				return err
+			}
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// targetServerName 返回握手的目标主机，IP 地址不会作为 SNI 发送，此时从配置中取
+func targetServerName(cs tls.ConnectionState, c *tls.Config) string {
+	if cs.ServerName != "" {
+		return cs.ServerName
+	}
+	return c.ServerName
+}
+
+// verifyConnection 按规则验证服务端证书，没有匹配规则时等同于标准验证
+func verifyConnection(cs tls.ConnectionState, serverName string, rule *tlsRule, insecure bool, roots *x509.CertPool) error {
+	if rule != nil {
+		if rule.rootCAs != nil {
+			roots = rule.rootCAs
+		}
+		insecure = insecure || rule.skipVerify
+	}
+	if !insecure {
+		if err := verifyChain(cs, serverName, roots); err != nil {
+			return err
+		}
+	}
+	if rule != nil {
+		return rule.checkPins(cs, serverName)
+	}
+	return nil
+}
+
+// verifyChain 用 roots 验证服务端证书链，roots 为 nil 时使用系统根证书
+func verifyChain(cs tls.ConnectionState, serverName string, roots *x509.CertPool) error {
+	if serverName == "" {
+		return fmt.Errorf("tls: either ServerName or InsecureSkipVerify must be specified")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       strings.Trim(serverName, "[]"),
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// checkPins 检查证书链中是否有指纹匹配的证书，规则没有指纹时直接通过
+func (r *tlsRule) checkPins(cs tls.ConnectionState, serverName string) error {
+	if len(r.pins) == 0 {
+		return nil
+	}
+	for _, cert := range cs.PeerCertificates {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if r.pins[base64.StdEncoding.EncodeToString(sum[:])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("tls: no pinned certificate matched for %s", serverName)
+}
@@ -0,0 +1,74 @@
+// Package hostport 处理 host:port 形式的地址，统一 IPv6 字面量的方括号、IPv4-mapped 地址和主机名的写法
+package hostport
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Join 拼接主机和端口，IPv6 字面量加方括号，已经带方括号的主机不重复添加
+func Join(host string, port int) string {
+	return net.JoinHostPort(trimBrackets(host), strconv.Itoa(port))
+}
+
+// Split 拆分 host:port，返回不带方括号的主机和数值端口
+func Split(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, &net.AddrError{Err: "invalid port", Addr: addr}
+	}
+	return host, int(port), nil
+}
+
+// Host 返回地址的主机部分，addr 没有端口时视为主机，IPv6 字面量去掉方括号
+func Host(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return trimBrackets(addr)
+}
+
+// ParseIP 解析 IP 字面量，接受方括号和 zone，IPv4-mapped 地址转换为 IPv4，zone 被丢弃
+// host 不是 IP 字面量时返回 nil
+func ParseIP(host string) net.IP {
+	ip, err := netip.ParseAddr(trimBrackets(host))
+	if err != nil {
+		return nil
+	}
+	return net.IP(ip.Unmap().WithZone("").AsSlice())
+}
+
+// CanonicalHost 规范化主机: IP 字面量转为标准写法，主机名转为小写并去掉末尾的点
+func CanonicalHost(host string) string {
+	if ip := ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Canonical 规范化 host:port，无法解析时原样返回
+func Canonical(addr string) string {
+	host, port, err := Split(addr)
+	if err != nil {
+		return addr
+	}
+	return Join(CanonicalHost(host), port)
+}
+
+// Equal 判断两个 host:port 规范化后是否相同，不做 DNS 解析
+func Equal(a, b string) bool {
+	return a == b || Canonical(a) == Canonical(b)
+}
+
+func trimBrackets(host string) string {
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Exporter 指标导出器，PrometheusExporter 和 OTLPExporter 输出同一组指标族
+type Exporter interface {
+	// Export 导出一次 mc 当前的指标
+	Export(ctx context.Context, mc *MetricsCollector) error
+}
+
+// StartExporter 每 interval 调用一次 e.Export，用于 OTLPExporter 这类推送的导出器
+// 每次导出的超时为 interval，失败交给 onError(可以为 nil)；返回的 stop 停止定时导出并做最后一次导出
+func (mc *MetricsCollector) StartExporter(e Exporter, interval time.Duration, onError func(error)) (stop func(ctx context.Context) error) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				if err := e.Export(ctx, mc); err != nil && onError != nil {
+					onError(err)
+				}
+				cancel()
+			}
+		}
+	}()
+	return func(ctx context.Context) error {
+		close(done)
+		<-exited
+		return e.Export(ctx, mc)
+	}
+}
+
+// family 导出器共用的指标族定义，counter 的 name 不含 _total 后缀
+type family struct {
+	name       string
+	help       string
+	unit       string
+	typ        string // counter、gauge 或 histogram
+	value      float64
+	samples    []point // 带标签的 counter 或 gauge，为空时使用 value
+	histograms []histogramSeries
+}
+
+// point 带标签的值
+type point struct {
+	labels [][2]string
+	value  float64
+}
+
+// histogramSeries 带标签的直方图
+type histogramSeries struct {
+	labels [][2]string
+	snap   HistogramSnapshot
+}
+
+// points 返回带标签的值，没有标签时返回只包含 value 的一个值
+func (f family) points() []point {
+	if len(f.samples) == 0 {
+		return []point{{value: f.value}}
+	}
+	return f.samples
+}
+
+// families 返回当前快照的所有指标族
+func (mc *MetricsCollector) families() []family {
+	m := mc.GetSnapshot()
+
+	dial := family{
+		name: "gohookproxy_dial_duration_seconds",
+		help: "Dial latency by stage.",
+		unit: "seconds",
+		typ:  "histogram",
+	}
+	for _, stage := range DialStages {
+		dial.histograms = append(dial.histograms, histogramSeries{
+			labels: [][2]string{{"stage", string(stage)}},
+			snap:   m.StageLatency[stage],
+		})
+	}
+
+	families := []family{
+		{name: "gohookproxy_active_connections", help: "Currently open proxied connections.", typ: "gauge", value: float64(m.ActiveConnections)},
+		{name: "gohookproxy_connections", help: "Proxied connections.", typ: "counter", value: float64(m.TotalConnections)},
+		{name: "gohookproxy_connection_failures", help: "Failed proxied dials.", typ: "counter", value: float64(m.FailedConnections)},
+		{name: "gohookproxy_sent_bytes", help: "Bytes sent through the proxy.", unit: "bytes", typ: "counter", value: float64(m.BytesSent)},
+		{name: "gohookproxy_received_bytes", help: "Bytes received through the proxy.", unit: "bytes", typ: "counter", value: float64(m.BytesReceived)},
+		{name: "gohookproxy_dial_sample_rate", help: "One in this many dials is recorded in the dial duration histogram.", typ: "gauge", value: float64(m.SampleRate)},
+		{name: "gohookproxy_byte_cap_blocked_dials", help: "Dials refused because the destination's daily byte cap was used up.", typ: "counter", value: float64(m.ByteCaps.Blocked)},
+		{name: "gohookproxy_byte_cap_closed_connections", help: "Connections closed after exceeding a byte cap.", typ: "counter", value: float64(m.ByteCaps.Closed)},
+		{name: "gohookproxy_dns_cache_hits", help: "Lookups answered from the prefetch resolver cache.", typ: "counter", value: float64(m.DNSCache.Hits)},
+		{name: "gohookproxy_dns_cache_misses", help: "Lookups that waited for the upstream resolver.", typ: "counter", value: float64(m.DNSCache.Misses)},
+		{name: "gohookproxy_dns_prefetches", help: "Cache entries refreshed in the background before expiry.", typ: "counter", value: float64(m.DNSCache.Prefetches)},
+		{name: "gohookproxy_dns_prefetch_errors", help: "Background refreshes that failed.", typ: "counter", value: float64(m.DNSCache.PrefetchErrors)},
+		{name: "gohookproxy_negative_cache_hits", help: "Dials failed fast because the proxy refused the destination by policy moments ago.", typ: "counter", value: float64(m.NegativeCacheHits)},
+		{name: "gohookproxy_fallback_direct_dials", help: "Dials retried directly after the proxy was unreachable or the handshake failed.", typ: "counter", value: float64(m.FallbackDirect)},
+		{name: "gohookproxy_fallback_direct_failures", help: "Direct retries after a proxy failure that failed as well.", typ: "counter", value: float64(m.FallbackDirectFailed)},
+		{name: "gohookproxy_dns_cache_hosts", help: "Hostnames currently held by the prefetch resolver cache.", typ: "gauge", value: float64(m.DNSCache.Hosts)},
+		dial,
+	}
+
+	if len(m.Decisions) > 0 {
+		decisions := family{
+			name: "gohookproxy_hook_decisions",
+			help: "Hooked dials by routing action.",
+			typ:  "counter",
+		}
+		actions := make([]string, 0, len(m.Decisions))
+		for action := range m.Decisions {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		for _, action := range actions {
+			decisions.samples = append(decisions.samples, point{
+				labels: [][2]string{{"action", action}},
+				value:  float64(m.Decisions[action]),
+			})
+		}
+		families = append(families, decisions)
+	}
+
+	if len(m.ByteCaps.Daily) > 0 {
+		daily := family{
+			name: "gohookproxy_byte_cap_daily_bytes",
+			help: "Bytes used today by destinations with a daily byte cap.",
+			unit: "bytes",
+			typ:  "gauge",
+		}
+		hosts := make([]string, 0, len(m.ByteCaps.Daily))
+		for host := range m.ByteCaps.Daily {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			daily.samples = append(daily.samples, point{
+				labels: [][2]string{{"destination", host}},
+				value:  float64(m.ByteCaps.Daily[host]),
+			})
+		}
+		families = append(families, daily)
+	}
+
+	families = append(families, rateFamilies(m.Rates)...)
+	families = append(families, upstreamFamilies(m.Upstreams)...)
+
+	if len(m.SLO) > 0 {
+		burn := family{
+			name: "gohookproxy_slo_burn_rate",
+			help: "Error budget burn rate of proxied dials per sliding window.",
+			typ:  "gauge",
+		}
+		risk := family{
+			name: "gohookproxy_slo_budget_at_risk",
+			help: "1 when the burn rate exceeds the threshold in every window.",
+			typ:  "gauge",
+		}
+		for _, s := range m.SLO {
+			labels := [][2]string{{"scope", s.Scope}, {"target", s.Target}, {"objective", s.Objective}}
+			burn.samples = append(burn.samples, point{
+				labels: append(labels, [2]string{"window", s.Window.String()}),
+				value:  s.BurnRate,
+			})
+			// 每个窗口的 AtRisk 相同，只输出一次
+			if len(risk.samples) == 0 || formatLabels(risk.samples[len(risk.samples)-1].labels) != formatLabels(labels) {
+				value := 0.0
+				if s.AtRisk {
+					value = 1
+				}
+				risk.samples = append(risk.samples, point{labels: labels, value: value})
+			}
+		}
+		families = append(families, burn, risk)
+	}
+	return families
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DialStage 拨号阶段
+type DialStage string
+
+const (
+	StageTCPConnect     DialStage = "tcp_connect"     // 与代理建立 TCP 连接
+	StageTLSHandshake   DialStage = "tls_handshake"   // 与代理的 TLS 握手
+	StageProxyHandshake DialStage = "proxy_handshake" // 代理协议握手 (CONNECT/SOCKS)
+	StageTargetReady    DialStage = "target_ready"    // 从开始拨号到目标连接可用
+)
+
+// DialStages 所有拨号阶段
+var DialStages = []DialStage{StageTCPConnect, StageTLSHandshake, StageProxyHandshake, StageTargetReady}
+
+// DefaultLatencyBuckets 默认的延迟分桶上界
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// NativeSchema 原生直方图的精度，相邻桶边界之比为 2^(2^-3)，约 9%
+const NativeSchema = 3
+
+// NativeZeroThreshold 原生直方图零桶的上界(秒)，不超过 1ns 的观测值计入零桶
+const NativeZeroThreshold = 1e-9
+
+// Exemplar 与观测值关联的样例，如连接 ID 和 trace ID
+type Exemplar struct {
+	Labels    map[string]string
+	Value     time.Duration
+	Timestamp time.Time
+}
+
+// Histogram 并发安全直方图，同时维护固定分桶和按 NativeSchema 指数分桶的稀疏原生分桶
+type Histogram struct {
+	bounds []time.Duration
+	counts []int64 // 最后一个桶为 +Inf
+	count  int64
+	sum    int64
+
+	exemplars []atomic.Value // 每个固定分桶最近一次的 *Exemplar
+
+	mu        sync.Mutex
+	native    map[int]int64 // 原生分桶索引 -> 计数
+	zeroCount int64
+}
+
+// NewHistogram 使用给定的分桶上界创建直方图
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		bounds:    bounds,
+		counts:    make([]int64, len(bounds)+1),
+		exemplars: make([]atomic.Value, len(bounds)+1),
+		native:    make(map[int]int64),
+	}
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(d time.Duration) {
+	h.observe(d)
+}
+
+// ObserveExemplar 记录一次观测值，并作为所在分桶最近的 exemplar 保存
+func (h *Histogram) ObserveExemplar(d time.Duration, labels map[string]string) {
+	i := h.observe(d)
+	h.exemplars[i].Store(&Exemplar{Labels: labels, Value: d, Timestamp: time.Now()})
+}
+
+// observe 记录观测值，返回所在固定分桶的下标
+func (h *Histogram) observe(d time.Duration) int {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+
+	h.mu.Lock()
+	if v := d.Seconds(); v <= NativeZeroThreshold {
+		h.zeroCount++
+	} else {
+		h.native[nativeIndex(v)]++
+	}
+	h.mu.Unlock()
+	return i
+}
+
+// nativeIndex 返回原生分桶索引，桶 i 覆盖 (2^((i-1)/2^schema), 2^(i/2^schema)]
+func nativeIndex(v float64) int {
+	return int(math.Ceil(math.Log2(v) * (1 << NativeSchema)))
+}
+
+// NativeBucketBound 返回原生分桶 i 的上界(秒)
+func NativeBucketBound(i int) float64 {
+	return math.Exp2(float64(i) / (1 << NativeSchema))
+}
+
+// Snapshot 返回直方图的快照
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snap := HistogramSnapshot{
+		Count:   atomic.LoadInt64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+		Buckets: make([]Bucket, len(h.counts)),
+	}
+	for i := range h.counts {
+		upper := time.Duration(-1)
+		if i < len(h.bounds) {
+			upper = h.bounds[i]
+		}
+		snap.Buckets[i] = Bucket{UpperBound: upper, Count: atomic.LoadInt64(&h.counts[i])}
+		if e, ok := h.exemplars[i].Load().(*Exemplar); ok {
+			snap.Buckets[i].Exemplar = e
+		}
+	}
+
+	h.mu.Lock()
+	snap.ZeroCount = h.zeroCount
+	snap.NativeBuckets = make(map[int]int64, len(h.native))
+	for i, n := range h.native {
+		snap.NativeBuckets[i] = n
+	}
+	h.mu.Unlock()
+	return snap
+}
+
+// Bucket 直方图分桶，UpperBound 为 -1 表示 +Inf
+type Bucket struct {
+	UpperBound time.Duration
+	Count      int64
+	Exemplar   *Exemplar // 落在该桶最近的 exemplar，没有时为 nil
+}
+
+// HistogramSnapshot 直方图快照
+type HistogramSnapshot struct {
+	Count   int64
+	Sum     time.Duration
+	Buckets []Bucket
+
+	// 按 NativeSchema 分桶的原生直方图，键为桶索引，见 NativeBucketBound
+	ZeroCount     int64
+	NativeBuckets map[int]int64
+}
+
+// Mean 返回平均值
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile 返回分位数所在桶的上界，落在 +Inf 桶时返回最大的有限上界
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	target := int64(float64(s.Count) * q)
+	if target < 1 {
+		target = 1
+	}
+
+	var current int64
+	var last time.Duration
+	for _, b := range s.Buckets {
+		current += b.Count
+		if b.UpperBound >= 0 {
+			last = b.UpperBound
+		}
+		if current >= target {
+			return last
+		}
+	}
+	return last
+}
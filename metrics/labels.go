@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxLabelSets 默认最多跟踪的标签组合数，超出的组合计入 OverflowLabelKey
+const DefaultMaxLabelSets = 100
+
+// OverflowLabelKey 超出标签组合上限后使用的汇总键
+const OverflowLabelKey = "_overflow"
+
+// LabelStats 一个标签组合的统计
+type LabelStats struct {
+	Connections   int64
+	Failures      int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// LabelCounter 一个标签组合的计数器
+type LabelCounter struct {
+	connections   int64
+	failures      int64
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// AddConnection 记录一次成功连接
+func (c *LabelCounter) AddConnection() {
+	atomic.AddInt64(&c.connections, 1)
+}
+
+// AddFailure 记录一次失败连接
+func (c *LabelCounter) AddFailure() {
+	atomic.AddInt64(&c.failures, 1)
+}
+
+// AddBytes 记录收发字节数
+func (c *LabelCounter) AddBytes(sent, received int64) {
+	atomic.AddInt64(&c.bytesSent, sent)
+	atomic.AddInt64(&c.bytesReceived, received)
+}
+
+// Stats 返回计数器的当前值
+func (c *LabelCounter) Stats() LabelStats {
+	return LabelStats{
+		Connections:   atomic.LoadInt64(&c.connections),
+		Failures:      atomic.LoadInt64(&c.failures),
+		BytesSent:     atomic.LoadInt64(&c.bytesSent),
+		BytesReceived: atomic.LoadInt64(&c.bytesReceived),
+	}
+}
+
+// labelSets 有上限的标签组合集合
+type labelSets struct {
+	mu       sync.RWMutex
+	max      int
+	counters map[string]*LabelCounter
+}
+
+// LabelKey 返回标签组合的规范键，按键名排序，形如 "k1=v1,k2=v2"
+func LabelKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+// counter 返回标签组合对应的计数器，组合数达到上限后新组合共用溢出计数器
+func (s *labelSets) counter(labels map[string]string) *LabelCounter {
+	key := LabelKey(labels)
+
+	s.mu.RLock()
+	c, ok := s.counters[key]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[key]; ok {
+		return c
+	}
+	if s.max > 0 && len(s.counters) >= s.max {
+		key = OverflowLabelKey
+		if c, ok := s.counters[key]; ok {
+			return c
+		}
+	}
+	c = &LabelCounter{}
+	s.counters[key] = c
+	return c
+}
+
+func (s *labelSets) snapshot() map[string]LabelStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make(map[string]LabelStats, len(s.counters))
+	for key, c := range s.counters {
+		stats[key] = c.Stats()
+	}
+	return stats
+}
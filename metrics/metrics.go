@@ -6,6 +6,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
 )
 
 type Metrics struct {
@@ -18,9 +20,70 @@ type Metrics struct {
 	AverageLatency     time.Duration
 	ErrorDistribution  map[string]int64
 	ProtocolStats      map[string]int64
-	BandwidthUsage     float64
+	BandwidthUsage     float64 // 最近 10 秒收发字节的平均速率(字节/秒)，同 Rates 中 10s 窗口的 BytesSent+BytesReceived
 	P95Latency         time.Duration
 	P99Latency         time.Duration
+
+	// HTTP2 流控
+	HTTP2Streams          int64         // 已结束的 HTTP2 流数量
+	HTTP2WriteBlockedTime time.Duration // 所有流写入等待传输层取走数据的累计时间，包括流控等待、帧调度和写入背压，不只是流控停顿
+	HTTP2WindowSize       uint32        // 当前会话的流窗口大小，0 表示默认值
+
+	// HTTP3 会话
+	HTTP3Sessions int64 // 建立的 QUIC 会话数
+	HTTP3ZeroRTT  int64 // 其中服务端接受 0-RTT 的会话数
+
+	// SOCKS5 UDP 中继
+	UDP UDPStats
+
+	// RateWindows 中各窗口的字节和连接速率
+	Rates []Rates
+
+	// 各拨号阶段的延迟分布，每 SampleRate 次拨号记录 1 次
+	StageLatency map[DialStage]HistogramSnapshot
+	SampleRate   int
+
+	// 按应用标签统计，键为 LabelKey 的结果
+	LabelStats map[string]LabelStats
+
+	// 各代理和目标主机在各窗口内的 SLO 状态，未配置 SLO 时为空
+	SLO []SLOStatus
+
+	// 路由规则流量上限的执行情况
+	ByteCaps ByteCapStats
+
+	// 预取解析器的缓存统计，未使用 PrefetchResolver 时为零值
+	DNSCache DNSCacheStats
+
+	// hook 按路由动作(proxy、direct)统计的拨号数
+	Decisions map[string]int64
+
+	// 代理最近按策略拒绝过目标而直接失败的拨号数
+	NegativeCacheHits int64
+
+	// 代理拨号失败后按 FallbackDirect 改为直连的拨号数，FallbackDirectFailed 是其中直连也失败的数量
+	FallbackDirect       int64
+	FallbackDirectFailed int64
+
+	// 负载均衡各上游代理的统计，键为 Proxies 中的名称，主代理为空字符串；未配置 Balance 时为空
+	Upstreams map[string]UpstreamStats
+}
+
+// DNSCacheStats 预取解析器的缓存统计
+type DNSCacheStats struct {
+	Hits           int64   // 命中未过期缓存的解析数
+	Misses         int64   // 需要等待上游解析的解析数
+	Prefetches     int64   // 过期前在后台完成的刷新数
+	PrefetchErrors int64   // 失败的后台刷新数，失败时继续使用旧结果直到过期
+	Hosts          int     // 当前缓存的主机名数
+	HitRate        float64 // Hits / (Hits + Misses)，没有解析时为 0
+}
+
+// ByteCapStats 流量上限统计
+type ByteCapStats struct {
+	Blocked int64            // 因当天流量用完被拒绝的拨号数
+	Closed  int64            // 因超出上限被关闭的连接数
+	Daily   map[string]int64 // 设置了每日上限的目标主机当天已用的字节数
 }
 
 type MetricsCollector struct {
@@ -37,19 +100,64 @@ type MetricsCollector struct {
 	latencyCount    int64
 	connectionTimes *sync.Map
 	errorCounts     *sync.Map
-	bandwidthStats  atomic.Value
-	lastUpdateTime  atomic.Value
+	rates           rateMeter
+
+	http2Streams      int64
+	http2WriteBlocked int64
+	http2Window       uint32
+
+	http3Sessions int64
+	http3ZeroRTT  int64
+
+	udp UDPCounter
+
+	stages     map[DialStage]*Histogram
+	stageSeen  map[DialStage]*uint64 // 各阶段的观测次数，用于采样
+	sampleRate int64
+	labels     labelSets
+	slo        atomic.Pointer[SLOTracker]
+
+	byteCapBlocked int64
+	byteCapClosed  int64
+	byteCapDaily   atomic.Pointer[func() map[string]int64]
+
+	dnsCache atomic.Pointer[func() DNSCacheStats]
+
+	negativeHits int64
+
+	fallbacks        int64
+	fallbackFailures int64
+
+	decisions sync.Map  // 路由动作 -> *int64
+	upstreams sync.Map  // 上游代理名称 -> *UpstreamCounter
+	started   time.Time // 累计指标的起始时间
 }
 
 func NewMetricsCollector() *MetricsCollector {
 	mc := &MetricsCollector{
 		connectionTimes: &sync.Map{},
 		errorCounts:     &sync.Map{},
+		stages:          make(map[DialStage]*Histogram, len(DialStages)),
+		stageSeen:       make(map[DialStage]*uint64, len(DialStages)),
+		sampleRate:      1,
+		started:         time.Now(),
+		labels: labelSets{
+			max:      DefaultMaxLabelSets,
+			counters: make(map[string]*LabelCounter),
+		},
+	}
+	for _, stage := range DialStages {
+		mc.stages[stage] = NewHistogram(DefaultLatencyBuckets)
+		mc.stageSeen[stage] = new(uint64)
 	}
-	mc.lastUpdateTime.Store(time.Now())
 	return mc
 }
 
+// SetClock 设置计算速率窗口使用的时间来源，可以与记录并发调用；clk 为 nil 时使用 clock.Real
+func (mc *MetricsCollector) SetClock(clk clock.Clock) {
+	mc.rates.setClock(clk)
+}
+
 func (mc *MetricsCollector) RecordConnection(duration time.Duration) {
 	atomic.AddInt64(&mc.totalConns, 1)
 	atomic.AddInt64(&mc.totalDuration, int64(duration))
@@ -57,15 +165,147 @@ func (mc *MetricsCollector) RecordConnection(duration time.Duration) {
 
 func (mc *MetricsCollector) RecordFailure(err error) {
 	atomic.AddInt64(&mc.failedConns, 1)
+	mc.rates.add(0, 0, 0, 1)
 }
 
 func (mc *MetricsCollector) RecordBytes(sent, received int64) {
 	atomic.AddInt64(&mc.bytesSent, sent)
 	atomic.AddInt64(&mc.bytesReceived, received)
+	mc.rates.add(sent, received, 0, 0)
+}
+
+// RecordStage 记录拨号阶段耗时，按采样率跳过
+func (mc *MetricsCollector) RecordStage(stage DialStage, d time.Duration) {
+	if h, ok := mc.stages[stage]; ok && mc.sample(stage) {
+		h.Observe(d)
+	}
+}
+
+// RecordStageExemplar 记录拨号阶段耗时，并附带连接 ID、trace ID 等 exemplar 标签，按采样率跳过
+func (mc *MetricsCollector) RecordStageExemplar(stage DialStage, d time.Duration, labels map[string]string) {
+	if h, ok := mc.stages[stage]; ok && mc.sample(stage) {
+		h.ObserveExemplar(d, labels)
+	}
+}
+
+// SetSampleRate 设置拨号阶段延迟的采样率，每 n 次拨号记录 1 次，n 不大于 1 时全部记录
+// 连接数、失败数、字节数和按标签的计数不采样，始终精确
+func (mc *MetricsCollector) SetSampleRate(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt64(&mc.sampleRate, int64(n))
+}
+
+// SampleRate 返回当前的采样率
+func (mc *MetricsCollector) SampleRate() int {
+	return int(atomic.LoadInt64(&mc.sampleRate))
+}
+
+// sample 判断 stage 的本次观测是否记录
+// 每个阶段单独计数，每次拨号每个阶段最多记录一次，所以各阶段记录的大致是同一批拨号
+func (mc *MetricsCollector) sample(stage DialStage) bool {
+	n := uint64(atomic.LoadInt64(&mc.sampleRate))
+	if n <= 1 {
+		return true
+	}
+	return (atomic.AddUint64(mc.stageSeen[stage], 1)-1)%n == 0
+}
+
+// RecordHTTP2Stream 记录一个结束的 HTTP2 流及其写入阻塞时间
+func (mc *MetricsCollector) RecordHTTP2Stream(writeBlocked time.Duration) {
+	atomic.AddInt64(&mc.http2Streams, 1)
+	atomic.AddInt64(&mc.http2WriteBlocked, int64(writeBlocked))
+}
+
+// SetHTTP2Window 记录当前 HTTP2 会话的流窗口大小
+func (mc *MetricsCollector) SetHTTP2Window(size uint32) {
+	atomic.StoreUint32(&mc.http2Window, size)
+}
+
+// RecordHTTP3Session 记录一个握手完成的 QUIC 会话，zeroRTT 表示服务端接受了 0-RTT
+func (mc *MetricsCollector) RecordHTTP3Session(zeroRTT bool) {
+	atomic.AddInt64(&mc.http3Sessions, 1)
+	if zeroRTT {
+		atomic.AddInt64(&mc.http3ZeroRTT, 1)
+	}
+}
+
+// RecordByteCapBlocked 记录一次因当天流量用完被拒绝的拨号
+func (mc *MetricsCollector) RecordByteCapBlocked() {
+	atomic.AddInt64(&mc.byteCapBlocked, 1)
+}
+
+// RecordByteCapClosed 记录一个因超出流量上限被关闭的连接
+func (mc *MetricsCollector) RecordByteCapClosed() {
+	atomic.AddInt64(&mc.byteCapClosed, 1)
+}
+
+// SetByteCapUsage 设置快照中读取各目标主机当天流量的函数，nil 表示不输出
+func (mc *MetricsCollector) SetByteCapUsage(fn func() map[string]int64) {
+	if fn == nil {
+		mc.byteCapDaily.Store(nil)
+		return
+	}
+	mc.byteCapDaily.Store(&fn)
+}
+
+// SetDNSCacheStats 设置快照中读取预取解析器统计的函数，nil 表示不输出
+func (mc *MetricsCollector) SetDNSCacheStats(fn func() DNSCacheStats) {
+	if fn == nil {
+		mc.dnsCache.Store(nil)
+		return
+	}
+	mc.dnsCache.Store(&fn)
+}
+
+// dnsCacheStats 返回预取解析器统计
+func (mc *MetricsCollector) dnsCacheStats() DNSCacheStats {
+	if fn := mc.dnsCache.Load(); fn != nil {
+		return (*fn)()
+	}
+	return DNSCacheStats{}
+}
+
+// byteCapStats 返回流量上限统计
+func (mc *MetricsCollector) byteCapStats() ByteCapStats {
+	stats := ByteCapStats{
+		Blocked: atomic.LoadInt64(&mc.byteCapBlocked),
+		Closed:  atomic.LoadInt64(&mc.byteCapClosed),
+	}
+	if fn := mc.byteCapDaily.Load(); fn != nil {
+		stats.Daily = (*fn)()
+	}
+	return stats
+}
+
+// UDP 返回 UDP 中继的汇总计数器，nil 收集器返回 nil
+func (mc *MetricsCollector) UDP() *UDPCounter {
+	if mc == nil {
+		return nil
+	}
+	return &mc.udp
+}
+
+// Labeled 返回标签组合对应的计数器
+func (mc *MetricsCollector) Labeled(labels map[string]string) *LabelCounter {
+	return mc.labels.counter(labels)
+}
+
+// SetMaxLabelSets 设置最多跟踪的标签组合数，0 时为 DefaultMaxLabelSets，负数表示不限制
+// 已经跟踪的组合不受影响，新的组合按新的上限计入 OverflowLabelKey
+func (mc *MetricsCollector) SetMaxLabelSets(n int) {
+	if n == 0 {
+		n = DefaultMaxLabelSets
+	}
+	mc.labels.mu.Lock()
+	mc.labels.max = n
+	mc.labels.mu.Unlock()
 }
 
 func (mc *MetricsCollector) IncrementActiveConnections() {
 	atomic.AddInt64(&mc.activeConns, 1)
+	mc.rates.add(0, 0, 1, 0)
 }
 
 func (mc *MetricsCollector) DecrementActiveConnections() {
@@ -74,27 +314,42 @@ func (mc *MetricsCollector) DecrementActiveConnections() {
 
 func (mc *MetricsCollector) GetMetrics() *Metrics {
 	return &Metrics{
-		ActiveConnections:  atomic.LoadInt64(&mc.activeConns),
-		TotalConnections:   atomic.LoadInt64(&mc.totalConns),
-		FailedConnections:  atomic.LoadInt64(&mc.failedConns),
-		ConnectionDuration: time.Duration(atomic.LoadInt64(&mc.totalDuration)),
-		BytesSent:          atomic.LoadInt64(&mc.bytesSent),
-		BytesReceived:      atomic.LoadInt64(&mc.bytesReceived),
+		ActiveConnections:     atomic.LoadInt64(&mc.activeConns),
+		TotalConnections:      atomic.LoadInt64(&mc.totalConns),
+		FailedConnections:     atomic.LoadInt64(&mc.failedConns),
+		ConnectionDuration:    time.Duration(atomic.LoadInt64(&mc.totalDuration)),
+		BytesSent:             atomic.LoadInt64(&mc.bytesSent),
+		BytesReceived:         atomic.LoadInt64(&mc.bytesReceived),
+		HTTP2Streams:          atomic.LoadInt64(&mc.http2Streams),
+		HTTP2WriteBlockedTime: time.Duration(atomic.LoadInt64(&mc.http2WriteBlocked)),
+		HTTP2WindowSize:       atomic.LoadUint32(&mc.http2Window),
+		HTTP3Sessions:         atomic.LoadInt64(&mc.http3Sessions),
+		HTTP3ZeroRTT:          atomic.LoadInt64(&mc.http3ZeroRTT),
+		UDP:                   mc.udp.Stats(),
+		ByteCaps:              mc.byteCapStats(),
+		DNSCache:              mc.dnsCacheStats(),
 	}
 }
 
 func (mc *MetricsCollector) GetSnapshot() *Metrics {
-	if mc.lastUpdateTime.Load() == nil {
-		mc.lastUpdateTime.Store(time.Now())
-	}
-
 	metrics := &Metrics{
-		ActiveConnections:  atomic.LoadInt64(&mc.activeConns),
-		TotalConnections:   atomic.LoadInt64(&mc.totalConns),
-		FailedConnections:  atomic.LoadInt64(&mc.failedConns),
-		ConnectionDuration: time.Duration(atomic.LoadInt64(&mc.totalDuration)),
-		BytesSent:          atomic.LoadInt64(&mc.bytesSent),
-		BytesReceived:      atomic.LoadInt64(&mc.bytesReceived),
+		ActiveConnections:     atomic.LoadInt64(&mc.activeConns),
+		TotalConnections:      atomic.LoadInt64(&mc.totalConns),
+		FailedConnections:     atomic.LoadInt64(&mc.failedConns),
+		ConnectionDuration:    time.Duration(atomic.LoadInt64(&mc.totalDuration)),
+		BytesSent:             atomic.LoadInt64(&mc.bytesSent),
+		BytesReceived:         atomic.LoadInt64(&mc.bytesReceived),
+		HTTP2Streams:          atomic.LoadInt64(&mc.http2Streams),
+		HTTP2WriteBlockedTime: time.Duration(atomic.LoadInt64(&mc.http2WriteBlocked)),
+		HTTP2WindowSize:       atomic.LoadUint32(&mc.http2Window),
+		HTTP3Sessions:         atomic.LoadInt64(&mc.http3Sessions),
+		HTTP3ZeroRTT:          atomic.LoadInt64(&mc.http3ZeroRTT),
+		UDP:                   mc.udp.Stats(),
+		ByteCaps:              mc.byteCapStats(),
+		DNSCache:              mc.dnsCacheStats(),
+		NegativeCacheHits:     atomic.LoadInt64(&mc.negativeHits),
+		FallbackDirect:        atomic.LoadInt64(&mc.fallbacks),
+		FallbackDirectFailed:  atomic.LoadInt64(&mc.fallbackFailures),
 	}
 
 	latencyCount := atomic.LoadInt64(&mc.latencyCount)
@@ -102,13 +357,60 @@ func (mc *MetricsCollector) GetSnapshot() *Metrics {
 		metrics.AverageLatency = time.Duration(atomic.LoadInt64(&mc.latencySum) / latencyCount)
 	}
 
-	metrics.BandwidthUsage = mc.calculateBandwidth()
+	metrics.Rates = mc.rates.rates()
+	for _, r := range metrics.Rates {
+		if r.Window == 10*time.Second {
+			metrics.BandwidthUsage = r.BytesSent + r.BytesReceived
+		}
+	}
 
-	mc.lastUpdateTime.Store(time.Now())
+	metrics.SampleRate = mc.SampleRate()
+	metrics.StageLatency = make(map[DialStage]HistogramSnapshot, len(mc.stages))
+	for stage, h := range mc.stages {
+		metrics.StageLatency[stage] = h.Snapshot()
+	}
+	metrics.LabelStats = mc.labels.snapshot()
+	metrics.Decisions = make(map[string]int64)
+	mc.decisions.Range(func(k, v interface{}) bool {
+		metrics.Decisions[k.(string)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	if t := mc.slo.Load(); t != nil {
+		metrics.SLO = t.Status()
+	}
+	metrics.Upstreams = mc.upstreamStats()
+
+	ready := metrics.StageLatency[StageTargetReady]
+	metrics.P95Latency = ready.Quantile(0.95)
+	metrics.P99Latency = ready.Quantile(0.99)
 
 	return metrics
 }
 
+// RecordNegativeCacheHit 记录一次因缓存的拒绝而直接失败的拨号
+func (mc *MetricsCollector) RecordNegativeCacheHit() {
+	atomic.AddInt64(&mc.negativeHits, 1)
+}
+
+// RecordFallbackDirect 记录一次代理拨号失败后改为直连的拨号，ok 表示直连是否成功
+func (mc *MetricsCollector) RecordFallbackDirect(ok bool) {
+	atomic.AddInt64(&mc.fallbacks, 1)
+	if !ok {
+		atomic.AddInt64(&mc.fallbackFailures, 1)
+	}
+}
+
+// RecordDecision 记录一次 hook 拨号的路由动作
+func (mc *MetricsCollector) RecordDecision(action string) {
+	v, _ := mc.decisions.LoadOrStore(action, new(int64))
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+// SetSLOTracker 设置在快照和 Prometheus 输出中展示的 SLO 跟踪器，nil 表示不展示
+func (mc *MetricsCollector) SetSLOTracker(t *SLOTracker) {
+	mc.slo.Store(t)
+}
+
 func (mc *MetricsCollector) RecordLatency(d time.Duration) {
 	atomic.AddInt64(&mc.totalDuration, int64(d))
 }
@@ -179,22 +481,6 @@ func (mc *MetricsCollector) RecordProtocolUse(protocol string) {
 	}
 }
 
-func (mc *MetricsCollector) calculateBandwidth() float64 {
-	lastTime := mc.lastUpdateTime.Load()
-	if lastTime == nil {
-		return 0
-	}
-
-	now := time.Now()
-	duration := now.Sub(lastTime.(time.Time))
-	if duration == 0 {
-		return 0
-	}
-
-	totalBytes := atomic.LoadInt64(&mc.bytesSent) + atomic.LoadInt64(&mc.bytesReceived)
-	return float64(totalBytes) / duration.Seconds()
-}
-
 func (mc *MetricsCollector) GetActiveConnections() int64 {
 	return atomic.LoadInt64(&mc.activeConns)
 }
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// OTLPContentType OTLP/HTTP 的 protobuf 编码
+	OTLPContentType = "application/x-protobuf"
+	// OTLPScope 导出指标的 InstrumentationScope 名称
+	OTLPScope = "github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// opentelemetry.proto.metrics.v1 的 AggregationTemporality
+const otlpCumulative = 2
+
+// otlpUnits 指标族单位对应的 UCUM 单位
+var otlpUnits = map[string]string{
+	"seconds": "s",
+	"bytes":   "By",
+}
+
+// OTLPExporter 以 OTLP/HTTP(protobuf) 把指标推送到 OpenTelemetry Collector，与 Prometheus 输出同一组指标族
+// counter 导出为累计单调的 Sum，gauge 导出为 Gauge，拨号延迟导出为固定分桶的 Histogram；
+// exemplar 的 trace_id 和 span_id 为十六进制时放入 Exemplar 对应字段，其他标签作为 filtered_attributes
+type OTLPExporter struct {
+	Endpoint string            // 完整的接收地址，如 http://collector:4318/v1/metrics
+	Headers  map[string]string // 附加的请求头，如认证令牌
+	Resource map[string]string // 资源属性，未设置 service.name 时使用进程名
+	Client   *http.Client      // 为 nil 时使用 http.DefaultClient
+}
+
+// Export 实现 Exporter 接口，Collector 返回非 2xx 状态时返回错误
+func (e *OTLPExporter) Export(ctx context.Context, mc *MetricsCollector) error {
+	body := e.encode(mc.families(), mc.started, time.Now())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", OTLPContentType)
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp export to %s: %s", e.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// encode 编码 ExportMetricsServiceRequest，start 为累计指标的起始时间
+func (e *OTLPExporter) encode(families []family, start, now time.Time) []byte {
+	resource := make(map[string]string, len(e.Resource)+1)
+	for k, v := range e.Resource {
+		resource[k] = v
+	}
+	if resource["service.name"] == "" {
+		resource["service.name"] = filepath.Base(os.Args[0])
+	}
+	var res pbBuf
+	for _, l := range sortedLabels(resource) {
+		res.bytes(1, encodeKeyValue(l[0], l[1]))
+	}
+
+	var scope pbBuf
+	scope.string(1, OTLPScope)
+	var sm pbBuf
+	sm.bytes(1, scope)
+	for _, f := range families {
+		sm.bytes(2, encodeOTLPMetric(f, uint64(start.UnixNano()), uint64(now.UnixNano())))
+	}
+
+	var rm pbBuf
+	rm.bytes(1, res)
+	rm.bytes(2, sm)
+	var req pbBuf
+	req.bytes(1, rm)
+	return req
+}
+
+// encodeOTLPMetric 编码一个 Metric
+func encodeOTLPMetric(f family, start, now uint64) []byte {
+	var m pbBuf
+	m.string(1, f.name)
+	m.string(2, f.help)
+	if unit := otlpUnits[f.unit]; unit != "" {
+		m.string(3, unit)
+	}
+
+	switch f.typ {
+	case "counter":
+		var sum pbBuf
+		for _, p := range f.points() {
+			sum.bytes(1, encodeNumberPoint(p, start, now))
+		}
+		sum.uvarint(2, otlpCumulative)
+		sum.uvarint(3, 1) // is_monotonic
+		m.bytes(7, sum)
+	case "gauge":
+		var gauge pbBuf
+		for _, p := range f.points() {
+			gauge.bytes(1, encodeNumberPoint(p, 0, now))
+		}
+		m.bytes(5, gauge)
+	case "histogram":
+		var hist pbBuf
+		for _, h := range f.histograms {
+			hist.bytes(1, encodeHistogramPoint(h, start, now))
+		}
+		hist.uvarint(2, otlpCumulative)
+		m.bytes(9, hist)
+	}
+	return m
+}
+
+// encodeNumberPoint 编码 NumberDataPoint，start 为 0 时不设置起始时间
+func encodeNumberPoint(p point, start, now uint64) []byte {
+	var b pbBuf
+	if start != 0 {
+		b.fixed64(2, start)
+	}
+	b.fixed64(3, now)
+	b.double(4, p.value)
+	for _, l := range p.labels {
+		b.bytes(7, encodeKeyValue(l[0], l[1]))
+	}
+	return b
+}
+
+// encodeHistogramPoint 编码 HistogramDataPoint，OTLP 的 bucket_counts 不累加，最后一个桶为 +Inf
+func encodeHistogramPoint(h histogramSeries, start, now uint64) []byte {
+	var b pbBuf
+	b.fixed64(2, start)
+	b.fixed64(3, now)
+	b.fixed64(4, uint64(h.snap.Count))
+	b.double(5, h.snap.Sum.Seconds())
+
+	var counts, bounds []byte
+	for _, bucket := range h.snap.Buckets {
+		counts = binary.LittleEndian.AppendUint64(counts, uint64(bucket.Count))
+		if bucket.UpperBound >= 0 {
+			bounds = binary.LittleEndian.AppendUint64(bounds, math.Float64bits(bucket.UpperBound.Seconds()))
+		}
+	}
+	b.bytes(6, counts)
+	b.bytes(7, bounds)
+
+	for _, bucket := range h.snap.Buckets {
+		if bucket.Exemplar != nil {
+			b.bytes(8, encodeOTLPExemplar(bucket.Exemplar))
+		}
+	}
+	for _, l := range h.labels {
+		b.bytes(9, encodeKeyValue(l[0], l[1]))
+	}
+	return b
+}
+
+// encodeOTLPExemplar 编码 Exemplar，按 W3C 格式的 trace_id(16 字节)和 span_id(8 字节)关联到链路
+func encodeOTLPExemplar(e *Exemplar) []byte {
+	var b pbBuf
+	b.fixed64(2, uint64(e.Timestamp.UnixNano()))
+	b.double(3, e.Value.Seconds())
+
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := e.Labels[k]
+		if id, err := hex.DecodeString(v); err == nil {
+			if k == "trace_id" && len(id) == 16 {
+				b.bytes(5, id)
+				continue
+			}
+			if k == "span_id" && len(id) == 8 {
+				b.bytes(4, id)
+				continue
+			}
+		}
+		b.bytes(7, encodeKeyValue(k, v))
+	}
+	return b
+}
+
+// encodeKeyValue 编码字符串值的 KeyValue
+func encodeKeyValue(key, value string) []byte {
+	var v pbBuf
+	v.string(1, value)
+	var kv pbBuf
+	kv.string(1, key)
+	kv.bytes(2, v)
+	return kv
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PrometheusProtobufType 原生直方图只能通过 protobuf 格式抓取
+	PrometheusProtobufType = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	// OpenMetricsType 文本格式，支持 exemplar，直方图只包含固定分桶
+	OpenMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// PrometheusHandler 返回 Prometheus 抓取接口
+// 请求接受 protobuf 时输出固定分桶和原生直方图，否则输出 OpenMetrics 文本，两种格式都带 exemplar
+func (mc *MetricsCollector) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept")
+		e := &PrometheusExporter{
+			W:        w,
+			Protobuf: strings.Contains(accept, "application/vnd.google.protobuf") && strings.Contains(accept, "io.prometheus.client.MetricFamily"),
+		}
+		if e.Protobuf {
+			w.Header().Set("Content-Type", PrometheusProtobufType)
+		} else {
+			w.Header().Set("Content-Type", OpenMetricsType)
+		}
+		e.Export(r.Context(), mc)
+	})
+}
+
+// PrometheusExporter 把指标按 Prometheus 格式写入 W，可以写入 node_exporter 的 textfile 目录
+type PrometheusExporter struct {
+	W        io.Writer
+	Protobuf bool // 为 true 时输出带长度前缀的 protobuf，否则输出 OpenMetrics 文本
+}
+
+// Export 实现 Exporter 接口
+func (e *PrometheusExporter) Export(ctx context.Context, mc *MetricsCollector) error {
+	families := mc.families()
+	if e.Protobuf {
+		for _, f := range families {
+			if _, err := e.W.Write(encodeFamily(f)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	bw := bufio.NewWriter(e.W)
+	for _, f := range families {
+		writeOpenMetrics(bw, f)
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+// writeOpenMetrics 按 OpenMetrics 文本格式输出指标族
+func writeOpenMetrics(w *bufio.Writer, f family) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	if f.unit != "" {
+		fmt.Fprintf(w, "# UNIT %s %s\n", f.name, f.unit)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+
+	switch f.typ {
+	case "counter":
+		for _, p := range f.points() {
+			fmt.Fprintf(w, "%s_total%s %s\n", f.name, formatLabels(p.labels), formatFloat(p.value))
+		}
+	case "gauge":
+		for _, p := range f.points() {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(p.labels), formatFloat(p.value))
+		}
+	case "histogram":
+		for _, h := range f.histograms {
+			var cumulative int64
+			for _, b := range h.snap.Buckets {
+				cumulative += b.Count
+				le := math.Inf(1)
+				if b.UpperBound >= 0 {
+					le = b.UpperBound.Seconds()
+				}
+				labels := append(append([][2]string(nil), h.labels...), [2]string{"le", formatFloat(le)})
+				fmt.Fprintf(w, "%s_bucket%s %d", f.name, formatLabels(labels), cumulative)
+				if e := b.Exemplar; e != nil {
+					fmt.Fprintf(w, " # %s %s %s", formatLabels(sortedLabels(e.Labels)), formatFloat(e.Value.Seconds()),
+						formatFloat(float64(e.Timestamp.UnixNano())/1e9))
+				}
+				w.WriteByte('\n')
+			}
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(h.labels), h.snap.Count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(h.labels), formatFloat(h.snap.Sum.Seconds()))
+		}
+	}
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[0])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l[1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedLabels 按名称排序标签，保证输出稳定
+func sortedLabels(m map[string]string) [][2]string {
+	labels := make([][2]string, 0, len(m))
+	for k, v := range m {
+		labels = append(labels, [2]string{k, v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+	return labels
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// io.prometheus.client 的 MetricType
+const (
+	pbCounter   = 0
+	pbGauge     = 1
+	pbHistogram = 4
+)
+
+// pbBuf 最小的 protobuf 编码器，只包含 Prometheus 和 OTLP 指标用到的字段类型
+type pbBuf []byte
+
+func (b *pbBuf) tag(field, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire))
+}
+
+func (b *pbBuf) uvarint(field int, v uint64) {
+	b.tag(field, 0)
+	*b = binary.AppendUvarint(*b, v)
+}
+
+// sint 使用 zigzag 编码的 sint32/sint64
+func (b *pbBuf) sint(field int, v int64) {
+	b.uvarint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (b *pbBuf) double(field int, v float64) {
+	b.tag(field, 1)
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *pbBuf) fixed64(field int, v uint64) {
+	b.tag(field, 1)
+	*b = binary.LittleEndian.AppendUint64(*b, v)
+}
+
+func (b *pbBuf) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *pbBuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+// encodeFamily 编码一个带长度前缀的 MetricFamily
+func encodeFamily(f family) []byte {
+	var fam pbBuf
+	switch f.typ {
+	case "counter":
+		fam.string(1, f.name+"_total")
+		fam.string(2, f.help)
+		fam.uvarint(3, pbCounter)
+		for _, p := range f.points() {
+			var value pbBuf
+			value.double(1, p.value)
+			fam.bytes(4, encodeMetric(p.labels, 3, value))
+		}
+	case "gauge":
+		fam.string(1, f.name)
+		fam.string(2, f.help)
+		fam.uvarint(3, pbGauge)
+		for _, p := range f.points() {
+			var value pbBuf
+			value.double(1, p.value)
+			fam.bytes(4, encodeMetric(p.labels, 2, value))
+		}
+	case "histogram":
+		fam.string(1, f.name)
+		fam.string(2, f.help)
+		fam.uvarint(3, pbHistogram)
+		for _, h := range f.histograms {
+			fam.bytes(4, encodeMetric(h.labels, 7, encodeHistogram(h.snap)))
+		}
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(fam)))
+	return append(out, fam...)
+}
+
+// encodeMetric 编码一个 Metric，value 是 field 对应的 Counter、Gauge 或 Histogram
+func encodeMetric(labels [][2]string, field int, value []byte) []byte {
+	var metric pbBuf
+	for _, l := range labels {
+		metric.bytes(1, encodeLabel(l[0], l[1]))
+	}
+	metric.bytes(field, value)
+	return metric
+}
+
+func encodeLabel(name, value string) []byte {
+	var b pbBuf
+	b.string(1, name)
+	b.string(2, value)
+	return b
+}
+
+func encodeExemplar(e *Exemplar) []byte {
+	var b pbBuf
+	for _, l := range sortedLabels(e.Labels) {
+		b.bytes(1, encodeLabel(l[0], l[1]))
+	}
+	b.double(2, e.Value.Seconds())
+	var ts pbBuf
+	ts.uvarint(1, uint64(e.Timestamp.Unix()))
+	ts.uvarint(2, uint64(e.Timestamp.Nanosecond()))
+	b.bytes(3, ts)
+	return b
+}
+
+// encodeHistogram 编码 Histogram，同时包含固定分桶和原生分桶
+func encodeHistogram(s HistogramSnapshot) []byte {
+	var b pbBuf
+	b.uvarint(1, uint64(s.Count))
+	b.double(2, s.Sum.Seconds())
+
+	// 固定分桶，+Inf 桶由 sample_count 表示
+	var cumulative int64
+	for _, bucket := range s.Buckets {
+		cumulative += bucket.Count
+		if bucket.UpperBound < 0 {
+			continue
+		}
+		var pb pbBuf
+		pb.uvarint(1, uint64(cumulative))
+		pb.double(2, bucket.UpperBound.Seconds())
+		if bucket.Exemplar != nil {
+			pb.bytes(3, encodeExemplar(bucket.Exemplar))
+		}
+		b.bytes(3, pb)
+	}
+
+	// 原生分桶: 连续的桶合并为 span，计数按与前一个桶的差值编码
+	b.sint(5, NativeSchema)
+	b.double(6, NativeZeroThreshold)
+	b.uvarint(7, uint64(s.ZeroCount))
+
+	keys := make([]int, 0, len(s.NativeBuckets))
+	for k := range s.NativeBuckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	type span struct{ offset, length int }
+	var spans []span
+	var deltas []int64
+	var prevKey int
+	var prevCount int64
+	for i, k := range keys {
+		switch {
+		case i == 0:
+			spans = append(spans, span{offset: k, length: 1})
+		case k == prevKey+1:
+			spans[len(spans)-1].length++
+		default:
+			spans = append(spans, span{offset: k - prevKey - 1, length: 1})
+		}
+		count := s.NativeBuckets[k]
+		deltas = append(deltas, count-prevCount)
+		prevKey, prevCount = k, count
+	}
+	for _, sp := range spans {
+		var pb pbBuf
+		pb.sint(1, int64(sp.offset))
+		pb.uvarint(2, uint64(sp.length))
+		b.bytes(12, pb)
+	}
+	for _, d := range deltas {
+		b.sint(13, d)
+	}
+
+	for _, bucket := range s.Buckets {
+		if bucket.Exemplar != nil {
+			b.bytes(16, encodeExemplar(bucket.Exemplar))
+		}
+	}
+	return b
+}
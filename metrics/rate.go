@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+)
+
+// RateWindows 速率统计的滑动窗口
+var RateWindows = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// rateBuckets 按秒计数的桶数，覆盖最长的窗口和正在计数的一秒
+const rateBuckets = 61
+
+// Rates 一个窗口内的平均速率，单位为每秒
+// 窗口由最近几个完整的秒组成，不含正在计数的一秒，快照间隔不影响结果
+type Rates struct {
+	Window        time.Duration
+	BytesSent     float64 // 经过代理发送的字节数
+	BytesReceived float64 // 经过代理接收的字节数
+	Connections   float64 // 新建立的连接数
+	Failures      float64 // 失败的拨号数
+}
+
+const (
+	// rateEpochBase 加到 Unix 秒上得到桶的 epoch，使零值的桶早于任何时间(包括 time.Time{})
+	rateEpochBase = 1 << 62
+	// rateResetting 桶正在被清零时 epoch 的值
+	rateResetting = -1
+)
+
+// rateBucket 一秒内的计数，epoch 为计数所属的 Unix 秒加 rateEpochBase
+type rateBucket struct {
+	epoch    atomic.Int64
+	sent     atomic.Int64
+	received atomic.Int64
+	conns    atomic.Int64
+	failures atomic.Int64
+}
+
+// rateMeter 按秒计数的环形缓冲，计算 RateWindows 中各窗口的速率
+// 记录不加锁：每个桶的计数都是原子的，进入新的一秒时由一个调用方清零后再发布新的秒
+type rateMeter struct {
+	clock   atomic.Pointer[clock.Clock] // 为 nil 时使用 clock.Real
+	buckets [rateBuckets]rateBucket
+}
+
+// setClock 设置计算速率使用的时间来源
+func (m *rateMeter) setClock(clk clock.Clock) {
+	m.clock.Store(&clk)
+}
+
+// now 返回当前的 Unix 秒
+func (m *rateMeter) now() int64 {
+	if clk := m.clock.Load(); clk != nil {
+		return clock.OrReal(*clk).Now().Unix()
+	}
+	return clock.Real.Now().Unix()
+}
+
+// slot 返回 sec 所在的桶
+func (m *rateMeter) slot(sec int64) *rateBucket {
+	return &m.buckets[(sec%rateBuckets+rateBuckets)%rateBuckets]
+}
+
+// bucket 返回属于 sec 的桶，桶属于其他秒时先清零
+func (m *rateMeter) bucket(sec int64) *rateBucket {
+	b, epoch := m.slot(sec), sec+rateEpochBase
+	for {
+		cur := b.epoch.Load()
+		switch {
+		case cur == epoch:
+			return b
+		case cur == rateResetting:
+			// 另一个调用方正在清零，很快就会发布新的秒
+			runtime.Gosched()
+		case b.epoch.CompareAndSwap(cur, rateResetting):
+			b.sent.Store(0)
+			b.received.Store(0)
+			b.conns.Store(0)
+			b.failures.Store(0)
+			b.epoch.Store(epoch)
+			return b
+		}
+	}
+}
+
+// add 把计数加到当前这一秒
+func (m *rateMeter) add(sent, received, conns, failures int64) {
+	b := m.bucket(m.now())
+	if sent != 0 {
+		b.sent.Add(sent)
+	}
+	if received != 0 {
+		b.received.Add(received)
+	}
+	if conns != 0 {
+		b.conns.Add(conns)
+	}
+	if failures != 0 {
+		b.failures.Add(failures)
+	}
+}
+
+// rates 返回各窗口的速率
+func (m *rateMeter) rates() []Rates {
+	now := m.now()
+	rates := make([]Rates, len(RateWindows))
+	for i, window := range RateWindows {
+		seconds := int64(window / time.Second)
+		r := Rates{Window: window}
+		for sec := now - seconds; sec < now; sec++ {
+			b, epoch := m.slot(sec), sec+rateEpochBase
+			if b.epoch.Load() != epoch {
+				continue
+			}
+			sent, received := b.sent.Load(), b.received.Load()
+			conns, failures := b.conns.Load(), b.failures.Load()
+			// 读取期间桶被清零给了新的一秒时丢弃读到的值
+			if b.epoch.Load() != epoch {
+				continue
+			}
+			r.BytesSent += float64(sent)
+			r.BytesReceived += float64(received)
+			r.Connections += float64(conns)
+			r.Failures += float64(failures)
+		}
+		r.BytesSent /= float64(seconds)
+		r.BytesReceived /= float64(seconds)
+		r.Connections /= float64(seconds)
+		r.Failures /= float64(seconds)
+		rates[i] = r
+	}
+	return rates
+}
+
+// rateFamilies 返回各窗口速率的指标族
+func rateFamilies(rates []Rates) []family {
+	sent := family{name: "gohookproxy_sent_bytes_per_second", help: "Bytes sent through the proxy per second, averaged over the window.", typ: "gauge"}
+	received := family{name: "gohookproxy_received_bytes_per_second", help: "Bytes received through the proxy per second, averaged over the window.", typ: "gauge"}
+	conns := family{name: "gohookproxy_connections_per_second", help: "Proxied connections established per second, averaged over the window.", typ: "gauge"}
+	failures := family{name: "gohookproxy_connection_failures_per_second", help: "Failed proxied dials per second, averaged over the window.", typ: "gauge"}
+	for _, r := range rates {
+		labels := [][2]string{{"window", r.Window.String()}}
+		sent.samples = append(sent.samples, point{labels: labels, value: r.BytesSent})
+		received.samples = append(received.samples, point{labels: labels, value: r.BytesReceived})
+		conns.samples = append(conns.samples, point{labels: labels, value: r.Connections})
+		failures.samples = append(failures.samples, point{labels: labels, value: r.Failures})
+	}
+	return []family{sent, received, conns, failures}
+}
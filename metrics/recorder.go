@@ -0,0 +1,107 @@
+package metrics
+
+import "time"
+
+// Recorder 拨号器和 ProxyManager 上报连接指标的接口
+// MetricsCollector 是默认实现；实现它可以把指标送到 statsd、OpenTelemetry 等自己的系统。
+// 方法在拨号路径上被并发调用，应快速返回
+type Recorder interface {
+	// RecordDial 记录一次拨号: 开始时 d 为 0，连接建立后以总耗时再调用一次
+	RecordDial(d time.Duration)
+	// RecordFailure 记录一次失败的拨号
+	RecordFailure(err error)
+	// RecordBytes 记录连接收发的字节数
+	RecordBytes(sent, received int64)
+	// RecordStage 记录拨号阶段耗时
+	RecordStage(stage DialStage, d time.Duration)
+
+	// IncrementActiveConnections 和 DecrementActiveConnections 维护活动连接数的 gauge
+	IncrementActiveConnections()
+	DecrementActiveConnections()
+}
+
+// ExemplarRecorder Recorder 可选实现的接口，记录附带连接 ID、trace ID 等 exemplar 标签的阶段耗时
+// 未实现时拨号器改用 RecordStage
+type ExemplarRecorder interface {
+	RecordStageExemplar(stage DialStage, d time.Duration, labels map[string]string)
+}
+
+var (
+	_ Recorder         = (*MetricsCollector)(nil)
+	_ ExemplarRecorder = (*MetricsCollector)(nil)
+)
+
+// RecordDial 记录一次拨号，同 RecordConnection
+func (mc *MetricsCollector) RecordDial(d time.Duration) {
+	mc.RecordConnection(d)
+}
+
+// MultiRecorder 返回把每次记录依次交给 recorders 的 Recorder，nil 会被跳过
+// 用于在保留 MetricsCollector 的同时上报到其他系统，如 MultiRecorder(pm.Metrics, statsd)
+func MultiRecorder(recorders ...Recorder) Recorder {
+	var m multiRecorder
+	for _, r := range recorders {
+		if isNilRecorder(r) {
+			continue
+		}
+		m = append(m, r)
+	}
+	return m
+}
+
+// isNilRecorder 判断 r 是 nil 或 nil 的 *MetricsCollector(如未启用指标时的 pm.Metrics)
+func isNilRecorder(r Recorder) bool {
+	if r == nil {
+		return true
+	}
+	mc, ok := r.(*MetricsCollector)
+	return ok && mc == nil
+}
+
+type multiRecorder []Recorder
+
+func (m multiRecorder) RecordDial(d time.Duration) {
+	for _, r := range m {
+		r.RecordDial(d)
+	}
+}
+
+func (m multiRecorder) RecordFailure(err error) {
+	for _, r := range m {
+		r.RecordFailure(err)
+	}
+}
+
+func (m multiRecorder) RecordBytes(sent, received int64) {
+	for _, r := range m {
+		r.RecordBytes(sent, received)
+	}
+}
+
+func (m multiRecorder) RecordStage(stage DialStage, d time.Duration) {
+	for _, r := range m {
+		r.RecordStage(stage, d)
+	}
+}
+
+func (m multiRecorder) RecordStageExemplar(stage DialStage, d time.Duration, labels map[string]string) {
+	for _, r := range m {
+		if er, ok := r.(ExemplarRecorder); ok {
+			er.RecordStageExemplar(stage, d, labels)
+		} else {
+			r.RecordStage(stage, d)
+		}
+	}
+}
+
+func (m multiRecorder) IncrementActiveConnections() {
+	for _, r := range m {
+		r.IncrementActiveConnections()
+	}
+}
+
+func (m multiRecorder) DecrementActiveConnections() {
+	for _, r := range m {
+		r.DecrementActiveConnections()
+	}
+}
@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+)
+
+// SLO 的跟踪范围和目标类型
+const (
+	SLOScopeProxy       = "proxy"       // 按代理地址
+	SLOScopeDestination = "destination" // 按目标主机
+
+	SLOSuccess = "success" // 拨号成功率
+	SLOLatency = "latency" // 拨号延迟不超过阈值的比例
+)
+
+// sloBucketsPerWindow 最短窗口划分的桶数，决定滑动窗口的精度
+const sloBucketsPerWindow = 10
+
+// SLOObjective SLO 目标
+type SLOObjective struct {
+	SuccessTarget     float64       // 成功率目标，0 表示不跟踪
+	LatencyTarget     time.Duration // 延迟阈值，0 表示不跟踪延迟
+	LatencyObjective  float64       // 延迟不超过阈值的比例目标
+	Windows           []time.Duration
+	BurnRateThreshold float64 // 所有窗口的消耗速率都达到该值时认为预算有风险
+	MinSamples        int     // 窗口内样本少于该值时消耗速率记为 0
+	PerDestination    bool
+	MaxDestinations   int // 超出的目标计入 OverflowLabelKey，0 表示不限制
+}
+
+// SLOStatus 一个跟踪对象在一个窗口内的 SLO 状态
+type SLOStatus struct {
+	Scope     string // SLOScopeProxy 或 SLOScopeDestination
+	Target    string // 代理地址或目标主机
+	Objective string // SLOSuccess 或 SLOLatency
+	Window    time.Duration
+	Total     int64   // 窗口内的拨号数
+	Bad       int64   // 窗口内失败或超过延迟阈值的拨号数
+	BurnRate  float64 // 错误预算的消耗速率，1 表示恰好在窗口内用完预算
+	AtRisk    bool    // 所有窗口的消耗速率都达到阈值
+}
+
+// SLOEvent 错误预算有风险时的事件，每个跟踪对象和目标类型进入风险状态时通知一次，恢复后重新计数
+type SLOEvent struct {
+	Scope     string
+	Target    string
+	Objective string
+	BurnRates map[time.Duration]float64 // 各窗口的消耗速率
+}
+
+// sloBucket 一个时间片内的计数
+type sloBucket struct {
+	start time.Time
+	total int64
+	fails int64
+	slow  int64
+}
+
+// sloSeries 一个跟踪对象的滑动窗口计数
+type sloSeries struct {
+	buckets []sloBucket // 环形缓冲，覆盖最长的窗口
+	atRisk  map[string]bool
+}
+
+// SLOTracker 在滑动窗口内统计代理拨号的成功率和延迟，计算错误预算的消耗速率
+type SLOTracker struct {
+	objective SLOObjective
+	clock     clock.Clock
+	width     time.Duration // 每个桶的时长
+
+	mu       sync.Mutex
+	series   map[[2]string]*sloSeries // 键为 {scope, target}
+	dests    int
+	onAtRisk func(SLOEvent)
+}
+
+// NewSLOTracker 创建 SLO 跟踪器，clk 为 nil 时使用 clock.Real
+func NewSLOTracker(objective SLOObjective, clk clock.Clock) *SLOTracker {
+	objective.Windows = append([]time.Duration(nil), objective.Windows...)
+	sort.Slice(objective.Windows, func(i, j int) bool { return objective.Windows[i] < objective.Windows[j] })
+
+	width := time.Second
+	if len(objective.Windows) > 0 {
+		if w := objective.Windows[0] / sloBucketsPerWindow; w > width {
+			width = w
+		}
+	}
+	return &SLOTracker{
+		objective: objective,
+		clock:     clock.OrReal(clk),
+		width:     width,
+		series:    make(map[[2]string]*sloSeries),
+	}
+}
+
+// OnAtRisk 设置错误预算有风险时的回调，回调在记录拨号的 goroutine 中同步执行
+func (t *SLOTracker) OnAtRisk(fn func(SLOEvent)) {
+	t.mu.Lock()
+	t.onAtRisk = fn
+	t.mu.Unlock()
+}
+
+// Record 记录一次代理拨号，proxy 为代理地址，dest 为目标主机
+func (t *SLOTracker) Record(proxy, dest string, latency time.Duration, failed bool) {
+	now := t.clock.Now()
+	slow := !failed && t.objective.LatencyTarget > 0 && latency > t.objective.LatencyTarget
+
+	var events []SLOEvent
+	t.mu.Lock()
+	events = append(events, t.recordLocked(SLOScopeProxy, proxy, now, failed, slow)...)
+	if t.objective.PerDestination {
+		events = append(events, t.recordLocked(SLOScopeDestination, t.destination(dest), now, failed, slow)...)
+	}
+	fn := t.onAtRisk
+	t.mu.Unlock()
+
+	if fn != nil {
+		for _, e := range events {
+			fn(e)
+		}
+	}
+}
+
+// destination 返回目标主机的跟踪键，达到上限后新目标汇总到 OverflowLabelKey，调用方持有 t.mu
+func (t *SLOTracker) destination(dest string) string {
+	if _, ok := t.series[[2]string{SLOScopeDestination, dest}]; ok {
+		return dest
+	}
+	if t.objective.MaxDestinations > 0 && t.dests >= t.objective.MaxDestinations {
+		return OverflowLabelKey
+	}
+	t.dests++
+	return dest
+}
+
+// recordLocked 计入一次拨号，返回新进入风险状态的事件，调用方持有 t.mu
+func (t *SLOTracker) recordLocked(scope, target string, now time.Time, failed, slow bool) []SLOEvent {
+	key := [2]string{scope, target}
+	s, ok := t.series[key]
+	if !ok {
+		s = &sloSeries{buckets: make([]sloBucket, t.bucketCount()), atRisk: make(map[string]bool)}
+		t.series[key] = s
+	}
+
+	start := now.Truncate(t.width)
+	b := &s.buckets[int(start.UnixNano()/int64(t.width))%len(s.buckets)]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start}
+	}
+	b.total++
+	if failed {
+		b.fails++
+	}
+	if slow {
+		b.slow++
+	}
+
+	var events []SLOEvent
+	for _, objective := range t.objectives() {
+		rates, risk := t.burnRates(s, objective, now)
+		switch {
+		case risk && !s.atRisk[objective]:
+			s.atRisk[objective] = true
+			events = append(events, SLOEvent{Scope: scope, Target: target, Objective: objective, BurnRates: rates})
+		case !risk:
+			s.atRisk[objective] = false
+		}
+	}
+	return events
+}
+
+// bucketCount 覆盖最长窗口需要的桶数
+func (t *SLOTracker) bucketCount() int {
+	longest := t.width
+	if n := len(t.objective.Windows); n > 0 {
+		longest = t.objective.Windows[n-1]
+	}
+	return int((longest+t.width-1)/t.width) + 1
+}
+
+// objectives 返回配置的目标类型
+func (t *SLOTracker) objectives() []string {
+	var objectives []string
+	if t.objective.SuccessTarget > 0 {
+		objectives = append(objectives, SLOSuccess)
+	}
+	if t.objective.LatencyTarget > 0 {
+		objectives = append(objectives, SLOLatency)
+	}
+	return objectives
+}
+
+// window 统计 s 在 now 之前 window 时长内的拨号数和不达标的拨号数
+func (t *SLOTracker) window(s *sloSeries, objective string, now time.Time, window time.Duration) (total, bad int64) {
+	since := now.Add(-window)
+	for _, b := range s.buckets {
+		// 桶的结束时间在窗口内才计入
+		if b.total == 0 || !b.start.Add(t.width).After(since) || b.start.After(now) {
+			continue
+		}
+		total += b.total
+		if objective == SLOSuccess {
+			bad += b.fails
+		} else {
+			bad += b.slow
+		}
+	}
+	return total, bad
+}
+
+// burnRate 错误率与预算的比值
+func (t *SLOTracker) burnRate(objective string, total, bad int64) float64 {
+	if total == 0 || int(total) < t.objective.MinSamples {
+		return 0
+	}
+	target := t.objective.SuccessTarget
+	if objective == SLOLatency {
+		target = t.objective.LatencyObjective
+	}
+	return float64(bad) / float64(total) / (1 - target)
+}
+
+// burnRates 返回各窗口的消耗速率，以及是否所有窗口都达到阈值
+func (t *SLOTracker) burnRates(s *sloSeries, objective string, now time.Time) (map[time.Duration]float64, bool) {
+	rates := make(map[time.Duration]float64, len(t.objective.Windows))
+	risk := len(t.objective.Windows) > 0
+	for _, w := range t.objective.Windows {
+		total, bad := t.window(s, objective, now, w)
+		rate := t.burnRate(objective, total, bad)
+		rates[w] = rate
+		if rate < t.objective.BurnRateThreshold {
+			risk = false
+		}
+	}
+	return rates, risk
+}
+
+// Status 返回所有跟踪对象在各窗口内的状态，按范围、对象、目标类型和窗口排序
+func (t *SLOTracker) Status() []SLOStatus {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var status []SLOStatus
+	for key, s := range t.series {
+		for _, objective := range t.objectives() {
+			_, risk := t.burnRates(s, objective, now)
+			for _, w := range t.objective.Windows {
+				total, bad := t.window(s, objective, now, w)
+				status = append(status, SLOStatus{
+					Scope:     key[0],
+					Target:    key[1],
+					Objective: objective,
+					Window:    w,
+					Total:     total,
+					Bad:       bad,
+					BurnRate:  t.burnRate(objective, total, bad),
+					AtRisk:    risk,
+				})
+			}
+		}
+	}
+	sort.Slice(status, func(i, j int) bool {
+		a, b := status[i], status[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Objective != b.Objective {
+			return a.Objective < b.Objective
+		}
+		return a.Window < b.Window
+	})
+	return status
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import "sync/atomic"
+
+// UDPStats SOCKS5 UDP 中继统计
+type UDPStats struct {
+	Associations    int64 // 建立的 UDP 关联数
+	PacketsSent     int64 // 发往中继的数据报数，不含保活数据报
+	PacketsReceived int64 // 从中继收到并交给调用方的数据报数
+	OversizedDrops  int64 // 超过调用方缓冲区而被截断或丢弃的数据报数
+	QueueDrops      int64 // 接收队列已满而被丢弃的数据报数
+	HeaderErrors    int64 // SOCKS5 UDP 头无法解析的数据报数
+	RelayResets     int64 // 关联使用中控制连接被代理关闭的次数
+}
+
+// UDPCounter UDP 中继计数器，计数会同时累加到父计数器
+// 所有方法对 nil 计数器都是空操作
+type UDPCounter struct {
+	parent *UDPCounter
+
+	associations    int64
+	packetsSent     int64
+	packetsReceived int64
+	oversizedDrops  int64
+	queueDrops      int64
+	headerErrors    int64
+	relayResets     int64
+}
+
+// Child 返回一个单独的关联计数器，计数同时累加到 c
+func (c *UDPCounter) Child() *UDPCounter {
+	return &UDPCounter{parent: c}
+}
+
+func (c *UDPCounter) add(field func(*UDPCounter) *int64) {
+	for ; c != nil; c = c.parent {
+		atomic.AddInt64(field(c), 1)
+	}
+}
+
+// AddAssociation 记录一个建立的关联
+func (c *UDPCounter) AddAssociation() {
+	c.add(func(c *UDPCounter) *int64 { return &c.associations })
+}
+
+// AddPacketSent 记录一个发往中继的数据报
+func (c *UDPCounter) AddPacketSent() {
+	c.add(func(c *UDPCounter) *int64 { return &c.packetsSent })
+}
+
+// AddPacketReceived 记录一个从中继收到的数据报
+func (c *UDPCounter) AddPacketReceived() {
+	c.add(func(c *UDPCounter) *int64 { return &c.packetsReceived })
+}
+
+// AddOversizedDrop 记录一个超过缓冲区的数据报
+func (c *UDPCounter) AddOversizedDrop() {
+	c.add(func(c *UDPCounter) *int64 { return &c.oversizedDrops })
+}
+
+// AddQueueDrop 记录一个因接收队列已满而丢弃的数据报
+func (c *UDPCounter) AddQueueDrop() {
+	c.add(func(c *UDPCounter) *int64 { return &c.queueDrops })
+}
+
+// AddHeaderError 记录一个 UDP 头无法解析的数据报
+func (c *UDPCounter) AddHeaderError() {
+	c.add(func(c *UDPCounter) *int64 { return &c.headerErrors })
+}
+
+// AddRelayReset 记录一次中继被代理关闭
+func (c *UDPCounter) AddRelayReset() {
+	c.add(func(c *UDPCounter) *int64 { return &c.relayResets })
+}
+
+// Stats 返回计数器的当前值
+func (c *UDPCounter) Stats() UDPStats {
+	if c == nil {
+		return UDPStats{}
+	}
+	return UDPStats{
+		Associations:    atomic.LoadInt64(&c.associations),
+		PacketsSent:     atomic.LoadInt64(&c.packetsSent),
+		PacketsReceived: atomic.LoadInt64(&c.packetsReceived),
+		OversizedDrops:  atomic.LoadInt64(&c.oversizedDrops),
+		QueueDrops:      atomic.LoadInt64(&c.queueDrops),
+		HeaderErrors:    atomic.LoadInt64(&c.headerErrors),
+		RelayResets:     atomic.LoadInt64(&c.relayResets),
+	}
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// upstreamLatencyWeight 新的拨号耗时在 EWMA 中的权重
+const upstreamLatencyWeight = 0.2
+
+// UpstreamStats 负载均衡中一个上游代理的统计
+type UpstreamStats struct {
+	Active   int64         // 当前打开的连接数
+	Dials    int64         // 分配到该代理的拨号数
+	Failures int64         // 其中失败的拨号数
+	Latency  time.Duration // 成功拨号耗时(含到代理的连接和握手)的 EWMA，没有成功的拨号时为 0
+}
+
+// UpstreamCounter 上游代理的计数器，负载均衡按 Active 和 Latency 选择代理
+// 所有方法对 nil 计数器都是空操作
+type UpstreamCounter struct {
+	active   int64
+	dials    int64
+	failures int64
+	latency  int64 // 纳秒
+}
+
+// AddDial 记录一次分配到该代理的拨号
+func (c *UpstreamCounter) AddDial() {
+	if c != nil {
+		atomic.AddInt64(&c.dials, 1)
+	}
+}
+
+// AddFailure 记录一次失败的拨号
+func (c *UpstreamCounter) AddFailure() {
+	if c != nil {
+		atomic.AddInt64(&c.failures, 1)
+	}
+}
+
+// Acquire 增加活动连接数，连接关闭时调用 Release
+func (c *UpstreamCounter) Acquire() {
+	if c != nil {
+		atomic.AddInt64(&c.active, 1)
+	}
+}
+
+// Release 减少活动连接数
+func (c *UpstreamCounter) Release() {
+	if c != nil {
+		atomic.AddInt64(&c.active, -1)
+	}
+}
+
+// ObserveLatency 把一次成功拨号的耗时计入 EWMA，第一次直接使用该耗时
+func (c *UpstreamCounter) ObserveLatency(d time.Duration) {
+	if c == nil {
+		return
+	}
+	for {
+		old := atomic.LoadInt64(&c.latency)
+		next := int64(d)
+		if old > 0 {
+			next = old + int64(upstreamLatencyWeight*float64(int64(d)-old))
+		}
+		if next <= 0 {
+			next = 1
+		}
+		if atomic.CompareAndSwapInt64(&c.latency, old, next) {
+			return
+		}
+	}
+}
+
+// Latency 返回拨号耗时的 EWMA，没有成功的拨号时为 0
+func (c *UpstreamCounter) Latency() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
+// Active 返回当前打开的连接数
+func (c *UpstreamCounter) Active() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.active)
+}
+
+// Stats 返回计数器的当前值
+func (c *UpstreamCounter) Stats() UpstreamStats {
+	if c == nil {
+		return UpstreamStats{}
+	}
+	return UpstreamStats{
+		Active:   atomic.LoadInt64(&c.active),
+		Dials:    atomic.LoadInt64(&c.dials),
+		Failures: atomic.LoadInt64(&c.failures),
+		Latency:  time.Duration(atomic.LoadInt64(&c.latency)),
+	}
+}
+
+// Upstream 返回名为 name 的上游代理的计数器，同一名称总是返回同一个计数器，nil 收集器返回 nil
+// 主代理的名称为空字符串
+func (mc *MetricsCollector) Upstream(name string) *UpstreamCounter {
+	if mc == nil {
+		return nil
+	}
+	c, _ := mc.upstreams.LoadOrStore(name, &UpstreamCounter{})
+	return c.(*UpstreamCounter)
+}
+
+// upstreamStats 返回各上游代理的统计，没有时返回 nil
+func (mc *MetricsCollector) upstreamStats() map[string]UpstreamStats {
+	var stats map[string]UpstreamStats
+	mc.upstreams.Range(func(k, v interface{}) bool {
+		if stats == nil {
+			stats = make(map[string]UpstreamStats)
+		}
+		stats[k.(string)] = v.(*UpstreamCounter).Stats()
+		return true
+	})
+	return stats
+}
+
+// upstreamFamilies 返回各上游代理的指标族
+func upstreamFamilies(stats map[string]UpstreamStats) []family {
+	if len(stats) == 0 {
+		return nil
+	}
+	active := family{name: "gohookproxy_upstream_active_connections", help: "Currently open connections per balanced upstream proxy.", typ: "gauge"}
+	dials := family{name: "gohookproxy_upstream_dials", help: "Dials assigned to each balanced upstream proxy.", typ: "counter"}
+	failures := family{name: "gohookproxy_upstream_dial_failures", help: "Failed dials per balanced upstream proxy.", typ: "counter"}
+	latency := family{name: "gohookproxy_upstream_dial_latency_seconds", help: "Moving average of successful dial times per balanced upstream proxy.", unit: "seconds", typ: "gauge"}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels := [][2]string{{"proxy", name}}
+		s := stats[name]
+		active.samples = append(active.samples, point{labels: labels, value: float64(s.Active)})
+		dials.samples = append(dials.samples, point{labels: labels, value: float64(s.Dials)})
+		failures.samples = append(failures.samples, point{labels: labels, value: float64(s.Failures)})
+		latency.samples = append(latency.samples, point{labels: labels, value: s.Latency.Seconds()})
+	}
+	return []family{active, dials, failures, latency}
+}
@@ -0,0 +1,222 @@
+package pac
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// builtins PAC 标准定义的辅助函数
+var builtins = map[string]builtin{
+	"isPlainHostName":     isPlainHostName,
+	"dnsDomainIs":         dnsDomainIs,
+	"localHostOrDomainIs": localHostOrDomainIs,
+	"isResolvable":        isResolvable,
+	"isInNet":             isInNet,
+	"dnsResolve":          dnsResolve,
+	"convert_addr":        convertAddr,
+	"myIpAddress":         myIPAddress,
+	"dnsDomainLevels":     dnsDomainLevels,
+	"shExpMatch":          shExpMatch,
+	"weekdayRange":        weekdayRange,
+	"timeRange":           timeRange,
+	"dateRange":           dateRange,
+	"alert":               alert,
+}
+
+// argString 返回第 i 个参数的字符串形式，缺少时为 undefined
+func argString(args []value, i int) string {
+	if i < len(args) {
+		return toString(args[i])
+	}
+	return "undefined"
+}
+
+func isPlainHostName(_ *interp, args []value) (value, error) {
+	return !strings.Contains(argString(args, 0), "."), nil
+}
+
+func dnsDomainIs(_ *interp, args []value) (value, error) {
+	host, domain := strings.ToLower(argString(args, 0)), strings.ToLower(argString(args, 1))
+	return strings.HasSuffix(host, domain), nil
+}
+
+func localHostOrDomainIs(_ *interp, args []value) (value, error) {
+	host, hostdom := strings.ToLower(argString(args, 0)), strings.ToLower(argString(args, 1))
+	if host == hostdom {
+		return true, nil
+	}
+	return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+}
+
+// resolve 解析主机名，优先返回 IPv4 地址，与浏览器的 dnsResolve 一致
+func (in *interp) resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	var ips []net.IP
+	var err error
+	if in.env != nil && in.env.Resolve != nil {
+		ips, err = in.env.Resolve(in.ctx, host)
+	} else {
+		ips, err = net.DefaultResolver.LookupIP(in.ctx, "ip", host)
+	}
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return ips[0]
+}
+
+func isResolvable(in *interp, args []value) (value, error) {
+	return in.resolve(argString(args, 0)) != nil, nil
+}
+
+func dnsResolve(in *interp, args []value) (value, error) {
+	if ip := in.resolve(argString(args, 0)); ip != nil {
+		return ip.String(), nil
+	}
+	return nil, nil
+}
+
+func isInNet(in *interp, args []value) (value, error) {
+	ip := in.resolve(argString(args, 0)).To4()
+	pattern := net.ParseIP(argString(args, 1)).To4()
+	mask := net.ParseIP(argString(args, 2)).To4()
+	if ip == nil || pattern == nil || mask == nil {
+		return false, nil
+	}
+	for i := range ip {
+		if ip[i]&mask[i] != pattern[i]&mask[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func convertAddr(_ *interp, args []value) (value, error) {
+	ip := net.ParseIP(argString(args, 0)).To4()
+	if ip == nil {
+		return math.NaN(), nil
+	}
+	return float64(uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])), nil
+}
+
+func myIPAddress(in *interp, _ []value) (value, error) {
+	if in.env != nil && in.env.MyIP != nil {
+		if ip := in.env.MyIP(); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "127.0.0.1", nil
+}
+
+func dnsDomainLevels(_ *interp, args []value) (value, error) {
+	return float64(strings.Count(argString(args, 0), ".")), nil
+}
+
+func shExpMatch(_ *interp, args []value) (value, error) {
+	return globMatch(argString(args, 1), argString(args, 0)), nil
+}
+
+// globMatch shell 风格的匹配，* 和 ? 也匹配 /，与浏览器的 shExpMatch 一致
+func globMatch(pattern, s string) bool {
+	px, sx := 0, 0
+	star, mark := -1, 0
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case px < len(pattern) && pattern[px] == '*':
+			star, mark = px, sx
+			px++
+		case star >= 0:
+			mark++
+			px, sx = star+1, mark
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
+
+// now 返回当前时间，最后一个参数为 "GMT" 时使用 UTC
+func (in *interp) now(args []value) (time.Time, []value) {
+	now := time.Now()
+	if in.env != nil && in.env.Now != nil {
+		now = in.env.Now()
+	}
+	if n := len(args); n > 0 && toString(args[n-1]) == "GMT" {
+		return now.UTC(), args[:n-1]
+	}
+	return now.Local(), args
+}
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+func weekdayRange(in *interp, args []value) (value, error) {
+	now, args := in.now(args)
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("weekdayRange: expected 1 or 2 weekdays")
+	}
+	first, ok := weekdays[toString(args[0])]
+	if !ok {
+		return nil, fmt.Errorf("weekdayRange: invalid weekday %q", toString(args[0]))
+	}
+	last := first
+	if len(args) == 2 {
+		if last, ok = weekdays[toString(args[1])]; !ok {
+			return nil, fmt.Errorf("weekdayRange: invalid weekday %q", toString(args[1]))
+		}
+	}
+	return inRange(int(now.Weekday()), int(first), int(last)), nil
+}
+
+func timeRange(in *interp, args []value) (value, error) {
+	now, args := in.now(args)
+	nums := make([]int, len(args))
+	for i, a := range args {
+		nums[i] = int(toNumber(a))
+	}
+	cur := now.Hour()*3600 + now.Minute()*60 + now.Second()
+	switch len(nums) {
+	case 1:
+		return now.Hour() == nums[0], nil
+	case 2:
+		return inRange(cur, nums[0]*3600, nums[1]*3600), nil
+	case 4:
+		return inRange(cur, nums[0]*3600+nums[1]*60, nums[2]*3600+nums[3]*60), nil
+	case 6:
+		return inRange(cur, nums[0]*3600+nums[1]*60+nums[2], nums[3]*3600+nums[4]*60+nums[5]), nil
+	}
+	return nil, fmt.Errorf("timeRange: expected 1, 2, 4 or 6 numbers, got %d", len(nums))
+}
+
+// inRange 判断 v 是否在闭区间内，start 大于 end 时跨越边界
+func inRange(v, start, end int) bool {
+	if start <= end {
+		return v >= start && v <= end
+	}
+	return v >= start || v <= end
+}
+
+func dateRange(_ *interp, _ []value) (value, error) {
+	return nil, fmt.Errorf("dateRange is not supported")
+}
+
+func alert(_ *interp, _ []value) (value, error) {
+	return nil, nil
+}
@@ -0,0 +1,463 @@
+package pac
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// value 脚本中的值: string、float64、bool、nil(null 或 undefined)、*funcDecl 或 builtin
+type value interface{}
+
+// builtin 内置函数
+type builtin func(in *interp, args []value) (value, error)
+
+const (
+	// maxDepth 函数调用的最大深度
+	maxDepth = 64
+	// maxSteps 单次执行最多求值的节点数，防止递归的脚本耗尽时间
+	maxSteps = 1 << 20
+)
+
+type scope struct {
+	vars   map[string]value
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]value), parent: parent}
+}
+
+func (s *scope) lookup(name string) (value, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// set 给已声明的变量赋值，未声明的变量成为全局变量
+func (s *scope) set(name string, v value) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok {
+			cur.vars[name] = v
+			return
+		}
+		if cur.parent == nil {
+			cur.vars[name] = v
+		}
+	}
+}
+
+// interp 单次执行的解释器状态
+type interp struct {
+	ctx   context.Context
+	env   *Env
+	depth int
+	steps int
+}
+
+// exec 执行语句，遇到 return 时 returned 为 true
+func (in *interp) exec(s stmt, sc *scope) (returned bool, v value, err error) {
+	if err := in.step(); err != nil {
+		return false, nil, err
+	}
+	switch s := s.(type) {
+	case varStmt:
+		var v value
+		if s.init != nil {
+			if v, err = in.eval(s.init, sc); err != nil {
+				return false, nil, err
+			}
+		}
+		sc.vars[s.name] = v
+	case assignStmt:
+		v, err := in.eval(s.value, sc)
+		if err != nil {
+			return false, nil, err
+		}
+		if s.op == "+=" {
+			old, _ := sc.lookup(s.name)
+			v = add(old, v)
+		}
+		sc.set(s.name, v)
+	case ifStmt:
+		cond, err := in.eval(s.cond, sc)
+		if err != nil {
+			return false, nil, err
+		}
+		if truthy(cond) {
+			return in.exec(s.then, sc)
+		}
+		if s.els != nil {
+			return in.exec(s.els, sc)
+		}
+	case blockStmt:
+		return in.execBody(s.body, sc)
+	case returnStmt:
+		if s.value == nil {
+			return true, nil, nil
+		}
+		v, err := in.eval(s.value, sc)
+		return true, v, err
+	case exprStmt:
+		_, err := in.eval(s.x, sc)
+		return false, nil, err
+	case *funcDecl:
+		// 函数声明在 execBody 中提前绑定
+	default:
+		return false, nil, fmt.Errorf("unsupported statement %T", s)
+	}
+	return false, nil, nil
+}
+
+// execBody 执行语句序列，先绑定其中的函数声明，与 JavaScript 的提升一致
+func (in *interp) execBody(body []stmt, sc *scope) (bool, value, error) {
+	for _, s := range body {
+		if fn, ok := s.(*funcDecl); ok {
+			sc.vars[fn.name] = fn
+		}
+	}
+	for _, s := range body {
+		if returned, v, err := in.exec(s, sc); err != nil || returned {
+			return returned, v, err
+		}
+	}
+	return false, nil, nil
+}
+
+func (in *interp) step() error {
+	in.steps++
+	if in.steps > maxSteps {
+		return fmt.Errorf("script exceeded %d evaluation steps", maxSteps)
+	}
+	return in.ctx.Err()
+}
+
+func (in *interp) eval(e expr, sc *scope) (value, error) {
+	if err := in.step(); err != nil {
+		return nil, err
+	}
+	switch e := e.(type) {
+	case literal:
+		return e.v, nil
+	case ident:
+		v, ok := sc.lookup(e.name)
+		if !ok {
+			return nil, fmt.Errorf("%s is not defined", e.name)
+		}
+		return v, nil
+	case unary:
+		x, err := in.eval(e.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "!":
+			return !truthy(x), nil
+		case "-":
+			return -toNumber(x), nil
+		default:
+			return toNumber(x), nil
+		}
+	case binary:
+		return in.evalBinary(e, sc)
+	case conditional:
+		cond, err := in.eval(e.cond, sc)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return in.eval(e.then, sc)
+		}
+		return in.eval(e.els, sc)
+	case member:
+		x, err := in.eval(e.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := x.(string); ok && e.name == "length" {
+			return float64(len(s)), nil
+		}
+		return nil, fmt.Errorf("unsupported property %s", e.name)
+	case call:
+		return in.evalCall(e, sc)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+func (in *interp) evalBinary(e binary, sc *scope) (value, error) {
+	x, err := in.eval(e.x, sc)
+	if err != nil {
+		return nil, err
+	}
+	// 逻辑运算短路，结果为其中一个操作数
+	switch e.op {
+	case "&&":
+		if !truthy(x) {
+			return x, nil
+		}
+		return in.eval(e.y, sc)
+	case "||":
+		if truthy(x) {
+			return x, nil
+		}
+		return in.eval(e.y, sc)
+	}
+	y, err := in.eval(e.y, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "+":
+		return add(x, y), nil
+	case "-":
+		return toNumber(x) - toNumber(y), nil
+	case "==":
+		return looseEqual(x, y), nil
+	case "!=":
+		return !looseEqual(x, y), nil
+	case "===":
+		return strictEqual(x, y), nil
+	case "!==":
+		return !strictEqual(x, y), nil
+	}
+	// 比较运算: 两边都是字符串时按字典序，否则按数值
+	xs, xok := x.(string)
+	ys, yok := y.(string)
+	var c int
+	if xok && yok {
+		c = strings.Compare(xs, ys)
+	} else {
+		xn, yn := toNumber(x), toNumber(y)
+		if math.IsNaN(xn) || math.IsNaN(yn) {
+			return false, nil
+		}
+		switch {
+		case xn < yn:
+			c = -1
+		case xn > yn:
+			c = 1
+		}
+	}
+	switch e.op {
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func (in *interp) evalCall(e call, sc *scope) (value, error) {
+	args := make([]value, len(e.args))
+	for i, a := range e.args {
+		v, err := in.eval(a, sc)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	if m, ok := e.fn.(member); ok {
+		recv, err := in.eval(m.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := recv.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot call %s on %s", m.name, typeName(recv))
+		}
+		return stringMethod(s, m.name, args)
+	}
+
+	fn, err := in.eval(e.fn, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch fn := fn.(type) {
+	case builtin:
+		return fn(in, args)
+	case *funcDecl:
+		return in.callFunc(fn, args, sc)
+	}
+	return nil, fmt.Errorf("%s is not a function", typeName(fn))
+}
+
+// callFunc 调用脚本中定义的函数，缺少的参数为 undefined
+func (in *interp) callFunc(fn *funcDecl, args []value, sc *scope) (value, error) {
+	if in.depth >= maxDepth {
+		return nil, fmt.Errorf("maximum call depth exceeded in %s", fn.name)
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	global := sc
+	for global.parent != nil {
+		global = global.parent
+	}
+	local := newScope(global)
+	for i, name := range fn.params {
+		var v value
+		if i < len(args) {
+			v = args[i]
+		}
+		local.vars[name] = v
+	}
+	_, v, err := in.execBody(fn.body, local)
+	return v, err
+}
+
+// stringMethod 支持 PAC 脚本常用的字符串方法
+func stringMethod(s, name string, args []value) (value, error) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return toString(args[i])
+		}
+		return "undefined"
+	}
+	switch name {
+	case "toLowerCase":
+		return strings.ToLower(s), nil
+	case "toUpperCase":
+		return strings.ToUpper(s), nil
+	case "indexOf":
+		return float64(strings.Index(s, arg(0))), nil
+	case "lastIndexOf":
+		return float64(strings.LastIndex(s, arg(0))), nil
+	case "startsWith":
+		return strings.HasPrefix(s, arg(0)), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg(0)), nil
+	case "includes":
+		return strings.Contains(s, arg(0)), nil
+	case "substring":
+		start, end := 0, len(s)
+		if len(args) > 0 {
+			start = clampIndex(toNumber(args[0]), len(s))
+		}
+		if len(args) > 1 {
+			end = clampIndex(toNumber(args[1]), len(s))
+		}
+		if start > end {
+			start, end = end, start
+		}
+		return s[start:end], nil
+	}
+	return nil, fmt.Errorf("unsupported string method %s", name)
+}
+
+func clampIndex(n float64, length int) int {
+	switch {
+	case math.IsNaN(n) || n < 0:
+		return 0
+	case n > float64(length):
+		return length
+	}
+	return int(n)
+}
+
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func toNumber(v value) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return 0
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	}
+	return math.NaN()
+}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return "function"
+}
+
+func add(x, y value) value {
+	_, xs := x.(string)
+	_, ys := y.(string)
+	if xs || ys {
+		return toString(x) + toString(y)
+	}
+	return toNumber(x) + toNumber(y)
+}
+
+// strictEqual === 语义，函数值只等于自身
+func strictEqual(x, y value) bool {
+	if _, ok := x.(builtin); ok {
+		return false
+	}
+	if _, ok := y.(builtin); ok {
+		return false
+	}
+	return x == y
+}
+
+// looseEqual 简化的 == 语义: 同类型直接比较，null 只等于 null，其他按数值比较
+func looseEqual(x, y value) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	switch x.(type) {
+	case string, float64, bool:
+	default:
+		return false
+	}
+	if fmt.Sprintf("%T", x) == fmt.Sprintf("%T", y) {
+		return strictEqual(x, y)
+	}
+	return toNumber(x) == toNumber(y)
+}
+
+func typeName(v value) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "function"
+}
@@ -0,0 +1,130 @@
+package pac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind 词法单元的类型
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string  // 标识符、标点或解码后的字符串
+	num  float64 // tokNumber 的值
+	pos  int     // 在源码中的偏移，用于错误信息
+}
+
+// puncts 支持的标点，长的在前以便最长匹配
+var puncts = []string{
+	"===", "!==",
+	"==", "!=", "<=", ">=", "&&", "||", "+=",
+	"(", ")", "{", "}", ",", ";", ".", "!", "<", ">", "+", "-", "?", ":", "=",
+}
+
+// lex 把 PAC 脚本切分为词法单元，不支持正则字面量、除法和模板字符串
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("offset %d: unterminated comment", i)
+			}
+			i += end + 4
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("offset %d: invalid number %q", start, src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokNumber, num: n, text: src[start:i], pos: start})
+		case c == '"' || c == '\'':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("offset %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		default:
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, token{kind: tokPunct, text: p, pos: i})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("offset %d: unexpected character %q", i, c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString 解码以引号开头的字符串字面量，返回内容和消耗的字节数
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
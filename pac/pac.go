@@ -0,0 +1,125 @@
+// Package pac 解析并执行代理自动配置(PAC)脚本
+// 内置一个只覆盖 PAC 常用语法的 JavaScript 子集解释器，不依赖外部的 JS 引擎
+package pac
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Env 脚本执行时依赖的外部环境，字段为空时使用系统默认值
+type Env struct {
+	// Resolve 供 dnsResolve、isResolvable、isInNet 解析主机名
+	Resolve func(ctx context.Context, host string) ([]net.IP, error)
+	// MyIP 供 myIpAddress 返回本机地址，为空时返回 127.0.0.1
+	MyIP func() net.IP
+	// Now 供 weekdayRange、timeRange 读取当前时间
+	Now func() time.Time
+}
+
+// Script 解析后的 PAC 脚本，可以并发执行
+type Script struct {
+	body []stmt
+}
+
+// Parse 解析 PAC 脚本，脚本必须定义 FindProxyForURL 函数
+func Parse(src string) (*Script, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	body, err := p.program()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range body {
+		if fn, ok := s.(*funcDecl); ok && fn.name == "FindProxyForURL" {
+			return &Script{body: body}, nil
+		}
+	}
+	return nil, fmt.Errorf("FindProxyForURL is not defined")
+}
+
+// FindProxyForURL 执行脚本的 FindProxyForURL(url, host)，返回原始结果字符串
+// 每次调用都在新的全局作用域中执行脚本顶层语句，调用之间不共享状态
+func (s *Script) FindProxyForURL(ctx context.Context, env *Env, url, host string) (string, error) {
+	in := &interp{ctx: ctx, env: env}
+	global := newScope(nil)
+	for name, fn := range builtins {
+		global.vars[name] = fn
+	}
+	if _, _, err := in.execBody(s.body, global); err != nil {
+		return "", err
+	}
+	fn, ok := global.vars["FindProxyForURL"].(*funcDecl)
+	if !ok {
+		return "", fmt.Errorf("FindProxyForURL is not a function")
+	}
+	v, err := in.callFunc(fn, []value{url, host}, global)
+	if err != nil {
+		return "", err
+	}
+	result, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("FindProxyForURL returned %s, want string", typeName(v))
+	}
+	return result, nil
+}
+
+// Proxy 结果中的一项
+type Proxy struct {
+	Type string // DIRECT、PROXY、HTTP、HTTPS、SOCKS、SOCKS4 或 SOCKS5
+	Addr string // host:port，DIRECT 时为空
+}
+
+// String 返回 PAC 结果格式
+func (p Proxy) String() string {
+	if p.Type == "DIRECT" {
+		return p.Type
+	}
+	return p.Type + " " + p.Addr
+}
+
+// proxyTypes 支持的结果类型
+var proxyTypes = map[string]bool{
+	"DIRECT": true, "PROXY": true, "HTTP": true, "HTTPS": true,
+	"SOCKS": true, "SOCKS4": true, "SOCKS5": true,
+}
+
+// ParseResult 解析 FindProxyForURL 的结果，例如 "PROXY a:8080; SOCKS b:1080; DIRECT"
+// 空结果等同于 DIRECT
+func ParseResult(result string) ([]Proxy, error) {
+	var proxies []Proxy
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		typ := strings.ToUpper(fields[0])
+		if !proxyTypes[typ] {
+			return nil, fmt.Errorf("unsupported proxy type %q", fields[0])
+		}
+		if typ == "DIRECT" {
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("unexpected address after DIRECT in %q", strings.TrimSpace(entry))
+			}
+			proxies = append(proxies, Proxy{Type: typ})
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected %s host:port, got %q", typ, strings.TrimSpace(entry))
+		}
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", typ, fields[1], err)
+		}
+		proxies = append(proxies, Proxy{Type: typ, Addr: fields[1]})
+	}
+	if len(proxies) == 0 {
+		return []Proxy{{Type: "DIRECT"}}, nil
+	}
+	return proxies, nil
+}
@@ -0,0 +1,391 @@
+package pac
+
+import "fmt"
+
+// 表达式节点
+type (
+	expr interface{}
+
+	literal struct{ v value }
+	ident   struct{ name string }
+	unary   struct {
+		op string
+		x  expr
+	}
+	binary struct {
+		op   string
+		x, y expr
+	}
+	conditional struct{ cond, then, els expr }
+	call        struct {
+		fn   expr
+		args []expr
+	}
+	member struct {
+		x    expr
+		name string
+	}
+)
+
+// 语句节点
+type (
+	stmt interface{}
+
+	varStmt struct {
+		name string
+		init expr // 为 nil 时为 undefined
+	}
+	assignStmt struct {
+		name  string
+		op    string // = 或 +=
+		value expr
+	}
+	ifStmt struct {
+		cond expr
+		then stmt
+		els  stmt
+	}
+	blockStmt  struct{ body []stmt }
+	returnStmt struct{ value expr }
+	exprStmt   struct{ x expr }
+	funcDecl   struct {
+		name   string
+		params []string
+		body   []stmt
+	}
+)
+
+// parser 递归下降解析 PAC 使用的 JavaScript 子集:
+// 函数声明、var/let/const、赋值、if/else、return 和常见的表达式，不支持循环和对象
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+// is 判断下一个词法单元是否为指定的标点或关键字
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokEOF {
+		found = "end of script"
+	}
+	return fmt.Errorf("offset %d: %s, found %q", t.pos, fmt.Sprintf(format, args...), found)
+}
+
+func (p *parser) identName() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf("expected identifier")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// program 解析整个脚本
+func (p *parser) program() ([]stmt, error) {
+	var body []stmt
+	for p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+	return body, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	switch {
+	case p.accept(";"):
+		return blockStmt{}, nil
+	case p.is("{"):
+		return p.block()
+	case p.accept("function"):
+		return p.function()
+	case p.accept("var"), p.accept("let"), p.accept("const"):
+		return p.varDecl()
+	case p.accept("if"):
+		return p.ifStatement()
+	case p.accept("return"):
+		var value expr
+		if !p.is(";") && !p.is("}") && p.peek().kind != tokEOF {
+			var err error
+			if value, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return returnStmt{value: value}, nil
+	}
+
+	// 赋值只能作为语句出现
+	if t := p.peek(); t.kind == tokIdent && p.pos+1 < len(p.tokens) {
+		if op := p.tokens[p.pos+1]; op.kind == tokPunct && (op.text == "=" || op.text == "+=") {
+			p.pos += 2
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			p.accept(";")
+			return assignStmt{name: t.text, op: op.text, value: value}, nil
+		}
+	}
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	return exprStmt{x: x}, nil
+}
+
+func (p *parser) block() (blockStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return blockStmt{}, err
+	}
+	var body []stmt
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return blockStmt{}, p.errorf("expected %q", "}")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return blockStmt{}, err
+		}
+		body = append(body, s)
+	}
+	return blockStmt{body: body}, nil
+}
+
+func (p *parser) function() (stmt, error) {
+	name, err := p.identName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var params []string
+	for !p.accept(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.identName()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return &funcDecl{name: name, params: params, body: body.body}, nil
+}
+
+func (p *parser) varDecl() (stmt, error) {
+	var decls []stmt
+	for {
+		name, err := p.identName()
+		if err != nil {
+			return nil, err
+		}
+		var init expr
+		if p.accept("=") {
+			if init, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		decls = append(decls, varStmt{name: name, init: init})
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.accept(";")
+	if len(decls) == 1 {
+		return decls[0], nil
+	}
+	return blockStmt{body: decls}, nil
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	then, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	var els stmt
+	if p.accept("else") {
+		if els, err = p.statement(); err != nil {
+			return nil, err
+		}
+	}
+	return ifStmt{cond: cond, then: then, els: els}, nil
+}
+
+func (p *parser) expression() (expr, error) {
+	cond, err := p.binaryLevel(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return conditional{cond: cond, then: then, els: els}, nil
+}
+
+// binaryLevels 二元运算符按优先级从低到高排列
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+}
+
+func (p *parser) binaryLevel(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.unaryExpr()
+	}
+	x, err := p.binaryLevel(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		if t.kind == tokPunct {
+			for _, candidate := range binaryLevels[level] {
+				if t.text == candidate {
+					op = candidate
+				}
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.binaryLevel(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) unaryExpr() (expr, error) {
+	for _, op := range []string{"!", "-", "+"} {
+		if p.accept(op) {
+			x, err := p.unaryExpr()
+			if err != nil {
+				return nil, err
+			}
+			return unary{op: op, x: x}, nil
+		}
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("("):
+			var args []expr
+			for !p.accept(")") {
+				if len(args) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			x = call{fn: x, args: args}
+		case p.accept("."):
+			name, err := p.identName()
+			if err != nil {
+				return nil, err
+			}
+			x = member{x: x, name: name}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	if t.kind == tokEOF || t.kind == tokPunct && t.text != "(" {
+		return nil, p.errorf("unexpected token")
+	}
+	p.pos++
+	switch t.kind {
+	case tokString:
+		return literal{v: t.text}, nil
+	case tokNumber:
+		return literal{v: t.num}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{v: true}, nil
+		case "false":
+			return literal{v: false}, nil
+		case "null", "undefined":
+			return literal{v: nil}, nil
+		}
+		return ident{name: t.text}, nil
+	}
+	// 括号表达式
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(")")
+}
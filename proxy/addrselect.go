@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+const (
+	// defaultAddrExploreInterval 地址的统计过期后重新测量的默认间隔
+	defaultAddrExploreInterval = 5 * time.Minute
+	// defaultMaxAddrs 默认保存统计的地址数上限
+	defaultMaxAddrs = 4096
+)
+
+// addrPicker 在解析得到的多个同族地址中选择拨号地址的解析器实现的可选接口
+type addrPicker interface {
+	pickAddr(ips []net.IPAddr) int
+}
+
+// addrObserver 需要按地址记录拨号结果的解析器实现的可选接口
+type addrObserver interface {
+	observeAddr(ip net.IPAddr, rtt time.Duration, err error)
+}
+
+// addrSelector 按每个地址的历史拨号延迟和失败选择地址
+// 没有统计或统计已过期的地址优先拨号一次，从而定期重新测量；其余地址中选择没有失败且延迟最低的
+type addrSelector struct {
+	explore  time.Duration
+	maxAddrs int
+	clock    clock.Clock
+
+	mu    sync.Mutex
+	stats map[netip.Addr]*addrStat
+}
+
+// addrStat 单个地址的拨号统计
+type addrStat struct {
+	rtt      time.Duration // 成功拨号延迟的指数加权平均
+	failures int           // 连续失败次数
+	updated  time.Time
+}
+
+// newAddrSelector config 为 nil 时返回 nil
+func newAddrSelector(config *C.AddrSelectionConfig, clk clock.Clock) *addrSelector {
+	if config == nil {
+		return nil
+	}
+	s := &addrSelector{
+		explore:  config.ExploreInterval,
+		maxAddrs: config.MaxAddrs,
+		clock:    clk,
+		stats:    make(map[netip.Addr]*addrStat),
+	}
+	if s.explore <= 0 {
+		s.explore = defaultAddrExploreInterval
+	}
+	if s.maxAddrs <= 0 {
+		s.maxAddrs = defaultMaxAddrs
+	}
+	return s
+}
+
+// pick 返回要拨号的地址的下标，selector 为 nil 时按解析器的顺序选择第一个
+func (s *addrSelector) pick(ips []net.IPAddr) int {
+	if s == nil || len(ips) < 2 {
+		return 0
+	}
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best := -1
+	var bestStat *addrStat
+	for i, ip := range ips {
+		st := s.stats[addrKey(ip)]
+		if st == nil || now.Sub(st.updated) >= s.explore {
+			return i
+		}
+		if best < 0 || st.failures < bestStat.failures || (st.failures == bestStat.failures && st.rtt < bestStat.rtt) {
+			best, bestStat = i, st
+		}
+	}
+	return best
+}
+
+// observe 记录一次到 ip 的拨号结果
+func (s *addrSelector) observe(ip net.IPAddr, rtt time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	key := addrKey(ip)
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.stats[key]
+	if st == nil {
+		if len(s.stats) >= s.maxAddrs {
+			s.evictOldest()
+		}
+		st = &addrStat{}
+		s.stats[key] = st
+	}
+	if err != nil {
+		st.failures++
+	} else {
+		st.failures = 0
+		if st.rtt == 0 {
+			st.rtt = rtt
+		} else {
+			st.rtt = (3*st.rtt + rtt) / 4
+		}
+	}
+	st.updated = now
+}
+
+// evictOldest 淘汰最久没有更新的地址，调用方持有 mu
+func (s *addrSelector) evictOldest() {
+	var oldest netip.Addr
+	var oldestTime time.Time
+	for addr, st := range s.stats {
+		if !oldest.IsValid() || st.updated.Before(oldestTime) {
+			oldest, oldestTime = addr, st.updated
+		}
+	}
+	delete(s.stats, oldest)
+}
+
+// addrKey 返回统计使用的地址键，IPv4 映射地址按 IPv4 处理
+func addrKey(ip net.IPAddr) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip.IP)
+	return addr.Unmap().WithZone(ip.Zone)
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/health"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// balanceRetryAfter latency 策略中拨号失败的代理在这段时间内不被选择，所有代理都失败时仍然选择
+const balanceRetryAfter = 10 * time.Second
+
+// balanceDialer 在主代理和命名代理之间分配拨号
+type balanceDialer struct {
+	members  []*balanceMember
+	strategy C.BalanceStrategy
+	clock    clock.Clock
+	health   *health.Checker // 为 nil 时所有成员都可用
+	next     uint64          // 轮询位置
+
+	mu sync.Mutex // 保护 weighted 策略的 current
+}
+
+// balanceMember 参与均衡的一个代理
+type balanceMember struct {
+	name     string // Proxies 中的名称，主代理为空
+	dialer   ProxyDialer
+	counter  *metrics.UpstreamCounter
+	weight   int
+	current  int   // 平滑加权轮询的当前值
+	failedAt int64 // 最近一次拨号失败的 UnixNano，0 表示没有失败或之后已成功
+}
+
+// newBalanceDialer 按 Balance 创建均衡拨号器，未配置或未启用代理时返回 nil
+// 成员的拨号器由 pm.dialer 和 named 持有，均衡拨号器不需要关闭；checker 判定不可用的成员不参与均衡
+func newBalanceDialer(config *C.Config, primary ProxyDialer, named map[string]*namedProxy, checker *health.Checker, pm *ProxyManager) *balanceDialer {
+	balance := ifFeature(config, C.FeatureBalance, config.Balance)
+	if balance == nil || !config.Enable {
+		return nil
+	}
+	d := &balanceDialer{strategy: balance.Strategy, clock: pm.Clock(), health: checker}
+	d.add("", primary, balance.PrimaryWeight, pm.Metrics)
+	for _, name := range balance.Members(config) {
+		d.add(name, named[name].dialer, config.NamedProxy(name).Weight, pm.Metrics)
+	}
+	return d
+}
+
+// add 加入一个成员，启用指标时活动连接数和拨号耗时记录在收集器中，UpdateConfig 前后同名的代理使用同一个计数器
+func (d *balanceDialer) add(name string, dialer ProxyDialer, weight int, mc *metrics.MetricsCollector) {
+	counter := mc.Upstream(name)
+	if counter == nil {
+		counter = &metrics.UpstreamCounter{}
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	d.members = append(d.members, &balanceMember{name: name, dialer: dialer, counter: counter, weight: weight})
+}
+
+// String 返回成员列表，用于连接池的键
+func (d *balanceDialer) String() string {
+	names := make([]string, len(d.members))
+	for i, m := range d.members {
+		names[i] = m.name
+	}
+	return "balance " + string(d.strategy) + " [" + strings.Join(names, ",") + "]"
+}
+
+// candidates 返回健康检查判定可用的成员，都不可用时返回全部成员
+func (d *balanceDialer) candidates() []*balanceMember {
+	if d.health == nil {
+		return d.members
+	}
+	up := make([]*balanceMember, 0, len(d.members))
+	for _, m := range d.members {
+		if d.health.Up(m.name) {
+			up = append(up, m)
+		}
+	}
+	if len(up) == 0 {
+		return d.members
+	}
+	return up
+}
+
+// pick 按策略在可用的成员中选择
+func (d *balanceDialer) pick() *balanceMember {
+	members := d.candidates()
+	start := int((atomic.AddUint64(&d.next, 1) - 1) % uint64(len(members)))
+	switch d.strategy {
+	case C.BalanceLeastConn:
+		return scan(members, start, func(m, best *balanceMember) bool { return m.counter.Active() < best.counter.Active() })
+	case C.BalanceWeighted:
+		return d.pickWeighted(members)
+	case C.BalanceLatency:
+		now := d.clock.Now().UnixNano()
+		return scan(members, start, func(m, best *balanceMember) bool {
+			if mh, bh := m.healthy(now), best.healthy(now); mh != bh {
+				return mh
+			}
+			// 还没有成功拨号的代理先试一次，得到它的耗时
+			return m.counter.Latency() < best.counter.Latency()
+		})
+	default:
+		return members[start]
+	}
+}
+
+// scan 从轮询位置开始查找 better 认为最好的成员，相同的成员轮流使用
+func scan(members []*balanceMember, start int, better func(m, best *balanceMember) bool) *balanceMember {
+	best := members[start]
+	for i := 1; i < len(members); i++ {
+		if m := members[(start+i)%len(members)]; better(m, best) {
+			best = m
+		}
+	}
+	return best
+}
+
+// pickWeighted 平滑加权轮询: 每次选择后 current 最大的成员减去权重总和，权重大的成员被更均匀地穿插选择
+func (d *balanceDialer) pickWeighted(members []*balanceMember) *balanceMember {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var best *balanceMember
+	total := 0
+	for _, m := range members {
+		m.current += m.weight
+		total += m.weight
+		if best == nil || m.current > best.current {
+			best = m
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// healthy 判断成员在 now 时没有处于失败后的等待期
+func (m *balanceMember) healthy(now int64) bool {
+	failed := atomic.LoadInt64(&m.failedAt)
+	return failed == 0 || now-failed >= int64(balanceRetryAfter)
+}
+
+func (d *balanceDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 通过选中的代理拨号，成功时记录拨号耗时，连接关闭前计入该代理的活动连接数
+func (d *balanceDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	m := d.pick()
+	m.counter.AddDial()
+	start := d.clock.Now()
+	conn, err := m.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		m.counter.AddFailure()
+		// 调用方取消的拨号不说明代理有问题
+		if ctx.Err() == nil {
+			atomic.StoreInt64(&m.failedAt, d.clock.Now().UnixNano())
+		}
+		return nil, err
+	}
+	m.counter.ObserveLatency(d.clock.Since(start))
+	atomic.StoreInt64(&m.failedAt, 0)
+	m.counter.Acquire()
+	return &balancedConn{Conn: conn, counter: m.counter}, nil
+}
+
+// balancedConn 关闭时减少上游代理的活动连接数
+type balancedConn struct {
+	net.Conn
+	counter *metrics.UpstreamCounter
+	once    sync.Once
+}
+
+func (c *balancedConn) Close() error {
+	c.once.Do(c.counter.Release)
+	return c.Conn.Close()
+}
+
+// CloseWrite 关闭底层连接的写方向
+func (c *balancedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return &net.OpError{Op: "close", Net: "tcp", Err: errors.ErrUnsupportedProxy}
+}
+
+func (c *balancedConn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// dailyBytes 一个目标主机当天的流量
+type dailyBytes struct {
+	limit int64
+	day   time.Time // 当天零点，按时间来源的时区计算
+	bytes int64
+}
+
+// byteCapEnforcer 执行路由规则的流量上限
+// 由管理器创建一次，UpdateConfig 后当天的用量仍然保留
+type byteCapEnforcer struct {
+	clock   clock.Clock
+	metrics *metrics.MetricsCollector
+
+	mu    sync.Mutex
+	daily map[string]*dailyBytes
+}
+
+func newByteCapEnforcer(clk clock.Clock, mc *metrics.MetricsCollector) *byteCapEnforcer {
+	e := &byteCapEnforcer{clock: clk, metrics: mc, daily: make(map[string]*dailyBytes)}
+	if mc != nil {
+		mc.SetByteCapUsage(e.snapshot)
+	}
+	return e
+}
+
+// today 返回当前时间所在日期的零点
+func (e *byteCapEnforcer) today() time.Time {
+	now := e.clock.Now()
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+// state 返回 host 当天的流量状态，进入新的一天时清零，调用方持有 e.mu
+func (e *byteCapEnforcer) state(host string, limit int64) *dailyBytes {
+	today := e.today()
+	s, ok := e.daily[host]
+	if !ok {
+		s = &dailyBytes{}
+		e.daily[host] = s
+	}
+	if !s.day.Equal(today) {
+		s.day = today
+		s.bytes = 0
+	}
+	s.limit = limit
+	return s
+}
+
+// admit 检查 host 当天的流量是否已经用完，返回包装连接时使用的每日状态
+func (e *byteCapEnforcer) admit(rule *rules.Rule, host string) (*dailyBytes, error) {
+	if rule.MaxDailyBytes <= 0 {
+		return nil, nil
+	}
+	e.mu.Lock()
+	s := e.state(host, rule.MaxDailyBytes)
+	exceeded := s.bytes >= s.limit
+	e.mu.Unlock()
+	if exceeded {
+		if e.metrics != nil {
+			e.metrics.RecordByteCapBlocked()
+		}
+		return nil, errors.WrapError(errors.ErrByteCapExceeded, host)
+	}
+	return s, nil
+}
+
+// remaining 返回 host 当天剩余的字节数
+func (e *byteCapEnforcer) remaining(host string, s *dailyBytes) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s = e.state(host, s.limit)
+	return s.limit - s.bytes
+}
+
+// account 记录 host 当天的流量
+func (e *byteCapEnforcer) account(host string, s *dailyBytes, n int) {
+	e.mu.Lock()
+	e.state(host, s.limit).bytes += int64(n)
+	e.mu.Unlock()
+}
+
+// snapshot 返回各目标主机当天已用的字节数
+func (e *byteCapEnforcer) snapshot() map[string]int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	today := e.today()
+	usage := make(map[string]int64, len(e.daily))
+	for host, s := range e.daily {
+		if s.day.Equal(today) {
+			usage[host] = s.bytes
+		} else {
+			usage[host] = 0
+		}
+	}
+	return usage
+}
+
+// wrap 为设置了流量上限的连接统计流量
+func (e *byteCapEnforcer) wrap(conn net.Conn, rule *rules.Rule, host string, daily *dailyBytes) net.Conn {
+	if rule.MaxConnBytes <= 0 && daily == nil {
+		return conn
+	}
+	return &byteCapConn{Conn: conn, enforcer: e, host: host, connLimit: rule.MaxConnBytes, daily: daily}
+}
+
+// byteCapConn 超出流量上限后关闭的连接，读写不会超过剩余的字节数
+type byteCapConn struct {
+	net.Conn
+	enforcer  *byteCapEnforcer
+	host      string
+	connLimit int64       // 0 表示不限制
+	daily     *dailyBytes // nil 表示不限制
+
+	mu     sync.Mutex
+	bytes  int64
+	closed bool
+}
+
+// allowance 返回本次读写最多可以传输的字节数，limited 为 false 表示不限制
+func (c *byteCapConn) allowance() (allow int64, limited bool) {
+	if c.connLimit > 0 {
+		c.mu.Lock()
+		allow, limited = c.connLimit-c.bytes, true
+		c.mu.Unlock()
+	}
+	if c.daily != nil {
+		if left := c.enforcer.remaining(c.host, c.daily); !limited || left < allow {
+			allow, limited = left, true
+		}
+	}
+	return allow, limited
+}
+
+func (c *byteCapConn) account(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.bytes += int64(n)
+	c.mu.Unlock()
+	if c.daily != nil {
+		c.enforcer.account(c.host, c.daily, n)
+	}
+}
+
+// exceed 关闭连接并返回流量上限错误
+func (c *byteCapConn) exceed() error {
+	c.mu.Lock()
+	first := !c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if first {
+		c.Conn.Close()
+		if c.enforcer.metrics != nil {
+			c.enforcer.metrics.RecordByteCapClosed()
+		}
+	}
+	return errors.WrapError(errors.ErrByteCapExceeded, c.host)
+}
+
+func (c *byteCapConn) Read(b []byte) (int, error) {
+	allow, limited := c.allowance()
+	if limited && allow <= 0 {
+		return 0, c.exceed()
+	}
+	if limited && int64(len(b)) > allow {
+		b = b[:allow]
+	}
+	n, err := c.Conn.Read(b)
+	c.account(n)
+	return n, err
+}
+
+func (c *byteCapConn) Write(b []byte) (int, error) {
+	allow, limited := c.allowance()
+	if limited && allow <= 0 {
+		return 0, c.exceed()
+	}
+	if limited && int64(len(b)) > allow {
+		n, err := c.Conn.Write(b[:allow])
+		c.account(n)
+		if err != nil {
+			return n, err
+		}
+		return n, c.exceed()
+	}
+	n, err := c.Conn.Write(b)
+	c.account(n)
+	return n, err
+}
+
+func (c *byteCapConn) Unwrap() net.Conn { return c.Conn }
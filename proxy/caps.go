@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+// Capability 握手中发现的上游代理能力
+type Capability string
+
+const (
+	CapUDPAssociate Capability = "udp_associate" // SOCKS5 支持 UDP ASSOCIATE
+	CapNoAuth       Capability = "no_auth"       // SOCKS5 接受无认证
+	CapIPv6         Capability = "ipv6"          // SOCKS5 接受 IPv6 地址类型
+	CapHTTP2Connect Capability = "h2_connect"    // TLS 握手协商出 h2，可以使用 HTTP2 CONNECT
+)
+
+type capabilityKey struct {
+	endpoint string
+	cap      Capability
+}
+
+type capabilityEntry struct {
+	supported bool
+	expires   time.Time
+}
+
+// capabilityCache 按代理地址缓存握手中发现的能力，条目在 TTL 后失效
+// 所有拨号器共用，UpdateConfig 重建拨号器后缓存仍然有效；由多个管理器共用，始终按真实时间判断过期
+type capabilityCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	clock   clock.Clock
+	entries map[capabilityKey]capabilityEntry
+}
+
+var capabilities = &capabilityCache{
+	ttl:     C.DefaultCapabilityTTL,
+	clock:   clock.Real,
+	entries: make(map[capabilityKey]capabilityEntry),
+}
+
+// setTTL 设置之后记录的条目的有效期，0 表示不缓存
+func (c *capabilityCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+}
+
+// record 记录 endpoint 是否支持 cap
+func (c *capabilityCache) record(endpoint string, cap Capability, supported bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[capabilityKey{endpoint, cap}] = capabilityEntry{supported: supported, expires: c.clock.Now().Add(c.ttl)}
+}
+
+// lookup 返回缓存的结果，known 为 false 表示没有记录或已过期
+func (c *capabilityCache) lookup(endpoint string, cap Capability) (supported, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := capabilityKey{endpoint, cap}
+	e, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	if c.clock.Now().After(e.expires) {
+		delete(c.entries, key)
+		return false, false
+	}
+	return e.supported, true
+}
+
+// unsupported 判断缓存是否记录 endpoint 不支持 cap
+func (c *capabilityCache) unsupported(endpoint string, cap Capability) bool {
+	supported, known := c.lookup(endpoint, cap)
+	return known && !supported
+}
+
+// ProxyCapabilities 返回 endpoint 上未过期的能力记录，endpoint 为代理的 host:port
+func ProxyCapabilities(endpoint string) map[Capability]bool {
+	capabilities.mu.Lock()
+	defer capabilities.mu.Unlock()
+	now := capabilities.clock.Now()
+	caps := make(map[Capability]bool)
+	for key, e := range capabilities.entries {
+		if key.endpoint == endpoint && now.Before(e.expires) {
+			caps[key.cap] = e.supported
+		}
+	}
+	return caps
+}
+
+// ResetCapabilities 清空能力缓存，例如代理升级或更换配置后
+func ResetCapabilities() {
+	capabilities.mu.Lock()
+	capabilities.entries = make(map[capabilityKey]capabilityEntry)
+	capabilities.mu.Unlock()
+}
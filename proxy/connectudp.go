@@ -0,0 +1,586 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/masque"
+	"github.com/ba0gu0/GoHookProxy/rules"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// connectUDPQueueSize 每个 UDP 连接缓存的未读数据报数，队列满时丢弃新数据报
+const connectUDPQueueSize = 64
+
+// udpTunnel 一个 connect-udp 请求，与固定的 UDP 目标收发数据报
+type udpTunnel interface {
+	SendDatagram(payload []byte) error
+	// ReceiveDatagram 阻塞直到收到一个数据报，请求流结束时返回错误
+	ReceiveDatagram() ([]byte, error)
+	Close() error
+}
+
+// DialPacketContext 通过 connect-udp 扩展 CONNECT 建立到 addr 的 UDP 连接，实现 PacketDialer
+// 只有 http2 和 http3 代理支持，代理没有开启扩展 CONNECT 时返回 ErrConnectUDPUnsupported
+func (d *HTTPProxyDialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.record(ctx, func() (net.Conn, error) {
+		c, err := d.newConnectUDPConn(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tunnel, err := d.dialUDPTunnel(ctx, c.target)
+		if err != nil {
+			return nil, err
+		}
+		c.attach(c.target, tunnel)
+		c.counter.AddAssociation()
+		return c, nil
+	})
+}
+
+// ListenPacket 建立不固定目标的 UDP 套接字，实现 PacketDialer
+// connect-udp 的每个请求只对应一个目标，WriteTo 第一次发往某个目标时为它建立请求
+func (d *HTTPProxyDialer) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	conn, err := d.record(ctx, func() (net.Conn, error) {
+		if d.proxyType != C.HTTP2 && d.proxyType != C.HTTP3 {
+			return nil, E.WrapError(E.ErrConnectUDPUnsupported, string(d.proxyType))
+		}
+		c, err := d.newConnectUDPConn(network, "")
+		if err != nil {
+			return nil, err
+		}
+		c.counter.AddAssociation()
+		return c, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*connectUDPConn), nil
+}
+
+func (d *HTTPProxyDialer) newConnectUDPConn(network, target string) (*connectUDPConn, error) {
+	if !rules.IsUDPNetwork(network) {
+		return nil, E.WrapError(E.ErrUnsupportedProxy, "connect-udp: unsupported network "+network)
+	}
+	c := &connectUDPConn{
+		dialer:  d,
+		tunnels: make(map[string]udpTunnel),
+		recv:    make(chan connectUDPPacket, connectUDPQueueSize),
+		closed:  make(chan struct{}),
+		counter: collectorOf(d.metrics).UDP().Child(),
+	}
+	if target != "" {
+		c.target = hostport.Canonical(target)
+	}
+	return c, nil
+}
+
+// dialUDPTunnel 按代理类型发送 connect-udp 请求
+func (d *HTTPProxyDialer) dialUDPTunnel(ctx context.Context, addr string) (udpTunnel, error) {
+	path, err := masque.Path(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch d.proxyType {
+	case C.HTTP2:
+		return d.connectUDPHTTP2(ctx, path)
+	case C.HTTP3:
+		conn, err := d.dialHTTP3(ctx, path, d.connectUDPHTTP3)
+		if err != nil {
+			return nil, err
+		}
+		return &http3UDPTunnel{conn.(*http3Conn)}, nil
+	default:
+		return nil, E.WrapError(E.ErrConnectUDPUnsupported, string(d.proxyType))
+	}
+}
+
+// newConnectUDPRequest 创建到代理的 connect-udp 扩展 CONNECT 请求
+func (d *HTTPProxyDialer) newConnectUDPRequest(ctx context.Context, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "https://"+d.proxyURL.Host+path, body)
+	if err != nil {
+		return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+	}
+	req.Header.Set(http3.CapsuleProtocolHeader, masque.CapsuleProtocol)
+	if err := d.setProxyAuthorization(ctx, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// connectUDPStatus 检查 connect-udp 请求的响应状态
+func connectUDPStatus(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return E.ErrHTTPProxyAuth
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return connectStatusError(resp)
+	}
+	return nil
+}
+
+// connectUDPHTTP3 在 HTTP/3 会话上发送 connect-udp 请求，数据报以 QUIC 数据报收发
+// 代理的 SETTINGS 必须同时开启扩展 CONNECT 和 HTTP 数据报
+func (d *HTTPProxyDialer) connectUDPHTTP3(ctx context.Context, s *http3Session, path string) (net.Conn, error) {
+	select {
+	case <-s.client.ReceivedSettings():
+	case <-s.conn.Context().Done():
+		return nil, E.WrapError(E.ErrProxyNegotiation, context.Cause(s.conn.Context()).Error())
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+	if settings := s.client.Settings(); !settings.EnableExtendedConnect || !settings.EnableDatagrams {
+		return nil, E.ErrConnectUDPUnsupported
+	}
+
+	str, err := s.client.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+	}
+	conn := &http3Conn{
+		RequestStream: str,
+		localAddr:     s.conn.LocalAddr(),
+		remoteAddr:    s.conn.RemoteAddr(),
+	}
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
+
+	req, err := d.newConnectUDPRequest(ctx, path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Proto = masque.Protocol
+
+	stageStart := time.Now()
+	if err := str.SendRequestHeader(req); err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+	}
+	if err := connectUDPStatus(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+
+	if err := guard.done(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// http3UDPTunnel HTTP/3 上的 connect-udp 请求流
+type http3UDPTunnel struct {
+	*http3Conn
+}
+
+func (t *http3UDPTunnel) SendDatagram(payload []byte) error {
+	return t.RequestStream.SendDatagram(masque.AppendDatagram(nil, payload))
+}
+
+func (t *http3UDPTunnel) ReceiveDatagram() ([]byte, error) {
+	for {
+		b, err := t.RequestStream.ReceiveDatagram(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if payload, ok, err := masque.ParseDatagram(b); err == nil && ok {
+			return payload, nil
+		}
+	}
+}
+
+// getHTTP2UDPConn 返回 connect-udp 共用的 HTTP/2 连接
+// net/http 的传输不允许设置 :protocol，connect-udp 使用单独的 golang.org/x/net/http2 连接
+func (d *HTTPProxyDialer) getHTTP2UDPConn(ctx context.Context) (*http2.ClientConn, error) {
+	d.h2mu.Lock()
+	defer d.h2mu.Unlock()
+	if d.h2UDPConn != nil && d.h2UDPConn.CanTakeNewRequest() {
+		return d.h2UDPConn, nil
+	}
+	tlsConfig, err := d.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	stageStart := time.Now()
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyURL.Host)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, E.ErrConnectionTimeout
+		}
+		return nil, E.WrapError(E.ErrProxyDialFailed, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	d.rtt.Observe(time.Since(stageStart))
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
+
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
+
+	stageStart = time.Now()
+	tlsConfig.NextProtos = []string{"h2"}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %w", E.ErrTLSHandshake, err)
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+		conn.Close()
+		return nil, E.WrapError(E.ErrConnectUDPUnsupported, "proxy did not negotiate h2")
+	}
+	recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+
+	cc, err := (&http2.Transport{ReadIdleTimeout: d.Config.KeepAlive}).NewClientConn(tlsConn)
+	if err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+	}
+	if err := guard.done(ctx); err != nil {
+		cc.Close()
+		return nil, err
+	}
+
+	if d.h2UDPConn != nil {
+		// 旧连接上的请求继续使用原连接，全部结束后关闭
+		go d.h2UDPConn.Shutdown(context.Background())
+	}
+	d.h2UDPConn = cc
+	return cc, nil
+}
+
+// connectUDPHTTP2 在 HTTP/2 连接上发送 connect-udp 请求，数据报以 DATAGRAM capsule 在请求流上收发
+func (d *HTTPProxyDialer) connectUDPHTTP2(ctx context.Context, path string) (udpTunnel, error) {
+	cc, err := d.getHTTP2UDPConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 流的生命周期由隧道的 Close 控制，拨号 ctx 和握手截止时间只限制等待响应
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	var timer *time.Timer
+	if deadline := handshakeDeadline(ctx, &d.rtt, d.Config.AdaptiveTimeout, d.Config.MinHandshakeTimeout, d.Config.Timeout); !deadline.IsZero() {
+		timer = time.AfterFunc(time.Until(deadline), cancel)
+	}
+
+	pr, pw := io.Pipe()
+	req, err := d.newConnectUDPRequest(streamCtx, path, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set(":protocol", masque.Protocol)
+
+	stageStart := time.Now()
+	resp, err := cc.RoundTrip(req)
+	stop()
+	if timer != nil {
+		timer.Stop()
+	}
+	if err == nil && streamCtx.Err() != nil {
+		resp.Body.Close()
+		err = streamCtx.Err()
+	}
+	if err != nil {
+		timedOut := streamCtx.Err() != nil
+		cancel()
+		pw.Close()
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if timedOut {
+			return nil, E.ErrConnectionTimeout
+		}
+		if cc.State().Closed {
+			return nil, E.WrapError(E.ErrProxyNegotiation, err.Error())
+		}
+		// 代理的 SETTINGS 没有开启扩展 CONNECT
+		return nil, E.WrapError(E.ErrConnectUDPUnsupported, err.Error())
+	}
+	if err := connectUDPStatus(resp); err != nil {
+		resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+
+	return &http2UDPTunnel{writer: pw, body: resp.Body, reader: bufio.NewReader(resp.Body), cancel: cancel}, nil
+}
+
+// http2UDPTunnel HTTP/2 上的 connect-udp 请求流
+type http2UDPTunnel struct {
+	writer *io.PipeWriter
+	body   io.ReadCloser
+	reader *bufio.Reader
+	cancel context.CancelFunc
+}
+
+func (t *http2UDPTunnel) SendDatagram(payload []byte) error {
+	return masque.WriteDatagramCapsule(t.writer, payload)
+}
+
+func (t *http2UDPTunnel) ReceiveDatagram() ([]byte, error) {
+	return masque.ReadDatagramCapsule(t.reader)
+}
+
+func (t *http2UDPTunnel) Close() error {
+	t.writer.Close()
+	t.body.Close()
+	t.cancel()
+	return nil
+}
+
+// closeHTTP2UDP 关闭 connect-udp 共用的 HTTP/2 连接
+func (d *HTTPProxyDialer) closeHTTP2UDP() {
+	d.h2mu.Lock()
+	defer d.h2mu.Unlock()
+	if d.h2UDPConn != nil {
+		d.h2UDPConn.Close()
+		d.h2UDPConn = nil
+	}
+}
+
+// connectUDPPacket 从某个目标收到的数据报
+type connectUDPPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// connectUDPConn connect-udp 上的 UDP 连接，同时实现 net.Conn 和 net.PacketConn
+// 每个目标对应一个请求，DialPacketContext 建立的连接只有默认目标的请求
+type connectUDPConn struct {
+	dialer  *HTTPProxyDialer
+	target  string // DialPacketContext 的默认目标
+	counter *metrics.UDPCounter
+
+	mu      sync.Mutex
+	tunnels map[string]udpTunnel
+
+	recv         chan connectUDPPacket
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline atomic.Pointer[time.Time]
+}
+
+// attach 登记到 target 的请求并开始接收数据报，返回之后使用的请求
+// 并发的 WriteTo 已经为 target 建立了请求时关闭 tunnel 并返回已有的请求，连接已关闭时返回 nil
+func (c *connectUDPConn) attach(target string, tunnel udpTunnel) udpTunnel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		tunnel.Close()
+		return nil
+	default:
+	}
+	if existing := c.tunnels[target]; existing != nil {
+		tunnel.Close()
+		return existing
+	}
+	c.tunnels[target] = tunnel
+	go c.receive(target, tunnel)
+	return tunnel
+}
+
+// receive 把请求收到的数据报放入接收队列
+// 默认目标的请求结束时连接随之关闭，其他目标的请求在下一次 WriteTo 时重建
+func (c *connectUDPConn) receive(target string, tunnel udpTunnel) {
+	from := udpAddr(target)
+	for {
+		b, err := tunnel.ReceiveDatagram()
+		if err != nil {
+			break
+		}
+		select {
+		case c.recv <- connectUDPPacket{data: b, from: from}:
+		case <-c.closed:
+			return
+		default:
+			c.counter.AddQueueDrop()
+		}
+	}
+
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	c.counter.AddRelayReset()
+	if target == c.target {
+		c.Close()
+		return
+	}
+	c.mu.Lock()
+	if c.tunnels[target] == tunnel {
+		delete(c.tunnels, target)
+	}
+	c.mu.Unlock()
+	tunnel.Close()
+}
+
+func (c *connectUDPConn) Write(b []byte) (int, error) {
+	if c.target == "" {
+		return 0, E.ErrConnectUDPNoTarget
+	}
+	return c.send(b, c.target)
+}
+
+// WriteTo 向 addr 发送数据报，addr 为域名时由代理解析
+func (c *connectUDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.send(b, hostport.Canonical(addr.String()))
+}
+
+// send 发送一个数据报，还没有到 target 的请求时先建立请求
+func (c *connectUDPConn) send(b []byte, target string) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	tunnel := c.tunnels[target]
+	c.mu.Unlock()
+	if tunnel == nil {
+		// 连接关闭时放弃正在建立的请求
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		var err error
+		tunnel, err = c.dialer.dialUDPTunnel(ctx, target)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if tunnel = c.attach(target, tunnel); tunnel == nil {
+			return 0, net.ErrClosed
+		}
+	}
+
+	if err := tunnel.SendDatagram(b); err != nil {
+		var tooLarge *quic.DatagramTooLargeError
+		if errors.As(err, &tooLarge) {
+			c.counter.AddOversizedDrop()
+			return 0, E.WrapError(E.ErrConnectUDPMessage, "datagram too large")
+		}
+		return 0, err
+	}
+	c.counter.AddPacketSent()
+	return len(b), nil
+}
+
+func (c *connectUDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// ReadFrom 读取一个数据报，返回数据报所属请求的目标地址
+func (c *connectUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var timeout <-chan time.Time
+	if deadline := c.readDeadline.Load(); deadline != nil && !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(*deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-c.recv:
+		c.counter.AddPacketReceived()
+		n := copy(b, p.data)
+		if n < len(p.data) {
+			c.counter.AddOversizedDrop()
+			return n, p.from, io.ErrShortBuffer
+		}
+		return n, p.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Close 关闭所有目标的请求，共用的 HTTP/2 或 HTTP/3 连接继续供其他连接使用
+func (c *connectUDPConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		tunnels := c.tunnels
+		c.tunnels = make(map[string]udpTunnel)
+		c.mu.Unlock()
+		for _, tunnel := range tunnels {
+			tunnel.Close()
+		}
+	})
+	return nil
+}
+
+// Stats 返回本连接的数据报统计
+func (c *connectUDPConn) Stats() metrics.UDPStats {
+	return c.counter.Stats()
+}
+
+func (c *connectUDPConn) LocalAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4zero} }
+
+// RemoteAddr 返回默认目标，没有默认目标时返回代理地址
+func (c *connectUDPConn) RemoteAddr() net.Addr {
+	if c.target == "" {
+		return SocksAddr(c.dialer.proxyURL.Host)
+	}
+	return udpAddr(c.target)
+}
+
+func (c *connectUDPConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline 设置读取截止时间，在下一次读取时生效
+func (c *connectUDPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	return nil
+}
+
+// SetWriteDeadline 数据报发送不会阻塞，截止时间没有作用
+func (c *connectUDPConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// udpAddr 返回 addr 对应的地址，域名目标返回 SocksAddr
+func udpAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return SocksAddr(addr)
+}
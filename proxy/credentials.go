@@ -0,0 +1,80 @@
+package proxy
+
+import "context"
+
+// Credentials 代理认证凭证
+type Credentials struct {
+	User string
+	Pass string
+}
+
+// credentialsKey context 中保存单次拨号凭证的键
+type credentialsKey struct{}
+
+// WithCredentials 为经过 ctx 的拨号指定代理凭证，覆盖路由规则和拨号器配置中的 User/Pass
+// 不修改共享的配置，可用于 Tor 流隔离或按租户使用不同的代理账号
+//
+//	ctx = proxy.WithCredentials(ctx, proxy.Credentials{User: "tenant-a", Pass: "secret"})
+func WithCredentials(ctx context.Context, creds Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+// CredentialsFromContext 返回 ctx 中指定的代理凭证
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(Credentials)
+	return creds, ok
+}
+
+// credentialsFromContext 返回单次拨号的凭证，没有时返回 fallback
+func credentialsFromContext(ctx context.Context, fallback Credentials) Credentials {
+	if creds, ok := CredentialsFromContext(ctx); ok {
+		return creds
+	}
+	return fallback
+}
+
+// ProxyAuthorizer 为单次拨号生成发给 HTTP 代理的 Proxy-Authorization 头
+// 用于按请求签发短期令牌的出口网关，如基于 SPIFFE/JWT 的代理
+type ProxyAuthorizer interface {
+	// ProxyAuthorization 返回完整的头值，如 "Bearer eyJ..."，proxy 为代理地址 host:port；返回空字符串时不发送该头
+	ProxyAuthorization(ctx context.Context, proxy string) (string, error)
+}
+
+// ProxyAuthorizerFunc 将函数适配为 ProxyAuthorizer
+type ProxyAuthorizerFunc func(ctx context.Context, proxy string) (string, error)
+
+// ProxyAuthorization 实现 ProxyAuthorizer 接口
+func (f ProxyAuthorizerFunc) ProxyAuthorization(ctx context.Context, proxy string) (string, error) {
+	return f(ctx, proxy)
+}
+
+// staticAuthorization 总是返回同一个头值的 ProxyAuthorizer
+type staticAuthorization string
+
+func (s staticAuthorization) ProxyAuthorization(context.Context, string) (string, error) {
+	return string(s), nil
+}
+
+// proxyAuthorizerKey context 中保存单次拨号 ProxyAuthorizer 的键
+type proxyAuthorizerKey struct{}
+
+// WithProxyAuthorization 为经过 ctx 的拨号指定发给 HTTP 代理的 Proxy-Authorization 头，同 WithProxyAuthorizer
+//
+//	ctx = proxy.WithProxyAuthorization(ctx, "Bearer "+token)
+func WithProxyAuthorization(ctx context.Context, header string) context.Context {
+	return WithProxyAuthorizer(ctx, staticAuthorization(header))
+}
+
+// WithProxyAuthorizer 为经过 ctx 的拨号指定生成 Proxy-Authorization 头的 a，每次向代理发送 CONNECT 前调用
+// 优先于 WithCredentials、路由规则和配置中的凭证以及 Negotiate 认证，不修改共享的配置；
+// 只用于 HTTP、HTTPS、HTTP2、HTTP3 代理和 connect-udp，其他代理类型忽略。
+// 这样的拨号不复用已认证的空闲连接，也不进入连接池
+func WithProxyAuthorizer(ctx context.Context, a ProxyAuthorizer) context.Context {
+	return context.WithValue(ctx, proxyAuthorizerKey{}, a)
+}
+
+// ProxyAuthorizerFromContext 返回 ctx 中指定的 ProxyAuthorizer
+func ProxyAuthorizerFromContext(ctx context.Context) (ProxyAuthorizer, bool) {
+	a, ok := ctx.Value(proxyAuthorizerKey{}).(ProxyAuthorizer)
+	return a, ok && a != nil
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// aLongTimeAgo 设置为截止时间后阻塞中的读写立即返回超时
+var aLongTimeAgo = time.Unix(1, 0)
+
+// handshakeGuard 握手期间 ctx 结束时通过截止时间打断阻塞的读写
+// 登记在握手结束后取消，不会为每个连接留下等待 ctx 的 goroutine
+type handshakeGuard struct {
+	conn     net.Conn
+	deadline time.Time // 整个握手的截止时间，为零时不限制
+	stop     func() bool
+
+	mu       sync.Mutex
+	canceled bool // ctx 已经结束，截止时间已设置为 aLongTimeAgo
+}
+
+// guardHandshake 设置握手截止时间，并登记 ctx 结束时让读写立即超时
+func guardHandshake(ctx context.Context, conn net.Conn, deadline time.Time) *handshakeGuard {
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+	g := &handshakeGuard{conn: conn, deadline: deadline}
+	g.stop = context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.canceled = true
+		conn.SetDeadline(aLongTimeAgo)
+	})
+	return g
+}
+
+// phase 为握手中的一个阶段(如认证子协商)单独设置超时，阶段截止时间不晚于整个握手的截止时间
+// 外层没有截止时间时停滞的代理也不会让这个阶段一直阻塞；返回的函数恢复整个握手的截止时间
+func (g *handshakeGuard) phase(timeout time.Duration) (restore func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	end := time.Now().Add(timeout)
+	if g.canceled || timeout <= 0 || !g.deadline.IsZero() && !end.Before(g.deadline) {
+		return func() {}
+	}
+	g.conn.SetDeadline(end)
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if !g.canceled {
+			g.conn.SetDeadline(g.deadline)
+		}
+	}
+}
+
+// done 取消 ctx 登记并清除截止时间，ctx 已经结束时返回对应的错误
+func (g *handshakeGuard) done(ctx context.Context) error {
+	if !g.stop() {
+		return contextError(ctx)
+	}
+	g.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// contextError 将 ctx 的错误转换为包内错误，ctx 未结束时返回 nil
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return errors.ErrContextDeadlineExceeded
+	default:
+		return errors.ErrContextCanceled
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// directDialer 不经过 hook 的直连拨号器
+// hook 会替换 net.Dialer.DialContext，这里使用 net.DialTCP/DialUDP 避免再次进入代理
+// 主机名通过 resolver 解析，为 nil 时使用 SystemResolver
+type directDialer struct {
+	resolver Resolver
+	timeout  time.Duration // 建立连接的超时，0 表示只受 ctx 限制
+}
+
+func (d directDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d directDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	parent := ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dialDirect(ctx, d.resolver, network, addr)
+		done <- result{conn: conn, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// net.DialTCP 无法取消，连接建立后直接关闭
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		if parent.Err() == nil {
+			// 超过 DirectDialTimeout，调用方的 ctx 仍然有效
+			return nil, errors.WrapError(errors.ErrConnectionTimeout, fmt.Sprintf("direct dial %s after %v", addr, d.timeout))
+		}
+		return nil, parent.Err()
+	}
+}
+
+func (d directDialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.DialContext(ctx, network, addr)
+}
+
+func (directDialer) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	return net.ListenUDP(network, nil)
+}
+
+// DirectDialer 返回不经过 hook 的直连拨号器，供同一进程中绝不能走代理的代码使用，如推送指标的客户端
+// hook 替换的是 net.Dialer.DialContext，net.Dial、http.DefaultTransport 和 golang.org/x/net/websocket 的拨号都会被拦截，
+// 需要直连时把返回值的 DialContext 交给 http.Transport，或用它建立连接后交给 websocket.NewClient
+// 拨号使用 net.DialTCP/DialUDP，主机名由 Go 解析器解析，查询 DNS 服务器同样不经过 hook；不受路由规则、配额和指标影响
+// 返回值同时实现 PacketDialer
+func DirectDialer() ProxyDialer {
+	return directDialer{resolver: unhookedResolver}
+}
+
+// unhookedResolver 查询 DNS 服务器时也直连的系统解析器
+var unhookedResolver = SystemResolver{Resolver: &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return directDialer{}.DialContext(ctx, network, addr)
+	},
+}}
+
+func dialDirect(ctx context.Context, r Resolver, network, addr string) (net.Conn, error) {
+	ip, port, err := resolveAddr(ctx, r, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	resolved := ip
+	if t, ok := r.(addrTranslator); ok {
+		ip = t.translate(ctx, network, ip)
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		return net.DialUDP(network, nil, &net.UDPAddr{IP: ip.IP, Port: port, Zone: ip.Zone})
+	default:
+		start := time.Now()
+		conn, err := net.DialTCP(network, nil, &net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone})
+		// 只统计解析得到的地址，IP 字面量目标没有可选的地址
+		if o, ok := r.(addrObserver); ok && hostport.ParseIP(hostport.Host(addr)) == nil {
+			o.observeAddr(resolved, time.Since(start), err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// DialDirect 不经过代理连接目标，和 hook 的直连一样用管理器的解析器解析主机名、还原假 IP 并按 NAT64 转换
+// 配置了 AddrSelection 时在多个地址中选择表现最好的，并记录本次拨号的结果；ctx 结束或超过 DirectDialTimeout 时返回
+func (pm *ProxyManager) DialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	return pm.unhookedDialer().DialContext(ctx, network, pm.unmapFakeIP(addr))
+}
+
+// unhookedDialer 返回直连路径(hook 直连、规则和 PAC 直连、FallbackDirect)使用的拨号器，用管理器的解析器解析主机名，按 DirectDialTimeout 限制耗时
+func (pm *ProxyManager) unhookedDialer() directDialer {
+	d := directDialer{resolver: pm.localResolver()}
+	if config := pm.Config; config != nil {
+		d.timeout = config.DirectDialTimeout
+	}
+	return d
+}
+
+// managedDirectDialer 未启用代理或 ProxyType 为 direct 时使用的直连拨号器
+// 与代理拨号器一样记录连接指标，主机名通过管理器的解析器解析，hook 启用时不会再次进入代理
+type managedDirectDialer struct {
+	directDialer
+	keepAlive time.Duration
+	metrics   metrics.Recorder
+}
+
+func newManagedDirectDialer(config *C.Config, metrics metrics.Recorder) *managedDirectDialer {
+	return &managedDirectDialer{
+		directDialer: directDialer{timeout: config.DirectDialTimeout},
+		keepAlive:    config.KeepAlive,
+		metrics:      metrics,
+	}
+}
+
+// setResolver 设置解析目标主机名的解析器，实现 resolverSetter 接口
+func (d *managedDirectDialer) setResolver(r Resolver) {
+	d.resolver = r
+}
+
+func (d *managedDirectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *managedDirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.directDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok && d.keepAlive > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(d.keepAlive)
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordStage(d.metrics, metrics.StageTCPConnect, start)
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *managedDirectDialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.DialContext(ctx, network, addr)
+}
+
+// NewDirectManaged 创建不使用代理的管理器，拨号全部直连，但同样经过路由规则、配额、流量上限、
+// 标签统计、SLO 和指标收集；cfg 为 nil 时使用默认配置
+// 返回的管理器 Enable 为 false、ProxyType 为 direct，hook 不会替换函数，可以直接使用 pm.DialContext
+// 或 nohook 下的 h.DialContext/h.Transport
+func NewDirectManaged(cfg *C.Config) (*ProxyManager, error) {
+	if cfg == nil {
+		cfg = C.DefaultConfig()
+	}
+	direct := *cfg
+	direct.Enable = false
+	direct.ProxyType = C.Direct
+	direct.ProxyIP = ""
+	direct.ProxyPort = 0
+	direct.Race = nil
+	direct.UDPProxy = nil
+
+	pm, err := New(&direct)
+	if err != nil {
+		return nil, err
+	}
+	// 未启用代理时路由引擎不匹配规则，这里让规则的凭证、DSCP 和流量上限照常生效
+	pm.directManaged = true
+	pm.Rules().Enabled = true
+	return pm, nil
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/discovery"
+)
+
+// autoDiscoverTimeout 自动发现的总体超时，超过后放弃所有候选
+const autoDiscoverTimeout = 4 * discovery.DefaultProbeTimeout
+
+// resolveAuto 探测本地 sidecar 代理，返回填入探测结果的配置副本
+func resolveAuto(ctx context.Context, config *C.Config) (*C.Config, error) {
+	candidates := make([]discovery.Candidate, 0, len(config.Discovery))
+	for _, s := range config.Discovery {
+		c, err := discovery.ParseCandidate(s)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+
+	c, err := discovery.Discover(ctx, candidates, discovery.DefaultProbeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := *config
+	c.Apply(&resolved)
+	if err := resolved.Validate(); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohMaxResponse DoH 响应的最大长度
+const dohMaxResponse = 64 * 1024
+
+// DoHResolver 通过 DNS over HTTPS (RFC 8484) 解析，分别查询 A 和 AAAA 记录
+// Client 为 nil 时直连 DoH 服务器，不经过 hook 和代理
+type DoHResolver struct {
+	URL    string // 如 https://1.1.1.1/dns-query
+	Client *http.Client
+}
+
+// NewDoHResolver 创建直连 url 的 DoH 解析器
+func NewDoHResolver(url string) *DoHResolver {
+	return &DoHResolver{
+		URL: url,
+		Client: &http.Client{
+			Transport: &http.Transport{DialContext: directDialer{}.DialContext, ForceAttemptHTTP2: true},
+		},
+	}
+}
+
+// LookupIPAddr 实现 Resolver 接口，A 和 AAAA 都失败时返回第一个错误
+func (r *DoHResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+
+	var ips []net.IPAddr
+	var firstErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := r.query(ctx, name, qtype)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		ips = append(ips, answers...)
+	}
+	if len(ips) == 0 {
+		if firstErr != nil {
+			return nil, &net.DNSError{Err: firstErr.Error(), Name: host, UnwrapErr: firstErr}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// query 发送一个查询，返回应答中的地址
+func (r *DoHResolver) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, error) {
+	// RFC 8484 建议 ID 为 0，便于 HTTP 缓存
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, E.WrapError(E.ErrDoHQuery, err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, E.WrapError(E.ErrDoHQuery, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, E.WrapError(E.ErrDoHQuery, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, E.WrapError(E.ErrDoHQuery, "status "+strconv.Itoa(resp.StatusCode))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponse))
+	if err != nil {
+		return nil, E.WrapError(E.ErrDoHQuery, err.Error())
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return nil, E.WrapError(E.ErrDoHQuery, err.Error())
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, E.WrapError(E.ErrDoHQuery, reply.RCode.String())
+	}
+
+	var ips []net.IPAddr
+	for _, answer := range reply.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IPAddr{IP: net.IP(body.A[:])})
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IPAddr{IP: net.IP(body.AAAA[:])})
+		}
+	}
+	return ips, nil
+}
+
+// dnsFQDN 在主机名末尾补上点
+func dnsFQDN(host string) string {
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		return host
+	}
+	return host + "."
+}
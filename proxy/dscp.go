@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// dscpKey context 中保存单次拨号 DSCP 的键
+type dscpKey struct{}
+
+// withDSCP 为单次拨号指定到代理的连接使用的 DSCP
+func withDSCP(ctx context.Context, dscp int) context.Context {
+	return context.WithValue(ctx, dscpKey{}, dscp)
+}
+
+// markDSCP 按 ctx 中的 DSCP 设置到代理的连接的 IP_TOS/IPV6_TCLASS
+// 标记只影响网络 QoS，设置失败(平台不支持、包装链中没有套接字)时忽略，不影响拨号
+func markDSCP(ctx context.Context, conn net.Conn) {
+	dscp, ok := ctx.Value(dscpKey{}).(int)
+	if !ok || dscp <= 0 {
+		return
+	}
+	sc, ok := Unwrap[syscall.Conn](conn)
+	if !ok {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	ipv6 := false
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		ipv6 = addr.IP.To4() == nil
+	case *net.UDPAddr:
+		ipv6 = addr.IP.To4() == nil
+	default:
+		return
+	}
+	// DSCP 占 TOS/Traffic Class 字节的高 6 位，低 2 位为 ECN
+	setTrafficClass(rc, ipv6, dscp<<2)
+}
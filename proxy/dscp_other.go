@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package proxy
+
+import "syscall"
+
+// setTrafficClass 其他平台不设置 DSCP，Windows 需要通过 QoS 策略标记
+func setTrafficClass(rc syscall.RawConn, ipv6 bool, tos int) error {
+	return nil
+}
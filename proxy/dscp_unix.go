@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+
+package proxy
+
+import "syscall"
+
+// setTrafficClass 设置套接字的 IP_TOS 或 IPV6_TCLASS
+func setTrafficClass(rc syscall.RawConn, ipv6 bool, tos int) error {
+	var err error
+	cerr := rc.Control(func(fd uintptr) {
+		if ipv6 {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			return
+		}
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// EffectiveConfig 管理器当前实际生效的设置，敏感字段已脱敏，可以直接附在问题报告中
+type EffectiveConfig struct {
+	// 补全默认值、解析 auto 之后的配置
+	Config *C.Config `json:"config"`
+	// 编译后的路由规则
+	Routing EffectiveRouting `json:"routing"`
+
+	Resolver        string `json:"resolver"`          // 解析主机名使用的解析器类型
+	Negotiate       bool   `json:"negotiate"`         // 是否设置了 Negotiate 令牌提供者
+	WaitingForProxy bool   `json:"waiting_for_proxy"` // 是否处于 direct_until_healthy 的直连阶段
+	DirectManaged   bool   `json:"direct_managed"`    // 是否由 NewDirectManaged 创建
+
+	Features []FeatureStatus `json:"features"` // 功能开关的状态
+}
+
+// EffectiveRouting 路由引擎的状态
+type EffectiveRouting struct {
+	Enabled       bool            `json:"enabled"`
+	ProxyAddr     string          `json:"proxy_addr"`
+	AltProxyAddrs []string        `json:"alt_proxy_addrs"`
+	HookUDP       bool            `json:"hook_udp"`
+	ExcludeSelf   bool            `json:"exclude_self"`
+	BypassPrivate bool            `json:"bypass_private"`
+	Rules         []EffectiveRule `json:"rules"`
+}
+
+// EffectiveRule 编译后的路由规则，DSCP 为解析后的数值
+type EffectiveRule struct {
+	Type          string `json:"type"`
+	Pattern       string `json:"pattern"`
+	Action        string `json:"action"`
+	User          string `json:"user"`
+	Pass          string `json:"pass"`
+	DSCP          int    `json:"dscp"`
+	MaxConnBytes  int64  `json:"max_conn_bytes"`
+	MaxDailyBytes int64  `json:"max_daily_bytes"`
+	Tuning        string `json:"tuning"`
+}
+
+// EffectiveConfig 返回当前实际生效的设置，未设置配置时返回 nil
+func (pm *ProxyManager) EffectiveConfig() *EffectiveConfig {
+	config := pm.Config
+	if config == nil {
+		return nil
+	}
+
+	return &EffectiveConfig{
+		Config:          config.WithDefaults().Redacted(),
+		Routing:         effectiveRouting(pm.Rules()),
+		Resolver:        fmt.Sprintf("%T", pm.Resolver()),
+		Negotiate:       pm.NegotiateProvider() != nil,
+		WaitingForProxy: pm.WaitingForProxy(),
+		DirectManaged:   pm.directManaged,
+		Features:        pm.Features(),
+	}
+}
+
+func effectiveRouting(e *rules.Engine) EffectiveRouting {
+	routing := EffectiveRouting{
+		Enabled:       e.Enabled,
+		ProxyAddr:     e.ProxyAddr,
+		AltProxyAddrs: append([]string{}, e.AltProxyAddrs...),
+		HookUDP:       e.HookUDP,
+		ExcludeSelf:   e.Local != nil,
+		BypassPrivate: len(e.Private) > 0,
+		Rules:         make([]EffectiveRule, 0, len(e.Rules)),
+	}
+	for _, r := range e.Rules {
+		pass := r.Pass
+		if pass != "" {
+			pass = C.RedactedSecret
+		}
+		routing.Rules = append(routing.Rules, EffectiveRule{
+			Type:          string(r.Type),
+			Pattern:       r.Pattern,
+			Action:        string(r.Action),
+			User:          r.User,
+			Pass:          pass,
+			DSCP:          r.DSCP,
+			MaxConnBytes:  r.MaxConnBytes,
+			MaxDailyBytes: r.MaxDailyBytes,
+			Tuning:        string(r.Tuning),
+		})
+	}
+	return routing
+}
+
+// JSON 返回缩进的 JSON，相同的设置总是得到相同的输出
+func (e *EffectiveConfig) JSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
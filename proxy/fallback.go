@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// FallbackEvent 代理拨号失败后按 FallbackDirect 改为直连的记录
+type FallbackEvent struct {
+	Network   string
+	Addr      string
+	Proxy     string // 失败的代理地址
+	ProxyErr  error  // 代理拨号的错误
+	DirectErr error  // 直连的错误，直连成功时为 nil
+}
+
+// OnFallbackDirect 设置改为直连时的回调，可以用来记录日志；回调在拨号的 goroutine 中同步调用
+func (pm *ProxyManager) OnFallbackDirect(fn func(FallbackEvent)) {
+	pm.onFallback.Store(&fn)
+}
+
+// fallbackAllowed 判断代理拨号的失败是否可以改为直连
+// 代理按策略拒绝目标时直连会绕过策略，ctx 结束时不再重试
+func fallbackAllowed(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !deterministicFailure(err)
+}
+
+// fallbackDirect 直连目标，直连也失败时返回同时匹配两个错误的错误
+func (pm *ProxyManager) fallbackDirect(ctx context.Context, network, addr, proxyAddr string, proxyErr error) (net.Conn, error) {
+	direct := pm.unhookedDialer()
+	dial := direct.DialContext
+	if rules.IsUDPNetwork(network) {
+		dial = direct.DialPacketContext
+	}
+	conn, err := dial(ctx, network, addr)
+	if pm.Metrics != nil {
+		pm.Metrics.RecordFallbackDirect(err == nil)
+	}
+	if fn := pm.onFallback.Load(); fn != nil && *fn != nil {
+		(*fn)(FallbackEvent{Network: network, Addr: addr, Proxy: proxyAddr, ProxyErr: proxyErr, DirectErr: err})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w; direct fallback: %w", proxyErr, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+// FeatureStatus 功能开关在管理器中的状态
+type FeatureStatus struct {
+	C.FeatureInfo
+	Enabled bool `json:"enabled"` // 开关是否打开
+	Active  bool `json:"active"`  // 功能是否已配置且正在生效
+}
+
+// Features 返回所有功能开关的状态，顺序与 config.Features 相同
+func (pm *ProxyManager) Features() []FeatureStatus {
+	config := pm.Config
+	features := C.Features()
+	status := make([]FeatureStatus, 0, len(features))
+	for _, info := range features {
+		enabled := config.FeatureEnabled(info.Name)
+		status = append(status, FeatureStatus{
+			FeatureInfo: info,
+			Enabled:     enabled,
+			Active:      enabled && pm.featureActive(info.Name),
+		})
+	}
+	return status
+}
+
+// featureActive 判断功能对应的设置或组件是否存在，不考虑开关
+func (pm *ProxyManager) featureActive(f C.Feature) bool {
+	config := pm.Config
+	if config == nil {
+		return false
+	}
+	switch f {
+	case C.FeatureSNIRouting:
+		return config.SNIRouting
+	case C.FeatureFakeIP:
+		_, ok := pm.configuredResolver().(*FakeIPResolver)
+		return ok
+	case C.FeatureRace:
+		return pm.race != nil
+	case C.FeatureNAT64:
+		return pm.nat64 != nil
+	case C.FeatureAddrSelection:
+		return pm.addrs != nil
+	case C.FeaturePAC:
+		return pm.pac != nil
+	case C.FeatureScheduler:
+		return pm.sched != nil
+	case C.FeatureNegativeCache:
+		return pm.failed != nil
+	case C.FeatureCapabilityCache:
+		return config.Enable && config.CapabilityTTL > 0
+	case C.FeatureSelfPipe:
+		return config.SelfPipe
+	case C.FeatureResolvedHints:
+		return pm.hints != nil
+	case C.FeatureBalance:
+		return pm.balance != nil
+	case C.FeatureHealthCheck:
+		return pm.health != nil
+	}
+	return false
+}
+
+// ifFeature 功能开关关闭时返回零值，对应的组件按未配置处理
+func ifFeature[T any](config *C.Config, f C.Feature, v T) T {
+	if !config.FeatureEnabled(f) {
+		var zero T
+		return zero
+	}
+	return v
+}
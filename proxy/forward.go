@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// forwards 判断到 addr 的连接是否按 absolute-form 请求转发
+func (d *HTTPProxyDialer) forwards(addr string) bool {
+	if len(d.Config.ForwardPorts) == 0 || (d.proxyType != C.HTTP && d.proxyType != C.HTTPS) {
+		return false
+	}
+	_, port, err := hostport.Split(addr)
+	return err == nil && slices.Contains(d.Config.ForwardPorts, port)
+}
+
+// dialForward 连接代理但不发送 CONNECT，调用方写入的 HTTP 请求改写为 absolute-form 后发给代理
+// 只支持 Basic 认证和 ctx 指定的 ProxyAuthorizer，头值在拨号时生成，连接上的每个请求都携带它；代理的响应原样返回给调用方
+func (d *HTTPProxyDialer) dialForward(ctx context.Context, addr string) (net.Conn, error) {
+	var tlsConfig *tls.Config
+	if d.proxyType == C.HTTPS {
+		var err error
+		if tlsConfig, err = d.clientTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	stageStart := time.Now()
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyURL.Host)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.ErrConnectionTimeout
+		}
+		return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	d.rtt.Observe(time.Since(stageStart))
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
+
+	if d.proxyType == C.HTTPS {
+		guard := d.guardHandshake(ctx, conn)
+		defer guard.stop()
+
+		stageStart = time.Now()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %w", errors.ErrTLSHandshake, err)
+		}
+		recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+		if err := guard.done(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	auth, err := d.proxyAuthorization(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newForwardConn(conn, hostport.Canonical(addr), auth), nil
+}
+
+// forwardConn 把写入的 HTTP 请求改写为 absolute-form 后发给代理的连接，读取直接返回代理的响应
+type forwardConn struct {
+	net.Conn
+	pw   *io.PipeWriter // 调用方写入的请求，改写失败后写入返回失败的原因
+	done chan struct{}  // 改写协程退出时关闭
+}
+
+func newForwardConn(conn net.Conn, addr, auth string) *forwardConn {
+	pr, pw := io.Pipe()
+	c := &forwardConn{Conn: conn, pw: pw, done: make(chan struct{})}
+	go c.rewrite(pr, addr, auth)
+	return c
+}
+
+// rewrite 逐个读取调用方写入的请求，按 absolute-form 发给代理
+// 请求没有 Host 头时使用拨号的目标地址，auth 不为空时设置为 Proxy-Authorization 头
+func (c *forwardConn) rewrite(pr *io.PipeReader, addr, auth string) {
+	defer close(c.done)
+	br := bufio.NewReader(pr)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if err != io.EOF {
+				pr.CloseWithError(errors.WrapError(errors.ErrProxyProtocol, "forward: "+err.Error()))
+			}
+			return
+		}
+		if req.Host == "" {
+			req.Host = addr
+		}
+		req.URL.Scheme = "http"
+		req.URL.Host = req.Host
+		if auth != "" {
+			req.Header.Set("Proxy-Authorization", auth)
+		}
+		// 调用方没有发送 User-Agent 时不补上默认值
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header["User-Agent"] = []string{""}
+		}
+		if err := req.WriteProxy(c.Conn); err != nil {
+			pr.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func (c *forwardConn) Write(b []byte) (int, error) {
+	return c.pw.Write(b)
+}
+
+// CloseWrite 等已写入的请求发给代理后关闭写方向
+func (c *forwardConn) CloseWrite() error {
+	c.pw.Close()
+	<-c.done
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return &net.OpError{Op: "close", Net: "tcp", Err: errors.ErrUnsupportedProxy}
+}
+
+func (c *forwardConn) Close() error {
+	c.pw.CloseWithError(net.ErrClosed)
+	return c.Conn.Close()
+}
+
+func (c *forwardConn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/grpc"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"golang.org/x/net/http2"
+)
+
+// GRPCDialer 通过 gRPC 双向流拨号，每个连接是 /ServiceName/Tun 上的一个流，与 gun 传输兼容
+// 所有流共用一个 HTTP/2 连接，连接断开或流数达到上限后在下一次拨号时重建；只支持 TCP
+// gRPC 服务端通常在第一条消息之前不回应，所以服务器拒绝流(包括认证失败)表现为读写失败而不是拨号失败
+type GRPCDialer struct {
+	addr      string
+	authority string
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+	transport *http2.Transport
+	Config    *C.GRPCConfig
+	metrics   metrics.Recorder
+	obfs      transport.Transport // 到代理的连接使用的传输插件，为 nil 时不使用
+
+	mu      sync.Mutex
+	session *grpcSession
+}
+
+// grpcSession 共用的 HTTP/2 连接
+type grpcSession struct {
+	cc     *http2.ClientConn
+	local  net.Addr
+	remote net.Addr
+}
+
+func createGRPCDialer(proxyIP string, proxyPort int, config *C.GRPCConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	return NewGRPCDialer(hostport.Join(proxyIP, proxyPort), config, metrics), nil
+}
+
+// NewGRPCDialer 创建 gRPC 拨号器，HTTP/2 连接在第一次拨号时建立
+func NewGRPCDialer(addr string, config *C.GRPCConfig, metrics metrics.Recorder) *GRPCDialer {
+	if config == nil {
+		config = C.DefaultGRPCConfig()
+	}
+	authority := addr
+	if config.Authority != "" {
+		authority = config.Authority
+	}
+	return &GRPCDialer{
+		addr:      addr,
+		authority: authority,
+		dialer:    &net.Dialer{Timeout: config.Timeout, KeepAlive: config.KeepAlive},
+		tlsConfig: &tls.Config{
+			ServerName:         hostport.Host(authority),
+			InsecureSkipVerify: config.SkipVerify,
+			NextProtos:         []string{"h2"},
+		},
+		transport: &http2.Transport{
+			AllowHTTP:       config.Plaintext,
+			ReadIdleTimeout: config.KeepAlive,
+		},
+		Config:  config,
+		metrics: metrics,
+	}
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *GRPCDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 在共用的 HTTP/2 连接上打开到 addr 的隧道流
+func (d *GRPCDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *GRPCDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, E.ErrGRPCNetworkNotSupported
+	}
+	session, err := d.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 流的生命周期由连接的 Close 控制，不受拨号 ctx 影响
+	streamCtx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodPost, d.scheme()+"://"+d.authority+grpc.Path(d.Config.ServiceName), pr)
+	if err != nil {
+		cancel()
+		return nil, E.WrapError(E.ErrInvalidConfig, "grpc url: "+err.Error())
+	}
+	req.Header.Set("Content-Type", grpc.ContentType)
+	req.Header.Set("Te", "trailers")
+	for key, value := range d.Config.Metadata {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set(grpc.TargetMetadata, hostport.Canonical(addr))
+	if creds := credentialsFromContext(ctx, Credentials{User: d.Config.User, Pass: d.Config.Pass}); creds.User != "" {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds.User+":"+creds.Pass)))
+	}
+
+	c := &grpcConn{
+		writer: pw,
+		local:  session.local,
+		remote: session.remote,
+		cancel: cancel,
+		ready:  make(chan struct{}),
+	}
+	go c.roundTrip(session.cc, req, collectorOf(d.metrics))
+	return c, nil
+}
+
+func (d *GRPCDialer) scheme() string {
+	if d.Config.Plaintext {
+		return "http"
+	}
+	return "https"
+}
+
+// getSession 返回共用的 HTTP/2 连接，没有可用的连接时建立新连接
+func (d *GRPCDialer) getSession(ctx context.Context) (*grpcSession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session != nil && d.session.cc.CanTakeNewRequest() {
+		return d.session, nil
+	}
+
+	stageStart := time.Now()
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, E.WrapError(E.ErrGRPCProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
+
+	deadline := handshakeDeadline(ctx, nil, false, 0, d.Config.Timeout)
+	guard := guardHandshake(ctx, conn, deadline)
+	defer guard.stop()
+
+	raw := conn
+	if !d.Config.Plaintext {
+		stageStart = time.Now()
+		tlsConn := tls.Client(conn, d.tlsConfig.Clone())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, E.WrapError(E.ErrTLSHandshake, err.Error())
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+			conn.Close()
+			return nil, E.WrapError(E.ErrProxyProtocol, "grpc server did not negotiate h2")
+		}
+		recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+		conn = tlsConn
+	}
+
+	stageStart = time.Now()
+	cc, err := d.transport.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrGRPCProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		cc.Close()
+		return nil, err
+	}
+
+	if d.session != nil {
+		// 旧连接上的流继续使用原连接，全部结束后关闭
+		go d.session.cc.Shutdown(context.Background())
+	}
+	d.session = &grpcSession{cc: cc, local: raw.LocalAddr(), remote: raw.RemoteAddr()}
+	return d.session, nil
+}
+
+// Close 关闭共用的 HTTP/2 连接和其上的所有流
+func (d *GRPCDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session == nil {
+		return nil
+	}
+	err := d.session.cc.Close()
+	d.session = nil
+	return err
+}
+
+// grpcConn 隧道流，响应在第一次读取时等待
+type grpcConn struct {
+	writer *io.PipeWriter
+	local  net.Addr
+	remote net.Addr
+	cancel context.CancelFunc
+
+	ready  chan struct{} // 收到响应头或流失败后关闭
+	resp   *http.Response
+	reader *grpc.Reader
+	err    error
+
+	closeOnce sync.Once
+}
+
+// roundTrip 发送请求并等待响应头，服务器拒绝流时让之后的读写返回错误
+func (c *grpcConn) roundTrip(cc *http2.ClientConn, req *http.Request, mc *metrics.MetricsCollector) {
+	resp, err := cc.RoundTrip(req)
+	if err == nil {
+		err = grpcStatus(resp.StatusCode, resp.Header)
+		if err != nil {
+			resp.Body.Close()
+		}
+	} else {
+		err = E.WrapError(E.ErrGRPCStreamRejected, err.Error())
+	}
+
+	if err != nil {
+		c.err = err
+		c.writer.CloseWithError(err)
+		if mc != nil {
+			mc.RecordErrorType(err)
+		}
+	} else {
+		c.resp = resp
+		c.reader = grpc.NewReader(resp.Body)
+	}
+	close(c.ready)
+}
+
+// grpcStatus 检查响应状态和 grpc-status，只有 trailer 的响应把状态放在响应头中
+func grpcStatus(code int, header http.Header) error {
+	if code != http.StatusOK {
+		return E.WrapError(E.ErrGRPCStreamRejected, http.StatusText(code))
+	}
+	if ct := header.Get("Content-Type"); !strings.HasPrefix(ct, grpc.ContentType) {
+		return E.WrapError(E.ErrGRPCStreamRejected, "unexpected content type: "+ct)
+	}
+	status := header.Get("Grpc-Status")
+	switch status {
+	case "", grpc.StatusOK:
+		return nil
+	case grpc.StatusUnauthenticated, grpc.StatusPermissionDenied:
+		return E.WrapError(E.ErrGRPCAuth, header.Get("Grpc-Message"))
+	default:
+		return E.WrapError(E.ErrGRPCStreamRejected, "grpc-status "+status+": "+header.Get("Grpc-Message"))
+	}
+}
+
+func (c *grpcConn) Read(b []byte) (int, error) {
+	<-c.ready
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.reader.Read(b)
+	if err == io.EOF {
+		// 流结束时 trailer 中的 grpc-status 非 0 表示服务器异常终止了隧道
+		if statusErr := grpcStatus(http.StatusOK, http.Header{
+			"Content-Type": {grpc.ContentType},
+			"Grpc-Status":  {c.resp.Trailer.Get("Grpc-Status")},
+			"Grpc-Message": {c.resp.Trailer.Get("Grpc-Message")},
+		}); statusErr != nil {
+			err = statusErr
+		}
+	}
+	return n, err
+}
+
+// Write 把数据编码为 gRPC 消息写入流，流被拒绝后返回拒绝的原因
+func (c *grpcConn) Write(b []byte) (int, error) {
+	return grpc.WriteHunks(c.writer, b)
+}
+
+// CloseWrite 结束发送方向，服务器读到流结束
+func (c *grpcConn) CloseWrite() error {
+	return c.writer.Close()
+}
+
+func (c *grpcConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writer.Close()
+		c.cancel()
+		go func() {
+			<-c.ready
+			if c.resp != nil {
+				c.resp.Body.Close()
+			}
+		}()
+	})
+	return nil
+}
+
+func (c *grpcConn) LocalAddr() net.Addr  { return c.local }
+func (c *grpcConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *grpcConn) SetDeadline(t time.Time) error {
+	return &net.OpError{Op: "set", Net: "grpc", Err: E.ErrUnsupportedProxy}
+}
+func (c *grpcConn) SetReadDeadline(t time.Time) error {
+	return &net.OpError{Op: "set", Net: "grpc", Err: E.ErrUnsupportedProxy}
+}
+func (c *grpcConn) SetWriteDeadline(t time.Time) error {
+	return &net.OpError{Op: "set", Net: "grpc", Err: E.ErrUnsupportedProxy}
+}
@@ -0,0 +1,167 @@
+// Package grpc gRPC 隧道的消息编解码，与 V2Ray/Xray 的 gun 传输兼容，拨号器和测试服务共用
+//
+// 每个隧道连接是 /服务名/Tun 上的一个双向流，两个方向的数据都是一串 gRPC 消息:
+//
+//	+------+----------+-----------------------------+
+//	| 压缩 |   长度    | Hunk                        |
+//	+------+----------+-----------------------------+
+//	|  1   | 4 (大端) | 0x0A | varint 长度 | 数据   |
+//	+------+----------+-----------------------------+
+//
+// Hunk 是只有一个字段的 protobuf 消息: message Hunk { bytes data = 1; }，不压缩
+// 目标地址通过 TargetMetadata 元数据(HTTP/2 请求头)发送
+package grpc
+
+import (
+	"encoding/binary"
+	"io"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+const (
+	// DefaultServiceName 未设置服务名时使用的服务名，与 gun 传输相同
+	DefaultServiceName = "GunService"
+	// ContentType 隧道流的 Content-Type
+	ContentType = "application/grpc"
+	// TargetMetadata 携带目标 host:port 的元数据
+	TargetMetadata = "tunnel-target"
+
+	// MaxHunkSize 写入时每条消息携带的最大数据量，较大的写入被拆成多条消息
+	MaxHunkSize = 32 << 10
+	// maxMessageSize 读取时接受的最大消息长度，与 gRPC 默认的 4MB 接收上限相同
+	maxMessageSize = 4 << 20
+)
+
+// gRPC 状态码，响应头或 trailer 中的 grpc-status
+const (
+	StatusOK               = "0"
+	StatusPermissionDenied = "7"
+	StatusUnavailable      = "14"
+	StatusUnauthenticated  = "16"
+)
+
+// Path 返回服务的隧道方法路径，service 为空时使用 DefaultServiceName
+func Path(service string) string {
+	if service == "" {
+		service = DefaultServiceName
+	}
+	return "/" + service + "/Tun"
+}
+
+// WriteHunks 把 data 编码为一条或多条消息写入 w
+func WriteHunks(w io.Writer, data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		n := min(len(data), MaxHunkSize)
+		if _, err := w.Write(AppendHunk(nil, data[:n])); err != nil {
+			return written, err
+		}
+		written += n
+		data = data[n:]
+	}
+	return written, nil
+}
+
+// AppendHunk 把携带 data 的一条消息追加到 dst
+func AppendHunk(dst, data []byte) []byte {
+	var field [binary.MaxVarintLen64 + 1]byte
+	field[0] = 0x0A // 字段 1，长度分隔类型
+	fieldLen := 1 + binary.PutUvarint(field[1:], uint64(len(data)))
+
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(fieldLen+len(data)))
+	dst = append(dst, header[:]...)
+	dst = append(dst, field[:fieldLen]...)
+	return append(dst, data...)
+}
+
+// Reader 从消息流中读出数据
+type Reader struct {
+	r       io.Reader
+	pending []byte // 当前消息中尚未读出的数据
+}
+
+// NewReader 创建读取 r 中消息的 Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read 实现 io.Reader，消息流正常结束时返回 io.EOF
+func (r *Reader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		data, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = data
+	}
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next 读取下一条消息并返回其中的数据
+func (r *Reader) next() ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, E.WrapError(E.ErrGRPCMessage, "truncated message header")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, E.WrapError(E.ErrGRPCMessage, "compressed message")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		return nil, E.WrapError(E.ErrGRPCMessage, "message too large")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r.r, msg); err != nil {
+		return nil, E.WrapError(E.ErrGRPCMessage, "truncated message")
+	}
+	return parseHunk(msg)
+}
+
+// parseHunk 取出 Hunk 的 data 字段，跳过未知字段
+func parseHunk(msg []byte) ([]byte, error) {
+	var data []byte
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, E.WrapError(E.ErrGRPCMessage, "invalid field key")
+		}
+		msg = msg[n:]
+
+		switch key & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, E.WrapError(E.ErrGRPCMessage, "invalid varint")
+			}
+			msg = msg[n:]
+		case 1: // 64 位
+			if len(msg) < 8 {
+				return nil, E.WrapError(E.ErrGRPCMessage, "truncated field")
+			}
+			msg = msg[8:]
+		case 5: // 32 位
+			if len(msg) < 4 {
+				return nil, E.WrapError(E.ErrGRPCMessage, "truncated field")
+			}
+			msg = msg[4:]
+		case 2: // 长度分隔
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return nil, E.WrapError(E.ErrGRPCMessage, "truncated field")
+			}
+			if key>>3 == 1 {
+				data = append(data, msg[n:n+int(size)]...)
+			}
+			msg = msg[n+int(size):]
+		default:
+			return nil, E.WrapError(E.ErrGRPCMessage, "unsupported wire type")
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// handshakeBufferSize 握手阶段读缓冲的大小，足够容纳常见的代理响应
+const handshakeBufferSize = 512
+
+// handshakeConn 握手阶段带缓冲读取的连接
+// 有的代理把目标的数据紧跟在响应后一起发送，握手结束后用 tunnel 取回连接，多读到的数据不会丢失
+type handshakeConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func newHandshakeConn(conn net.Conn) *handshakeConn {
+	return &handshakeConn{Conn: conn, br: bufio.NewReaderSize(conn, handshakeBufferSize)}
+}
+
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
+func (c *handshakeConn) Unwrap() net.Conn { return c.Conn }
+
+// tunnel 返回握手完成后的连接，缓冲中剩余的数据先于连接上的后续数据返回
+func (c *handshakeConn) tunnel() net.Conn {
+	n := c.br.Buffered()
+	if n == 0 {
+		return c.Conn
+	}
+	pending, _ := c.br.Peek(n)
+	return &prefixedConn{Conn: c.Conn, prefix: append([]byte(nil), pending...)}
+}
+
+// prefixedConn 先返回 prefix 再读取底层连接
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// CloseWrite 关闭底层连接的写方向
+func (c *prefixedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return &net.OpError{Op: "close", Net: "tcp", Err: errors.ErrUnsupportedProxy}
+}
+
+func (c *prefixedConn) Unwrap() net.Conn { return c.Conn }
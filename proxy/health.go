@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/health"
+)
+
+// newHealthChecker 按 HealthCheck 创建探测主代理和命名代理的 Checker，未配置或未启用代理时返回 nil
+// 主代理的名称为空，主代理为 direct 时只探测命名代理
+func newHealthChecker(config *C.Config, primary ProxyDialer, named map[string]*namedProxy, pm *ProxyManager) *health.Checker {
+	check := ifFeature(config, C.FeatureHealthCheck, config.HealthCheck)
+	if check == nil || !config.Enable {
+		return nil
+	}
+	var targets []health.Target
+	if config.ProxyType != C.Direct {
+		targets = append(targets, health.Target{Probe: healthProbe(check, config, primary)})
+	}
+	for _, p := range config.Proxies {
+		n := named[p.Name]
+		targets = append(targets, health.Target{Name: p.Name, Probe: healthProbe(check, n.config, n.dialer)})
+	}
+	return health.NewChecker(check, targets, pm.Clock(), pm.healthChanged)
+}
+
+// healthProbe 连接代理并完成代理协议的握手，设置了 URL 时再通过 dialer 请求该地址
+// 没有设置 Timeout 时整个探测使用代理类型的握手超时
+func healthProbe(check *C.HealthCheckConfig, config *C.Config, dialer ProxyDialer) health.Probe {
+	var fetch health.Probe
+	if check.URL != "" {
+		fetch = health.URLProbe(dialer.DialContext, check.URL)
+	}
+	timeout := probeTimeout(config)
+	return func(ctx context.Context) error {
+		if check.Timeout <= 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if err := probeProxy(ctx, config, dialer); err != nil {
+			return err
+		}
+		if fetch != nil {
+			return fetch(ctx)
+		}
+		return nil
+	}
+}
+
+// Health 返回主代理和命名代理的健康状态，顺序为主代理和 Proxies 的顺序，未配置 HealthCheck 时返回 nil
+// UpdateConfig 后重新开始探测，第一次探测结束前的状态为 health.Unknown
+func (pm *ProxyManager) Health() []health.Status {
+	return pm.health.Status()
+}
+
+// OnHealthChange 设置代理健康状态变化时的回调，可以用来记录日志或告警；回调在探测的 goroutine 中同步调用
+func (pm *ProxyManager) OnHealthChange(fn func(health.Status)) {
+	pm.onHealth.Store(&fn)
+}
+
+// healthChanged 把状态变化交给 OnHealthChange 设置的回调
+func (pm *ProxyManager) healthChanged(status health.Status) {
+	if fn := pm.onHealth.Load(); fn != nil && *fn != nil {
+		(*fn)(status)
+	}
+}
+
+// failoverFrom 启用 Failover 且 current(为 nil 时是主代理)不可用时，返回主代理和 Proxies 中第一个可用的代理，主代理返回 nil
+// 不需要或不能故障转移时 ok 为 false
+func (pm *ProxyManager) failoverFrom(current *namedProxy) (p *namedProxy, ok bool) {
+	check := pm.Config.HealthCheck
+	if pm.health == nil || check == nil || !check.Failover {
+		return nil, false
+	}
+	name := ""
+	if current != nil {
+		name = current.name
+	}
+	if pm.health.Up(name) {
+		return nil, false
+	}
+	if name != "" && pm.Config.ProxyType != C.Direct && pm.health.Up("") {
+		return nil, true
+	}
+	for _, np := range pm.Config.Proxies {
+		if np.Name != name && pm.health.Up(np.Name) {
+			return pm.named[np.Name], true
+		}
+	}
+	return nil, false
+}
@@ -1,21 +1,27 @@
 package proxy
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	C "github.com/ba0gu0/GoHookProxy/config"
 	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
 	"github.com/ba0gu0/GoHookProxy/metrics"
-
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+	"github.com/quic-go/quic-go"
 	"golang.org/x/net/http2"
 )
 
@@ -24,52 +30,87 @@ type HTTPProxyDialer struct {
 	proxyURL  *url.URL
 	proxyType C.ProxyType
 	dialer    *net.Dialer
-	tlsConfig *tls.Config
+	tlsConfig *lazy[*tls.Config] // 客户端证书和根证书在第一次握手时读取
 	Config    *C.HTTPConfig
-	metrics   *metrics.MetricsCollector
+	metrics   metrics.Recorder
+
+	// HTTP2 共享会话
+	h2mu        sync.Mutex
+	h2Transport *http.Transport
+	h2Window    uint32            // 当前会话使用的流窗口大小，0 表示默认值
+	h2UDPConn   *http2.ClientConn // connect-udp 共用的 HTTP/2 连接
+
+	// HTTP3 共享会话
+	h3mu        sync.Mutex
+	h3Transport *quic.Transport        // 所有 QUIC 会话共用的 UDP 套接字
+	h3Session   *http3Session          // 当前会话，断开后在下一次拨号时重建
+	h3Sessions  tls.ClientSessionCache // 会话票据，用于 0-RTT 重连
+
+	rtt  rttEstimator
+	obfs transport.Transport // 到代理的连接使用的传输插件，为 nil 时不使用
+
+	// HTTP/HTTPS 代理拒绝 CONNECT 后保持的已认证连接，之后的 CONNECT 优先使用
+	raw rawPool
+
+	// Negotiate 认证
+	negotiator       func() NegotiateProvider
+	negotiateOffered atomic.Bool // 代理在 407 中提供过 Negotiate，之后的 CONNECT 直接发送令牌
 }
 
+const (
+	defaultHTTP2StreamWindow = 4 << 20               // 传输层默认的流窗口
+	maxHTTP2StreamWindow     = 16 << 20              // 自动调优的窗口上限
+	http2MinActiveGap        = 50 * time.Millisecond // RTT 很小时仍视为持续传输的读取间隔
+	defaultHTTP2IdleTimeout  = 90 * time.Second
+
+	// maxDrainBody 拒绝 CONNECT 的响应体超过该大小时不复用连接
+	maxDrainBody = 64 << 10
+)
+
 // Dial 实现 ProxyDialer 接口
 func (d *HTTPProxyDialer) Dial(network, addr string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, addr)
 }
 
-// DialContext 实现 ProxyDialer 接口
+// DialContext 实现 ProxyDialer 接口，UDP 交给 DialPacketContext
 func (d *HTTPProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	start := time.Now()
-
-	// 记录总连接数
-	if d.metrics != nil {
-		d.metrics.RecordConnection(0) // 先记录连接,duration后面再更新
+	if rules.IsUDPNetwork(network) {
+		return d.DialPacketContext(ctx, network, addr)
 	}
-
-	if network != "tcp" && network != "tcp4" && network != "tcp6" {
-		return nil, errors.WrapError(errors.ErrUnsupportedProxy, fmt.Sprintf("unsupported network type: %s", network))
-	}
-
-	var conn net.Conn
-	var err error
-
-	select {
-	case <-ctx.Done():
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, errors.ErrContextDeadlineExceeded
+	return d.record(ctx, func() (net.Conn, error) {
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return nil, errors.WrapError(errors.ErrUnsupportedProxy, fmt.Sprintf("unsupported network type: %s", network))
 		}
-		return nil, errors.ErrContextCanceled
-	default:
-		switch d.proxyType {
-		case C.HTTP:
-			conn, err = d.dialHTTP(ctx, addr)
-		case C.HTTPS:
-			conn, err = d.dialHTTPS(ctx, addr)
-		case C.HTTP2:
-			conn, err = d.dialHTTP2(ctx, addr)
+
+		select {
+		case <-ctx.Done():
+			return nil, contextError(ctx)
 		default:
-			return nil, errors.WrapError(errors.ErrUnsupportedProxy, string(d.proxyType))
 		}
+		conn, err := d.dial(ctx, addr)
+		// 代理在 Negotiate 质询后关闭了连接，新连接上直接发送令牌
+		if err == errNegotiateReconnect {
+			conn, err = d.dial(ctx, addr)
+		}
+		return conn, err
+	})
+}
+
+// record 执行拨号并记录指标
+func (d *HTTPProxyDialer) record(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	start := time.Now()
+
+	// 记录总连接数
+	if d.metrics != nil {
+		d.metrics.RecordDial(0) // 先记录连接,duration后面再更新
 	}
 
+	conn, err := dial()
 	if err != nil {
+		// ctx 结束打断的握手按 ctx 的错误返回
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
 		if d.metrics != nil {
 			d.metrics.RecordFailure(err)
 		}
@@ -78,15 +119,41 @@ func (d *HTTPProxyDialer) DialContext(ctx context.Context, network, addr string)
 
 	if d.metrics != nil {
 		d.metrics.IncrementActiveConnections()
-		d.metrics.RecordConnection(time.Since(start))
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
 	}
 
 	return conn, nil
 }
 
-// dialHTTP 处理普通 HTTP 代理连接
+// dial 按代理类型建立到 addr 的隧道，ForwardPorts 中的端口不建立隧道而是转发请求
+func (d *HTTPProxyDialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if d.forwards(addr) {
+		return d.dialForward(ctx, addr)
+	}
+	switch d.proxyType {
+	case C.HTTP:
+		return d.dialHTTP(ctx, addr)
+	case C.HTTPS:
+		return d.dialHTTPS(ctx, addr)
+	case C.HTTP2:
+		return d.dialHTTP2(ctx, addr)
+	case C.HTTP3:
+		return d.dialHTTP3(ctx, addr, d.connectHTTP3)
+	default:
+		return nil, errors.WrapError(errors.ErrUnsupportedProxy, string(d.proxyType))
+	}
+}
+
+// dialHTTP 处理普通 HTTP 代理连接，有已认证的空闲连接时先在上面 CONNECT
 func (d *HTTPProxyDialer) dialHTTP(ctx context.Context, addr string) (net.Conn, error) {
+	creds := d.credentials(ctx)
+	if tunnel, ok, err := d.reuseRaw(ctx, addr, creds); ok {
+		return tunnel, err
+	}
+
 	// 建立 TCP 连接
+	stageStart := time.Now()
 	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyURL.Host)
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
@@ -94,23 +161,134 @@ func (d *HTTPProxyDialer) dialHTTP(ctx context.Context, addr string) (net.Conn,
 		}
 		return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
 	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	d.rtt.Observe(time.Since(stageStart))
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
+	return d.connectOver(ctx, guard, conn, addr, creds)
+}
+
+// reuseRaw 在已认证的空闲连接上 CONNECT，没有空闲连接、连接已失效或 ctx 指定了 ProxyAuthorizer 时 ok 为 false
+func (d *HTTPProxyDialer) reuseRaw(ctx context.Context, addr string, creds Credentials) (tunnel net.Conn, ok bool, err error) {
+	if _, ok := ProxyAuthorizerFromContext(ctx); ok {
+		return nil, false, nil
+	}
+	conn := d.raw.get(creds)
+	if conn == nil {
+		return nil, false, nil
+	}
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
+	tunnel, err = d.connectOver(ctx, guard, conn, addr, creds)
+	if err != nil && staleConnError(err) && ctx.Err() == nil {
+		return nil, false, nil
+	}
+	return tunnel, true, err
+}
 
+// connectOver 在到代理的连接上发送 CONNECT，guard 是调用方为握手设置的截止时间，失败时关闭连接
+// 代理拒绝 CONNECT 但保持连接时，连接放回 d.raw 供之后使用同样凭证的拨号使用
+func (d *HTTPProxyDialer) connectOver(ctx context.Context, guard *handshakeGuard, conn net.Conn, addr string, creds Credentials) (net.Conn, error) {
 	// 发送 CONNECT 请求
-	if err := d.sendConnectRequest(conn, addr); err != nil {
+	stageStart := time.Now()
+	tunnel, reusable, err := d.sendConnectRequest(ctx, conn, addr)
+	if err != nil {
+		_, authorized := ProxyAuthorizerFromContext(ctx)
+		if reusable && !authorized && guard.done(ctx) == nil {
+			d.raw.put(creds, conn)
+		} else {
+			conn.Close()
+		}
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
 		conn.Close()
 		return nil, err
 	}
-	return conn, nil
+	return tunnel, nil
+}
+
+// guardHandshake 为握手阶段设置截止时间，ctx 结束时打断握手
+func (d *HTTPProxyDialer) guardHandshake(ctx context.Context, conn net.Conn) *handshakeGuard {
+	deadline := handshakeDeadline(ctx, &d.rtt, d.Config.AdaptiveTimeout, d.Config.MinHandshakeTimeout, d.Config.Timeout)
+	return guardHandshake(ctx, conn, deadline)
+}
+
+// basicAuthorization 返回 Basic 认证的 Proxy-Authorization 头值
+func basicAuthorization(creds Credentials) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.User+":"+creds.Pass))
+}
+
+// proxyAuthorization 返回本次拨号发给代理的 Proxy-Authorization 头值，不需要认证时返回空字符串
+// ctx 中的 ProxyAuthorizer 优先于凭证，它返回的错误按 ErrHTTPProxyAuth 返回
+func (d *HTTPProxyDialer) proxyAuthorization(ctx context.Context) (string, error) {
+	if a, ok := ProxyAuthorizerFromContext(ctx); ok {
+		header, err := a.ProxyAuthorization(ctx, d.proxyURL.Host)
+		if err != nil {
+			return "", errors.WrapError(errors.ErrHTTPProxyAuth, err.Error())
+		}
+		return header, nil
+	}
+	if creds := d.credentials(ctx); creds.User != "" {
+		return basicAuthorization(creds), nil
+	}
+	return "", nil
+}
+
+// setProxyAuthorization 按 proxyAuthorization 设置请求的 Proxy-Authorization 头
+func (d *HTTPProxyDialer) setProxyAuthorization(ctx context.Context, req *http.Request) error {
+	header, err := d.proxyAuthorization(ctx)
+	if err != nil {
+		return err
+	}
+	if header != "" {
+		req.Header.Set("Proxy-Authorization", header)
+	}
+	return nil
+}
+
+// credentials 返回本次拨号使用的凭证，优先使用路由规则指定的凭证
+func (d *HTTPProxyDialer) credentials(ctx context.Context) Credentials {
+	return credentialsFromContext(ctx, Credentials{User: d.Config.User, Pass: d.Config.Pass})
+}
+
+// Close 关闭已认证的空闲连接、HTTP2 的空闲会话、connect-udp 的 HTTP2 连接和 HTTP3 的 QUIC 会话，之后的拨号会重新建立会话
+func (d *HTTPProxyDialer) Close() error {
+	d.h2mu.Lock()
+	if d.h2Transport != nil {
+		d.h2Transport.CloseIdleConnections()
+	}
+	d.h2mu.Unlock()
+	d.closeHTTP2UDP()
+	d.raw.close()
+	return d.closeHTTP3()
+}
+
+// SmoothedRTT 返回到代理的平滑 RTT 估计
+func (d *HTTPProxyDialer) SmoothedRTT() time.Duration {
+	return d.rtt.SRTT()
 }
 
-// dialHTTPS 处理 HTTPS 代理连接
+// dialHTTPS 处理 HTTPS 代理连接，有已认证的空闲连接时先在上面 CONNECT
 func (d *HTTPProxyDialer) dialHTTPS(ctx context.Context, addr string) (net.Conn, error) {
-	// 检查必要的配置
-	if d.tlsConfig == nil {
-		return nil, errors.WrapError(errors.ErrTLSConfig, "TLS configuration is missing")
+	creds := d.credentials(ctx)
+	if tunnel, ok, err := d.reuseRaw(ctx, addr, creds); ok {
+		return tunnel, err
+	}
+
+	// 证书在连接代理之前加载，读取失败时不浪费连接
+	tlsConfig, err := d.clientTLSConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	// 建立 TCP 连接
+	stageStart := time.Now()
 	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyURL.Host)
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
@@ -118,29 +296,29 @@ func (d *HTTPProxyDialer) dialHTTPS(ctx context.Context, addr string) (net.Conn,
 		}
 		return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
 	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	d.rtt.Observe(time.Since(stageStart))
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
 
-	// 确保连接在出错时被关闭
-	defer func() {
-		if err != nil {
-			conn.Close()
-		}
-	}()
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
 
-	// 克隆 TLS 配置以避免并发问题
-	tlsConfig := d.tlsConfig.Clone()
+	// CONNECT 以 HTTP/1.1 发送，http2 代理回退到这里时不能再提供 h2
+	tlsConfig.NextProtos = slices.DeleteFunc(slices.Clone(tlsConfig.NextProtos), func(proto string) bool { return proto == "h2" })
 
 	// 升级到 TLS
+	stageStart = time.Now()
 	tlsConn := tls.Client(conn, tlsConfig)
-	if err := tlsConn.HandshakeContext(ctx); err != nil {
-		return nil, errors.WrapError(errors.ErrTLSHandshake, err.Error())
-	}
-
-	// 发送 CONNECT 请求
-	if err := d.sendConnectRequest(tlsConn, addr); err != nil {
-		return nil, err
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %w", errors.ErrTLSHandshake, err)
 	}
+	recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
 
-	return tlsConn, nil
+	return d.connectOver(ctx, guard, tlsConn, addr, creds)
 }
 
 type http2Conn struct {
@@ -152,6 +330,15 @@ type http2Conn struct {
 	closed     chan struct{}
 	closeOnce  sync.Once
 	err        error
+
+	// 流控统计
+	dialer       *HTTPProxyDialer
+	start        time.Time
+	rtt          time.Duration // CONNECT 往返时间，用于估算 BDP
+	lastRead     int64         // 上次读到数据距 start 的时间(纳秒)
+	activeTime   int64         // 持续有数据到达的累计时间(纳秒)，不含空闲间隔
+	activeBytes  int64         // activeTime 内读到的字节数
+	writeBlocked int64         // 写入等待传输层取走数据的累计时间(纳秒)
 }
 
 func (c *http2Conn) closeWithError(err error) {
@@ -160,7 +347,26 @@ func (c *http2Conn) closeWithError(err error) {
 }
 
 func (c *http2Conn) Read(b []byte) (n int, err error) {
-	return c.stream.Read(b)
+	n, err = c.stream.Read(b)
+	if n > 0 {
+		c.markActive(n)
+	}
+	return n, err
+}
+
+// markActive 累计数据到达的活跃时间，与上次读到数据的间隔超过 http2ActiveGap 时视为空闲，不计入 BDP 估算
+func (c *http2Conn) markActive(n int) {
+	now := int64(time.Since(c.start))
+	last := atomic.SwapInt64(&c.lastRead, now)
+	if gap := now - last; last > 0 && gap <= int64(http2ActiveGap(c.rtt)) {
+		atomic.AddInt64(&c.activeTime, gap)
+		atomic.AddInt64(&c.activeBytes, int64(n))
+	}
+}
+
+// http2ActiveGap 读到数据的最大间隔，窗口受限时发送方每个 RTT 等待一次 WINDOW_UPDATE，更长的间隔是空闲
+func http2ActiveGap(rtt time.Duration) time.Duration {
+	return max(4*rtt, http2MinActiveGap)
 }
 
 func (c *http2Conn) Write(b []byte) (n int, err error) {
@@ -168,7 +374,11 @@ func (c *http2Conn) Write(b []byte) (n int, err error) {
 	case <-c.closed:
 		return 0, io.ErrClosedPipe
 	default:
-		return c.writer.Write(b)
+		// 管道写入阻塞到传输层取走数据，包括等待对端的流窗口、帧调度和套接字的写入背压
+		start := time.Now()
+		n, err = c.writer.Write(b)
+		atomic.AddInt64(&c.writeBlocked, int64(time.Since(start)))
+		return n, err
 	}
 }
 
@@ -180,6 +390,9 @@ func (c *http2Conn) Close() error {
 		}
 		c.reader.Close()
 		c.writer.Close()
+		if c.dialer != nil {
+			c.dialer.observeHTTP2Stream(c)
+		}
 	})
 	return nil
 }
@@ -197,31 +410,120 @@ func (c *http2Conn) SetWriteDeadline(t time.Time) error {
 	return &net.OpError{Op: "set", Net: "http2", Err: errors.ErrUnsupportedProxy}
 }
 
-// dialHTTP2 处理 HTTP2 代理连接
-func (d *HTTPProxyDialer) dialHTTP2(ctx context.Context, addr string) (net.Conn, error) {
-	transport := &http2.Transport{
-		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+// getHTTP2Transport 返回共享的 HTTP2 会话传输，窗口调优后会重建
+func (d *HTTPProxyDialer) getHTTP2Transport() *http.Transport {
+	d.h2mu.Lock()
+	defer d.h2mu.Unlock()
+
+	if d.h2Transport != nil {
+		return d.h2Transport
+	}
+
+	if d.h2Window == 0 {
+		d.h2Window = d.Config.InitialWindowSize
+	}
+
+	h2Config := &http.HTTP2Config{
+		MaxReadFrameSize: int(d.Config.MaxFrameSize),
+	}
+	if d.h2Window > 0 {
+		h2Config.MaxReceiveBufferPerStream = int(d.h2Window)
+	}
+
+	d.h2Transport = &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			tlsConfig, err := d.clientTLSConfig()
+			if err != nil {
+				return nil, err
+			}
+			stageStart := time.Now()
 			conn, err := d.dialer.DialContext(ctx, network, d.proxyURL.Host)
 			if err != nil {
 				return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
 			}
+			recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+			d.rtt.Observe(time.Since(stageStart))
+			if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+				return nil, err
+			}
 
-			tlsConfig := cfg.Clone()
-			tlsConfig.NextProtos = []string{"h2"}
+			stageStart = time.Now()
 			tlsConn := tls.Client(conn, tlsConfig)
 			if err := tlsConn.HandshakeContext(ctx); err != nil {
 				conn.Close()
-				return nil, errors.WrapError(errors.ErrTLSHandshake, err.Error())
+				return nil, fmt.Errorf("%w: %w", errors.ErrTLSHandshake, err)
+			}
+			recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+
+			// 代理没有协商 h2 时记录下来，之后改用 TLS 上的 HTTP/1.1 CONNECT
+			h2 := tlsConn.ConnectionState().NegotiatedProtocol == "h2"
+			capabilities.record(d.proxyURL.Host, CapHTTP2Connect, h2)
+			if !h2 {
+				tlsConn.Close()
+				return nil, errors.WrapError(errors.ErrProxyProtocol, "proxy did not negotiate h2")
 			}
 			return tlsConn, nil
 		},
-		TLSClientConfig: d.tlsConfig,
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   defaultHTTP2IdleTimeout,
+		HTTP2:             h2Config,
+	}
+
+	if mc := collectorOf(d.metrics); mc != nil {
+		mc.SetHTTP2Window(d.h2Window)
+	}
+	return d.h2Transport
+}
+
+// observeHTTP2Stream 记录流控指标，并根据观测到的 BDP 调整后续会话的窗口大小
+func (d *HTTPProxyDialer) observeHTTP2Stream(c *http2Conn) {
+	blocked := time.Duration(atomic.LoadInt64(&c.writeBlocked))
+	if mc := collectorOf(d.metrics); mc != nil {
+		mc.RecordHTTP2Stream(blocked)
 	}
 
-	client := &http.Client{
-		Transport: transport,
+	if !d.Config.AutoTuneWindow || c.rtt <= 0 {
+		return
 	}
 
+	// 只用持续传输的区间估算吞吐量，突发或长时间保持的流中的空闲不拉低 BDP
+	active := time.Duration(atomic.LoadInt64(&c.activeTime))
+	activeBytes := atomic.LoadInt64(&c.activeBytes)
+	if active <= 0 || activeBytes == 0 {
+		return
+	}
+
+	// BDP = 吞吐量 * RTT，窗口取两倍 BDP 以留出余量
+	bdp := float64(activeBytes) / active.Seconds() * c.rtt.Seconds()
+	target := uint32(math.Min(bdp*2, maxHTTP2StreamWindow))
+
+	d.h2mu.Lock()
+	current := d.h2Window
+	if current == 0 {
+		current = defaultHTTP2StreamWindow
+	}
+	if target <= current {
+		d.h2mu.Unlock()
+		return
+	}
+	old := d.h2Transport
+	d.h2Window = target
+	d.h2Transport = nil
+	d.h2mu.Unlock()
+
+	// 旧会话上的流继续使用原连接，空闲后关闭
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+}
+
+// dialHTTP2 处理 HTTP2 代理连接，代理不支持 h2 时改用 TLS 上的 HTTP/1.1 CONNECT
+func (d *HTTPProxyDialer) dialHTTP2(ctx context.Context, addr string) (net.Conn, error) {
+	if capabilities.unsupported(d.proxyURL.Host, CapHTTP2Connect) {
+		return d.dialHTTPS(ctx, addr)
+	}
+	transport := d.getHTTP2Transport()
+
 	pr, pw := io.Pipe()
 	req, err := http.NewRequestWithContext(ctx, http.MethodConnect,
 		fmt.Sprintf("https://%s", d.proxyURL.Host), pr)
@@ -229,20 +531,27 @@ func (d *HTTPProxyDialer) dialHTTP2(ctx context.Context, addr string) (net.Conn,
 		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
 	}
 
-	req.Host = addr
-	if d.Config.User != "" {
-		req.SetBasicAuth(d.Config.User, d.Config.Pass)
+	req.Host = hostport.Canonical(addr)
+	if err := d.setProxyAuthorization(ctx, req); err != nil {
+		pw.Close()
+		return nil, err
 	}
 
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
 	if err != nil {
+		if capabilities.unsupported(d.proxyURL.Host, CapHTTP2Connect) {
+			return d.dialHTTPS(ctx, addr)
+		}
 		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, errors.WrapError(errors.ErrProxyProtocol, resp.Status)
+		return nil, connectStatusError(resp)
 	}
+	// 复用会话时只包含 CONNECT 往返，新建会话时还包含建立会话的时间
+	recordStage(d.metrics, metrics.StageProxyHandshake, start)
 
 	return &http2Conn{
 		reader:     pr,
@@ -251,52 +560,126 @@ func (d *HTTPProxyDialer) dialHTTP2(ctx context.Context, addr string) (net.Conn,
 		localAddr:  &net.TCPAddr{IP: net.IPv4zero, Port: 0},
 		remoteAddr: &net.TCPAddr{IP: net.IPv4zero, Port: 0},
 		closed:     make(chan struct{}),
+		dialer:     d,
+		start:      time.Now(),
+		rtt:        time.Since(start),
 	}, nil
 }
 
 // sendConnectRequest 发送 CONNECT 请并处理响应
-func (d *HTTPProxyDialer) sendConnectRequest(conn net.Conn, addr string) error {
-	req := &http.Request{
-		Method: "CONNECT",
-		URL:    &url.URL{Host: addr},
-		Host:   addr,
-		Header: make(http.Header),
+// IPv6 目标按 [addr]:port 发送，IPv4-mapped 地址按 IPv4 发送
+// 代理返回 407 并提供 Negotiate 时，在同一连接上用 SPNEGO 令牌重试
+// 返回的连接包含代理紧跟在响应后发送的目标数据；代理拒绝 CONNECT 但连接可以继续发送请求时 reusable 为 true
+func (d *HTTPProxyDialer) sendConnectRequest(ctx context.Context, conn net.Conn, addr string) (tunnel net.Conn, reusable bool, err error) {
+	addr = hostport.Canonical(addr)
+	provider := d.negotiateProvider()
+	if _, ok := ProxyAuthorizerFromContext(ctx); ok {
+		// 调用方指定的头被拒绝时不改用 Negotiate
+		provider = nil
 	}
+	negotiate := provider != nil && d.negotiateOffered.Load()
+	hc := newHandshakeConn(conn)
+
+	var challenge []byte
+	for leg := 1; ; leg++ {
+		req := &http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Host: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if err := d.authorize(ctx, req, negotiate, challenge); err != nil {
+			return nil, false, err
+		}
+
+		if err := req.Write(conn); err != nil {
+			return nil, false, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+		}
+
+		resp, err := http.ReadResponse(hc.br, req)
+		if err != nil {
+			return nil, false, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+		}
 
-	if d.Config.User != "" {
-		req.SetBasicAuth(d.Config.User, d.Config.Pass)
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			offered, token := negotiateChallenge(resp.Header)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			// 已发送的令牌被拒绝且没有后续质询时认证失败
+			if !offered || provider == nil || (negotiate && token == nil) || leg >= maxNegotiateLegs {
+				return nil, false, errors.ErrHTTPProxyAuth
+			}
+			d.negotiateOffered.Store(true)
+			if resp.Close {
+				return nil, false, errNegotiateReconnect
+			}
+			negotiate, challenge = true, token
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			// 代理拒绝目标但保持连接时，连接停在请求边界上，可以用于下一个 CONNECT
+			reusable = !resp.Close && drainBody(resp.Body) && hc.br.Buffered() == 0
+			resp.Body.Close()
+			return nil, reusable, connectStatusError(resp)
+		}
+		resp.Body.Close()
+		return hc.tunnel(), false, nil
 	}
+}
+
+// drainBody 读取并丢弃不超过 maxDrainBody 的响应体，返回是否读到了结尾
+func drainBody(body io.Reader) bool {
+	n, err := io.CopyN(io.Discard, body, maxDrainBody+1)
+	return err == io.EOF && n <= maxDrainBody
+}
 
-	if err := req.Write(conn); err != nil {
-		return errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+// connectStatusError 返回 CONNECT 失败响应对应的错误
+// 403 和 451 表示代理按策略拒绝目标，错误同时匹配 ErrProxyForbidden，重试同一目标会得到相同的结果
+func connectStatusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnavailableForLegalReasons {
+		return fmt.Errorf("%w: %w: %s", errors.ErrProxyProtocol, errors.ErrProxyForbidden, resp.Status)
 	}
+	return errors.WrapError(errors.ErrProxyProtocol, resp.Status)
+}
 
-	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+// clientTLSConfig 返回与代理握手使用的 TLS 配置的副本，第一次调用时读取证书文件，读取失败时返回 ErrCertValidation
+func (d *HTTPProxyDialer) clientTLSConfig() (*tls.Config, error) {
+	if d.tlsConfig == nil {
+		return nil, errors.WrapError(errors.ErrTLSConfig, "TLS configuration is missing")
+	}
+	tlsConfig, err := d.tlsConfig.get()
 	if err != nil {
-		return errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return tlsConfig.Clone(), nil
+}
 
-	if resp.StatusCode == http.StatusProxyAuthRequired {
-		return errors.ErrHTTPProxyAuth
+// newTLSConfig 创建与代理握手的 TLS 配置，certFile 和 keyFile 都设置时加载客户端证书
+// HTTPS/HTTP2/HTTP3 和 TLS 上的 SOCKS 共用
+func newTLSConfig(minVersion uint16, skipVerify bool, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		InsecureSkipVerify: skipVerify,
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.WrapError(errors.ErrProxyProtocol, resp.Status)
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.WrapError(errors.ErrCertValidation, err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-
-	return nil
+	return tlsConfig, nil
 }
 
 // createHTTPProxyDialer 创建 HTTP 代理拨号器
-func createHTTPProxyDialer(proxyType C.ProxyType, ip string, port int, config *C.HTTPConfig, metrics *metrics.MetricsCollector) (ProxyDialer, error) {
+func createHTTPProxyDialer(proxyType C.ProxyType, ip string, port int, config *C.HTTPConfig, metrics metrics.Recorder) (ProxyDialer, error) {
 	if config == nil {
 		config = C.DefaultHTTPConfig()
 	}
 
 	proxyURL := &url.URL{
 		Scheme: string(proxyType),
-		Host:   fmt.Sprintf("%s:%d", ip, port),
+		Host:   hostport.Join(ip, port),
 	}
 
 	// 设置认证信息
@@ -304,21 +687,24 @@ func createHTTPProxyDialer(proxyType C.ProxyType, ip string, port int, config *C
 		proxyURL.User = url.UserPassword(config.User, config.Pass)
 	}
 
-	// 配置 TLS
-	tlsConfig := &tls.Config{
-		MinVersion:         config.TLSMinVersion,
-		InsecureSkipVerify: config.SkipVerify,
-		NextProtos:         []string{"h2", "http/1.1"}, // 支持 HTTP2
+	// 配置 TLS，ALPN 未设置时按代理类型使用默认协议
+	nextProtos := config.NextProtos
+	if len(nextProtos) == 0 {
+		nextProtos = C.DefaultNextProtos(proxyType)
 	}
-
-	// 加载证书
-	if config.CertFile != "" && config.KeyFile != "" {
-		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	// 证书文件在第一次握手时读取，只用明文连接代理时不需要
+	tlsConfig := newLazy(func() (*tls.Config, error) {
+		tlsConfig, err := newTLSConfig(config.TLSMinVersion, config.SkipVerify, config.CertFile, config.KeyFile)
 		if err != nil {
-			return nil, errors.WrapError(errors.ErrCertValidation, err.Error())
+			return nil, err
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
-	}
+		tlsConfig.NextProtos = slices.Clone(nextProtos)
+		tlsConfig.ServerName = ip
+		if err := applyPeerVerification(tlsConfig, config); err != nil {
+			return nil, err
+		}
+		return tlsConfig, nil
+	})
 
 	return &HTTPProxyDialer{
 		proxyURL:  proxyURL,
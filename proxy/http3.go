@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Session 共享的 QUIC 连接及其上的 HTTP/3 客户端
+type http3Session struct {
+	conn   *quic.Conn
+	client *http3.ClientConn
+	early  bool // 以 0-RTT 建立，握手完成前发出的请求可能被服务端拒绝
+}
+
+// http3Conn HTTP/3 CONNECT 建立的隧道，数据以 DATA 帧在请求流上收发
+type http3Conn struct {
+	*http3.RequestStream
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	closeOnce  sync.Once
+}
+
+func (c *http3Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+		c.RequestStream.Close()
+	})
+	return nil
+}
+
+// CloseWrite 结束请求流的发送方向，对端读到 EOF
+func (c *http3Conn) CloseWrite() error {
+	return c.RequestStream.Close()
+}
+
+func (c *http3Conn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *http3Conn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// getHTTP3Session 返回共享的 QUIC 会话，会话已断开时重新建立
+// Enable0RTT 时用缓存的会话票据以 0-RTT 重连，full 为 true 时使用完整握手
+func (d *HTTPProxyDialer) getHTTP3Session(ctx context.Context, full bool) (*http3Session, error) {
+	d.h3mu.Lock()
+	defer d.h3mu.Unlock()
+
+	if s := d.h3Session; s != nil {
+		if s.conn.Context().Err() == nil {
+			return s, nil
+		}
+		d.h3Session = nil
+	}
+
+	if d.h3Transport == nil {
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
+		}
+		d.h3Transport = &quic.Transport{Conn: udpConn}
+	}
+	if d.h3Sessions == nil {
+		d.h3Sessions = tls.NewLRUClientSessionCache(0)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", d.proxyURL.Host)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrProxyDialFailed, err.Error())
+	}
+
+	tlsConfig, err := d.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientSessionCache = d.h3Sessions
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = d.proxyURL.Hostname()
+	}
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout: d.Config.Timeout,
+		KeepAlivePeriod:      d.Config.KeepAlive,
+		EnableDatagrams:      true, // connect-udp 的 HTTP 数据报
+	}
+
+	stageStart := time.Now()
+	var conn *quic.Conn
+	if d.Config.Enable0RTT && !full {
+		conn, err = d.h3Transport.DialEarly(ctx, udpAddr, tlsConfig, quicConfig)
+	} else {
+		conn, err = d.h3Transport.Dial(ctx, udpAddr, tlsConfig, quicConfig)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.ErrConnectionTimeout
+		}
+		return nil, fmt.Errorf("%w: %w", errors.ErrTLSHandshake, err)
+	}
+
+	s := &http3Session{conn: conn, client: (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn)}
+	select {
+	case <-conn.HandshakeComplete():
+		// 0-RTT 时握手和第一个 CONNECT 并行，握手耗时计入 CONNECT 阶段
+		recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+		d.observeHTTP3Session(s)
+	default:
+		s.early = true
+	}
+	d.h3Session = s
+	return s, nil
+}
+
+// observeHTTP3Session 在握手完成后记录 RTT 和是否使用了 0-RTT
+func (d *HTTPProxyDialer) observeHTTP3Session(s *http3Session) {
+	d.rtt.Observe(s.conn.ConnectionStats().SmoothedRTT)
+	if mc := collectorOf(d.metrics); mc != nil {
+		mc.RecordHTTP3Session(s.conn.ConnectionState().Used0RTT)
+	}
+}
+
+// dropHTTP3Session 关闭已失效的会话，会话已被替换时只关闭
+func (d *HTTPProxyDialer) dropHTTP3Session(s *http3Session) {
+	d.h3mu.Lock()
+	if d.h3Session == s {
+		d.h3Session = nil
+	}
+	d.h3mu.Unlock()
+	s.conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+}
+
+// dialHTTP3 在共享的 QUIC 会话上用 connect 发送 CONNECT 或 connect-udp，每个连接对应一个请求流
+// 会话已失效或 0-RTT 被拒绝时丢弃会话，以完整握手重连一次
+func (d *HTTPProxyDialer) dialHTTP3(ctx context.Context, addr string, connect func(context.Context, *http3Session, string) (net.Conn, error)) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		s, err := d.getHTTP3Session(ctx, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := connect(ctx, s, addr)
+		if err == nil {
+			d.h3mu.Lock()
+			early := s.early
+			s.early = false
+			d.h3mu.Unlock()
+			if early {
+				// 收到 CONNECT 响应时握手已经完成
+				d.observeHTTP3Session(s)
+			}
+			return conn, nil
+		}
+
+		if attempt > 0 || ctx.Err() != nil || !d.http3SessionFailed(s) {
+			return nil, err
+		}
+		d.dropHTTP3Session(s)
+	}
+}
+
+// http3SessionFailed 判断请求失败是否由会话引起: 会话已断开，或 0-RTT 被服务端拒绝
+func (d *HTTPProxyDialer) http3SessionFailed(s *http3Session) bool {
+	if s.conn.Context().Err() != nil {
+		return true
+	}
+	d.h3mu.Lock()
+	early := s.early
+	d.h3mu.Unlock()
+	if !early {
+		return false
+	}
+	select {
+	case <-s.conn.HandshakeComplete():
+		return !s.conn.ConnectionState().Used0RTT
+	default:
+		return false
+	}
+}
+
+// connectHTTP3 打开请求流并完成 CONNECT
+func (d *HTTPProxyDialer) connectHTTP3(ctx context.Context, s *http3Session, addr string) (net.Conn, error) {
+	str, err := s.client.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+	}
+	conn := &http3Conn{
+		RequestStream: str,
+		localAddr:     s.conn.LocalAddr(),
+		remoteAddr:    s.conn.RemoteAddr(),
+	}
+	guard := d.guardHandshake(ctx, conn)
+	defer guard.stop()
+
+	addr = hostport.Canonical(addr)
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "https://"+addr, nil)
+	if err != nil {
+		conn.Close()
+		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+	}
+	req.Host = addr
+	if err := d.setProxyAuthorization(ctx, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	stageStart := time.Now()
+	if err := str.SendRequestHeader(req); err != nil {
+		conn.Close()
+		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		conn.Close()
+		return nil, errors.WrapError(errors.ErrProxyNegotiation, err.Error())
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		conn.Close()
+		return nil, errors.ErrHTTPProxyAuth
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, connectStatusError(resp)
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+
+	if err := guard.done(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// closeHTTP3 关闭 QUIC 会话和 UDP 套接字
+func (d *HTTPProxyDialer) closeHTTP3() error {
+	d.h3mu.Lock()
+	defer d.h3mu.Unlock()
+	if d.h3Session != nil {
+		d.h3Session.conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+		d.h3Session = nil
+	}
+	if d.h3Transport == nil {
+		return nil
+	}
+	err := d.h3Transport.Close()
+	d.h3Transport.Conn.Close()
+	d.h3Transport = nil
+	return err
+}
@@ -0,0 +1,556 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/hysteria2"
+	"github.com/ba0gu0/GoHookProxy/rules"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// hysteria2QueueSize 每个 UDP 会话等待读取的数据报数，读取跟不上时丢弃新数据报
+const hysteria2QueueSize = 128
+
+// Hysteria2Dialer 通过 Hysteria 2 协议拨号，所有连接共用一个认证过的 QUIC 连接
+// TCP 连接是 QUIC 流，UDP 数据报以 QUIC 数据报传输；目标主机名由服务器解析
+type Hysteria2Dialer struct {
+	addr       string
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	Config     *C.Hysteria2Config
+	metrics    metrics.Recorder
+
+	mu        sync.Mutex
+	transport *quic.Transport
+	session   *hysteria2Session
+}
+
+// hysteria2Session 认证过的 QUIC 连接及其上的 UDP 会话
+type hysteria2Session struct {
+	conn *quic.Conn
+	udp  bool // 服务器允许 UDP 转发
+
+	mu     sync.Mutex
+	nextID uint32
+	udps   map[uint32]*hysteria2UDPConn
+}
+
+func createHysteria2Dialer(proxyIP string, proxyPort int, config *C.Hysteria2Config, metrics metrics.Recorder) (ProxyDialer, error) {
+	return NewHysteria2Dialer(hostport.Join(proxyIP, proxyPort), config, metrics), nil
+}
+
+// NewHysteria2Dialer 创建 Hysteria2 拨号器，QUIC 连接在第一次拨号时建立并认证
+func NewHysteria2Dialer(addr string, config *C.Hysteria2Config, metrics metrics.Recorder) *Hysteria2Dialer {
+	if config == nil {
+		config = C.DefaultHysteria2Config()
+	}
+	serverName := hostport.Host(addr)
+	if config.SNI != "" {
+		serverName = config.SNI
+	}
+	streamWindow := config.StreamReceiveWindow
+	if streamWindow == 0 {
+		streamWindow = C.DefaultHysteria2StreamReceiveWindow
+	}
+	connWindow := config.ConnReceiveWindow
+	if connWindow == 0 {
+		connWindow = C.DefaultHysteria2ConnReceiveWindow
+	}
+	return &Hysteria2Dialer{
+		addr: addr,
+		tlsConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: config.SkipVerify,
+			NextProtos:         []string{http3.NextProtoH3},
+		},
+		quicConfig: &quic.Config{
+			HandshakeIdleTimeout:           config.Timeout,
+			MaxIdleTimeout:                 config.IdleTimeout,
+			KeepAlivePeriod:                config.KeepAlive,
+			InitialStreamReceiveWindow:     streamWindow,
+			MaxStreamReceiveWindow:         streamWindow,
+			InitialConnectionReceiveWindow: connWindow,
+			MaxConnectionReceiveWindow:     connWindow,
+			EnableDatagrams:                true,
+		},
+		Config:  config,
+		metrics: metrics,
+	}
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *Hysteria2Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 在共用的 QUIC 连接上打开到 addr 的流，UDP 交给 DialPacketContext
+func (d *Hysteria2Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if rules.IsUDPNetwork(network) {
+		return d.DialPacketContext(ctx, network, addr)
+	}
+	return d.record(ctx, func() (net.Conn, error) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return nil, E.WrapError(E.ErrUnsupportedProxy, "hysteria2: unsupported network "+network)
+		}
+		return d.dial(ctx, addr)
+	})
+}
+
+// DialPacketContext 建立到 addr 的 UDP 会话，实现 PacketDialer
+func (d *Hysteria2Dialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.record(ctx, func() (net.Conn, error) {
+		return d.dialPacket(ctx, network, addr)
+	})
+}
+
+// ListenPacket 建立不固定目标的 UDP 会话，实现 PacketDialer
+// 每个数据报通过 WriteTo 指定目标，ReadFrom 返回服务器报告的来源地址
+func (d *Hysteria2Dialer) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	conn, err := d.record(ctx, func() (net.Conn, error) {
+		return d.dialPacket(ctx, network, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*hysteria2UDPConn), nil
+}
+
+// record 执行拨号并记录指标
+func (d *Hysteria2Dialer) record(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+// dial 打开流并发送 TCPRequest，QUIC 连接已断开时重建一次
+func (d *Hysteria2Dialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		s, err := d.getSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := d.openStream(ctx, s, addr)
+		if err == nil || attempt > 0 || ctx.Err() != nil || s.conn.Context().Err() == nil {
+			return conn, err
+		}
+		d.dropSession(s)
+	}
+}
+
+// openStream 在会话上打开到 addr 的流，等待服务器的 TCPResponse
+func (d *Hysteria2Dialer) openStream(ctx context.Context, s *hysteria2Session, addr string) (net.Conn, error) {
+	str, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+	conn := &hysteria2Conn{Stream: str, local: s.conn.LocalAddr(), remote: s.conn.RemoteAddr()}
+
+	stageStart := time.Now()
+	deadline := handshakeDeadline(ctx, nil, false, 0, d.Config.Timeout)
+	guard := guardHandshake(ctx, conn, deadline)
+	defer guard.stop()
+
+	if _, err := str.Write(hysteria2.AppendTCPRequest(nil, hostport.Canonical(addr))); err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+	if err := hysteria2.ReadTCPResponse(str); err != nil {
+		conn.Close()
+		if errors.Is(err, E.ErrHysteria2Rejected) || errors.Is(err, E.ErrHysteria2Message) {
+			return nil, err
+		}
+		return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// getSession 返回认证过的 QUIC 连接，连接已断开时重新建立并认证
+func (d *Hysteria2Dialer) getSession(ctx context.Context) (*hysteria2Session, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if s := d.session; s != nil {
+		if s.conn.Context().Err() == nil {
+			return s, nil
+		}
+		d.session = nil
+	}
+
+	if d.transport == nil {
+		// 直接创建的 UDP 套接字不经过 hook
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+		}
+		d.transport = &quic.Transport{Conn: udpConn}
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", d.addr)
+	if err != nil {
+		return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+
+	if d.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Config.Timeout)
+		defer cancel()
+	}
+
+	stageStart := time.Now()
+	conn, err := d.transport.Dial(ctx, udpAddr, d.tlsConfig.Clone(), d.quicConfig)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, E.ErrConnectionTimeout
+		}
+		return nil, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+
+	stageStart = time.Now()
+	udp, err := d.authenticate(ctx, conn)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+
+	s := &hysteria2Session{conn: conn, udp: udp, udps: make(map[uint32]*hysteria2UDPConn)}
+	if udp {
+		go d.receive(s)
+	}
+	d.session = s
+	return s, nil
+}
+
+// authenticate 发送 HTTP/3 认证请求，返回服务器是否允许 UDP 转发
+func (d *Hysteria2Dialer) authenticate(ctx context.Context, conn *quic.Conn) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hysteria2.AuthURL, nil)
+	if err != nil {
+		return false, E.WrapError(E.ErrHysteria2Auth, err.Error())
+	}
+	req.Header.Set(hysteria2.HeaderAuth, d.Config.Password)
+	req.Header.Set(hysteria2.HeaderCCRX, strconv.FormatUint(uint64(d.Config.DownMbps)*1000000/8, 10))
+	req.Header.Set(hysteria2.HeaderPadding, hysteria2.Padding(256, 2048))
+
+	resp, err := (&http3.Transport{}).NewClientConn(conn).RoundTrip(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return false, E.ErrConnectionTimeout
+		}
+		return false, E.WrapError(E.ErrHysteria2ProxyUnreachable, err.Error())
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != hysteria2.StatusAuthOK {
+		return false, E.WrapError(E.ErrHysteria2Auth, "status "+strconv.Itoa(resp.StatusCode))
+	}
+	return resp.Header.Get(hysteria2.HeaderUDP) == "true", nil
+}
+
+// dropSession 关闭已失效的会话，会话已被替换时只关闭
+func (d *Hysteria2Dialer) dropSession(s *hysteria2Session) {
+	d.mu.Lock()
+	if d.session == s {
+		d.session = nil
+	}
+	d.mu.Unlock()
+	s.conn.CloseWithError(0, "")
+}
+
+// Close 关闭 QUIC 连接和其上的所有流与 UDP 会话
+func (d *Hysteria2Dialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session != nil {
+		d.session.conn.CloseWithError(0, "")
+		d.session = nil
+	}
+	if d.transport != nil {
+		err := d.transport.Close()
+		d.transport = nil
+		return err
+	}
+	return nil
+}
+
+// hysteria2Conn 代理 TCP 连接的 QUIC 流
+type hysteria2Conn struct {
+	*quic.Stream
+	local     net.Addr
+	remote    net.Addr
+	closeOnce sync.Once
+}
+
+func (c *hysteria2Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.CancelRead(0)
+		c.Stream.Close()
+	})
+	return nil
+}
+
+// CloseWrite 结束流的发送方向，服务器读到 EOF
+func (c *hysteria2Conn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+func (c *hysteria2Conn) LocalAddr() net.Addr  { return c.local }
+func (c *hysteria2Conn) RemoteAddr() net.Addr { return c.remote }
+
+// dialPacket 在会话上注册新的 UDP 会话，target 为空时不设置默认目标
+func (d *Hysteria2Dialer) dialPacket(ctx context.Context, network, target string) (*hysteria2UDPConn, error) {
+	if !rules.IsUDPNetwork(network) {
+		return nil, E.WrapError(E.ErrUnsupportedProxy, "hysteria2: unsupported network "+network)
+	}
+	s, err := d.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !s.udp {
+		return nil, E.ErrHysteria2UDPDisabled
+	}
+
+	c := &hysteria2UDPConn{
+		session: s,
+		recv:    make(chan *hysteria2.UDPMessage, hysteria2QueueSize),
+		closed:  make(chan struct{}),
+		counter: collectorOf(d.metrics).UDP().Child(),
+	}
+	if target != "" {
+		c.target = hostport.Canonical(target)
+	}
+	s.mu.Lock()
+	s.nextID++
+	c.id = s.nextID
+	s.udps[c.id] = c
+	s.mu.Unlock()
+	c.counter.AddAssociation()
+	return c, nil
+}
+
+// receive 读取会话的 QUIC 数据报并分发给 UDP 会话，连接断开时关闭所有 UDP 会话
+func (d *Hysteria2Dialer) receive(s *hysteria2Session) {
+	defrag := make(map[uint32]*hysteria2.Defragger)
+	for {
+		b, err := s.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			break
+		}
+		m, err := hysteria2.ParseUDPMessage(b)
+		if err != nil {
+			collectorOf(d.metrics).UDP().AddHeaderError()
+			continue
+		}
+		s.mu.Lock()
+		c := s.udps[m.SessionID]
+		s.mu.Unlock()
+		if c == nil {
+			delete(defrag, m.SessionID)
+			continue
+		}
+		if m.FragCount > 1 {
+			if defrag[m.SessionID] == nil {
+				defrag[m.SessionID] = &hysteria2.Defragger{}
+			}
+			if m = defrag[m.SessionID].Feed(m); m == nil {
+				continue
+			}
+		}
+		select {
+		case c.recv <- m:
+		default:
+			c.counter.AddQueueDrop()
+		}
+	}
+
+	s.mu.Lock()
+	udps := s.udps
+	s.udps = make(map[uint32]*hysteria2UDPConn)
+	s.mu.Unlock()
+	for _, c := range udps {
+		c.counter.AddRelayReset()
+		c.Close()
+	}
+}
+
+// hysteria2UDPConn Hysteria2 UDP 会话，同时实现 net.Conn 和 net.PacketConn
+type hysteria2UDPConn struct {
+	session *hysteria2Session
+	id      uint32
+	target  string // DialPacketContext 的默认目标
+	counter *metrics.UDPCounter
+
+	packetID     atomic.Uint32
+	recv         chan *hysteria2.UDPMessage
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline atomic.Pointer[time.Time]
+}
+
+func (c *hysteria2UDPConn) Write(b []byte) (int, error) {
+	if c.target == "" {
+		return 0, E.ErrHysteria2NoTarget
+	}
+	return c.send(b, c.target)
+}
+
+// WriteTo 向 addr 发送数据报，addr 为域名时由服务器解析
+func (c *hysteria2UDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.send(b, addr.String())
+}
+
+// send 发送一个数据报，超过 QUIC 数据报上限时拆成分片
+func (c *hysteria2UDPConn) send(b []byte, addr string) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	m := &hysteria2.UDPMessage{
+		SessionID: c.id,
+		PacketID:  uint16(c.packetID.Add(1)),
+		FragCount: 1,
+		Addr:      addr,
+		Data:      b,
+	}
+	err := c.session.conn.SendDatagram(m.Append(nil))
+	var tooLarge *quic.DatagramTooLargeError
+	if errors.As(err, &tooLarge) {
+		frags := hysteria2.Fragment(m, int(tooLarge.MaxDatagramPayloadSize)-hysteria2.PacketOverhead)
+		if frags == nil {
+			c.counter.AddOversizedDrop()
+			return 0, E.WrapError(E.ErrHysteria2Message, "datagram too large")
+		}
+		for _, frag := range frags {
+			if err = c.session.conn.SendDatagram(frag.Append(nil)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.counter.AddPacketSent()
+	return len(b), nil
+}
+
+func (c *hysteria2UDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// ReadFrom 读取一个数据报，返回服务器报告的来源地址
+func (c *hysteria2UDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var timeout <-chan time.Time
+	if deadline := c.readDeadline.Load(); deadline != nil && !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(*deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case m := <-c.recv:
+		c.counter.AddPacketReceived()
+		var from net.Addr = SocksAddr(m.Addr)
+		if ap, err := netip.ParseAddrPort(m.Addr); err == nil {
+			from = net.UDPAddrFromAddrPort(ap)
+		}
+		n := copy(b, m.Data)
+		if n < len(m.Data) {
+			c.counter.AddOversizedDrop()
+			return n, from, io.ErrShortBuffer
+		}
+		return n, from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Close 注销 UDP 会话，QUIC 连接继续供其他连接使用
+func (c *hysteria2UDPConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.session.mu.Lock()
+		delete(c.session.udps, c.id)
+		c.session.mu.Unlock()
+	})
+	return nil
+}
+
+// Stats 返回本会话的数据报统计
+func (c *hysteria2UDPConn) Stats() metrics.UDPStats {
+	return c.counter.Stats()
+}
+
+func (c *hysteria2UDPConn) LocalAddr() net.Addr { return c.session.conn.LocalAddr() }
+
+// RemoteAddr 返回默认目标，没有默认目标时返回服务器地址
+func (c *hysteria2UDPConn) RemoteAddr() net.Addr {
+	if c.target == "" {
+		return c.session.conn.RemoteAddr()
+	}
+	if ap, err := netip.ParseAddrPort(c.target); err == nil {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return SocksAddr(c.target)
+}
+
+func (c *hysteria2UDPConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline 设置读取截止时间，在下一次读取时生效
+func (c *hysteria2UDPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	return nil
+}
+
+// SetWriteDeadline 数据报发送不会阻塞，截止时间没有作用
+func (c *hysteria2UDPConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
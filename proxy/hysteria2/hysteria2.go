@@ -0,0 +1,253 @@
+// Package hysteria2 Hysteria 2 协议的认证头和消息编解码，拨号器和测试服务共用
+//
+// 客户端在 QUIC 连接上先以 HTTP/3 请求 POST https://hysteria/auth 认证，服务器返回状态码 233 表示成功。
+// 之后每个 TCP 连接是一个双向流，流的开头是 TCPRequest，服务器回应 TCPResponse 后开始转发数据:
+//
+//	TCPRequest:  varint 0x401 | varint 地址长度 | 地址 | varint 填充长度 | 填充
+//	TCPResponse: uint8 状态   | varint 消息长度 | 消息 | varint 填充长度 | 填充
+//
+// UDP 数据报以 QUIC 数据报传输，超过数据报上限的负载拆成多个分片:
+//
+//	UDPMessage: uint32 会话 ID | uint16 包 ID | uint8 分片 ID | uint8 分片数 | varint 地址长度 | 地址 | 负载
+//
+// 地址为 host:port，主机名由服务器解析；varint 为 QUIC 变长整数
+package hysteria2
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"strconv"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+const (
+	// AuthURL 认证请求的地址，主机名固定为 hysteria
+	AuthURL = "https://hysteria/auth"
+	// StatusAuthOK 认证成功时的 HTTP 状态码
+	StatusAuthOK = 233
+
+	HeaderAuth    = "Hysteria-Auth"    // 认证密码
+	HeaderCCRX    = "Hysteria-CC-RX"   // 请求中为客户端的下行带宽(字节/秒)，响应中为服务器的接收带宽或 auto
+	HeaderUDP     = "Hysteria-UDP"     // 响应中为 true 表示服务器允许 UDP 转发
+	HeaderPadding = "Hysteria-Padding" // 随机填充，隐藏请求和响应的长度
+
+	// FrameTCPRequest TCP 请求流开头的帧类型
+	FrameTCPRequest = 0x401
+
+	// TCPResponse 的状态
+	StatusOK    byte = 0x00
+	StatusError byte = 0x01
+
+	// PacketOverhead QUIC 短包头和 AEAD 标签的最大开销。quic-go 返回的 DatagramTooLargeError
+	// 只扣除了 DATAGRAM 帧头，按其中的上限拆分的分片会被静默丢弃，拆分前要再减去这部分
+	PacketOverhead = 64
+
+	maxAddressLength = 2048
+	maxMessageLength = 2048
+	maxPaddingLength = 4096
+)
+
+// Padding 返回 min 到 max 字节的随机填充，只使用可打印字符，可以放在请求头中
+func Padding(min, max int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, min+rand.IntN(max-min+1))
+	for i := range b {
+		b[i] = chars[rand.IntN(len(chars))]
+	}
+	return string(b)
+}
+
+// AppendTCPRequest 把到 addr 的 TCPRequest 追加到 dst
+func AppendTCPRequest(dst []byte, addr string) []byte {
+	padding := Padding(64, 512)
+	dst = quicvarint.Append(dst, FrameTCPRequest)
+	dst = quicvarint.Append(dst, uint64(len(addr)))
+	dst = append(dst, addr...)
+	dst = quicvarint.Append(dst, uint64(len(padding)))
+	return append(dst, padding...)
+}
+
+// ReadTCPRequest 读取 TCPRequest 并返回目标地址
+func ReadTCPRequest(r io.Reader) (string, error) {
+	br := quicvarint.NewReader(r)
+	frame, err := quicvarint.Read(br)
+	if err != nil {
+		return "", err
+	}
+	if frame != FrameTCPRequest {
+		return "", E.WrapError(E.ErrHysteria2Message, "unexpected frame type "+strconv.FormatUint(frame, 16))
+	}
+	addr, err := readString(br, maxAddressLength)
+	if err != nil {
+		return "", err
+	}
+	if _, err := readString(br, maxPaddingLength); err != nil {
+		return "", err
+	}
+	return string(addr), nil
+}
+
+// AppendTCPResponse 把 TCPResponse 追加到 dst，ok 为 false 时 msg 说明拒绝的原因
+func AppendTCPResponse(dst []byte, ok bool, msg string) []byte {
+	status := StatusOK
+	if !ok {
+		status = StatusError
+	}
+	padding := Padding(128, 1024)
+	dst = append(dst, status)
+	dst = quicvarint.Append(dst, uint64(len(msg)))
+	dst = append(dst, msg...)
+	dst = quicvarint.Append(dst, uint64(len(padding)))
+	return append(dst, padding...)
+}
+
+// ReadTCPResponse 读取 TCPResponse，服务器拒绝时返回 ErrHysteria2Rejected
+func ReadTCPResponse(r io.Reader) error {
+	br := quicvarint.NewReader(r)
+	status, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	msg, err := readString(br, maxMessageLength)
+	if err != nil {
+		return err
+	}
+	if _, err := readString(br, maxPaddingLength); err != nil {
+		return err
+	}
+	if status != StatusOK {
+		return E.WrapError(E.ErrHysteria2Rejected, string(msg))
+	}
+	return nil
+}
+
+// readString 读取 varint 长度前缀的字符串
+func readString(r quicvarint.Reader, limit uint64) ([]byte, error) {
+	n, err := quicvarint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, E.WrapError(E.ErrHysteria2Message, "field too long")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, E.WrapError(E.ErrHysteria2Message, "truncated field")
+	}
+	return b, nil
+}
+
+// UDPMessage UDP 数据报或其中一个分片
+type UDPMessage struct {
+	SessionID uint32
+	PacketID  uint16
+	FragID    uint8
+	FragCount uint8
+	Addr      string
+	Data      []byte
+}
+
+// HeaderSize 返回消息头的长度
+func (m *UDPMessage) HeaderSize() int {
+	return 4 + 2 + 1 + 1 + quicvarint.Len(uint64(len(m.Addr))) + len(m.Addr)
+}
+
+// Append 把消息编码后追加到 dst
+func (m *UDPMessage) Append(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, m.SessionID)
+	dst = binary.BigEndian.AppendUint16(dst, m.PacketID)
+	dst = append(dst, m.FragID, m.FragCount)
+	dst = quicvarint.Append(dst, uint64(len(m.Addr)))
+	dst = append(dst, m.Addr...)
+	return append(dst, m.Data...)
+}
+
+// ParseUDPMessage 解析一个 QUIC 数据报，Data 引用 b 的内存
+func ParseUDPMessage(b []byte) (*UDPMessage, error) {
+	if len(b) < 8 {
+		return nil, E.WrapError(E.ErrHysteria2Message, "truncated udp header")
+	}
+	m := &UDPMessage{
+		SessionID: binary.BigEndian.Uint32(b),
+		PacketID:  binary.BigEndian.Uint16(b[4:]),
+		FragID:    b[6],
+		FragCount: b[7],
+	}
+	if m.FragCount == 0 || m.FragID >= m.FragCount {
+		return nil, E.WrapError(E.ErrHysteria2Message, "invalid udp fragment")
+	}
+	size, n, err := quicvarint.Parse(b[8:])
+	if err != nil || size > maxAddressLength || size > uint64(len(b)-8-n) {
+		return nil, E.WrapError(E.ErrHysteria2Message, "invalid udp address")
+	}
+	rest := b[8+n:]
+	m.Addr = string(rest[:size])
+	m.Data = rest[size:]
+	return m, nil
+}
+
+// Fragment 把负载超过 maxSize 的消息拆成分片，每个分片编码后不超过 maxSize
+// 消息头本身超过 maxSize 或需要超过 255 个分片时返回 nil
+func Fragment(m *UDPMessage, maxSize int) []*UDPMessage {
+	if m.HeaderSize()+len(m.Data) <= maxSize {
+		return []*UDPMessage{m}
+	}
+	chunk := maxSize - m.HeaderSize()
+	if chunk <= 0 {
+		return nil
+	}
+	count := (len(m.Data) + chunk - 1) / chunk
+	if count > 255 {
+		return nil
+	}
+	frags := make([]*UDPMessage, 0, count)
+	for i, data := 0, m.Data; len(data) > 0; i++ {
+		n := min(len(data), chunk)
+		frag := *m
+		frag.FragID, frag.FragCount, frag.Data = uint8(i), uint8(count), data[:n]
+		frags = append(frags, &frag)
+		data = data[n:]
+	}
+	return frags
+}
+
+// Defragger 重组一个会话的分片，同一时间只重组一个包，收到新包的分片时丢弃未完成的旧包
+type Defragger struct {
+	packetID uint16
+	frags    [][]byte
+	received int
+	size     int
+}
+
+// Feed 处理一个消息，返回重组完成的消息，包还不完整时返回 nil
+func (d *Defragger) Feed(m *UDPMessage) *UDPMessage {
+	if m.FragCount <= 1 {
+		return m
+	}
+	if d.frags == nil || m.PacketID != d.packetID || int(m.FragCount) != len(d.frags) {
+		d.packetID = m.PacketID
+		d.frags = make([][]byte, m.FragCount)
+		d.received, d.size = 0, 0
+	}
+	if d.frags[m.FragID] != nil {
+		return nil
+	}
+	d.frags[m.FragID] = append(make([]byte, 0, len(m.Data)), m.Data...) // 空分片也要非 nil
+	d.received++
+	d.size += len(m.Data)
+	if d.received < len(d.frags) {
+		return nil
+	}
+
+	data := make([]byte, 0, d.size)
+	for _, frag := range d.frags {
+		data = append(data, frag...)
+	}
+	d.frags = nil
+	full := *m
+	full.FragID, full.FragCount, full.Data = 0, 1, data
+	return &full
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+const (
+	// resolvedHintTTL 解析结果用于还原主机名的时间，超过后按 IP 拨号
+	resolvedHintTTL = 10 * time.Minute
+	// resolvedHintMaxEntries 最多记录的地址数，记录满且没有过期条目时不再记录新的地址
+	resolvedHintMaxEntries = 4096
+)
+
+// IPHint 调用方已经为主机名选择的地址
+// 能把主机名交给代理的拨号器(HTTP、SOCKS4A、SOCKS5、SOCKS5H)仍然发送主机名，由代理解析；
+// 需要在本地解析的拨号(直连、SOCKS4、SOCKS5 的 UDP)直接使用提示的地址，不再解析
+type IPHint struct {
+	Host string     // 提示对应的主机名，只在拨号目标为该主机名时生效
+	IP   netip.Addr // 解析时选择的地址
+}
+
+// ipHintKey context 中保存地址提示的键
+type ipHintKey struct{}
+
+// WithIPHint 告诉经过 ctx 的拨号主机名已经解析为 hint.IP，避免再次解析，拨号延迟的 exemplar 记录为 expected_ip
+func WithIPHint(ctx context.Context, hint IPHint) context.Context {
+	return context.WithValue(ctx, ipHintKey{}, hint)
+}
+
+// IPHintFromContext 返回 ctx 中的地址提示
+func IPHintFromContext(ctx context.Context) (IPHint, bool) {
+	hint, ok := ctx.Value(ipHintKey{}).(IPHint)
+	return hint, ok && hint.IP.IsValid()
+}
+
+// hintedIP 返回 ctx 中 host 的提示地址，地址族不符合 network 时不使用
+func hintedIP(ctx context.Context, network, host string) (net.IPAddr, bool) {
+	hint, ok := IPHintFromContext(ctx)
+	if !ok || hostport.CanonicalHost(hint.Host) != hostport.CanonicalHost(host) {
+		return net.IPAddr{}, false
+	}
+	ip := net.IPAddr{IP: hint.IP.Unmap().AsSlice(), Zone: hint.IP.Zone()}
+	if len(filterAddrFamily(network, []net.IPAddr{ip})) == 0 {
+		return net.IPAddr{}, false
+	}
+	return ip, true
+}
+
+type resolvedHintEntry struct {
+	host    string
+	expires time.Time
+}
+
+// resolvedHints 记录管理器为 hook 解析的主机名，应用拨号解析得到的 IP 时还原为主机名，
+// 代理收到主机名，路由规则也按主机名匹配，与直接拨号主机名的结果一致
+type resolvedHints struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[netip.Addr]resolvedHintEntry
+}
+
+// newResolvedHints enabled 为 false 时返回 nil，不记录
+func newResolvedHints(enabled bool, clk clock.Clock) *resolvedHints {
+	if !enabled {
+		return nil
+	}
+	return &resolvedHints{clock: clk, entries: make(map[netip.Addr]resolvedHintEntry)}
+}
+
+// remember 记录主机名解析得到的地址
+func (h *resolvedHints) remember(host string, ips []net.IPAddr) {
+	if h == nil || hostport.ParseIP(host) != nil {
+		return
+	}
+	now := h.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ip := range ips {
+		addr, ok := netip.AddrFromSlice(ip.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if _, ok := h.entries[addr]; !ok && len(h.entries) >= resolvedHintMaxEntries {
+			for k, e := range h.entries {
+				if !now.Before(e.expires) {
+					delete(h.entries, k)
+				}
+			}
+			if len(h.entries) >= resolvedHintMaxEntries {
+				return
+			}
+		}
+		h.entries[addr] = resolvedHintEntry{host: host, expires: now.Add(resolvedHintTTL)}
+	}
+}
+
+// restore 把解析得到的 IP 目标还原为主机名，返回还原后的地址和提示；没有记录时 ok 为 false
+func (h *resolvedHints) restore(addr string) (string, IPHint, bool) {
+	if h == nil {
+		return addr, IPHint{}, false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, IPHint{}, false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return addr, IPHint{}, false
+	}
+	ip = ip.Unmap()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[ip]
+	if !ok {
+		return addr, IPHint{}, false
+	}
+	if !h.clock.Now().Before(e.expires) {
+		delete(h.entries, ip)
+		return addr, IPHint{}, false
+	}
+	return net.JoinHostPort(e.host, port), IPHint{Host: e.host, IP: ip}, true
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// labelsKey context 中保存连接标签的键
+type labelsKey struct{}
+
+// WithLabels 为经过 ctx 的拨号附加应用定义的标签，与 ctx 中已有的标签合并，同名时新值优先
+//
+//	ctx = proxy.WithLabels(ctx, map[string]string{"tenant": "acme"})
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := make(map[string]string, len(labels))
+	for k, v := range LabelsFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFromContext 返回 ctx 中的连接标签，调用方不能修改返回的 map
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// labeledConn 按标签统计收发字节数的连接
+type labeledConn struct {
+	net.Conn
+	counter *metrics.LabelCounter
+}
+
+func (c *labeledConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.AddBytes(0, int64(n))
+	return n, err
+}
+
+func (c *labeledConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.AddBytes(int64(n), 0)
+	return n, err
+}
+
+func (c *labeledConn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// lazy 第一次使用时才创建的值，如从文件加载的证书和私钥
+// 创建成功后缓存；失败时返回错误，下次使用时重新创建，修复文件后不需要重建管理器
+type lazy[T any] struct {
+	create func() (T, error)
+
+	mu    sync.Mutex
+	value T
+	ok    bool
+}
+
+func newLazy[T any](create func() (T, error)) *lazy[T] {
+	return &lazy[T]{create: create}
+}
+
+// get 返回缓存的值，还没有创建成功时调用 create
+func (l *lazy[T]) get() (T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ok {
+		return l.value, nil
+	}
+	v, err := l.create()
+	if err != nil {
+		return v, err
+	}
+	l.value, l.ok = v, true
+	return v, nil
+}
+
+// peek 返回已经创建的值，不触发创建
+func (l *lazy[T]) peek() (T, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.value, l.ok
+}
+
+// lazyDialer 第一次拨号时才创建的拨号器，用于可能从不使用的路径，如竞速的第二条路径
+// 配置在创建管理器时已经验证，证书和会话等资源在需要时才准备
+type lazyDialer struct {
+	dialer *lazy[ProxyDialer]
+}
+
+func newLazyDialer(create func() (ProxyDialer, error)) *lazyDialer {
+	return &lazyDialer{dialer: newLazy(create)}
+}
+
+func (d *lazyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *lazyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer, err := d.dialer.get()
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Close 关闭已经创建的拨号器持有的共享会话
+func (d *lazyDialer) Close() error {
+	if dialer, ok := d.dialer.peek(); ok {
+		closeDialer(dialer)
+	}
+	return nil
+}
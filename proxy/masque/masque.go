@@ -0,0 +1,121 @@
+// Package masque CONNECT-UDP(RFC 9298) 的路径模板和 HTTP 数据报编解码，拨号器和测试服务共用
+//
+// 客户端用扩展 CONNECT(:protocol 为 connect-udp)请求默认的 URI 模板，
+// 每个请求对应一个固定的 UDP 目标:
+//
+//	/.well-known/masque/udp/{target_host}/{target_port}/
+//
+// 每个 UDP 负载前加上 varint 编码的上下文 ID(0)作为一个 HTTP 数据报(RFC 9297)，
+// HTTP/3 中以 QUIC 数据报发送，HTTP/2 中以 DATAGRAM capsule 在请求流上发送:
+//
+//	+-------------+------------------+-------------+--------------+
+//	| 类型(varint) | 长度(varint)      | 上下文 ID 0 | UDP 负载     |
+//	+-------------+------------------+-------------+--------------+
+package masque
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+const (
+	// Protocol 扩展 CONNECT 请求的 :protocol
+	Protocol = "connect-udp"
+	// CapsuleProtocol 请求和响应中 Capsule-Protocol 头的值
+	CapsuleProtocol = "?1"
+	// PathPrefix 默认 URI 模板中目标之前的部分
+	PathPrefix = "/.well-known/masque/udp/"
+
+	// CapsuleDatagram DATAGRAM capsule 的类型
+	CapsuleDatagram http3.CapsuleType = 0
+	// MaxCapsuleSize 读取时接受的最大 capsule 长度，足够容纳最大的 UDP 负载
+	MaxCapsuleSize = 65535 + 8
+)
+
+// Path 返回到 addr 的请求路径，IPv6 地址中的冒号按 RFC 9298 编码为 %3A
+func Path(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport.Canonical(addr))
+	if err != nil {
+		return "", E.WrapError(E.ErrConnectUDPMessage, err.Error())
+	}
+	host = strings.ReplaceAll(host, ":", "%3A")
+	return PathPrefix + host + "/" + port + "/", nil
+}
+
+// ParsePath 从已解码的请求路径中取出目标 host:port
+func ParsePath(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, PathPrefix)
+	if !ok {
+		return "", E.WrapError(E.ErrConnectUDPMessage, "unexpected path "+path)
+	}
+	host, port, ok := strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if !ok || host == "" || strings.Contains(port, "/") {
+		return "", E.WrapError(E.ErrConnectUDPMessage, "unexpected path "+path)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", E.WrapError(E.ErrConnectUDPMessage, "invalid port "+port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// AppendDatagram 把 payload 编码为上下文 ID 为 0 的 HTTP 数据报追加到 b
+func AppendDatagram(b, payload []byte) []byte {
+	b = quicvarint.Append(b, 0)
+	return append(b, payload...)
+}
+
+// ParseDatagram 返回 HTTP 数据报中的 UDP 负载，ok 为 false 表示上下文 ID 不是 0，调用方应丢弃
+func ParseDatagram(b []byte) (payload []byte, ok bool, err error) {
+	id, n, err := quicvarint.Parse(b)
+	if err != nil {
+		return nil, false, E.WrapError(E.ErrConnectUDPMessage, err.Error())
+	}
+	return b[n:], id == 0, nil
+}
+
+// WriteDatagramCapsule 把 payload 编码为一个 DATAGRAM capsule，一次写入 w
+func WriteDatagramCapsule(w io.Writer, payload []byte) error {
+	datagram := AppendDatagram(nil, payload)
+	b := quicvarint.Append(make([]byte, 0, len(datagram)+16), uint64(CapsuleDatagram))
+	b = quicvarint.Append(b, uint64(len(datagram)))
+	_, err := w.Write(append(b, datagram...))
+	return err
+}
+
+// ReadDatagramCapsule 读取下一个上下文 ID 为 0 的 DATAGRAM capsule 并返回其中的 UDP 负载
+// 其他类型的 capsule 和其他上下文 ID 的数据报被跳过
+func ReadDatagramCapsule(r quicvarint.Reader) ([]byte, error) {
+	for {
+		ct, cr, err := http3.ParseCapsule(r)
+		if err != nil {
+			return nil, err
+		}
+		if ct != CapsuleDatagram {
+			if _, err := io.Copy(io.Discard, cr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b, err := io.ReadAll(io.LimitReader(cr, MaxCapsuleSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > MaxCapsuleSize {
+			return nil, E.WrapError(E.ErrConnectUDPMessage, "capsule too large")
+		}
+		payload, ok, err := ParseDatagram(b)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return payload, nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// namedProxy Config.Proxies 中的一个代理
+type namedProxy struct {
+	name      string
+	dialer    ProxyDialer
+	proxyType C.ProxyType
+	addr      string    // 代理地址，用于负缓存
+	config    *C.Config // 连接该代理使用的配置，用于健康检查
+}
+
+// newNamedProxies 为 Proxies 创建拨号器，未配置或未启用代理时返回 nil
+func newNamedProxies(config *C.Config, pm *ProxyManager) (map[string]*namedProxy, error) {
+	if len(config.Proxies) == 0 || !config.Enable {
+		return nil, nil
+	}
+	named := make(map[string]*namedProxy, len(config.Proxies))
+	for i := range config.Proxies {
+		p := &config.Proxies[i]
+		proxyConfig := p.ProxyConfig(config)
+		dialer, err := createProxyDialer(proxyConfig, pm.dialRecorder())
+		if err != nil {
+			closeNamedProxies(named)
+			return nil, errors.WrapError(err, "proxy "+p.Name)
+		}
+		pm.bindDialer(dialer)
+		named[p.Name] = &namedProxy{name: p.Name, dialer: dialer, proxyType: p.ProxyType, addr: proxyConfig.GetProxyAddr(), config: proxyConfig}
+	}
+	return named, nil
+}
+
+// closeNamedProxies 关闭命名代理持有的共享会话
+func closeNamedProxies(named map[string]*namedProxy) {
+	for _, p := range named {
+		closeDialer(p.dialer)
+	}
+}
+
+// Use 选择走代理的目标默认使用的命名代理，规则的 Proxy 指定了代理时仍使用规则的代理
+// name 为空时恢复为 ProxyType/ProxyIP/ProxyPort 配置的代理；name 不在 Config.Proxies 中时返回 ErrUnknownProxy
+// 只影响之后的拨号，已建立的连接不受影响。UpdateConfig 后新配置中没有该名称时恢复为主代理
+func (pm *ProxyManager) Use(name string) error {
+	if name == "" {
+		pm.using.Store(nil)
+		return nil
+	}
+	if pm.Config == nil || pm.Config.NamedProxy(name) == nil {
+		return errors.WrapError(errors.ErrUnknownProxy, name)
+	}
+	pm.using.Store(&name)
+	return nil
+}
+
+// Using 返回 Use 选择的命名代理，使用主代理时返回空字符串
+func (pm *ProxyManager) Using() string {
+	if name := pm.using.Load(); name != nil {
+		return *name
+	}
+	return ""
+}
+
+// namedProxyFor 返回走代理的决策使用的命名代理: 规则指定的代理优先，其次是 Use 选择的代理，都没有时返回 nil
+// 未启用代理时没有命名代理的拨号器，同样返回 nil
+func (pm *ProxyManager) namedProxyFor(decision rules.Decision) (*namedProxy, error) {
+	name := pm.Using()
+	if rule := decision.Rule; rule != nil && rule.Proxy != "" {
+		name = rule.Proxy
+	}
+	if name == "" {
+		return nil, nil
+	}
+	if p, ok := pm.named[name]; ok {
+		return p, nil
+	}
+	if pm.Config == nil || !pm.Config.Enable {
+		return nil, nil
+	}
+	// RulesFile 中的规则可能引用配置中没有的代理
+	return nil, errors.WrapError(errors.ErrUnknownProxy, name)
+}
+
+// keepUsing UpdateConfig 后保留仍然存在的选择
+func (pm *ProxyManager) keepUsing(config *C.Config) {
+	if name := pm.Using(); name != "" && config.NamedProxy(name) == nil {
+		pm.using.Store(nil)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+const (
+	// nat64DiscoveryHost RFC 7050 用于发现 NAT64 前缀的域名，DNS64 为它合成 AAAA 记录
+	nat64DiscoveryHost = "ipv4only.arpa"
+	// nat64Refresh 发现的前缀的缓存时间
+	nat64Refresh = time.Hour
+	// nat64Retry 发现失败后再次尝试的间隔
+	nat64Retry = time.Minute
+)
+
+// nat64WellKnownIPv4 ipv4only.arpa 的 A 记录，合成地址中嵌入的就是它们
+var nat64WellKnownIPv4 = []netip.Addr{netip.MustParseAddr("192.0.0.170"), netip.MustParseAddr("192.0.0.171")}
+
+// nat64SharedSpace 运营商级 NAT 的地址段，和私有地址一样不能使用知名前缀转换
+var nat64SharedSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// nat64Translator 把直连的 IPv4 目标转换为 NAT64 前缀下的 IPv6 地址
+// 配置了前缀时直接使用，否则第一次转换时通过 resolver 发现，结果按 clock 缓存
+type nat64Translator struct {
+	resolver Resolver
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	static  bool
+	prefix  netip.Prefix
+	err     error
+	expires time.Time
+}
+
+// newNAT64Translator config 为 nil 时返回 nil，Validate 已检查过前缀
+func newNAT64Translator(config *C.NAT64Config, resolver Resolver, clk clock.Clock) *nat64Translator {
+	if config == nil {
+		return nil
+	}
+	t := &nat64Translator{resolver: resolver, clock: clk}
+	if config.Prefix != "" {
+		t.prefix, _ = C.ParseNAT64Prefix(config.Prefix)
+		t.static = true
+	}
+	return t
+}
+
+// Prefix 返回使用的 NAT64 前缀，需要时先发现
+func (t *nat64Translator) Prefix(ctx context.Context) (netip.Prefix, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.static {
+		return t.prefix, nil
+	}
+	now := t.clock.Now()
+	if now.Before(t.expires) {
+		return t.prefix, t.err
+	}
+	t.prefix, t.err = discoverNAT64Prefix(ctx, t.resolver)
+	if t.err != nil {
+		t.expires = now.Add(nat64Retry)
+	} else {
+		t.expires = now.Add(nat64Refresh)
+	}
+	return t.prefix, t.err
+}
+
+// translate 把 IPv4 地址转换为 NAT64 地址，network 限定 IPv4、地址不能转换或没有前缀时原样返回
+func (t *nat64Translator) translate(ctx context.Context, network string, ip net.IPAddr) net.IPAddr {
+	if t == nil || strings.HasSuffix(network, "4") {
+		return ip
+	}
+	addr, ok := netip.AddrFromSlice(ip.IP)
+	if !ok || !addr.Unmap().Is4() {
+		return ip
+	}
+	addr = addr.Unmap()
+	prefix, err := t.Prefix(ctx)
+	if err != nil || !nat64Translatable(prefix, addr) {
+		return ip
+	}
+	return net.IPAddr{IP: embedIPv4(prefix, addr).AsSlice()}
+}
+
+// nat64Translatable 判断 IPv4 地址是否应当经过 NAT64，本机、链路本地、组播和广播地址不转换
+// 知名前缀只能用于公网地址(RFC 6052 3.1)
+func nat64Translatable(prefix netip.Prefix, addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return false
+	}
+	if prefix == C.NAT64WellKnownPrefix {
+		return !addr.IsPrivate() && !nat64SharedSpace.Contains(addr)
+	}
+	return true
+}
+
+// embedIPv4 按 RFC 6052 把 IPv4 地址嵌入前缀，跳过第 64-71 位
+func embedIPv4(prefix netip.Prefix, v4 netip.Addr) netip.Addr {
+	b := prefix.Addr().As16()
+	pos := prefix.Bits() / 8
+	for _, octet := range v4.As4() {
+		if pos == 8 {
+			pos++
+		}
+		b[pos] = octet
+		pos++
+	}
+	return netip.AddrFrom16(b)
+}
+
+// extractIPv4 从 NAT64 地址中取出按 prefixLen 嵌入的 IPv4 地址
+func extractIPv4(addr netip.Addr, prefixLen int) netip.Addr {
+	b := addr.As16()
+	var v4 [4]byte
+	pos := prefixLen / 8
+	for i := range v4 {
+		if pos == 8 {
+			pos++
+		}
+		v4[i] = b[pos]
+		pos++
+	}
+	return netip.AddrFrom4(v4)
+}
+
+// discoverNAT64Prefix 解析 ipv4only.arpa，在合成的 IPv6 地址中找到嵌入的知名 IPv4 地址，从而得到前缀(RFC 7050)
+func discoverNAT64Prefix(ctx context.Context, r Resolver) (netip.Prefix, error) {
+	if r == nil {
+		r = SystemResolver{}
+	}
+	ips, err := r.LookupIPAddr(ctx, nat64DiscoveryHost)
+	if err != nil {
+		return netip.Prefix{}, E.WrapError(E.ErrNAT64PrefixNotFound, err.Error())
+	}
+	for _, ip := range ips {
+		addr, ok := netip.AddrFromSlice(ip.IP)
+		if !ok || !addr.Is6() || addr.Is4In6() {
+			continue
+		}
+		for _, bits := range []int{96, 64, 56, 48, 40, 32} {
+			embedded := extractIPv4(addr, bits)
+			for _, known := range nat64WellKnownIPv4 {
+				if embedded == known {
+					return netip.PrefixFrom(addr, bits).Masked(), nil
+				}
+			}
+		}
+	}
+	return netip.Prefix{}, E.ErrNAT64PrefixNotFound
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// negativeCacheMaxEntries 最多缓存的失败数，缓存满且没有过期条目时不再记录新的失败
+const negativeCacheMaxEntries = 1024
+
+type negativeKey struct {
+	proxy   string
+	network string // tcp 或 udp
+	addr    string
+	creds   Credentials // 单次拨号的凭证，代理可能按账号决定是否允许目标
+}
+
+type negativeEntry struct {
+	err     error
+	expires time.Time
+}
+
+// negativeCache 按代理、目标和凭证缓存确定性的握手失败，TTL 内到同一目标的拨号直接返回缓存的错误，
+// 避免调用方的重试循环反复请求代理
+type negativeCache struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[negativeKey]negativeEntry
+}
+
+// newNegativeCache ttl 不大于 0 时返回 nil，不缓存
+func newNegativeCache(ttl time.Duration, clk clock.Clock) *negativeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &negativeCache{
+		ttl:     ttl,
+		clock:   clk,
+		entries: make(map[negativeKey]negativeEntry),
+	}
+}
+
+// deterministicFailure 判断错误是否是代理按策略对目标的拒绝，在 TTL 内重试会得到相同的结果
+// 超时、连接重置和认证失败等可能随时恢复或与凭证有关的错误不缓存，不支持的地址类型由能力缓存处理
+func deterministicFailure(err error) bool {
+	return errors.Is(err, E.ErrProxyForbidden) || errors.Is(err, E.ErrSOCKS5NotAllowed)
+}
+
+// newNegativeKey 返回拨号对应的键，ctx 指定了 ProxyAuthorizer 时无法区分身份，ok 为 false，不使用缓存
+func newNegativeKey(ctx context.Context, proxy, network, addr string) (key negativeKey, ok bool) {
+	if _, authorized := ProxyAuthorizerFromContext(ctx); authorized {
+		return negativeKey{}, false
+	}
+	if rules.IsUDPNetwork(network) {
+		network = "udp"
+	} else {
+		network = "tcp"
+	}
+	creds, _ := CredentialsFromContext(ctx)
+	return negativeKey{proxy: proxy, network: network, addr: hostport.Canonical(addr), creds: creds}, true
+}
+
+// check 返回缓存的失败，错误同时匹配 ErrRecentFailure 和原来的错误
+func (c *negativeCache) check(ctx context.Context, proxy, network, addr string) error {
+	if c == nil {
+		return nil
+	}
+	key, ok := newNegativeKey(ctx, proxy, network, addr)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !c.clock.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return fmt.Errorf("%w: %w", E.ErrRecentFailure, e.err)
+}
+
+// record 记录一次拨号失败，只缓存确定性的失败
+func (c *negativeCache) record(ctx context.Context, proxy, network, addr string, err error) {
+	if c == nil || !deterministicFailure(err) {
+		return
+	}
+	key, ok := newNegativeKey(ctx, proxy, network, addr)
+	if !ok {
+		return
+	}
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= negativeCacheMaxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= negativeCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[key] = negativeEntry{err: err, expires: now.Add(c.ttl)}
+}
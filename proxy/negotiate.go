@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+// NegotiateProvider 为 HTTP 代理的 Negotiate(SPNEGO) 认证生成令牌
+// 可以基于 gokrb5 的 spnego 包、系统的 GSSAPI 或 SSPI 实现
+type NegotiateProvider interface {
+	// Token 返回发给代理的令牌，spn 为代理的服务主体名，如 HTTP/proxy.example.com
+	// challenge 为代理在 407 响应中返回的令牌，第一轮为 nil
+	Token(ctx context.Context, spn string, challenge []byte) ([]byte, error)
+}
+
+// NegotiateFunc 将函数适配为 NegotiateProvider
+type NegotiateFunc func(ctx context.Context, spn string, challenge []byte) ([]byte, error)
+
+// Token 实现 NegotiateProvider 接口
+func (f NegotiateFunc) Token(ctx context.Context, spn string, challenge []byte) ([]byte, error) {
+	return f(ctx, spn, challenge)
+}
+
+// maxNegotiateLegs 一次 CONNECT 最多发送的 Negotiate 令牌数，Kerberos 通常只需要一轮
+const maxNegotiateLegs = 3
+
+// errNegotiateReconnect 代理在 407 后关闭了连接，需要用新连接发送令牌
+var errNegotiateReconnect = errors.WrapError(errors.ErrHTTPProxyAuth, "proxy closed connection after negotiate challenge")
+
+// negotiateChallenge 解析 407 响应中的 Proxy-Authenticate 头，返回代理是否接受 Negotiate 及附带的令牌
+func negotiateChallenge(header http.Header) (offered bool, token []byte) {
+	for _, v := range header.Values("Proxy-Authenticate") {
+		scheme, param, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "Negotiate") {
+			continue
+		}
+		if param = strings.TrimSpace(param); param != "" {
+			token, _ = base64.StdEncoding.DecodeString(param)
+		}
+		return true, token
+	}
+	return false, nil
+}
+
+// setNegotiator 设置获取 Negotiate 令牌提供者的函数，实现 negotiatorSetter 接口
+func (d *HTTPProxyDialer) setNegotiator(fn func() NegotiateProvider) {
+	d.negotiator = fn
+}
+
+// negotiateProvider 返回当前的 Negotiate 令牌提供者，没有时返回 nil
+func (d *HTTPProxyDialer) negotiateProvider() NegotiateProvider {
+	if d.negotiator == nil {
+		return nil
+	}
+	return d.negotiator()
+}
+
+// spn 返回代理的服务主体名，未配置时为 HTTP/<代理主机名>
+func (d *HTTPProxyDialer) spn() string {
+	if d.Config.NegotiateSPN != "" {
+		return d.Config.NegotiateSPN
+	}
+	return "HTTP/" + hostport.Host(d.proxyURL.Host)
+}
+
+// authorize 设置 CONNECT 请求的 Proxy-Authorization 头
+// ctx 指定了 ProxyAuthorizer 时使用它生成的头；代理要求过 Negotiate 且设置了令牌提供者时发送 SPNEGO 令牌，否则有凭证时使用 Basic 认证
+func (d *HTTPProxyDialer) authorize(ctx context.Context, req *http.Request, negotiate bool, challenge []byte) error {
+	if _, ok := ProxyAuthorizerFromContext(ctx); ok {
+		return d.setProxyAuthorization(ctx, req)
+	}
+	if negotiate {
+		token, err := d.negotiateProvider().Token(ctx, d.spn(), challenge)
+		if err != nil {
+			return errors.WrapError(errors.ErrHTTPProxyAuth, "negotiate: "+err.Error())
+		}
+		req.Header.Set("Proxy-Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+		return nil
+	}
+	return d.setProxyAuthorization(ctx, req)
+}
+
+// negotiatorSetter 支持 Negotiate 认证的拨号器实现的可选接口
+type negotiatorSetter interface {
+	setNegotiator(fn func() NegotiateProvider)
+}
+
+// SetNegotiateProvider 设置 HTTP/HTTPS 代理 Negotiate 认证的令牌提供者，nil 表示只使用 Basic 认证
+// 代理在 407 响应中提供 Negotiate 时自动改用 SPNEGO 令牌，之后的拨号直接发送令牌
+func (pm *ProxyManager) SetNegotiateProvider(p NegotiateProvider) {
+	pm.mu.Lock()
+	pm.negotiate = p
+	pm.mu.Unlock()
+}
+
+// NegotiateProvider 返回当前的 Negotiate 令牌提供者
+func (pm *ProxyManager) NegotiateProvider() NegotiateProvider {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.negotiate
+}
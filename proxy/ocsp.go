@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// applyPeerVerification 把 HTTPConfig 中代理证书的验证选项设置到 tlsConfig
+// 根证书替换系统根证书；指纹和 OCSP 装订在标准证书链验证之后由 VerifyConnection 检查
+func applyPeerVerification(tlsConfig *tls.Config, config *C.HTTPConfig) error {
+	if config.RootCAFile != "" {
+		pem, err := os.ReadFile(config.RootCAFile)
+		if err != nil {
+			return E.WrapError(E.ErrCertValidation, err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return E.WrapError(E.ErrCertValidation, "no certificates found in "+config.RootCAFile)
+		}
+	}
+	if len(config.PinnedSHA256) == 0 && !config.RequireOCSPStaple {
+		return nil
+	}
+
+	pins := make(map[string]bool, len(config.PinnedSHA256))
+	for _, pin := range config.PinnedSHA256 {
+		pins[pin] = true
+	}
+	requireStaple := config.RequireOCSPStaple
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return E.WrapError(E.ErrCertValidation, "proxy presented no certificate")
+		}
+		if len(pins) > 0 {
+			sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+			if !pins[base64.StdEncoding.EncodeToString(sum[:])] {
+				return E.ErrCertPinMismatch
+			}
+		}
+		if requireStaple {
+			return checkOCSPStaple(cs, time.Now())
+		}
+		return nil
+	}
+	return nil
+}
+
+// checkOCSPStaple 检查握手中装订的 OCSP 响应: 由签发者(或其授权的响应者)签名、证书状态为 good 且 now 在有效期内
+// 签发者优先取验证后的证书链，跳过验证时取代理发送的第二个证书
+func checkOCSPStaple(cs tls.ConnectionState, now time.Time) error {
+	if len(cs.OCSPResponse) == 0 {
+		return E.ErrOCSPStapleMissing
+	}
+	leaf := cs.PeerCertificates[0]
+	var issuer *x509.Certificate
+	if len(cs.VerifiedChains) > 0 && len(cs.VerifiedChains[0]) > 1 {
+		issuer = cs.VerifiedChains[0][1]
+	} else if len(cs.PeerCertificates) > 1 {
+		issuer = cs.PeerCertificates[1]
+	}
+	if issuer == nil {
+		return E.WrapError(E.ErrOCSPStapleInvalid, "issuer certificate not available")
+	}
+
+	resp, err := ocsp.ParseResponseForCert(cs.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return E.WrapError(E.ErrOCSPStapleInvalid, err.Error())
+	}
+	switch resp.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return E.WrapError(E.ErrCertRevoked, fmt.Sprintf("revoked at %s", resp.RevokedAt.Format(time.RFC3339)))
+	default:
+		return E.WrapError(E.ErrOCSPStapleInvalid, "certificate status unknown")
+	}
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return E.WrapError(E.ErrOCSPStapleInvalid, "response is outside its validity period")
+	}
+	return nil
+}
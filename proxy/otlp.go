@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// otlpShutdownTimeout 停止推送时最后一次导出的超时
+const otlpShutdownTimeout = 5 * time.Second
+
+// updateOTLP 按配置启动或停止 OTLP 指标推送，配置未变化时沿用正在运行的推送
+// 停止时同步做最后一次导出，保证关闭前的计数送达 Collector
+func (pm *ProxyManager) updateOTLP(old *C.Config, config *C.Config) {
+	if pm.Metrics == nil {
+		return
+	}
+	var otlp *C.OTLPConfig
+	if config != nil {
+		otlp = config.OTLP
+	}
+	if pm.stopOTLP != nil && old != nil && reflect.DeepEqual(old.OTLP, otlp) {
+		return
+	}
+
+	if pm.stopOTLP != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+		pm.exportFailed(pm.stopOTLP(ctx))
+		cancel()
+		pm.stopOTLP = nil
+	}
+	if otlp == nil {
+		return
+	}
+
+	interval := otlp.Interval
+	if interval == 0 {
+		interval = C.DefaultOTLPInterval
+	}
+	e := &metrics.OTLPExporter{
+		Endpoint: otlp.Endpoint,
+		Headers:  otlp.Headers,
+		Resource: otlp.Resource,
+	}
+	pm.stopOTLP = pm.Metrics.StartExporter(e, interval, pm.exportFailed)
+}
+
+// exportFailed 把导出错误交给 OnMetricsExportError 设置的回调
+func (pm *ProxyManager) exportFailed(err error) {
+	if fn := pm.onExportError.Load(); err != nil && fn != nil {
+		(*fn)(err)
+	}
+}
+
+// OnMetricsExportError 设置 OTLP 指标推送失败时的回调，失败的推送不重试，下一次推送包含完整的累计值
+func (pm *ProxyManager) OnMetricsExportError(fn func(error)) {
+	pm.onExportError.Store(&fn)
+}
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/pac"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+const (
+	// pacRefresh PAC 地址下载成功后重新下载的间隔
+	pacRefresh = time.Hour
+	// pacRetry PAC 地址下载失败后重试的间隔，期间继续使用上次成功的脚本
+	pacRetry = time.Minute
+	// pacFetchTimeout 下载 PAC 脚本的超时时间
+	pacFetchTimeout = 30 * time.Second
+	// pacMaxSize PAC 脚本的大小上限
+	pacMaxSize = 4 << 20
+)
+
+// pacEngine 按 PAC 脚本为目标选择直连或代理
+// PACFile 在创建时读取，PACURL 在第一次路由时下载并在后台定期刷新
+type pacEngine struct {
+	url     string
+	config  *C.Config // 创建 PAC 代理拨号器时使用其中的 HTTPConfig 和 SOCKSConfig
+	pm      *ProxyManager
+	metrics metrics.Recorder
+	clock   clock.Clock
+	ctx     context.Context // 后台下载使用，close 时取消
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	script  *pac.Script
+	next    time.Time     // 下次下载 PACURL 的时间
+	loading chan struct{} // 正在进行的下载，结束时关闭
+	loadErr error         // 最近一次下载的错误
+	dialers map[pac.Proxy]ProxyDialer
+	proxies []string // 脚本返回过的代理地址，到这些地址的连接直连
+}
+
+// newPACEngine 创建 PAC 引擎，没有配置 PAC 时返回 nil
+func newPACEngine(config *C.Config, pm *ProxyManager) (*pacEngine, error) {
+	if config == nil || config.PACURL == "" && config.PACFile == "" {
+		return nil, nil
+	}
+	e := &pacEngine{
+		url:     config.PACURL,
+		config:  config,
+		pm:      pm,
+		metrics: pm.dialRecorder(),
+		clock:   pm.Clock(),
+		dialers: make(map[pac.Proxy]ProxyDialer),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	if config.PACFile != "" {
+		src, err := os.ReadFile(config.PACFile)
+		if err != nil {
+			return nil, errors.WrapError(errors.ErrPACFetch, err.Error())
+		}
+		if e.script, err = pac.Parse(string(src)); err != nil {
+			return nil, errors.WrapError(errors.ErrPACScript, config.PACFile+": "+err.Error())
+		}
+	}
+	return e, nil
+}
+
+// load 返回当前的脚本，PACURL 到了刷新时间时在后台重新下载，下载期间和下载失败时继续使用旧脚本
+// 还没有脚本时等待正在进行的下载，同一时间只有一个下载
+func (e *pacEngine) load(ctx context.Context) (*pac.Script, error) {
+	e.mu.Lock()
+	if e.url == "" || e.clock.Now().Before(e.next) {
+		script := e.script
+		e.mu.Unlock()
+		return script, nil
+	}
+	if e.loading == nil {
+		e.loading = make(chan struct{})
+		go e.refresh(e.loading)
+	}
+	script, loading := e.script, e.loading
+	e.mu.Unlock()
+	if script != nil {
+		return script, nil
+	}
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.script, e.loadErr
+}
+
+// refresh 下载脚本并设置下次下载的时间，结束时关闭 loading
+func (e *pacEngine) refresh(loading chan struct{}) {
+	script, err := e.fetch(e.ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.next = e.clock.Now().Add(pacRetry)
+	} else {
+		e.script = script
+		e.next = e.clock.Now().Add(pacRefresh)
+	}
+	e.loadErr = err
+	e.loading = nil
+	close(loading)
+}
+
+// fetch 下载并解析 PACURL，下载不经过 hook 和代理
+func (e *pacEngine) fetch(ctx context.Context) (*pac.Script, error) {
+	ctx, cancel := context.WithTimeout(ctx, pacFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACFetch, err.Error())
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: DirectDialer().DialContext}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACFetch, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WrapError(errors.ErrPACFetch, fmt.Sprintf("%s: %s", e.url, resp.Status))
+	}
+	src, err := io.ReadAll(io.LimitReader(resp.Body, pacMaxSize))
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACFetch, err.Error())
+	}
+	script, err := pac.Parse(string(src))
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACScript, e.url+": "+err.Error())
+	}
+	return script, nil
+}
+
+// route 执行脚本，返回目标按顺序尝试的路径；只路由 TCP，脚本不可用或执行失败时返回错误
+func (e *pacEngine) route(ctx context.Context, addr string) ([]pac.Proxy, error) {
+	script, err := e.load(ctx)
+	if script == nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	env := &pac.Env{
+		Resolve: e.resolve,
+		MyIP:    myIPAddress,
+		Now:     e.clock.Now,
+	}
+	result, err := script.FindProxyForURL(ctx, env, pacURL(host, port), host)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACScript, err.Error())
+	}
+	route, err := pac.ParseResult(result)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrPACScript, err.Error())
+	}
+	e.remember(route)
+	return route, nil
+}
+
+// pacURL 按端口合成传给 FindProxyForURL 的地址，hook 只知道目标的主机和端口
+func pacURL(host, port string) string {
+	addr := net.JoinHostPort(host, port)
+	switch port {
+	case "443":
+		return "https://" + strings.TrimSuffix(addr, ":443") + "/"
+	case "80":
+		addr = strings.TrimSuffix(addr, ":80")
+	}
+	return "http://" + addr + "/"
+}
+
+func (e *pacEngine) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := e.pm.localResolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// myIPAddress 返回默认路由使用的本机地址，UDP 连接不发送数据
+func myIPAddress() net.IP {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// remember 记录脚本返回的代理地址，hook 拦截到发往这些地址的连接时直连
+func (e *pacEngine) remember(route []pac.Proxy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, p := range route {
+		if p.Type == "DIRECT" {
+			continue
+		}
+		known := false
+		for _, addr := range e.proxies {
+			if hostport.Equal(addr, p.Addr) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			e.proxies = append(e.proxies, p.Addr)
+		}
+	}
+}
+
+// isProxyAddr 判断 addr 是否为脚本返回过的代理地址
+func (e *pacEngine) isProxyAddr(addr string) bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, p := range e.proxies {
+		if hostport.Equal(addr, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialer 返回路径对应的拨号器，代理拨号器按地址缓存
+func (e *pacEngine) dialer(p pac.Proxy) (ProxyDialer, error) {
+	if p.Type == "DIRECT" {
+		return e.pm.unhookedDialer(), nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if d, ok := e.dialers[p]; ok {
+		return d, nil
+	}
+	host, portStr, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s: %w", p, err)
+	}
+	var d ProxyDialer
+	switch p.Type {
+	case "PROXY", "HTTP":
+		d, err = createHTTPProxyDialer(C.HTTP, host, port, e.config.HTTPConfig, e.metrics)
+	case "HTTPS":
+		d, err = createHTTPProxyDialer(C.HTTPS, host, port, e.config.HTTPConfig, e.metrics)
+	case "SOCKS", "SOCKS5":
+		// 与浏览器一致，主机名交给 SOCKS5 代理解析，内网域名在本地可能无法解析
+		d, err = createSocksDialer(C.SOCKS5H, host, port, false, e.config.SOCKSConfig, e.metrics)
+	case "SOCKS4":
+		d, err = createSocksDialer(C.SOCKS4, host, port, false, e.config.SOCKSConfig, e.metrics)
+	default:
+		err = fmt.Errorf("unsupported pac proxy type %s", p.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.pm.bindDialer(d)
+	e.dialers[p] = d
+	return d, nil
+}
+
+// close 取消后台下载并关闭缓存的代理拨号器
+func (e *pacEngine) close() {
+	if e == nil {
+		return
+	}
+	e.cancel()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.dialers {
+		closeDialer(d)
+	}
+	clear(e.dialers)
+}
+
+// pacDecision 把脚本的结果转换为路由决策，第一个路径决定 hook 直连还是交给管理器
+func pacDecision(route []pac.Proxy) rules.Decision {
+	entries := make([]string, len(route))
+	for i, p := range route {
+		entries[i] = p.String()
+	}
+	decision := rules.Decision{Action: rules.Proxy, Reason: "pac " + strings.Join(entries, "; ")}
+	if route[0].Type == "DIRECT" {
+		decision.Action = rules.Direct
+	}
+	return decision
+}
+
+// pacDialer 按脚本返回的顺序尝试各个路径，直到有一个成功
+type pacDialer struct {
+	engine *pacEngine
+	route  []pac.Proxy
+}
+
+func (d pacDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d pacDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var lastErr error
+	for _, p := range d.route {
+		dialer, err := d.engine.dialer(p)
+		if err == nil {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, addr); err == nil {
+				return conn, nil
+			}
+		}
+		lastErr = fmt.Errorf("pac %s: %w", p, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// pipeBacklog 进程内管道连接的等待队列长度
+const pipeBacklog = 128
+
+// pipeAddr 进程内管道连接的本地地址
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeConn 带真实监听地址的 net.Pipe 连接
+type pipeConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+func (c *pipeConn) Unwrap() net.Conn     { return c.Conn }
+
+// PipeListener 同时接受真实连接和进程内管道连接的监听器
+//
+// 启用 Config.SelfPipe 后，本进程发往该监听地址的拨号不经过网络和代理，
+// 而是通过内存管道直接交给 Accept
+type PipeListener struct {
+	net.Listener
+
+	conns chan net.Conn
+	errs  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+var (
+	pipeMu        sync.RWMutex
+	pipeListeners = make(map[*PipeListener]struct{})
+)
+
+// WrapListener 包装监听器并登记为可短路的自连接目标
+func WrapListener(ln net.Listener) *PipeListener {
+	l := &PipeListener{
+		Listener: ln,
+		conns:    make(chan net.Conn, pipeBacklog),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+
+	pipeMu.Lock()
+	pipeListeners[l] = struct{}{}
+	pipeMu.Unlock()
+
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop 将真实连接和 Accept 的错误转入统一的 Accept 队列
+// 临时错误(如文件描述符耗尽)交给调用方处理后继续接受，监听器关闭后退出
+func (l *PipeListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept 返回下一个真实连接或管道连接
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听器并取消登记
+func (l *PipeListener) Close() error {
+	l.once.Do(func() {
+		pipeMu.Lock()
+		delete(pipeListeners, l)
+		pipeMu.Unlock()
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// DialContext 建立到该监听器的进程内管道连接
+func (l *PipeListener) DialContext(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	addr := l.Listener.Addr()
+
+	select {
+	case l.conns <- &pipeConn{Conn: server, local: addr, remote: pipeAddr{}}:
+		return &pipeConn{Conn: client, local: pipeAddr{}, remote: addr}, nil
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// lookupPipeListener 查找目标地址对应的本进程管道监听器，只支持 TCP
+func lookupPipeListener(network, addr string) *PipeListener {
+	if !rules.IsTCPNetwork(network) {
+		return nil
+	}
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	if hostport.CanonicalHost(host) == "localhost" {
+		ips = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	} else if ip := hostport.ParseIP(host); ip != nil && isLocalIP(ip) {
+		ips = []net.IP{ip}
+	} else {
+		return nil
+	}
+
+	pipeMu.RLock()
+	defer pipeMu.RUnlock()
+	for l := range pipeListeners {
+		tcpAddr, ok := l.Listener.Addr().(*net.TCPAddr)
+		if !ok || tcpAddr.Port != port {
+			continue
+		}
+		for _, ip := range ips {
+			if tcpAddr.IP.IsUnspecified() || tcpAddr.IP.Equal(ip) {
+				return l
+			}
+		}
+	}
+	return nil
+}
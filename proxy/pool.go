@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+const (
+	// DefaultPoolMaxIdle 连接池每个键默认保留的空闲连接数
+	DefaultPoolMaxIdle = 2
+	// DefaultPoolIdleTimeout 连接池默认保留空闲连接的时间
+	DefaultPoolIdleTimeout = 90 * time.Second
+
+	// idleProbeTimeout 复用前检查空闲连接是否已被对端关闭的等待时间
+	idleProbeTimeout = time.Millisecond
+
+	// 已认证的代理连接每组凭证保留的数量和时间
+	rawPoolMaxIdle     = 4
+	rawPoolIdleTimeout = 90 * time.Second
+)
+
+// PoolConfig 连接池设置
+type PoolConfig struct {
+	MaxIdlePerKey int           // 每个键保留的空闲连接数，0 时为 DefaultPoolMaxIdle
+	IdleTimeout   time.Duration // 空闲连接保留的最长时间，0 时为 DefaultPoolIdleTimeout
+}
+
+// PoolKey 连接池的键
+// 经过代理的连接是到某个目标的隧道，只能复用于同一代理端点、同一目标和同一凭证的拨号
+type PoolKey struct {
+	Proxy       string // 代理端点，如 socks5://10.0.0.1:1080，直连为 direct，PAC 路由为 pac 加脚本给出的路径
+	Network     string
+	Target      string      // 规范化的目标地址
+	Credentials Credentials // WithCredentials 或路由规则指定的用户名和密码
+}
+
+// Pool 按 PoolKey 复用到目标的连接
+// 复用前检查连接仍由当前的代理拨号器建立(UpdateConfig 后旧隧道不再复用)且没有被对端关闭；
+// 只有 TCP 连接进入池，调用方只应归还处于协议边界上、可以直接发送下一个请求的连接
+type Pool struct {
+	pm     *ProxyManager
+	config PoolConfig
+
+	mu     sync.Mutex
+	idle   map[PoolKey][]*pooledConn
+	closed bool
+}
+
+// pooledConn 池中的连接，记录建立时的键和拨号器
+type pooledConn struct {
+	net.Conn
+	key    PoolKey
+	dialer ProxyDialer
+	since  time.Time // 归还到池中的时间
+}
+
+func (c *pooledConn) Unwrap() net.Conn { return c.Conn }
+
+// NewPool 创建通过 pm 拨号的连接池
+func NewPool(pm *ProxyManager, config PoolConfig) *Pool {
+	if config.MaxIdlePerKey <= 0 {
+		config.MaxIdlePerKey = DefaultPoolMaxIdle
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultPoolIdleTimeout
+	}
+	return &Pool{pm: pm, config: config, idle: make(map[PoolKey][]*pooledConn)}
+}
+
+// Get 返回到 addr 的连接，池中有同一键的可用连接时复用，否则通过 ProxyManager 拨号
+func (p *Pool) Get(ctx context.Context, network, addr string) (net.Conn, error) {
+	key, dialer, ok := p.key(ctx, network, addr)
+	if ok {
+		if conn := p.take(key, dialer); conn != nil {
+			return conn, nil
+		}
+	}
+	conn, err := p.pm.DialContext(ctx, network, addr)
+	if err != nil || !ok {
+		return conn, err
+	}
+	return &pooledConn{Conn: conn, key: key, dialer: dialer}, nil
+}
+
+// Put 归还 Get 返回的连接，池已满、已关闭或连接不是来自池时关闭连接
+func (p *Pool) Put(conn net.Conn) {
+	pc, ok := conn.(*pooledConn)
+	if !ok {
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	if p.closed || len(p.idle[pc.key]) >= p.config.MaxIdlePerKey {
+		p.mu.Unlock()
+		pc.Conn.Close()
+		return
+	}
+	pc.since = p.pm.Clock().Now()
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+	p.mu.Unlock()
+}
+
+// Close 关闭池中的空闲连接，之后归还的连接直接关闭
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[PoolKey][]*pooledConn)
+	p.closed = true
+	p.mu.Unlock()
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.Conn.Close()
+		}
+	}
+	return nil
+}
+
+// Idle 返回池中的空闲连接数
+func (p *Pool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, conns := range p.idle {
+		n += len(conns)
+	}
+	return n
+}
+
+// take 取出 key 下最近归还的可用连接，过期、由其他拨号器建立或已被关闭的连接被丢弃
+func (p *Pool) take(key PoolKey, dialer ProxyDialer) *pooledConn {
+	now := p.pm.Clock().Now()
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		pc := conns[len(conns)-1]
+		if len(conns) == 1 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = conns[:len(conns)-1]
+		}
+		p.mu.Unlock()
+
+		if pc.dialer == dialer && now.Sub(pc.since) < p.config.IdleTimeout && idleAlive(pc.Conn) {
+			return pc
+		}
+		pc.Conn.Close()
+	}
+}
+
+// key 按当前配置返回 addr 的池键和建立连接的拨号器，UDP、Unix 和被拒绝的目标不进入池
+// 路由决策与 DialContext 相同，决策变化(如 UpdateConfig 或路由回调)后得到新的键
+func (p *Pool) key(ctx context.Context, network, addr string) (PoolKey, ProxyDialer, bool) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return PoolKey{}, nil, false
+	}
+	// 按拨号生成的 Proxy-Authorization 无法比较，这样的连接不进入池
+	if _, ok := ProxyAuthorizerFromContext(ctx); ok {
+		return PoolKey{}, nil, false
+	}
+	pm := p.pm
+	decision, route, routed := pm.explain(ctx, network, addr)
+	if decision.Action == rules.Reject {
+		return PoolKey{}, nil, false
+	}
+
+	key := PoolKey{Network: network, Target: hostport.Canonical(addr)}
+	switch {
+	case route != nil:
+		paths := make([]string, len(route))
+		for i, proxy := range route {
+			paths[i] = proxy.String()
+		}
+		key.Proxy = "pac " + strings.Join(paths, "; ")
+	case (decision.Rule != nil || routed) && decision.Action == rules.Direct,
+		!pm.Config.Enable, pm.Config.ProxyType == C.Direct:
+		key.Proxy = "direct"
+	default:
+		key.Proxy = string(pm.Config.ProxyType) + "://" + pm.Config.GetProxyAddr()
+		named, err := pm.namedProxyFor(decision)
+		if err != nil {
+			return PoolKey{}, nil, false
+		}
+		if named != nil {
+			key.Proxy = string(named.proxyType) + "://" + named.addr
+		} else if pm.balance != nil {
+			key.Proxy = pm.balance.String()
+		}
+	}
+	if creds, ok := CredentialsFromContext(ctx); ok {
+		key.Credentials = creds
+	} else if decision.Rule != nil {
+		key.Credentials = Credentials{User: decision.Rule.User, Pass: decision.Rule.Pass}
+	}
+	return key, pm.GetDialer(), true
+}
+
+// idleAlive 检查空闲连接没有被对端关闭，也没有收到未预期的数据
+func idleAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(idleProbeTimeout)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	ne, ok := err.(net.Error)
+	alive := ok && ne.Timeout()
+	conn.SetReadDeadline(time.Time{})
+	return alive
+}
+
+// rawPool 已认证的到代理的空闲连接，按凭证区分
+// HTTP/1.1 代理拒绝 CONNECT 但保持连接时，连接停在已认证的请求边界上，可以在上面 CONNECT 其他目标；
+// 建立隧道后的连接绑定在目标上不再进入这里，SOCKS 每个连接只能发送一次请求，不使用它
+type rawPool struct {
+	mu   sync.Mutex
+	idle map[Credentials][]rawConn
+}
+
+type rawConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// get 取出 creds 认证过的可用连接，没有时返回 nil
+func (p *rawPool) get(creds Credentials) net.Conn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[creds]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		rc := conns[len(conns)-1]
+		p.idle[creds] = conns[:len(conns)-1]
+		p.mu.Unlock()
+
+		if time.Since(rc.since) < rawPoolIdleTimeout && idleAlive(rc.conn) {
+			return rc.conn
+		}
+		rc.conn.Close()
+	}
+}
+
+// put 保存 creds 认证过的连接，已满时关闭连接
+func (p *rawPool) put(creds Credentials, conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle == nil {
+		p.idle = make(map[Credentials][]rawConn)
+	}
+	if len(p.idle[creds]) >= rawPoolMaxIdle {
+		conn.Close()
+		return
+	}
+	p.idle[creds] = append(p.idle[creds], rawConn{conn: conn, since: time.Now()})
+}
+
+// close 关闭全部空闲连接
+func (p *rawPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, conns := range idle {
+		for _, rc := range conns {
+			rc.conn.Close()
+		}
+	}
+}
+
+// staleConnError 判断复用的代理连接上的 CONNECT 是否在得到有效响应前失败，这时应换新连接重试
+func staleConnError(err error) bool {
+	return errors.Is(err, E.ErrProxyNegotiation)
+}
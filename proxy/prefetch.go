@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// PrefetchResolver 缓存 Upstream 的解析结果，并在过期前后台刷新经常解析的主机名，热点拨号不会阻塞在解析上
+// 过期前 RefreshAhead 内的命中会触发后台刷新，Run 还会定期刷新最近用过、即将过期的条目
+// 缓存最多保存 MaxHosts 个主机名，满了以后替换解析次数最少的条目
+// 上游返回错误时不缓存，所以 RemoteResolver 的主机名仍然交给代理解析；不要包装 FakeIPResolver
+type PrefetchResolver struct {
+	Upstream     Resolver      // 为 nil 时使用 SystemResolver，可以是 DoHResolver
+	TTL          time.Duration // 解析结果的缓存时间
+	RefreshAhead time.Duration // 过期前多久开始后台刷新
+	MaxHosts     int           // 缓存的主机名数上限
+
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*prefetchEntry
+
+	hits           int64
+	misses         int64
+	prefetches     int64
+	prefetchErrors int64
+}
+
+type prefetchEntry struct {
+	addrs      []net.IPAddr
+	expires    time.Time
+	lookups    int64 // 累计解析次数，用于挑选替换的条目
+	used       bool  // 上次刷新后是否被解析过，Run 只刷新用过的条目
+	refreshing bool
+}
+
+// NewPrefetchResolver 创建包装 upstream 的预取解析器，clk 为 nil 时使用 clock.Real
+// 通过 pm.SetResolver 设置后，命中率等统计出现在 pm.Metrics 的快照和 Prometheus 指标中
+func NewPrefetchResolver(upstream Resolver, clk clock.Clock) *PrefetchResolver {
+	return &PrefetchResolver{
+		Upstream:     upstream,
+		TTL:          C.DefaultPrefetchTTL,
+		RefreshAhead: C.DefaultPrefetchRefreshAhead,
+		MaxHosts:     C.DefaultPrefetchMaxHosts,
+		clock:        clock.OrReal(clk),
+		entries:      make(map[string]*prefetchEntry),
+	}
+}
+
+func (r *PrefetchResolver) upstream() Resolver {
+	if r.Upstream == nil {
+		return SystemResolver{}
+	}
+	return r.Upstream
+}
+
+// LookupIPAddr 实现 Resolver 接口，IP 字面量直接交给 Upstream
+func (r *PrefetchResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if hostport.ParseIP(host) != nil {
+		return r.upstream().LookupIPAddr(ctx, host)
+	}
+	key := hostport.CanonicalHost(host)
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok && now.Before(e.expires) {
+		e.lookups++
+		e.used = true
+		addrs := append([]net.IPAddr(nil), e.addrs...)
+		refresh := !e.refreshing && !now.Before(e.expires.Add(-r.RefreshAhead))
+		if refresh {
+			e.refreshing = true
+		}
+		r.mu.Unlock()
+
+		atomic.AddInt64(&r.hits, 1)
+		if refresh {
+			go r.refresh(key)
+		}
+		return addrs, nil
+	}
+	r.mu.Unlock()
+
+	atomic.AddInt64(&r.misses, 1)
+	addrs, err := r.upstream().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.store(key, addrs)
+	return append([]net.IPAddr(nil), addrs...), nil
+}
+
+// store 缓存 key 的解析结果，缓存满时替换解析次数最少的条目
+func (r *PrefetchResolver) store(key string, addrs []net.IPAddr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[key]
+	if !ok {
+		if r.MaxHosts > 0 && len(r.entries) >= r.MaxHosts {
+			r.evict()
+		}
+		e = &prefetchEntry{}
+		r.entries[key] = e
+	}
+	e.addrs = addrs
+	e.expires = r.clock.Now().Add(r.TTL)
+	e.lookups++
+	e.used = true
+}
+
+// evict 删除解析次数最少的条目，调用方持有 r.mu
+func (r *PrefetchResolver) evict() {
+	var victim string
+	var fewest int64 = -1
+	for key, e := range r.entries {
+		if e.refreshing {
+			continue
+		}
+		if fewest < 0 || e.lookups < fewest {
+			victim, fewest = key, e.lookups
+		}
+	}
+	if fewest >= 0 {
+		delete(r.entries, victim)
+	}
+}
+
+// refresh 在后台重新解析 key，失败时保留旧结果直到过期
+func (r *PrefetchResolver) refresh(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultPrefetchTimeout)
+	defer cancel()
+	addrs, err := r.upstream().LookupIPAddr(ctx, key)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[key]
+	if !ok {
+		return
+	}
+	e.refreshing = false
+	if err != nil {
+		atomic.AddInt64(&r.prefetchErrors, 1)
+		return
+	}
+	atomic.AddInt64(&r.prefetches, 1)
+	e.addrs = addrs
+	e.expires = r.clock.Now().Add(r.TTL)
+	e.used = false
+}
+
+// Run 定期刷新上次刷新后被解析过、将在 RefreshAhead 内过期的条目，直到 ctx 结束
+// 不调用 Run 时只在命中即将过期的条目时刷新
+func (r *PrefetchResolver) Run(ctx context.Context) {
+	interval := r.RefreshAhead / 2
+	if interval <= 0 {
+		interval = C.DefaultPrefetchRefreshAhead / 2
+	}
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			for _, key := range r.due() {
+				r.refresh(key)
+			}
+		}
+	}
+}
+
+// due 返回需要刷新的条目并标记为刷新中，同时删除已经过期且没有再用过的条目
+func (r *PrefetchResolver) due() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	deadline := now.Add(r.RefreshAhead)
+	var keys []string
+	for key, e := range r.entries {
+		if e.refreshing {
+			continue
+		}
+		if !e.used && !now.Before(e.expires) {
+			delete(r.entries, key)
+			continue
+		}
+		if e.used && e.expires.Before(deadline) {
+			e.refreshing = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Stats 返回缓存统计
+func (r *PrefetchResolver) Stats() metrics.DNSCacheStats {
+	r.mu.Lock()
+	hosts := len(r.entries)
+	r.mu.Unlock()
+
+	stats := metrics.DNSCacheStats{
+		Hits:           atomic.LoadInt64(&r.hits),
+		Misses:         atomic.LoadInt64(&r.misses),
+		Prefetches:     atomic.LoadInt64(&r.prefetches),
+		PrefetchErrors: atomic.LoadInt64(&r.prefetchErrors),
+		Hosts:          hosts,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
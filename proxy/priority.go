@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// priorityKey context 中保存单次拨号优先级的键
+type priorityKey struct{}
+
+// WithPriority 为经过 ctx 的拨号指定优先级类别，优先于路由规则中的 Priority
+//
+//	ctx = proxy.WithPriority(ctx, config.PriorityBulk)
+func WithPriority(ctx context.Context, p C.Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityOf 返回拨号的优先级类别: ctx 中指定的优先，其次是命中的规则，默认为 interactive
+func priorityOf(ctx context.Context, rule *rules.Rule) C.Priority {
+	if p, ok := ctx.Value(priorityKey{}).(C.Priority); ok && p != "" {
+		return p
+	}
+	if rule != nil && rule.Priority != "" {
+		return rule.Priority
+	}
+	return C.PriorityInteractive
+}
+
+// scheduler 按优先级类别调度经过代理的拨号和流量
+type scheduler struct {
+	dials   *dialQueue       // 为 nil 时不限制并发拨号
+	limiter *priorityLimiter // 为 nil 时不限速
+}
+
+// newScheduler config 为 nil 或没有设置任何限制时返回 nil
+func newScheduler(config *C.SchedulerConfig, clk clock.Clock) *scheduler {
+	if config == nil || (config.MaxConcurrentDials <= 0 && config.Rate <= 0) {
+		return nil
+	}
+	s := &scheduler{}
+	if config.MaxConcurrentDials > 0 {
+		s.dials = &dialQueue{max: config.MaxConcurrentDials}
+	}
+	if config.Rate > 0 {
+		burst := config.Burst
+		if burst <= 0 {
+			burst = config.Rate
+		}
+		s.limiter = &priorityLimiter{
+			rate:   float64(config.Rate),
+			burst:  float64(burst),
+			clock:  clk,
+			tokens: float64(burst),
+			last:   clk.Now(),
+		}
+	}
+	return s
+}
+
+// acquireDial 按优先级占用拨号名额，不限制并发拨号时直接返回
+func (s *scheduler) acquireDial(ctx context.Context, p C.Priority) error {
+	if s == nil || s.dials == nil {
+		return nil
+	}
+	return s.dials.acquire(ctx, p)
+}
+
+// releaseDial 拨号结束后归还名额
+func (s *scheduler) releaseDial() {
+	if s == nil || s.dials == nil {
+		return
+	}
+	s.dials.release()
+}
+
+// wrap 为限速包装连接，不限速时原样返回
+func (s *scheduler) wrap(conn net.Conn, p C.Priority) net.Conn {
+	if s == nil || s.limiter == nil {
+		return conn
+	}
+	return &priorityConn{Conn: conn, limiter: s.limiter, priority: p}
+}
+
+// dialQueue 限制同时进行的拨号数，空出名额时先交给等待中的 interactive 拨号
+type dialQueue struct {
+	max int
+
+	mu      sync.Mutex
+	active  int
+	waiters [2][]chan struct{} // 按类别排队的等待者，下标为 priorityIndex
+}
+
+// priorityIndex interactive 为 0，bulk 为 1
+func priorityIndex(p C.Priority) int {
+	if p == C.PriorityBulk {
+		return 1
+	}
+	return 0
+}
+
+// acquire 占用一个拨号名额，没有空位时排队等待，ctx 结束时返回对应的错误
+func (q *dialQueue) acquire(ctx context.Context, p C.Priority) error {
+	q.mu.Lock()
+	if q.active < q.max && len(q.waiters[0]) == 0 && len(q.waiters[1]) == 0 {
+		q.active++
+		q.mu.Unlock()
+		return nil
+	}
+	i := priorityIndex(p)
+	ch := make(chan struct{})
+	q.waiters[i] = append(q.waiters[i], ch)
+	q.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		for j, w := range q.waiters[i] {
+			if w == ch {
+				q.waiters[i] = append(q.waiters[i][:j], q.waiters[i][j+1:]...)
+				q.mu.Unlock()
+				return contextError(ctx)
+			}
+		}
+		q.mu.Unlock()
+		// 名额已经交给了本次拨号，转交给下一个等待者
+		q.release()
+		return contextError(ctx)
+	}
+}
+
+// release 归还名额，有等待者时直接转交，interactive 先于 bulk
+func (q *dialQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.waiters {
+		if len(q.waiters[i]) > 0 {
+			ch := q.waiters[i][0]
+			q.waiters[i] = q.waiters[i][1:]
+			close(ch)
+			return
+		}
+	}
+	q.active--
+}
+
+// priorityLimiter 所有经过代理的连接共享的令牌桶
+// interactive 的流量先收发后扣除令牌，可以透支；bulk 在令牌足够时才扣除，
+// 因此 interactive 的透支会让 bulk 等待，bulk 只使用 interactive 剩下的带宽
+type priorityLimiter struct {
+	rate  float64 // 每秒补充的令牌(字节)
+	burst float64
+	clock clock.Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill 按经过的时间补充令牌，调用方持有 mu
+func (l *priorityLimiter) refill() {
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// wait 记录收发了 n 字节，按类别等待令牌
+func (l *priorityLimiter) wait(p C.Priority, n int) {
+	if n <= 0 {
+		return
+	}
+	need := float64(n)
+	if p == C.PriorityBulk {
+		// 超过突发大小的读写等待桶满后扣除，透支的部分由之后的 bulk 流量偿还
+		threshold := min(need, l.burst)
+		for {
+			l.mu.Lock()
+			l.refill()
+			if l.tokens >= threshold {
+				l.tokens -= need
+				l.mu.Unlock()
+				return
+			}
+			delay := l.delay(threshold - l.tokens)
+			l.mu.Unlock()
+			l.clock.Sleep(delay)
+		}
+	}
+
+	l.mu.Lock()
+	l.refill()
+	l.tokens -= need
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = l.delay(-l.tokens)
+	}
+	l.mu.Unlock()
+	if delay > 0 {
+		l.clock.Sleep(delay)
+	}
+}
+
+// delay 返回补充 deficit 个令牌需要的时间，至少 1ms
+func (l *priorityLimiter) delay(deficit float64) time.Duration {
+	return max(time.Duration(deficit/l.rate*float64(time.Second)), time.Millisecond)
+}
+
+// priorityConn 按优先级类别共享限速的连接
+type priorityConn struct {
+	net.Conn
+	limiter  *priorityLimiter
+	priority C.Priority
+}
+
+func (c *priorityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.limiter.wait(c.priority, n)
+	return n, err
+}
+
+func (c *priorityConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.limiter.wait(c.priority, n)
+	return n, err
+}
+
+func (c *priorityConn) Unwrap() net.Conn { return c.Conn }
@@ -3,13 +3,20 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ba0gu0/GoHookProxy/clock"
 	C "github.com/ba0gu0/GoHookProxy/config"
 	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/health"
+	"github.com/ba0gu0/GoHookProxy/hostport"
 	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/pac"
+	"github.com/ba0gu0/GoHookProxy/rules"
 )
 
 // ProxyManager 代理管理器
@@ -17,7 +24,46 @@ type ProxyManager struct {
 	mu      sync.RWMutex
 	Config  *C.Config
 	dialer  ProxyDialer
+	race    *raceDialer
+	udp     *udpProxy
+	named   map[string]*namedProxy       // Config.Proxies 的拨号器，未配置时为 nil
+	using   atomic.Pointer[string]       // Use 选择的命名代理，为 nil 时使用主代理
+	balance *balanceDialer               // 在主代理和命名代理之间分配拨号，未配置时为 nil
+	health  *health.Checker              // 探测主代理和命名代理，未配置时为 nil
+	rules   atomic.Pointer[rules.Engine] // RulesFile 变化时由监视协程替换
+	quotas  *quotaEnforcer
+	caps    *byteCapEnforcer
+	failed  *negativeCache
+	sched   *scheduler
+	nat64   *nat64Translator
+	addrs   *addrSelector
+	pac     *pacEngine
+	hints   *resolvedHints
+	slo     *metrics.SLOTracker
 	Metrics *metrics.MetricsCollector
+	clock   clock.Clock
+
+	resolver  Resolver          // 为 nil 时使用 SystemResolver
+	negotiate NegotiateProvider // HTTP 代理 Negotiate 认证的令牌提供者
+	route     RouteFunc         // 程序化的路由回调，为 nil 时只使用配置的规则
+
+	onQuotaExceeded atomic.Pointer[func(QuotaEvent)]
+	onSLOAtRisk     func(metrics.SLOEvent)
+	onExportError   atomic.Pointer[func(error)] // 由推送协程读取
+	onFallback      atomic.Pointer[func(FallbackEvent)]
+	onRulesReload   atomic.Pointer[func(error)]
+	onHealth        atomic.Pointer[func(health.Status)]
+	recorder        atomic.Pointer[metrics.Recorder] // SetRecorder 设置的 Recorder，为 nil 时使用 Metrics
+
+	stopOTLP   func(context.Context) error // 停止 OTLP 推送并做最后一次导出，未推送时为 nil
+	rulesWatch *rulesWatcher               // 监视 RulesFile，未配置时为 nil
+
+	shutdown      atomic.Bool                   // 已调用 Shutdown
+	shutdownFuncs []func(context.Context) error // OnShutdown 注册的函数
+
+	waiting int32 // direct_until_healthy 的直连阶段为 1
+
+	directManaged bool // 由 NewDirectManaged 创建，路由规则照常生效
 }
 
 // ProxyDialer 代理拨号器接口
@@ -26,18 +72,35 @@ type ProxyDialer interface {
 	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
+// PacketDialer 支持 UDP 的拨号器实现的可选接口，ProxyManager 通过它转发 UDP
+type PacketDialer interface {
+	// DialPacketContext 建立到 addr 的 UDP 连接，Read/Write 只收发负载
+	DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error)
+	// ListenPacket 建立不固定目标的 UDP 套接字，每个数据报通过 WriteTo 指定目标
+	ListenPacket(ctx context.Context, network string) (net.PacketConn, error)
+}
+
 // New 创建代理管理器
 func New(config *C.Config) (*ProxyManager, error) {
+	return NewWithClock(config, clock.Real)
+}
+
+// NewWithClock 创建使用 clk 计时的代理管理器，配额周期、启动探测、竞速延迟和指标的速率窗口都按 clk 计算
+// 能力缓存由所有管理器共用，始终使用真实时间
+// 测试中传入 clock.Fake 可以手动推进时间；clk 为 nil 时使用 clock.Real
+func NewWithClock(config *C.Config, clk clock.Clock) (*ProxyManager, error) {
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	pm := &ProxyManager{}
+	pm := &ProxyManager{clock: clock.OrReal(clk)}
 
 	// 只在启用指标收集时创建 MetricsCollector
 	if config.MetricsEnable {
 		pm.Metrics = metrics.NewMetricsCollector()
+		pm.Metrics.SetClock(pm.Clock())
 	}
+	pm.caps = newByteCapEnforcer(pm.Clock(), pm.Metrics)
 
 	// 更新配置
 	if err := pm.UpdateConfig(config); err != nil {
@@ -52,9 +115,40 @@ func (pm *ProxyManager) UpdateConfig(config *C.Config) error {
 	// pm.mu.Lock()
 	// defer pm.mu.Unlock()
 
+	if pm.IsShutdown() {
+		return errors.ErrManagerShutdown
+	}
+
 	if config == nil {
+		pm.updateOTLP(pm.Config, nil)
+		pm.health.Stop()
+		closeDialer(pm.dialer)
+		pm.race.close()
+		pm.udp.close()
+		closeNamedProxies(pm.named)
 		pm.Config = nil
 		pm.dialer = nil
+		pm.race = nil
+		pm.udp = nil
+		pm.named = nil
+		pm.balance = nil
+		pm.health = nil
+		pm.using.Store(nil)
+		pm.rules.Store(nil)
+		pm.quotas = nil
+		pm.failed = nil
+		pm.sched = nil
+		pm.nat64 = nil
+		pm.addrs = nil
+		pm.pac.close()
+		pm.pac = nil
+		pm.rulesWatch.close()
+		pm.rulesWatch = nil
+		pm.hints = nil
+		pm.slo = nil
+		if pm.Metrics != nil {
+			pm.Metrics.SetSLOTracker(nil)
+		}
 		return nil
 	}
 
@@ -62,16 +156,141 @@ func (pm *ProxyManager) UpdateConfig(config *C.Config) error {
 		return err
 	}
 
-	dialer, err := createProxyDialer(config, pm.Metrics)
+	if config.Enable && config.ProxyType == C.Auto {
+		ctx, cancel := context.WithTimeout(context.Background(), autoDiscoverTimeout)
+		resolved, err := resolveAuto(ctx, config)
+		cancel()
+		if err != nil {
+			return err
+		}
+		config = resolved
+	}
+
+	var (
+		dialer     ProxyDialer
+		race       *raceDialer
+		udp        *udpProxy
+		named      map[string]*namedProxy
+		autoConfig *pacEngine
+		watch      *rulesWatcher
+		committed  bool
+	)
+	// 任何一步失败时关闭已经创建的组件，新配置不生效
+	defer func() {
+		if committed {
+			return
+		}
+		closeDialer(dialer)
+		race.close()
+		udp.close()
+		closeNamedProxies(named)
+		autoConfig.close()
+		watch.close()
+	}()
+
+	dialer, err := createProxyDialer(config, pm.dialRecorder())
 	if err != nil {
 		return err
 	}
+	if err := applyTransport(dialer, config); err != nil {
+		return err
+	}
+	pm.bindDialer(dialer)
+
+	if race, err = newRaceDialer(config, dialer, pm, pm.Clock()); err != nil {
+		return err
+	}
+	if udp, err = newUDPProxy(config, pm); err != nil {
+		return err
+	}
+	if named, err = newNamedProxies(config, pm); err != nil {
+		return err
+	}
+	if autoConfig, err = newPACEngine(ifFeature(config, C.FeaturePAC, config), pm); err != nil {
+		return err
+	}
+	if watch, err = newRulesWatcher(config, pm); err != nil {
+		return err
+	}
+	committed = true
+
+	if pm.Metrics != nil {
+		pm.Metrics.SetSampleRate(config.MetricsSampleRate)
+		pm.Metrics.SetMaxLabelSets(config.MetricsMaxLabelSets)
+	}
+
+	slo := pm.newSLOTracker(pm.Config, config)
+	if pm.Metrics != nil {
+		pm.Metrics.SetSLOTracker(slo)
+	}
+
+	checker := newHealthChecker(config, dialer, named, pm)
 
+	pm.updateOTLP(pm.Config, config)
+	pm.health.Stop()
+	closeDialer(pm.dialer)
+	pm.race.close()
+	pm.udp.close()
+	closeNamedProxies(pm.named)
 	pm.Config = config
+	pm.slo = slo
 	pm.dialer = dialer
+	pm.race = race
+	pm.udp = udp
+	pm.named = named
+	pm.health = checker
+	pm.balance = newBalanceDialer(config, dialer, named, checker, pm)
+	pm.keepUsing(config)
+	pm.pac.close()
+	pm.pac = autoConfig
+	atomic.StoreInt32(&pm.waiting, 0)
+	pm.rulesWatch.close()
+	pm.rules.Store(pm.buildRules(config, watch.ruleSet()))
+	pm.rulesWatch = watch
+	watch.start()
+	checker.Start()
+	pm.quotas = newQuotaEnforcer(config.Quotas, pm.Clock(), pm.notifyQuotaExceeded)
+	pm.failed = newNegativeCache(ifFeature(config, C.FeatureNegativeCache, config.NegativeCacheTTL), pm.Clock())
+	pm.sched = newScheduler(ifFeature(config, C.FeatureScheduler, config.Scheduler), pm.Clock())
+	pm.nat64 = newNAT64Translator(ifFeature(config, C.FeatureNAT64, config.NAT64), pm.localResolver(), pm.Clock())
+	pm.addrs = newAddrSelector(ifFeature(config, C.FeatureAddrSelection, config.AddrSelection), pm.Clock())
+	pm.hints = newResolvedHints(ifFeature(config, C.FeatureResolvedHints, config.ResolvedHints), pm.Clock())
 	return nil
 }
 
+// OnQuotaExceeded 设置配额超出时的回调，每个标签值的流量配额每个周期只通知一次
+func (pm *ProxyManager) OnQuotaExceeded(fn func(QuotaEvent)) {
+	pm.onQuotaExceeded.Store(&fn)
+}
+
+// notifyQuotaExceeded 调用 OnQuotaExceeded 设置的回调
+func (pm *ProxyManager) notifyQuotaExceeded(event QuotaEvent) {
+	if fn := pm.onQuotaExceeded.Load(); fn != nil && *fn != nil {
+		(*fn)(event)
+	}
+}
+
+// SetMetricsSampleRate 运行时调整拨号阶段延迟的采样率，每 n 次拨号记录 1 次，n 不大于 1 时全部记录
+// 连接数、失败数和字节数等计数器始终精确；未启用指标收集时不做任何事
+func (pm *ProxyManager) SetMetricsSampleRate(n int) {
+	if pm.Metrics != nil {
+		pm.Metrics.SetSampleRate(n)
+	}
+}
+
+// closeDialer 关闭被替换的拨号器持有的共享会话，如 SSH 会话
+func closeDialer(d ProxyDialer) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Clock 返回代理管理器使用的时间来源
+func (pm *ProxyManager) Clock() clock.Clock {
+	return clock.OrReal(pm.clock)
+}
+
 // GetDialer 获取代理拨号器
 func (pm *ProxyManager) GetDialer() ProxyDialer {
 	// pm.mu.RLock()
@@ -80,29 +299,56 @@ func (pm *ProxyManager) GetDialer() ProxyDialer {
 }
 
 // createProxyDialer 创建代理拨号器
-func createProxyDialer(config *C.Config, metrics *metrics.MetricsCollector) (ProxyDialer, error) {
+func createProxyDialer(config *C.Config, metrics metrics.Recorder) (ProxyDialer, error) {
 	if !config.Enable {
-		return &net.Dialer{
-			Timeout:   config.IdleTimeout,
-			KeepAlive: config.KeepAlive,
-		}, nil
+		return newManagedDirectDialer(config, metrics), nil
 	}
 
+	capabilities.setTTL(ifFeature(config, C.FeatureCapabilityCache, config.CapabilityTTL))
+
 	switch config.ProxyType {
-	case C.HTTP, C.HTTPS, C.HTTP2:
+	case C.HTTP, C.HTTPS, C.HTTP2, C.HTTP3:
 		return createHTTPProxyDialer(config.ProxyType, config.ProxyIP, config.ProxyPort, config.HTTPConfig, metrics)
-	case C.SOCKS4, C.SOCKS5:
-		return createSocksDialer(config.ProxyType, config.ProxyIP, config.ProxyPort, config.SOCKSConfig, metrics)
+	case C.SOCKS4, C.SOCKS4A, C.SOCKS5, C.SOCKS5H, C.SOCKS5S:
+		return createSocksDialer(config.ProxyType, config.ProxyIP, config.ProxyPort, config.HookUDP, config.SOCKSConfig, metrics)
+	case C.VMESS:
+		return createVMessDialer(config.ProxyIP, config.ProxyPort, config.HookUDP, config.VMessConfig, metrics)
+	case C.SSH:
+		return createSSHDialer(config.ProxyIP, config.ProxyPort, config.SSHConfig, metrics)
+	case C.TOR:
+		return createTorDialer(config.ProxyIP, config.ProxyPort, config.SOCKSConfig, config.TorConfig, metrics)
+	case C.WIREGUARD:
+		return createWireGuardDialer(config.ProxyIP, config.ProxyPort, config.WGConfig, metrics)
+	case C.WS, C.WSS:
+		return createWSDialer(config.ProxyType, config.ProxyIP, config.ProxyPort, config.WSConfig, metrics)
+	case C.GRPC:
+		return createGRPCDialer(config.ProxyIP, config.ProxyPort, config.GRPCConfig, metrics)
+	case C.HYSTERIA2:
+		return createHysteria2Dialer(config.ProxyIP, config.ProxyPort, config.Hysteria2Config, metrics)
 	case C.Direct:
-		return &net.Dialer{
-			Timeout:   config.IdleTimeout,
-			KeepAlive: config.KeepAlive,
-		}, nil
+		return newManagedDirectDialer(config, metrics), nil
 	default:
 		return nil, fmt.Errorf("unsupported proxy type: %s", config.ProxyType)
 	}
 }
 
+// bindDialer 把解析器和 Negotiate 令牌提供者交给需要它们的拨号器，拨号时读取 pm 当前的设置
+func (pm *ProxyManager) bindDialer(dialer ProxyDialer) {
+	if rs, ok := dialer.(resolverSetter); ok {
+		rs.setResolver(pm.localResolver())
+	}
+	if ns, ok := dialer.(negotiatorSetter); ok {
+		ns.setNegotiator(pm.NegotiateProvider)
+	}
+}
+
+// recordStage 记录拨号阶段耗时
+func recordStage(r metrics.Recorder, stage metrics.DialStage, start time.Time) {
+	if r != nil {
+		r.RecordStage(stage, time.Since(start))
+	}
+}
+
 // GetMetrics 获取指标
 func (pm *ProxyManager) GetMetrics() *metrics.Metrics {
 	if !pm.Config.MetricsEnable || pm.Metrics == nil {
@@ -111,54 +357,66 @@ func (pm *ProxyManager) GetMetrics() *metrics.Metrics {
 	return pm.Metrics.GetSnapshot()
 }
 
-// ShouldProxy 判断给定地址是否为代理地址
 // ShouldProxy 判断是否需要代理给定的网络和地址
 func (pm *ProxyManager) ShouldProxy(network, addr string) bool {
+	return pm.Explain(network, addr).Action == rules.Proxy
+}
+
+// Explain 返回给定网络和地址的路由决策及原因
+func (pm *ProxyManager) Explain(network, addr string) rules.Decision {
+	return pm.ExplainContext(context.Background(), network, addr)
+}
+
+// ExplainContext 与 Explain 相同，ctx 传给路由回调和 PAC 脚本
+func (pm *ProxyManager) ExplainContext(ctx context.Context, network, addr string) rules.Decision {
+	decision, _, _ := pm.explain(ctx, network, addr)
+	return decision
+}
+
+// explain 返回路由决策，目标由 PAC 脚本路由时同时返回脚本给出的路径，决策来自路由回调时 routed 为 true
+// 只有没有命中 BypassList 和 Rules、按默认走代理或按 BypassPrivate 直连的 TCP 目标才执行脚本，脚本不可用时保持原决策
+func (pm *ProxyManager) explain(ctx context.Context, network, addr string) (decision rules.Decision, route []pac.Proxy, routed bool) {
 	// pm.mu.RLock()
 	// defer pm.mu.RUnlock()
-
-	// 如果代理配置未启用，则不需要代理
-	if pm.Config == nil || !pm.Config.Enable {
-		return false
+	if pm.WaitingForProxy() {
+		return rules.Decision{Action: rules.Direct, Reason: "waiting for proxy"}, nil, false
 	}
-
-	// 不代理 Unix 域套接字的通信
-	if isUnixNetwork(network) {
-		return false
+	addr = pm.unmapFakeIP(addr)
+	addr, _, _ = pm.hints.restore(addr)
+	engine := pm.pac
+	if engine.isProxyAddr(addr) || pm.Rules().IsProxyAddr(addr) {
+		return rules.Decision{Action: rules.Direct, Reason: "proxy address"}, nil, false
 	}
-
-	// 代理的目标地址
-	proxyAddr := fmt.Sprintf("%s:%d", pm.Config.ProxyIP, pm.Config.ProxyPort)
-
-	// UDP 请求
-	if isUDPNetwork(network) {
-		// 如果启用了 UDP Hook 并且地址不是代理地址，则需要代理
-		return pm.Config.HookUDP && addr != proxyAddr
+	if decision, ok := pm.routeDecision(ctx, network, addr); ok {
+		return decision, nil, true
 	}
-
-	// TCP 请求
-	if isTCPNetwork(network) {
-		// 如果地址是代理的地址，则不需要再次代理
-		return addr != proxyAddr
+	decision = pm.Rules().Explain(network, addr)
+	if engine == nil || decision.Rule != nil || (decision.Reason != "default" && !decision.IsPrivate()) {
+		return decision, nil, false
 	}
-
-	// 对于其他未知的网络类型，默认不代理
-	return false
-}
-
-// 判断是否为 Unix 套接字网络类型
-func isUnixNetwork(network string) bool {
-	return network == "unix" || network == "unixpacket" || network == "unixgram"
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return decision, nil, false
+	}
+	route, err := engine.route(ctx, addr)
+	if err != nil || route == nil {
+		return decision, nil, false
+	}
+	return pacDecision(route), route, false
 }
 
-// 判断是否为 UDP 网络类型
-func isUDPNetwork(network string) bool {
-	return network == "udp" || network == "udp4" || network == "udp6"
+// Rules 返回当前使用的路由引擎
+func (pm *ProxyManager) Rules() *rules.Engine {
+	return pm.rules.Load()
 }
 
-// 判断是否为 TCP 网络类型
-func isTCPNetwork(network string) bool {
-	return network == "tcp" || network == "tcp4" || network == "tcp6"
+// SelfListener 返回目标地址对应的本进程管道监听器，未启用 SelfPipe、关闭了 self_pipe 功能或没有匹配时返回 nil
+func (pm *ProxyManager) SelfListener(network, addr string) *PipeListener {
+	if pm.Config == nil || !pm.Config.SelfPipe || !pm.Config.FeatureEnabled(C.FeatureSelfPipe) {
+		return nil
+	}
+	return lookupPipeListener(network, addr)
 }
 
 // Dial 实现 ProxyDialer 接口
@@ -171,7 +429,29 @@ func (pm *ProxyManager) DialContext(ctx context.Context, network, addr string) (
 	// pm.mu.RLock()
 	// defer pm.mu.RUnlock()
 
+	if pm.IsShutdown() {
+		return nil, errors.ErrManagerShutdown
+	}
+	if l := pm.SelfListener(network, addr); l != nil {
+		return l.DialContext(ctx)
+	}
+
+	// 假 IP 还原为主机名后再路由，代理收到的是主机名
+	addr = pm.unmapFakeIP(addr)
+	// 为应用解析过的 IP 同样还原为主机名，IP 作为提示，需要在本地解析时直接使用
+	if restored, hint, ok := pm.hints.restore(addr); ok {
+		addr = restored
+		if _, ok := IPHintFromContext(ctx); !ok {
+			ctx = WithIPHint(ctx, hint)
+		}
+	}
+
+	if pm.WaitingForProxy() {
+		return pm.unhookedDialer().DialContext(ctx, network, addr)
+	}
+
 	start := time.Now()
+	sloStart := pm.Clock().Now()
 
 	if pm.Config.MetricsEnable && pm.Metrics != nil {
 		pm.Metrics.RecordProtocol(network)
@@ -182,10 +462,150 @@ func (pm *ProxyManager) DialContext(ctx context.Context, network, addr string) (
 		return nil, errors.ErrUnsupportedProxy
 	}
 
-	conn, err := dialer.DialContext(ctx, network, addr)
+	decision, route, routed := pm.explain(ctx, network, addr)
+	if decision.Action == rules.Reject {
+		return nil, errors.WrapError(errors.ErrRuleRejected, decision.Reason)
+	}
+	// 规则或路由回调选择直连的目标和 PAC 脚本路由的目标不经过配置的代理，也不计入代理的负缓存、拨号调度和 SLO
+	// 其他原因的直连决策(如未启用 UDP Hook)只影响 hook，显式调用 DialContext 时仍然走代理
+	bypass := true
+	proxyAddr := pm.Config.GetProxyAddr()
+	switch {
+	case route != nil:
+		dialer = pacDialer{engine: pm.pac, route: route}
+	case (decision.Rule != nil || routed) && decision.Action == rules.Direct:
+		dialer = pm.unhookedDialer()
+	case pm.udp != nil && rules.IsUDPNetwork(network):
+		// 配置了 UDP 代理时 UDP 流量走它，负缓存也按它记录
+		dialer, proxyAddr = pm.udp.dialer, pm.udp.addr
+		bypass = false
+	default:
+		bypass = false
+	}
+	// 规则指定的命名代理替代主代理和 UDP 代理，Use 选择的命名代理只替代主代理；命名代理不参与竞速
+	var named *namedProxy
+	if !bypass && (dialer == pm.GetDialer() || decision.Rule != nil && decision.Rule.Proxy != "") {
+		var err error
+		if named, err = pm.namedProxyFor(decision); err != nil {
+			return nil, err
+		}
+		if named != nil {
+			dialer, proxyAddr = named.dialer, named.addr
+		}
+	}
+	// 没有指定命名代理的 TCP 拨号由均衡拨号器分配，均衡的拨号不参与竞速
+	balanced := !bypass && named == nil && dialer == pm.GetDialer() && pm.balance != nil && rules.IsTCPNetwork(network)
+	// 其他走主代理或命名代理的拨号在代理不可用时按 HealthCheck.Failover 改用可用的代理，故障转移的拨号也不参与竞速
+	failover := false
+	if balanced {
+		dialer = pm.balance
+	} else if !bypass && (named != nil || dialer == pm.GetDialer()) {
+		var alt *namedProxy
+		if alt, failover = pm.failoverFrom(named); failover {
+			named, dialer, proxyAddr = alt, pm.GetDialer(), pm.Config.GetProxyAddr()
+			if alt != nil {
+				dialer, proxyAddr = alt.dialer, alt.addr
+			}
+		}
+	}
+
+	// 路由规则可以为目标指定凭证和 DSCP，调用方通过 WithCredentials 指定的凭证优先
+	if rule := decision.Rule; rule != nil && rule.User != "" {
+		if _, ok := CredentialsFromContext(ctx); !ok {
+			ctx = WithCredentials(ctx, Credentials{User: rule.User, Pass: rule.Pass})
+		}
+	}
+	if rule := decision.Rule; rule != nil && rule.DSCP > 0 {
+		ctx = withDSCP(ctx, rule.DSCP)
+	}
+
+	// 代理最近按策略拒绝过该目标时直接返回缓存的错误
+	if !bypass {
+		if err := pm.failed.check(ctx, proxyAddr, network, addr); err != nil {
+			if pm.Metrics != nil {
+				pm.Metrics.RecordNegativeCacheHit()
+			}
+			return nil, err
+		}
+	}
+
+	if !bypass && named == nil && !balanced && !failover && pm.race != nil && pm.race.allowed(network, addr, decision) {
+		dialer = pm.race
+	}
+
+	// 规则设置了流量上限时，当天的流量用完后不再拨号
+	var capHost string
+	var daily *dailyBytes
+	if rule := decision.Rule; rule != nil && pm.caps != nil && (rule.MaxConnBytes > 0 || rule.MaxDailyBytes > 0) {
+		capHost = hostport.CanonicalHost(hostport.Host(addr))
+		var err error
+		if daily, err = pm.caps.admit(rule, capHost); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := LabelsFromContext(ctx)
+	var counter *metrics.LabelCounter
+	if len(labels) > 0 && pm.Metrics != nil {
+		counter = pm.Metrics.Labeled(labels)
+	}
+
+	var quotas []*quotaState
+	if len(labels) > 0 && pm.quotas != nil {
+		var err error
+		if quotas, err = pm.quotas.acquire(ctx, labels); err != nil {
+			if counter != nil {
+				counter.AddFailure()
+			}
+			return nil, err
+		}
+	}
+
+	dial := dialer.DialContext
+	if pd, ok := dialer.(PacketDialer); ok && rules.IsUDPNetwork(network) {
+		dial = pd.DialPacketContext
+	}
+
+	// 代理拨号并发受限时排队，interactive 先于 bulk
+	priority := priorityOf(ctx, decision.Rule)
+	sched := pm.sched
+	if bypass {
+		sched = nil
+	}
+	if err := sched.acquireDial(ctx, priority); err != nil {
+		if counter != nil {
+			counter.AddFailure()
+		}
+		for _, q := range quotas {
+			q.release()
+		}
+		return nil, err
+	}
+
+	conn, err := dial(ctx, network, addr)
+	sched.releaseDial()
+	if !bypass {
+		pm.recordSLO(ctx, addr, sloStart, err)
+	}
+	// 经过代理或 PAC 路径的拨号器已为连接增加活动连接数，直连拨号不计入
+	counted := !bypass || route != nil
+	// 代理不可达或握手失败时按 FallbackDirect 改为直连，代理的失败仍然计入 SLO
+	if err != nil && !bypass && pm.Config.FallbackDirect && fallbackAllowed(ctx, err) {
+		conn, err = pm.fallbackDirect(ctx, network, addr, proxyAddr, err)
+		counted = false
+	}
 	if err != nil {
-		if pm.Metrics != nil {
-			pm.Metrics.RecordFailure(err)
+		if !bypass {
+			pm.failed.record(ctx, proxyAddr, network, addr, err)
+		}
+		if r, _ := pm.currentRecorder(); r != nil {
+			r.RecordFailure(err)
+		}
+		if counter != nil {
+			counter.AddFailure()
+		}
+		for _, q := range quotas {
+			q.release()
 		}
 		return nil, err
 	}
@@ -193,6 +613,51 @@ func (pm *ProxyManager) DialContext(ctx context.Context, network, addr string) (
 	if pm.Config.MetricsEnable && pm.Metrics != nil {
 		pm.Metrics.RecordLatency(time.Since(start))
 	}
+	if rule := decision.Rule; rule != nil {
+		ApplySocketTuning(conn, rule.Tuning)
+	}
+	conn = pm.wrapRecorded(conn, counted)
 
-	return conn, nil
+	if counter != nil {
+		counter.AddConnection()
+		conn = &labeledConn{Conn: conn, counter: counter}
+	}
+	if len(quotas) > 0 {
+		conn = newQuotaConn(conn, pm.quotas, quotas)
+	}
+	if capHost != "" {
+		conn = pm.caps.wrap(conn, decision.Rule, capHost, daily)
+	}
+	return sched.wrap(conn, priority), nil
+}
+
+// ListenPacket 创建不固定目标的 UDP 套接字
+// 拨号器实现 PacketDialer 时经过代理(配置了 UDPProxy 时经过 UDP 代理)，未启用代理或处于直连阶段时使用本地套接字
+func (pm *ProxyManager) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	if !rules.IsUDPNetwork(network) {
+		return nil, errors.WrapError(errors.ErrUnsupportedProxy, "listen packet: unsupported network "+network)
+	}
+	if pm.IsShutdown() {
+		return nil, errors.ErrManagerShutdown
+	}
+	if pm.Config == nil || !pm.Config.Enable || pm.WaitingForProxy() {
+		return directDialer{resolver: pm.localResolver()}.ListenPacket(ctx, network)
+	}
+
+	dialer := pm.GetDialer()
+	named, err := pm.namedProxyFor(rules.Decision{Action: rules.Proxy})
+	if err != nil {
+		return nil, err
+	}
+	if named != nil {
+		dialer = named.dialer
+	}
+	if pm.udp != nil {
+		dialer = pm.udp.dialer
+	}
+	pd, ok := dialer.(PacketDialer)
+	if !ok {
+		return nil, errors.WrapError(errors.ErrUnsupportedProxy, fmt.Sprintf("%s proxy does not support udp", pm.Config.ProxyType))
+	}
+	return pd.ListenPacket(ctx, network)
 }
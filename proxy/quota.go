@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// QuotaEvent 配额超出事件
+type QuotaEvent struct {
+	Quota C.Quota
+	Value string // 超出配额的标签值
+	Kind  string // "conns" 或 "bytes"
+}
+
+// quotaState 一个配额在一个标签值上的状态
+type quotaState struct {
+	quota C.Quota
+	value string
+	clock clock.Clock
+
+	mu        sync.Mutex
+	conns     int
+	bytes     int64
+	periodEnd time.Time
+	warned    bool
+	released  chan struct{} // 有连接释放时关闭并替换
+}
+
+// quotaEnforcer 按连接标签执行配额
+type quotaEnforcer struct {
+	quotas []C.Quota
+	clock  clock.Clock
+
+	onExceed func(QuotaEvent) // 配额超出时调用
+
+	mu     sync.Mutex
+	states map[string]*quotaState
+}
+
+func newQuotaEnforcer(quotas []C.Quota, clk clock.Clock, onExceed func(QuotaEvent)) *quotaEnforcer {
+	if len(quotas) == 0 {
+		return nil
+	}
+	return &quotaEnforcer{
+		quotas:   quotas,
+		clock:    clk,
+		onExceed: onExceed,
+		states:   make(map[string]*quotaState),
+	}
+}
+
+// match 返回标签命中的配额状态
+func (e *quotaEnforcer) match(labels map[string]string) []*quotaState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var states []*quotaState
+	for i, q := range e.quotas {
+		value, ok := labels[q.Label]
+		if !ok || q.Value != "" && q.Value != value {
+			continue
+		}
+		key := strconv.Itoa(i) + "/" + value
+		s, ok := e.states[key]
+		if !ok {
+			s = &quotaState{quota: q, value: value, clock: e.clock, released: make(chan struct{})}
+			e.states[key] = s
+		}
+		states = append(states, s)
+	}
+	return states
+}
+
+func (e *quotaEnforcer) notify(s *quotaState, kind string) {
+	if e.onExceed != nil {
+		e.onExceed(QuotaEvent{Quota: s.quota, Value: s.value, Kind: kind})
+	}
+}
+
+// acquire 为一次拨号占用连接配额，返回的状态需要在连接关闭时释放
+func (e *quotaEnforcer) acquire(ctx context.Context, labels map[string]string) ([]*quotaState, error) {
+	states := e.match(labels)
+	for i, s := range states {
+		if err := e.acquireOne(ctx, s); err != nil {
+			for _, acquired := range states[:i] {
+				acquired.release()
+			}
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+func (e *quotaEnforcer) acquireOne(ctx context.Context, s *quotaState) error {
+	q := s.quota
+	for {
+		s.mu.Lock()
+		s.resetPeriod()
+		connsExceeded := q.MaxConns > 0 && s.conns >= q.MaxConns
+		bytesExceeded := q.MaxBytes > 0 && s.bytes >= q.MaxBytes
+		released := s.released
+
+		if q.Action == C.QuotaThrottle && connsExceeded {
+			s.mu.Unlock()
+			select {
+			case <-released:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if q.Action == C.QuotaBlock && (connsExceeded || bytesExceeded) {
+			s.mu.Unlock()
+			return errors.WrapError(errors.ErrQuotaExceeded, q.Label+"="+s.value)
+		}
+
+		s.conns++
+		s.mu.Unlock()
+
+		if connsExceeded {
+			e.notify(s, "conns")
+		}
+		return nil
+	}
+}
+
+// resetPeriod 进入新周期时清零流量，调用方持有 s.mu
+func (s *quotaState) resetPeriod() {
+	if s.quota.Period <= 0 {
+		return
+	}
+	now := s.clock.Now()
+	if now.After(s.periodEnd) {
+		s.bytes = 0
+		s.warned = false
+		s.periodEnd = now.Add(s.quota.Period)
+	}
+}
+
+func (s *quotaState) release() {
+	s.mu.Lock()
+	s.conns--
+	close(s.released)
+	s.released = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// quotaConn 按配额统计流量的连接
+type quotaConn struct {
+	net.Conn
+	enforcer *quotaEnforcer
+	states   []*quotaState
+	once     sync.Once
+	closed   chan struct{} // Close 时关闭，结束限速的等待
+
+	readDeadline  atomic.Pointer[time.Time]
+	writeDeadline atomic.Pointer[time.Time]
+}
+
+func newQuotaConn(conn net.Conn, enforcer *quotaEnforcer, states []*quotaState) *quotaConn {
+	return &quotaConn{Conn: conn, enforcer: enforcer, states: states, closed: make(chan struct{})}
+}
+
+// check 在读写前检查流量配额
+func (c *quotaConn) check() error {
+	for _, s := range c.states {
+		if s.quota.Action != C.QuotaBlock || s.quota.MaxBytes <= 0 {
+			continue
+		}
+		s.mu.Lock()
+		s.resetPeriod()
+		exceeded := s.bytes >= s.quota.MaxBytes
+		s.mu.Unlock()
+		if exceeded {
+			return errors.WrapError(errors.ErrQuotaExceeded, s.quota.Label+"="+s.value)
+		}
+	}
+	return nil
+}
+
+// account 记录流量，超出配额时通知或限速
+// 限速的等待在连接关闭或 deadline 到期时提前结束并返回对应的错误
+func (c *quotaConn) account(n int, deadline *atomic.Pointer[time.Time]) error {
+	if n <= 0 {
+		return nil
+	}
+	var delay time.Duration
+	for _, s := range c.states {
+		q := s.quota
+		if q.MaxBytes <= 0 {
+			continue
+		}
+		s.mu.Lock()
+		s.resetPeriod()
+		s.bytes += int64(n)
+		exceeded := s.bytes > q.MaxBytes
+		notify := exceeded && !s.warned
+		if notify {
+			s.warned = true
+		}
+		s.mu.Unlock()
+
+		if notify {
+			c.enforcer.notify(s, "bytes")
+		}
+		if exceeded && q.Action == C.QuotaThrottle {
+			if d := time.Duration(int64(n) * int64(time.Second) / q.ThrottleRate); d > delay {
+				delay = d
+			}
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	return c.wait(delay, deadline)
+}
+
+// wait 限速等待 delay，连接关闭时返回 net.ErrClosed，deadline 先到期时返回 os.ErrDeadlineExceeded
+func (c *quotaConn) wait(delay time.Duration, deadline *atomic.Pointer[time.Time]) error {
+	var timeout <-chan time.Time
+	if t := deadline.Load(); t != nil && !t.IsZero() {
+		timer := time.NewTimer(time.Until(*t))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	timer := c.enforcer.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *quotaConn) Read(b []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	if waitErr := c.account(n, &c.readDeadline); err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+func (c *quotaConn) Write(b []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b)
+	if waitErr := c.account(n, &c.writeDeadline); err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+func (c *quotaConn) SetDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	c.writeDeadline.Store(&t)
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline 同时限制限速的等待
+func (c *quotaConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline 同时限制限速的等待
+func (c *quotaConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Store(&t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *quotaConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		for _, s := range c.states {
+			s.release()
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *quotaConn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// raceDialer 通过主路径和第二条路径竞速拨号，使用先建立的连接
+type raceDialer struct {
+	primary   ProxyDialer
+	secondary ProxyDialer
+	mode      C.RaceMode
+	delay     time.Duration
+	patterns  []string
+	clock     clock.Clock
+}
+
+// newRaceDialer 根据竞速配置创建第二条路径，未配置竞速时返回 nil
+func newRaceDialer(config *C.Config, primary ProxyDialer, pm *ProxyManager, clk clock.Clock) (*raceDialer, error) {
+	race := ifFeature(config, C.FeatureRace, config.Race)
+	if race == nil || !config.Enable {
+		return nil, nil
+	}
+
+	d := &raceDialer{
+		primary:  primary,
+		mode:     race.Mode,
+		delay:    race.Delay,
+		patterns: race.Patterns,
+		clock:    clk,
+	}
+	switch race.Mode {
+	case C.RaceDirect:
+		d.secondary = directDialer{resolver: pm.localResolver(), timeout: config.DirectDialTimeout}
+	case C.RaceProxy:
+		// 第二条路径只在竞速时使用，第一次竞速时才创建
+		secondaryConfig := race.ProxyConfig(config)
+		d.secondary = newLazyDialer(func() (ProxyDialer, error) {
+			secondary, err := createProxyDialer(secondaryConfig, pm.dialRecorder())
+			if err != nil {
+				return nil, err
+			}
+			pm.bindDialer(secondary)
+			return secondary, nil
+		})
+	}
+	return d, nil
+}
+
+// close 关闭第二条路径持有的共享会话
+func (d *raceDialer) close() {
+	if d != nil {
+		closeDialer(d.secondary)
+	}
+}
+
+// allowed 判断目标是否参与竞速，直连竞速不会用于规则明确要求走代理的目标
+func (d *raceDialer) allowed(network, addr string, decision rules.Decision) bool {
+	if !rules.IsTCPNetwork(network) {
+		return false
+	}
+	if d.mode == C.RaceDirect && decision.Rule != nil {
+		return false
+	}
+	if len(d.patterns) == 0 {
+		return true
+	}
+	host := hostport.Host(addr)
+	for _, pattern := range d.patterns {
+		if rules.MatchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *raceDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 先启动主路径，delay 后或主路径失败时启动第二条路径
+// 返回第一个成功的连接，另一条路径被取消，已经建立的连接会被关闭；ctx 在得到连接前结束时取消两条路径
+func (d *raceDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan result, 2)
+	// 有的连接在整个生命周期内使用拨号的 ctx(如 HTTP/2 的 CONNECT 流)，
+	// 每条路径使用不随调用方结束的 ctx，只在该路径落败或调用方放弃拨号时取消
+	var cancels []context.CancelFunc
+	cancelAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	dial := func(dialer ProxyDialer, primary bool) {
+		pathCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cancels = append(cancels, cancel)
+		go func() {
+			conn, err := dialer.DialContext(pathCtx, network, addr)
+			results <- result{conn: conn, err: err, primary: primary}
+		}()
+	}
+	// closeLosers 关闭还在拨号的路径可能建立的连接
+	closeLosers := func(pending int) {
+		go func() {
+			for ; pending > 0; pending-- {
+				if loser := <-results; loser.conn != nil {
+					loser.conn.Close()
+				}
+			}
+		}()
+	}
+
+	dial(d.primary, true)
+	pending := 1
+	started := false
+	start := func() {
+		started = true
+		pending++
+		dial(d.secondary, false)
+	}
+
+	timer := d.clock.NewTimer(d.delay)
+	defer timer.Stop()
+
+	var primaryErr, secondaryErr error
+	for {
+		select {
+		case <-ctx.Done():
+			cancelAll()
+			closeLosers(pending)
+			return nil, ctx.Err()
+		case <-timer.C():
+			if !started {
+				start()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 只取消落败的路径，胜出的连接仍然使用自己的 ctx
+				for i, cancel := range cancels {
+					if (i == 0) != r.primary {
+						cancel()
+					}
+				}
+				closeLosers(pending)
+				return r.conn, nil
+			}
+
+			if r.primary {
+				primaryErr = r.err
+			} else {
+				secondaryErr = r.err
+			}
+			if !started {
+				start()
+				continue
+			}
+			if pending == 0 {
+				cancelAll()
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, secondaryErr
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// SetRecorder 设置拨号器上报拨号、失败、阶段耗时和活动连接数的 Recorder，nil 恢复为 pm.Metrics
+// 已创建的拨号器立即使用新的 Recorder。设置后 DialContext 返回的 TCP 连接在读写时调用 RecordBytes，
+// 关闭时调用 DecrementActiveConnections。r 替代 pm.Metrics，需要同时保留时传入 metrics.MultiRecorder(pm.Metrics, r)；
+// UDP 中继、HTTP2、HTTP3 等协议统计以及标签、SLO 和路由决策的统计仍然只记录在 pm.Metrics
+func (pm *ProxyManager) SetRecorder(r metrics.Recorder) {
+	if r == nil {
+		pm.recorder.Store(nil)
+		return
+	}
+	pm.recorder.Store(&r)
+}
+
+// currentRecorder 返回当前的 Recorder，custom 表示由 SetRecorder 设置；都没有时返回 nil
+func (pm *ProxyManager) currentRecorder() (r metrics.Recorder, custom bool) {
+	if p := pm.recorder.Load(); p != nil {
+		return *p, true
+	}
+	if pm.Metrics != nil {
+		return pm.Metrics, false
+	}
+	return nil, false
+}
+
+// dialRecorder 返回交给拨号器的 Recorder
+func (pm *ProxyManager) dialRecorder() metrics.Recorder {
+	return managerRecorder{pm: pm}
+}
+
+// managerRecorder 交给拨号器的 Recorder，每次记录时转发给 pm 当前的 Recorder
+type managerRecorder struct {
+	pm *ProxyManager
+}
+
+func (m managerRecorder) RecordDial(d time.Duration) {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.RecordDial(d)
+	}
+}
+
+func (m managerRecorder) RecordFailure(err error) {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.RecordFailure(err)
+	}
+}
+
+func (m managerRecorder) RecordBytes(sent, received int64) {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.RecordBytes(sent, received)
+	}
+}
+
+func (m managerRecorder) RecordStage(stage metrics.DialStage, d time.Duration) {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.RecordStage(stage, d)
+	}
+}
+
+func (m managerRecorder) RecordStageExemplar(stage metrics.DialStage, d time.Duration, labels map[string]string) {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		recordStageExemplar(r, stage, d, labels)
+	}
+}
+
+func (m managerRecorder) IncrementActiveConnections() {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.IncrementActiveConnections()
+	}
+}
+
+func (m managerRecorder) DecrementActiveConnections() {
+	if r, _ := m.pm.currentRecorder(); r != nil {
+		r.DecrementActiveConnections()
+	}
+}
+
+// recordStageExemplar 记录附带 exemplar 的阶段耗时，r 不支持 exemplar 时只记录耗时
+func recordStageExemplar(r metrics.Recorder, stage metrics.DialStage, d time.Duration, labels map[string]string) {
+	if er, ok := r.(metrics.ExemplarRecorder); ok {
+		er.RecordStageExemplar(stage, d, labels)
+		return
+	}
+	r.RecordStage(stage, d)
+}
+
+// collectorOf 返回 r 背后的 MetricsCollector，UDP 中继、HTTP2 和 HTTP3 等协议统计只记录在它上面；没有时返回 nil
+func collectorOf(r metrics.Recorder) *metrics.MetricsCollector {
+	switch r := r.(type) {
+	case *metrics.MetricsCollector:
+		return r
+	case managerRecorder:
+		return r.pm.Metrics
+	}
+	return nil
+}
+
+// recordedConn 把收发字节数报告给 Recorder，关闭时减少活动连接数
+type recordedConn struct {
+	net.Conn
+	recorder metrics.Recorder
+	counted  bool // 拨号器已为连接增加活动连接数
+	once     sync.Once
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.recorder.RecordBytes(0, int64(n))
+	}
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.recorder.RecordBytes(int64(n), 0)
+	}
+	return n, err
+}
+
+func (c *recordedConn) Close() error {
+	if c.counted {
+		c.once.Do(c.recorder.DecrementActiveConnections)
+	}
+	return c.Conn.Close()
+}
+
+func (c *recordedConn) Unwrap() net.Conn { return c.Conn }
+
+// wrapRecorded 在设置了 Recorder 时包装 conn；UDP 连接保持原类型，Go 解析器按连接是否实现 PacketConn 决定 DNS 报文格式
+func (pm *ProxyManager) wrapRecorded(conn net.Conn, counted bool) net.Conn {
+	r, custom := pm.currentRecorder()
+	if !custom || r == nil {
+		return conn
+	}
+	if _, ok := conn.(net.PacketConn); ok {
+		return conn
+	}
+	return &recordedConn{Conn: conn, recorder: r, counted: counted}
+}
@@ -0,0 +1,350 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+// Resolver 主机名解析器
+// hook、直连拨号、UDP 目标和路由规则都通过 ProxyManager 上设置的 Resolver 解析，默认为 SystemResolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ReverseResolver 可以把自己分配的地址还原为主机名的解析器，如 FakeIPResolver
+// ProxyManager 在路由和拨号前把这些地址还原为主机名，代理收到的是主机名
+type ReverseResolver interface {
+	Resolver
+	LookupAddr(ip netip.Addr) (host string, ok bool)
+}
+
+// SystemResolver 使用 net.Resolver 解析，Resolver 为 nil 时使用 net.DefaultResolver
+type SystemResolver struct {
+	Resolver *net.Resolver
+}
+
+// LookupIPAddr 实现 Resolver 接口
+func (r SystemResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return resolver.LookupIPAddr(ctx, host)
+}
+
+// HostsResolver 先查静态主机表，未命中时交给 Fallback，Fallback 为 nil 时返回 NXDOMAIN
+type HostsResolver struct {
+	Hosts    map[string][]netip.Addr // 主机名不区分大小写
+	Fallback Resolver
+}
+
+// LookupIPAddr 实现 Resolver 接口
+func (r HostsResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	canonical := hostport.CanonicalHost(host)
+	for name, addrs := range r.Hosts {
+		if hostport.CanonicalHost(name) != canonical || len(addrs) == 0 {
+			continue
+		}
+		ips := make([]net.IPAddr, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, net.IPAddr{IP: net.IP(addr.Unmap().AsSlice()), Zone: addr.Zone()})
+		}
+		return ips, nil
+	}
+	if r.Fallback == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return r.Fallback.LookupIPAddr(ctx, host)
+}
+
+// RemoteResolver 不在本地解析，所有主机名都返回 ErrLocalDNSBlocked，由代理解析
+type RemoteResolver struct{}
+
+// LookupIPAddr 实现 Resolver 接口
+func (RemoteResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{Err: E.ErrLocalDNSBlocked.Error(), Name: host, UnwrapErr: E.ErrLocalDNSBlocked}
+}
+
+// FakeIPResolver 从地址池为每个主机名分配一个假 IP，不发送任何 DNS 查询
+// 连接假 IP 时 ProxyManager 把它还原为主机名，按主机名匹配规则并交给代理解析
+// 地址池用完后返回 ErrFakeIPExhausted，已分配的映射不会回收
+// 直连的目标和需要在本地解析的 UDP 目标用 Upstream 解析，为 nil 时使用 SystemResolver
+type FakeIPResolver struct {
+	Upstream Resolver
+
+	prefix netip.Prefix
+
+	mu     sync.Mutex
+	next   netip.Addr
+	byHost map[string]netip.Addr
+	byAddr map[netip.Addr]string
+}
+
+// NewFakeIPResolver 创建使用 prefix 地址池的假 IP 解析器，如 198.18.0.0/15
+func NewFakeIPResolver(prefix string) (*FakeIPResolver, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, E.WrapError(E.ErrInvalidConfig, "fake ip prefix: "+err.Error())
+	}
+	p = p.Masked()
+	// 跳过网络地址
+	next := p.Addr().Next()
+	if !p.Contains(next) {
+		return nil, E.WrapError(E.ErrInvalidConfig, "fake ip prefix too small: "+prefix)
+	}
+	return &FakeIPResolver{
+		prefix: p,
+		next:   next,
+		byHost: make(map[string]netip.Addr),
+		byAddr: make(map[netip.Addr]string),
+	}, nil
+}
+
+// LookupIPAddr 实现 Resolver 接口，同一主机名总是返回同一个地址，IP 字面量原样返回
+func (r *FakeIPResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := hostport.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	host = hostport.CanonicalHost(host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addr, ok := r.byHost[host]
+	if !ok {
+		if !r.prefix.Contains(r.next) {
+			return nil, &net.DNSError{Err: E.ErrFakeIPExhausted.Error(), Name: host, UnwrapErr: E.ErrFakeIPExhausted}
+		}
+		addr = r.next
+		r.next = r.next.Next()
+		r.byHost[host] = addr
+		r.byAddr[addr] = host
+	}
+	return []net.IPAddr{{IP: net.IP(addr.AsSlice())}}, nil
+}
+
+// upstream 返回解析直连目标使用的解析器
+func (r *FakeIPResolver) upstream() Resolver {
+	if r.Upstream == nil {
+		return SystemResolver{}
+	}
+	return r.Upstream
+}
+
+// LookupAddr 实现 ReverseResolver 接口，返回分配了 ip 的主机名
+func (r *FakeIPResolver) LookupAddr(ip netip.Addr) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	host, ok := r.byAddr[ip.Unmap()]
+	return host, ok
+}
+
+// SetResolver 设置主机名解析器，nil 恢复为 SystemResolver
+func (pm *ProxyManager) SetResolver(r Resolver) {
+	pm.mu.Lock()
+	pm.resolver = r
+	pm.mu.Unlock()
+
+	if pm.Metrics != nil {
+		if p, ok := r.(*PrefetchResolver); ok {
+			pm.Metrics.SetDNSCacheStats(p.Stats)
+		} else {
+			pm.Metrics.SetDNSCacheStats(nil)
+		}
+	}
+}
+
+// Resolver 返回当前使用的主机名解析器
+// 关闭 fake_ip 功能后 FakeIPResolver 改用它的 Upstream，不再分配新的假 IP
+func (pm *ProxyManager) Resolver() Resolver {
+	r := pm.configuredResolver()
+	if fake, ok := r.(*FakeIPResolver); ok && !pm.Config.FeatureEnabled(C.FeatureFakeIP) {
+		return fake.upstream()
+	}
+	return r
+}
+
+// configuredResolver 返回 SetResolver 设置的解析器，不受功能开关影响
+func (pm *ProxyManager) configuredResolver() Resolver {
+	pm.mu.RLock()
+	r := pm.resolver
+	pm.mu.RUnlock()
+	if r == nil {
+		return SystemResolver{}
+	}
+	return r
+}
+
+// LookupIPAddr 用当前的解析器解析主机名，ProxyManager 本身也实现了 Resolver
+// 启用 ResolvedHints 时记录解析结果，之后拨号这些 IP 时还原为主机名；假 IP 本身就会还原，不记录
+func (pm *ProxyManager) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	resolver := pm.Resolver()
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if _, fake := resolver.(ReverseResolver); err == nil && !fake {
+		pm.hints.remember(host, ips)
+	}
+	return ips, err
+}
+
+// ResolveTCPAddr 解析直连目标 host:port，行为同 net.ResolveTCPAddr
+// 假 IP 先还原为主机名，再用 FakeIPResolver.Upstream 解析；配置了 NAT64 时 IPv4 地址转换为 NAT64 地址
+func (pm *ProxyManager) ResolveTCPAddr(ctx context.Context, network, addr string) (*net.TCPAddr, error) {
+	ip, port, err := resolveAddr(ctx, pm.localResolver(), network, pm.unmapFakeIP(addr))
+	if err != nil {
+		return nil, err
+	}
+	ip = pm.nat64.translate(ctx, network, ip)
+	return &net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone}, nil
+}
+
+// ResolveUDPAddr 解析直连目标 host:port，行为同 net.ResolveUDPAddr
+// 假 IP 先还原为主机名，再用 FakeIPResolver.Upstream 解析；配置了 NAT64 时 IPv4 地址转换为 NAT64 地址
+func (pm *ProxyManager) ResolveUDPAddr(ctx context.Context, network, addr string) (*net.UDPAddr, error) {
+	ip, port, err := resolveAddr(ctx, pm.localResolver(), network, pm.unmapFakeIP(addr))
+	if err != nil {
+		return nil, err
+	}
+	ip = pm.nat64.translate(ctx, network, ip)
+	return &net.UDPAddr{IP: ip.IP, Port: port, Zone: ip.Zone}, nil
+}
+
+// resolveAddr 用 r 解析 host:port，返回符合 network 地址族的地址
+// 和 net.ResolveTCPAddr 一样，network 不限定地址族时优先 IPv4，端口可以是服务名
+// ctx 中有该主机名的地址提示时直接使用；有多个候选地址且 r 实现 addrPicker 时由它选择，否则使用第一个
+func resolveAddr(ctx context.Context, r Resolver, network, addr string) (net.IPAddr, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.IPAddr{}, 0, err
+	}
+	port, err := net.LookupPort(network, portStr)
+	if err != nil {
+		return net.IPAddr{}, 0, err
+	}
+	if host == "" {
+		return net.IPAddr{}, port, nil
+	}
+	if ip, zone, _ := strings.Cut(host, "%"); hostport.ParseIP(ip) != nil {
+		return net.IPAddr{IP: hostport.ParseIP(ip), Zone: zone}, port, nil
+	}
+	if ip, ok := hintedIP(ctx, network, host); ok {
+		return ip, port, nil
+	}
+	if r == nil {
+		r = SystemResolver{}
+	}
+
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return net.IPAddr{}, 0, err
+	}
+	candidates := filterAddrFamily(network, ips)
+	if len(candidates) == 0 {
+		return net.IPAddr{}, 0, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	i := 0
+	if p, ok := r.(addrPicker); ok {
+		i = p.pickAddr(candidates)
+	}
+	return candidates[i], port, nil
+}
+
+// filterAddrFamily 返回符合 network 地址族的地址，不限定地址族时有 IPv4 地址就只返回 IPv4 地址
+func filterAddrFamily(network string, ips []net.IPAddr) []net.IPAddr {
+	var v4s, v6s []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4s = append(v4s, ip)
+		} else {
+			v6s = append(v6s, ip)
+		}
+	}
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return v4s
+	case strings.HasSuffix(network, "6"):
+		return v6s
+	case len(v4s) > 0:
+		return v4s
+	}
+	return v6s
+}
+
+// unmapFakeIP 把解析器分配的假 IP 还原为主机名，其他地址原样返回
+// 关闭 fake_ip 功能后已经分配的假 IP 仍然还原
+func (pm *ProxyManager) unmapFakeIP(addr string) string {
+	reverse, ok := pm.configuredResolver().(ReverseResolver)
+	if !ok {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return addr
+	}
+	if name, ok := reverse.LookupAddr(ip); ok {
+		return net.JoinHostPort(name, port)
+	}
+	return addr
+}
+
+// localResolver 返回在本地解析目标时使用的解析器，每次解析时读取 pm 当前的设置
+// 假 IP 不能用于直连，FakeIPResolver 改用它的 Upstream
+func (pm *ProxyManager) localResolver() Resolver {
+	return localResolver{pm}
+}
+
+type localResolver struct {
+	pm *ProxyManager
+}
+
+func (r localResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	resolver := r.pm.Resolver()
+	if fake, ok := resolver.(*FakeIPResolver); ok {
+		resolver = fake.upstream()
+	}
+	return resolver.LookupIPAddr(ctx, host)
+}
+
+// translate 按管理器的 NAT64 设置转换直连的目标地址，实现 addrTranslator 接口
+func (r localResolver) translate(ctx context.Context, network string, ip net.IPAddr) net.IPAddr {
+	return r.pm.nat64.translate(ctx, network, ip)
+}
+
+// pickAddr 按管理器的地址统计选择拨号地址，实现 addrPicker 接口
+func (r localResolver) pickAddr(ips []net.IPAddr) int {
+	return r.pm.addrs.pick(ips)
+}
+
+// observeAddr 记录到解析得到的地址的拨号结果，实现 addrObserver 接口
+func (r localResolver) observeAddr(ip net.IPAddr, rtt time.Duration, err error) {
+	r.pm.addrs.observe(ip, rtt, err)
+}
+
+// addrTranslator 直连前需要转换目标地址的解析器实现的可选接口
+type addrTranslator interface {
+	translate(ctx context.Context, network string, ip net.IPAddr) net.IPAddr
+}
+
+// NAT64Prefix 返回直连时使用的 NAT64 前缀，没有配置前缀时按 RFC 7050 发现
+// 未配置 NAT64 或发现失败时返回 ErrNAT64PrefixNotFound
+func (pm *ProxyManager) NAT64Prefix(ctx context.Context) (netip.Prefix, error) {
+	if pm.nat64 == nil {
+		return netip.Prefix{}, E.WrapError(E.ErrNAT64PrefixNotFound, "nat64 is not configured")
+	}
+	return pm.nat64.Prefix(ctx)
+}
+
+// resolverSetter 需要在本地解析目标地址的拨号器实现的可选接口
+type resolverSetter interface {
+	setResolver(r Resolver)
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// RouteFunc 程序化的路由回调，按租户、请求头等配置无法表达的条件决定直连、走代理或拒绝
+// 返回 Action 为空的决策时按配置的 BypassList、Rules 和 PAC 决策；决策可以带 Rule 以使用其中的凭证、DSCP、调优和流量上限
+type RouteFunc func(ctx context.Context, network, addr string) rules.Decision
+
+// SetRouteFunc 设置路由回调，在 BypassList 和 Rules 之前调用，nil 表示只使用配置的规则
+// 回调收到的地址已经把假 IP 还原为主机名；到代理本身的连接和 direct_until_healthy 的直连阶段不经过回调
+// 回调在每次拨号时同步调用，需要并发安全且不阻塞
+func (pm *ProxyManager) SetRouteFunc(fn RouteFunc) {
+	pm.mu.Lock()
+	pm.route = fn
+	pm.mu.Unlock()
+}
+
+// routeFunc 返回当前的路由回调
+func (pm *ProxyManager) routeFunc() RouteFunc {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.route
+}
+
+// routeDecision 调用路由回调，没有回调或回调不做决定时 ok 为 false
+func (pm *ProxyManager) routeDecision(ctx context.Context, network, addr string) (rules.Decision, bool) {
+	fn := pm.routeFunc()
+	if fn == nil || rules.IsUnixNetwork(network) {
+		return rules.Decision{}, false
+	}
+	decision := fn(ctx, network, addr)
+	if decision.Action == "" {
+		return rules.Decision{}, false
+	}
+	if decision.Reason == "" {
+		decision.Reason = "route func"
+	}
+	return decision, true
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	rttAlpha          = 0.125 // SRTT 平滑系数 (RFC 6298)
+	rttBeta           = 0.25  // RTTVAR 平滑系数 (RFC 6298)
+	handshakeRTTScale = 4     // 握手超时为 4 倍 SRTT
+)
+
+// rttEstimator 单个代理的平滑 RTT 估计
+type rttEstimator struct {
+	mu     sync.Mutex
+	srtt   time.Duration
+	rttvar time.Duration
+}
+
+// Observe 记录一次 RTT 样本
+func (e *rttEstimator) Observe(sample time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.srtt == 0 {
+		e.srtt = sample
+		e.rttvar = sample / 2
+		return
+	}
+
+	diff := e.srtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	e.rttvar = time.Duration((1-rttBeta)*float64(e.rttvar) + rttBeta*float64(diff))
+	e.srtt = time.Duration((1-rttAlpha)*float64(e.srtt) + rttAlpha*float64(sample))
+}
+
+// SRTT 返回当前的平滑 RTT，没有样本时为 0
+func (e *rttEstimator) SRTT() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.srtt
+}
+
+// Timeout 返回按 RTT 估计的握手超时，限制在 [min, max] 之间
+func (e *rttEstimator) Timeout(min, max time.Duration) time.Duration {
+	e.mu.Lock()
+	srtt, rttvar := e.srtt, e.rttvar
+	e.mu.Unlock()
+
+	if srtt == 0 {
+		return max
+	}
+
+	timeout := handshakeRTTScale*srtt + 4*rttvar
+	if timeout < min {
+		timeout = min
+	}
+	if max > 0 && timeout > max {
+		timeout = max
+	}
+	return timeout
+}
+
+// handshakeDeadline 返回握手阶段的截止时间，取 context 截止时间和超时中较早的一个
+// 未启用自适应超时时使用固定超时 max，max 为 0 且 context 没有截止时间时返回零值
+func handshakeDeadline(ctx context.Context, rtt *rttEstimator, adaptive bool, min, max time.Duration) time.Time {
+	deadline, _ := ctx.Deadline()
+
+	timeout := max
+	if adaptive {
+		timeout = rtt.Timeout(min, max)
+	}
+	if timeout <= 0 {
+		return deadline
+	}
+
+	timeoutDeadline := time.Now().Add(timeout)
+	if deadline.IsZero() || timeoutDeadline.Before(deadline) {
+		return timeoutDeadline
+	}
+	return deadline
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// rulesReloadDelay 文件变化后等待这么久再重新加载，合并编辑器一次保存产生的多个事件
+const rulesReloadDelay = 100 * time.Millisecond
+
+// rulesWatcher 监视 RulesFile，内容变化时重新编译路由规则并替换 pm 的路由引擎
+// 监视文件所在的目录而不是文件本身，编辑器以重命名方式保存和 Kubernetes ConfigMap 替换符号链接时同样能收到事件；
+// 目录中的任何变化都会重新读取文件，内容没有变化时不替换
+type rulesWatcher struct {
+	path    string
+	config  *C.Config // 编译规则时使用的配置
+	pm      *ProxyManager
+	watcher *fsnotify.Watcher
+	set     *C.RuleSet // 创建时加载的规则集
+
+	mu   sync.Mutex
+	last []byte // 上次成功加载的文件内容
+
+	started bool
+	done    chan struct{}
+	exited  chan struct{}
+}
+
+// OnRulesReload 设置重新加载 RulesFile 后的回调，成功时 err 为 nil；失败时继续使用上次的规则
+// 回调在监视协程中同步调用
+func (pm *ProxyManager) OnRulesReload(fn func(err error)) {
+	pm.onRulesReload.Store(&fn)
+}
+
+// ReloadRules 立即重新加载 RulesFile，未配置时不做任何事；内容无效时返回 ErrRulesFile 并继续使用上次的规则
+func (pm *ProxyManager) ReloadRules() error {
+	w := pm.rulesWatch
+	if w == nil {
+		return nil
+	}
+	return w.reload(true)
+}
+
+// newRulesWatcher 加载 RulesFile 并开始监视其所在目录，没有配置 RulesFile 时返回 nil
+// 返回的监视器在 start 之前不会替换路由引擎
+func newRulesWatcher(config *C.Config, pm *ProxyManager) (*rulesWatcher, error) {
+	if config == nil || config.RulesFile == "" {
+		return nil, nil
+	}
+	path, err := filepath.Abs(config.RulesFile)
+	if err != nil {
+		return nil, errors.WrapError(errors.ErrRulesFile, err.Error())
+	}
+	w := &rulesWatcher{
+		path:   path,
+		config: config,
+		pm:     pm,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	data, set, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.set, w.last = set, data
+
+	if w.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return nil, errors.WrapError(errors.ErrRulesFile, err.Error())
+	}
+	if err := w.watcher.Add(filepath.Dir(path)); err != nil {
+		w.watcher.Close()
+		return nil, errors.WrapError(errors.ErrRulesFile, err.Error())
+	}
+	return w, nil
+}
+
+// buildRules 按配置和规则集编译路由引擎，set 为 nil 时只使用配置中的规则
+func (pm *ProxyManager) buildRules(config *C.Config, set *C.RuleSet) *rules.Engine {
+	engine := rules.FromConfig(set.Apply(config))
+	if pm.directManaged {
+		engine.Enabled = true
+	}
+	if config.ExcludeSelf {
+		engine.Local = isSelfConnection
+	}
+	return engine
+}
+
+// ruleSet 返回 w 创建时加载的规则集，w 为 nil 时返回 nil
+func (w *rulesWatcher) ruleSet() *C.RuleSet {
+	if w == nil {
+		return nil
+	}
+	return w.set
+}
+
+// load 读取并解析文件
+func (w *rulesWatcher) load() ([]byte, *C.RuleSet, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, nil, errors.WrapError(errors.ErrRulesFile, err.Error())
+	}
+	set, err := C.ParseRuleSet(data)
+	if err != nil {
+		return nil, nil, errors.WrapError(errors.ErrRulesFile, w.path+": "+err.Error())
+	}
+	return data, set, nil
+}
+
+// reload 重新加载文件并替换路由引擎，force 为 false 时内容没有变化不替换也不回调
+func (w *rulesWatcher) reload(force bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, set, err := w.load()
+	if err == nil {
+		if !force && bytes.Equal(data, w.last) {
+			return nil
+		}
+		w.last = data
+		w.pm.rules.Store(w.pm.buildRules(w.config, set))
+	}
+	if fn := w.pm.onRulesReload.Load(); fn != nil && *fn != nil {
+		(*fn)(err)
+	}
+	return err
+}
+
+// start 开始处理文件变化
+func (w *rulesWatcher) start() {
+	if w == nil {
+		return
+	}
+	w.started = true
+	go w.run()
+}
+
+func (w *rulesWatcher) run() {
+	defer close(w.exited)
+	var timer *time.Timer
+	var fire <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-w.done:
+			return
+		case _, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if timer == nil {
+				timer = time.NewTimer(rulesReloadDelay)
+			} else {
+				timer.Reset(rulesReloadDelay)
+			}
+			fire = timer.C
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			if fn := w.pm.onRulesReload.Load(); fn != nil && *fn != nil {
+				(*fn)(errors.WrapError(errors.ErrRulesFile, err.Error()))
+			}
+		case <-fire:
+			fire = nil
+			w.reload(false)
+		}
+	}
+}
+
+// close 停止监视，等待正在进行的重新加载结束
+func (w *rulesWatcher) close() {
+	if w == nil {
+		return
+	}
+	close(w.done)
+	w.watcher.Close()
+	if w.started {
+		<-w.exited
+	}
+}
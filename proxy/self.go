@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// listener 本进程的监听地址
+type listener struct {
+	ip   net.IP
+	port int
+}
+
+// isSelfConnection 判断目标是否为本进程正在监听的地址
+// 只检查 IP 字面量和 localhost，不做 DNS 解析
+func isSelfConnection(network, addr string) bool {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		return false
+	}
+
+	var ips []net.IP
+	if hostport.CanonicalHost(host) == "localhost" {
+		ips = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	} else if ip := hostport.ParseIP(host); ip != nil && isLocalIP(ip) {
+		ips = []net.IP{ip}
+	} else {
+		return false
+	}
+
+	var listeners []listener
+	switch {
+	case rules.IsTCPNetwork(network):
+		listeners = localListeners("tcp")
+	case rules.IsUDPNetwork(network):
+		listeners = localListeners("udp")
+	default:
+		return false
+	}
+
+	for _, l := range listeners {
+		if l.port != port {
+			continue
+		}
+		for _, ip := range ips {
+			if l.ip.IsUnspecified() || l.ip.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isLocalIP 判断 IP 是否属于本机
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// localListeners 通过 /proc 查找本进程监听的 TCP 套接字或绑定的 UDP 套接字
+func localListeners(network string) []listener {
+	inodes := socketInodes()
+	if len(inodes) == 0 {
+		return nil
+	}
+
+	// TCP 只看 LISTEN(0A)，UDP 看未连接的套接字(07)
+	state := "0A"
+	if network == "udp" {
+		state = "07"
+	}
+
+	var listeners []listener
+	for _, file := range []string{"/proc/net/" + network, "/proc/net/" + network + "6"} {
+		listeners = append(listeners, parseProcNet(file, state, inodes)...)
+	}
+	return listeners
+}
+
+// socketInodes 返回本进程打开的套接字 inode
+func socketInodes() map[string]bool {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+
+	inodes := make(map[string]bool)
+	for _, entry := range entries {
+		link, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(link, "socket:[") {
+			inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+		}
+	}
+	return inodes
+}
+
+// parseProcNet 解析 /proc/net/{tcp,udp}[6] 中属于本进程且处于指定状态的套接字
+func parseProcNet(file, state string, inodes map[string]bool) []listener {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var listeners []listener
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 跳过表头
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state || !inodes[fields[9]] {
+			continue
+		}
+
+		hostHex, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		ip := parseProcIP(hostHex)
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if ip == nil || err != nil {
+			continue
+		}
+		listeners = append(listeners, listener{ip: ip, port: int(port)})
+	}
+	return listeners
+}
+
+// parseProcIP 解析 /proc/net 中按 32 位小端序分组的十六进制地址
+func parseProcIP(s string) net.IP {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(b[i:]))
+	}
+	return ip
+}
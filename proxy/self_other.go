@@ -0,0 +1,8 @@
+//go:build !linux
+
+package proxy
+
+// localListeners 非 Linux 平台无法枚举本进程的监听套接字，不识别自连接
+func localListeners(network string) []listener {
+	return nil
+}
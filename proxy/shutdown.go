@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// OnShutdown 注册 Shutdown 时调用的函数，如发送最后一次报告或刷新审计日志，按注册顺序调用
+// 函数收到 Shutdown 的 ctx，返回的错误包含在 Shutdown 的结果中
+func (pm *ProxyManager) OnShutdown(fn func(context.Context) error) {
+	pm.mu.Lock()
+	pm.shutdownFuncs = append(pm.shutdownFuncs, fn)
+	pm.mu.Unlock()
+}
+
+// Shutdown 关闭代理管理器，之后的拨号和 UpdateConfig 返回 ErrManagerShutdown
+// 依次停止 OTLP 推送并做最后一次导出、调用 OnShutdown 注册的函数、关闭拨号器持有的会话(SSH、HTTP2、QUIC 等)、健康检查、PAC 刷新和 RulesFile 监视；
+// ctx 限制最后一次导出和注册函数的时间，返回所有未能正常停止的部分的错误，重复调用返回 nil
+func (pm *ProxyManager) Shutdown(ctx context.Context) error {
+	if !pm.shutdown.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	var errs []error
+	if pm.stopOTLP != nil {
+		if err := pm.stopOTLP(ctx); err != nil {
+			errs = append(errs, E.WrapError(err, "otlp export"))
+		}
+		pm.stopOTLP = nil
+	}
+
+	pm.mu.Lock()
+	funcs := pm.shutdownFuncs
+	pm.shutdownFuncs = nil
+	pm.mu.Unlock()
+	for _, fn := range funcs {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := closeDialer(pm.dialer); err != nil {
+		errs = append(errs, E.WrapError(err, "close dialer"))
+	}
+	if pm.udp != nil {
+		if err := closeDialer(pm.udp.dialer); err != nil {
+			errs = append(errs, E.WrapError(err, "close udp proxy"))
+		}
+	}
+	for name, p := range pm.named {
+		if err := closeDialer(p.dialer); err != nil {
+			errs = append(errs, E.WrapError(err, "close proxy "+name))
+		}
+	}
+	pm.health.Stop()
+	pm.race.close()
+	pm.pac.close()
+	pm.rulesWatch.close()
+	pm.rulesWatch = nil
+	return errors.Join(errs...)
+}
+
+// IsShutdown 判断是否已经调用过 Shutdown
+func (pm *ProxyManager) IsShutdown() bool {
+	return pm.shutdown.Load()
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// newSLOTracker 根据配置创建 SLO 跟踪器，配置未变化时沿用 old 以保留窗口内的统计
+func (pm *ProxyManager) newSLOTracker(old *C.Config, config *C.Config) *metrics.SLOTracker {
+	if config.SLO == nil {
+		return nil
+	}
+	if pm.slo != nil && old != nil && reflect.DeepEqual(old.SLO, config.SLO) {
+		return pm.slo
+	}
+
+	slo := config.SLO
+	objective := metrics.SLOObjective{
+		SuccessTarget:     slo.SuccessTarget,
+		LatencyTarget:     slo.LatencyTarget,
+		LatencyObjective:  slo.LatencyObjective,
+		Windows:           slo.Windows,
+		BurnRateThreshold: slo.BurnRateThreshold,
+		MinSamples:        slo.MinSamples,
+		PerDestination:    slo.PerDestination,
+		MaxDestinations:   slo.MaxDestinations,
+	}
+	if len(objective.Windows) == 0 {
+		objective.Windows = C.DefaultSLOWindows
+	}
+	if objective.BurnRateThreshold == 0 {
+		objective.BurnRateThreshold = C.DefaultSLOBurnRateThreshold
+	}
+	if objective.MinSamples == 0 {
+		objective.MinSamples = C.DefaultSLOMinSamples
+	}
+	if objective.MaxDestinations == 0 {
+		objective.MaxDestinations = C.DefaultSLOMaxDestinations
+	}
+
+	t := metrics.NewSLOTracker(objective, pm.Clock())
+	if pm.onSLOAtRisk != nil {
+		t.OnAtRisk(pm.onSLOAtRisk)
+	}
+	return t
+}
+
+// recordSLO 记录一次代理拨号，调用方取消的拨号不计入
+func (pm *ProxyManager) recordSLO(ctx context.Context, addr string, start time.Time, err error) {
+	t := pm.slo
+	if t == nil {
+		return
+	}
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	t.Record(pm.Config.GetProxyAddr(), hostport.CanonicalHost(hostport.Host(addr)), pm.Clock().Since(start), err != nil)
+}
+
+// OnSLOBudgetAtRisk 设置错误预算有风险时的回调
+// 每个代理或目标主机的每个目标在所有窗口的消耗速率都达到阈值时通知一次，回落后重新计数
+func (pm *ProxyManager) OnSLOBudgetAtRisk(fn func(metrics.SLOEvent)) {
+	pm.onSLOAtRisk = fn
+	if pm.slo != nil {
+		pm.slo.OnAtRisk(fn)
+	}
+}
+
+// SLOStatus 返回各代理和目标主机在各窗口内的 SLO 状态，未配置 SLO 时返回 nil
+func (pm *ProxyManager) SLOStatus() []metrics.SLOStatus {
+	if pm.slo == nil {
+		return nil
+	}
+	return pm.slo.Status()
+}
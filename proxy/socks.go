@@ -2,136 +2,46 @@ package proxy
 
 import (
 	"context"
-	"encoding/binary"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	C "github.com/ba0gu0/GoHookProxy/config"
 	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
 	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/rules"
 )
 
-// SOCKS4请求格式:
-// +----+----+----+----+----+----+----+----+----+----+....+----+
-// | VN | CD | DSTPORT |      DSTIP        | USERID       |NULL|
-// +----+----+----+----+----+----+----+----+----+----+....+----+
-//    1    1      2              4           variable       1
-//
-// VN: SOCKS版本号(0x04)
-// CD: 命令码
-//     0x01 = CONNECT
-//     0x02 = BIND
-// DSTPORT: 目标端口(2字节)
-// DSTIP: 目标IP地址(4字节)
-// USERID: 用户ID字符串(可变长度)
-// NULL: 结束符(0x00)
-
-// SOCKS4响应格式:
-// +----+----+----+----+----+----+----+----+
-// | VN | CD | DSTPORT |      DSTIP        |
-// +----+----+----+----+----+----+----+----+
-//   1    1      2              4
-//
-// CD: 返回码
-//     0x5A = 请求granted
-//     0x5B = 请求rejected
-//     0x5C = 请求failed(无法连接到identd)
-//     0x5D = 请求failed(identd用户ID不匹配)
-
-// SOCKS5认证请求:
-// +----+----------+----------+
-// |VER | NMETHODS | METHODS  |
-// +----+----------+----------+
-// | 1  |    1     | 1 to 255 |
-// +----+----------+----------+
-//
-// VER: SOCKS版本号(0x05)
-// NMETHODS: 认证方法数量
-// METHODS: 认证方法列表
-//     0x00 = 无认证
-//     0x01 = GSSAPI
-//     0x02 = 用户名/密码
-//     0x03-0x7F = IANA分配
-//     0x80-0xFE = 私有方法
-
-// SOCKS5认证响应:
-// +----+--------+
-// |VER | METHOD |
-// +----+--------+
-// | 1  |   1    |
-// +----+--------+
-//
-// METHOD: 选择的认证方法
-//     0x00 = 无认证
-//     0xFF = 无可接受的方法
-
-// SOCKS5用户名/密码认证:
-// +----+------+----------+------+----------+
-// |VER | ULEN |  UNAME   | PLEN |  PASSWD  |
-// +----+------+----------+------+----------+
-// | 1  |  1   | 1 to 255 |  1   | 1 to 255 |
-// +----+------+----------+------+----------+
-
-// SOCKS5认证响应:
-// +----+--------+
-// |VER | STATUS |
-// +----+--------+
-// | 1  |   1    |
-// +----+--------+
-//
-// STATUS: 0x00 = 成功, 其他 = 失败
-
-// SOCKS5请求:
-// +----+-----+-------+------+----------+----------+
-// |VER | CMD |  RSV  | ATYP | DST.ADDR | DST.PORT |
-// +----+-----+-------+------+----------+----------+
-// | 1  |  1  | X'00' |  1   | Variable |    2     |
-// +----+-----+-------+------+----------+----------+
-//
-// VER: SOCKS版本号(0x05)
-// CMD: 命令码
-//     0x01 = CONNECT
-//     0x02 = BIND
-//     0x03 = UDP ASSOCIATE
-// RSV: 保留字段(0x00)
-// ATYP: 地址类型
-//     0x01 = IPv4
-//     0x03 = 域名
-//     0x04 = IPv6
-// DST.ADDR: 目标地址(变长)
-// DST.PORT: 目标端口(2字节)
-
-// SOCKS5响应:
-// +----+-----+-------+------+----------+----------+
-// |VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
-// +----+-----+-------+------+----------+----------+
-// | 1  |  1  | X'00' |  1   | Variable |    2     |
-// +----+-----+-------+------+----------+----------+
-//
-// REP: 响应码
-//     0x00 = 成功
-//     0x01 = 常规SOCKS服务器连接失败
-//     0x02 = 现有规则不允许连接
-//     0x03 = 网络不可达
-//     0x04 = 主机不可达
-//     0x05 = 连接被拒绝
-//     0x06 = TTL过期
-//     0x07 = 命令不支持
-//     0x08 = 地址类型不支持
-//     0x09-0xFF = 未分配
-
 // SocksDialer SOCKS代理拨号器
 type SocksDialer struct {
 	proxyURL  string
-	proxyType C.ProxyType // SOCKS4 或 SOCKS5
+	proxyType C.ProxyType // SOCKS4、SOCKS4A、SOCKS5 或 SOCKS5H，SOCKS5S 按 SOCKS5 处理
 	Config    *C.SOCKSConfig
-	metrics   *metrics.MetricsCollector
+	metrics   metrics.Recorder
+
+	rtt      rttEstimator
+	allowUDP bool                // 是否允许 UDP，由 HookUDP 或已弃用的 EnableUDP 开启
+	resolver Resolver            // SOCKS4 和 SOCKS5 的 UDP 在本地解析目标时使用，为 nil 时使用 SystemResolver
+	obfs     transport.Transport // 到代理的连接使用的传输插件，为 nil 时不使用
+
+	tlsConfig *lazy[*tls.Config] // 为 nil 时以明文连接代理，客户端证书在第一次握手时读取
+}
+
+func (d *SocksDialer) setResolver(r Resolver) {
+	d.resolver = r
 }
 
-func createSocksDialer(proxyType C.ProxyType, proxyIP string, proxyPort int, config *C.SOCKSConfig, metrics *metrics.MetricsCollector) (ProxyDialer, error) {
+func createSocksDialer(proxyType C.ProxyType, proxyIP string, proxyPort int, hookUDP bool, config *C.SOCKSConfig, metrics metrics.Recorder) (ProxyDialer, error) {
 	// 确保配置不为空
 	if config == nil {
 		config = &C.SOCKSConfig{
@@ -140,13 +50,17 @@ func createSocksDialer(proxyType C.ProxyType, proxyIP string, proxyPort int, con
 		}
 	}
 
-	proxyURL := fmt.Sprintf("%s:%d", proxyIP, proxyPort)
+	proxyURL := hostport.Join(proxyIP, proxyPort)
+	if _, ok := C.UnixSocketPath(proxyIP); ok {
+		proxyURL = proxyIP
+	}
 	dialer := NewSocksDialer(proxyURL, proxyType, config, metrics)
+	dialer.allowUDP = dialer.allowUDP || hookUDP
 	return dialer, nil
 }
 
 // NewSocksDialer 创建SOCKS拨号器
-func NewSocksDialer(proxyURL string, proxyType C.ProxyType, config *C.SOCKSConfig, metrics *metrics.MetricsCollector) *SocksDialer {
+func NewSocksDialer(proxyURL string, proxyType C.ProxyType, config *C.SOCKSConfig, metrics metrics.Recorder) *SocksDialer {
 	// 确保配置不为空
 	if config == nil {
 		config = &C.SOCKSConfig{
@@ -155,12 +69,33 @@ func NewSocksDialer(proxyURL string, proxyType C.ProxyType, config *C.SOCKSConfi
 		}
 	}
 
-	return &SocksDialer{
+	d := &SocksDialer{
 		proxyURL:  proxyURL,
 		proxyType: proxyType,
 		Config:    config,
 		metrics:   metrics,
+		allowUDP:  config.EnableUDP,
+	}
+	if config.UsesTLS(proxyType) {
+		d.tlsConfig = newLazy(func() (*tls.Config, error) { return socksTLSConfig(proxyURL, config) })
+	}
+	if proxyType == C.SOCKS5S {
+		d.proxyType = C.SOCKS5
 	}
+	return d
+}
+
+// socksTLSConfig 创建与 SOCKS 代理握手的 TLS 配置，ServerName 为空时使用代理主机名
+func socksTLSConfig(proxyURL string, config *C.SOCKSConfig) (*tls.Config, error) {
+	tlsConfig, err := newTLSConfig(config.TLSMinVersion, config.SkipVerify, config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = config.ServerName
+	if _, ok := C.UnixSocketPath(proxyURL); !ok && tlsConfig.ServerName == "" {
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(proxyURL)
+	}
+	return tlsConfig, nil
 }
 
 // Dial 实现 ProxyDialer 接口
@@ -168,12 +103,16 @@ func (d *SocksDialer) Dial(network, addr string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, addr)
 }
 
-// DialContext 实现SOCKS连接
+// DialContext 实现SOCKS连接，UDP 交给 DialPacketContext
 func (d *SocksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if rules.IsUDPNetwork(network) {
+		return d.DialPacketContext(ctx, network, addr)
+	}
+
 	start := time.Now()
 
 	if d.metrics != nil {
-		d.metrics.RecordConnection(0)
+		d.metrics.RecordDial(0)
 	}
 
 	// 验证网络类型
@@ -184,37 +123,76 @@ func (d *SocksDialer) DialContext(ctx context.Context, network, addr string) (ne
 		return nil, err
 	}
 
-	// 处理UDP连接
-	if network == "udp" || network == "udp4" || network == "udp6" {
-		if d.proxyType != C.SOCKS5 {
-			return nil, E.ErrSOCKSNetworkNotSupported
+	// TCP连接处理
+	conn, err := d.dialWithTimeout(ctx, addr)
+	if err != nil {
+		// ctx 结束打断的握手按 ctx 的错误返回
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
 		}
-
-		// 解析UDP地址
-		raddr, err := net.ResolveUDPAddr(network, addr)
-		if err != nil {
-			return nil, err
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
 		}
+		return nil, err
+	}
 
-		// 创建UDP连接
-		conn, err := d.dialUDPSocks5(network, nil, raddr)
-		if err != nil {
-			if d.metrics != nil {
-				d.metrics.RecordFailure(err)
-			}
-			return nil, err
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+
+	return conn, nil
+}
+
+// DialPacketContext 通过 UDP ASSOCIATE 建立到 addr 的 UDP 连接，实现 PacketDialer
+// SOCKS5 在本地解析目标地址，SOCKS5H 把主机名交给代理
+func (d *SocksDialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.dialPacket(ctx, network, addr)
+}
+
+// ListenPacket 建立不固定目标的 UDP 关联，实现 PacketDialer
+// 每个数据报通过 WriteTo 指定目标，ReadFrom 返回代理报告的来源地址
+func (d *SocksDialer) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	return d.dialPacket(ctx, network, "")
+}
+
+// dialPacket 建立 UDP 关联并记录指标，target 为空时不设置默认目标
+func (d *SocksDialer) dialPacket(ctx context.Context, network, target string) (*SocksUDPConn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	err := d.validateNetwork(network)
+	if err == nil && d.proxyType != C.SOCKS5 && d.proxyType != C.SOCKS5H {
+		err = E.ErrSOCKSNetworkNotSupported
+	}
+	if err != nil {
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
 		}
+		return nil, err
+	}
 
+	if capabilities.unsupported(d.proxyURL, CapUDPAssociate) {
+		err := E.WrapError(socks.ReplyCommandNotSupported.Err(), "cached capability")
 		if d.metrics != nil {
-			d.metrics.IncrementActiveConnections()
-			d.metrics.RecordConnection(time.Since(start))
+			d.metrics.RecordFailure(err)
 		}
+		return nil, err
+	}
 
-		return conn, nil
+	if target != "" && d.proxyType == C.SOCKS5 {
+		ip, port, err := resolveAddr(ctx, d.resolver, network, target)
+		if err != nil {
+			return nil, err
+		}
+		target = (&net.UDPAddr{IP: ip.IP, Port: port, Zone: ip.Zone}).String()
 	}
 
-	// TCP连接处理
-	conn, err := d.dialWithTimeout(ctx, addr)
+	conn, err := d.dialUDPSocks5(ctx, network, nil, target)
 	if err != nil {
 		if d.metrics != nil {
 			d.metrics.RecordFailure(err)
@@ -224,9 +202,9 @@ func (d *SocksDialer) DialContext(ctx context.Context, network, addr string) (ne
 
 	if d.metrics != nil {
 		d.metrics.IncrementActiveConnections()
-		d.metrics.RecordConnection(time.Since(start))
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
 	}
-
 	return conn, nil
 }
 
@@ -235,7 +213,7 @@ func (d *SocksDialer) validateNetwork(network string) error {
 	case "tcp", "tcp4", "tcp6":
 		return nil
 	case "udp", "udp4", "udp6":
-		if !d.Config.EnableUDP {
+		if !d.allowUDP {
 			return E.ErrSOCKSNetworkNotSupported
 		}
 		return nil
@@ -246,65 +224,127 @@ func (d *SocksDialer) validateNetwork(network string) error {
 
 func (d *SocksDialer) dialWithTimeout(ctx context.Context, addr string) (net.Conn, error) {
 	switch d.proxyType {
-	case C.SOCKS4:
+	case C.SOCKS4, C.SOCKS4A:
 		return d.dialSocks4(ctx, addr)
-	case C.SOCKS5:
+	case C.SOCKS5, C.SOCKS5H:
 		return d.dialSocks5(ctx, addr)
 	default:
 		return nil, E.ErrSOCKSVersionNotSupported
 	}
 }
 
-func (d *SocksDialer) dialSocks4(ctx context.Context, addr string) (net.Conn, error) {
-	host, port, err := net.SplitHostPort(addr)
+// guardHandshake 为握手阶段设置截止时间，ctx 结束时打断握手
+func (d *SocksDialer) guardHandshake(ctx context.Context, conn net.Conn) *handshakeGuard {
+	deadline := handshakeDeadline(ctx, &d.rtt, d.Config.AdaptiveTimeout, d.Config.MinHandshakeTimeout, d.Config.Timeout)
+	return guardHandshake(ctx, conn, deadline)
+}
+
+// credentials 返回本次拨号使用的凭证，ctx 中的凭证优先于配置
+func (d *SocksDialer) credentials(ctx context.Context) Credentials {
+	return credentialsFromContext(ctx, Credentials{User: d.Config.User, Pass: d.Config.Pass})
+}
+
+// dialProxy 连接代理服务器，proxyURL 以 unix: 开头时连接 Unix 域套接字
+// TCP 连接按 KeepAlive 开启 TCP keepalive，避免长期空闲的控制连接被中间设备回收
+func (d *SocksDialer) dialProxy() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.Config.Timeout, KeepAlive: d.Config.KeepAlive}
+	if path, ok := C.UnixSocketPath(d.proxyURL); ok {
+		return dialer.Dial("unix", path)
+	}
+	return dialer.Dial("tcp", d.proxyURL)
+}
+
+// handshakeTLS 启用 TLS 时在到代理的连接上完成 TLS 握手，否则原样返回 conn，失败时关闭 conn
+func (d *SocksDialer) handshakeTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if d.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConfig, err := d.tlsConfig.get()
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	stageStart := time.Now()
+	tlsConn := tls.Client(conn, tlsConfig.Clone())
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrTLSHandshake, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+	return tlsConn, nil
+}
+
+// SmoothedRTT 返回到代理的平滑 RTT 估计
+func (d *SocksDialer) SmoothedRTT() time.Duration {
+	return d.rtt.SRTT()
+}
 
-	portNum, err := strconv.Atoi(port)
+// dialSocks4 通过 SOCKS4/4a 建立 TCP 连接
+// SOCKS4 只能发送 IPv4 地址，主机名用 resolver 在本地解析；SOCKS4A 把主机名原样交给代理
+func (d *SocksDialer) dialSocks4(ctx context.Context, addr string) (net.Conn, error) {
+	host, portNum, err := hostport.Split(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	proxyConn, err := net.DialTimeout("tcp", d.proxyURL, d.Config.Timeout)
+	// DSTIP 为 0.0.0.x 时表示 SOCKS4a，后跟以 NUL 结尾的域名
+	var dstIP net.IP
+	var domain string
+	var resolved *net.IPAddr // 在本地解析得到的地址，记录经过代理到它的连接结果
+	if ip := hostport.ParseIP(host); ip != nil {
+		if dstIP = ip.To4(); dstIP == nil {
+			return nil, E.ErrSOCKSAddressTypeNotSupported
+		}
+	} else if d.proxyType == C.SOCKS4A {
+		if host == "" || strings.IndexByte(host, 0) >= 0 {
+			return nil, E.ErrSOCKSAddressTypeNotSupported
+		}
+		dstIP, domain = net.IPv4(0, 0, 0, 1).To4(), host
+	} else {
+		ip, _, err := resolveAddr(ctx, d.resolver, "tcp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		if dstIP = ip.IP.To4(); dstIP == nil {
+			return nil, E.ErrSOCKSAddressTypeNotSupported
+		}
+		resolved = &ip
+	}
+	user := d.credentials(ctx).User
+	if strings.IndexByte(user, 0) >= 0 {
+		return nil, E.WrapError(E.ErrSOCKSAuthFailed, "socks4 user id cannot contain NUL")
+	}
+
+	stageStart := time.Now()
+	proxyConn, err := d.dialProxy()
 	if err != nil {
 		return nil, E.ErrSOCKSProxyUnreachable
 	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, proxyConn)
+	d.rtt.Observe(time.Since(stageStart))
+	if proxyConn, err = wrapTransport(ctx, d.obfs, proxyConn); err != nil {
+		return nil, err
+	}
 
-	if deadline, ok := ctx.Deadline(); ok {
-		proxyConn.SetDeadline(deadline)
+	guard := d.guardHandshake(ctx, proxyConn)
+	defer guard.stop()
+	if proxyConn, err = d.handshakeTLS(ctx, proxyConn); err != nil {
+		return nil, err
 	}
+	stageStart = time.Now()
 
-	// SOCKS4/4a请求
+	// SOCKS4/4a请求: VN CD DSTPORT DSTIP USERID NUL [域名 NUL]
 	req := []byte{
-		0x04,                                     // VN: SOCKS4版本
-		0x01,                                     // CD: CONNECT命令
+		socks.Version4,                           // VN: SOCKS4版本
+		byte(socks.CmdConnect),                   // CD: CONNECT命令
 		byte(portNum >> 8), byte(portNum & 0xff), // DSTPORT
 	}
-
-	ip := net.ParseIP(host)
-	if ip != nil {
-		// SOCKS4: 使用IP地址
-		ip4 := ip.To4()
-		if ip4 == nil {
-			proxyConn.Close()
-			return nil, E.ErrSOCKSAddressTypeNotSupported
-		}
-		req = append(req, ip4...)
-	} else {
-		// SOCKS4a: 使用域名
-		req = append(req, []byte{0, 0, 0, 1}...) // 特殊IP表示SOCKS4a
-	}
-
-	// 添加用户ID (如果有)
-	if d.Config.User != "" {
-		req = append(req, []byte(d.Config.User)...)
-	}
+	req = append(req, dstIP...)
+	req = append(req, user...)
 	req = append(req, 0x00) // NULL结束符
-
-	// SOCKS4a时添加域名
-	if ip == nil {
-		req = append(req, []byte(host)...)
+	if domain != "" {
+		req = append(req, domain...)
 		req = append(req, 0x00) // NULL结束符
 	}
 
@@ -315,146 +355,221 @@ func (d *SocksDialer) dialSocks4(ctx context.Context, addr string) (net.Conn, er
 	}
 
 	// 读取响应
+	hc := newHandshakeConn(proxyConn)
 	resp := make([]byte, 8)
-	if _, err := io.ReadFull(proxyConn, resp); err != nil {
+	if _, err := io.ReadFull(hc, resp); err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	// 检查响应
-	if resp[1] != 0x5A {
+	// 检查响应，VN 必须为 0
+	if resp[0] != 0x00 {
 		proxyConn.Close()
-		switch resp[1] {
-		case 0x5B:
-			return nil, E.ErrSOCKSConnectFailed
-		case 0x5C:
-			return nil, E.ErrSOCKSAuthFailed
-		case 0x5D:
-			return nil, E.ErrSOCKSAuthFailed
-		default:
-			return nil, E.ErrSOCKSConnectFailed
+		return nil, fmt.Errorf("%w: reply version %#02x", E.ErrSOCKSVersionNotSupported, resp[0])
+	}
+	err = socks.Reply4(resp[1]).Err()
+	if o, ok := d.resolver.(addrObserver); ok && resolved != nil && !errors.Is(err, E.ErrSOCKSAuthFailed) {
+		o.observeAddr(*resolved, time.Since(stageStart), err)
+	}
+	if err != nil {
+		proxyConn.Close()
+		if d.proxyType == C.SOCKS4A && errors.Is(err, E.ErrSOCKSAuthFailed) {
+			err = fmt.Errorf("%w: %w", E.ErrSOCKS4AAuth, err)
 		}
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		proxyConn.Close()
+		return nil, err
 	}
 
-	return proxyConn, nil
+	return hc.tunnel(), nil
 }
 
 func (d *SocksDialer) dialSocks5(ctx context.Context, addr string) (net.Conn, error) {
-	proxyConn, err := net.DialTimeout("tcp", d.proxyURL, d.Config.Timeout)
+	dst, err := socks.ParseAddr(addr)
 	if err != nil {
-		return nil, E.ErrSOCKSProxyUnreachable
+		return nil, err
 	}
-
-	if deadline, ok := ctx.Deadline(); ok {
-		proxyConn.SetDeadline(deadline)
+	creds := d.credentials(ctx)
+	// 缓存记录代理不支持时不再发起注定失败的握手
+	if socks5Method(creds) == socks.MethodNoAuth && capabilities.unsupported(d.proxyURL, CapNoAuth) {
+		return nil, E.WrapError(E.ErrSOCKS5NoAcceptableMethods, "cached capability")
+	}
+	ipv6 := dst.IP != nil && dst.IP.To4() == nil
+	if ipv6 && capabilities.unsupported(d.proxyURL, CapIPv6) {
+		return nil, E.WrapError(socks.ReplyAddrTypeNotSupported.Err(), "cached capability")
 	}
 
-	// 认证协商
-	methods := []byte{0x00} // 无认证
-	if d.Config.User != "" && d.Config.Pass != "" {
-		methods = []byte{0x02} // 用户名/密码认证
+	stageStart := time.Now()
+	proxyConn, err := d.dialProxy()
+	if err != nil {
+		return nil, E.ErrSOCKSProxyUnreachable
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, proxyConn)
+	d.rtt.Observe(time.Since(stageStart))
+	if proxyConn, err = wrapTransport(ctx, d.obfs, proxyConn); err != nil {
+		return nil, err
 	}
 
-	authReq := []byte{0x05, byte(len(methods))}
-	authReq = append(authReq, methods...)
+	guard := d.guardHandshake(ctx, proxyConn)
+	defer guard.stop()
+	if proxyConn, err = d.handshakeTLS(ctx, proxyConn); err != nil {
+		return nil, err
+	}
+	stageStart = time.Now()
+	hc := newHandshakeConn(proxyConn)
 
-	if _, err := proxyConn.Write(authReq); err != nil {
+	// 认证协商
+	if err := d.negotiateSocks5(guard, hc, creds); err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	authResp := make([]byte, 2)
-	if _, err := io.ReadFull(proxyConn, authResp); err != nil {
+	req, err := dst.Append([]byte{socks.Version5, byte(socks.CmdConnect), 0x00})
+	if err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	if authResp[0] != 0x05 {
+	if _, err := proxyConn.Write(req); err != nil {
 		proxyConn.Close()
-		return nil, E.ErrSOCKSVersionNotSupported
+		return nil, err
 	}
 
-	if authResp[1] == 0x02 {
-		if err := d.authenticateSocks5(proxyConn); err != nil {
-			proxyConn.Close()
-			return nil, err
+	bound, err := d.readSocks5Reply(hc)
+	if ipv6 {
+		if err == nil {
+			capabilities.record(d.proxyURL, CapIPv6, true)
+		} else if errors.Is(err, E.ErrSOCKS5AddressTypeNotSupported) {
+			capabilities.record(d.proxyURL, CapIPv6, false)
 		}
 	}
-
-	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
-
-	portNum, err := strconv.Atoi(port)
-	if err != nil {
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	req := []byte{0x05, 0x01, 0x00}
+	// 代理紧跟在响应后发送的目标数据保留在返回的连接中
+	return &socksConn{Conn: hc.tunnel(), bound: bound}, nil
+}
+
+// socksConn SOCKS5 CONNECT 建立的连接，记录代理响应中的 BND 地址
+type socksConn struct {
+	net.Conn
+	bound socks.Addr
+}
 
-	ip := net.ParseIP(host)
-	if ip == nil {
-		req = append(req, 0x03)
-		req = append(req, byte(len(host)))
-		req = append(req, []byte(host)...)
-	} else if ip4 := ip.To4(); ip4 != nil {
-		req = append(req, 0x01)
-		req = append(req, ip4...)
-	} else {
-		req = append(req, 0x04)
-		req = append(req, ip.To16()...)
+// CloseWrite 关闭底层连接的写方向
+func (c *socksConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
 	}
+	return &net.OpError{Op: "close", Net: "tcp", Err: E.ErrUnsupportedProxy}
+}
 
-	portBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(portBytes, uint16(portNum))
-	req = append(req, portBytes...)
+// SyscallConn 返回底层套接字，设置 DSCP 等套接字选项的代码不需要先 Unwrap
+func (c *socksConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, &net.OpError{Op: "raw-control", Net: "tcp", Err: E.ErrUnsupportedProxy}
+}
 
-	if _, err := proxyConn.Write(req); err != nil {
-		proxyConn.Close()
-		return nil, err
+func (c *socksConn) Unwrap() net.Conn { return c.Conn }
+
+// BoundAddr 返回 SOCKS5 代理在 CONNECT 响应中给出的 BND.ADDR 和 BND.PORT，即代理连接目标时使用的本端地址
+// FTP 的 PORT 命令、RTSP 等需要把地址告诉对端的协议使用它；地址原样返回，部分代理只返回 0.0.0.0:0。
+// 沿 Unwrap 链查找，conn 不是经过 SOCKS5 代理建立的 TCP 连接时返回 false
+func BoundAddr(conn net.Conn) (socks.Addr, bool) {
+	c, ok := Unwrap[*socksConn](conn)
+	if !ok {
+		return socks.Addr{}, false
 	}
+	return c.bound, true
+}
 
-	resp := make([]byte, 4)
-	if _, err := io.ReadFull(proxyConn, resp); err != nil {
-		proxyConn.Close()
-		return nil, err
+// socks5Method 返回 creds 对应的认证方法，用户名和密码都不为空时使用用户名/密码认证
+func socks5Method(creds Credentials) socks.Method {
+	if creds.User != "" && creds.Pass != "" {
+		return socks.MethodUserPass
 	}
+	return socks.MethodNoAuth
+}
 
-	if resp[1] != 0x00 {
-		proxyConn.Close()
-		return nil, E.ErrSOCKSConnectFailed
+// authTimeout 方法协商和认证子协商的超时，Timeout 未设置时使用 DefaultSOCKSTimeout
+func (d *SocksDialer) authTimeout() time.Duration {
+	if d.Config.Timeout > 0 {
+		return d.Config.Timeout
 	}
+	return C.DefaultSOCKSTimeout
+}
 
-	// 跳过绑定地址和端口
-	switch resp[3] {
-	case 0x01:
-		_, err = io.CopyN(io.Discard, proxyConn, 4+2) // IPv4 + Port
-	case 0x03:
-		var length [1]byte
-		_, err = io.ReadFull(proxyConn, length[:])
-		if err == nil {
-			_, err = io.CopyN(io.Discard, proxyConn, int64(length[0])+2)
-		}
-	case 0x04:
-		_, err = io.CopyN(io.Discard, proxyConn, 16+2) // IPv6 + Port
+// negotiateSocks5 发送方法协商请求，服务器选择用户名/密码认证时完成认证
+// 协商在 guard 的握手截止时间内进行，并且不超过 authTimeout，不响应的代理不会让拨号一直阻塞
+func (d *SocksDialer) negotiateSocks5(guard *handshakeGuard, conn net.Conn, creds Credentials) error {
+	defer guard.phase(d.authTimeout())()
+	method := socks5Method(creds)
+
+	authReq := []byte{socks.Version5, 1, byte(method)}
+
+	if _, err := conn.Write(authReq); err != nil {
+		return err
 	}
 
-	if err != nil {
-		proxyConn.Close()
-		return nil, err
+	authResp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, authResp); err != nil {
+		return err
+	}
+
+	if authResp[0] != socks.Version5 {
+		return E.ErrSOCKSVersionNotSupported
+	}
+
+	selected := socks.Method(authResp[1])
+	if method == socks.MethodNoAuth {
+		capabilities.record(d.proxyURL, CapNoAuth, selected == socks.MethodNoAuth)
+	}
+	switch selected {
+	case socks.MethodUserPass:
+		return d.authenticateSocks5(conn, creds)
+	case socks.MethodNoAcceptable:
+		return E.ErrSOCKS5NoAcceptableMethods
 	}
+	return nil
+}
 
-	return proxyConn, nil
+// readSocks5Reply 读取请求的响应，REP 不是成功时返回对应的错误，成功时返回 BND 地址
+func (d *SocksDialer) readSocks5Reply(conn net.Conn) (socks.Addr, error) {
+	resp := make([]byte, 3)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return socks.Addr{}, err
+	}
+	if resp[0] != socks.Version5 {
+		return socks.Addr{}, E.ErrSOCKSVersionNotSupported
+	}
+	if err := socks.Reply(resp[1]).Err(); err != nil {
+		return socks.Addr{}, err
+	}
+	return socks.ReadAddr(conn)
 }
 
-func (d *SocksDialer) authenticateSocks5(conn net.Conn) error {
-	username := []byte(d.Config.User)
-	password := []byte(d.Config.Pass)
+// authenticateSocks5 完成 RFC 1929 用户名/密码认证，用户名和密码各不超过 255 字节
+func (d *SocksDialer) authenticateSocks5(conn net.Conn, creds Credentials) error {
+	username := []byte(creds.User)
+	password := []byte(creds.Pass)
+	if len(username) > 255 || len(password) > 255 {
+		return E.ErrSOCKS5CredentialsTooLong
+	}
 
-	req := []byte{0x01, byte(len(username))}
+	req := []byte{socks.AuthVersion, byte(len(username))}
 	req = append(req, username...)
 	req = append(req, byte(len(password)))
 	req = append(req, password...)
@@ -468,7 +583,7 @@ func (d *SocksDialer) authenticateSocks5(conn net.Conn) error {
 		return err
 	}
 
-	if resp[1] != 0x00 {
+	if resp[1] != socks.AuthSucceeded {
 		return E.ErrSOCKSAuthFailed
 	}
 
@@ -477,13 +592,13 @@ func (d *SocksDialer) authenticateSocks5(conn net.Conn) error {
 
 // DialUDP 创建UDP连接
 func (d *SocksDialer) DialUDP(network string, laddr, raddr *net.UDPAddr) (*SocksUDPConn, error) {
-	if !d.Config.EnableUDP {
+	if !d.allowUDP {
 		return nil, E.ErrSOCKSNetworkNotSupported
 	}
 
 	switch d.proxyType {
-	case C.SOCKS5:
-		return d.dialUDPSocks5(network, laddr, raddr)
+	case C.SOCKS5, C.SOCKS5H:
+		return d.dialUDPSocks5(context.Background(), network, laddr, raddr.String())
 	default:
 		return nil, E.ErrSOCKSNetworkNotSupported
 	}
@@ -494,148 +609,265 @@ type SocksUDPConn struct {
 	*net.UDPConn
 	proxyConn  net.Conn     // TCP连接到代理服务器
 	udpAddr    *net.UDPAddr // UDP中继地址
-	targetAddr *net.UDPAddr // 目标地址
+	targetHead []byte       // 编码后的目标地址 ATYP DST.ADDR DST.PORT
 	closed     chan struct{}
+	closeOnce  sync.Once
+	lastWrite  int64               // 最近一次发往中继的时间，UnixNano
+	counter    *metrics.UDPCounter // 本关联的计数，同时累加到收集器
+
+	maxDatagram int        // 最大数据报负载
+	readMu      sync.Mutex // 保护 readBuf
+	readBuf     []byte     // 接收暂存区，容纳最长的 SOCKS5 UDP 头和最大负载，多 1 字节用于识别超长数据报
 }
 
 // dialUDPSocks5 通过SOCKS5代理建立UDP连接
-func (d *SocksDialer) dialUDPSocks5(network string, laddr, raddr *net.UDPAddr) (*SocksUDPConn, error) {
+// target 为 host:port，主机名原样写入 UDP 头由代理解析，为空时 Write 需要改用 WriteTo
+func (d *SocksDialer) dialUDPSocks5(ctx context.Context, network string, laddr *net.UDPAddr, target string) (*SocksUDPConn, error) {
+	var targetHeader []byte
+	if target != "" {
+		dst, err := socks.ParseAddr(target)
+		if err != nil {
+			return nil, err
+		}
+		if targetHeader, err = dst.Append(nil); err != nil {
+			return nil, err
+		}
+	}
+
 	// 1. 建立到代理服务器的TCP连接
-	proxyConn, err := net.DialTimeout("tcp", d.proxyURL, d.Config.Timeout)
+	stageStart := time.Now()
+	proxyConn, err := d.dialProxy()
 	if err != nil {
 		return nil, err
 	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	d.rtt.Observe(time.Since(stageStart))
+	guard := d.guardHandshake(ctx, proxyConn)
+	defer guard.stop()
+	if proxyConn, err = d.handshakeTLS(ctx, proxyConn); err != nil {
+		return nil, err
+	}
+	stageStart = time.Now()
 
 	// 2. 进行SOCKS5认证
-	if err := d.authenticateSocks5(proxyConn); err != nil {
+	if err := d.negotiateSocks5(guard, proxyConn, d.credentials(ctx)); err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
 	// 3. 发送UDP ASSOCIATE请求
-	req := []byte{
-		0x05,                   // VER
-		0x03,                   // CMD: UDP ASSOCIATE
-		0x00,                   // RSV
-		0x01,                   // ATYP: IPv4
-		0x00, 0x00, 0x00, 0x00, // IP: 0.0.0.0
-		0x00, 0x00, // PORT: 0
-	}
-
+	// 客户端地址未知，使用 0.0.0.0:0
+	req, _ := socks.Addr{IP: net.IPv4zero}.Append([]byte{socks.Version5, byte(socks.CmdUDPAssociate), 0x00})
 	if _, err := proxyConn.Write(req); err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	// 4. 读取响应
-	resp := make([]byte, 4)
-	if _, err := io.ReadFull(proxyConn, resp); err != nil {
+	// 4. 读取响应，BND 为UDP中继地址
+	bound, err := d.readSocks5Reply(proxyConn)
+	if err == nil {
+		capabilities.record(d.proxyURL, CapUDPAssociate, true)
+	} else if errors.Is(err, E.ErrSOCKS5CommandNotSupported) {
+		capabilities.record(d.proxyURL, CapUDPAssociate, false)
+	}
+	if err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
-
-	if resp[1] != 0x00 {
+	if bound.IP == nil {
 		proxyConn.Close()
-		return nil, E.ErrSOCKSConnectFailed
+		return nil, E.ErrSOCKSAddressTypeNotSupported
 	}
+	udpAddr := &net.UDPAddr{IP: bound.IP, Port: bound.Port}
 
-	// 5. 解析UDP中继地址
-	var udpAddr *net.UDPAddr
-	switch resp[3] {
-	case 0x01: // IPv4
-		addr := make([]byte, 4+2)
-		if _, err := io.ReadFull(proxyConn, addr); err != nil {
-			proxyConn.Close()
-			return nil, err
-		}
-		udpAddr = &net.UDPAddr{
-			IP:   net.IPv4(addr[0], addr[1], addr[2], addr[3]),
-			Port: int(binary.BigEndian.Uint16(addr[4:])),
-		}
-	case 0x04: // IPv6
-		addr := make([]byte, 16+2)
-		if _, err := io.ReadFull(proxyConn, addr); err != nil {
-			proxyConn.Close()
-			return nil, err
-		}
-		udpAddr = &net.UDPAddr{
-			IP:   addr[:16],
-			Port: int(binary.BigEndian.Uint16(addr[16:])),
-		}
-	default:
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
 		proxyConn.Close()
-		return nil, E.ErrSOCKSAddressTypeNotSupported
+		return nil, err
 	}
 
-	// 6. 创建本地UDP连接
+	// 5. 创建本地UDP连接
 	udpConn, err := net.ListenUDP(network, laddr)
 	if err != nil {
 		proxyConn.Close()
 		return nil, err
 	}
 
-	return &SocksUDPConn{
+	conn := &SocksUDPConn{
 		UDPConn:    udpConn,
 		proxyConn:  proxyConn,
 		udpAddr:    udpAddr,
-		targetAddr: raddr,
+		targetHead: targetHeader,
 		closed:     make(chan struct{}),
-	}, nil
+		lastWrite:  time.Now().UnixNano(),
+		counter:    collectorOf(d.metrics).UDP().Child(),
+	}
+	conn.maxDatagram = d.Config.MaxDatagramSize
+	if conn.maxDatagram <= 0 {
+		conn.maxDatagram = C.DefaultSOCKSMaxDatagramSize
+	}
+	conn.readBuf = make([]byte, socks.MaxUDPHeaderLen+conn.maxDatagram+1)
+	conn.counter.AddAssociation()
+	go conn.watchRelay()
+	if d.Config.UDPKeepAlive > 0 {
+		go conn.keepAlive(d.Config.UDPKeepAlive)
+	}
+	return conn, nil
 }
 
-// Write 实现UDP写入
-func (c *SocksUDPConn) Write(b []byte) (n int, err error) {
+// Stats 返回本关联的数据报统计
+func (c *SocksUDPConn) Stats() metrics.UDPStats {
+	return c.counter.Stats()
+}
+
+// watchRelay 等待控制连接结束，代理关闭控制连接时中继随之失效，记录后关闭关联
+func (c *SocksUDPConn) watchRelay() {
+	io.Copy(io.Discard, c.proxyConn)
 	select {
 	case <-c.closed:
-		return 0, net.ErrClosed
 	default:
-		// SOCKS5 UDP请求头
-		header := []byte{
-			0x00, 0x00, 0x00, // RSV
-			0x01, // FRAG: 0
-			0x01, // ATYP: IPv4
+		c.counter.AddRelayReset()
+		c.Close()
+	}
+}
+
+// keepAlive 关联空闲超过 interval 时向中继发送零长度数据报，
+// 中继会把它当作不完整的请求丢弃，但会刷新关联和沿途 NAT 的空闲计时
+func (c *SocksUDPConn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&c.lastWrite))
+			if now.Sub(last) < interval {
+				continue
+			}
+			if _, err := c.UDPConn.WriteToUDP(nil, c.udpAddr); err != nil {
+				return
+			}
+			atomic.StoreInt64(&c.lastWrite, now.UnixNano())
 		}
-		header = append(header, c.targetAddr.IP.To4()...)
-		port := make([]byte, 2)
-		binary.BigEndian.PutUint16(port, uint16(c.targetAddr.Port))
-		header = append(header, port...)
+	}
+}
 
-		// 组合数据
-		data := append(header, b...)
-		return c.UDPConn.WriteToUDP(data, c.udpAddr)
+// Write 向默认目标发送数据报，超过最大数据报负载时返回错误
+func (c *SocksUDPConn) Write(b []byte) (n int, err error) {
+	if c.targetHead == nil {
+		return 0, E.ErrSOCKS5NoTarget
 	}
+	return c.writeDatagram(b, c.targetHead)
 }
 
-// Read 实现UDP读取
-func (c *SocksUDPConn) Read(b []byte) (n int, err error) {
+// WriteTo 向 addr 发送数据报，addr 为域名时由代理解析
+func (c *SocksUDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, err := socks.ParseAddr(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	head, err := dst.Append(nil)
+	if err != nil {
+		return 0, err
+	}
+	return c.writeDatagram(b, head)
+}
+
+// writeDatagram 加上 SOCKS5 UDP 头发往中继
+func (c *SocksUDPConn) writeDatagram(b, head []byte) (int, error) {
 	select {
 	case <-c.closed:
 		return 0, net.ErrClosed
 	default:
-		buf := make([]byte, len(b)+10+net.IPv4len+2) // 预留UDP头空间
-		n, _, err := c.UDPConn.ReadFromUDP(buf)
+	}
+
+	if len(b) > c.maxDatagram {
+		c.counter.AddOversizedDrop()
+		return 0, E.ErrSOCKS5DatagramTooLarge
+	}
+
+	// SOCKS5 UDP请求头: RSV(2) FRAG(1) ATYP(1) DST.ADDR DST.PORT
+	data := make([]byte, 0, 3+len(head)+len(b))
+	data = append(data, 0x00, 0x00, 0x00)
+	data = append(data, head...)
+	data = append(data, b...)
+	atomic.StoreInt64(&c.lastWrite, time.Now().UnixNano())
+	if _, err := c.UDPConn.WriteToUDP(data, c.udpAddr); err != nil {
+		return 0, err
+	}
+	c.counter.AddPacketSent()
+	return len(b), nil
+}
+
+// Read 实现UDP读取
+// 头无法解析、分片或超过最大负载的数据报被丢弃并继续等待下一个；
+// 负载超过 b 时复制能放下的部分并返回 io.ErrShortBuffer，剩余部分被丢弃
+func (c *SocksUDPConn) Read(b []byte) (n int, err error) {
+	n, _, err = c.ReadFrom(b)
+	return n, err
+}
+
+// ReadFrom 读取一个数据报，返回代理报告的来源地址，丢弃规则与 Read 相同
+func (c *SocksUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		select {
+		case <-c.closed:
+			return 0, nil, net.ErrClosed
+		default:
+		}
+
+		n, _, err := c.UDPConn.ReadFromUDP(c.readBuf)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
+		}
+		if n == len(c.readBuf) {
+			c.counter.AddOversizedDrop()
+			continue
 		}
 
-		// 跳过SOCKS5 UDP响应头
-		if n < 10 {
-			return 0, io.ErrShortBuffer
+		src, hdr, err := socks.ParseUDPHeader(c.readBuf[:n])
+		if err != nil {
+			c.counter.AddHeaderError()
+			continue
+		}
+		payload := c.readBuf[hdr:n]
+		if len(payload) > c.maxDatagram {
+			c.counter.AddOversizedDrop()
+			continue
 		}
 
-		copy(b, buf[10:n])
-		return n - 10, nil
+		c.counter.AddPacketReceived()
+		var from net.Addr = SocksAddr(src.String())
+		if src.IP != nil {
+			from = &net.UDPAddr{IP: src.IP, Port: src.Port}
+		}
+		n = copy(b, payload)
+		if n < len(payload) {
+			c.counter.AddOversizedDrop()
+			return n, from, io.ErrShortBuffer
+		}
+		return n, from, nil
 	}
 }
 
+// SocksAddr 代理以域名形式报告的 UDP 地址，格式为 host:port
+type SocksAddr string
+
+func (a SocksAddr) Network() string { return "udp" }
+func (a SocksAddr) String() string  { return string(a) }
+
 // Close 关闭所有连接
 func (c *SocksUDPConn) Close() error {
-	select {
-	case <-c.closed:
-		return nil
-	default:
+	var err error
+	c.closeOnce.Do(func() {
 		close(c.closed)
 		c.proxyConn.Close()
-		return c.UDPConn.Close()
-	}
+		err = c.UDPConn.Close()
+	})
+	return err
 }
@@ -0,0 +1,170 @@
+package socks
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+// MaxAddrLen ATYP DST.ADDR DST.PORT 的最大长度: ATYP(1) 域名(1+255) PORT(2)
+const MaxAddrLen = 1 + 1 + 255 + 2
+
+// MaxUDPHeaderLen UDP 头的最大长度: RSV(2) FRAG(1) 加上最长的地址
+const MaxUDPHeaderLen = 3 + MaxAddrLen
+
+// Addr SOCKS 地址，IP 为空时使用域名 Name
+type Addr struct {
+	IP   net.IP
+	Name string
+	Port int
+}
+
+// ParseAddr 解析 host:port，主机名不在本地解析
+// IPv6 字面量的 zone 无法通过代理传递，会被丢弃；IPv4-mapped 地址按 IPv4 编码
+func ParseAddr(addr string) (Addr, error) {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		return Addr{}, err
+	}
+	if ip := hostport.ParseIP(host); ip != nil {
+		return Addr{IP: ip, Port: port}, nil
+	}
+	return Addr{Name: host, Port: port}, nil
+}
+
+// FromNetAddr 从 TCP/UDP 地址构造，其他类型或 nil 返回 0.0.0.0:0
+func FromNetAddr(addr net.Addr) Addr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return Addr{IP: a.IP, Port: a.Port}
+	case *net.UDPAddr:
+		return Addr{IP: a.IP, Port: a.Port}
+	default:
+		return Addr{IP: net.IPv4zero}
+	}
+}
+
+// Type 返回编码时使用的地址类型
+func (a Addr) Type() AddrType {
+	switch {
+	case a.IP == nil:
+		return AtypDomain
+	case a.IP.To4() != nil:
+		return AtypIPv4
+	default:
+		return AtypIPv6
+	}
+}
+
+// String 返回 host:port
+func (a Addr) String() string {
+	host := a.Name
+	if a.IP != nil {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+// Append 按 ATYP DST.ADDR DST.PORT 编码追加到 b
+func (a Addr) Append(b []byte) ([]byte, error) {
+	switch a.Type() {
+	case AtypDomain:
+		if len(a.Name) > 255 {
+			return nil, E.ErrSOCKS5HostnameTooLong
+		}
+		b = append(b, byte(AtypDomain), byte(len(a.Name)))
+		b = append(b, a.Name...)
+	case AtypIPv4:
+		b = append(b, byte(AtypIPv4))
+		b = append(b, a.IP.To4()...)
+	default:
+		b = append(b, byte(AtypIPv6))
+		b = append(b, a.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(a.Port)), nil
+}
+
+// ReadAddr 从 r 读取 ATYP DST.ADDR DST.PORT
+func ReadAddr(r io.Reader) (Addr, error) {
+	// 先读 ATYP 和下一个字节，域名类型时它是长度
+	var buf [MaxAddrLen]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return Addr{}, err
+	}
+
+	var n int
+	switch AddrType(buf[0]) {
+	case AtypIPv4:
+		n = 1 + net.IPv4len + 2
+	case AtypIPv6:
+		n = 1 + net.IPv6len + 2
+	case AtypDomain:
+		n = 1 + 1 + int(buf[1]) + 2
+	default:
+		return Addr{}, E.ErrSOCKS5AddressTypeNotSupported
+	}
+	if _, err := io.ReadFull(r, buf[2:n]); err != nil {
+		return Addr{}, err
+	}
+	a, _, err := DecodeAddr(buf[:n])
+	return a, err
+}
+
+// DecodeAddr 从 b 的开头解码 ATYP DST.ADDR DST.PORT，返回地址和占用的字节数
+func DecodeAddr(b []byte) (Addr, int, error) {
+	if len(b) < 1 {
+		return Addr{}, 0, io.ErrUnexpectedEOF
+	}
+
+	var a Addr
+	var n int
+	switch AddrType(b[0]) {
+	case AtypIPv4, AtypIPv6:
+		size := net.IPv4len
+		if AddrType(b[0]) == AtypIPv6 {
+			size = net.IPv6len
+		}
+		n = 1 + size + 2
+		if len(b) < n {
+			return Addr{}, 0, io.ErrUnexpectedEOF
+		}
+		a.IP = append(net.IP(nil), b[1:1+size]...)
+	case AtypDomain:
+		if len(b) < 2 {
+			return Addr{}, 0, io.ErrUnexpectedEOF
+		}
+		n = 1 + 1 + int(b[1]) + 2
+		if len(b) < n {
+			return Addr{}, 0, io.ErrUnexpectedEOF
+		}
+		a.Name = string(b[2 : n-2])
+	default:
+		return Addr{}, 0, E.ErrSOCKS5AddressTypeNotSupported
+	}
+	a.Port = int(binary.BigEndian.Uint16(b[n-2 : n]))
+	return a, n, nil
+}
+
+// AppendUDPHeader 追加 UDP 头 RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT，不分片
+func AppendUDPHeader(b []byte, a Addr) ([]byte, error) {
+	return a.Append(append(b, 0x00, 0x00, 0x00))
+}
+
+// ParseUDPHeader 解析 UDP 头，返回地址和头的长度，不支持分片
+func ParseUDPHeader(b []byte) (Addr, int, error) {
+	if len(b) < 4 {
+		return Addr{}, 0, io.ErrUnexpectedEOF
+	}
+	if b[2] != 0x00 {
+		return Addr{}, 0, E.ErrSOCKS5CommandNotSupported
+	}
+	a, n, err := DecodeAddr(b[3:])
+	if err != nil {
+		return Addr{}, 0, err
+	}
+	return a, 3 + n, nil
+}
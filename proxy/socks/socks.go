@@ -0,0 +1,308 @@
+// Package socks SOCKS4/4a 和 SOCKS5 的协议常量及地址编解码，拨号器和测试代理共用
+package socks
+
+import (
+	"fmt"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// SOCKS4请求格式:
+// +----+----+----+----+----+----+----+----+----+----+....+----+
+// | VN | CD | DSTPORT |      DSTIP        | USERID       |NULL|
+// +----+----+----+----+----+----+----+----+----+----+....+----+
+//    1    1      2              4           variable       1
+//
+// VN: SOCKS版本号(0x04)
+// CD: 命令码
+//     0x01 = CONNECT
+//     0x02 = BIND
+// DSTPORT: 目标端口(2字节)
+// DSTIP: 目标IP地址(4字节)
+// USERID: 用户ID字符串(可变长度)
+// NULL: 结束符(0x00)
+
+// SOCKS4响应格式:
+// +----+----+----+----+----+----+----+----+
+// | VN | CD | DSTPORT |      DSTIP        |
+// +----+----+----+----+----+----+----+----+
+//   1    1      2              4
+//
+// CD: 返回码
+//     0x5A = 请求granted
+//     0x5B = 请求rejected
+//     0x5C = 请求failed(无法连接到identd)
+//     0x5D = 请求failed(identd用户ID不匹配)
+
+// SOCKS5认证请求:
+// +----+----------+----------+
+// |VER | NMETHODS | METHODS  |
+// +----+----------+----------+
+// | 1  |    1     | 1 to 255 |
+// +----+----------+----------+
+//
+// VER: SOCKS版本号(0x05)
+// NMETHODS: 认证方法数量
+// METHODS: 认证方法列表
+//     0x00 = 无认证
+//     0x01 = GSSAPI
+//     0x02 = 用户名/密码
+//     0x03-0x7F = IANA分配
+//     0x80-0xFE = 私有方法
+
+// SOCKS5认证响应:
+// +----+--------+
+// |VER | METHOD |
+// +----+--------+
+// | 1  |   1    |
+// +----+--------+
+//
+// METHOD: 选择的认证方法
+//     0x00 = 无认证
+//     0xFF = 无可接受的方法
+
+// SOCKS5用户名/密码认证:
+// +----+------+----------+------+----------+
+// |VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+// +----+------+----------+------+----------+
+// | 1  |  1   | 1 to 255 |  1   | 1 to 255 |
+// +----+------+----------+------+----------+
+
+// SOCKS5认证响应:
+// +----+--------+
+// |VER | STATUS |
+// +----+--------+
+// | 1  |   1    |
+// +----+--------+
+//
+// STATUS: 0x00 = 成功, 其他 = 失败
+
+// SOCKS5请求:
+// +----+-----+-------+------+----------+----------+
+// |VER | CMD |  RSV  | ATYP | DST.ADDR | DST.PORT |
+// +----+-----+-------+------+----------+----------+
+// | 1  |  1  | X'00' |  1   | Variable |    2     |
+// +----+-----+-------+------+----------+----------+
+//
+// VER: SOCKS版本号(0x05)
+// CMD: 命令码
+//     0x01 = CONNECT
+//     0x02 = BIND
+//     0x03 = UDP ASSOCIATE
+// RSV: 保留字段(0x00)
+// ATYP: 地址类型
+//     0x01 = IPv4
+//     0x03 = 域名
+//     0x04 = IPv6
+// DST.ADDR: 目标地址(变长)
+// DST.PORT: 目标端口(2字节)
+
+// SOCKS5响应:
+// +----+-----+-------+------+----------+----------+
+// |VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
+// +----+-----+-------+------+----------+----------+
+// | 1  |  1  | X'00' |  1   | Variable |    2     |
+// +----+-----+-------+------+----------+----------+
+//
+// REP: 响应码
+//     0x00 = 成功
+//     0x01 = 常规SOCKS服务器连接失败
+//     0x02 = 现有规则不允许连接
+//     0x03 = 网络不可达
+//     0x04 = 主机不可达
+//     0x05 = 连接被拒绝
+//     0x06 = TTL过期
+//     0x07 = 命令不支持
+//     0x08 = 地址类型不支持
+//     0x09-0xFF = 未分配
+
+// 协议版本
+const (
+	Version4 byte = 0x04
+	Version5 byte = 0x05
+	// AuthVersion 用户名/密码认证子协商的版本
+	AuthVersion byte = 0x01
+)
+
+// AuthSucceeded 用户名/密码认证成功的 STATUS
+const AuthSucceeded byte = 0x00
+
+// Command 请求命令
+type Command byte
+
+const (
+	CmdConnect      Command = 0x01
+	CmdBind         Command = 0x02
+	CmdUDPAssociate Command = 0x03
+)
+
+func (c Command) String() string {
+	switch c {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	case CmdUDPAssociate:
+		return "UDP ASSOCIATE"
+	default:
+		return fmt.Sprintf("command(%#02x)", byte(c))
+	}
+}
+
+// Method SOCKS5 认证方法
+type Method byte
+
+const (
+	MethodNoAuth       Method = 0x00
+	MethodGSSAPI       Method = 0x01
+	MethodUserPass     Method = 0x02
+	MethodNoAcceptable Method = 0xFF
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodNoAuth:
+		return "no authentication"
+	case MethodGSSAPI:
+		return "GSSAPI"
+	case MethodUserPass:
+		return "username/password"
+	case MethodNoAcceptable:
+		return "no acceptable methods"
+	default:
+		return fmt.Sprintf("method(%#02x)", byte(m))
+	}
+}
+
+// AddrType SOCKS5 地址类型
+type AddrType byte
+
+const (
+	AtypIPv4   AddrType = 0x01
+	AtypDomain AddrType = 0x03
+	AtypIPv6   AddrType = 0x04
+)
+
+func (a AddrType) String() string {
+	switch a {
+	case AtypIPv4:
+		return "IPv4"
+	case AtypDomain:
+		return "domain"
+	case AtypIPv6:
+		return "IPv6"
+	default:
+		return fmt.Sprintf("atyp(%#02x)", byte(a))
+	}
+}
+
+// Reply SOCKS5 响应码 REP
+type Reply byte
+
+const (
+	ReplySucceeded            Reply = 0x00
+	ReplyGeneralFailure       Reply = 0x01
+	ReplyNotAllowed           Reply = 0x02
+	ReplyNetworkUnreachable   Reply = 0x03
+	ReplyHostUnreachable      Reply = 0x04
+	ReplyConnectionRefused    Reply = 0x05
+	ReplyTTLExpired           Reply = 0x06
+	ReplyCommandNotSupported  Reply = 0x07
+	ReplyAddrTypeNotSupported Reply = 0x08
+)
+
+func (r Reply) String() string {
+	switch r {
+	case ReplySucceeded:
+		return "succeeded"
+	case ReplyGeneralFailure:
+		return "general SOCKS server failure"
+	case ReplyNotAllowed:
+		return "connection not allowed by ruleset"
+	case ReplyNetworkUnreachable:
+		return "network unreachable"
+	case ReplyHostUnreachable:
+		return "host unreachable"
+	case ReplyConnectionRefused:
+		return "connection refused"
+	case ReplyTTLExpired:
+		return "TTL expired"
+	case ReplyCommandNotSupported:
+		return "command not supported"
+	case ReplyAddrTypeNotSupported:
+		return "address type not supported"
+	default:
+		return fmt.Sprintf("unassigned reply(%#02x)", byte(r))
+	}
+}
+
+// Err 返回响应码对应的错误，成功时返回 nil
+// 错误同时匹配 ErrSOCKSConnectFailed 和具体原因，如 ErrSOCKS5ConnectionRefused
+func (r Reply) Err() error {
+	var cause error
+	switch r {
+	case ReplySucceeded:
+		return nil
+	case ReplyGeneralFailure:
+		cause = E.ErrSOCKS5GeneralFailure
+	case ReplyNotAllowed:
+		cause = E.ErrSOCKS5NotAllowed
+	case ReplyNetworkUnreachable:
+		cause = E.ErrSOCKS5NetworkUnreachable
+	case ReplyHostUnreachable:
+		cause = E.ErrSOCKS5HostUnreachable
+	case ReplyConnectionRefused:
+		cause = E.ErrSOCKS5ConnectionRefused
+	case ReplyTTLExpired:
+		cause = E.ErrSOCKS5TTLExpired
+	case ReplyCommandNotSupported:
+		cause = E.ErrSOCKS5CommandNotSupported
+	case ReplyAddrTypeNotSupported:
+		cause = E.ErrSOCKS5AddressTypeNotSupported
+	default:
+		return fmt.Errorf("%w: %s", E.ErrSOCKSConnectFailed, r)
+	}
+	return fmt.Errorf("%w: %w", E.ErrSOCKSConnectFailed, cause)
+}
+
+// Reply4 SOCKS4 响应码 CD
+type Reply4 byte
+
+const (
+	Reply4Granted        Reply4 = 0x5A
+	Reply4Rejected       Reply4 = 0x5B
+	Reply4IdentdFailed   Reply4 = 0x5C
+	Reply4IdentdMismatch Reply4 = 0x5D
+)
+
+func (r Reply4) String() string {
+	switch r {
+	case Reply4Granted:
+		return "request granted"
+	case Reply4Rejected:
+		return "request rejected or failed"
+	case Reply4IdentdFailed:
+		return "cannot connect to identd"
+	case Reply4IdentdMismatch:
+		return "identd user mismatch"
+	default:
+		return fmt.Sprintf("unknown reply(%#02x)", byte(r))
+	}
+}
+
+// Err 返回响应码对应的错误，成功时返回 nil
+// identd 相关的失败同时匹配 ErrSOCKSAuthFailed，其余匹配 ErrSOCKSConnectFailed
+func (r Reply4) Err() error {
+	switch r {
+	case Reply4Granted:
+		return nil
+	case Reply4Rejected:
+		return fmt.Errorf("%w: %w", E.ErrSOCKSConnectFailed, E.ErrSOCKS4RequestRejected)
+	case Reply4IdentdFailed:
+		return fmt.Errorf("%w: %w", E.ErrSOCKSAuthFailed, E.ErrSOCKS4IdentdFailed)
+	case Reply4IdentdMismatch:
+		return fmt.Errorf("%w: %w", E.ErrSOCKSAuthFailed, E.ErrSOCKS4IdentdMismatch)
+	default:
+		return fmt.Errorf("%w: %s", E.ErrSOCKSConnectFailed, r)
+	}
+}
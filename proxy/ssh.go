@@ -0,0 +1,306 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHDialer 通过 SSH 跳板机的 direct-tcpip 通道拨号，目标主机名由跳板机解析
+// 所有连接共用一个 SSH 会话，会话断开后在下一次拨号时重连；只支持 TCP
+type SSHDialer struct {
+	addr         string
+	Config       *C.SSHConfig
+	metrics      metrics.Recorder
+	clientConfig *lazy[*ssh.ClientConfig] // 私钥和 known_hosts 在第一次建立会话时读取
+
+	mu         sync.Mutex
+	client     *ssh.Client
+	connecting *sshConnect // 正在建立的会话，其他拨号等待它而不是各自建立
+	closes     int         // Close 的次数，建立期间被 Close 的会话不再使用
+}
+
+// sshConnect 一次会话建立的结果，结束时关闭 done
+type sshConnect struct {
+	done     chan struct{}
+	client   *ssh.Client
+	err      error
+	canceled bool // 发起者的 ctx 结束导致失败，等待者需要自己重新建立
+}
+
+func createSSHDialer(proxyIP string, proxyPort int, config *C.SSHConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	return NewSSHDialer(hostport.Join(proxyIP, proxyPort), config, metrics)
+}
+
+// NewSSHDialer 创建 SSH 拨号器
+// SSH 会话在第一次拨号时建立，私钥和 known_hosts 也在这时读取，读取失败时拨号返回 ErrInvalidConfig
+func NewSSHDialer(addr string, config *C.SSHConfig, metrics metrics.Recorder) (*SSHDialer, error) {
+	if config == nil {
+		config = C.DefaultSSHConfig()
+	}
+	return &SSHDialer{
+		addr:         addr,
+		Config:       config,
+		metrics:      metrics,
+		clientConfig: newLazy(func() (*ssh.ClientConfig, error) { return sshClientConfig(config) }),
+	}, nil
+}
+
+// sshClientConfig 根据配置生成认证方式和主机密钥校验
+func sshClientConfig(config *C.SSHConfig) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		key, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "read ssh key: "+err.Error())
+		}
+		var signer ssh.Signer
+		if config.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(config.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "parse ssh key: "+err.Error())
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case config.KnownHostsFile != "":
+		callback, err := knownhosts.New(config.KnownHostsFile)
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "read known_hosts: "+err.Error())
+		}
+		hostKeyCallback = callback
+	case config.HostKeySHA256 != "":
+		want := config.HostKeySHA256
+		if !strings.HasPrefix(want, "SHA256:") {
+			want = "SHA256:" + want
+		}
+		hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != want {
+				return E.ErrSSHHostKeyMismatch
+			}
+			return nil
+		}
+	case config.InsecureIgnoreHostKey:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, E.WrapError(E.ErrInvalidConfig, "ssh host key verification is not configured")
+	}
+
+	return &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         config.Timeout,
+	}, nil
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *SSHDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 在共用的 SSH 会话上打开到 addr 的 direct-tcpip 通道
+func (d *SSHDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *SSHDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, E.ErrSSHNetworkNotSupported
+	}
+
+	// 会话断开但还没有被 watch 清除时，丢弃后重连一次
+	for attempt := 0; ; attempt++ {
+		client, err := d.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			return nil, E.WrapError(E.ErrSSHConnectFailed, openErr.Error())
+		}
+		if attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+		d.drop(client)
+	}
+}
+
+// session 返回共用的 SSH 会话，没有时建立新会话
+// 同一时间只有一个拨号建立会话，建立期间不持有 d.mu，其他拨号等待结果或自己的 ctx 结束
+func (d *SSHDialer) session(ctx context.Context) (*ssh.Client, error) {
+	for {
+		d.mu.Lock()
+		if client := d.client; client != nil {
+			d.mu.Unlock()
+			return client, nil
+		}
+		if pending := d.connecting; pending != nil {
+			d.mu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if pending.canceled {
+				continue
+			}
+			return pending.client, pending.err
+		}
+		pending := &sshConnect{done: make(chan struct{})}
+		d.connecting = pending
+		closes := d.closes
+		d.mu.Unlock()
+
+		client, err := d.connect(ctx)
+
+		d.mu.Lock()
+		d.connecting = nil
+		if err == nil && d.closes != closes {
+			client.Close()
+			client, err = nil, E.WrapError(net.ErrClosed, "ssh dialer closed")
+		}
+		if err == nil {
+			d.client = client
+			go d.watch(client)
+		}
+		d.mu.Unlock()
+
+		pending.client, pending.err, pending.canceled = client, err, err != nil && ctx.Err() != nil
+		close(pending.done)
+		return client, err
+	}
+}
+
+// connect 连接跳板机并完成 SSH 握手
+func (d *SSHDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	clientConfig, err := d.clientConfig.get()
+	if err != nil {
+		return nil, err
+	}
+
+	stageStart := time.Now()
+	dialer := &net.Dialer{Timeout: d.Config.Timeout, KeepAlive: d.Config.KeepAlive}
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, E.WrapError(E.ErrSSHProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	stageStart = time.Now()
+
+	deadline := handshakeDeadline(ctx, nil, false, 0, d.Config.Timeout)
+	guard := guardHandshake(ctx, conn, deadline)
+	defer guard.stop()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrSSHHandshakeFailed, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// watch 按 KeepAlive 发送 keepalive 请求，会话断开后清除，下一次拨号时重连
+func (d *SSHDialer) watch(client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	var tick <-chan time.Time
+	if d.Config.KeepAlive > 0 {
+		ticker := time.NewTicker(d.Config.KeepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			d.drop(client)
+			return
+		case <-tick:
+			// 请求失败说明会话已不可用，关闭后由 done 分支清理
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				client.Close()
+			}
+		}
+	}
+}
+
+// drop 关闭并清除会话，会话已被替换时只关闭
+func (d *SSHDialer) drop(client *ssh.Client) {
+	d.mu.Lock()
+	if d.client == client {
+		d.client = nil
+	}
+	d.mu.Unlock()
+	client.Close()
+}
+
+// Close 关闭共用的 SSH 会话，经过它的连接随之断开，之后的拨号会重新建立会话
+func (d *SSHDialer) Close() error {
+	d.mu.Lock()
+	client := d.client
+	d.client = nil
+	d.closes++
+	d.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/discovery"
+	"github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// Startup 按 StartupPolicy 处理启动时代理不可用的情况，hook 在替换函数前调用
+//   - lazy: 不检查，拨号时返回错误
+//   - fail_fast: 探测代理，不可用时返回包含代理地址和原因的错误
+//   - direct_until_healthy: 代理不可用时先直连，后台按 StartupProbeInterval 探测，通过后开始走代理，ctx 结束时停止探测
+func (pm *ProxyManager) Startup(ctx context.Context) error {
+	config := pm.Config
+	if config == nil || !config.Enable {
+		return nil
+	}
+
+	switch config.StartupPolicy {
+	case C.StartupFailFast:
+		if err := pm.probe(ctx); err != nil {
+			return errors.WrapError(errors.ErrProxyDialFailed,
+				fmt.Sprintf("startup: %s proxy %s unavailable: %v", config.ProxyType, config.GetProxyAddr(), err))
+		}
+	case C.StartupDirectUntilHealthy:
+		if pm.probe(ctx) == nil {
+			return nil
+		}
+		atomic.StoreInt32(&pm.waiting, 1)
+		go pm.waitHealthy(ctx, config.StartupProbeInterval)
+	}
+	return nil
+}
+
+// WaitingForProxy 是否处于 direct_until_healthy 的直连阶段
+func (pm *ProxyManager) WaitingForProxy() bool {
+	return atomic.LoadInt32(&pm.waiting) == 1
+}
+
+// waitHealthy 定期探测代理，通过后结束直连阶段
+func (pm *ProxyManager) waitHealthy(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = C.DefaultStartupProbeInterval
+	}
+	ticker := pm.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for pm.WaitingForProxy() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if pm.probe(ctx) == nil {
+				atomic.StoreInt32(&pm.waiting, 0)
+			}
+		}
+	}
+}
+
+// probe 检查代理是否在监听并且使用对应的代理协议
+func (pm *ProxyManager) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout(pm.Config))
+	defer cancel()
+	return probeProxy(ctx, pm.Config, pm.GetDialer())
+}
+
+// probeTimeout 返回探测代理的超时，使用代理类型的握手超时，未设置时为 discovery.DefaultProbeTimeout
+func probeTimeout(config *C.Config) time.Duration {
+	timeout := discovery.DefaultProbeTimeout
+	switch config.ProxyType {
+	case C.HTTP, C.HTTPS, C.HTTP2, C.HTTP3:
+		if config.HTTPConfig != nil && config.HTTPConfig.Timeout > 0 {
+			timeout = config.HTTPConfig.Timeout
+		}
+	case C.WS, C.WSS:
+		if config.WSConfig != nil && config.WSConfig.Timeout > 0 {
+			timeout = config.WSConfig.Timeout
+		}
+	case C.GRPC:
+		if config.GRPCConfig != nil && config.GRPCConfig.Timeout > 0 {
+			timeout = config.GRPCConfig.Timeout
+		}
+	case C.HYSTERIA2:
+		if config.Hysteria2Config != nil && config.Hysteria2Config.Timeout > 0 {
+			timeout = config.Hysteria2Config.Timeout
+		}
+	default:
+		if config.SOCKSConfig != nil && config.SOCKSConfig.Timeout > 0 {
+			timeout = config.SOCKSConfig.Timeout
+		}
+	}
+	return timeout
+}
+
+// probeProxy 检查 config 配置的代理是否在监听并且使用对应的代理协议，dialer 是连接该代理的拨号器
+func probeProxy(ctx context.Context, config *C.Config, dialer ProxyDialer) error {
+	// Hysteria2 只监听 UDP，没有 TCP 端口可探测，改为建立并认证 QUIC 连接
+	if d, ok := dialer.(*Hysteria2Dialer); ok {
+		_, err := d.getSession(ctx)
+		return err
+	}
+	proxyType := config.ProxyType
+	// TLS 上的 SOCKS 不能发送明文方法协商，按 socks5s 只检查端口可连接
+	if d, ok := dialer.(*SocksDialer); ok && d.tlsConfig != nil {
+		proxyType = C.SOCKS5S
+	}
+	return discovery.Probe(ctx, discovery.Candidate{
+		ProxyType: proxyType,
+		ProxyIP:   config.ProxyIP,
+		ProxyPort: config.ProxyPort,
+	})
+}
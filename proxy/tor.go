@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// torIsolationUser 不按目标隔离时流隔离凭证使用的用户名
+const torIsolationUser = "gohookproxy"
+
+// TorDialer 通过 Tor 的 SOCKS 端口拨号，主机名由 Tor 解析
+// Tor 默认按 SOCKS 凭证隔离线路(IsolateSOCKSAuth)，按目标隔离和切换线路都通过更换凭证实现
+type TorDialer struct {
+	socks  *SocksDialer
+	Config *C.TorConfig
+
+	generation uint64 // RotateCircuit 的次数，写入流隔离凭证
+}
+
+func createTorDialer(proxyIP string, proxyPort int, socksConfig *C.SOCKSConfig, torConfig *C.TorConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	proxyURL := hostport.Join(proxyIP, proxyPort)
+	if _, ok := C.UnixSocketPath(proxyIP); ok {
+		proxyURL = proxyIP
+	}
+	return NewTorDialer(proxyURL, socksConfig, torConfig, metrics), nil
+}
+
+// NewTorDialer 创建 Tor 拨号器，proxyURL 为 SOCKS 端口地址或 unix:路径
+// Tor 不转发 UDP，UDP 拨号返回 ErrSOCKSNetworkNotSupported
+func NewTorDialer(proxyURL string, socksConfig *C.SOCKSConfig, torConfig *C.TorConfig, metrics metrics.Recorder) *TorDialer {
+	if socksConfig != nil {
+		cfg := *socksConfig
+		cfg.EnableUDP = false
+		socksConfig = &cfg
+	}
+	if torConfig == nil {
+		torConfig = C.DefaultTorConfig()
+	}
+	return &TorDialer{
+		socks:  NewSocksDialer(proxyURL, C.SOCKS5H, socksConfig, metrics),
+		Config: torConfig,
+	}
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *TorDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 通过 Tor 连接 addr，ctx 中通过 WithCredentials 指定的凭证优先于流隔离凭证
+func (d *TorDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.socks.DialContext(d.isolate(ctx, addr), network, addr)
+}
+
+// isolate 为本次拨号选择流隔离凭证
+// 按目标隔离时用户名为目标主机，密码随 RotateCircuit 变化，凭证不同的连接不会共用线路
+func (d *TorDialer) isolate(ctx context.Context, addr string) context.Context {
+	if _, ok := CredentialsFromContext(ctx); ok {
+		return ctx
+	}
+	generation := atomic.LoadUint64(&d.generation)
+	if !d.Config.IsolateDestination && generation == 0 {
+		return ctx
+	}
+	user := torIsolationUser
+	if d.Config.IsolateDestination {
+		user = hostport.CanonicalHost(hostport.Host(addr))
+	}
+	return WithCredentials(ctx, Credentials{User: user, Pass: "circuit-" + strconv.FormatUint(generation, 10)})
+}
+
+// RotateCircuit 让之后的连接使用新线路，已建立的连接不受影响
+// 配置了控制端口时同时发送 SIGNAL NEWNYM，Tor 会限制 NEWNYM 的频率
+func (d *TorDialer) RotateCircuit(ctx context.Context) error {
+	atomic.AddUint64(&d.generation, 1)
+	if d.Config.ControlAddr == "" {
+		return nil
+	}
+	return d.signalNewnym(ctx)
+}
+
+// signalNewnym 连接控制端口，认证后发送 SIGNAL NEWNYM
+func (d *TorDialer) signalNewnym(ctx context.Context) error {
+	auth, err := d.authenticateCommand()
+	if err != nil {
+		return err
+	}
+
+	network, addr := "tcp", d.Config.ControlAddr
+	if path, ok := C.UnixSocketPath(addr); ok {
+		network, addr = "unix", path
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return E.WrapError(E.ErrTorControl, err.Error())
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tc := textproto.NewConn(conn)
+	for _, cmd := range []string{auth, "SIGNAL NEWNYM"} {
+		if err := torCommand(tc, cmd); err != nil {
+			if ctxErr := contextError(ctx); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+	}
+	tc.Cmd("QUIT")
+	return nil
+}
+
+// authenticateCommand 按配置生成 AUTHENTICATE 命令: 密码、cookie 或无认证
+func (d *TorDialer) authenticateCommand() (string, error) {
+	switch {
+	case d.Config.ControlPassword != "":
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		return `AUTHENTICATE "` + r.Replace(d.Config.ControlPassword) + `"`, nil
+	case d.Config.CookieFile != "":
+		cookie, err := os.ReadFile(d.Config.CookieFile)
+		if err != nil {
+			return "", E.WrapError(E.ErrTorControl, "read cookie: "+err.Error())
+		}
+		return "AUTHENTICATE " + hex.EncodeToString(cookie), nil
+	default:
+		return "AUTHENTICATE", nil
+	}
+}
+
+// torCommand 发送一条控制命令，响应不是 250 时返回错误
+func torCommand(tc *textproto.Conn, cmd string) error {
+	if _, err := tc.Cmd("%s", cmd); err != nil {
+		return E.WrapError(E.ErrTorControl, err.Error())
+	}
+	if _, _, err := tc.ReadResponse(250); err != nil {
+		return E.WrapError(E.ErrTorControl, err.Error())
+	}
+	return nil
+}
+
+// RotateCircuit 让之后的 Tor 连接使用新线路，代理类型不是 tor 时返回 ErrTorNotConfigured
+func (pm *ProxyManager) RotateCircuit() error {
+	d, ok := pm.GetDialer().(*TorDialer)
+	if !ok {
+		return E.ErrTorNotConfigured
+	}
+	timeout := d.Config.Timeout
+	if timeout <= 0 {
+		timeout = C.DefaultTorControlTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.RotateCircuit(ctx)
+}
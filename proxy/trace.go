@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// traceIDKey context 中保存 trace ID 的键
+type traceIDKey struct{}
+
+// connSeq 拨号序号，作为 exemplar 的 conn_id
+var connSeq uint64
+
+// WithTraceID 为经过 ctx 的拨号附加 trace ID，记录到拨号延迟的 exemplar 中
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext 返回 ctx 中的 trace ID，没有时返回空字符串
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// recordReady 记录目标就绪耗时，exemplar 携带本次拨号的序号、trace ID 和提示的目标地址
+func recordReady(ctx context.Context, r metrics.Recorder, start time.Time) {
+	labels := map[string]string{"conn_id": strconv.FormatUint(atomic.AddUint64(&connSeq, 1), 10)}
+	if id := TraceIDFromContext(ctx); id != "" {
+		labels["trace_id"] = id
+	}
+	if hint, ok := IPHintFromContext(ctx); ok {
+		labels["expected_ip"] = hint.IP.String()
+	}
+	recordStageExemplar(r, metrics.StageTargetReady, time.Since(start), labels)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+)
+
+// transportSetter 在 TCP 连接上运行代理协议的拨号器实现的接口，拨号时用传输插件包装到代理的连接
+type transportSetter interface {
+	setTransport(t transport.Transport) error
+}
+
+// applyTransport 按配置创建传输插件并交给拨号器，未启用代理或没有配置插件时不做任何事
+func applyTransport(dialer ProxyDialer, config *C.Config) error {
+	if !config.Enable || config.Transport == nil {
+		return nil
+	}
+	t, err := transport.New(config.Transport.Name, config.Transport.Options)
+	if err != nil {
+		return err
+	}
+	ts, ok := dialer.(transportSetter)
+	if !ok {
+		return E.WrapError(E.ErrTransportUnsupported, string(config.ProxyType))
+	}
+	return ts.setTransport(t)
+}
+
+// wrapTransport 用传输插件包装到代理的连接，t 为 nil 时原样返回 conn，失败时关闭 conn
+// 插件握手期间 ctx 结束时打断阻塞的读写
+func wrapTransport(ctx context.Context, t transport.Transport, conn net.Conn) (net.Conn, error) {
+	if t == nil {
+		return conn, nil
+	}
+	guard := guardHandshake(ctx, conn, time.Time{})
+	wrapped, err := t.Wrap(ctx, conn)
+	if err != nil {
+		guard.stop()
+		conn.Close()
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("%w: %w", E.ErrTransportHandshake, err)
+	}
+	if err := guard.done(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// setTransport 实现 transportSetter 接口，HTTP3 代理运行在 QUIC 上，不支持传输插件
+func (d *HTTPProxyDialer) setTransport(t transport.Transport) error {
+	if d.proxyType == C.HTTP3 {
+		return E.WrapError(E.ErrTransportUnsupported, string(d.proxyType))
+	}
+	d.obfs = t
+	return nil
+}
+
+// setTransport 实现 transportSetter 接口，Unix 域套接字上的连接同样经过插件
+func (d *SocksDialer) setTransport(t transport.Transport) error {
+	d.obfs = t
+	return nil
+}
+
+// setTransport 实现 transportSetter 接口
+func (d *VMessDialer) setTransport(t transport.Transport) error {
+	d.obfs = t
+	return nil
+}
+
+// setTransport 实现 transportSetter 接口
+func (d *WSDialer) setTransport(t transport.Transport) error {
+	d.obfs = t
+	return nil
+}
+
+// setTransport 实现 transportSetter 接口
+func (d *GRPCDialer) setTransport(t transport.Transport) error {
+	d.obfs = t
+	return nil
+}
@@ -0,0 +1,88 @@
+// Package transport 到代理的连接上的混淆传输插件，obfs4、tls-obfs 等混淆层实现 Transport 后
+// 按名称注册，在配置的 transport 中选择，不需要内置到拨号器中
+//
+// 插件位于 TCP 连接和代理协议之间:
+//
+//	代理协议(HTTP CONNECT、SOCKS、VMess 等) -> [TLS] -> Transport -> TCP
+//
+// 插件通常在 init 中注册:
+//
+//	func init() {
+//		transport.Register("xor", func(options map[string]string) (transport.Transport, error) {
+//			return newXOR(options["key"])
+//		})
+//	}
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// Transport 混淆传输插件，包装到代理的连接
+// 返回的连接建议实现 Unwrap() net.Conn 返回被包装的连接，以便 DSCP 标记等功能找到底层套接字
+type Transport interface {
+	// Wrap 客户端在到代理的连接建立后、代理协议握手前调用，返回的连接承载代理协议
+	Wrap(ctx context.Context, conn net.Conn) (net.Conn, error)
+	// Unwrap 服务端在接受连接后调用，还原出客户端发送的代理协议
+	Unwrap(ctx context.Context, conn net.Conn) (net.Conn, error)
+}
+
+// Factory 按配置中的选项创建插件，选项的含义由插件定义
+type Factory func(options map[string]string) (Transport, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register 注册名为 name 的插件，name 为空、factory 为 nil 或重复注册时 panic
+func Register(name string, factory Factory) {
+	if name == "" || factory == nil {
+		panic("transport: Register with empty name or nil factory")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := factories[name]; dup {
+		panic("transport: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Lookup 返回名为 name 的插件的 Factory
+func Lookup(name string) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names 返回已注册的插件名称，按字母排序
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// New 用选项创建名为 name 的插件，未注册时返回 ErrTransportNotRegistered
+func New(name string, options map[string]string) (Transport, error) {
+	factory, ok := Lookup(name)
+	if !ok {
+		return nil, E.WrapError(E.ErrTransportNotRegistered, name)
+	}
+	t, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("transport %s: %w", name, err)
+	}
+	return t, nil
+}
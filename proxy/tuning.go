@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+const (
+	// lowLatencyWriteBuffer low-latency 预设的发送缓冲区，排队的数据少，新的小写入不会等在大块数据后面
+	lowLatencyWriteBuffer = 64 << 10
+	// throughputBuffer throughput 预设的收发缓冲区，足够覆盖高 BDP 链路
+	throughputBuffer = 4 << 20
+)
+
+// tcpTuner *net.TCPConn 上调优使用的方法
+type tcpTuner interface {
+	SetNoDelay(noDelay bool) error
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// ApplySocketTuning 按预设调整连接最内层的 TCP 套接字，经过代理时是到代理的连接
+// 多路复用的隧道(HTTP2/HTTP3 流、gRPC)共享到代理的连接，找不到 TCP 套接字时不调整；调优只影响性能，设置失败时忽略
+func ApplySocketTuning(conn net.Conn, tuning C.SocketTuning) {
+	if tuning == "" {
+		return
+	}
+	tc, ok := Unwrap[tcpTuner](conn)
+	if !ok {
+		return
+	}
+	switch tuning {
+	case C.TuningLowLatency:
+		tc.SetNoDelay(true)
+		tc.SetWriteBuffer(lowLatencyWriteBuffer)
+	case C.TuningThroughput:
+		tc.SetNoDelay(false)
+		tc.SetReadBuffer(throughputBuffer)
+		tc.SetWriteBuffer(throughputBuffer)
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"fmt"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// udpProxy UDP 流量单独使用的代理，如 TCP 走不能转发 UDP 的 HTTP 代理时 UDP 走 SOCKS5 代理
+type udpProxy struct {
+	dialer ProxyDialer // 实现 PacketDialer
+	addr   string      // 代理地址，用于负缓存
+}
+
+// newUDPProxy 根据 UDPProxy 创建 UDP 代理的拨号器，未配置或未启用代理时返回 nil
+func newUDPProxy(config *C.Config, pm *ProxyManager) (*udpProxy, error) {
+	if config.UDPProxy == nil || !config.Enable {
+		return nil, nil
+	}
+	udpConfig := config.UDPProxy.ProxyConfig(config)
+	dialer, err := createProxyDialer(udpConfig, pm.dialRecorder())
+	if err != nil {
+		return nil, errors.WrapError(err, "udp proxy")
+	}
+	if _, ok := dialer.(PacketDialer); !ok {
+		closeDialer(dialer)
+		return nil, errors.WrapError(errors.ErrUnsupportedProxy, fmt.Sprintf("udp proxy: %s proxy does not support udp", udpConfig.ProxyType))
+	}
+	pm.bindDialer(dialer)
+	return &udpProxy{dialer: dialer, addr: udpConfig.GetProxyAddr()}, nil
+}
+
+// close 关闭 UDP 代理持有的共享会话
+func (u *udpProxy) close() {
+	if u != nil {
+		closeDialer(u.dialer)
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import "net"
+
+// maxUnwrapDepth Unwrap 链的最大层数，防止实现错误的包装形成环
+const maxUnwrapDepth = 32
+
+// Unwrapper 包装其他连接的连接，Unwrap 返回被包装的下一层
+//
+// 包内的计数、配额、流量上限等包装连接都实现了 Unwrapper，
+// 外部的包装连接实现它后也可以被 Unwrap 穿过
+type Unwrapper interface {
+	Unwrap() net.Conn
+}
+
+// Unwrap 从 conn 开始沿包装链逐层查找第一个类型为 T 的连接，包括 conn 本身
+// 每层通过 Unwrap() 或 *tls.Conn 的 NetConn() 取得下一层，例如:
+//
+//	tlsConn, ok := proxy.Unwrap[*tls.Conn](conn)
+//	pc, ok := proxy.Unwrap[net.PacketConn](conn)
+func Unwrap[T any](conn net.Conn) (T, bool) {
+	for i := 0; conn != nil && i < maxUnwrapDepth; i++ {
+		if t, ok := conn.(T); ok {
+			return t, true
+		}
+		conn = unwrapOnce(conn)
+	}
+	var zero T
+	return zero, false
+}
+
+// UnwrapAll 返回最内层的连接，通常是到代理或目标的 *net.TCPConn
+func UnwrapAll(conn net.Conn) net.Conn {
+	for i := 0; i < maxUnwrapDepth; i++ {
+		next := unwrapOnce(conn)
+		if next == nil {
+			return conn
+		}
+		conn = next
+	}
+	return conn
+}
+
+// unwrapOnce 返回下一层连接，conn 不是包装连接时返回 nil
+func unwrapOnce(conn net.Conn) net.Conn {
+	switch c := conn.(type) {
+	case Unwrapper:
+		return c.Unwrap()
+	case interface{ NetConn() net.Conn }:
+		return c.NetConn()
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/proxy/vmess"
+)
+
+// VMessDialer VMess 代理拨号器
+// 请求头随拨号发出，服务端的响应头在第一次读取时校验，UUID 错误表现为读取失败
+type VMessDialer struct {
+	proxyURL string
+	id       vmess.UUID
+	security vmess.Security
+	Config   *C.VMessConfig
+	metrics  metrics.Recorder
+
+	allowUDP bool                // HookUDP 开启时通过 VMess 的 UDP 命令转发
+	obfs     transport.Transport // 到代理的连接使用的传输插件，为 nil 时不使用
+}
+
+func createVMessDialer(proxyIP string, proxyPort int, hookUDP bool, config *C.VMessConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	dialer, err := NewVMessDialer(hostport.Join(proxyIP, proxyPort), config, metrics)
+	if err != nil {
+		return nil, err
+	}
+	dialer.allowUDP = hookUDP
+	return dialer, nil
+}
+
+// NewVMessDialer 创建 VMess 拨号器，UUID 或加密方式无效时返回错误
+func NewVMessDialer(proxyURL string, config *C.VMessConfig, metrics metrics.Recorder) (*VMessDialer, error) {
+	if config == nil {
+		return nil, E.ErrVMessInvalidUUID
+	}
+	id, err := vmess.ParseUUID(config.UUID)
+	if err != nil {
+		return nil, err
+	}
+	security, err := vmess.ParseSecurity(config.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VMessDialer{
+		proxyURL: proxyURL,
+		id:       id,
+		security: security,
+		Config:   config,
+		metrics:  metrics,
+	}, nil
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *VMessDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 通过 VMess 服务端连接 addr，UDP 网络使用 VMess 的 UDP 命令
+func (d *VMessDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		// ctx 结束打断的握手按 ctx 的错误返回
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *VMessDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var cmd vmess.Command
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		cmd = vmess.CmdTCP
+	case "udp", "udp4", "udp6":
+		if !d.allowUDP {
+			return nil, E.ErrVMessNetworkNotSupported
+		}
+		cmd = vmess.CmdUDP
+	default:
+		return nil, E.ErrVMessNetworkNotSupported
+	}
+
+	header, err := vmess.NewRequestHeader(cmd, d.security, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stageStart := time.Now()
+	dialer := &net.Dialer{Timeout: d.Config.Timeout, KeepAlive: d.Config.KeepAlive}
+	proxyConn, err := dialer.Dial("tcp", d.proxyURL)
+	if err != nil {
+		return nil, E.ErrVMessProxyUnreachable
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, proxyConn)
+	if proxyConn, err = wrapTransport(ctx, d.obfs, proxyConn); err != nil {
+		return nil, err
+	}
+	stageStart = time.Now()
+
+	deadline := handshakeDeadline(ctx, nil, false, 0, d.Config.Timeout)
+	guard := guardHandshake(ctx, proxyConn, deadline)
+	defer guard.stop()
+
+	conn, err := vmess.Client(proxyConn, d.id, header)
+	if err != nil {
+		proxyConn.Close()
+		return nil, err
+	}
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		proxyConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,276 @@
+package vmess
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// MaxPayloadSize 每个数据块的最大负载，与 V2Ray 的 8KB 缓冲区一致
+// UDP 的每个数据报占一个块，超过的数据报无法发送
+const MaxPayloadSize = 8192 - 2 - 16
+
+// chunkWriter 按块加密写出数据
+type chunkWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD // SecurityNone 时为 nil
+	nonce [16]byte    // 前 2 字节为块序号，取前 12 字节作为 nonce
+	count uint16
+	buf   []byte
+}
+
+// chunkReader 按块读取并解密数据
+type chunkReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	nonce [16]byte
+	count uint16
+	buf   []byte
+}
+
+// newBodyAEAD 返回数据加密使用的 AEAD，SecurityNone 时为 nil
+func newBodyAEAD(security Security, key []byte) (cipher.AEAD, error) {
+	switch security {
+	case SecurityAES128GCM:
+		return newGCM(key)
+	case SecurityNone:
+		return nil, nil
+	default:
+		return nil, E.ErrVMessSecurityNotSupported
+	}
+}
+
+func newChunkWriter(w io.Writer, security Security, key, iv []byte) (*chunkWriter, error) {
+	aead, err := newBodyAEAD(security, key)
+	if err != nil {
+		return nil, err
+	}
+	cw := &chunkWriter{w: w, aead: aead}
+	copy(cw.nonce[:], iv)
+	return cw, nil
+}
+
+func newChunkReader(r io.Reader, security Security, key, iv []byte) (*chunkReader, error) {
+	aead, err := newBodyAEAD(security, key)
+	if err != nil {
+		return nil, err
+	}
+	cr := &chunkReader{r: r, aead: aead}
+	copy(cr.nonce[:], iv)
+	return cr, nil
+}
+
+// writeChunk 写出一个块，prefix 在块之前一起写出，p 为空时写出结束块
+func (cw *chunkWriter) writeChunk(prefix, p []byte) error {
+	size := len(p)
+	if cw.aead != nil {
+		size += cw.aead.Overhead()
+	}
+	b := append(append(cw.buf[:0], prefix...), byte(size>>8), byte(size))
+	if cw.aead != nil {
+		binary.BigEndian.PutUint16(cw.nonce[:2], cw.count)
+		cw.count++
+		b = cw.aead.Seal(b, cw.nonce[:12], p, nil)
+	} else {
+		b = append(b, p...)
+	}
+	cw.buf = b
+	_, err := cw.w.Write(b)
+	return err
+}
+
+// readChunk 读取一个块，读到结束块时返回 io.EOF
+// 返回的切片在下一次读取前有效
+func (cr *chunkReader) readChunk() ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(cr.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if cap(cr.buf) < n {
+		cr.buf = make([]byte, n)
+	}
+	b := cr.buf[:n]
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if cr.aead != nil {
+		if n < cr.aead.Overhead() {
+			return nil, E.ErrVMessAuthFailed
+		}
+		binary.BigEndian.PutUint16(cr.nonce[:2], cr.count)
+		cr.count++
+		var err error
+		if b, err = cr.aead.Open(b[:0], cr.nonce[:12], b, nil); err != nil {
+			return nil, E.ErrVMessAuthFailed
+		}
+	}
+	if len(b) == 0 {
+		return nil, io.EOF
+	}
+	return b, nil
+}
+
+// Conn 已完成请求头交换的 VMess 连接，Read/Write 只收发负载
+// CmdUDP 时每次 Write 发送一个数据报，每次 Read 返回一个数据报
+type Conn struct {
+	net.Conn
+	packet bool
+
+	rmu     sync.Mutex
+	r       *chunkReader
+	pending []byte
+	// openReader 客户端在第一次读取时校验响应头并创建 r
+	openReader func() (*chunkReader, error)
+
+	wmu sync.Mutex
+	w   *chunkWriter
+	// prefix 服务端在第一次写出时发送的响应头
+	prefix []byte
+}
+
+// Client 向已连接的 VMess 服务端发送请求头，响应头在第一次读取时校验
+func Client(conn net.Conn, id UUID, h *RequestHeader) (*Conn, error) {
+	plain, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	header, err := SealHeader(id.CmdKey(), plain, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newChunkWriter(conn, h.Security, h.BodyKey[:], h.BodyIV[:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(header); err != nil {
+		return nil, err
+	}
+
+	c := &Conn{Conn: conn, packet: h.Command == CmdUDP, w: w}
+	c.openReader = func() (*chunkReader, error) {
+		if err := OpenResponseHeader(conn, h); err != nil {
+			return nil, err
+		}
+		return newChunkReader(conn, h.Security, h.ResponseKey(), h.ResponseIV())
+	}
+	return c, nil
+}
+
+// Server 读取并校验客户端的请求头，响应头在第一次写出时发送
+func Server(conn net.Conn, id UUID) (*Conn, *RequestHeader, error) {
+	plain, err := OpenHeader(conn, id.CmdKey(), time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := ParseRequestHeader(plain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := newChunkReader(conn, h.Security, h.BodyKey[:], h.BodyIV[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := newChunkWriter(conn, h.Security, h.ResponseKey(), h.ResponseIV())
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, err := SealResponseHeader(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Conn{Conn: conn, packet: h.Command == CmdUDP, r: r, w: w, prefix: prefix}, h, nil
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.r == nil {
+		r, err := c.openReader()
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+
+	if len(c.pending) == 0 {
+		b, err := c.r.readChunk()
+		if err != nil {
+			return 0, err
+		}
+		if c.packet {
+			// 缓冲区不够时截断数据报
+			return copy(p, b), nil
+		}
+		c.pending = b
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.packet {
+		// 空块表示结束，零长度数据报不发送
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if len(p) > MaxPayloadSize {
+			return 0, E.ErrVMessPacketTooLarge
+		}
+		if err := c.writeChunk(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxPayloadSize {
+			chunk = chunk[:MaxPayloadSize]
+		}
+		if err := c.writeChunk(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// writeChunk 写出一个块，服务端的第一个块之前带上响应头
+func (c *Conn) writeChunk(p []byte) error {
+	prefix := c.prefix
+	c.prefix = nil
+	return c.w.writeChunk(prefix, p)
+}
+
+// CloseWrite 发送结束块并关闭写方向，底层连接支持半关闭时一并关闭
+func (c *Conn) CloseWrite() error {
+	c.wmu.Lock()
+	err := c.writeChunk(nil)
+	c.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Unwrap 返回承载 VMess 的底层连接
+func (c *Conn) Unwrap() net.Conn { return c.Conn }
@@ -0,0 +1,297 @@
+package vmess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"time"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+// MaxTimeSkew AuthID 中的时间戳与服务端时间允许的最大偏差
+const MaxTimeSkew = 120 * time.Second
+
+// RequestHeader 请求头
+type RequestHeader struct {
+	BodyIV       [16]byte
+	BodyKey      [16]byte
+	ResponseAuth byte // 响应头中回显的校验字节
+	Option       Option
+	Security     Security
+	Command      Command
+	Host         string // IP 或域名
+	Port         int
+}
+
+// NewRequestHeader 创建使用随机数据密钥的请求头，addr 为 host:port
+func NewRequestHeader(cmd Command, security Security, addr string) (*RequestHeader, error) {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &RequestHeader{
+		Option:   OptionChunkStream,
+		Security: security,
+		Command:  cmd,
+		Host:     host,
+		Port:     port,
+	}
+	var random [33]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	copy(h.BodyIV[:], random[:16])
+	copy(h.BodyKey[:], random[16:32])
+	h.ResponseAuth = random[32]
+	return h, nil
+}
+
+// Target 返回 host:port 形式的目标地址
+func (h *RequestHeader) Target() string {
+	return hostport.Join(h.Host, h.Port)
+}
+
+// ResponseKey 返回响应数据的密钥
+func (h *RequestHeader) ResponseKey() []byte {
+	sum := sha256.Sum256(h.BodyKey[:])
+	return sum[:16]
+}
+
+// ResponseIV 返回响应数据的 IV
+func (h *RequestHeader) ResponseIV() []byte {
+	sum := sha256.Sum256(h.BodyIV[:])
+	return sum[:16]
+}
+
+// Marshal 编码请求头明文，包含随机填充和 FNV1a 校验
+func (h *RequestHeader) Marshal() ([]byte, error) {
+	var padding [1]byte
+	if _, err := rand.Read(padding[:]); err != nil {
+		return nil, err
+	}
+	p := int(padding[0] & 0x0f)
+
+	b := make([]byte, 0, 64+len(h.Host)+p)
+	b = append(b, Version)
+	b = append(b, h.BodyIV[:]...)
+	b = append(b, h.BodyKey[:]...)
+	b = append(b, h.ResponseAuth, byte(h.Option), byte(p<<4)|byte(h.Security), 0x00, byte(h.Command))
+	b = binary.BigEndian.AppendUint16(b, uint16(h.Port))
+
+	if ip := hostport.ParseIP(h.Host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, atypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, atypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(h.Host) > 255 {
+			return nil, E.ErrVMessHostnameTooLong
+		}
+		b = append(b, atypDomain, byte(len(h.Host)))
+		b = append(b, h.Host...)
+	}
+
+	pad := make([]byte, p)
+	if _, err := rand.Read(pad); err != nil {
+		return nil, err
+	}
+	b = append(b, pad...)
+
+	sum := fnv.New32a()
+	sum.Write(b)
+	return sum.Sum(b), nil
+}
+
+// ParseRequestHeader 解析请求头明文并校验 FNV1a
+func ParseRequestHeader(b []byte) (*RequestHeader, error) {
+	// 固定部分到 ATYP 为 41 字节，加上 4 字节校验
+	if len(b) < 45 {
+		return nil, E.ErrVMessAuthFailed
+	}
+	body, check := b[:len(b)-4], b[len(b)-4:]
+	sum := fnv.New32a()
+	sum.Write(body)
+	if binary.BigEndian.Uint32(check) != sum.Sum32() || body[0] != Version {
+		return nil, E.ErrVMessAuthFailed
+	}
+
+	h := &RequestHeader{}
+	copy(h.BodyIV[:], body[1:17])
+	copy(h.BodyKey[:], body[17:33])
+	h.ResponseAuth = body[33]
+	h.Option = Option(body[34])
+	p := int(body[35] >> 4)
+	h.Security = Security(body[35] & 0x0f)
+	h.Command = Command(body[37])
+	h.Port = int(binary.BigEndian.Uint16(body[38:40]))
+
+	rest := body[41:]
+	switch body[40] {
+	case atypIPv4:
+		if len(rest) < 4 {
+			return nil, E.ErrVMessAuthFailed
+		}
+		h.Host = net.IP(rest[:4]).String()
+		rest = rest[4:]
+	case atypIPv6:
+		if len(rest) < 16 {
+			return nil, E.ErrVMessAuthFailed
+		}
+		h.Host = net.IP(rest[:16]).String()
+		rest = rest[16:]
+	case atypDomain:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, E.ErrVMessAuthFailed
+		}
+		h.Host = string(rest[1 : 1+int(rest[0])])
+		rest = rest[1+int(rest[0]):]
+	default:
+		return nil, E.ErrVMessAddressTypeUnsupported
+	}
+	if len(rest) != p {
+		return nil, E.ErrVMessAuthFailed
+	}
+	return h, nil
+}
+
+// SealHeader 用 cmdKey 加密请求头明文，返回完整的请求头
+func SealHeader(cmdKey, header []byte, now time.Time) ([]byte, error) {
+	var authID [16]byte
+	var nonce [8]byte
+	binary.BigEndian.PutUint64(authID[:8], uint64(now.Unix()))
+	if _, err := rand.Read(authID[8:12]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(authID[12:], crc32.ChecksumIEEE(authID[:12]))
+	block, err := aes.NewCipher(KDF16(cmdKey, kdfAuthIDKey))
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(authID[:], authID[:])
+
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	id, n := string(authID[:]), string(nonce[:])
+
+	out := append(make([]byte, 0, 16+18+8+len(header)+16), authID[:]...)
+	length := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+	if out, err = seal(out, KDF16(cmdKey, kdfLengthKey, id, n), KDF(cmdKey, kdfLengthIV, id, n)[:12], length, authID[:]); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce[:]...)
+	return seal(out, KDF16(cmdKey, kdfHeaderKey, id, n), KDF(cmdKey, kdfHeaderIV, id, n)[:12], header, authID[:])
+}
+
+// OpenHeader 从 r 读取并解密请求头，返回明文
+// AuthID 校验失败或时间戳偏差超过 MaxTimeSkew 时返回 ErrVMessAuthFailed
+func OpenHeader(r io.Reader, cmdKey []byte, now time.Time) ([]byte, error) {
+	var authID, plain [16]byte
+	if _, err := io.ReadFull(r, authID[:]); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(KDF16(cmdKey, kdfAuthIDKey))
+	if err != nil {
+		return nil, err
+	}
+	block.Decrypt(plain[:], authID[:])
+	if binary.BigEndian.Uint32(plain[12:]) != crc32.ChecksumIEEE(plain[:12]) {
+		return nil, E.ErrVMessAuthFailed
+	}
+	if skew := now.Sub(time.Unix(int64(binary.BigEndian.Uint64(plain[:8])), 0)); skew > MaxTimeSkew || skew < -MaxTimeSkew {
+		return nil, E.ErrVMessAuthFailed
+	}
+
+	var lengthNonce [18 + 8]byte
+	if _, err := io.ReadFull(r, lengthNonce[:]); err != nil {
+		return nil, err
+	}
+	id, n := string(authID[:]), string(lengthNonce[18:])
+	length, err := open(KDF16(cmdKey, kdfLengthKey, id, n), KDF(cmdKey, kdfLengthIV, id, n)[:12], lengthNonce[:18], authID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, err
+	}
+	return open(KDF16(cmdKey, kdfHeaderKey, id, n), KDF(cmdKey, kdfHeaderIV, id, n)[:12], sealed, authID[:])
+}
+
+// SealResponseHeader 返回服务端发送的加密响应头
+func SealResponseHeader(h *RequestHeader) ([]byte, error) {
+	key, iv := h.ResponseKey(), h.ResponseIV()
+	header := []byte{h.ResponseAuth, 0x00, 0x00, 0x00}
+	out, err := seal(nil, KDF16(key, kdfRespLengthKey), KDF(iv, kdfRespLengthIV)[:12], binary.BigEndian.AppendUint16(nil, uint16(len(header))), nil)
+	if err != nil {
+		return nil, err
+	}
+	return seal(out, KDF16(key, kdfRespHeaderKey), KDF(iv, kdfRespHeaderIV)[:12], header, nil)
+}
+
+// OpenResponseHeader 从 r 读取响应头并校验回显的 ResponseAuth
+func OpenResponseHeader(r io.Reader, h *RequestHeader) error {
+	key, iv := h.ResponseKey(), h.ResponseIV()
+	sealedLength := make([]byte, 18)
+	if _, err := io.ReadFull(r, sealedLength); err != nil {
+		return err
+	}
+	length, err := open(KDF16(key, kdfRespLengthKey), KDF(iv, kdfRespLengthIV)[:12], sealedLength, nil)
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, int(binary.BigEndian.Uint16(length))+16)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return err
+	}
+	header, err := open(KDF16(key, kdfRespHeaderKey), KDF(iv, kdfRespHeaderIV)[:12], sealed, nil)
+	if err != nil {
+		return err
+	}
+	if len(header) < 4 || header[0] != h.ResponseAuth {
+		return E.ErrVMessAuthFailed
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(dst, key, nonce, plaintext, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(dst, nonce, plaintext, ad), nil
+}
+
+// open 解密失败时返回 ErrVMessAuthFailed
+func open(key, nonce, ciphertext, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, E.ErrVMessAuthFailed
+	}
+	return plain, nil
+}
@@ -0,0 +1,164 @@
+// Package vmess VMess 协议(AEAD 请求头)的编解码，客户端和测试服务共用
+//
+// 请求(客户端 -> 服务端):
+//
+//	+---------+-----------------+----------+-------------------+
+//	| AuthID  | 加密的头长度     | 连接随机数 | 加密的请求头       |
+//	+---------+-----------------+----------+-------------------+
+//	|   16    |     2 + 16      |    8     | 变长 + 16          |
+//	+---------+-----------------+----------+-------------------+
+//
+// AuthID 为 AES-128 加密的 时间戳(8) + 随机数(4) + CRC32(4)，密钥由 UUID 派生
+// 请求头明文:
+//
+//	+-----+--------+---------+------+-----+---------+-----+-----+------+------+-----+------+-------+
+//	| VER | 数据 IV | 数据密钥 | 响应V | OPT | P | SEC | RSV | CMD | PORT | ATYP | ADDR | 填充  | FNV1a |
+//	+-----+--------+---------+------+-----+---------+-----+-----+------+------+-----+------+-------+
+//	|  1  |   16   |   16    |  1   |  1  |    1    |  1  |  1  |  2   |  1   | 变长 |  P   |   4   |
+//	+-----+--------+---------+------+-----+---------+-----+-----+------+------+-----+------+-------+
+//
+// 响应头明文为 响应V + OPT + CMD + CMD长度，同样按 AEAD 加密长度和内容
+// 数据按块传输，每块为 2 字节长度 + AEAD 加密的负载，长度只含认证标签的空块表示结束
+package vmess
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// Version 请求头版本
+const Version = 1
+
+// Security 数据加密方式
+type Security byte
+
+const (
+	SecurityAES128GCM Security = 0x03
+	SecurityChacha20  Security = 0x04 // 需要 golang.org/x/crypto，不支持
+	SecurityNone      Security = 0x05
+)
+
+// ParseSecurity 解析配置中的加密方式，auto 和空字符串使用 AES-128-GCM
+func ParseSecurity(s string) (Security, error) {
+	switch strings.ToLower(s) {
+	case "", "auto", "aes-128-gcm":
+		return SecurityAES128GCM, nil
+	case "none":
+		return SecurityNone, nil
+	default:
+		return 0, E.ErrVMessSecurityNotSupported
+	}
+}
+
+func (s Security) String() string {
+	switch s {
+	case SecurityAES128GCM:
+		return "aes-128-gcm"
+	case SecurityChacha20:
+		return "chacha20-poly1305"
+	case SecurityNone:
+		return "none"
+	default:
+		return "security(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Option 请求选项位
+type Option byte
+
+const (
+	OptionChunkStream   Option = 0x01 // 数据按块传输
+	OptionChunkMasking  Option = 0x04 // 块长度用 SHAKE128 掩码，客户端不使用
+	OptionGlobalPadding Option = 0x08 // 块尾随机填充，客户端不使用
+)
+
+// Command 请求命令
+type Command byte
+
+const (
+	CmdTCP Command = 0x01
+	CmdUDP Command = 0x02
+)
+
+func (c Command) String() string {
+	switch c {
+	case CmdTCP:
+		return "tcp"
+	case CmdUDP:
+		return "udp"
+	default:
+		return "command(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// 请求头中的地址类型，与 SOCKS5 的 ATYP 取值不同
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x02
+	atypIPv6   = 0x03
+)
+
+// UUID 用户 ID
+type UUID [16]byte
+
+// ParseUUID 解析带或不带连字符的 UUID
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	s = strings.ReplaceAll(s, "-", "")
+	if len(s) != 32 {
+		return id, E.ErrVMessInvalidUUID
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, E.ErrVMessInvalidUUID
+	}
+	return id, nil
+}
+
+func (id UUID) String() string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// CmdKey 返回由 UUID 派生的请求头密钥
+func (id UUID) CmdKey() []byte {
+	sum := md5.Sum(append(id[:], "c48619fe-8f02-49e0-b9e9-edf763e17e21"...))
+	return sum[:]
+}
+
+// KDF 使用的路径
+const (
+	kdfSalt          = "VMess AEAD KDF"
+	kdfAuthIDKey     = "AES Auth ID Encryption"
+	kdfLengthKey     = "VMess Header AEAD Key_Length"
+	kdfLengthIV      = "VMess Header AEAD Nonce_Length"
+	kdfHeaderKey     = "VMess Header AEAD Key"
+	kdfHeaderIV      = "VMess Header AEAD Nonce"
+	kdfRespLengthKey = "AEAD Resp Header Len Key"
+	kdfRespLengthIV  = "AEAD Resp Header Len IV"
+	kdfRespHeaderKey = "AEAD Resp Header Key"
+	kdfRespHeaderIV  = "AEAD Resp Header IV"
+)
+
+// KDF 按路径嵌套 HMAC-SHA256 派生密钥，每一层以上一层为哈希函数
+func KDF(key []byte, path ...string) []byte {
+	h := func() hash.Hash { return hmac.New(sha256.New, []byte(kdfSalt)) }
+	for _, p := range path {
+		parent, p := h, []byte(p)
+		h = func() hash.Hash { return hmac.New(parent, p) }
+	}
+	m := h()
+	m.Write(key)
+	return m.Sum(nil)
+}
+
+// KDF16 返回 KDF 结果的前 16 字节，用作 AES-128 密钥
+func KDF16(key []byte, path ...string) []byte {
+	return KDF(key, path...)[:16]
+}
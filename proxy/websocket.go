@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"golang.org/x/net/websocket"
+)
+
+// WSDialer 通过 WebSocket 隧道拨号，每个连接建立一个 WebSocket，隧道数据以二进制帧传输
+// 目标地址按 Path 中的占位符写入握手请求，主机名由服务器解析；只支持 TCP
+type WSDialer struct {
+	addr      string
+	secure    bool
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+	Config    *C.WSConfig
+	metrics   metrics.Recorder
+	obfs      transport.Transport // 到代理的连接使用的传输插件，为 nil 时不使用
+}
+
+func createWSDialer(proxyType C.ProxyType, proxyIP string, proxyPort int, config *C.WSConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	return NewWSDialer(hostport.Join(proxyIP, proxyPort), proxyType == C.WSS, config, metrics), nil
+}
+
+// NewWSDialer 创建 WebSocket 拨号器，secure 为 true 时在 TLS 上建立 WebSocket(wss)
+func NewWSDialer(addr string, secure bool, config *C.WSConfig, metrics metrics.Recorder) *WSDialer {
+	if config == nil {
+		config = C.DefaultWSConfig()
+	}
+	serverName := hostport.Host(addr)
+	if config.Host != "" {
+		serverName = hostport.Host(config.Host)
+	}
+	return &WSDialer{
+		addr:   addr,
+		secure: secure,
+		dialer: &net.Dialer{Timeout: config.Timeout, KeepAlive: config.KeepAlive},
+		tlsConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: config.SkipVerify,
+			NextProtos:         []string{"http/1.1"}, // WebSocket 握手只能在 HTTP/1.1 上进行
+		},
+		Config:  config,
+		metrics: metrics,
+	}
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *WSDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 建立到 addr 的 WebSocket 隧道
+func (d *WSDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *WSDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, E.ErrWSNetworkNotSupported
+	}
+	config, err := d.handshakeConfig(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stageStart := time.Now()
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, E.WrapError(E.ErrWSProxyUnreachable, err.Error())
+	}
+	recordStage(d.metrics, metrics.StageTCPConnect, stageStart)
+	markDSCP(ctx, conn)
+	if conn, err = wrapTransport(ctx, d.obfs, conn); err != nil {
+		return nil, err
+	}
+
+	deadline := handshakeDeadline(ctx, nil, false, 0, d.Config.Timeout)
+	guard := guardHandshake(ctx, conn, deadline)
+	defer guard.stop()
+
+	if d.secure {
+		stageStart = time.Now()
+		tlsConn := tls.Client(conn, d.tlsConfig.Clone())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, E.WrapError(E.ErrTLSHandshake, err.Error())
+		}
+		recordStage(d.metrics, metrics.StageTLSHandshake, stageStart)
+		conn = tlsConn
+	}
+
+	stageStart = time.Now()
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, E.WrapError(E.ErrWSHandshakeFailed, err.Error())
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	recordStage(d.metrics, metrics.StageProxyHandshake, stageStart)
+	if err := guard.done(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: ws, raw: conn}, nil
+}
+
+// handshakeConfig 生成到 addr 的握手请求，路由规则指定的凭证优先于 User/Pass
+func (d *WSDialer) handshakeConfig(ctx context.Context, addr string) (*websocket.Config, error) {
+	scheme, origin := "ws", "http"
+	if d.secure {
+		scheme, origin = "wss", "https"
+	}
+	host := d.addr
+	if d.Config.Host != "" {
+		host = d.Config.Host
+	}
+	path := d.Config.Path
+	if path == "" {
+		path = C.DefaultWSPath
+	}
+
+	config, err := websocket.NewConfig(scheme+"://"+host+wsPath(path, addr), origin+"://"+host)
+	if err != nil {
+		return nil, E.WrapError(E.ErrInvalidConfig, "websocket url: "+err.Error())
+	}
+	for name, value := range d.Config.Headers {
+		if strings.EqualFold(name, "Origin") {
+			if config.Origin, err = url.ParseRequestURI(value); err != nil {
+				return nil, E.WrapError(E.ErrInvalidConfig, "websocket origin: "+err.Error())
+			}
+			continue
+		}
+		config.Header.Set(name, value)
+	}
+	if creds := credentialsFromContext(ctx, Credentials{User: d.Config.User, Pass: d.Config.Pass}); creds.User != "" {
+		config.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds.User+":"+creds.Pass)))
+	}
+	return config, nil
+}
+
+// wsPath 把路径中的占位符替换为转义后的目标地址
+func wsPath(path, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return strings.NewReplacer(
+		"{host}", url.QueryEscape(host),
+		"{port}", port,
+		"{target}", url.QueryEscape(addr),
+	).Replace(path)
+}
+
+// wsConn 隧道连接，地址使用到服务器的 TCP 连接的地址
+type wsConn struct {
+	*websocket.Conn
+	raw net.Conn // 到服务器的 TCP 连接，wss 时为其上的 TLS 连接
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.raw.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.raw.RemoteAddr() }
+
+// Unwrap 返回到服务器的 TCP 或 TLS 连接
+func (c *wsConn) Unwrap() net.Conn { return c.raw }
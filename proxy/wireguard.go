@@ -0,0 +1,278 @@
+//go:build wireguard
+
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// WireGuardDialer 通过进程内的用户态 WireGuard 接口拨号，不需要 TUN 设备和 root 权限
+// TCP 和 UDP 都经过隧道，目标不在 AllowedIPs 内时返回 ErrWireGuardNoRoute
+type WireGuardDialer struct {
+	Config  *C.WGConfig
+	metrics metrics.Recorder
+
+	dev        *device.Device
+	tnet       *netstack.Net
+	allowedIPs []netip.Prefix
+	resolver   Resolver // 没有配置 DNS 时在本地解析主机名，为 nil 时使用 SystemResolver
+}
+
+func (d *WireGuardDialer) setResolver(r Resolver) {
+	d.resolver = r
+}
+
+func createWireGuardDialer(proxyIP string, proxyPort int, config *C.WGConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	return NewWireGuardDialer(hostport.Join(proxyIP, proxyPort), config, metrics)
+}
+
+// NewWireGuardDialer 创建 WireGuard 拨号器，endpoint 为对端的 host:port
+// 接口在创建时启动，握手在第一个数据包发出时进行
+func NewWireGuardDialer(endpoint string, config *C.WGConfig, metrics metrics.Recorder) (*WireGuardDialer, error) {
+	if config == nil {
+		return nil, E.WrapError(E.ErrInvalidConfig, "wireguard config cannot be empty")
+	}
+	var addrs, dns []netip.Addr
+	for _, a := range config.Address {
+		addr, err := C.ParseWireGuardAddr(a)
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "wireguard address: "+a)
+		}
+		addrs = append(addrs, addr)
+	}
+	for _, d := range config.DNS {
+		addr, err := netip.ParseAddr(d)
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "wireguard dns server: "+d)
+		}
+		dns = append(dns, addr)
+	}
+	allowed := config.AllowedIPs
+	if len(allowed) == 0 {
+		allowed = []string{"0.0.0.0/0", "::/0"}
+	}
+	prefixes := make([]netip.Prefix, 0, len(allowed))
+	for _, p := range allowed {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, E.WrapError(E.ErrInvalidConfig, "wireguard allowed ip: "+p)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	uapi, err := wireGuardUAPI(endpoint, config, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	mtu := config.MTU
+	if mtu <= 0 {
+		mtu = C.DefaultWireGuardMTU
+	}
+	tunDev, tnet, err := netstack.CreateNetTUN(addrs, dns, mtu)
+	if err != nil {
+		return nil, E.WrapError(E.ErrWireGuardSetup, err.Error())
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	if err := dev.IpcSet(uapi); err != nil {
+		dev.Close()
+		return nil, E.WrapError(E.ErrWireGuardSetup, err.Error())
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, E.WrapError(E.ErrWireGuardSetup, err.Error())
+	}
+
+	return &WireGuardDialer{
+		Config:     config,
+		metrics:    metrics,
+		dev:        dev,
+		tnet:       tnet,
+		allowedIPs: prefixes,
+	}, nil
+}
+
+// wireGuardUAPI 生成设置接口和对端的 UAPI 配置，密钥需要转换为 hex
+func wireGuardUAPI(endpoint string, config *C.WGConfig, allowed []netip.Prefix) (string, error) {
+	key := func(name, value string) (string, error) {
+		b, err := C.ParseWireGuardKey(value)
+		if err != nil {
+			return "", E.WrapError(E.ErrInvalidConfig, "wireguard "+name+": "+err.Error())
+		}
+		return hex.EncodeToString(b), nil
+	}
+
+	// UAPI 的 endpoint 必须是 IP，主机名在这里解析一次
+	udpAddr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return "", E.WrapError(E.ErrWireGuardSetup, "resolve endpoint: "+err.Error())
+	}
+
+	var b strings.Builder
+	privateKey, err := key("private key", config.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := key("public key", config.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&b, "private_key=%s\n", privateKey)
+	fmt.Fprintf(&b, "public_key=%s\n", publicKey)
+	if config.PresharedKey != "" {
+		presharedKey, err := key("preshared key", config.PresharedKey)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "preshared_key=%s\n", presharedKey)
+	}
+	ap := udpAddr.AddrPort()
+	fmt.Fprintf(&b, "endpoint=%s\n", netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()))
+	if config.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", int(config.PersistentKeepalive/time.Second))
+	}
+	for _, p := range allowed {
+		fmt.Fprintf(&b, "allowed_ip=%s\n", p)
+	}
+	return b.String(), nil
+}
+
+// Dial 实现 ProxyDialer 接口
+func (d *WireGuardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 经隧道连接 addr，配置了 DNS 时主机名经隧道解析，否则在本地解析
+func (d *WireGuardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+
+	if d.metrics != nil {
+		d.metrics.RecordDial(0)
+	}
+
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			err = ctxErr
+		}
+		if d.metrics != nil {
+			d.metrics.RecordFailure(err)
+		}
+		return nil, err
+	}
+
+	if d.metrics != nil {
+		d.metrics.IncrementActiveConnections()
+		d.metrics.RecordDial(time.Since(start))
+		recordReady(ctx, d.metrics, start)
+	}
+	return conn, nil
+}
+
+func (d *WireGuardDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil, E.ErrWireGuardNetworkNotSupported
+	}
+	target, err := d.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.tnet.DialContext(ctx, network, target.String())
+}
+
+// resolve 把 addr 解析为隧道内的目标地址，并检查是否在 AllowedIPs 内
+func (d *WireGuardDialer) resolve(ctx context.Context, addr string) (netip.AddrPort, error) {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ip, err := netip.ParseAddr(hostport.CanonicalHost(host))
+	if err != nil {
+		var ips []string
+		if len(d.Config.DNS) > 0 {
+			ips, err = d.tnet.LookupContextHost(ctx, host)
+		} else {
+			ips, err = d.lookupLocal(ctx, host)
+		}
+		if err != nil {
+			return netip.AddrPort{}, E.WrapError(err, "wireguard: resolve "+host)
+		}
+		ip = netip.Addr{}
+		for _, s := range ips {
+			if candidate, err := netip.ParseAddr(s); err == nil && d.allowed(candidate) {
+				ip = candidate
+				break
+			}
+		}
+		if !ip.IsValid() {
+			return netip.AddrPort{}, E.WrapError(E.ErrWireGuardNoRoute, host)
+		}
+	}
+	ip = ip.Unmap()
+	if !d.allowed(ip) {
+		return netip.AddrPort{}, E.WrapError(E.ErrWireGuardNoRoute, ip.String())
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
+
+// lookupLocal 用本地解析器解析主机名
+func (d *WireGuardDialer) lookupLocal(ctx context.Context, host string) ([]string, error) {
+	resolver := d.resolver
+	if resolver == nil {
+		resolver = SystemResolver{}
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.String())
+	}
+	return ips, nil
+}
+
+func (d *WireGuardDialer) allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range d.allowedIPs {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialPacketContext 实现 PacketDialer 接口，建立经隧道到 addr 的 UDP 连接
+func (d *WireGuardDialer) DialPacketContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	target, err := d.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.tnet.DialUDPAddrPort(netip.AddrPort{}, target)
+}
+
+// ListenPacket 实现 PacketDialer 接口，返回隧道内的 UDP 套接字
+func (d *WireGuardDialer) ListenPacket(ctx context.Context, network string) (net.PacketConn, error) {
+	return d.tnet.ListenUDPAddrPort(netip.AddrPort{})
+}
+
+// Close 关闭 WireGuard 接口，经过它的连接随之断开
+func (d *WireGuardDialer) Close() error {
+	d.dev.Close()
+	return nil
+}
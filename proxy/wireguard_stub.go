@@ -0,0 +1,14 @@
+//go:build !wireguard
+
+package proxy
+
+import (
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// createWireGuardDialer 未使用 -tags wireguard 构建时不包含 wireguard-go 和 gVisor 网络栈
+func createWireGuardDialer(proxyIP string, proxyPort int, config *C.WGConfig, metrics metrics.Recorder) (ProxyDialer, error) {
+	return nil, E.ErrWireGuardNotBuilt
+}
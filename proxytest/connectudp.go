@@ -0,0 +1,250 @@
+package proxytest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/masque"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// NewConnectUDPServer 启动 TLS 上的 HTTP/2 connect-udp 测试代理，证书为自签名证书
+// net/http 的 HTTP/2 服务默认不开启扩展 CONNECT，这里直接在帧层实现，只接受 connect-udp 请求；
+// 支持 WithAuth 和 WithStatus，Targets 返回请求的 UDP 目标
+func NewConnectUDPServer(opts ...Option) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}
+	return newServer(func(s *Server, conn net.Conn) {
+		tlsConn := tls.Server(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		serveConnectUDP(s, tlsConn)
+	}, opts)
+}
+
+// h2UDPConn 帧层的 HTTP/2 服务端连接，写入由 mu 串行化
+type h2UDPConn struct {
+	s  *Server
+	fr *http2.Framer
+
+	mu      sync.Mutex
+	hbuf    bytes.Buffer
+	henc    *hpack.Encoder
+	streams map[uint32]*io.PipeWriter // 只在读循环中访问
+}
+
+func serveConnectUDP(s *Server, conn net.Conn) {
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil || string(preface) != http2.ClientPreface {
+		return
+	}
+	c := &h2UDPConn{s: s, fr: http2.NewFramer(conn, conn), streams: make(map[uint32]*io.PipeWriter)}
+	c.henc = hpack.NewEncoder(&c.hbuf)
+	c.fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	defer func() {
+		for _, pw := range c.streams {
+			pw.Close()
+		}
+	}()
+
+	c.mu.Lock()
+	err := c.fr.WriteSettings(http2.Setting{ID: http2.SettingEnableConnectProtocol, Val: 1})
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for {
+		f, err := c.fr.ReadFrame()
+		if err != nil {
+			return
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				c.mu.Lock()
+				c.fr.WriteSettingsAck()
+				c.mu.Unlock()
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				c.mu.Lock()
+				c.fr.WritePing(true, f.Data)
+				c.mu.Unlock()
+			}
+		case *http2.MetaHeadersFrame:
+			c.handleHeaders(f)
+		case *http2.DataFrame:
+			pw := c.streams[f.StreamID]
+			if pw == nil {
+				continue
+			}
+			// 管道写入在请求的转发协程读完后返回，帧缓冲区在此之前不会被复用
+			pw.Write(f.Data())
+			if n := f.Header().Length; n > 0 {
+				c.mu.Lock()
+				c.fr.WriteWindowUpdate(0, n)
+				c.fr.WriteWindowUpdate(f.StreamID, n)
+				c.mu.Unlock()
+			}
+			if f.StreamEnded() {
+				pw.Close()
+				delete(c.streams, f.StreamID)
+			}
+		case *http2.RSTStreamFrame:
+			if pw := c.streams[f.StreamID]; pw != nil {
+				pw.Close()
+				delete(c.streams, f.StreamID)
+			}
+		case *http2.GoAwayFrame:
+			return
+		}
+	}
+}
+
+// handleHeaders 校验 connect-udp 请求，通过后打开到目标的 UDP 套接字并开始转发
+func (c *h2UDPConn) handleHeaders(f *http2.MetaHeadersFrame) {
+	id := f.StreamID
+	if f.PseudoValue("method") != http.MethodConnect || f.PseudoValue("protocol") != masque.Protocol {
+		c.writeHeaders(id, http.StatusMethodNotAllowed, true)
+		return
+	}
+	target, err := masque.ParsePath(f.PseudoValue("path"))
+	if err != nil {
+		c.writeHeaders(id, http.StatusBadRequest, true)
+		return
+	}
+	c.s.recordTarget(target)
+
+	header := make(http.Header)
+	for _, hf := range f.RegularFields() {
+		header.Add(hf.Name, hf.Value)
+	}
+	if len(c.s.opts.users) > 0 {
+		user, pass, ok := proxyBasicAuth(&http.Request{Header: header})
+		if !ok || !c.s.checkAuth(user, pass) {
+			c.writeHeaders(id, http.StatusProxyAuthRequired, true)
+			return
+		}
+	}
+	if c.s.opts.status != 0 && c.s.opts.status != http.StatusOK {
+		c.writeHeaders(id, c.s.opts.status, true)
+		return
+	}
+
+	udp, err := net.Dial("udp", target)
+	if err != nil {
+		c.writeHeaders(id, http.StatusBadGateway, true)
+		return
+	}
+	pr, pw := io.Pipe()
+	c.streams[id] = pw
+	c.writeHeaders(id, http.StatusOK, false)
+
+	go func() {
+		defer pr.Close()
+		br := bufio.NewReader(pr)
+		relayConnectUDP(udp, func(b []byte) error {
+			var capsule bytes.Buffer
+			masque.WriteDatagramCapsule(&capsule, b)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.fr.WriteData(id, false, capsule.Bytes())
+		}, func() ([]byte, error) {
+			return masque.ReadDatagramCapsule(br)
+		})
+		c.mu.Lock()
+		c.fr.WriteData(id, true, nil)
+		c.mu.Unlock()
+	}()
+}
+
+// writeHeaders 发送响应头，成功的响应带 Capsule-Protocol
+func (c *h2UDPConn) writeHeaders(id uint32, status int, endStream bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hbuf.Reset()
+	c.henc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+	if status == http.StatusOK {
+		c.henc.WriteField(hpack.HeaderField{Name: "capsule-protocol", Value: masque.CapsuleProtocol})
+	}
+	c.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: c.hbuf.Bytes(),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+}
+
+// relayConnectUDP 在 connect-udp 请求和到目标的 UDP 套接字之间转发数据报，请求结束时关闭套接字并返回
+func relayConnectUDP(target net.Conn, send func([]byte) error, receive func() ([]byte, error)) {
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := target.Read(buf)
+			if err != nil {
+				return
+			}
+			// 超过数据报上限的响应被丢弃
+			send(buf[:n])
+		}
+	}()
+	for {
+		b, err := receive()
+		if err != nil {
+			break
+		}
+		target.Write(b)
+	}
+	target.Close()
+}
+
+// serveHTTP3ConnectUDP 在 HTTP/3 请求流上转发 QUIC 数据报，请求流结束时返回
+func serveHTTP3ConnectUDP(w http.ResponseWriter, r *http.Request, target string) {
+	udp, err := net.Dial("udp", target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	w.Header().Set(http3.CapsuleProtocolHeader, masque.CapsuleProtocol)
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	str := w.(http3.HTTPStreamer).HTTPStream()
+	defer str.Close()
+
+	// 客户端关闭请求流时结束数据报接收
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		io.Copy(io.Discard, str)
+		cancel()
+	}()
+	relayConnectUDP(udp, func(b []byte) error {
+		return str.SendDatagram(masque.AppendDatagram(nil, b))
+	}, func() ([]byte, error) {
+		for {
+			b, err := str.ReceiveDatagram(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if payload, ok, err := masque.ParseDatagram(b); err == nil && ok {
+				return payload, nil
+			}
+		}
+	})
+}
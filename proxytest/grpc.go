@@ -0,0 +1,98 @@
+package proxytest
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/grpc"
+	"golang.org/x/net/http2"
+)
+
+// NewGRPCServer 启动明文 HTTP/2(h2c) 上的 gRPC 隧道测试服务，接受任意服务名
+// 目标地址取自 tunnel-target 元数据；WithAuth 要求 Basic 认证的 authorization 元数据，认证失败返回 grpc-status 16
+func NewGRPCServer(opts ...Option) (*Server, error) {
+	return newServer(serveGRPC, opts)
+}
+
+// NewGRPCSServer 启动 TLS 上的 gRPC 隧道测试服务，证书为自签名证书
+func NewGRPCSServer(opts ...Option) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}
+	return newServer(func(s *Server, conn net.Conn) {
+		tlsConn := tls.Server(conn, tlsConfig)
+		// http2.Server 根据握手后的连接状态检查 TLS 版本和 ALPN
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		serveGRPC(s, tlsConn)
+	}, opts)
+}
+
+func serveGRPC(s *Server, conn net.Conn) {
+	server := &http2.Server{}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleGRPC(s, w, r)
+		}),
+	})
+}
+
+func handleGRPC(s *Server, w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get(grpc.TargetMetadata)
+	s.recordTarget(target)
+
+	w.Header().Set("Content-Type", grpc.ContentType)
+	if len(s.opts.users) > 0 || s.opts.anyAuth {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !s.checkAuth(user, pass) {
+			// 只有 trailer 的响应，状态放在响应头中
+			w.Header().Set("Grpc-Status", grpc.StatusUnauthenticated)
+			w.Header().Set("Grpc-Message", "invalid credentials")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		w.Header().Set("Grpc-Status", grpc.StatusUnavailable)
+		w.Header().Set("Grpc-Message", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	defer remote.Close()
+
+	w.WriteHeader(http.StatusOK)
+	flusher := w.(http.Flusher)
+	flusher.Flush()
+
+	go func() {
+		io.Copy(remote, grpc.NewReader(r.Body))
+		if tcp, ok := remote.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+	}()
+
+	buf := make([]byte, grpc.MaxHunkSize)
+	for {
+		n, err := remote.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(grpc.AppendHunk(nil, buf[:n])); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", grpc.StatusOK)
+}
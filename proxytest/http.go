@@ -0,0 +1,248 @@
+package proxytest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewHTTPServer 启动 HTTP CONNECT 测试代理，absolute-form 的普通请求转发给目标
+func NewHTTPServer(opts ...Option) (*Server, error) {
+	return newServer(handleHTTP, opts)
+}
+
+func handleHTTP(s *Server, conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect && !req.URL.IsAbs() {
+			s.writeReply(conn, statusLine(http.StatusMethodNotAllowed))
+			return
+		}
+		s.recordTarget(req.Host)
+
+		// 认证失败时保持连接，允许客户端带凭证重试
+		if s.opts.fault == AuthLoop || !s.authorized(req) {
+			resp := "HTTP/1.1 407 Proxy Authentication Required\r\n"
+			if s.opts.negotiate != nil {
+				resp += "Proxy-Authenticate: Negotiate\r\n"
+			}
+			resp += "Proxy-Authenticate: Basic realm=\"proxytest\"\r\n"
+			if s.opts.fault == AuthClose {
+				resp += "Connection: close\r\n"
+			}
+			resp += "Content-Length: 0\r\n\r\n"
+			if !s.writeReply(conn, []byte(resp)) || s.opts.fault == AuthClose {
+				return
+			}
+			continue
+		}
+
+		if s.opts.status != 0 && s.opts.status != http.StatusOK {
+			s.writeReply(conn, statusLine(s.opts.status))
+			return
+		}
+
+		if req.Method != http.MethodConnect {
+			if !s.forward(conn, req) {
+				return
+			}
+			continue
+		}
+		// 拒绝目标时保持连接，客户端可以在同一连接上 CONNECT 其他目标
+		if !s.connectAllowed(req.Host) {
+			if !s.writeReply(conn, statusLine(http.StatusForbidden)) {
+				return
+			}
+			continue
+		}
+
+		remote, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			conn.Write(statusLine(http.StatusBadGateway))
+			return
+		}
+		defer remote.Close()
+
+		if !s.writeReply(conn, s.pipeline([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))) {
+			return
+		}
+		if n := br.Buffered(); n > 0 {
+			buffered, _ := br.Peek(n)
+			remote.Write(buffered)
+		}
+		relay(conn, remote)
+		return
+	}
+}
+
+// connectAllowed 判断是否允许 CONNECT 到 addr
+func (s *Server) connectAllowed(addr string) bool {
+	if len(s.opts.connect) == 0 {
+		return true
+	}
+	_, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	return slices.Contains(s.opts.connect, port)
+}
+
+// forward 把 absolute-form 请求以 origin-form 发给目标，响应原样返回，返回是否可以继续读取下一个请求
+func (s *Server) forward(conn net.Conn, req *http.Request) bool {
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		host = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	remote, err := net.Dial("tcp", host)
+	if err != nil {
+		conn.Write(statusLine(http.StatusBadGateway))
+		return false
+	}
+	defer remote.Close()
+
+	req.Header.Del("Proxy-Authorization")
+	if err := req.Write(remote); err != nil {
+		conn.Write(statusLine(http.StatusBadGateway))
+		return false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(remote), req)
+	if err != nil {
+		conn.Write(statusLine(http.StatusBadGateway))
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.Write(conn) == nil && !resp.Close && !req.Close
+}
+
+// authorized 校验 Proxy-Authorization 头
+func (s *Server) authorized(req *http.Request) bool {
+	if s.opts.negotiate != nil {
+		auth := req.Header.Get("Proxy-Authorization")
+		if auth == "Negotiate "+base64.StdEncoding.EncodeToString(s.opts.negotiate) {
+			return true
+		}
+	}
+	if auth := req.Header.Get("Proxy-Authorization"); auth != "" && slices.Contains(s.opts.headers, auth) {
+		s.mu.Lock()
+		s.authorizations = append(s.authorizations, auth)
+		s.mu.Unlock()
+		return true
+	}
+	if len(s.opts.users) == 0 {
+		return s.opts.negotiate == nil && len(s.opts.headers) == 0
+	}
+	user, pass, ok := proxyBasicAuth(req)
+	return ok && s.checkAuth(user, pass)
+}
+
+// proxyBasicAuth 解析 Basic 认证的 Proxy-Authorization 头
+func proxyBasicAuth(req *http.Request) (user, pass string, ok bool) {
+	auth := req.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, _ = strings.Cut(string(decoded), ":")
+	return user, pass, true
+}
+
+func statusLine(code int) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: 0\r\n\r\n", code, http.StatusText(code)))
+}
+
+// NewHTTPSServer 启动 TLS 上的 HTTP CONNECT 测试代理，ALPN 只协商 http/1.1
+// 默认使用自签名证书，WithCertificate 指定其他证书
+func NewHTTPSServer(opts ...Option) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	return newServer(func(s *Server, conn net.Conn) {
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+		}
+		if s.opts.cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*s.opts.cert}
+		}
+		handleHTTP(s, tls.Server(conn, tlsConfig))
+	}, opts)
+}
+
+// NewHTTP2Server 启动支持 CONNECT 的 TLS HTTP2 测试代理
+func NewHTTP2Server() *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		go io.Copy(target, r.Body)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	return srv
+}
+
+// selfSignedCert 生成 127.0.0.1 和 localhost 的自签名证书
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxytest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,205 @@
+package proxytest
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/masque"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Server 支持 CONNECT 和 connect-udp 的 HTTP/3 测试代理，接受 0-RTT
+type HTTP3Server struct {
+	pc   net.PacketConn
+	ln   *quic.EarlyListener
+	srv  *http3.Server
+	opts options
+
+	mu      sync.Mutex
+	conns   map[*quic.Conn]struct{}
+	targets []string
+	wg      sync.WaitGroup
+
+	accepted int64
+	zeroRTT  int64
+}
+
+// NewHTTP3Server 在本地回环地址的 UDP 端口上启动 HTTP/3 测试代理
+// 同时支持 CONNECT 和以 QUIC 数据报转发的 connect-udp，支持 WithAuth 和 WithStatus
+func NewHTTP3Server(opts ...Option) (*HTTP3Server, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+	ln, err := quic.ListenEarly(pc, tlsConfig, &quic.Config{Allow0RTT: true, EnableDatagrams: true})
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	s := &HTTP3Server{pc: pc, ln: ln, opts: o, conns: make(map[*quic.Conn]struct{})}
+	s.srv = &http3.Server{Handler: http.HandlerFunc(s.handleConnect), EnableDatagrams: true}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *HTTP3Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.accepted, 1)
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+			go func() {
+				select {
+				case <-conn.HandshakeComplete():
+					if conn.ConnectionState().Used0RTT {
+						atomic.AddInt64(&s.zeroRTT, 1)
+					}
+				case <-conn.Context().Done():
+				}
+			}()
+			s.srv.ServeQUICConn(conn)
+		}()
+	}
+}
+
+func (s *HTTP3Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target := r.Host
+	udp := r.Proto == masque.Protocol
+	if udp {
+		var err error
+		if target, err = masque.ParsePath(r.URL.Path); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	if len(s.opts.users) > 0 {
+		user, pass, ok := proxyBasicAuth(r)
+		if want, found := s.opts.users[user]; !ok || !found || want != pass {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+	}
+	if s.opts.status != 0 && s.opts.status != http.StatusOK {
+		w.WriteHeader(s.opts.status)
+		return
+	}
+
+	if udp {
+		serveHTTP3ConnectUDP(w, r, target)
+		return
+	}
+
+	conn, err := net.Dial("tcp", target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	str := w.(http3.HTTPStreamer).HTTPStream()
+	defer str.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, str)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(str, conn)
+	str.Close()
+	<-done
+}
+
+// Addr 返回服务监听的 UDP 地址
+func (s *HTTP3Server) Addr() string {
+	return s.pc.LocalAddr().String()
+}
+
+// Host 返回服务监听的 IP
+func (s *HTTP3Server) Host() string {
+	return s.pc.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// Port 返回服务监听的 UDP 端口
+func (s *HTTP3Server) Port() int {
+	return s.pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Accepted 返回已接受的 QUIC 连接数
+func (s *HTTP3Server) Accepted() int64 {
+	return atomic.LoadInt64(&s.accepted)
+}
+
+// ZeroRTT 返回接受了 0-RTT 数据的 QUIC 连接数
+func (s *HTTP3Server) ZeroRTT() int64 {
+	return atomic.LoadInt64(&s.zeroRTT)
+}
+
+// Targets 返回客户端请求过的目标地址
+func (s *HTTP3Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// CloseConnections 关闭所有 QUIC 连接但继续监听，用于测试客户端重连
+func (s *HTTP3Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+	}
+}
+
+// Close 关闭服务和所有连接
+func (s *HTTP3Server) Close() error {
+	s.srv.Close()
+	err := s.ln.Close()
+	s.pc.Close()
+	s.wg.Wait()
+	return err
+}
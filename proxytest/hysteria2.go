@@ -0,0 +1,330 @@
+package proxytest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/hysteria2"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Hysteria2Server Hysteria 2 测试服务，支持 TCP 和 UDP 转发
+type Hysteria2Server struct {
+	pc   net.PacketConn
+	ln   *quic.Listener
+	opts options
+
+	mu      sync.Mutex
+	conns   map[*quic.Conn]struct{}
+	targets []string
+	logins  []string
+	wg      sync.WaitGroup
+
+	accepted int64
+}
+
+// NewHysteria2Server 在本地回环地址的 UDP 端口上启动 Hysteria2 测试服务，证书为自签名证书
+// WithAuth 要求认证密码为 用户名:密码(与 Hysteria 的 userpass 认证相同)，未设置时接受任意密码；
+// WithStatus 让认证返回该状态码，WithoutUDP 关闭 UDP 转发
+func NewHysteria2Server(opts ...Option) (*Hysteria2Server, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+	ln, err := quic.Listen(pc, tlsConfig, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	s := &Hysteria2Server{pc: pc, ln: ln, opts: o, conns: make(map[*quic.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Hysteria2Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.accepted, 1)
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn 第一个双向流是 HTTP/3 认证请求，认证通过后其余双向流是 TCP 请求
+func (s *Hysteria2Server) serveConn(conn *quic.Conn) {
+	var authed atomic.Bool
+	h3 := &http3.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticate(r) {
+			authed.Store(true)
+			udp := "true"
+			if s.opts.noUDP {
+				udp = "false"
+			}
+			w.Header().Set(hysteria2.HeaderUDP, udp)
+			w.Header().Set(hysteria2.HeaderCCRX, "auto")
+			w.WriteHeader(hysteria2.StatusAuthOK)
+			return
+		}
+		status := s.opts.status
+		if status == 0 {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+	})}
+	raw, err := h3.NewRawServerConn(conn)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+
+	ctx := conn.Context()
+	go func() {
+		for {
+			str, err := conn.AcceptUniStream(ctx)
+			if err != nil {
+				return
+			}
+			go raw.HandleUnidirectionalStream(str)
+		}
+	}()
+	if !s.opts.noUDP {
+		go s.relayUDP(conn, &authed)
+	}
+
+	str, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return
+	}
+	go raw.HandleRequestStream(str)
+	for {
+		str, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		if !authed.Load() {
+			str.CancelRead(0)
+			str.CancelWrite(0)
+			continue
+		}
+		go s.handleTCP(str)
+	}
+}
+
+// authenticate 校验认证请求的密码并记录成功的登录
+func (s *Hysteria2Server) authenticate(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.Host != "hysteria" || r.URL.Path != "/auth" {
+		return false
+	}
+	password := r.Header.Get(hysteria2.HeaderAuth)
+	if len(s.opts.users) > 0 {
+		ok := false
+		for user, pass := range s.opts.users {
+			if password == user+":"+pass {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	s.mu.Lock()
+	s.logins = append(s.logins, password)
+	s.mu.Unlock()
+	return true
+}
+
+// handleTCP 读取 TCPRequest，连接目标后双向转发
+func (s *Hysteria2Server) handleTCP(str *quic.Stream) {
+	defer str.Close()
+	addr, err := hysteria2.ReadTCPRequest(str)
+	if err != nil {
+		str.CancelRead(0)
+		return
+	}
+	s.recordTarget(addr)
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		str.Write(hysteria2.AppendTCPResponse(nil, false, err.Error()))
+		return
+	}
+	defer target.Close()
+	if _, err := str.Write(hysteria2.AppendTCPResponse(nil, true, "")); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(target, str)
+		if tcp, ok := target.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(str, target)
+	str.Close()
+	<-done
+}
+
+// relayUDP 为每个会话 ID 打开一个本地 UDP 套接字转发数据报
+func (s *Hysteria2Server) relayUDP(conn *quic.Conn, authed *atomic.Bool) {
+	sessions := make(map[uint32]net.PacketConn)
+	defrag := make(map[uint32]*hysteria2.Defragger)
+	defer func() {
+		for _, pc := range sessions {
+			pc.Close()
+		}
+	}()
+
+	for {
+		b, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			return
+		}
+		m, err := hysteria2.ParseUDPMessage(b)
+		if err != nil || !authed.Load() {
+			continue
+		}
+		if defrag[m.SessionID] == nil {
+			defrag[m.SessionID] = &hysteria2.Defragger{}
+		}
+		if m = defrag[m.SessionID].Feed(m); m == nil {
+			continue
+		}
+
+		pc := sessions[m.SessionID]
+		if pc == nil {
+			if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+				continue
+			}
+			sessions[m.SessionID] = pc
+			s.recordTarget(m.Addr)
+			go s.replyUDP(conn, pc, m.SessionID)
+		}
+		addr, err := net.ResolveUDPAddr("udp", m.Addr)
+		if err != nil {
+			continue
+		}
+		pc.WriteTo(m.Data, addr)
+	}
+}
+
+// replyUDP 把目标的响应作为数据报发回客户端，超过数据报上限时拆成分片
+func (s *Hysteria2Server) replyUDP(conn *quic.Conn, pc net.PacketConn, sessionID uint32) {
+	buf := make([]byte, 65535)
+	var packetID uint16
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packetID++
+		m := &hysteria2.UDPMessage{
+			SessionID: sessionID,
+			PacketID:  packetID,
+			FragCount: 1,
+			Addr:      from.String(),
+			Data:      buf[:n],
+		}
+		err = conn.SendDatagram(m.Append(nil))
+		var tooLarge *quic.DatagramTooLargeError
+		if errors.As(err, &tooLarge) {
+			for _, frag := range hysteria2.Fragment(m, int(tooLarge.MaxDatagramPayloadSize)-hysteria2.PacketOverhead) {
+				conn.SendDatagram(frag.Append(nil))
+			}
+		}
+	}
+}
+
+func (s *Hysteria2Server) recordTarget(target string) {
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+}
+
+// Addr 返回服务监听的 UDP 地址
+func (s *Hysteria2Server) Addr() string {
+	return s.pc.LocalAddr().String()
+}
+
+// Host 返回服务监听的 IP
+func (s *Hysteria2Server) Host() string {
+	return s.pc.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// Port 返回服务监听的 UDP 端口
+func (s *Hysteria2Server) Port() int {
+	return s.pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Accepted 返回已接受的 QUIC 连接数
+func (s *Hysteria2Server) Accepted() int64 {
+	return atomic.LoadInt64(&s.accepted)
+}
+
+// Targets 返回客户端请求过的 TCP 目标和每个 UDP 会话的第一个目标
+func (s *Hysteria2Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// Logins 返回按顺序认证成功的密码
+func (s *Hysteria2Server) Logins() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logins...)
+}
+
+// CloseConnections 关闭所有 QUIC 连接但继续监听，用于测试客户端重连
+func (s *Hysteria2Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.CloseWithError(0, "")
+	}
+}
+
+// Close 关闭服务和所有连接
+func (s *Hysteria2Server) Close() error {
+	s.CloseConnections()
+	err := s.ln.Close()
+	s.pc.Close()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,457 @@
+// Package proxytest 提供进程内的 SOCKS/HTTP/HTTP3/connect-udp/VMess/SSH/WebSocket/gRPC/Hysteria2 测试代理服务和 Tor 控制端口，支持按脚本注入故障
+package proxytest
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/proxy/vmess"
+	"golang.org/x/crypto/ssh"
+)
+
+// Fault 注入的故障类型
+type Fault int
+
+const (
+	None           Fault = iota // 正常工作
+	SlowHandshake               // 慢速握手: 响应逐字节发送，每字节间隔 Delay
+	Stall                       // 接受连接后从不响应
+	TruncatedReply              // 响应只发送一部分后关闭连接
+	WrongATYP                   // SOCKS5 响应使用无效的地址类型
+	AuthLoop                    // HTTP 始终返回 407
+	Reset                       // 接受连接后立即发送 RST
+	AuthClose                   // HTTP 发送 407 质询后关闭连接
+	AuthStall                   // SOCKS5 选择用户名/密码认证后不再响应认证请求
+)
+
+func (f Fault) String() string {
+	switch f {
+	case None:
+		return "none"
+	case SlowHandshake:
+		return "slow-handshake"
+	case Stall:
+		return "stall"
+	case TruncatedReply:
+		return "truncated-reply"
+	case WrongATYP:
+		return "wrong-atyp"
+	case AuthLoop:
+		return "auth-loop"
+	case Reset:
+		return "reset"
+	case AuthClose:
+		return "auth-close"
+	case AuthStall:
+		return "auth-stall"
+	default:
+		return "fault(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// Option 测试服务选项
+type Option func(*options)
+
+type options struct {
+	fault     Fault
+	delay     time.Duration
+	resetRate float64
+	users     map[string]string // 用户名 -> 密码
+	anyAuth   bool              // 接受任意用户名/密码
+	reply     socks.Reply       // SOCKS5 REP 响应码
+	status    int               // HTTP 响应状态码
+	rand      *rand.Rand
+	unix      string // Unix 域套接字路径，为空时监听本地 TCP 端口
+	uuid      vmess.UUID
+	keys      []ssh.PublicKey     // SSH 服务接受的公钥
+	negotiate []byte              // HTTP 接受的 Negotiate 令牌
+	headers   []string            // HTTP 接受的完整 Proxy-Authorization 头值
+	pipelined []byte              // 成功响应后在同一次写入中紧跟的数据
+	connect   []int               // HTTP 允许 CONNECT 的端口，为空时不限制
+	noUDP     bool                // Hysteria2 不允许 UDP 转发
+	transport transport.Transport // 接受连接后先用传输插件还原代理协议
+	cert      *tls.Certificate    // HTTPS 服务使用的证书，为 nil 时使用自签名证书
+}
+
+// WithFault 注入故障
+func WithFault(f Fault) Option {
+	return func(o *options) { o.fault = f }
+}
+
+// WithDelay 设置慢速握手时每个字节的间隔
+func WithDelay(d time.Duration) Option {
+	return func(o *options) { o.delay = d }
+}
+
+// WithResetRate 以给定概率对每个连接发送 RST，seed 保证结果可复现
+func WithResetRate(p float64, seed int64) Option {
+	return func(o *options) {
+		o.resetRate = p
+		o.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithAuth 要求用户名/密码认证，多次使用可以添加多个用户
+func WithAuth(user, pass string) Option {
+	return func(o *options) {
+		if o.users == nil {
+			o.users = make(map[string]string)
+		}
+		o.users[user] = pass
+	}
+}
+
+// WithAnyAuth 像 Tor 的 SOCKS 端口一样接受无认证和任意用户名/密码，用于检查流隔离凭证
+func WithAnyAuth() Option {
+	return func(o *options) { o.anyAuth = true }
+}
+
+// WithNegotiate 要求 HTTP 代理的 Negotiate 认证，只接受 token，407 质询同时提供 Negotiate
+func WithNegotiate(token []byte) Option {
+	return func(o *options) { o.negotiate = token }
+}
+
+// WithAuthorization 要求 HTTP 代理的请求携带完整的 Proxy-Authorization 头值 header，如 "Bearer token"
+// 多次使用可以接受多个头值，与 WithAuth 同时使用时 Basic 凭证同样被接受
+func WithAuthorization(header string) Option {
+	return func(o *options) { o.headers = append(o.headers, header) }
+}
+
+// WithPipelined 在 CONNECT 成功响应后的同一次写入中紧跟 data，模拟把目标数据和响应一起发送的代理
+func WithPipelined(data []byte) Option {
+	return func(o *options) { o.pipelined = data }
+}
+
+// WithReplyCode 设置 SOCKS5 的 REP 响应码
+func WithReplyCode(rep socks.Reply) Option {
+	return func(o *options) { o.reply = rep }
+}
+
+// WithUnix 在 Unix 域套接字上监听，而不是本地 TCP 端口
+func WithUnix(path string) Option {
+	return func(o *options) { o.unix = path }
+}
+
+// WithUUID 设置 VMess 服务接受的用户 ID，无效的 UUID 按全零处理
+func WithUUID(id string) Option {
+	return func(o *options) { o.uuid, _ = vmess.ParseUUID(id) }
+}
+
+// WithAuthorizedKey 允许使用该公钥登录 SSH 服务，多次使用可以添加多个公钥
+func WithAuthorizedKey(key ssh.PublicKey) Option {
+	return func(o *options) { o.keys = append(o.keys, key) }
+}
+
+// WithConnectPorts 只允许 CONNECT 到这些端口，其他端口返回 403，模拟只允许 CONNECT 到 443 的代理
+func WithConnectPorts(ports ...int) Option {
+	return func(o *options) { o.connect = ports }
+}
+
+// WithStatus 设置 HTTP CONNECT 的响应状态码
+func WithStatus(code int) Option {
+	return func(o *options) { o.status = code }
+}
+
+// WithoutUDP 让 Hysteria2 服务在认证响应中关闭 UDP 转发
+func WithoutUDP() Option {
+	return func(o *options) { o.noUDP = true }
+}
+
+// WithTransport 接受连接后先用 t 的 Unwrap 还原客户端发送的代理协议，插件握手失败时关闭连接
+func WithTransport(t transport.Transport) Option {
+	return func(o *options) { o.transport = t }
+}
+
+// WithCertificate 让 HTTPS 服务使用 cert 而不是自签名证书，cert 可以带中间证书链和 OCSPStaple
+func WithCertificate(cert tls.Certificate) Option {
+	return func(o *options) { o.cert = &cert }
+}
+
+// Server 进程内测试代理服务
+type Server struct {
+	ln      net.Listener
+	opts    options
+	handler func(s *Server, conn net.Conn)
+
+	mu             sync.Mutex
+	randMu         sync.Mutex
+	conns          map[net.Conn]struct{}
+	targets        []string
+	users          []string
+	logins         []string
+	authorizations []string // 被 WithAuthorization 接受的头值
+	wg             sync.WaitGroup
+	accepted       int64
+	empty          int64 // 收到的零长度 UDP 数据报数
+
+	hostKey ssh.PublicKey // SSH 服务的主机公钥
+}
+
+// newServer 在本地回环地址上启动服务
+func newServer(handler func(s *Server, conn net.Conn), opts []Option) (*Server, error) {
+	o := options{delay: 50 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	network, address := "tcp", "127.0.0.1:0"
+	if o.unix != "" {
+		network, address = "unix", o.unix
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:      ln,
+		opts:    o,
+		handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.accepted, 1)
+
+		if s.shouldReset() {
+			reset(conn)
+			continue
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			if s.opts.fault == Stall {
+				io.Copy(io.Discard, conn)
+				return
+			}
+			c := conn
+			if s.opts.transport != nil {
+				var err error
+				if c, err = s.opts.transport.Unwrap(context.Background(), conn); err != nil {
+					return
+				}
+			}
+			s.handler(s, c)
+		}()
+	}
+}
+
+// shouldReset 判断是否对新连接发送 RST
+func (s *Server) shouldReset() bool {
+	if s.opts.fault == Reset {
+		return true
+	}
+	if s.opts.resetRate <= 0 {
+		return false
+	}
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	return s.opts.rand.Float64() < s.opts.resetRate
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// recordTarget 记录客户端请求的目标地址(按收到的原样，域名不解析)
+func (s *Server) recordTarget(target string) {
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+}
+
+// checkAuth 校验用户名和密码并记录认证成功的用户
+func (s *Server) checkAuth(user, pass string) bool {
+	if !s.opts.anyAuth {
+		if want, ok := s.opts.users[user]; !ok || want != pass {
+			return false
+		}
+	}
+	s.mu.Lock()
+	s.users = append(s.users, user)
+	s.logins = append(s.logins, user+":"+pass)
+	s.mu.Unlock()
+	return true
+}
+
+// Users 返回按顺序认证成功的用户名
+func (s *Server) Users() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.users...)
+}
+
+// Authorizations 返回按顺序被 WithAuthorization 接受的 Proxy-Authorization 头值
+func (s *Server) Authorizations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.authorizations...)
+}
+
+// Logins 返回按顺序认证成功的 用户名:密码
+func (s *Server) Logins() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logins...)
+}
+
+// Addr 返回服务监听地址
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Host 返回服务监听的 IP
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+// Port 返回服务监听的端口，Unix 域套接字时为 0
+func (s *Server) Port() int {
+	if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// Accepted 返回已接受的连接数
+func (s *Server) Accepted() int64 {
+	return atomic.LoadInt64(&s.accepted)
+}
+
+// EmptyDatagrams 返回 UDP 中继收到的零长度数据报数，用于检查客户端保活
+func (s *Server) EmptyDatagrams() int64 {
+	return atomic.LoadInt64(&s.empty)
+}
+
+// Targets 返回客户端请求过的目标地址
+func (s *Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// Close 关闭服务和所有连接
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// writeReply 按注入的故障写出握手响应，返回 false 表示连接已不可用
+func (s *Server) writeReply(conn net.Conn, reply []byte) bool {
+	switch s.opts.fault {
+	case TruncatedReply:
+		conn.Write(reply[:len(reply)/2])
+		return false
+	case SlowHandshake:
+		for _, b := range reply {
+			time.Sleep(s.opts.delay)
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return false
+			}
+		}
+		return true
+	default:
+		_, err := conn.Write(reply)
+		return err == nil
+	}
+}
+
+// reset 以 RST 方式关闭连接
+func reset(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// relay 在两个连接之间双向转发数据
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// StartEcho 启动一个回显服务，用作代理的目标
+func StartEcho() (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// StartUDPEcho 启动一个 UDP 回显服务，用作 UDP 关联的目标
+func StartUDPEcho() (net.PacketConn, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc, nil
+}
@@ -0,0 +1,264 @@
+package proxytest
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+)
+
+// NewSOCKSServer 启动同时支持 SOCKS4/4a 和 SOCKS5 的测试代理
+func NewSOCKSServer(opts ...Option) (*Server, error) {
+	return newServer(handleSOCKS, opts)
+}
+
+// NewSOCKSSServer 启动 TLS 上的 SOCKS 测试代理，证书为自签名证书，UDP 中继仍是明文 UDP
+func NewSOCKSSServer(opts ...Option) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	return newServer(func(s *Server, conn net.Conn) {
+		handleSOCKS(s, tls.Server(conn, tlsConfig))
+	}, opts)
+}
+
+func handleSOCKS(s *Server, conn net.Conn) {
+	ver := make([]byte, 1)
+	if _, err := io.ReadFull(conn, ver); err != nil {
+		return
+	}
+	switch ver[0] {
+	case socks.Version4:
+		handleSOCKS4(s, conn)
+	case socks.Version5:
+		handleSOCKS5(s, conn)
+	}
+}
+
+func handleSOCKS5(s *Server, conn net.Conn) {
+	// 方法协商
+	n := make([]byte, 1)
+	if _, err := io.ReadFull(conn, n); err != nil {
+		return
+	}
+	methods := make([]byte, n[0])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	want := socks.MethodNoAuth
+	if len(s.opts.users) > 0 {
+		want = socks.MethodUserPass
+	}
+	selected := socks.MethodNoAcceptable
+	for _, m := range methods {
+		if socks.Method(m) == want || s.opts.anyAuth && (m == byte(socks.MethodNoAuth) || m == byte(socks.MethodUserPass)) {
+			selected = socks.Method(m)
+		}
+	}
+	if _, err := conn.Write([]byte{socks.Version5, byte(selected)}); err != nil || selected == socks.MethodNoAcceptable {
+		return
+	}
+
+	if selected == socks.MethodUserPass && !socks5Auth(s, conn) {
+		return
+	}
+
+	// 请求
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	dst, err := socks.ReadAddr(conn)
+	if err != nil {
+		return
+	}
+	target := dst.String()
+	s.recordTarget(target)
+
+	if s.opts.reply != socks.ReplySucceeded {
+		s.writeReply(conn, s.socks5Reply(s.opts.reply, nil))
+		return
+	}
+
+	switch socks.Command(head[1]) {
+	case socks.CmdConnect:
+		socks5Connect(s, conn, target)
+	case socks.CmdUDPAssociate:
+		socks5Associate(s, conn)
+	default:
+		conn.Write(s.socks5Reply(socks.ReplyCommandNotSupported, nil))
+	}
+}
+
+func socks5Auth(s *Server, conn net.Conn) bool {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return false
+	}
+	user := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, head[:1]); err != nil {
+		return false
+	}
+	pass := make([]byte, head[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return false
+	}
+	if s.opts.fault == AuthStall {
+		io.Copy(io.Discard, conn)
+		return false
+	}
+	if !s.checkAuth(string(user), string(pass)) {
+		conn.Write([]byte{socks.AuthVersion, 0x01})
+		return false
+	}
+	_, err := conn.Write([]byte{socks.AuthVersion, socks.AuthSucceeded})
+	return err == nil
+}
+
+func socks5Connect(s *Server, conn net.Conn, target string) {
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write(s.socks5Reply(socks.ReplyConnectionRefused, nil))
+		return
+	}
+	defer remote.Close()
+
+	if !s.writeReply(conn, s.pipeline(s.socks5Reply(socks.ReplySucceeded, remote.LocalAddr()))) {
+		return
+	}
+	relay(conn, remote)
+}
+
+// socks5Associate 处理 UDP ASSOCIATE，控制连接关闭时中继结束
+func socks5Associate(s *Server, conn net.Conn) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		conn.Write(s.socks5Reply(socks.ReplyGeneralFailure, nil))
+		return
+	}
+	defer pc.Close()
+
+	if !s.writeReply(conn, s.socks5Reply(socks.ReplySucceeded, pc.LocalAddr())) {
+		return
+	}
+
+	go s.relayUDP(pc)
+	io.Copy(io.Discard, conn)
+}
+
+// relayUDP 在客户端与目标之间转发带 SOCKS5 UDP 头的数据报
+func (s *Server) relayUDP(pc net.PacketConn) {
+	var client net.Addr
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if client == nil || from.String() == client.String() {
+			client = from
+			if n == 0 {
+				atomic.AddInt64(&s.empty, 1)
+				continue
+			}
+			dst, hdr, err := socks.ParseUDPHeader(buf[:n])
+			if err != nil {
+				continue
+			}
+			addr, err := net.ResolveUDPAddr("udp", dst.String())
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(buf[hdr:n], addr)
+			continue
+		}
+
+		packet, _ := socks.AppendUDPHeader(nil, socks.FromNetAddr(from))
+		packet = append(packet, buf[:n]...)
+		pc.WriteTo(packet, client)
+	}
+}
+
+func handleSOCKS4(s *Server, conn net.Conn) {
+	head := make([]byte, 7)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(head[1:3])
+	ip := net.IP(head[3:7])
+
+	if _, err := readCString(conn); err != nil {
+		return
+	}
+
+	host := ip.String()
+	// SOCKS4a: 0.0.0.x 表示后跟域名
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readCString(conn)
+		if err != nil {
+			return
+		}
+		host = domain
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	s.recordTarget(target)
+
+	reply := []byte{0x00, byte(socks.Reply4Granted), 0, 0, 0, 0, 0, 0}
+	if s.opts.reply != socks.ReplySucceeded {
+		reply[1] = byte(socks.Reply4Rejected)
+		s.writeReply(conn, reply)
+		return
+	}
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		reply[1] = byte(socks.Reply4Rejected)
+		conn.Write(reply)
+		return
+	}
+	defer remote.Close()
+
+	if !s.writeReply(conn, s.pipeline(reply)) {
+		return
+	}
+	relay(conn, remote)
+}
+
+func readCString(r io.Reader) (string, error) {
+	var out []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(out), nil
+		}
+		out = append(out, b[0])
+	}
+}
+
+// pipeline 在成功响应后追加 WithPipelined 设置的数据
+func (s *Server) pipeline(reply []byte) []byte {
+	return append(reply, s.opts.pipelined...)
+}
+
+// socks5Reply 构造 SOCKS5 响应，WrongATYP 故障时使用无效的地址类型
+func (s *Server) socks5Reply(rep socks.Reply, bound net.Addr) []byte {
+	reply, _ := socks.FromNetAddr(bound).Append([]byte{socks.Version5, byte(rep), 0x00})
+	if s.opts.fault == WrongATYP {
+		reply[3] = 0x09
+	}
+	return reply
+}
@@ -0,0 +1,120 @@
+package proxytest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSSHServer 启动只支持 direct-tcpip 通道的 SSH 测试服务
+// 用户通过 WithAuth(密码)或 WithAuthorizedKey(公钥)设置，主机密钥每次启动随机生成
+func NewSSHServer(opts ...Option) (*Server, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newServer(func(s *Server, conn net.Conn) { handleSSH(s, conn, signer) }, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.hostKey = signer.PublicKey()
+	return s, nil
+}
+
+// HostKey 返回 SSH 服务的主机公钥，其他服务返回 nil
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey
+}
+
+// serverConfig 返回按 WithAuth 和 WithAuthorizedKey 认证的服务端配置
+func (s *Server) serverConfig(hostKey ssh.Signer) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if !s.checkAuth(meta.User(), string(pass)) {
+				return nil, errSSHAuth
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range s.opts.keys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errSSHAuth
+		},
+	}
+	config.AddHostKey(hostKey)
+	return config
+}
+
+var errSSHAuth = errors.New("proxytest: ssh authentication failed")
+
+func handleSSH(s *Server, conn net.Conn, hostKey ssh.Signer) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, s.serverConfig(hostKey))
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "direct-tcpip" {
+			newCh.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+			continue
+		}
+		// RFC 4254 7.2
+		var req struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+			newCh.Reject(ssh.ConnectionFailed, "invalid payload")
+			continue
+		}
+		target := net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port)))
+		s.recordTarget(target)
+
+		go func() {
+			dst, err := net.Dial("tcp", target)
+			if err != nil {
+				newCh.Reject(ssh.ConnectionFailed, err.Error())
+				return
+			}
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				dst.Close()
+				return
+			}
+			go ssh.DiscardRequests(chReqs)
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(ch, dst)
+				ch.CloseWrite()
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(dst, ch)
+				done <- struct{}{}
+			}()
+			<-done
+			ch.Close()
+			dst.Close()
+			<-done
+		}()
+	}
+}
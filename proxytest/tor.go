@@ -0,0 +1,97 @@
+package proxytest
+
+import (
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TorControlServer 模拟 Tor 控制端口，支持 AUTHENTICATE、SIGNAL NEWNYM 和 QUIT
+type TorControlServer struct {
+	ln       net.Listener
+	password string // 为空时接受无认证
+
+	wg     sync.WaitGroup
+	newnym int64
+}
+
+// NewTorControlServer 在本地回环地址上启动控制端口，password 为空时接受无认证的 AUTHENTICATE
+func NewTorControlServer(password string) (*TorControlServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &TorControlServer{ln: ln, password: password}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *TorControlServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *TorControlServer) handle(tc *textproto.Conn) {
+	authenticated := false
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch {
+		case strings.EqualFold(cmd, "AUTHENTICATE"):
+			if s.password != "" {
+				if pass, err := strconv.Unquote(arg); err != nil || pass != s.password {
+					tc.PrintfLine("515 Authentication failed: Password did not match HashedControlPassword value from configuration")
+					return
+				}
+			}
+			authenticated = true
+			tc.PrintfLine("250 OK")
+		case strings.EqualFold(cmd, "QUIT"):
+			tc.PrintfLine("250 closing connection")
+			return
+		case !authenticated:
+			tc.PrintfLine("514 Authentication required.")
+			return
+		case strings.EqualFold(cmd, "SIGNAL") && strings.EqualFold(arg, "NEWNYM"):
+			atomic.AddInt64(&s.newnym, 1)
+			tc.PrintfLine("250 OK")
+		default:
+			tc.PrintfLine("510 Unrecognized command %q", cmd)
+		}
+	}
+}
+
+// Addr 返回控制端口地址
+func (s *TorControlServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Newnym 返回收到的 SIGNAL NEWNYM 次数
+func (s *TorControlServer) Newnym() int64 {
+	return atomic.LoadInt64(&s.newnym)
+}
+
+// Close 关闭控制端口
+func (s *TorControlServer) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
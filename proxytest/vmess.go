@@ -0,0 +1,31 @@
+package proxytest
+
+import (
+	"net"
+
+	"github.com/ba0gu0/GoHookProxy/proxy/vmess"
+)
+
+// NewVMessServer 启动 VMess 测试服务，用户 ID 由 WithUUID 设置
+// 支持 TCP 和 UDP 命令，请求头认证失败时直接关闭连接
+func NewVMessServer(opts ...Option) (*Server, error) {
+	return newServer(handleVMess, opts)
+}
+
+func handleVMess(s *Server, conn net.Conn) {
+	vc, h, err := vmess.Server(conn, s.opts.uuid)
+	if err != nil {
+		return
+	}
+	s.recordTarget(h.Target())
+
+	network := "tcp"
+	if h.Command == vmess.CmdUDP {
+		network = "udp"
+	}
+	target, err := net.Dial(network, h.Target())
+	if err != nil {
+		return
+	}
+	relay(vc, target)
+}
@@ -0,0 +1,88 @@
+package proxytest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// NewWSServer 启动 WebSocket 隧道测试服务，目标地址取自握手请求的 target 查询参数
+// WithAuth 要求 Basic 认证的 Authorization 头，认证失败返回 401
+func NewWSServer(opts ...Option) (*Server, error) {
+	return newServer(handleWS, opts)
+}
+
+// NewWSSServer 启动 TLS 上的 WebSocket 隧道测试服务，证书为自签名证书
+func NewWSSServer(opts ...Option) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+	}
+	return newServer(func(s *Server, conn net.Conn) {
+		handleWS(s, tls.Server(conn, tlsConfig))
+	}, opts)
+}
+
+func handleWS(s *Server, conn net.Conn) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	target := req.URL.Query().Get("target")
+	s.recordTarget(target)
+
+	if len(s.opts.users) > 0 || s.opts.anyAuth {
+		user, pass, ok := req.BasicAuth()
+		if !ok || !s.checkAuth(user, pass) {
+			s.writeReply(conn, statusLine(http.StatusUnauthorized))
+			return
+		}
+	}
+	if s.opts.status != 0 && s.opts.status != http.StatusSwitchingProtocols {
+		s.writeReply(conn, statusLine(s.opts.status))
+		return
+	}
+
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write(statusLine(http.StatusBadGateway))
+		return
+	}
+	defer remote.Close()
+
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		relay(ws, remote)
+	}}
+	server.ServeHTTP(&hijackWriter{conn: conn, br: br}, req)
+}
+
+// hijackWriter 把已经读出请求的连接交给 websocket.Server 接管
+type hijackWriter struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	header http.Header
+}
+
+func (w *hijackWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *hijackWriter) Write(b []byte) (int, error) { return w.conn.Write(b) }
+
+func (w *hijackWriter) WriteHeader(code int) { w.conn.Write(statusLine(code)) }
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(w.br, bufio.NewWriter(w.conn)), nil
+}
@@ -0,0 +1,173 @@
+// Package report 定期采集指标快照，按 JSON、logfmt 或表格渲染后发送到可插拔的目的地
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/metrics"
+)
+
+// DefaultInterval 默认的报告间隔
+const DefaultInterval = 10 * time.Second
+
+// Format 报告的渲染格式
+type Format string
+
+const (
+	JSON   Format = "json"   // 每个快照一行 JSON
+	Logfmt Format = "logfmt" // 每个快照一行 key=value
+	Table  Format = "table"  // 适合人阅读的两列表格
+)
+
+// ContentType 返回格式对应的 MIME 类型
+func (f Format) ContentType() string {
+	if f == JSON {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Snapshot 一次报告的内容，时间字段以毫秒为单位
+type Snapshot struct {
+	Time              time.Time `json:"time"`
+	ActiveConnections int64     `json:"active_connections"`
+	TotalConnections  int64     `json:"total_connections"`
+	FailedConnections int64     `json:"failed_connections"`
+	BytesSent         int64     `json:"bytes_sent"`
+	BytesReceived     int64     `json:"bytes_received"`
+	AvgDurationMs     float64   `json:"avg_duration_ms"`
+	P95LatencyMs      float64   `json:"p95_latency_ms"`
+	P99LatencyMs      float64   `json:"p99_latency_ms"`
+}
+
+// FromMetrics 从指标快照生成报告内容
+func FromMetrics(m *metrics.Metrics, now time.Time) Snapshot {
+	s := Snapshot{
+		Time:              now,
+		ActiveConnections: m.ActiveConnections,
+		TotalConnections:  m.TotalConnections,
+		FailedConnections: m.FailedConnections,
+		BytesSent:         m.BytesSent,
+		BytesReceived:     m.BytesReceived,
+		P95LatencyMs:      ms(m.P95Latency),
+		P99LatencyMs:      ms(m.P99Latency),
+	}
+	if m.TotalConnections > 0 {
+		s.AvgDurationMs = ms(m.ConnectionDuration / time.Duration(m.TotalConnections))
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// fields 按输出顺序返回字段名和值
+func (s Snapshot) fields() [][2]string {
+	return [][2]string{
+		{"time", s.Time.Format(time.RFC3339)},
+		{"active_connections", fmt.Sprint(s.ActiveConnections)},
+		{"total_connections", fmt.Sprint(s.TotalConnections)},
+		{"failed_connections", fmt.Sprint(s.FailedConnections)},
+		{"bytes_sent", fmt.Sprint(s.BytesSent)},
+		{"bytes_received", fmt.Sprint(s.BytesReceived)},
+		{"avg_duration_ms", fmt.Sprintf("%.3f", s.AvgDurationMs)},
+		{"p95_latency_ms", fmt.Sprintf("%.3f", s.P95LatencyMs)},
+		{"p99_latency_ms", fmt.Sprintf("%.3f", s.P99LatencyMs)},
+	}
+}
+
+// Render 按格式渲染快照，结果以换行结尾
+func Render(s Snapshot, format Format) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case JSON:
+		if err := json.NewEncoder(&buf).Encode(s); err != nil {
+			return nil, err
+		}
+	case Logfmt:
+		for i, f := range s.fields() {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			fmt.Fprintf(&buf, "%s=%s", f[0], f[1])
+		}
+		buf.WriteByte('\n')
+	case Table:
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		for _, f := range s.fields() {
+			fmt.Fprintf(w, "%s\t%s\n", f[0], f[1])
+		}
+		w.Flush()
+		buf.WriteByte('\n')
+	default:
+		return nil, fmt.Errorf("unsupported report format: %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Reporter 定期采集并发送报告
+type Reporter struct {
+	Source   func() *metrics.Metrics // 指标来源，如 pm.GetMetrics
+	Format   Format
+	Interval time.Duration // 为 0 时使用 DefaultInterval
+	Sinks    []Sink
+
+	// OnError 发送失败时调用，为 nil 时忽略错误，一个目的地失败不影响其他目的地
+	OnError func(error)
+}
+
+// New 创建报告器
+func New(source func() *metrics.Metrics, format Format, sinks ...Sink) *Reporter {
+	return &Reporter{
+		Source:   source,
+		Format:   format,
+		Interval: DefaultInterval,
+		Sinks:    sinks,
+	}
+}
+
+// Report 立即采集并发送一次报告，返回第一个错误
+func (r *Reporter) Report(ctx context.Context) error {
+	data, err := Render(FromMetrics(r.Source(), time.Now()), r.Format)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, sink := range r.Sinks {
+		if err := sink.Send(ctx, r.Format.ContentType(), data); err != nil {
+			if r.OnError != nil {
+				r.OnError(err)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Run 每个 Interval 发送一次报告，直到 ctx 结束
+func (r *Reporter) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Report(ctx)
+		}
+	}
+}
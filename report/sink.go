@@ -0,0 +1,107 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Sink 报告的目的地
+type Sink interface {
+	Send(ctx context.Context, contentType string, data []byte) error
+}
+
+// SinkFunc 函数形式的 Sink
+type SinkFunc func(ctx context.Context, contentType string, data []byte) error
+
+func (f SinkFunc) Send(ctx context.Context, contentType string, data []byte) error {
+	return f(ctx, contentType, data)
+}
+
+// writerSink 写入 io.Writer，并发调用时按报告串行写入
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Writer 返回写入 w 的 Sink
+func Writer(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+// Stderr 返回写入标准错误的 Sink
+func Stderr() Sink {
+	return Writer(os.Stderr)
+}
+
+func (s *writerSink) Send(ctx context.Context, contentType string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(data)
+	return err
+}
+
+// fileSink 追加写入文件，每次发送时打开文件，便于外部轮转
+type fileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// File 返回追加写入 path 的 Sink
+func File(path string) Sink {
+	return &fileSink{path: path}
+}
+
+func (s *fileSink) Send(ctx context.Context, contentType string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// httpSink 以 POST 发送报告
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// HTTPPost 返回把报告 POST 到 url 的 Sink，client 为 nil 时使用 http.DefaultClient
+// 启用 hook 时请求同样经过路由规则，收集端需要直连时为它添加 direct 规则
+func HTTPPost(url string, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSink{url: url, client: client}
+}
+
+func (s *httpSink) Send(ctx context.Context, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("report: %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
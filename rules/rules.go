@@ -0,0 +1,381 @@
+// Package rules 实现代理路由决策，hook 和其他工具可以复用相同的路由语义
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+)
+
+// Action 路由动作
+type Action string
+
+const (
+	Proxy  Action = "proxy"  // 通过代理连接
+	Direct Action = "direct" // 直接连接
+	Reject Action = "reject" // 拒绝连接
+)
+
+// Rule 按目标匹配的路由规则
+type Rule struct {
+	Type    C.RuleType // 匹配方式，为空时为 domain
+	Pattern string     // 匹配的目标，含义由 Type 决定
+	Action  Action
+
+	// 通过代理访问该目标时使用的凭证，为空时使用全局凭证
+	User string
+	Pass string
+
+	// 走代理时到代理的连接使用的 DSCP，0 表示不设置
+	DSCP int
+
+	// 走代理时使用的命名代理，为空时使用 ProxyManager 当前选择的代理
+	Proxy string
+
+	// 单个连接和每个目标主机每天的流量上限，0 表示不限制
+	MaxConnBytes  int64
+	MaxDailyBytes int64
+
+	// 连接的优先级类别，为空时为 interactive
+	Priority C.Priority
+
+	// 连接的套接字调优预设，为空时不调整
+	Tuning C.SocketTuning
+}
+
+// Match 判断规则是否匹配目标主机，dst-port 规则不匹配没有端口的主机
+func (r Rule) Match(host string) bool {
+	return r.match(host, 0)
+}
+
+// MatchAddr 判断规则是否匹配目标地址 host:port
+func (r Rule) MatchAddr(addr string) bool {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		host, port = hostport.Host(addr), 0
+	}
+	return r.match(host, port)
+}
+
+func (r Rule) match(host string, port int) bool {
+	switch r.Type {
+	case "", C.RuleDomain:
+		return MatchHost(r.Pattern, host)
+	case C.RuleDomainSuffix:
+		suffix := hostport.CanonicalHost(strings.TrimPrefix(r.Pattern, "."))
+		host = hostport.CanonicalHost(host)
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	case C.RuleDomainKeyword:
+		return strings.Contains(hostport.CanonicalHost(host), strings.ToLower(r.Pattern))
+	case C.RuleDomainRegex:
+		re := compileRegex(r.Pattern)
+		return re != nil && re.MatchString(hostport.CanonicalHost(host))
+	case C.RuleIPCIDR:
+		ip := hostport.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		a, _ := netip.AddrFromSlice(ip)
+		a = a.Unmap()
+		if prefix, err := netip.ParsePrefix(r.Pattern); err == nil {
+			return prefix.Contains(a)
+		}
+		want, err := netip.ParseAddr(r.Pattern)
+		return err == nil && want.Unmap() == a
+	case C.RuleDstPort:
+		ports, err := C.ParsePortList(r.Pattern)
+		return err == nil && slices.ContainsFunc(ports, func(p C.PortRange) bool { return p.Contains(port) })
+	case C.RuleFinal:
+		return true
+	}
+	return false
+}
+
+// regexCache 编译过的 domain-regex 表达式，表达式来自配置，数量有限
+var regexCache sync.Map // pattern -> *regexp.Regexp
+
+// compileRegex 返回编译过的表达式，表达式无效时返回 nil，Validate 已拒绝这样的规则
+func compileRegex(pattern string) *regexp.Regexp {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	regexCache.Store(pattern, re)
+	return re
+}
+
+// String 返回规则的描述，用作决策原因
+func (r Rule) String() string {
+	switch r.Type {
+	case "", C.RuleDomain:
+		return r.Pattern
+	case C.RuleFinal:
+		return string(C.RuleFinal)
+	}
+	return string(r.Type) + " " + r.Pattern
+}
+
+// Decision 路由决策及其原因
+type Decision struct {
+	Action Action
+	Reason string
+	Rule   *Rule // 命中的规则，未命中规则时为 nil
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("%s (%s)", d.Action, d.Reason)
+}
+
+// IsPrivate 判断决策是否来自 PrivateBypass，这类直连决策可以被 PAC 脚本覆盖
+func (d Decision) IsPrivate() bool {
+	return d.Rule == nil && strings.HasPrefix(d.Reason, "private ")
+}
+
+// Engine 路由决策引擎
+//
+// 决策顺序:
+//  1. 未启用代理时直连
+//  2. Unix 域套接字直连
+//  3. 发往代理本身(包括 AltProxyAddrs)的连接直连，避免代理自身被再次代理
+//  4. 发往本进程监听地址的连接直连(设置了 Local 时)
+//  5. 未启用 UDP Hook 时 UDP 直连
+//  6. 命中 Bypass 的目标直连
+//  7. 按顺序匹配 Rules，第一个命中的规则生效，动作可以是 proxy、direct 或 reject
+//  8. 命中 Private 的目标直连(本机和内网地址)
+//  9. TCP/UDP 默认走代理，其他网络类型直连
+type Engine struct {
+	Enabled   bool   // 是否启用代理
+	ProxyAddr string // 代理地址 host:port
+	HookUDP   bool   // 是否代理 UDP
+	Rules     []Rule // 有序规则
+
+	// Bypass 直连的目标，先于 Rules 检查
+	Bypass []C.BypassEntry
+
+	// Private BypassPrivate 启用时直连的 PrivateBypass 条目，晚于 Rules 检查
+	Private []C.BypassEntry
+
+	// AltProxyAddrs 其他代理地址，如竞速的备用代理、UDP 代理和命名代理，与 ProxyAddr 一样直连
+	AltProxyAddrs []string
+
+	// Local 判断目标是否为本进程监听的地址，为 nil 时不检查自连接
+	Local func(network, addr string) bool
+}
+
+// PrivateBypass BypassPrivate 启用时直连的目标: localhost、回环地址、RFC1918 私有网段、链路本地地址和 mDNS 的 .local 域名
+// 本机的健康检查和内网服务通常不应经过代理
+var PrivateBypass = []string{
+	"localhost",
+	"127.0.0.0/8",
+	"::1",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"fe80::/10",
+	"*.local",
+}
+
+// privateBypass 解析后的 PrivateBypass
+var privateBypass = func() []C.BypassEntry {
+	entries := make([]C.BypassEntry, 0, len(PrivateBypass))
+	for _, s := range PrivateBypass {
+		b, err := C.ParseBypassEntry(s)
+		if err != nil {
+			panic(err)
+		}
+		entries = append(entries, b)
+	}
+	return entries
+}()
+
+// FromConfig 根据代理配置创建引擎
+func FromConfig(cfg *C.Config) *Engine {
+	if cfg == nil {
+		return &Engine{}
+	}
+	e := &Engine{
+		Enabled:   cfg.Enable,
+		ProxyAddr: cfg.GetProxyAddr(),
+		HookUDP:   cfg.HookUDP,
+	}
+	if cfg.Race != nil && cfg.Race.Mode == C.RaceProxy {
+		e.AltProxyAddrs = append(e.AltProxyAddrs, cfg.Race.ProxyConfig(cfg).GetProxyAddr())
+	}
+	if cfg.UDPProxy != nil {
+		e.AltProxyAddrs = append(e.AltProxyAddrs, cfg.UDPProxy.ProxyConfig(cfg).GetProxyAddr())
+	}
+	for i := range cfg.Proxies {
+		e.AltProxyAddrs = append(e.AltProxyAddrs, cfg.Proxies[i].ProxyConfig(cfg).GetProxyAddr())
+	}
+	for _, entry := range cfg.BypassList {
+		// Validate 已检查过条目，无效条目忽略
+		if b, err := C.ParseBypassEntry(entry); err == nil {
+			e.Bypass = append(e.Bypass, b)
+		}
+	}
+	if cfg.BypassPrivate {
+		e.Private = privateBypass
+	}
+	for _, r := range cfg.Rules {
+		action := Proxy
+		switch Action(r.Action) {
+		case Direct, Reject:
+			action = Action(r.Action)
+		}
+		// Validate 已检查过 DSCP，无效值按不设置处理
+		dscp, _ := C.ParseDSCP(r.DSCP)
+		e.Rules = append(e.Rules, Rule{
+			Type: r.Type, Pattern: r.Pattern, Action: action, User: r.User, Pass: r.Pass, DSCP: dscp, Proxy: r.Proxy,
+			MaxConnBytes: r.MaxConnBytes, MaxDailyBytes: r.MaxDailyBytes, Priority: r.Priority, Tuning: r.Tuning,
+		})
+	}
+	return e
+}
+
+// Evaluate 返回连接的路由动作
+func (e *Engine) Evaluate(network, addr string) Action {
+	return e.Explain(network, addr).Action
+}
+
+// Explain 返回连接的路由决策及原因
+func (e *Engine) Explain(network, addr string) Decision {
+	if e == nil || !e.Enabled {
+		return Decision{Action: Direct, Reason: "proxy disabled"}
+	}
+
+	if IsUnixNetwork(network) {
+		return Decision{Action: Direct, Reason: "unix socket"}
+	}
+
+	if e.IsProxyAddr(addr) {
+		return Decision{Action: Direct, Reason: "proxy address"}
+	}
+
+	if e.Local != nil && e.Local(network, addr) {
+		return Decision{Action: Direct, Reason: "self connection"}
+	}
+
+	if IsUDPNetwork(network) && !e.HookUDP {
+		return Decision{Action: Direct, Reason: "udp hook disabled"}
+	}
+
+	if !IsTCPNetwork(network) && !IsUDPNetwork(network) {
+		return Decision{Action: Direct, Reason: "unsupported network " + network}
+	}
+
+	for _, b := range e.Bypass {
+		if MatchBypass(b, addr) {
+			return Decision{Action: Direct, Reason: "bypass " + b.String()}
+		}
+	}
+	for i := range e.Rules {
+		if e.Rules[i].MatchAddr(addr) {
+			return Decision{Action: e.Rules[i].Action, Reason: "rule " + e.Rules[i].String(), Rule: &e.Rules[i]}
+		}
+	}
+	for _, b := range e.Private {
+		if MatchBypass(b, addr) {
+			return Decision{Action: Direct, Reason: "private " + b.String()}
+		}
+	}
+
+	return Decision{Action: Proxy, Reason: "default"}
+}
+
+// IsProxyAddr 判断 addr 是否为代理本身的地址(包括 AltProxyAddrs)
+func (e *Engine) IsProxyAddr(addr string) bool {
+	if e == nil {
+		return false
+	}
+	if hostport.Equal(addr, e.ProxyAddr) {
+		return true
+	}
+	for _, alt := range e.AltProxyAddrs {
+		if hostport.Equal(addr, alt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge 合并多个引擎，基础设置取第一个非空引擎，规则按传入顺序拼接(靠前的优先)
+func Merge(engines ...*Engine) *Engine {
+	merged := &Engine{}
+	base := false
+	for _, e := range engines {
+		if e == nil {
+			continue
+		}
+		if !base {
+			merged.Enabled = e.Enabled
+			merged.ProxyAddr = e.ProxyAddr
+			merged.HookUDP = e.HookUDP
+			merged.Local = e.Local
+			merged.AltProxyAddrs = e.AltProxyAddrs
+			merged.Bypass = e.Bypass
+			merged.Private = e.Private
+			base = true
+		}
+		merged.Rules = append(merged.Rules, e.Rules...)
+	}
+	return merged
+}
+
+// MatchHost 判断主机名是否匹配模式，*.example.com 匹配所有子域名，不区分大小写
+func MatchHost(pattern, host string) bool {
+	host = hostport.CanonicalHost(host)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, strings.ToLower(pattern[1:]))
+	}
+	return hostport.CanonicalHost(pattern) == host
+}
+
+// MatchBypass 判断绕过条目是否匹配目标地址 host:port，网段只匹配 IP 字面量
+func MatchBypass(b C.BypassEntry, addr string) bool {
+	host, port, err := hostport.Split(addr)
+	if err != nil {
+		host, port = hostport.Host(addr), 0
+	}
+	if b.Port != 0 && b.Port != port {
+		return false
+	}
+	switch {
+	case b.Prefix.IsValid():
+		ip := hostport.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		a, _ := netip.AddrFromSlice(ip)
+		return b.Prefix.Contains(a.Unmap())
+	case b.Host == "*":
+		return true
+	case b.Host != "":
+		return MatchHost(b.Host, host)
+	}
+	return true
+}
+
+// IsUnixNetwork 判断是否为 Unix 套接字网络类型
+func IsUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixpacket" || network == "unixgram"
+}
+
+// IsUDPNetwork 判断是否为 UDP 网络类型
+func IsUDPNetwork(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
+
+// IsTCPNetwork 判断是否为 TCP 网络类型
+func IsTCPNetwork(network string) bool {
+	return network == "tcp" || network == "tcp4" || network == "tcp6"
+}
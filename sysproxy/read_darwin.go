@@ -0,0 +1,22 @@
+package sysproxy
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// read 先读取环境变量，没有设置时读取 SystemConfiguration 的代理设置
+// 通过 scutil --proxy 读取，不需要 cgo；scutil 不可用时返回空的设置
+func read() (*Settings, error) {
+	if s, err := FromEnv(nil); err != nil || !s.Empty() {
+		return s, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "scutil", "--proxy").Output()
+	if err != nil {
+		return &Settings{}, nil
+	}
+	return ParseScutil(string(out))
+}
@@ -0,0 +1,99 @@
+package sysproxy
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// read 先读取环境变量，没有设置时读取 GNOME 的代理设置，gsettings 不可用时返回空的设置
+func read() (*Settings, error) {
+	if s, err := FromEnv(nil); err != nil || !s.Empty() {
+		return s, err
+	}
+	return readGNOME(gsettings)
+}
+
+// gsettings 读取一个 GSettings 键，返回 GVariant 文本形式的值
+func gsettings(schema, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "gsettings", "get", schema, key).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// readGNOME 读取 org.gnome.system.proxy，mode 为 manual 时使用各协议的代理，为 auto 时使用自动配置脚本
+func readGNOME(get func(schema, key string) (string, error)) (*Settings, error) {
+	const schema = "org.gnome.system.proxy"
+	mode, err := get(schema, "mode")
+	if err != nil {
+		return &Settings{}, nil
+	}
+	s := &Settings{}
+	switch unquote(mode) {
+	case "manual":
+		for _, p := range []struct {
+			name, scheme string
+			dst          **url.URL
+		}{
+			{"http", "http", &s.HTTP},
+			{"https", "http", &s.HTTPS},
+			{"socks", "socks5", &s.SOCKS},
+		} {
+			host, _ := get(schema+"."+p.name, "host")
+			port, _ := get(schema+"."+p.name, "port")
+			if host = unquote(host); host == "" || port == "" || port == "0" {
+				continue
+			}
+			if *p.dst, err = ParseProxyURL(net.JoinHostPort(host, port), p.scheme); err != nil {
+				return nil, err
+			}
+		}
+		// HTTP 代理的认证信息
+		if auth, _ := get(schema+".http", "use-authentication"); auth == "true" && s.HTTP != nil {
+			user, _ := get(schema+".http", "authentication-user")
+			pass, _ := get(schema+".http", "authentication-password")
+			s.HTTP.User = url.UserPassword(unquote(user), unquote(pass))
+		}
+		ignore, _ := get(schema, "ignore-hosts")
+		s.Bypass = parseStringList(ignore)
+	case "auto":
+		pac, _ := get(schema, "autoconfig-url")
+		if s.PACURL = unquote(pac); s.PACURL == "" {
+			s.AutoDetect = true
+		}
+	}
+	if !s.Empty() {
+		s.Source = "gnome"
+	}
+	return s, nil
+}
+
+// unquote 去掉 GVariant 字符串两边的引号
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], `\'`, "'")
+	}
+	return s
+}
+
+// parseStringList 解析 GVariant 字符串数组，如 ['localhost', '127.0.0.0/8']
+func parseStringList(s string) []string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "@as ")
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = unquote(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package sysproxy
+
+// read 其他系统没有统一的代理设置，只读取环境变量
+func read() (*Settings, error) {
+	return FromEnv(nil)
+}
@@ -0,0 +1,61 @@
+package sysproxy
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	internetSettings = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+	connections      = internetSettings + `\Connections`
+)
+
+// read 先读取环境变量，然后是当前用户的 WinINet 设置，都没有设置时读取机器级的 WinHTTP 设置
+func read() (*Settings, error) {
+	if s, err := FromEnv(nil); err != nil || !s.Empty() {
+		return s, err
+	}
+	if s, err := readWinINet(); err != nil || !s.Empty() {
+		return s, err
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, connections, registry.QUERY_VALUE)
+	if err != nil {
+		return &Settings{}, nil
+	}
+	defer key.Close()
+	blob, _, err := key.GetBinaryValue("WinHttpSettings")
+	if err != nil {
+		return &Settings{}, nil
+	}
+	return ParseWinHTTPSettings(blob)
+}
+
+func readWinINet() (*Settings, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettings, registry.QUERY_VALUE)
+	if err != nil {
+		return &Settings{}, nil
+	}
+	defer key.Close()
+
+	var server, override string
+	if enabled, _, err := key.GetIntegerValue("ProxyEnable"); err == nil && enabled != 0 {
+		server, _, _ = key.GetStringValue("ProxyServer")
+		override, _, _ = key.GetStringValue("ProxyOverride")
+	}
+	pac, _, _ := key.GetStringValue("AutoConfigURL")
+	s, err := ParseWinINet(server, override, pac)
+	if err != nil {
+		return nil, err
+	}
+
+	// DefaultConnectionSettings 第 8 字节的第 3 位表示自动检测设置(WPAD)
+	if conn, err := registry.OpenKey(registry.CURRENT_USER, connections, registry.QUERY_VALUE); err == nil {
+		defer conn.Close()
+		if blob, _, err := conn.GetBinaryValue("DefaultConnectionSettings"); err == nil && len(blob) > 8 && blob[8]&0x08 != 0 {
+			s.AutoDetect = true
+			if s.Source == "" {
+				s.Source = "wininet"
+			}
+		}
+	}
+	return s, nil
+}
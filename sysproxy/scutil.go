@@ -0,0 +1,76 @@
+package sysproxy
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ParseScutil 解析 macOS scutil --proxy 的输出
+func ParseScutil(out string) (*Settings, error) {
+	values := make(map[string]string)
+	var exceptions []string
+	inArray := ""
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "}" {
+			inArray = ""
+			continue
+		}
+		key, value, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if inArray != "" {
+			if inArray == "ExceptionsList" {
+				exceptions = append(exceptions, value)
+			}
+			continue
+		}
+		if strings.HasPrefix(value, "<array>") {
+			inArray = key
+			continue
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	s := &Settings{Bypass: exceptions}
+	if values["ExcludeSimpleHostnames"] == "1" {
+		s.Bypass = append(s.Bypass, "<local>")
+	}
+	var err error
+	for _, p := range []struct {
+		prefix, scheme string
+		dst            **url.URL
+	}{
+		{"HTTP", "http", &s.HTTP},
+		{"HTTPS", "http", &s.HTTPS},
+		{"SOCKS", "socks5", &s.SOCKS},
+	} {
+		host := values[p.prefix+"Proxy"]
+		if values[p.prefix+"Enable"] != "1" || host == "" {
+			continue
+		}
+		addr := host
+		if port := values[p.prefix+"Port"]; port != "" {
+			addr = net.JoinHostPort(host, port)
+		}
+		if *p.dst, err = ParseProxyURL(addr, p.scheme); err != nil {
+			return nil, err
+		}
+	}
+	if values["ProxyAutoConfigEnable"] == "1" {
+		s.PACURL = values["ProxyAutoConfigURLString"]
+	}
+	s.AutoDetect = values["ProxyAutoDiscoveryEnable"] == "1"
+	if !s.Empty() {
+		s.Source = "scutil"
+	}
+	return s, nil
+}
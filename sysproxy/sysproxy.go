@@ -0,0 +1,127 @@
+// Package sysproxy 读取操作系统的代理设置
+// 所有系统都先读取 http_proxy 等环境变量，没有设置时: Windows 读取 WinINet(Internet 选项)的注册表设置，再没有时读取 WinHTTP；
+// macOS 读取 scutil --proxy 输出的 SystemConfiguration 设置；Linux 读取 GNOME 的 gsettings；其他系统只使用环境变量
+package sysproxy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Settings 系统代理设置，没有设置的字段为空
+type Settings struct {
+	Source string // 设置的来源: env、gnome、scutil、wininet 或 winhttp，没有任何设置时为空
+
+	HTTP  *url.URL // http 请求使用的代理
+	HTTPS *url.URL // https 请求使用的代理，通常也是 HTTP CONNECT 代理
+	SOCKS *url.URL // SOCKS 代理
+
+	PACURL     string   // 代理自动配置脚本的地址
+	AutoDetect bool     // 开启了 WPAD 自动发现，需要调用方自己发现脚本地址
+	Bypass     []string // 不经过代理的目标，保持系统中的写法，如 *.local、10.*、<local>
+}
+
+// Empty 判断是否没有设置任何代理
+func (s *Settings) Empty() bool {
+	return s == nil || s.HTTP == nil && s.HTTPS == nil && s.SOCKS == nil && s.PACURL == "" && !s.AutoDetect
+}
+
+// Proxy 返回只能使用一个代理时的选择，依次为 HTTPS、HTTP 和 SOCKS 代理，都没有设置时返回 nil
+func (s *Settings) Proxy() *url.URL {
+	if s == nil {
+		return nil
+	}
+	for _, u := range []*url.URL{s.HTTPS, s.HTTP, s.SOCKS} {
+		if u != nil {
+			return u
+		}
+	}
+	return nil
+}
+
+// Read 读取当前系统的代理设置，没有设置代理时返回空的 Settings
+func Read() (*Settings, error) {
+	return read()
+}
+
+// ParseProxyURL 解析代理地址，没有 scheme 时(如 proxy.corp:8080)使用 defaultScheme
+func ParseProxyURL(s, defaultScheme string) (*url.URL, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("proxy address cannot be empty")
+	}
+	if !strings.Contains(s, "://") {
+		s = defaultScheme + "://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address %q: %w", s, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy address %q: missing host", s)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	return u, nil
+}
+
+// FromEnv 从 http_proxy、https_proxy、all_proxy 和 no_proxy 读取设置，小写的变量优先于大写
+// all_proxy 为 SOCKS 地址时作为 SOCKS 代理，否则补充没有设置的 HTTP 和 HTTPS 代理；getenv 为 nil 时使用 os.Getenv
+func FromEnv(getenv func(string) string) (*Settings, error) {
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	lookup := func(name string) string {
+		if v := getenv(strings.ToLower(name)); v != "" {
+			return v
+		}
+		return getenv(name)
+	}
+
+	s := &Settings{}
+	var err error
+	if v := lookup("HTTP_PROXY"); v != "" {
+		if s.HTTP, err = ParseProxyURL(v, "http"); err != nil {
+			return nil, fmt.Errorf("http_proxy: %w", err)
+		}
+	}
+	if v := lookup("HTTPS_PROXY"); v != "" {
+		if s.HTTPS, err = ParseProxyURL(v, "http"); err != nil {
+			return nil, fmt.Errorf("https_proxy: %w", err)
+		}
+	}
+	if v := lookup("ALL_PROXY"); v != "" {
+		all, err := ParseProxyURL(v, "http")
+		if err != nil {
+			return nil, fmt.Errorf("all_proxy: %w", err)
+		}
+		switch {
+		case strings.HasPrefix(all.Scheme, "socks"):
+			s.SOCKS = all
+		default:
+			if s.HTTP == nil {
+				s.HTTP = all
+			}
+			if s.HTTPS == nil {
+				s.HTTPS = all
+			}
+		}
+	}
+	s.Bypass = splitList(lookup("NO_PROXY"), ",")
+	if !s.Empty() {
+		s.Source = "env"
+	}
+	return s, nil
+}
+
+// splitList 按分隔符拆分列表，去掉空白和空项
+func splitList(s, seps string) []string {
+	var list []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return strings.ContainsRune(seps, r) }) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package sysproxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseWinINet 解析 Windows Internet 选项的 ProxyServer、ProxyOverride 和 AutoConfigURL 注册表值
+// ProxyServer 可以是所有协议共用的 host:port，也可以是 http=host:port;https=host:port;socks=host:port，
+// WinINet 的 socks 代理为 SOCKS4；ProxyServer 为空时只使用其他设置
+func ParseWinINet(proxyServer, proxyOverride, autoConfigURL string) (*Settings, error) {
+	s, err := parseWindowsProxy(proxyServer, proxyOverride)
+	if err != nil {
+		return nil, err
+	}
+	s.PACURL = strings.TrimSpace(autoConfigURL)
+	if !s.Empty() {
+		s.Source = "wininet"
+	}
+	return s, nil
+}
+
+// ParseWinHTTPSettings 解析 WinHTTP 的 WinHttpSettings 注册表值(netsh winhttp set proxy 写入的二进制结构):
+// 版本、计数器、标志(第 1 位表示使用代理)各 4 字节，然后是以长度开头的代理地址和绕过列表，均为小端序
+func ParseWinHTTPSettings(blob []byte) (*Settings, error) {
+	readString := func(off int) (string, int, error) {
+		if off+4 > len(blob) {
+			return "", 0, fmt.Errorf("winhttp settings truncated at offset %d", off)
+		}
+		n := int(binary.LittleEndian.Uint32(blob[off:]))
+		off += 4
+		if n < 0 || off+n > len(blob) {
+			return "", 0, fmt.Errorf("winhttp settings truncated at offset %d", off)
+		}
+		return string(blob[off : off+n]), off + n, nil
+	}
+
+	if len(blob) < 12 {
+		return nil, fmt.Errorf("winhttp settings too short: %d bytes", len(blob))
+	}
+	if flags := binary.LittleEndian.Uint32(blob[8:]); flags&2 == 0 {
+		return &Settings{}, nil
+	}
+	proxyServer, off, err := readString(12)
+	if err != nil {
+		return nil, err
+	}
+	bypass, _, err := readString(off)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseWindowsProxy(proxyServer, bypass)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Empty() {
+		s.Source = "winhttp"
+	}
+	return s, nil
+}
+
+// parseWindowsProxy 解析 WinINet 和 WinHTTP 共用的代理列表和绕过列表格式
+func parseWindowsProxy(proxyServer, bypass string) (*Settings, error) {
+	s := &Settings{Bypass: splitList(bypass, "; ")}
+	for _, entry := range splitList(proxyServer, "; ") {
+		proto, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			// 所有协议共用一个代理
+			u, err := ParseProxyURL(entry, "http")
+			if err != nil {
+				return nil, err
+			}
+			s.HTTP, s.HTTPS = u, u
+			continue
+		}
+		var dst **url.URL
+		scheme := "http"
+		switch strings.ToLower(proto) {
+		case "http":
+			dst = &s.HTTP
+		case "https":
+			dst = &s.HTTPS
+		case "socks":
+			dst, scheme = &s.SOCKS, "socks4"
+		default:
+			continue
+		}
+		u, err := ParseProxyURL(addr, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("%s proxy: %w", proto, err)
+		}
+		*dst = u
+	}
+	return s, nil
+}
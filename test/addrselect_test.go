@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// TestAddrSelection 测试主机名有多个地址时避开拨号失败的地址，统计过期后重新尝试
+func TestAddrSelection(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, port, _ := net.SplitHostPort(echoAddr)
+	// 127.0.0.2 上没有监听，拨号立即失败
+	hosts := PM.HostsResolver{Hosts: map[string][]netip.Addr{
+		"multi.test": {netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.1")},
+	}}
+	target := net.JoinHostPort("multi.test", port)
+
+	fake := clock.NewFake(time.Now())
+	cfg := C.DefaultConfig()
+	cfg.AddrSelection = &C.AddrSelectionConfig{ExploreInterval: time.Minute}
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pm.SetResolver(hosts)
+
+	dial := func() error {
+		conn, err := pm.DialDirect(context.Background(), "tcp", target)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	// 两个地址都没有统计，先按解析顺序尝试 127.0.0.2
+	if err := dial(); err == nil {
+		t.Fatal("第一次拨号应尝试没有监听的 127.0.0.2")
+	}
+	for i := 0; i < 3; i++ {
+		if err := dial(); err != nil {
+			t.Fatalf("第 %d 次拨号应选择可用的地址: %v", i+2, err)
+		}
+	}
+
+	// 统计过期后重新尝试失败过的地址
+	fake.Advance(time.Minute)
+	if err := dial(); err == nil {
+		t.Error("统计过期后应重新尝试 127.0.0.2")
+	}
+	if err := dial(); err != nil {
+		t.Errorf("重新尝试失败后应回到可用的地址: %v", err)
+	}
+
+	// 关闭功能后按解析顺序拨号
+	cfg.Features = map[C.Feature]bool{C.FeatureAddrSelection: false}
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := dial(); err == nil {
+			t.Error("未启用地址选择时应总是拨号第一个地址")
+		}
+	}
+
+	cfg.AddrSelection.MaxAddrs = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("负数的 MaxAddrs 应验证失败")
+	}
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// startALPNProxy 启动优先协商 h2 的 TLS CONNECT 代理，通过 protos 返回每个连接协商出的 ALPN 协议
+func startALPNProxy(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	// 借用 httptest 的自签名证书
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	certs := srv.TLS.Certificates
+	srv.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: certs,
+		NextProtos:   []string{"h2", "http/1.1", "x-proxy"},
+	})
+	if err != nil {
+		t.Fatalf("启动代理失败: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	protos := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				protos <- tlsConn.ConnectionState().NegotiatedProtocol
+
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go br.WriteTo(target)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String(), protos
+}
+
+// TestHTTPSALPN 测试 https 代理默认只提供 http/1.1，以及自定义的 ALPN 协议
+func TestHTTPSALPN(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyAddr, protos := startALPNProxy(t)
+	host, port, _ := net.SplitHostPort(proxyAddr)
+
+	for _, tc := range []struct {
+		name       string
+		nextProtos []string
+		want       string
+	}{
+		{"默认", nil, "http/1.1"},
+		{"自定义", []string{"x-proxy"}, "x-proxy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = C.HTTPS
+			cfg.ProxyIP = host
+			cfg.ProxyPort, _ = strconv.Atoi(port)
+			cfg.HTTPConfig.SkipVerify = true
+			cfg.HTTPConfig.NextProtos = tc.nextProtos
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("通过 HTTPS 代理连接失败: %v", err)
+			}
+			defer conn.Close()
+			sshEcho(t, conn)
+			if got := <-protos; got != tc.want {
+				t.Errorf("应协商出 %q, 实际: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestALPNConfig 测试 ALPN 协议列表按代理类型校验和补全默认值
+func TestALPNConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 443
+
+	for _, tc := range []struct {
+		proxyType  C.ProxyType
+		nextProtos []string
+		valid      bool
+	}{
+		{C.HTTPS, []string{"http/1.1"}, true},
+		{C.HTTPS, []string{"h2", "http/1.1"}, false},
+		{C.HTTP2, []string{"h2"}, true},
+		{C.HTTP2, []string{"http/1.1"}, false},
+		{C.HTTP3, []string{"h3"}, true},
+		{C.HTTP3, []string{"h2"}, false},
+		{C.HTTPS, []string{""}, false},
+	} {
+		cfg.ProxyType = tc.proxyType
+		cfg.HTTPConfig.NextProtos = tc.nextProtos
+		if err := cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s %v 校验结果应为 %v, 实际: %v", tc.proxyType, tc.nextProtos, tc.valid, err)
+		}
+	}
+
+	cfg.ProxyType = C.HTTPS
+	cfg.HTTPConfig.NextProtos = nil
+	if got := cfg.WithDefaults().HTTPConfig.NextProtos; len(got) != 1 || got[0] != "http/1.1" {
+		t.Errorf("https 默认应只提供 http/1.1, 实际: %v", got)
+	}
+	if got := C.DefaultNextProtos(C.HTTP2); len(got) != 2 || got[0] != "h2" {
+		t.Errorf("http2 默认应优先 h2, 实际: %v", got)
+	}
+}
@@ -0,0 +1,246 @@
+package test
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// balanceConfig 返回主代理和两个命名代理的均衡配置
+func balanceConfig(strategy C.BalanceStrategy, servers ...*proxytest.Server) *C.Config {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.MetricsEnable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = servers[0].Host()
+	cfg.ProxyPort = servers[0].Port()
+	cfg.Proxies = []C.NamedProxy{
+		{Name: "b", ProxyType: C.SOCKS5, ProxyIP: servers[1].Host(), ProxyPort: servers[1].Port()},
+		{Name: "c", ProxyType: C.HTTP, ProxyIP: servers[2].Host(), ProxyPort: servers[2].Port()},
+	}
+	cfg.Balance = &C.BalanceConfig{Strategy: strategy}
+	return cfg
+}
+
+// TestBalanceRoundRobin 测试轮询依次使用主代理和命名代理，Use 选择的代理不参与均衡
+func TestBalanceRoundRobin(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	servers := []*proxytest.Server{
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewHTTPServer),
+	}
+	pm, err := PM.New(balanceConfig(C.BalanceRoundRobin, servers...))
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	for i := 0; i < 6; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i, err)
+		}
+		conn.Close()
+	}
+	for i, srv := range servers {
+		if n := len(srv.Targets()); n != 2 {
+			t.Errorf("代理 %d 预期 2 次拨号, 实际: %d", i, n)
+		}
+	}
+	upstreams := pm.GetMetrics().Upstreams
+	if s := upstreams["b"]; s.Dials != 2 || s.Active != 0 {
+		t.Errorf("命名代理 b 的统计错误: %+v", s)
+	}
+
+	if err := pm.Use("c"); err != nil {
+		t.Fatalf("选择命名代理失败: %v", err)
+	}
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	conn.Close()
+	if n := len(servers[2].Targets()); n != 3 {
+		t.Errorf("Use 选择的代理应收到拨号, 实际: %d", n)
+	}
+}
+
+// TestBalanceLeastConn 测试最少连接策略选择活动连接最少的代理
+func TestBalanceLeastConn(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	servers := []*proxytest.Server{
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewHTTPServer),
+	}
+	pm, err := PM.New(balanceConfig(C.BalanceLeastConn, servers...))
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i, srv := range servers {
+		if n := len(srv.Targets()); n != 1 {
+			t.Errorf("代理 %d 预期 1 个连接, 实际: %d", i, n)
+		}
+	}
+	if s := pm.GetMetrics().Upstreams[""]; s.Active != 1 {
+		t.Errorf("主代理预期 1 个活动连接, 实际: %+v", s)
+	}
+
+	// 关闭经过 b 的连接后 b 的活动连接最少，新的连接走 b
+	conns[1].Close()
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	conns[1] = conn
+	if n := len(servers[1].Targets()); n != 2 {
+		t.Errorf("活动连接最少的代理应收到拨号, 实际: %d", n)
+	}
+	if s := pm.GetMetrics().Upstreams["b"]; s.Active != 1 || s.Dials != 2 {
+		t.Errorf("命名代理 b 的统计错误: %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	pm.Metrics.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if prom := rec.Body.String(); !strings.Contains(prom, `gohookproxy_upstream_active_connections{proxy="b"} 1`) {
+		t.Errorf("Prometheus 输出缺少上游代理的活动连接数:\n%s", prom)
+	}
+}
+
+// TestBalanceWeighted 测试加权轮询按权重分配拨号
+func TestBalanceWeighted(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	servers := []*proxytest.Server{
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewHTTPServer),
+	}
+	cfg := balanceConfig(C.BalanceWeighted, servers...)
+	cfg.Proxies[0].Weight = 2
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	for i := 0; i < 8; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i, err)
+		}
+		conn.Close()
+	}
+	// 主代理和 c 没有设置权重，按 1 计算
+	for i, want := range []int{2, 4, 2} {
+		if n := len(servers[i].Targets()); n != want {
+			t.Errorf("代理 %d 预期 %d 次拨号, 实际: %d", i, want, n)
+		}
+	}
+}
+
+// TestBalanceLatency 测试延迟策略优先使用握手最快的代理，跳过拨号失败的代理
+func TestBalanceLatency(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	servers := []*proxytest.Server{
+		startProxy(t, proxytest.NewSOCKSServer, proxytest.WithFault(proxytest.SlowHandshake), proxytest.WithDelay(20*time.Millisecond)),
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewHTTPServer),
+	}
+	pm, err := PM.New(balanceConfig(C.BalanceLatency, servers...))
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	// 每个代理先各试一次，之后不再使用慢的主代理
+	for i := 0; i < 9; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i, err)
+		}
+		conn.Close()
+	}
+	if n := len(servers[0].Targets()); n != 1 {
+		t.Errorf("慢的主代理预期 1 次拨号, 实际: %d", n)
+	}
+	upstreams := pm.GetMetrics().Upstreams
+	if upstreams[""].Latency <= upstreams["b"].Latency || upstreams["b"].Latency <= 0 {
+		t.Errorf("拨号耗时统计错误: %+v", upstreams)
+	}
+
+	// c 停止后最多失败一次，之后的拨号都走 b
+	servers[2].Close()
+	before := len(servers[1].Targets())
+	failures := 0
+	for i := 0; i < 4; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			failures++
+			continue
+		}
+		conn.Close()
+	}
+	if failures > 1 {
+		t.Errorf("失败的代理应被跳过, 实际失败 %d 次", failures)
+	}
+	if n := len(servers[1].Targets()) - before; n != 4-failures {
+		t.Errorf("代理 b 预期 %d 次拨号, 实际: %d", 4-failures, n)
+	}
+}
+
+// TestBalanceInvalid 测试负载均衡的配置检查
+func TestBalanceInvalid(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.Balance = &C.BalanceConfig{}
+	if err := cfg.Validate(); err == nil {
+		t.Error("没有命名代理时预期验证失败")
+	}
+
+	cfg.Proxies = []C.NamedProxy{{Name: "b", ProxyType: C.HTTP, ProxyIP: "127.0.0.1", ProxyPort: 8080}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("有效的配置验证失败: %v", err)
+	}
+	cfg.Balance = &C.BalanceConfig{Strategy: "random"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("未知的策略预期验证失败")
+	}
+	cfg.Balance = &C.BalanceConfig{Proxies: []string{"missing"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("未知的代理预期验证失败")
+	}
+	cfg.Balance = &C.BalanceConfig{Strategy: C.BalanceWeighted, PrimaryWeight: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("负的主代理权重预期验证失败")
+	}
+	cfg.Balance = &C.BalanceConfig{Strategy: C.BalanceWeighted}
+	cfg.Proxies[0].Weight = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("负的代理权重预期验证失败")
+	}
+}
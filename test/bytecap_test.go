@@ -0,0 +1,98 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+)
+
+// withByteCap 为目标 127.0.0.1 设置带流量上限的规则
+func withByteCap(rule C.Rule) func(*C.Config) {
+	rule.Pattern = "127.0.0.1"
+	return func(cfg *C.Config) {
+		cfg.Rules = []C.Rule{rule}
+	}
+}
+
+func TestByteCapPerConnection(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newTestManager(t, C.HTTP, proxyIP, proxyPort, withMetrics, withByteCap(C.Rule{MaxConnBytes: 8}))
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	// 写入 4 字节、读回 4 字节后用完上限
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if n, err := conn.Write([]byte("more")); n != 0 || !errors.Is(err, E.ErrByteCapExceeded) {
+		t.Errorf("预期超出单连接上限, 实际: %d %v", n, err)
+	}
+	if pm.Metrics.GetSnapshot().ByteCaps.Closed != 1 {
+		t.Errorf("关闭的连接数不符: %+v", pm.Metrics.GetSnapshot().ByteCaps)
+	}
+
+	// 单连接上限不影响新的连接
+	other, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("新连接失败: %v", err)
+	}
+	defer other.Close()
+	if n, err := other.Write([]byte("0123456789")); n != 8 || !errors.Is(err, E.ErrByteCapExceeded) {
+		t.Errorf("超出上限的写入应只写出剩余字节, 实际: %d %v", n, err)
+	}
+}
+
+func TestByteCapDaily(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newClockManager(t, fake, C.HTTP, proxyIP, proxyPort, withMetrics, withByteCap(C.Rule{MaxDailyBytes: 8}))
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if _, err := conn.Read(buf); !errors.Is(err, E.ErrByteCapExceeded) {
+		t.Errorf("当天流量用完后读取应失败, 实际: %v", err)
+	}
+
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrByteCapExceeded) {
+		t.Errorf("当天流量用完后应拒绝拨号, 实际: %v", err)
+	}
+	caps := pm.Metrics.GetSnapshot().ByteCaps
+	if caps.Blocked != 1 || caps.Daily["127.0.0.1"] != 8 {
+		t.Errorf("流量上限统计不符: %+v", caps)
+	}
+
+	// 第二天重新计算
+	fake.Advance(24 * time.Hour)
+	conn, err = pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("第二天连接失败: %v", err)
+	}
+	conn.Close()
+	if used := pm.Metrics.GetSnapshot().ByteCaps.Daily["127.0.0.1"]; used != 0 {
+		t.Errorf("第二天用量应清零, 实际: %d", used)
+	}
+}
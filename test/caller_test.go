@@ -0,0 +1,60 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestBypassPackages 测试调用栈中有 BypassPackages 中的包时 hook 拨号直连
+func TestBypassPackages(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	tests := []struct {
+		name     string
+		packages []string
+		proxied  bool
+	}{
+		{"本包", []string{"github.com/ba0gu0/GoHookProxy/test"}, false},
+		{"父路径", []string{"example.com/sdk", "github.com/ba0gu0/GoHookProxy"}, false},
+		{"不完整的路径", []string{"github.com/ba0gu0/GoHookProxy/tes"}, true},
+		{"未配置", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.BypassPrivate = false // 测试目标都在本机
+			cfg.ProxyType = C.HTTP
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+			cfg.BypassPackages = tt.packages
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			before := len(srv.Targets())
+			conn, err := hook.New(pm).DialContext(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("连接 %s 失败: %v", echoAddr, err)
+			}
+			conn.Close()
+			if proxied := len(srv.Targets()) > before; proxied != tt.proxied {
+				t.Errorf("预期经过代理: %v, 实际: %v", tt.proxied, proxied)
+			}
+		})
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.BypassPackages = []string{"example.com/sdk/"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("以 / 结尾的包路径应校验失败")
+	}
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/ba0gu0/GoHookProxy/hook"
+)
+
+// TestCapabilities 测试平台能力报告与当前构建一致
+func TestCapabilities(t *testing.T) {
+	caps := hook.Capabilities()
+	if caps.OS != runtime.GOOS || caps.Arch != runtime.GOARCH {
+		t.Errorf("平台应为 %s/%s, 实际: %s/%s", runtime.GOOS, runtime.GOARCH, caps.OS, caps.Arch)
+	}
+	if !hook.Patched && caps.Patching {
+		t.Error("nohook 构建不应报告支持运行时补丁")
+	}
+	if !caps.Patching && caps.PatchingNote == "" {
+		t.Error("不支持运行时补丁时应说明原因")
+	}
+	if runtime.GOOS == "linux" && (!caps.Splice || !caps.DSCP || !caps.SelfDetection) {
+		t.Errorf("Linux 应支持 splice、DSCP 和自连接识别, 实际: %+v", caps)
+	}
+	if runtime.GOOS != "linux" && (caps.SOMark || caps.EBPF) {
+		t.Errorf("SO_MARK 和 eBPF 只在 Linux 上可用, 实际: %+v", caps)
+	}
+	if again := hook.Capabilities(); again != caps {
+		t.Error("探测结果应被缓存")
+	}
+}
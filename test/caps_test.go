@@ -0,0 +1,146 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// withCapabilityTTL 同时代理 UDP 并设置能力缓存的有效期
+func withCapabilityTTL(ttl time.Duration) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.HookUDP = true
+		cfg.CapabilityTTL = ttl
+	}
+}
+
+// resetCapabilities 清空全局能力缓存，测试结束时再次清空
+func resetCapabilities(t *testing.T) {
+	PM.ResetCapabilities()
+	t.Cleanup(PM.ResetCapabilities)
+}
+
+func TestCapabilityUDPAssociate(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyCommandNotSupported))
+	resetCapabilities(t)
+	pm := newTestManager(t, C.SOCKS5, srv.Host(), srv.Port(), withCapabilityTTL(time.Minute))
+
+	for i := 0; i < 2; i++ {
+		_, err := pm.ListenPacket(context.Background(), "udp")
+		if !errors.Is(err, E.ErrSOCKS5CommandNotSupported) {
+			t.Fatalf("第 %d 次预期不支持 UDP ASSOCIATE, 实际: %v", i+1, err)
+		}
+	}
+	// 第二次由缓存直接拒绝，不再连接代理
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("预期只连接代理 1 次, 实际: %d", got)
+	}
+	if caps := PM.ProxyCapabilities(srv.Addr()); caps[PM.CapUDPAssociate] {
+		t.Errorf("能力缓存应记录不支持 UDP ASSOCIATE: %v", caps)
+	}
+}
+
+func TestCapabilityNoAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAuth("user", "pass"))
+	resetCapabilities(t)
+	pm := newTestManager(t, C.SOCKS5, srv.Host(), srv.Port(), withCapabilityTTL(time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrSOCKS5NoAcceptableMethods) {
+			t.Fatalf("第 %d 次预期无认证被拒绝, 实际: %v", i+1, err)
+		}
+	}
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("预期只连接代理 1 次, 实际: %d", got)
+	}
+
+}
+
+func TestCapabilityIPv6(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyAddrTypeNotSupported))
+	resetCapabilities(t)
+	pm := newTestManager(t, C.SOCKS5, srv.Host(), srv.Port(), withCapabilityTTL(time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if _, err := pm.Dial("tcp", "[::1]:80"); !errors.Is(err, E.ErrSOCKS5AddressTypeNotSupported) {
+			t.Fatalf("第 %d 次预期不支持 IPv6 地址, 实际: %v", i+1, err)
+		}
+	}
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("预期只连接代理 1 次, 实际: %d", got)
+	}
+
+	// IPv4 目标仍然发起握手
+	pm.Dial("tcp", "127.0.0.1:80")
+	if got := srv.Accepted(); got != 2 {
+		t.Errorf("IPv4 目标不应被缓存拦截, 连接次数: %d", got)
+	}
+}
+
+func TestCapabilityTTL(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyAddrTypeNotSupported))
+	resetCapabilities(t)
+	pm := newTestManager(t, C.SOCKS5, srv.Host(), srv.Port(), withCapabilityTTL(50*time.Millisecond))
+
+	pm.Dial("tcp", "[::1]:80")
+	pm.Dial("tcp", "[::1]:80")
+	if got := srv.Accepted(); got != 1 {
+		t.Fatalf("TTL 内预期只连接代理 1 次, 实际: %d", got)
+	}
+
+	// 过期后重新探测
+	time.Sleep(100 * time.Millisecond)
+	if caps := PM.ProxyCapabilities(srv.Addr()); len(caps) != 0 {
+		t.Errorf("过期的能力记录应被忽略: %v", caps)
+	}
+	pm.Dial("tcp", "[::1]:80")
+	if got := srv.Accepted(); got != 2 {
+		t.Errorf("过期后预期重新连接代理, 连接次数: %d", got)
+	}
+}
+
+func TestCapabilityHTTP2Fallback(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPSServer)
+	PM.ResetCapabilities()
+	t.Cleanup(PM.ResetCapabilities)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP2
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	t.Cleanup(func() { pm.GetDialer().(*PM.HTTPProxyDialer).Close() })
+
+	// 代理只协商 http/1.1，改用 TLS 上的 HTTP/1.1 CONNECT
+	for i := 0; i < 2; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i+1, err)
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("回显失败: %q, %v", buf, err)
+		}
+		conn.Close()
+	}
+	caps := PM.ProxyCapabilities(srv.Addr())
+	if supported, ok := caps[PM.CapHTTP2Connect]; !ok || supported {
+		t.Errorf("能力缓存应记录不支持 h2: %v", caps)
+	}
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	start := fake.Now()
+
+	late := fake.NewTimer(2 * time.Second)
+	early := fake.NewTimer(time.Second)
+	stopped := fake.NewTimer(time.Second)
+	ticker := fake.NewTicker(time.Second)
+	if !stopped.Stop() {
+		t.Error("未触发的定时器 Stop 应返回 true")
+	}
+
+	fake.Advance(1500 * time.Millisecond)
+	select {
+	case at := <-early.C():
+		if at != start.Add(time.Second) {
+			t.Errorf("定时器触发时间不符: %v", at)
+		}
+	default:
+		t.Fatal("到期的定时器没有触发")
+	}
+	select {
+	case <-late.C():
+		t.Fatal("未到期的定时器不应触发")
+	case <-stopped.C():
+		t.Fatal("已停止的定时器不应触发")
+	default:
+	}
+	<-ticker.C()
+
+	fake.Advance(time.Second)
+	<-late.C()
+	<-ticker.C()
+	ticker.Stop()
+	if got := fake.Since(start); got != 2500*time.Millisecond {
+		t.Errorf("Since 不符: %v", got)
+	}
+	if n := fake.Waiters(); n != 0 {
+		t.Errorf("预期没有未触发的等待, 实际: %d", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		fake.Sleep(time.Minute)
+		close(done)
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	<-done
+}
+
+func TestQuotaPeriodFakeClock(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	fake := clock.NewFake(time.Time{})
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.Quotas = []C.Quota{{Label: "tenant", MaxBytes: 4, Period: time.Hour, Action: C.QuotaBlock}}
+
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	conn, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if _, err := conn.Write([]byte("again")); !errors.Is(err, E.ErrQuotaExceeded) {
+		t.Fatalf("预期流量超出配额, 实际: %v", err)
+	}
+
+	// 进入下一个周期后流量清零
+	fake.Advance(time.Hour + time.Second)
+	if _, err := conn.Write([]byte("next")); err != nil {
+		t.Errorf("新周期写入失败: %v", err)
+	}
+}
+
+// TestCapabilityCacheRealClock 测试能力缓存由所有管理器共用，不受管理器的假时钟影响
+func TestCapabilityCacheRealClock(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyAddrTypeNotSupported))
+	PM.ResetCapabilities()
+	t.Cleanup(PM.ResetCapabilities)
+	fake := clock.NewFake(time.Time{})
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.CapabilityTTL = time.Hour
+
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pm.Dial("tcp", "[::1]:80")
+	fake.Advance(2 * time.Hour)
+	pm.Dial("tcp", "[::1]:80")
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("推进假时钟不应使能力缓存过期, 连接次数: %d", got)
+	}
+}
+
+func TestStartupProbeFakeClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	fake := clock.NewFake(time.Time{})
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = C.UnixSocketPrefix + path
+	cfg.StartupPolicy = C.StartupDirectUntilHealthy
+	cfg.StartupProbeInterval = time.Minute
+
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pm.Startup(ctx); err != nil {
+		t.Fatalf("direct_until_healthy 不应返回错误: %v", err)
+	}
+
+	// 探测间隔只按假时间计算
+	startProxy(t, proxytest.NewSOCKSServer, proxytest.WithUnix(path))
+	fake.BlockUntil(1)
+	time.Sleep(20 * time.Millisecond)
+	if !pm.WaitingForProxy() {
+		t.Fatal("假时间未推进时不应进行探测")
+	}
+
+	fake.Advance(time.Minute)
+	deadline := time.Now().Add(2 * time.Second)
+	for pm.WaitingForProxy() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if pm.WaitingForProxy() {
+		t.Fatal("推进一个探测间隔后应结束直连阶段")
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigParse(t *testing.T) {
+	data := []byte(`
+enable: true
+proxy_type: socks5
+proxy_ip: 127.0.0.1
+proxy_port: 1080
+socks:
+  timeout: 10s
+`)
+
+	cfg, err := C.Parse(data)
+	if err != nil {
+		t.Fatalf("解析配置失败: %v", err)
+	}
+
+	if cfg.SOCKSConfig.Timeout != 10*time.Second {
+		t.Errorf("预期超时 10s, 实际: %v", cfg.SOCKSConfig.Timeout)
+	}
+	// 未设置的字段保留默认值
+	if cfg.SOCKSConfig.KeepAlive != C.DefaultSOCKSKeepAlive {
+		t.Errorf("预期默认 keepalive, 实际: %v", cfg.SOCKSConfig.KeepAlive)
+	}
+	if cfg.HTTPConfig == nil || cfg.HTTPConfig.Timeout != C.DefaultHTTPTimeout {
+		t.Error("预期 HTTP 配置使用默认值")
+	}
+}
+
+func TestConfigParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"未知字段": "enable: true\nproxy_typo: socks5\n",
+		"无效端口": "enable: true\nproxy_type: socks5\nproxy_ip: 127.0.0.1\nproxy_port: 70000\n",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := C.Parse([]byte(data)); err == nil {
+				t.Fatal("预期应该返回错误，但没有")
+			}
+		})
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	data, err := C.Schema()
+	if err != nil {
+		t.Fatalf("生成 Schema 失败: %v", err)
+	}
+
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema 不是有效的 JSON: %v", err)
+	}
+
+	for _, key := range []string{"proxy_type", "http", "socks", "tls_rules"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("Schema 缺少字段 %s", key)
+		}
+	}
+}
+
+func TestConfigMigrate(t *testing.T) {
+	old := &C.Config{
+		Enable:      true,
+		ProxyType:   C.SOCKS5,
+		ProxyIP:     "127.0.0.1",
+		ProxyPort:   1080,
+		SOCKSConfig: &C.SOCKSConfig{EnableUDP: true},
+	}
+
+	cfg, warnings, err := C.Migrate(old)
+	if err != nil {
+		t.Fatalf("迁移配置失败: %v", err)
+	}
+	if cfg.Version != C.CurrentVersion {
+		t.Errorf("预期版本 %d, 实际: %d", C.CurrentVersion, cfg.Version)
+	}
+	if cfg.HookUDP || !cfg.SOCKSConfig.EnableUDP {
+		t.Error("预期 enable_udp 作为弃用字段保留，不开启 hook_udp")
+	}
+	if len(warnings) != 1 || warnings[0].Field != "socks.enable_udp" {
+		t.Errorf("预期一条 enable_udp 警告, 实际: %v", warnings)
+	}
+	// 不修改原配置
+	if old.Version != 0 {
+		t.Error("迁移不应修改原配置")
+	}
+
+	if _, _, err := C.Migrate(&C.Config{Version: C.CurrentVersion + 1}); err == nil {
+		t.Error("预期不支持的版本应该返回错误")
+	}
+}
+
+func TestConfigParseLegacy(t *testing.T) {
+	data := []byte("enable: true\nproxy_type: direct\n")
+
+	cfg, warnings, err := C.ParseWithWarnings(data)
+	if err != nil {
+		t.Fatalf("解析旧版本配置失败: %v", err)
+	}
+	if cfg.Enable {
+		t.Error("预期 direct 类型迁移后不启用代理")
+	}
+	if len(warnings) != 1 {
+		t.Errorf("预期一条警告, 实际: %v", warnings)
+	}
+
+	// 只有 PAC 脚本的 direct 配置保持启用，保存后重新加载不变
+	data = []byte("enable: true\nproxy_type: direct\npac_url: http://wpad.corp/wpad.dat\n")
+	cfg, warnings, err = C.ParseWithWarnings(data)
+	if err != nil {
+		t.Fatalf("解析 PAC 配置失败: %v", err)
+	}
+	if !cfg.Enable || cfg.PACURL != "http://wpad.corp/wpad.dat" || len(warnings) != 0 {
+		t.Errorf("预期 PAC 配置保持启用且没有警告, 实际: enable=%v pac_url=%q %v", cfg.Enable, cfg.PACURL, warnings)
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("序列化配置失败: %v", err)
+	}
+	if again, err := C.Parse(out); err != nil || !again.Enable || again.PACURL != cfg.PACURL {
+		t.Errorf("重新加载 PAC 配置应保持启用, 实际: %+v, %v", again, err)
+	}
+
+	// 当前版本的配置不会被迁移
+	data = []byte("version: 1\nenable: true\nproxy_type: direct\n")
+	if _, err := C.Parse(data); err == nil {
+		t.Error("预期当前版本的无效配置返回错误")
+	}
+}
@@ -0,0 +1,188 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/proxy/masque"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// withConnectUDP 通过 HTTP 代理转发 UDP，使用 user 和 pass 认证
+func withConnectUDP(user, pass string) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.HookUDP = true
+		cfg.HTTPConfig.SkipVerify = true
+		cfg.HTTPConfig.User = user
+		cfg.HTTPConfig.Pass = pass
+	}
+}
+
+// udpEcho 发送数据报并读回回显
+func udpEcho(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], payload) {
+		t.Fatalf("数据报回显失败: %q, %v", buf[:n], err)
+	}
+}
+
+// TestConnectUDPHTTP3 测试 HTTP/3 代理以 QUIC 数据报转发 UDP，ListenPacket 为每个目标建立请求
+func TestConnectUDPHTTP3(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startHTTP3Proxy(t, proxytest.WithAuth("user", "secret"))
+	pm := newTestManager(t, C.HTTP3, srv.Host(), srv.Port(), withConnectUDP("user", "secret"))
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 HTTP/3 代理建立 UDP 连接失败: %v", err)
+	}
+	defer conn.Close()
+	udpEcho(t, conn, []byte("ping"))
+	udpEcho(t, conn, bytes.Repeat([]byte("x"), 1000))
+	if conn.RemoteAddr().String() != echoAddr {
+		t.Errorf("RemoteAddr 应为目标地址, 实际: %v", conn.RemoteAddr())
+	}
+
+	pc, err := pm.ListenPacket(t.Context(), "udp")
+	if err != nil {
+		t.Fatalf("建立不固定目标的 UDP 套接字失败: %v", err)
+	}
+	defer pc.Close()
+	for _, addr := range []string{startUDPEchoServer(t), startUDPEchoServer(t)} {
+		dst, _ := net.ResolveUDPAddr("udp", addr)
+		if _, err := pc.WriteTo([]byte(addr), dst); err != nil {
+			t.Fatalf("WriteTo %s 失败: %v", addr, err)
+		}
+		buf := make([]byte, 64)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != addr || from.String() != addr {
+			t.Errorf("ReadFrom 应返回回显和目标地址 %s, 实际: %q %v %v", addr, buf[:n], from, err)
+		}
+	}
+	if targets := srv.Targets(); len(targets) != 3 || targets[0] != echoAddr {
+		t.Errorf("代理应为每个目标收到一个 connect-udp 请求, 实际: %v", targets)
+	}
+	if srv.Accepted() != 1 {
+		t.Errorf("所有请求应共用一个 QUIC 会话, 实际: %d", srv.Accepted())
+	}
+}
+
+// TestConnectUDPHTTP2 测试 HTTP/2 代理以 DATAGRAM capsule 转发 UDP 和认证失败
+func TestConnectUDPHTTP2(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewConnectUDPServer, proxytest.WithAuth("user", "secret"))
+	pm := newTestManager(t, C.HTTP2, srv.Host(), srv.Port(), withConnectUDP("user", "secret"))
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 HTTP/2 代理建立 UDP 连接失败: %v", err)
+	}
+	udpEcho(t, conn, []byte("ping"))
+	udpEcho(t, conn, bytes.Repeat([]byte("y"), 1400))
+	conn.Close()
+	if _, err := conn.Write([]byte("ping")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("关闭后写入应返回 net.ErrClosed, 实际: %v", err)
+	}
+	if users := srv.Users(); len(users) != 1 || users[0] != "user" {
+		t.Errorf("代理应收到 Proxy-Authorization, 实际: %v", users)
+	}
+
+	pm = newTestManager(t, C.HTTP2, srv.Host(), srv.Port(), withConnectUDP("user", "wrong"))
+	if _, err := pm.Dial("udp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Errorf("认证失败应返回 ErrHTTPProxyAuth, 实际: %v", err)
+	}
+}
+
+// TestConnectUDPQueueDrop 测试接收队列满时丢弃的数据报计入 QueueDrops 而不是 OversizedDrops
+func TestConnectUDPQueueDrop(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewConnectUDPServer)
+	pm := newTestManager(t, C.HTTP2, srv.Host(), srv.Port(), withConnectUDP("", ""))
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 HTTP/2 代理建立 UDP 连接失败: %v", err)
+	}
+	defer conn.Close()
+	udpEcho(t, conn, []byte("ping"))
+
+	// 不读取回显，超过接收队列的数据报被丢弃
+	for i := 0; i < 200; i++ {
+		if _, err := conn.Write([]byte("flood")); err != nil {
+			t.Fatalf("发送数据报失败: %v", err)
+		}
+	}
+	stats := conn.(interface{ Stats() metrics.UDPStats }).Stats
+	deadline := time.Now().Add(5 * time.Second)
+	for stats().QueueDrops == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := stats(); s.QueueDrops == 0 || s.OversizedDrops != 0 {
+		t.Errorf("队列满应计入 QueueDrops, 实际: %+v", s)
+	}
+}
+
+// TestConnectUDPUnsupported 测试代理没有开启扩展 CONNECT 和 HTTP/1.1 代理时返回 ErrConnectUDPUnsupported
+func TestConnectUDPUnsupported(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+
+	// net/http 的 HTTP/2 服务默认不开启扩展 CONNECT
+	host, port := startHTTP2Proxy(t)
+	pm := newTestManager(t, C.HTTP2, host, port, withConnectUDP("", ""))
+	if _, err := pm.Dial("udp", echoAddr); !errors.Is(err, E.ErrConnectUDPUnsupported) {
+		t.Errorf("代理不支持扩展 CONNECT 应返回 ErrConnectUDPUnsupported, 实际: %v", err)
+	}
+
+	srv := startProxy(t, proxytest.NewHTTPSServer)
+	pm = newTestManager(t, C.HTTPS, srv.Host(), srv.Port(), withConnectUDP("", ""))
+	if _, err := pm.Dial("udp", echoAddr); !errors.Is(err, E.ErrConnectUDPUnsupported) {
+		t.Errorf("https 代理转发 UDP 应返回 ErrConnectUDPUnsupported, 实际: %v", err)
+	}
+	if _, err := pm.ListenPacket(t.Context(), "udp"); !errors.Is(err, E.ErrConnectUDPUnsupported) {
+		t.Errorf("https 代理的 ListenPacket 应返回 ErrConnectUDPUnsupported, 实际: %v", err)
+	}
+}
+
+// TestConnectUDPMasque 测试 URI 模板展开和 capsule 编解码
+func TestConnectUDPMasque(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:53":          "/.well-known/masque/udp/192.0.2.1/53/",
+		"[2001:db8::1]:443":     "/.well-known/masque/udp/2001%3Adb8%3A%3A1/443/",
+		"DNS.Example.com.:5353": "/.well-known/masque/udp/dns.example.com/5353/",
+	} {
+		path, err := masque.Path(addr)
+		if err != nil || path != want {
+			t.Errorf("%s 的路径应为 %s, 实际: %s, %v", addr, want, path, err)
+		}
+	}
+	if target, err := masque.ParsePath("/.well-known/masque/udp/2001:db8::1/443/"); err != nil || target != "[2001:db8::1]:443" {
+		t.Errorf("解码后的路径应解析为目标地址, 实际: %s, %v", target, err)
+	}
+	if _, err := masque.ParsePath("/.well-known/masque/udp/example.com/0/"); !errors.Is(err, E.ErrConnectUDPMessage) {
+		t.Errorf("无效端口应返回 ErrConnectUDPMessage, 实际: %v", err)
+	}
+
+	var buf bytes.Buffer
+	http3.WriteCapsule(&buf, 0x1234, []byte("unknown"))
+	http3.WriteCapsule(&buf, masque.CapsuleDatagram, []byte{1, 'x'}) // 上下文 ID 1
+	masque.WriteDatagramCapsule(&buf, []byte("payload"))
+	payload, err := masque.ReadDatagramCapsule(bufio.NewReader(&buf))
+	if err != nil || string(payload) != "payload" {
+		t.Errorf("应跳过未知 capsule 和其他上下文 ID 的数据报, 实际: %q, %v", payload, err)
+	}
+}
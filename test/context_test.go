@@ -0,0 +1,99 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// TestDirectDialContextNoLeak 测试长期存在的 ctx 上直连拨号，连接关闭后不残留 goroutine
+func TestDirectDialContextNoLeak(t *testing.T) {
+	echo := startEchoServer(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = false
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		conn, err := h.DialContext(ctx, "tcp", echo)
+		if err != nil {
+			t.Fatalf("直连失败: %v", err)
+		}
+		conn.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Errorf("连接关闭后 goroutine 增加: %d -> %d", before, after)
+	}
+
+	// ctx 结束时仍然打开的连接被关闭
+	conn, err := h.DialContext(ctx, "tcp", echo)
+	if err != nil {
+		t.Fatalf("直连失败: %v", err)
+	}
+	defer conn.Close()
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("预期 ctx 结束后读取失败")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ctx 结束后连接没有被关闭")
+	}
+}
+
+// TestHandshakeContextCancel 测试代理握手卡住时 ctx 取消能立即打断握手
+func TestHandshakeContextCancel(t *testing.T) {
+	proxyIP, proxyPort := startBlackhole(t)
+
+	for _, proxyType := range []C.ProxyType{C.SOCKS5, C.HTTP} {
+		t.Run(string(proxyType), func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = proxyType
+			cfg.ProxyIP = proxyIP
+			cfg.ProxyPort = proxyPort
+			cfg.SOCKSConfig.Timeout = 10 * time.Second
+			cfg.HTTPConfig.Timeout = 10 * time.Second
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			start := time.Now()
+			_, err = pm.DialContext(ctx, "tcp", "example.com:80")
+			if !errors.Is(err, E.ErrContextCanceled) {
+				t.Errorf("预期 ErrContextCanceled, 实际: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("预期取消后立即返回, 实际耗时: %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestNewDirectManaged(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	// 代理设置被忽略，规则的流量上限和标签统计照常生效
+	cfg := C.DefaultConfig()
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 1080
+	cfg.MetricsEnable = true
+	cfg.Rules = []C.Rule{{Pattern: "127.0.0.1", MaxConnBytes: 8}}
+
+	pm, err := PM.NewDirectManaged(cfg)
+	if err != nil {
+		t.Fatalf("创建直连管理器失败: %v", err)
+	}
+	if pm.Config.ProxyType != C.Direct || cfg.ProxyType != C.SOCKS5 {
+		t.Errorf("应使用直连且不修改传入的配置: %s %s", pm.Config.ProxyType, cfg.ProxyType)
+	}
+	if err := pm.Startup(context.Background()); err != nil {
+		t.Errorf("直连不需要启动探测: %v", err)
+	}
+
+	ctx := PM.WithLabels(context.Background(), map[string]string{"tenant": "acme"})
+	conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连失败: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("读取失败: %q %v", buf, err)
+	}
+	if _, err := conn.Write([]byte("more")); !errors.Is(err, E.ErrByteCapExceeded) {
+		t.Errorf("预期超出单连接上限, 实际: %v", err)
+	}
+
+	snapshot := pm.Metrics.GetSnapshot()
+	if snapshot.TotalConnections == 0 || snapshot.ActiveConnections != 1 {
+		t.Errorf("直连应记录连接指标: total=%d active=%d", snapshot.TotalConnections, snapshot.ActiveConnections)
+	}
+	if len(snapshot.LabelStats) != 1 {
+		t.Errorf("直连应按标签统计: %+v", snapshot.LabelStats)
+	}
+
+	pc, err := pm.ListenPacket(context.Background(), "udp")
+	if err != nil {
+		t.Fatalf("直连 UDP 套接字失败: %v", err)
+	}
+	pc.Close()
+}
+
+func TestDirectDialerBypassesHook(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+
+	// 代理端口没有监听，经过 hook 的拨号都会失败
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, deadPort, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort, _ = strconv.Atoi(deadPort)
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	defer h.Disable()
+
+	target := net.JoinHostPort("localhost", echoPort)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if hook.Patched {
+		if conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target); err == nil {
+			conn.Close()
+			t.Fatal("hook 启用后拨号应经过不可用的代理")
+		}
+	}
+
+	conn, err := PM.DirectDialer().DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatalf("DirectDialer 应绕过 hook 直连: %v", err)
+	}
+	defer conn.Close()
+	sshEcho(t, conn)
+
+	if _, ok := PM.DirectDialer().(PM.PacketDialer); !ok {
+		t.Error("DirectDialer 应支持 UDP")
+	}
+}
+
+// stuckResolver 忽略 ctx、直到 release 关闭才返回的解析器
+type stuckResolver struct {
+	release chan struct{}
+}
+
+func (r stuckResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	<-r.release
+	return nil, errors.New("released")
+}
+
+// TestDirectDialTimeout 测试未启用代理时直连拨号按 DirectDialTimeout 和 ctx 的截止时间返回，不使用 IdleTimeout
+func TestDirectDialTimeout(t *testing.T) {
+	cfg := C.DefaultConfig()
+	if cfg.DirectDialTimeout != C.DefaultDirectDialTimeout || cfg.DirectDialTimeout >= cfg.IdleTimeout {
+		t.Errorf("默认的直连超时应独立于 IdleTimeout: %v", cfg.DirectDialTimeout)
+	}
+	cfg.DirectDialTimeout = 100 * time.Millisecond
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	stuck := stuckResolver{release: make(chan struct{})}
+	defer close(stuck.release)
+	pm.SetResolver(stuck)
+
+	start := time.Now()
+	if _, err := pm.Dial("tcp", "stuck.example:80"); !errors.Is(err, E.ErrConnectionTimeout) {
+		t.Errorf("超过 DirectDialTimeout 预期 ErrConnectionTimeout, 实际: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("拨号应在 DirectDialTimeout 后返回, 实际耗时: %v", elapsed)
+	}
+	if _, err := pm.DialDirect(context.Background(), "tcp", "stuck.example:80"); !errors.Is(err, E.ErrConnectionTimeout) {
+		t.Errorf("DialDirect 预期 ErrConnectionTimeout, 实际: %v", err)
+	}
+
+	// ctx 的截止时间更早时以 ctx 为准
+	cfg.DirectDialTimeout = time.Hour
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pm.DialContext(ctx, "tcp", "stuck.example:80"); !errors.Is(err, E.ErrContextDeadlineExceeded) {
+		t.Errorf("ctx 超时预期 ErrContextDeadlineExceeded, 实际: %v", err)
+	}
+	if _, err := pm.DialDirect(ctx, "tcp", "stuck.example:80"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialDirect 预期 context.DeadlineExceeded, 实际: %v", err)
+	}
+
+	cfg.DirectDialTimeout = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("负的 DirectDialTimeout 应验证失败")
+	}
+}
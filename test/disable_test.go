@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// newSlowHook 创建通过慢速握手 SOCKS5 代理拨号并已启用的 hook
+// 代理拒绝 CONNECT 而不连接目标，避免代理自身的出站连接被补丁拦截
+func newSlowHook(t *testing.T, delay, disableTimeout time.Duration) (*hook.Hook, *proxytest.Server) {
+	t.Helper()
+	srv := startProxy(t, proxytest.NewSOCKSServer,
+		proxytest.WithFault(proxytest.SlowHandshake), proxytest.WithDelay(delay),
+		proxytest.WithReplyCode(socks.ReplyConnectionRefused))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.DisableTimeout = disableTimeout
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	t.Cleanup(func() { h.Disable() })
+	return h, srv
+}
+
+// startInFlightDial 在后台拨号，等到拨号进入代理握手后返回
+func startInFlightDial(t *testing.T, h *hook.Hook, addr string) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() {
+		conn, err := h.DialContext(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		result <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for h.InFlight() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("拨号未进入进行中状态")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return result
+}
+
+func TestDisableWaitsForInFlightDials(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	h, srv := newSlowHook(t, 50*time.Millisecond, 5*time.Second)
+
+	inflight := startInFlightDial(t, h, echoAddr)
+	disabled := make(chan error, 1)
+	go func() { disabled <- h.Disable() }()
+	time.Sleep(20 * time.Millisecond)
+
+	// Disable 进行中的新拨号直连
+	conn, err := h.DialContext(context.Background(), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("Disable 期间直连失败: %v", err)
+	}
+	conn.Close()
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("Disable 期间的拨号不应经过代理, 代理连接次数: %d", got)
+	}
+
+	select {
+	case err := <-disabled:
+		t.Fatalf("Disable 应等待进行中的拨号, 提前返回: %v", err)
+	default:
+	}
+
+	// 进行中的拨号照常完成握手，得到代理的拒绝响应
+	if err := <-inflight; !errors.Is(err, E.ErrSOCKSConnectFailed) {
+		t.Fatalf("进行中的拨号应完成代理握手, 实际: %v", err)
+	}
+	select {
+	case err := <-disabled:
+		if err != nil {
+			t.Errorf("Disable 失败: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("拨号结束后 Disable 未返回")
+	}
+	if n := h.InFlight(); n != 0 {
+		t.Errorf("Disable 后不应有进行中的拨号: %d", n)
+	}
+}
+
+func TestDisableTimeout(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	h, _ := newSlowHook(t, 100*time.Millisecond, 50*time.Millisecond)
+
+	inflight := startInFlightDial(t, h, echoAddr)
+	start := time.Now()
+	err := h.Disable()
+	if !errors.Is(err, E.ErrHookDrainTimeout) {
+		t.Errorf("预期等待超时, 实际: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Disable 等待时间超过 DisableTimeout: %v", elapsed)
+	}
+	<-inflight
+}
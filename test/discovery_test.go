@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/discovery"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// unusedAddr 返回一个没有监听的本地地址
+func unusedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func mustCandidate(t *testing.T, s string) discovery.Candidate {
+	c, err := discovery.ParseCandidate(s)
+	if err != nil {
+		t.Fatalf("解析候选 %q 失败: %v", s, err)
+	}
+	return c
+}
+
+func TestDiscover(t *testing.T) {
+	socks := startProxy(t, proxytest.NewSOCKSServer)
+	httpProxy := startProxy(t, proxytest.NewHTTPServer)
+
+	candidates := []discovery.Candidate{
+		mustCandidate(t, "socks5://"+unusedAddr(t)),    // 没有监听
+		mustCandidate(t, "socks5://"+httpProxy.Addr()), // 协议不匹配
+		mustCandidate(t, "socks5://"+socks.Addr()),
+		mustCandidate(t, "http://"+httpProxy.Addr()),
+	}
+
+	c, err := discovery.Discover(context.Background(), candidates, 0)
+	if err != nil {
+		t.Fatalf("探测失败: %v", err)
+	}
+	if c != candidates[2] {
+		t.Errorf("预期选择 %s, 实际: %s", candidates[2], c)
+	}
+
+	if _, err := discovery.Discover(context.Background(), candidates[:2], 0); !errors.Is(err, E.ErrProxyNotFound) {
+		t.Errorf("预期没有可用代理, 实际: %v", err)
+	}
+
+	for _, s := range []string{"unknown", "ftp://127.0.0.1:21", "socks5://127.0.0.1"} {
+		if _, err := discovery.ParseCandidate(s); err == nil {
+			t.Errorf("预期候选 %q 无效", s)
+		}
+	}
+}
+
+func TestDiscoverEarlyReturn(t *testing.T) {
+	socks := startProxy(t, proxytest.NewSOCKSServer)
+	host, port := startBlackhole(t)
+	hole := discovery.Candidate{Name: "blackhole", ProxyType: C.SOCKS5, ProxyIP: host, ProxyPort: port}
+
+	// 偏好靠前的候选可用时不等待卡住的候选
+	start := time.Now()
+	c, err := discovery.Discover(context.Background(), []discovery.Candidate{mustCandidate(t, "socks5://"+socks.Addr()), hole}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("探测失败: %v", err)
+	}
+	if c.ProxyPort != socks.Port() {
+		t.Errorf("预期选择 SOCKS5 代理, 实际: %s", c)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("预期不等待卡住的候选, 耗时: %v", elapsed)
+	}
+
+	// 偏好靠前的候选卡住时，总体期限结束探测
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := discovery.Discover(ctx, []discovery.Candidate{hole, mustCandidate(t, "socks5://"+socks.Addr())}, 5*time.Second); !errors.Is(err, E.ErrProxyNotFound) {
+		t.Errorf("预期没有可用代理, 实际: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("预期在总体期限内返回, 耗时: %v", elapsed)
+	}
+}
+
+func TestAutoProxyType(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	httpProxy := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.Auto
+	cfg.Discovery = []string{"socks5://" + unusedAddr(t), "http://" + httpProxy.Addr()}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if pm.Config.ProxyType != C.HTTP || pm.Config.ProxyPort != httpProxy.Port() {
+		t.Errorf("预期选择 HTTP 代理, 实际: %s:%d", pm.Config.ProxyType, pm.Config.ProxyPort)
+	}
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	conn.Close()
+
+	if targets := httpProxy.Targets(); len(targets) != 2 || targets[1] != echoAddr {
+		t.Errorf("预期探测请求和一次连接, 代理收到: %v", targets)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// socketTOS 读取连接的 IP_TOS
+func socketTOS(t *testing.T, conn net.Conn) int {
+	rc, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("获取套接字失败: %v", err)
+	}
+	var tos int
+	rc.Control(func(fd uintptr) {
+		tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if err != nil {
+		t.Fatalf("读取 IP_TOS 失败: %v", err)
+	}
+	return tos
+}
+
+// TestRuleDSCP 测试规则为到代理的连接设置 DSCP
+func TestRuleDSCP(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.Rules = []C.Rule{{Pattern: "localhost", DSCP: "cs1"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", net.JoinHostPort("127.0.0.1", echoPort))
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	if tos := socketTOS(t, conn); tos != 0 {
+		t.Errorf("未命中规则的连接不应设置 DSCP, TOS: %#x", tos)
+	}
+	conn.Close()
+
+	conn, err = pm.Dial("tcp", net.JoinHostPort("localhost", echoPort))
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	defer conn.Close()
+	if tos := socketTOS(t, conn); tos != 8<<2 {
+		t.Errorf("CS1 对应的 TOS 应为 0x20, 实际: %#x", tos)
+	}
+}
+
+// TestParseDSCP 测试 DSCP 名称和数值的解析
+func TestParseDSCP(t *testing.T) {
+	cases := map[string]int{"cs0": 0, "CS1": 8, "af11": 10, "AF41": 34, "af43": 38, "ef": 46, "le": 1, "63": 63}
+	for name, want := range cases {
+		if got, err := C.ParseDSCP(name); err != nil || got != want {
+			t.Errorf("ParseDSCP(%q) = %d, %v, 预期 %d", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "cs8", "af44", "64", "-1", "bulk"} {
+		if _, err := C.ParseDSCP(name); err == nil {
+			t.Errorf("ParseDSCP(%q) 应失败", name)
+		}
+	}
+}
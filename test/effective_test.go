@@ -0,0 +1,66 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 8080
+	cfg.HTTPConfig.User = "user"
+	cfg.HTTPConfig.Pass = "http-secret"
+	cfg.SOCKSConfig = nil
+	cfg.StartupPolicy = ""
+	cfg.Rules = []C.Rule{{Pattern: "*.corp.test", User: "vendor", Pass: "rule-secret", DSCP: "cs1"}}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	eff := pm.EffectiveConfig()
+	if eff.Config.HTTPConfig.Pass != C.RedactedSecret || eff.Config.Rules[0].Pass != C.RedactedSecret {
+		t.Errorf("密码应脱敏: %q %q", eff.Config.HTTPConfig.Pass, eff.Config.Rules[0].Pass)
+	}
+	if cfg.HTTPConfig.Pass != "http-secret" || cfg.Rules[0].Pass != "rule-secret" {
+		t.Error("脱敏不应修改管理器的配置")
+	}
+	if eff.Config.SOCKSConfig == nil || eff.Config.StartupPolicy != C.StartupLazy {
+		t.Errorf("未设置的字段应补全默认值: %+v %q", eff.Config.SOCKSConfig, eff.Config.StartupPolicy)
+	}
+
+	routing := eff.Routing
+	if !routing.Enabled || routing.ProxyAddr != "127.0.0.1:8080" || len(routing.Rules) != 1 {
+		t.Fatalf("路由状态不符: %+v", routing)
+	}
+	if r := routing.Rules[0]; r.DSCP != 8 || r.Action != "proxy" || r.Pass != C.RedactedSecret {
+		t.Errorf("编译后的规则不符: %+v", r)
+	}
+	if eff.Resolver != "proxy.SystemResolver" {
+		t.Errorf("解析器类型不符: %q", eff.Resolver)
+	}
+
+	data, err := eff.JSON()
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("JSON 中不应包含密码: %s", data)
+	}
+	again, _ := pm.EffectiveConfig().JSON()
+	if !bytes.Equal(data, again) {
+		t.Error("相同的设置应得到相同的 JSON")
+	}
+	var decoded PM.EffectiveConfig
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Config.ProxyType != C.HTTP {
+		t.Errorf("JSON 无法还原: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	gohookproxy "github.com/ba0gu0/GoHookProxy"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestFacadeManager 测试顶层包的 Manager 按规则拨号、解释决策并在运行时更新配置
+func TestFacadeManager(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+	echoAddr := startEchoServer(t)
+
+	cfg := gohookproxy.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.MetricsEnable = true
+	r, err := C.ParseRule("DOMAIN-SUFFIX,corp.local,DIRECT")
+	if err != nil {
+		t.Fatalf("解析规则失败: %v", err)
+	}
+	cfg.Rules = []C.Rule{r}
+
+	var m gohookproxy.Manager
+	m, err = gohookproxy.New(cfg)
+	if err != nil {
+		t.Fatalf("创建管理器失败: %v", err)
+	}
+
+	conn, err := m.DialContext(context.Background(), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("经过代理拨号失败: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("回显不符: %q, %v", buf, err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("代理应收到目标 %s, 实际: %v", echoAddr, targets)
+	}
+	if got := m.GetMetrics().TotalConnections; got == 0 {
+		t.Errorf("指标应记录连接")
+	}
+
+	d := m.Explain("tcp", "git.corp.local:22")
+	if d.Action != gohookproxy.ActionDirect || d.Rule == nil {
+		t.Fatalf("预期命中直连规则, 实际: %s", d)
+	}
+	if d.Rule.String() != "domain-suffix corp.local" || !d.Rule.Match("corp.local:443") {
+		t.Errorf("规则描述或匹配不符: %s", d.Rule)
+	}
+	if d := m.Explain("tcp", "example.com:443"); d.Action != gohookproxy.ActionProxy || d.Rule != nil {
+		t.Errorf("未命中规则时预期走代理, 实际: %s", d)
+	}
+
+	if err := m.UpdateConfig(nil); err != nil {
+		t.Fatalf("更新为默认配置失败: %v", err)
+	}
+	if d := m.Explain("tcp", "example.com:443"); d.Action != gohookproxy.ActionDirect {
+		t.Errorf("默认配置预期直连, 实际: %s", d)
+	}
+}
+
+// TestFacadeDialerAndRule 测试顶层包的 Dialer 和规则解析
+func TestFacadeDialerAndRule(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	var d gohookproxy.Dialer
+	d, err := gohookproxy.NewDialer(nil)
+	if err != nil {
+		t.Fatalf("创建拨号器失败: %v", err)
+	}
+	conn, err := d.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连拨号失败: %v", err)
+	}
+	conn.Close()
+
+	bad := gohookproxy.DefaultConfig()
+	bad.Enable = true
+	bad.ProxyType = C.SOCKS5
+	if _, err := gohookproxy.NewDialer(bad); err == nil {
+		t.Errorf("缺少代理地址时应创建失败")
+	}
+
+	rule, err := gohookproxy.ParseRule("DST-PORT,22/3306,REJECT")
+	if err != nil {
+		t.Fatalf("解析规则失败: %v", err)
+	}
+	if rule.Action() != gohookproxy.ActionReject || !rule.Match("db:3306") || rule.Match("db:5432") {
+		t.Errorf("规则动作或匹配不符: %s %s", rule, rule.Action())
+	}
+	if _, err := gohookproxy.ParseRule("GEOIP,CN,DIRECT"); err == nil {
+		t.Errorf("不支持的规则类型应解析失败")
+	}
+}
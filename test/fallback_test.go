@@ -0,0 +1,120 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestFallbackDirect 测试代理不可达或握手失败时按 FallbackDirect 改为直连，按策略拒绝的目标不直连
+func TestFallbackDirect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	// 监听后立即关闭，得到一个不可达的代理地址
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	deadAddr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	truncated := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithFault(proxytest.TruncatedReply))
+	refusing := startProxy(t, proxytest.NewHTTPServer, proxytest.WithConnectPorts(1))
+
+	newManager := func(t *testing.T, proxyType C.ProxyType, host string, port int, fallback bool) *PM.ProxyManager {
+		t.Helper()
+		cfg := C.DefaultConfig()
+		cfg.Enable = true
+		cfg.ProxyType = proxyType
+		cfg.ProxyIP = host
+		cfg.ProxyPort = port
+		cfg.MetricsEnable = true
+		cfg.FallbackDirect = fallback
+		pm, err := PM.New(cfg)
+		if err != nil {
+			t.Fatalf("创建代理管理器失败: %v", err)
+		}
+		return pm
+	}
+
+	t.Run("代理不可达", func(t *testing.T) {
+		pm := newManager(t, C.SOCKS5, deadAddr.IP.String(), deadAddr.Port, true)
+		var mu sync.Mutex
+		var events []PM.FallbackEvent
+		pm.OnFallbackDirect(func(e PM.FallbackEvent) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		})
+
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("代理不可达时应直连成功: %v", err)
+		}
+		echoOnce(t, conn, "ping")
+		conn.Close()
+
+		if m := pm.GetMetrics(); m.FallbackDirect != 1 || m.FallbackDirectFailed != 0 {
+			t.Errorf("预期记录 1 次成功的直连回退, 实际: %d/%d", m.FallbackDirect, m.FallbackDirectFailed)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(events) != 1 || events[0].Addr != echoAddr || events[0].ProxyErr == nil || events[0].DirectErr != nil {
+			t.Errorf("回调事件不符: %+v", events)
+		}
+	})
+
+	t.Run("握手失败", func(t *testing.T) {
+		pm := newManager(t, C.SOCKS5, truncated.Host(), truncated.Port(), true)
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("握手失败时应直连成功: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("直连也失败", func(t *testing.T) {
+		pm := newManager(t, C.SOCKS5, deadAddr.IP.String(), deadAddr.Port, true)
+		_, err := pm.Dial("tcp", deadAddr.String())
+		if err == nil || !strings.Contains(err.Error(), "direct fallback") {
+			t.Errorf("错误应同时包含代理和直连的失败, 实际: %v", err)
+		}
+		if m := pm.GetMetrics(); m.FallbackDirectFailed != 1 {
+			t.Errorf("预期记录 1 次失败的直连回退, 实际: %d", m.FallbackDirectFailed)
+		}
+	})
+
+	t.Run("按策略拒绝", func(t *testing.T) {
+		pm := newManager(t, C.HTTP, refusing.Host(), refusing.Port(), true)
+		if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrProxyForbidden) {
+			t.Errorf("代理按策略拒绝的目标不应直连, 实际: %v", err)
+		}
+		if m := pm.GetMetrics(); m.FallbackDirect != 0 {
+			t.Errorf("不应回退直连, 实际: %d", m.FallbackDirect)
+		}
+	})
+
+	t.Run("未启用", func(t *testing.T) {
+		pm := newManager(t, C.SOCKS5, deadAddr.IP.String(), deadAddr.Port, false)
+		if _, err := pm.Dial("tcp", echoAddr); err == nil {
+			t.Errorf("未启用 FallbackDirect 时代理不可达应失败")
+		}
+	})
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.TOR
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 9050
+	cfg.FallbackDirect = true
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("tor 不能与 fallback_direct 一起使用")
+	}
+}
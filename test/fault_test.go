@@ -0,0 +1,212 @@
+package test
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// withShortHandshake 缩短代理的握手超时
+func withShortHandshake(cfg *C.Config) {
+	cfg.SOCKSConfig.Timeout = 300 * time.Millisecond
+	cfg.HTTPConfig.Timeout = 300 * time.Millisecond
+}
+
+func TestProxytestServers(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.SOCKS5, proxytest.NewSOCKSServer},
+		{C.SOCKS4, proxytest.NewSOCKSServer},
+		{C.HTTP, proxytest.NewHTTPServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tt.newServer)
+			pm := newTestManager(t, tt.proxyType, srv.Host(), srv.Port(), withShortHandshake)
+
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("连接失败: %v", err)
+			}
+			defer conn.Close()
+
+			msg := []byte("ping")
+			conn.Write(msg)
+			buf := make([]byte, len(msg))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("回显失败: %q, %v", buf, err)
+			}
+
+			if targets := srv.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+				t.Errorf("代理收到的目标地址不符: %v", targets)
+			}
+		})
+	}
+}
+
+// TestPipelinedReply 测试代理紧跟在握手响应后发送的数据不会丢失
+func TestPipelinedReply(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.SOCKS5, proxytest.NewSOCKSServer},
+		{C.SOCKS4, proxytest.NewSOCKSServer},
+		{C.HTTP, proxytest.NewHTTPServer},
+		{C.HTTPS, proxytest.NewHTTPSServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, proxytest.WithPipelined([]byte("banner")))
+			pm := newTestManager(t, tt.proxyType, srv.Host(), srv.Port(), withShortHandshake)
+
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("连接失败: %v", err)
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("ping"))
+			buf := make([]byte, len("bannerping"))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "bannerping" {
+				t.Fatalf("响应后的数据丢失: %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestProxytestFaults(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		name      string
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+		opts      []proxytest.Option
+	}{
+		{"socks5 slowloris", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.SlowHandshake)}},
+		{"socks5 truncated", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.TruncatedReply)}},
+		{"socks5 wrong atyp", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.WrongATYP)}},
+		{"socks5 reset", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.Reset)}},
+		{"socks5 stall", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.Stall)}},
+		{"socks5 refused", C.SOCKS5, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithReplyCode(0x02)}},
+		{"socks4 truncated", C.SOCKS4, proxytest.NewSOCKSServer, []proxytest.Option{proxytest.WithFault(proxytest.TruncatedReply)}},
+		{"http 407 loop", C.HTTP, proxytest.NewHTTPServer, []proxytest.Option{proxytest.WithFault(proxytest.AuthLoop)}},
+		{"http slowloris", C.HTTP, proxytest.NewHTTPServer, []proxytest.Option{proxytest.WithFault(proxytest.SlowHandshake)}},
+		{"http truncated", C.HTTP, proxytest.NewHTTPServer, []proxytest.Option{proxytest.WithFault(proxytest.TruncatedReply)}},
+		{"http reset", C.HTTP, proxytest.NewHTTPServer, []proxytest.Option{proxytest.WithResetRate(1, 1)}},
+		{"http forbidden", C.HTTP, proxytest.NewHTTPServer, []proxytest.Option{proxytest.WithStatus(403)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, tt.opts...)
+			pm := newTestManager(t, tt.proxyType, srv.Host(), srv.Port(), withShortHandshake)
+
+			start := time.Now()
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err == nil {
+				conn.Close()
+				t.Fatal("预期连接失败，但连接成功")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("预期快速失败, 实际耗时: %v", elapsed)
+			}
+			t.Logf("%s: %v", tt.name, err)
+		})
+	}
+}
+
+// TestSOCKS5AuthStall 测试认证子协商停滞时 TCP 和 UDP 拨号都在超时后失败，UDP 关联不依赖调用方的截止时间
+func TestSOCKS5AuthStall(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	udpEchoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAuth("user", "pass"), proxytest.WithFault(proxytest.AuthStall))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.HookUDP = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.SOCKSConfig.User = "user"
+	cfg.SOCKSConfig.Pass = "pass"
+	cfg.SOCKSConfig.Timeout = 300 * time.Millisecond
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	for _, dial := range []struct {
+		network, addr string
+	}{
+		{"tcp", echoAddr},
+		{"udp", udpEchoAddr},
+	} {
+		done := make(chan error, 1)
+		start := time.Now()
+		go func() {
+			conn, err := pm.Dial(dial.network, dial.addr)
+			if err == nil {
+				conn.Close()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s: 认证停滞时预期拨号失败", dial.network)
+			} else if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("%s: 预期在超时后失败, 实际耗时: %v", dial.network, elapsed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: 认证停滞时拨号一直阻塞", dial.network)
+		}
+	}
+}
+
+func TestSOCKSOverUnixSocket(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithUnix(path))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = C.UnixSocketPrefix + path
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 Unix 域套接字连接失败: %v", err)
+	}
+	conn.Close()
+
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("代理收到的目标地址不符: %v", targets)
+	}
+
+	cfg.ProxyType = C.HTTP
+	if err := cfg.Validate(); err == nil {
+		t.Error("预期 HTTP 代理不支持 Unix 域套接字")
+	}
+}
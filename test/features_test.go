@@ -0,0 +1,111 @@
+package test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// featureStatus 返回管理器中指定功能开关的状态
+func featureStatus(t *testing.T, pm *PM.ProxyManager, f C.Feature) PM.FeatureStatus {
+	t.Helper()
+	for _, s := range pm.Features() {
+		if s.Name == f {
+			return s
+		}
+	}
+	t.Fatalf("没有找到功能 %s", f)
+	return PM.FeatureStatus{}
+}
+
+// TestFeatureFlags 测试功能开关关闭已配置的功能，并可以在运行时通过 UpdateConfig 切换
+func TestFeatureFlags(t *testing.T) {
+	for _, info := range C.Features() {
+		if !info.Default {
+			t.Errorf("功能 %s 默认应打开", info.Name)
+		}
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.NAT64 = &C.NAT64Config{Prefix: "64:ff9b::/96"}
+	cfg.Features = map[C.Feature]bool{"no_such_feature": false}
+	if err := cfg.Validate(); err == nil {
+		t.Error("未知的功能名应验证失败")
+	}
+
+	cfg.Features = nil
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if s := featureStatus(t, pm, C.FeatureNAT64); !s.Enabled || !s.Active {
+		t.Errorf("配置了 NAT64 时应生效, 实际: %+v", s)
+	}
+	if s := featureStatus(t, pm, C.FeatureRace); !s.Enabled || s.Active {
+		t.Errorf("没有配置竞速拨号时不应生效, 实际: %+v", s)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "8.8.8.8:53"); addr.String() != "[64:ff9b::808:808]:53" {
+		t.Errorf("打开 nat64 时应转换, 实际: %v", addr)
+	}
+
+	cfg.Features = map[C.Feature]bool{C.FeatureNAT64: false}
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if s := featureStatus(t, pm, C.FeatureNAT64); s.Enabled || s.Active {
+		t.Errorf("关闭 nat64 后不应生效, 实际: %+v", s)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "8.8.8.8:53"); addr.String() != "8.8.8.8:53" {
+		t.Errorf("关闭 nat64 后不应转换, 实际: %v", addr)
+	}
+	if features := pm.EffectiveConfig().Features; len(features) != len(C.Features()) {
+		t.Errorf("EffectiveConfig 应包含所有功能开关, 实际: %d", len(features))
+	}
+}
+
+// TestFeatureFakeIPOff 测试关闭 fake_ip 后改用 Upstream 解析，已分配的假 IP 仍然还原为主机名
+func TestFeatureFakeIPOff(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5H
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.Rules = []C.Rule{{Pattern: "db.internal", Action: C.ActionDirect}}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	fake, err := PM.NewFakeIPResolver("198.18.0.0/15")
+	if err != nil {
+		t.Fatalf("创建假 IP 解析器失败: %v", err)
+	}
+	fake.Upstream = PM.HostsResolver{Hosts: map[string][]netip.Addr{
+		"db.internal":  {netip.MustParseAddr("10.0.0.5")},
+		"api.internal": {netip.MustParseAddr("10.0.0.6")},
+	}}
+	pm.SetResolver(fake)
+
+	ips, err := pm.LookupIPAddr(context.Background(), "db.internal")
+	if err != nil || len(ips) != 1 {
+		t.Fatalf("解析失败: %v, %v", ips, err)
+	}
+	fakeAddr := ips[0].IP.String() + ":5432"
+
+	cfg.Features = map[C.Feature]bool{C.FeatureFakeIP: false}
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if s := featureStatus(t, pm, C.FeatureFakeIP); s.Enabled || s.Active {
+		t.Errorf("关闭 fake_ip 后不应生效, 实际: %+v", s)
+	}
+	if ips, err := pm.LookupIPAddr(context.Background(), "api.internal"); err != nil || len(ips) != 1 || ips[0].IP.String() != "10.0.0.6" {
+		t.Errorf("关闭 fake_ip 后应使用 Upstream 解析, 实际: %v, %v", ips, err)
+	}
+	if d := pm.Explain("tcp", fakeAddr); d.Rule == nil || d.Rule.Pattern != "db.internal" {
+		t.Errorf("已分配的假 IP 应还原为主机名匹配规则, 实际: %s", d)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// startForwardProxy 启动只允许 CONNECT 到 443 且需要认证的代理
+func startForwardProxy(t *testing.T, proxyType C.ProxyType) *proxytest.Server {
+	newServer := proxytest.NewHTTPServer
+	if proxyType == C.HTTPS {
+		newServer = proxytest.NewHTTPSServer
+	}
+	return startProxy(t, newServer, proxytest.WithConnectPorts(443), proxytest.WithAuth("user", "pass"))
+}
+
+// withForwardAuth 使用 startForwardProxy 的凭证
+func withForwardAuth(cfg *C.Config) {
+	cfg.HTTPConfig.User = "user"
+	cfg.HTTPConfig.Pass = "pass"
+	cfg.HTTPConfig.SkipVerify = true
+}
+
+// withForwardPort 到 origin 端口的连接使用转发模式
+func withForwardPort(origin string) func(*C.Config) {
+	_, port, _ := net.SplitHostPort(origin)
+	p, _ := strconv.Atoi(port)
+	return func(cfg *C.Config) {
+		cfg.HTTPConfig.ForwardPorts = []int{p}
+	}
+}
+
+func TestForwardPlainHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body)))
+	}))
+	defer origin.Close()
+	originAddr := strings.TrimPrefix(origin.URL, "http://")
+
+	for _, proxyType := range []C.ProxyType{C.HTTP, C.HTTPS} {
+		t.Run(string(proxyType), func(t *testing.T) {
+			srv := startForwardProxy(t, proxyType)
+			pm := newTestManager(t, proxyType, srv.Host(), srv.Port(), withForwardAuth, withForwardPort(originAddr))
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return pm.DialContext(ctx, network, addr)
+				},
+			}}
+			defer client.CloseIdleConnections()
+
+			// 同一连接上的多个请求都要改写
+			for _, req := range []struct{ method, path, body string }{
+				{http.MethodGet, "/a", ""},
+				{http.MethodPost, "/b", "payload"},
+			} {
+				r, _ := http.NewRequest(req.method, origin.URL+req.path, strings.NewReader(req.body))
+				resp, err := client.Do(r)
+				if err != nil {
+					t.Fatalf("请求 %s 失败: %v", req.path, err)
+				}
+				got, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if want := req.method + " " + req.path + " " + req.body; string(got) != want {
+					t.Errorf("响应不符: %q, 预期 %q", got, want)
+				}
+			}
+			if targets := srv.Targets(); len(targets) != 2 || targets[0] != originAddr {
+				t.Errorf("代理收到的目标不符: %v", targets)
+			}
+		})
+	}
+}
+
+func TestForwardPortsDisabled(t *testing.T) {
+	origin := httptest.NewServer(http.NotFoundHandler())
+	defer origin.Close()
+	originAddr := strings.TrimPrefix(origin.URL, "http://")
+
+	// 未配置 ForwardPorts 时仍然使用 CONNECT，被代理拒绝
+	srv := startForwardProxy(t, C.HTTP)
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withForwardAuth)
+	if _, err := pm.Dial("tcp", originAddr); !errors.Is(err, E.ErrProxyProtocol) {
+		t.Errorf("预期 CONNECT 被拒绝, 实际: %v", err)
+	}
+}
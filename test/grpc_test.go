@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/grpc"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestGRPCDial 测试通过明文和 TLS 上的 gRPC 流连接，多个连接共用一个 HTTP/2 连接
+func TestGRPCDial(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	target := net.JoinHostPort("localhost", echoPort)
+
+	for _, tc := range []struct {
+		name      string
+		plaintext bool
+		start     func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{"h2c", true, proxytest.NewGRPCServer},
+		{"tls", false, proxytest.NewGRPCSServer},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := startProxy(t, tc.start, proxytest.WithAuth("grpc", "secret"))
+			grpcConfig := C.DefaultGRPCConfig()
+			grpcConfig.User = "grpc"
+			grpcConfig.Pass = "secret"
+			grpcConfig.Plaintext = tc.plaintext
+			grpcConfig.SkipVerify = true
+			pm := newTestManager(t, C.GRPC, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.GRPCConfig = grpcConfig })
+
+			for i := 0; i < 3; i++ {
+				conn, err := pm.Dial("tcp", target)
+				if err != nil {
+					t.Fatalf("通过 gRPC 连接失败: %v", err)
+				}
+				sshEcho(t, conn)
+				conn.Close()
+			}
+			if srv.Accepted() != 1 {
+				t.Errorf("多个流应共用一个连接, 实际建立了 %d 个", srv.Accepted())
+			}
+			if targets := srv.Targets(); len(targets) != 3 || targets[0] != target {
+				t.Errorf("服务器应收到主机名 %s, 实际: %v", target, targets)
+			}
+
+			if _, err := pm.Dial("udp", target); !errors.Is(err, E.ErrGRPCNetworkNotSupported) {
+				t.Errorf("gRPC 不支持 UDP, 实际: %v", err)
+			}
+		})
+	}
+}
+
+// TestGRPCAuth 测试认证失败在第一次读取时返回，以及路由规则指定的凭证
+func TestGRPCAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewGRPCServer, proxytest.WithAuth("rule", "pass"))
+
+	grpcConfig := C.DefaultGRPCConfig()
+	grpcConfig.Plaintext = true
+	grpcConfig.User = "wrong"
+	grpcConfig.Pass = "wrong"
+	pm := newTestManager(t, C.GRPC, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.GRPCConfig = grpcConfig })
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("流在第一次读取前不应失败: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, E.ErrGRPCAuth) {
+		t.Errorf("认证失败应返回 ErrGRPCAuth, 实际: %v", err)
+	}
+	conn.Close()
+
+	ctx := PM.WithCredentials(context.Background(), PM.Credentials{User: "rule", Pass: "pass"})
+	conn, err = pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 gRPC 连接失败: %v", err)
+	}
+	sshEcho(t, conn)
+	conn.Close()
+	if logins := srv.Logins(); len(logins) != 1 || logins[0] != "rule:pass" {
+		t.Errorf("服务器应收到规则凭证, 实际: %v", logins)
+	}
+}
+
+// TestGRPCCodec 测试大块数据拆分为多条消息后能完整读回
+func TestGRPCCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("gohookproxy"), grpc.MaxHunkSize/4)
+	var buf bytes.Buffer
+	if n, err := grpc.WriteHunks(&buf, data); err != nil || n != len(data) {
+		t.Fatalf("写入消息失败: %d, %v", n, err)
+	}
+	got, err := io.ReadAll(grpc.NewReader(&buf))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("读回的数据不一致: %d 字节, %v", len(got), err)
+	}
+
+	compressed := grpc.AppendHunk(nil, []byte("x"))
+	compressed[0] = 1
+	if _, err := grpc.NewReader(bytes.NewReader(compressed)).Read(make([]byte, 1)); !errors.Is(err, E.ErrGRPCMessage) {
+		t.Errorf("压缩的消息应返回 ErrGRPCMessage, 实际: %v", err)
+	}
+}
+
+// TestGRPCConfig 测试 gRPC 配置的校验、默认值和脱敏
+func TestGRPCConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.GRPC
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 443
+	cfg.GRPCConfig.Metadata = map[string]string{"authorization": "Bearer secret", "x-client": "gohookproxy"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+	if cfg.WithDefaults().GRPCConfig.ServiceName != grpc.DefaultServiceName {
+		t.Error("未设置服务名时应使用默认服务名")
+	}
+
+	redacted := cfg.Redacted()
+	if redacted.GRPCConfig.Metadata["authorization"] != C.RedactedSecret || redacted.GRPCConfig.Metadata["x-client"] != "gohookproxy" {
+		t.Errorf("只有携带凭证的元数据应脱敏: %v", redacted.GRPCConfig.Metadata)
+	}
+
+	cfg.GRPCConfig.Metadata = map[string]string{"grpc-timeout": "1S"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("保留的元数据键应校验失败")
+	}
+	cfg.GRPCConfig.Metadata = nil
+	cfg.GRPCConfig.ServiceName = "a/b"
+	if err := cfg.Validate(); err == nil {
+		t.Error("包含 / 的服务名应校验失败")
+	}
+	cfg.GRPCConfig = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少 gRPC 配置应校验失败")
+	}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/health"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// waitHealth 等待代理的健康状态满足 ok
+func waitHealth(t *testing.T, pm *PM.ProxyManager, name string, ok func(health.Status) bool) health.Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, s := range pm.Health() {
+			if s.Name == name && ok(s) {
+				return s
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待代理 %q 的健康状态超时: %+v", name, pm.Health())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// targetCount 返回代理收到的到 addr 的连接数，不含健康检查对 HTTP 代理的握手探测
+func targetCount(srv *proxytest.Server, addr string) int {
+	n := 0
+	for _, target := range srv.Targets() {
+		if target == addr {
+			n++
+		}
+	}
+	return n
+}
+
+// isState 返回判断健康状态的函数
+func isState(state health.State) func(health.Status) bool {
+	return func(s health.Status) bool { return s.State == state }
+}
+
+// TestHealthCheck 测试健康检查标记不可用的代理，负载均衡和故障转移跳过它
+func TestHealthCheck(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	servers := []*proxytest.Server{
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewSOCKSServer),
+		startProxy(t, proxytest.NewHTTPServer),
+	}
+	cfg := balanceConfig(C.BalanceRoundRobin, servers...)
+	cfg.HealthCheck = &C.HealthCheckConfig{Interval: time.Minute, Failover: true}
+	fake := clock.NewFake(time.Time{})
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	changes := make(chan health.Status, 10)
+	pm.OnHealthChange(func(s health.Status) { changes <- s })
+	for _, name := range []string{"", "b", "c"} {
+		waitHealth(t, pm, name, isState(health.Up))
+	}
+
+	servers[1].Close()
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	// 跳过第一次探测成功时从 unknown 变为 up 的回调
+	for down := false; !down; {
+		select {
+		case s := <-changes:
+			if s.State != health.Down {
+				continue
+			}
+			down = true
+			if s.Name != "b" || s.Error == "" || s.Failures != 1 {
+				t.Errorf("状态变化回调收到的状态错误: %+v", s)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("等待状态变化回调超时: %+v", pm.Health())
+		}
+	}
+
+	// 均衡跳过不可用的 b
+	for i := 0; i < 4; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次拨号失败: %v", i, err)
+		}
+		conn.Close()
+	}
+	for i, want := range []int{2, 0, 2} {
+		if n := targetCount(servers[i], echoAddr); n != want {
+			t.Errorf("代理 %d 预期 %d 次拨号, 实际: %d", i, want, n)
+		}
+	}
+
+	// Use 选择的代理不可用时故障转移到第一个可用的代理
+	if err := pm.Use("b"); err != nil {
+		t.Fatalf("选择命名代理失败: %v", err)
+	}
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("故障转移的拨号失败: %v", err)
+	}
+	conn.Close()
+	if n := targetCount(servers[0], echoAddr); n != 3 {
+		t.Errorf("故障转移应使用主代理, 实际主代理拨号数: %d", n)
+	}
+}
+
+// TestHealthCheckURL 测试通过代理请求检查地址，连续 Fall 次失败后才标记为不可用
+func TestHealthCheckURL(t *testing.T) {
+	var failing atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HealthCheck = &C.HealthCheckConfig{Interval: time.Minute, Timeout: 5 * time.Second, URL: target.URL, Fall: 2}
+	fake := clock.NewFake(time.Time{})
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	waitHealth(t, pm, "", isState(health.Up))
+	if n := len(srv.Targets()); n != 1 {
+		t.Errorf("检查地址应通过代理请求, 实际代理收到: %d", n)
+	}
+
+	failing.Store(true)
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	if s := waitHealth(t, pm, "", func(s health.Status) bool { return s.Failures == 1 }); s.State != health.Up {
+		t.Errorf("第一次失败后应仍然可用: %+v", s)
+	}
+	fake.Advance(time.Minute)
+	waitHealth(t, pm, "", isState(health.Down))
+
+	failing.Store(false)
+	fake.Advance(time.Minute)
+	waitHealth(t, pm, "", isState(health.Up))
+}
+
+// TestHealthCheckInvalid 测试健康检查的配置检查
+func TestHealthCheckInvalid(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.HealthCheck = &C.HealthCheckConfig{}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("有效的配置验证失败: %v", err)
+	}
+	cfg.HealthCheck = &C.HealthCheckConfig{Fall: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("负的失败次数预期验证失败")
+	}
+	cfg.HealthCheck = &C.HealthCheckConfig{URL: "ftp://example.com"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("非 http 的检查地址预期验证失败")
+	}
+}
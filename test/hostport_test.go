@@ -0,0 +1,150 @@
+package test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hostport"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+func TestHostportCanonical(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"[2001:db8::1]:443", "[2001:db8::1]:443"},
+		{"[2001:DB8:0::1]:443", "[2001:db8::1]:443"},
+		{"[::ffff:127.0.0.1]:80", "127.0.0.1:80"},
+		{"[fe80::1%eth0]:22", "[fe80::1]:22"},
+		{"Example.COM.:8080", "example.com:8080"},
+		{"10.0.0.1:53", "10.0.0.1:53"},
+		{"no-port", "no-port"},
+	}
+	for _, tt := range tests {
+		if got := hostport.Canonical(tt.in); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, 预期 %q", tt.in, got, tt.want)
+		}
+	}
+
+	if got := hostport.Join("::1", 1080); got != "[::1]:1080" {
+		t.Errorf("IPv6 地址应加方括号: %s", got)
+	}
+	if got := hostport.Join("[::1]", 1080); got != "[::1]:1080" {
+		t.Errorf("已有方括号时不应重复添加: %s", got)
+	}
+	if host, port, err := hostport.Split("[2001:db8::1]:443"); err != nil || host != "2001:db8::1" || port != 443 {
+		t.Errorf("拆分 IPv6 地址失败: %s, %d, %v", host, port, err)
+	}
+	if _, _, err := hostport.Split("example.com:99999"); err == nil {
+		t.Error("预期端口超出范围时失败")
+	}
+	if got := hostport.Host("[::1]"); got != "::1" {
+		t.Errorf("没有端口的 IPv6 主机应去掉方括号: %s", got)
+	}
+}
+
+// TestIPv6ProxyAddress 测试 IPv6 代理地址的格式化和直连判断
+func TestIPv6ProxyAddress(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "::1"
+	cfg.ProxyPort = 1080
+
+	if got := cfg.GetProxyAddr(); got != "[::1]:1080" {
+		t.Errorf("代理地址格式错误: %s", got)
+	}
+
+	e := rules.FromConfig(cfg)
+	for _, addr := range []string{"[::1]:1080", "[0:0::1]:1080"} {
+		if d := e.Explain("tcp", addr); d.Action != rules.Direct {
+			t.Errorf("发往代理 %s 的连接应直连, 实际: %s", addr, d)
+		}
+	}
+
+	e.Rules = []rules.Rule{{Pattern: "2001:db8::1", Action: rules.Direct}}
+	if d := e.Explain("tcp", "[2001:0db8::1]:443"); d.Action != rules.Direct {
+		t.Errorf("IPv6 规则应匹配不同写法的同一地址, 实际: %s", d)
+	}
+}
+
+// startIPv6EchoServer 在 IPv6 回环地址上启动回显服务
+func startIPv6EchoServer(t *testing.T) int {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 回环地址不可用: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// TestIPv6Targets 测试各种代理协议连接 IPv6 字面量和 IPv4-mapped 目标
+func TestIPv6Targets(t *testing.T) {
+	port := strconv.Itoa(startIPv6EchoServer(t))
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+
+	tests := []struct {
+		name       string
+		proxyType  C.ProxyType
+		newServer  func(...proxytest.Option) (*proxytest.Server, error)
+		target     string
+		wantTarget string
+	}{
+		{"socks5 ipv6", C.SOCKS5, proxytest.NewSOCKSServer, "[::1]:" + port, "[::1]:" + port},
+		{"http ipv6", C.HTTP, proxytest.NewHTTPServer, "[::1]:" + port, "[::1]:" + port},
+		{"socks5 mapped", C.SOCKS5, proxytest.NewSOCKSServer, "[::ffff:127.0.0.1]:" + echoPort, "127.0.0.1:" + echoPort},
+		{"socks4 mapped", C.SOCKS4, proxytest.NewSOCKSServer, "[::ffff:127.0.0.1]:" + echoPort, "127.0.0.1:" + echoPort},
+		{"http mapped", C.HTTP, proxytest.NewHTTPServer, "[::ffff:127.0.0.1]:" + echoPort, "127.0.0.1:" + echoPort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startProxy(t, tt.newServer)
+
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = tt.proxyType
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			conn, err := pm.Dial("tcp", tt.target)
+			if err != nil {
+				t.Fatalf("连接 %s 失败: %v", tt.target, err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatalf("写入失败: %v", err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("回显失败: %q, %v", buf, err)
+			}
+			if targets := srv.Targets(); len(targets) == 0 || targets[0] != tt.wantTarget {
+				t.Errorf("代理收到的目标错误: 预期 %s, 实际 %v", tt.wantTarget, targets)
+			}
+		})
+	}
+}
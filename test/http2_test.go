@@ -0,0 +1,70 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestHTTP2FlowControl(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startHTTP2Proxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP2
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	cfg.HTTPConfig.InitialWindowSize = 1 << 20
+	cfg.HTTPConfig.MaxFrameSize = 1 << 16
+	cfg.HTTPConfig.AutoTuneWindow = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("x"), 256*1024)
+	for i := 0; i < 2; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("HTTP2 隧道连接失败: %v", err)
+		}
+
+		go conn.Write(payload)
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("读取回显数据失败: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatal("回显数据不一致")
+		}
+		conn.Close()
+	}
+
+	metrics := pm.GetMetrics()
+	if metrics.HTTP2Streams != 2 {
+		t.Errorf("预期 2 个 HTTP2 流, 实际: %d", metrics.HTTP2Streams)
+	}
+	if metrics.HTTP2WindowSize < 1<<20 {
+		t.Errorf("预期窗口不小于初始配置, 实际: %d", metrics.HTTP2WindowSize)
+	}
+	t.Logf("HTTP2 写入阻塞时间: %v, 窗口: %d", metrics.HTTP2WriteBlockedTime, metrics.HTTP2WindowSize)
+}
+
+func TestHTTP2InvalidFrameSize(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP2
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 9003
+	cfg.HTTPConfig.MaxFrameSize = 1024
+
+	if _, err := PM.New(cfg); err == nil {
+		t.Fatal("预期无效帧大小应该返回错误")
+	}
+}
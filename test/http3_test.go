@@ -0,0 +1,99 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func startHTTP3Proxy(t *testing.T, opts ...proxytest.Option) *proxytest.HTTP3Server {
+	srv, err := proxytest.NewHTTP3Server(opts...)
+	if err != nil {
+		t.Fatalf("启动 HTTP3 测试代理失败: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// withZeroRTT 启用 HTTP/3 代理的 0-RTT
+func withZeroRTT(cfg *C.Config) {
+	cfg.HTTPConfig.Enable0RTT = true
+}
+
+// http3Echo 通过隧道发送数据并读回
+func http3Echo(t *testing.T, pm *PM.ProxyManager, addr string, payload []byte) {
+	t.Helper()
+	conn, err := pm.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("HTTP3 隧道连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("回显数据不一致: %v", err)
+	}
+}
+
+// TestHTTP3Dial 测试多个 CONNECT 共用一个 QUIC 会话，会话断开后以 0-RTT 重连
+func TestHTTP3Dial(t *testing.T) {
+	echo := startEchoServer(t)
+	srv := startHTTP3Proxy(t)
+	pm := newTestManager(t, C.HTTP3, srv.Host(), srv.Port(), withMetrics, withZeroRTT)
+
+	payload := bytes.Repeat([]byte("h3"), 64*1024)
+	for i := 0; i < 3; i++ {
+		http3Echo(t, pm, echo, payload)
+	}
+	if n := srv.Accepted(); n != 1 {
+		t.Errorf("多个连接应共用一个 QUIC 会话, 实际建立: %d", n)
+	}
+	if targets := srv.Targets(); len(targets) != 3 || targets[0] != echo {
+		t.Errorf("代理收到的目标不正确: %v", targets)
+	}
+
+	// 服务端关闭会话后，下一次拨号用会话票据以 0-RTT 重连
+	srv.CloseConnections()
+	time.Sleep(100 * time.Millisecond)
+	http3Echo(t, pm, echo, []byte("again"))
+	if n := srv.Accepted(); n != 2 {
+		t.Errorf("会话断开后应重新建立, 实际建立: %d", n)
+	}
+	if n := srv.ZeroRTT(); n != 1 {
+		t.Errorf("重连应使用 0-RTT, 实际: %d", n)
+	}
+	if m := pm.GetMetrics(); m.HTTP3Sessions != 2 || m.HTTP3ZeroRTT != 1 {
+		t.Errorf("HTTP3 会话指标不正确: sessions=%d, 0-RTT=%d", m.HTTP3Sessions, m.HTTP3ZeroRTT)
+	}
+}
+
+// TestHTTP3Auth 测试 HTTP3 CONNECT 的 Basic 认证
+func TestHTTP3Auth(t *testing.T) {
+	echo := startEchoServer(t)
+	srv := startHTTP3Proxy(t, proxytest.WithAuth("user", "pass"))
+	pm := newTestManager(t, C.HTTP3, srv.Host(), srv.Port(), withMetrics, withZeroRTT)
+
+	if _, err := pm.Dial("tcp", echo); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Fatalf("缺少凭证时预期 ErrHTTPProxyAuth, 实际: %v", err)
+	}
+
+	pm.Config.HTTPConfig.User = "user"
+	pm.Config.HTTPConfig.Pass = "pass"
+	http3Echo(t, pm, echo, []byte("ping"))
+
+	// UDP 通过 connect-udp 转发，同样使用凭证
+	conn, err := pm.Dial("udp", echo)
+	if err != nil {
+		t.Fatalf("HTTP3 代理应通过 connect-udp 转发 UDP: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,192 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy/hysteria2"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func startHysteria2Proxy(t *testing.T, opts ...proxytest.Option) *proxytest.Hysteria2Server {
+	srv, err := proxytest.NewHysteria2Server(opts...)
+	if err != nil {
+		t.Fatalf("启动 Hysteria2 测试服务失败: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// withHysteria2 使用 password 认证 Hysteria2 代理
+func withHysteria2(password string) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.Hysteria2Config.Password = password
+		cfg.Hysteria2Config.SkipVerify = true
+		cfg.Hysteria2Config.DownMbps = 100
+	}
+}
+
+// TestHysteria2Dial 测试多个 TCP 连接共用一个认证过的 QUIC 连接，连接断开后重建
+func TestHysteria2Dial(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	target := net.JoinHostPort("localhost", echoPort)
+	srv := startHysteria2Proxy(t, proxytest.WithAuth("hy", "secret"))
+	pm := newTestManager(t, C.HYSTERIA2, srv.Host(), srv.Port(), withHysteria2("hy:secret"))
+
+	for i := 0; i < 3; i++ {
+		conn, err := pm.Dial("tcp", target)
+		if err != nil {
+			t.Fatalf("通过 Hysteria2 连接失败: %v", err)
+		}
+		sshEcho(t, conn)
+		conn.Close()
+	}
+	if srv.Accepted() != 1 || len(srv.Logins()) != 1 {
+		t.Errorf("多个连接应共用一个 QUIC 连接且只认证一次, 实际: %d 个连接, %d 次认证", srv.Accepted(), len(srv.Logins()))
+	}
+	if targets := srv.Targets(); len(targets) != 3 || targets[0] != target {
+		t.Errorf("服务器应收到主机名 %s, 实际: %v", target, targets)
+	}
+
+	srv.CloseConnections()
+	time.Sleep(50 * time.Millisecond)
+	conn, err := pm.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("QUIC 连接断开后应重建: %v", err)
+	}
+	sshEcho(t, conn)
+	conn.Close()
+	if srv.Accepted() != 2 {
+		t.Errorf("应建立第二个 QUIC 连接, 实际: %d", srv.Accepted())
+	}
+}
+
+// TestHysteria2UDP 测试 UDP 数据报，较大的数据报在两个方向上拆成分片
+func TestHysteria2UDP(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startHysteria2Proxy(t)
+	pm := newTestManager(t, C.HYSTERIA2, srv.Host(), srv.Port(), withHysteria2("any"))
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("建立 UDP 会话失败: %v", err)
+	}
+	defer conn.Close()
+	for _, size := range []int{16, 4000} {
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+		if _, err := conn.Write(payload); err != nil {
+			t.Fatalf("发送 %d 字节数据报失败: %v", size, err)
+		}
+		buf := make([]byte, 8192)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("%d 字节数据报回显失败: %d, %v", size, n, err)
+		}
+	}
+
+	pc, err := pm.ListenPacket(t.Context(), "udp")
+	if err != nil {
+		t.Fatalf("建立不固定目标的 UDP 会话失败: %v", err)
+	}
+	defer pc.Close()
+	dst, _ := net.ResolveUDPAddr("udp", echoAddr)
+	if _, err := pc.WriteTo([]byte("ping"), dst); err != nil {
+		t.Fatalf("WriteTo 失败: %v", err)
+	}
+	buf := make([]byte, 16)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" || from.String() != echoAddr {
+		t.Errorf("ReadFrom 应返回回显和目标地址, 实际: %q %v %v", buf[:n], from, err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := pc.ReadFrom(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("读取截止时间到达应返回超时, 实际: %v", err)
+	}
+}
+
+// TestHysteria2Auth 测试认证失败和服务器关闭 UDP 转发
+func TestHysteria2Auth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startHysteria2Proxy(t, proxytest.WithAuth("hy", "secret"))
+	pm := newTestManager(t, C.HYSTERIA2, srv.Host(), srv.Port(), withHysteria2("hy:wrong"))
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrHysteria2Auth) {
+		t.Errorf("认证失败应返回 ErrHysteria2Auth, 实际: %v", err)
+	}
+
+	srv = startHysteria2Proxy(t, proxytest.WithoutUDP())
+	pm = newTestManager(t, C.HYSTERIA2, srv.Host(), srv.Port(), withHysteria2("any"))
+	if _, err := pm.Dial("udp", startUDPEchoServer(t)); !errors.Is(err, E.ErrHysteria2UDPDisabled) {
+		t.Errorf("服务器关闭 UDP 时应返回 ErrHysteria2UDPDisabled, 实际: %v", err)
+	}
+	if _, err := pm.Dial("tcp", "127.0.0.1:1"); !errors.Is(err, E.ErrHysteria2Rejected) {
+		t.Errorf("服务器连接目标失败应返回 ErrHysteria2Rejected, 实际: %v", err)
+	}
+}
+
+// TestHysteria2Fragment 测试分片和重组，新包的分片丢弃未完成的旧包
+func TestHysteria2Fragment(t *testing.T) {
+	data := bytes.Repeat([]byte("hysteria"), 500)
+	m := &hysteria2.UDPMessage{SessionID: 7, PacketID: 1, FragCount: 1, Addr: "example.com:53", Data: data}
+	frags := hysteria2.Fragment(m, 1200)
+	if len(frags) < 2 {
+		t.Fatalf("4000 字节负载应拆成多个分片, 实际: %d", len(frags))
+	}
+
+	var defrag hysteria2.Defragger
+	stale := *frags[0]
+	stale.PacketID = 0
+	defrag.Feed(&stale)
+	var full *hysteria2.UDPMessage
+	for i := len(frags) - 1; i >= 0; i-- {
+		encoded := frags[i].Append(nil)
+		if len(encoded) > 1200 {
+			t.Fatalf("分片编码后超过上限: %d", len(encoded))
+		}
+		parsed, err := hysteria2.ParseUDPMessage(encoded)
+		if err != nil {
+			t.Fatalf("解析分片失败: %v", err)
+		}
+		full = defrag.Feed(parsed)
+	}
+	if full == nil || full.Addr != m.Addr || !bytes.Equal(full.Data, data) {
+		t.Fatal("分片重组后的数据不一致")
+	}
+
+	if _, err := hysteria2.ParseUDPMessage([]byte{0, 0, 0, 1, 0, 1, 2, 1, 0}); !errors.Is(err, E.ErrHysteria2Message) {
+		t.Errorf("分片 ID 超出分片数应返回 ErrHysteria2Message, 实际: %v", err)
+	}
+}
+
+// TestHysteria2Config 测试 Hysteria2 配置的校验和脱敏
+func TestHysteria2Config(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HYSTERIA2
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 443
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少密码应校验失败")
+	}
+
+	cfg.Hysteria2Config.Password = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+	if cfg.Redacted().Hysteria2Config.Password != C.RedactedSecret {
+		t.Error("密码应脱敏")
+	}
+
+	cfg.Hysteria2Config.StreamReceiveWindow = 64 << 20
+	if err := cfg.Validate(); err == nil {
+		t.Error("流接收窗口大于连接接收窗口应校验失败")
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestHookIntegrationHelpers 测试不依赖补丁的显式集成方式，nohook 构建下同样可用
+func TestHookIntegrationHelpers(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer web.Close()
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+
+	conn, err := h.DialContext(context.Background(), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("显式拨号失败: %v", err)
+	}
+	conn.Close()
+
+	client := &http.Client{Transport: h.Transport()}
+	resp, err := client.Get(web.URL)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+
+	webAddr := strings.TrimPrefix(web.URL, "http://")
+	if targets := srv.Targets(); len(targets) != 2 || targets[0] != echoAddr || targets[1] != webAddr {
+		t.Errorf("预期两个连接都经过代理, 代理收到: %v", targets)
+	}
+}
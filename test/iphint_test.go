@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// lookupCounter 记录解析次数的静态解析器
+type lookupCounter struct {
+	PM.HostsResolver
+	lookups atomic.Int32
+}
+
+func (r *lookupCounter) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups.Add(1)
+	return r.HostsResolver.LookupIPAddr(ctx, host)
+}
+
+// TestResolvedHints 测试为应用解析过的 IP 在拨号时还原为主机名，解析选择的 IP 作为提示不再解析
+func TestResolvedHints(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	ipTarget := net.JoinHostPort("127.0.0.1", echoPort)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.ResolvedHints = true
+	cfg.Rules = []C.Rule{{Pattern: "direct.test", Action: C.ActionDirect}}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	loopback := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+	resolver := &lookupCounter{HostsResolver: PM.HostsResolver{Hosts: map[string][]netip.Addr{
+		"localhost":   loopback,
+		"direct.test": loopback,
+	}}}
+	pm.SetResolver(resolver)
+
+	// 代理收到应用解析前的主机名
+	if _, err := pm.LookupIPAddr(context.Background(), "localhost"); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	conn, err := pm.Dial("tcp", ipTarget)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != net.JoinHostPort("localhost", echoPort) {
+		t.Errorf("代理应收到主机名, 实际: %v", targets)
+	}
+
+	// 规则按主机名匹配，直连使用提示的地址，不再解析
+	if _, err := pm.LookupIPAddr(context.Background(), "direct.test"); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if d := pm.Explain("tcp", ipTarget); d.Action != rules.Direct || d.Rule == nil {
+		t.Errorf("还原的主机名应命中规则, 实际: %v", d)
+	}
+	lookups := resolver.lookups.Load()
+	if conn, err = pm.Dial("tcp", ipTarget); err != nil {
+		t.Fatalf("直连失败: %v", err)
+	}
+	conn.Close()
+	if n := resolver.lookups.Load(); n != lookups {
+		t.Errorf("有地址提示时不应再次解析, 解析次数从 %d 变为 %d", lookups, n)
+	}
+	if n := len(srv.Targets()); n != 1 {
+		t.Errorf("直连的目标不应经过代理, 实际代理收到 %d 个请求", n)
+	}
+
+	// 关闭功能后按 IP 拨号
+	cfg.Features = map[C.Feature]bool{C.FeatureResolvedHints: false}
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	pm.LookupIPAddr(context.Background(), "localhost")
+	if conn, err = pm.Dial("tcp", ipTarget); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 2 || targets[1] != ipTarget {
+		t.Errorf("关闭功能后代理应收到 IP, 实际: %v", targets)
+	}
+}
+
+// TestIPHintSOCKS4 测试 SOCKS4 使用调用方提示的地址，不在本地解析主机名
+func TestIPHintSOCKS4(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS4
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	// 解析器不认识 app.test，只能使用提示的地址
+	pm.SetResolver(PM.HostsResolver{})
+
+	target := net.JoinHostPort("app.test", echoPort)
+	ctx := PM.WithIPHint(context.Background(), PM.IPHint{Host: "App.Test", IP: netip.MustParseAddr("127.0.0.1")})
+	conn, err := pm.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatalf("使用地址提示连接失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != net.JoinHostPort("127.0.0.1", echoPort) {
+		t.Errorf("SOCKS4 应发送提示的地址, 实际: %v", targets)
+	}
+
+	// 提示只对它的主机名生效
+	ctx = PM.WithIPHint(context.Background(), PM.IPHint{Host: "other.test", IP: netip.MustParseAddr("127.0.0.1")})
+	if _, err := pm.DialContext(ctx, "tcp", target); err == nil {
+		t.Error("提示的主机名与目标不同时应在本地解析并失败")
+	}
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestLazyCertificates 测试证书文件在第一次握手时才读取，缺少文件不影响创建管理器和不需要证书的连接
+func TestLazyCertificates(t *testing.T) {
+	echo := startEchoServer(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+
+	// 明文 HTTP 代理不使用 TLS 配置中的文件
+	plain := startProxy(t, proxytest.NewHTTPServer)
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = plain.Host()
+	cfg.ProxyPort = plain.Port()
+	cfg.HTTPConfig.CertFile = certFile
+	cfg.HTTPConfig.KeyFile = keyFile
+	cfg.HTTPConfig.RootCAFile = filepath.Join(dir, "missing-ca.pem")
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("缺少证书文件时创建管理器应成功: %v", err)
+	}
+	conn, err := pm.Dial("tcp", echo)
+	if err != nil {
+		t.Fatalf("明文 HTTP 代理不应读取证书文件: %v", err)
+	}
+	conn.Close()
+
+	srv := startProxy(t, proxytest.NewSOCKSSServer)
+	cfg = newSOCKSTLSConfig(srv, C.SOCKS5S)
+	cfg.SOCKSConfig.CertFile = certFile
+	cfg.SOCKSConfig.KeyFile = keyFile
+	pm, err = PM.New(cfg)
+	if err != nil {
+		t.Fatalf("缺少证书文件时创建管理器应成功: %v", err)
+	}
+	if _, err := pm.Dial("tcp", echo); !errors.Is(err, E.ErrCertValidation) {
+		t.Fatalf("缺少证书文件时拨号应返回 ErrCertValidation, 实际: %v", err)
+	}
+
+	// 写入证书后下一次拨号重新读取，不需要重建管理器
+	cert := newTestCA(t).issue(t, 2, -1, time.Time{})
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+	conn, err = pm.Dial("tcp", echo)
+	if err != nil {
+		t.Fatalf("写入证书后拨号应成功: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 {
+		t.Errorf("代理应收到一个请求, 实际: %v", targets)
+	}
+
+	// 证书读取成功后被缓存，删除文件不影响之后的连接
+	os.Remove(certFile)
+	if conn, err = pm.Dial("tcp", echo); err != nil {
+		t.Fatalf("证书已缓存时拨号应成功: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// newTestManager 创建通过 host:port 上 proxyType 代理拨号的管理器，mutators 依次修改默认配置
+func newTestManager(t *testing.T, proxyType C.ProxyType, host string, port int, mutators ...func(*C.Config)) *PM.ProxyManager {
+	t.Helper()
+	return newClockManager(t, nil, proxyType, host, port, mutators...)
+}
+
+// newClockManager 同 newTestManager，使用 clk 计时，clk 为 nil 时使用真实时钟
+// 测试结束时关闭管理器的拨号器和后台任务
+func newClockManager(t *testing.T, clk clock.Clock, proxyType C.ProxyType, host string, port int, mutators ...func(*C.Config)) *PM.ProxyManager {
+	t.Helper()
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = proxyType
+	cfg.ProxyIP = host
+	cfg.ProxyPort = port
+	for _, mutate := range mutators {
+		mutate(cfg)
+	}
+
+	pm, err := PM.NewWithClock(cfg, clk)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	t.Cleanup(func() { pm.UpdateConfig(nil) })
+	return pm
+}
+
+// withHookUDP 同时代理 UDP
+func withHookUDP(cfg *C.Config) {
+	cfg.HookUDP = true
+}
+
+// withMetrics 启用指标收集
+func withMetrics(cfg *C.Config) {
+	cfg.MetricsEnable = true
+}
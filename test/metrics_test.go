@@ -0,0 +1,292 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestDialStageMetrics(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("通过 HTTP 代理连接失败: %v", err)
+		}
+		conn.Close()
+	}
+
+	stages := pm.GetMetrics().StageLatency
+	for _, stage := range []metrics.DialStage{metrics.StageTCPConnect, metrics.StageProxyHandshake, metrics.StageTargetReady} {
+		if stages[stage].Count != 3 {
+			t.Errorf("阶段 %s 预期 3 次记录, 实际: %d", stage, stages[stage].Count)
+		}
+	}
+	// 普通 HTTP 代理没有 TLS 阶段
+	if stages[metrics.StageTLSHandshake].Count != 0 {
+		t.Errorf("预期没有 TLS 阶段记录, 实际: %d", stages[metrics.StageTLSHandshake].Count)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+	for i := 0; i < 100; i++ {
+		h.Observe(metrics.DefaultLatencyBuckets[0])
+	}
+	h.Observe(metrics.DefaultLatencyBuckets[5])
+
+	snap := h.Snapshot()
+	if snap.Count != 101 {
+		t.Fatalf("预期 101 次记录, 实际: %d", snap.Count)
+	}
+	if q := snap.Quantile(0.5); q != metrics.DefaultLatencyBuckets[0] {
+		t.Errorf("预期 P50 为 %v, 实际: %v", metrics.DefaultLatencyBuckets[0], q)
+	}
+	if q := snap.Quantile(1); q != metrics.DefaultLatencyBuckets[5] {
+		t.Errorf("预期 P100 为 %v, 实际: %v", metrics.DefaultLatencyBuckets[5], q)
+	}
+}
+
+func TestLabelMetrics(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	cfg.MetricsMaxLabelSets = 2
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	ctx := PM.WithLabels(context.Background(), map[string]string{"tenant": "acme"})
+	ctx = PM.WithLabels(ctx, map[string]string{"job": "sync"})
+	conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	conn.Close()
+
+	// 超过组合上限的标签汇总统计
+	for _, tenant := range []string{"a", "b", "c"} {
+		ctx := PM.WithLabels(context.Background(), map[string]string{"tenant": tenant})
+		if conn, err := pm.DialContext(ctx, "tcp", echoAddr); err == nil {
+			conn.Close()
+		}
+	}
+
+	stats := pm.GetMetrics().LabelStats
+	want := metrics.LabelStats{Connections: 1, BytesSent: 5, BytesReceived: 5}
+	if got := stats["job=sync,tenant=acme"]; got != want {
+		t.Errorf("预期 %+v, 实际: %+v", want, got)
+	}
+	if len(stats) != 3 || stats[metrics.OverflowLabelKey].Connections != 2 {
+		t.Errorf("预期 2 个标签组合和 2 次溢出连接, 实际: %+v", stats)
+	}
+
+	// UpdateConfig 调整上限，负数表示不限制
+	next := *pm.Config
+	next.MetricsMaxLabelSets = -1
+	if err := pm.UpdateConfig(&next); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	ctx = PM.WithLabels(context.Background(), map[string]string{"tenant": "d"})
+	if conn, err := pm.DialContext(ctx, "tcp", echoAddr); err == nil {
+		conn.Close()
+	}
+	if stats := pm.GetMetrics().LabelStats; stats["tenant=d"].Connections != 1 {
+		t.Errorf("更新配置后不限制标签组合, 实际: %+v", stats)
+	}
+
+	// 0 使用默认上限
+	mc := metrics.NewMetricsCollector()
+	mc.SetMaxLabelSets(0)
+	for i := 0; i <= metrics.DefaultMaxLabelSets; i++ {
+		mc.Labeled(map[string]string{"tenant": strconv.Itoa(i)}).AddConnection()
+	}
+	if n := mc.GetSnapshot().LabelStats[metrics.OverflowLabelKey].Connections; n != 1 {
+		t.Errorf("预期 0 使用默认上限 %d, 溢出连接: %d", metrics.DefaultMaxLabelSets, n)
+	}
+}
+
+func TestMetricsSampleRate(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	cfg.MetricsSampleRate = 4
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	ctx := PM.WithLabels(context.Background(), map[string]string{"app": "scanner"})
+	dial := func(n int) {
+		for i := 0; i < n; i++ {
+			conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("通过 HTTP 代理连接失败: %v", err)
+			}
+			conn.Close()
+		}
+	}
+
+	dial(8)
+	m := pm.GetMetrics()
+	if len(m.LabelStats) != 1 {
+		t.Fatalf("预期 1 个标签组合, 实际: %v", m.LabelStats)
+	}
+	for _, stats := range m.LabelStats {
+		if stats.Connections != 8 {
+			t.Errorf("连接计数不应采样, 预期 8, 实际: %d", stats.Connections)
+		}
+	}
+	if m.SampleRate != 4 {
+		t.Errorf("预期采样率 4, 实际: %d", m.SampleRate)
+	}
+	for _, stage := range []metrics.DialStage{metrics.StageTCPConnect, metrics.StageProxyHandshake, metrics.StageTargetReady} {
+		if got := m.StageLatency[stage].Count; got != 2 {
+			t.Errorf("阶段 %s 预期采样 2 次, 实际: %d", stage, got)
+		}
+	}
+
+	// 运行时恢复全量记录
+	pm.SetMetricsSampleRate(1)
+	dial(3)
+	if got := pm.GetMetrics().StageLatency[metrics.StageTargetReady].Count; got != 5 {
+		t.Errorf("恢复全量记录后预期 5 次, 实际: %d", got)
+	}
+}
+
+// TestMetricsRates 测试速率按固定的滑动窗口计算，不受快照间隔影响
+func TestMetricsRates(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	mc := metrics.NewMetricsCollector()
+	mc.SetClock(clk)
+
+	rate := func(window time.Duration) metrics.Rates {
+		t.Helper()
+		for _, r := range mc.GetSnapshot().Rates {
+			if r.Window == window {
+				return r
+			}
+		}
+		t.Fatalf("缺少 %v 窗口", window)
+		return metrics.Rates{}
+	}
+
+	// 正在计数的一秒不计入窗口
+	mc.RecordBytes(1000, 3000)
+	mc.IncrementActiveConnections()
+	if r := rate(time.Second); r.BytesSent != 0 {
+		t.Errorf("未完成的一秒不应计入, 实际: %+v", r)
+	}
+
+	clk.Advance(time.Second)
+	mc.RecordFailure(nil)
+	if r := rate(time.Second); r.BytesSent != 1000 || r.BytesReceived != 3000 || r.Connections != 1 || r.Failures != 0 {
+		t.Errorf("1s 窗口错误: %+v", r)
+	}
+	if r := rate(10 * time.Second); r.BytesSent != 100 || r.Connections != 0.1 {
+		t.Errorf("10s 窗口错误: %+v", r)
+	}
+	if m := mc.GetSnapshot(); m.BandwidthUsage != 400 {
+		t.Errorf("BandwidthUsage 预期 10s 窗口的 400, 实际: %v", m.BandwidthUsage)
+	}
+
+	// 连续快照不改变结果
+	if r := rate(time.Minute); r.BytesReceived != 50 {
+		t.Errorf("1m 窗口错误: %+v", r)
+	}
+	if r := rate(time.Minute); r.BytesReceived != 50 {
+		t.Errorf("重复快照后 1m 窗口不应变化: %+v", r)
+	}
+
+	clk.Advance(10 * time.Second)
+	if r := rate(time.Second); r.Failures != 0 {
+		t.Errorf("1s 窗口应已滑过: %+v", r)
+	}
+	if r := rate(10 * time.Second); r.BytesSent != 0 || r.Failures != 0.1 {
+		t.Errorf("10s 窗口错误: %+v", r)
+	}
+	if r := rate(time.Minute); r.BytesSent != 1000.0/60 {
+		t.Errorf("1m 窗口错误: %+v", r)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if r := rate(time.Minute); r != (metrics.Rates{Window: time.Minute}) {
+		t.Errorf("超过一分钟后速率应为 0: %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := (&metrics.PrometheusExporter{W: &buf}).Export(context.Background(), mc); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	if !strings.Contains(buf.String(), `gohookproxy_sent_bytes_per_second{window="10s"} 0`) {
+		t.Errorf("Prometheus 输出缺少速率 gauge:\n%s", buf.String())
+	}
+}
+
+// TestMetricsRatesConcurrent 测试并发记录的字节数不丢失，SetClock 可以与记录并发调用
+func TestMetricsRatesConcurrent(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	mc := metrics.NewMetricsCollector()
+	mc.SetClock(clk)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				mc.RecordBytes(1, 2)
+			}
+		}()
+	}
+	mc.SetClock(clk)
+	wg.Wait()
+
+	clk.Advance(time.Second)
+	for _, r := range mc.GetSnapshot().Rates {
+		if r.Window == time.Second && (r.BytesSent != 8000 || r.BytesReceived != 16000) {
+			t.Errorf("并发记录的字节数错误: %+v", r)
+		}
+	}
+}
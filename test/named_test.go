@@ -0,0 +1,142 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestNamedProxies 测试规则指定的命名代理和 Use 选择的命名代理
+func TestNamedProxies(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	ruleAddr := startEchoServer(t)
+	_, rulePort, _ := net.SplitHostPort(ruleAddr)
+	main := startProxy(t, proxytest.NewHTTPServer)
+	office := startProxy(t, proxytest.NewSOCKSServer)
+	backup := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = main.Host()
+	cfg.ProxyPort = main.Port()
+	cfg.Proxies = []C.NamedProxy{
+		{Name: "office", ProxyType: C.SOCKS5, ProxyIP: office.Host(), ProxyPort: office.Port()},
+		{Name: "backup", ProxyType: C.HTTP, ProxyIP: backup.Host(), ProxyPort: backup.Port()},
+	}
+	cfg.Rules = []C.Rule{{Type: C.RuleDstPort, Pattern: rulePort, Action: "proxy", Proxy: "office"}}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	dial := func(addr string) {
+		t.Helper()
+		conn, err := pm.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("拨号 %s 失败: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	dial(echoAddr)
+	dial(ruleAddr)
+	if targets := main.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("主代理只应收到未指定代理的目标, 实际: %v", targets)
+	}
+	if targets := office.Targets(); len(targets) != 1 || targets[0] != ruleAddr {
+		t.Errorf("规则指定的代理应收到匹配的目标, 实际: %v", targets)
+	}
+
+	// Use 只替换主代理，规则指定的代理不变
+	if err := pm.Use("backup"); err != nil {
+		t.Fatalf("选择命名代理失败: %v", err)
+	}
+	if pm.Using() != "backup" {
+		t.Errorf("Using 预期 backup, 实际: %q", pm.Using())
+	}
+	dial(echoAddr)
+	dial(ruleAddr)
+	if targets := backup.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("Use 选择的代理应收到未指定代理的目标, 实际: %v", targets)
+	}
+	if targets := office.Targets(); len(targets) != 2 {
+		t.Errorf("规则指定的代理应优先于 Use, 实际: %v", targets)
+	}
+
+	if err := pm.Use("missing"); !errors.Is(err, E.ErrUnknownProxy) {
+		t.Errorf("未知的名称预期 ErrUnknownProxy, 实际: %v", err)
+	}
+	if pm.Using() != "backup" {
+		t.Errorf("选择失败时应保留原来的选择, 实际: %q", pm.Using())
+	}
+
+	// 新配置中仍有该名称时保留选择，没有时恢复为主代理
+	next := *cfg
+	if err := pm.UpdateConfig(&next); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if pm.Using() != "backup" {
+		t.Errorf("UpdateConfig 后应保留仍然存在的选择, 实际: %q", pm.Using())
+	}
+	next.Proxies = cfg.Proxies[:1]
+	if err := pm.UpdateConfig(&next); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if pm.Using() != "" {
+		t.Errorf("UpdateConfig 后选择的代理不存在时应恢复为主代理, 实际: %q", pm.Using())
+	}
+	dial(echoAddr)
+	if targets := main.Targets(); len(targets) != 2 {
+		t.Errorf("恢复后应使用主代理, 实际: %v", targets)
+	}
+
+	if err := pm.Use(""); err != nil || pm.Using() != "" {
+		t.Errorf("空名称应恢复为主代理, 实际: %q, %v", pm.Using(), err)
+	}
+}
+
+// TestNamedProxiesInvalid 测试命名代理的配置检查
+func TestNamedProxiesInvalid(t *testing.T) {
+	base := func() *C.Config {
+		cfg := C.DefaultConfig()
+		cfg.Enable = true
+		cfg.ProxyType = C.SOCKS5
+		cfg.ProxyIP = "127.0.0.1"
+		cfg.ProxyPort = 1080
+		cfg.Proxies = []C.NamedProxy{{Name: "office", ProxyType: C.HTTP, ProxyIP: "127.0.0.1", ProxyPort: 8080}}
+		return cfg
+	}
+	if err := base().Validate(); err != nil {
+		t.Fatalf("有效的配置验证失败: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *C.Config)
+	}{
+		{"缺少名称", func(cfg *C.Config) { cfg.Proxies[0].Name = "" }},
+		{"重复的名称", func(cfg *C.Config) { cfg.Proxies = append(cfg.Proxies, cfg.Proxies[0]) }},
+		{"直连类型", func(cfg *C.Config) { cfg.Proxies[0].ProxyType = C.Direct }},
+		{"缺少端口", func(cfg *C.Config) { cfg.Proxies[0].ProxyPort = 0 }},
+		{"规则引用未知的代理", func(cfg *C.Config) {
+			cfg.Rules = []C.Rule{{Pattern: "*.example.com", Action: "proxy", Proxy: "missing"}}
+		}},
+		{"直连规则指定代理", func(cfg *C.Config) {
+			cfg.Rules = []C.Rule{{Pattern: "*.example.com", Action: "direct", Proxy: "office"}}
+		}},
+	}
+	for _, tt := range tests {
+		cfg := base()
+		tt.modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: 预期验证失败", tt.name)
+		}
+	}
+}
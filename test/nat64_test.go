@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// TestNAT64Translate 测试直连的 IPv4 目标按 RFC 6052 的各种前缀长度转换
+func TestNAT64Translate(t *testing.T) {
+	// RFC 6052 2.4 的示例
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "[2001:db8:c000:221::]:443"},
+		{"2001:db8:100::/40", "[2001:db8:1c0:2:21::]:443"},
+		{"2001:db8:122::/48", "[2001:db8:122:c000:2:2100::]:443"},
+		{"2001:db8:122:300::/56", "[2001:db8:122:3c0:0:221::]:443"},
+		{"2001:db8:122:344::/64", "[2001:db8:122:344:c0:2:2100:0]:443"},
+		{"2001:db8:122:344::/96", "[2001:db8:122:344::c000:221]:443"},
+	}
+	for _, tt := range tests {
+		cfg := C.DefaultConfig()
+		cfg.NAT64 = &C.NAT64Config{Prefix: tt.prefix}
+		pm, err := PM.New(cfg)
+		if err != nil {
+			t.Fatalf("创建代理管理器失败: %v", err)
+		}
+		addr, err := pm.ResolveTCPAddr(context.Background(), "tcp", "192.0.2.33:443")
+		if err != nil || addr.String() != tt.want {
+			t.Errorf("%s: 应转换为 %s, 实际: %v, %v", tt.prefix, tt.want, addr, err)
+		}
+		if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp4", "192.0.2.33:443"); addr.String() != "192.0.2.33:443" {
+			t.Errorf("%s: tcp4 不应转换, 实际: %v", tt.prefix, addr)
+		}
+		if addr, _ := pm.ResolveUDPAddr(context.Background(), "udp", "127.0.0.1:53"); addr.String() != "127.0.0.1:53" {
+			t.Errorf("%s: 本机地址不应转换, 实际: %v", tt.prefix, addr)
+		}
+	}
+
+	// 知名前缀只转换公网地址
+	cfg := C.DefaultConfig()
+	cfg.NAT64 = &C.NAT64Config{Prefix: "64:ff9b::/96"}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "8.8.8.8:53"); addr.String() != "[64:ff9b::808:808]:53" {
+		t.Errorf("公网地址应使用知名前缀转换, 实际: %v", addr)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "10.1.2.3:80"); addr.String() != "10.1.2.3:80" {
+		t.Errorf("知名前缀不应转换私有地址, 实际: %v", addr)
+	}
+
+	for _, prefix := range []string{"2001:db8::/36", "10.0.0.0/8", "2001:db8:0:0:ff00::/96"} {
+		cfg.NAT64.Prefix = prefix
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("无效的前缀 %s 应验证失败", prefix)
+		}
+	}
+}
+
+// TestNAT64Discovery 测试通过 ipv4only.arpa 发现前缀，发现失败后按间隔重试
+func TestNAT64Discovery(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	cfg := C.DefaultConfig()
+	cfg.NAT64 = &C.NAT64Config{}
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	// 没有 DNS64 时不转换
+	pm.SetResolver(PM.HostsResolver{Fallback: PM.RemoteResolver{}})
+	if _, err := pm.NAT64Prefix(context.Background()); !errors.Is(err, E.ErrNAT64PrefixNotFound) {
+		t.Errorf("没有合成地址时应返回 ErrNAT64PrefixNotFound, 实际: %v", err)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "192.0.2.33:443"); addr.String() != "192.0.2.33:443" {
+		t.Errorf("没有前缀时不应转换, 实际: %v", addr)
+	}
+
+	pm.SetResolver(PM.HostsResolver{Hosts: map[string][]netip.Addr{
+		"ipv4only.arpa": {netip.MustParseAddr("192.0.0.170"), netip.MustParseAddr("2001:db8:122:344:c0:0:aa00:0")},
+	}})
+	if _, err := pm.NAT64Prefix(context.Background()); err == nil {
+		t.Error("重试间隔内应继续使用失败的结果")
+	}
+	fake.Advance(time.Minute)
+	prefix, err := pm.NAT64Prefix(context.Background())
+	if err != nil || prefix.String() != "2001:db8:122:344::/64" {
+		t.Fatalf("应发现 /64 前缀, 实际: %v, %v", prefix, err)
+	}
+	if addr, _ := pm.ResolveTCPAddr(context.Background(), "tcp", "192.0.2.33:443"); addr.String() != "[2001:db8:122:344:c0:2:2100:0]:443" {
+		t.Errorf("应使用发现的前缀转换, 实际: %v", addr)
+	}
+
+	cfg.NAT64 = nil
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if _, err := pm.NAT64Prefix(context.Background()); !errors.Is(err, E.ErrNAT64PrefixNotFound) {
+		t.Errorf("未配置 NAT64 时应返回 ErrNAT64PrefixNotFound, 实际: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// withNegativeCache 设置失败缓存时间并启用指标收集
+func withNegativeCache(ttl time.Duration) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.MetricsEnable = true
+		cfg.NegativeCacheTTL = ttl
+	}
+}
+
+// TestNegativeCache 测试代理按策略拒绝目标后，TTL 内到同一目标的拨号直接失败
+func TestNegativeCache(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	otherAddr := startEchoServer(t)
+
+	tests := []struct {
+		name      string
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+		opt       proxytest.Option
+		cause     error
+	}{
+		{"http 403", C.HTTP, proxytest.NewHTTPServer, proxytest.WithStatus(403), E.ErrProxyForbidden},
+		{"http 451", C.HTTP, proxytest.NewHTTPServer, proxytest.WithStatus(451), E.ErrProxyForbidden},
+		{"socks5 not allowed", C.SOCKS5, proxytest.NewSOCKSServer, proxytest.WithReplyCode(0x02), E.ErrSOCKS5NotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, tt.opt)
+			fake := clock.NewFake(time.Now())
+			pm := newClockManager(t, fake, tt.proxyType, srv.Host(), srv.Port(), withNegativeCache(5*time.Second))
+
+			if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, tt.cause) || errors.Is(err, E.ErrRecentFailure) {
+				t.Fatalf("第一次拨号应返回代理的拒绝, 实际: %v", err)
+			}
+			if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrRecentFailure) || !errors.Is(err, tt.cause) {
+				t.Errorf("TTL 内的拨号应返回缓存的失败, 实际: %v", err)
+			}
+			if n := len(srv.Targets()); n != 1 {
+				t.Errorf("缓存的失败不应再请求代理, 实际请求 %d 次", n)
+			}
+			if _, err := pm.Dial("tcp", otherAddr); errors.Is(err, E.ErrRecentFailure) {
+				t.Errorf("其他目标不应命中缓存: %v", err)
+			}
+			if hits := pm.GetMetrics().NegativeCacheHits; hits != 1 {
+				t.Errorf("缓存命中数应为 1, 实际: %d", hits)
+			}
+
+			fake.Advance(5 * time.Second)
+			if _, err := pm.Dial("tcp", echoAddr); errors.Is(err, E.ErrRecentFailure) {
+				t.Errorf("过期后应重新请求代理, 实际: %v", err)
+			}
+			if n := len(srv.Targets()); n != 3 {
+				t.Errorf("过期后应重新请求代理, 实际请求 %d 次", n)
+			}
+		})
+	}
+}
+
+// TestNegativeCacheTransient 测试非策略性的失败和关闭缓存时每次拨号都请求代理
+func TestNegativeCacheTransient(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	srv := startProxy(t, proxytest.NewHTTPServer, proxytest.WithStatus(502))
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withNegativeCache(5*time.Second))
+	for i := 0; i < 2; i++ {
+		if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrProxyProtocol) || errors.Is(err, E.ErrProxyForbidden) {
+			t.Errorf("502 应返回 ErrProxyProtocol 且不是 ErrProxyForbidden, 实际: %v", err)
+		}
+	}
+	if n := len(srv.Targets()); n != 2 {
+		t.Errorf("临时性失败不应缓存, 实际请求 %d 次", n)
+	}
+
+	srv = startProxy(t, proxytest.NewHTTPServer, proxytest.WithStatus(403))
+	pm = newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withNegativeCache(0))
+	for i := 0; i < 2; i++ {
+		if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrProxyForbidden) || !errors.Is(err, E.ErrProxyProtocol) {
+			t.Errorf("403 应同时匹配 ErrProxyForbidden 和 ErrProxyProtocol, 实际: %v", err)
+		}
+	}
+	if n := len(srv.Targets()); n != 2 {
+		t.Errorf("NegativeCacheTTL 为 0 时不应缓存, 实际请求 %d 次", n)
+	}
+}
+
+// TestNegativeCacheCredentials 测试失败按凭证缓存，指定了 ProxyAuthorizer 的拨号不使用缓存
+func TestNegativeCacheCredentials(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer, proxytest.WithStatus(403))
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withNegativeCache(5*time.Second))
+
+	tenantA := PM.WithCredentials(context.Background(), PM.Credentials{User: "tenant", Pass: "a"})
+	tenantB := PM.WithCredentials(context.Background(), PM.Credentials{User: "tenant", Pass: "b"})
+	if _, err := pm.DialContext(tenantA, "tcp", echoAddr); errors.Is(err, E.ErrRecentFailure) {
+		t.Fatalf("第一次拨号不应命中缓存: %v", err)
+	}
+	if _, err := pm.DialContext(tenantA, "tcp", echoAddr); !errors.Is(err, E.ErrRecentFailure) {
+		t.Errorf("同一凭证应命中缓存, 实际: %v", err)
+	}
+	if _, err := pm.DialContext(tenantB, "tcp", echoAddr); errors.Is(err, E.ErrRecentFailure) {
+		t.Errorf("其他凭证不应命中缓存: %v", err)
+	}
+	if _, err := pm.Dial("tcp", echoAddr); errors.Is(err, E.ErrRecentFailure) {
+		t.Errorf("没有凭证的拨号不应命中其他凭证的缓存: %v", err)
+	}
+
+	authorized := PM.WithProxyAuthorization(context.Background(), "Bearer token")
+	for i := 0; i < 2; i++ {
+		if _, err := pm.DialContext(authorized, "tcp", echoAddr); errors.Is(err, E.ErrRecentFailure) {
+			t.Errorf("第 %d 次指定 ProxyAuthorizer 的拨号不应使用缓存: %v", i, err)
+		}
+	}
+	if n := len(srv.Targets()); n != 5 {
+		t.Errorf("代理应收到 5 个请求, 实际: %d", n)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// ticketProvider 返回固定令牌并记录请求的服务主体名
+type ticketProvider struct {
+	mu     sync.Mutex
+	ticket []byte
+	spns   []string
+}
+
+func (p *ticketProvider) Token(ctx context.Context, spn string, challenge []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spns = append(p.spns, spn)
+	return p.ticket, nil
+}
+
+func TestNegotiateAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer, proxytest.WithNegotiate([]byte("ticket")))
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withShortHandshake)
+
+	// 没有令牌提供者时按 Basic 认证失败处理
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Fatalf("预期认证失败, 实际: %v", err)
+	}
+
+	provider := &ticketProvider{ticket: []byte("ticket")}
+	pm.SetNegotiateProvider(provider)
+	for i := 0; i < 2; i++ {
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次 Negotiate 认证失败: %v", i+1, err)
+		}
+		conn.Close()
+	}
+
+	// 第一次在同一连接上响应 407 质询，之后直接发送令牌
+	if got := srv.Accepted(); got != 3 {
+		t.Errorf("预期代理连接 3 次, 实际: %d", got)
+	}
+	if got := len(srv.Targets()); got != 4 {
+		t.Errorf("预期 4 个 CONNECT 请求, 实际: %d", got)
+	}
+	if len(provider.spns) != 2 || provider.spns[0] != "HTTP/"+srv.Host() {
+		t.Errorf("服务主体名不符: %v", provider.spns)
+	}
+
+	// 错误的令牌不会无限重试
+	provider.ticket = []byte("wrong")
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Errorf("预期令牌被拒绝, 实际: %v", err)
+	}
+}
+
+func TestNegotiateReconnect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPSServer,
+		proxytest.WithNegotiate([]byte("ticket")), proxytest.WithFault(proxytest.AuthClose))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTPS
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HTTPConfig.NegotiateSPN = "HTTP/proxy.example.com"
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	provider := &ticketProvider{ticket: []byte("ticket")}
+	pm.SetNegotiateProvider(provider)
+
+	// 代理在质询后关闭连接，新连接上发送令牌
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("Negotiate 认证失败: %v", err)
+	}
+	conn.Close()
+	if got := srv.Accepted(); got != 2 {
+		t.Errorf("预期代理连接 2 次, 实际: %d", got)
+	}
+	if len(provider.spns) != 1 || provider.spns[0] != cfg.HTTPConfig.NegotiateSPN {
+		t.Errorf("应使用配置的服务主体名: %v", provider.spns)
+	}
+}
@@ -0,0 +1,220 @@
+package test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCA 测试用的根证书，签发代理证书和 OCSP 响应
+type testCA struct {
+	cert   *x509.Certificate
+	key    crypto.Signer
+	pemDir string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成根证书失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pemDir: t.TempDir()}
+}
+
+// rootFile 把根证书写入 PEM 文件
+func (ca *testCA) rootFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(ca.pemDir, "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatalf("写入根证书失败: %v", err)
+	}
+	return path
+}
+
+// issue 签发 127.0.0.1 的代理证书，status 不为负时装订对应状态的 OCSP 响应，nextUpdate 为响应的过期时间
+func (ca *testCA) issue(t *testing.T, serial int64, status int, nextUpdate time.Time) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("签发代理证书失败: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}
+	if status < 0 {
+		return cert
+	}
+	resp := ocsp.Response{
+		Status:       status,
+		SerialNumber: tmpl.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		resp.RevokedAt = time.Now().Add(-time.Minute)
+	}
+	cert.OCSPStaple, err = ocsp.CreateResponse(ca.cert, ca.cert, resp, ca.key)
+	if err != nil {
+		t.Fatalf("生成 OCSP 响应失败: %v", err)
+	}
+	return cert
+}
+
+// spkiPin 返回证书公钥的 SHA256 指纹
+func spkiPin(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("解析证书失败: %v", err)
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TestProxyOCSPStaple 测试要求 HTTPS 代理装订有效的 OCSP 响应
+func TestProxyOCSPStaple(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	ca := newTestCA(t)
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		cert tls.Certificate
+		want error
+	}{
+		{"good", ca.issue(t, 2, ocsp.Good, valid), nil},
+		{"missing", ca.issue(t, 3, -1, valid), E.ErrOCSPStapleMissing},
+		{"revoked", ca.issue(t, 4, ocsp.Revoked, valid), E.ErrCertRevoked},
+		{"expired", ca.issue(t, 5, ocsp.Good, time.Now().Add(-time.Minute)), E.ErrOCSPStapleInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startProxy(t, proxytest.NewHTTPSServer, proxytest.WithCertificate(tt.cert))
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = C.HTTPS
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+			cfg.HTTPConfig.SkipVerify = false
+			cfg.HTTPConfig.RootCAFile = ca.rootFile(t)
+			cfg.HTTPConfig.RequireOCSPStaple = true
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+			conn, err := pm.Dial("tcp", echoAddr)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) || !errors.Is(err, E.ErrTLSHandshake) {
+					t.Fatalf("应返回 %v, 实际: %v", tt.want, err)
+				}
+				if n := len(srv.Targets()); n != 0 {
+					t.Errorf("验证失败时不应发送 CONNECT, 实际: %d", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("拨号失败: %v", err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("回显失败: %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+// TestProxyCertPin 测试代理证书指纹在跳过证书链验证时仍然生效
+func TestProxyCertPin(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	ca := newTestCA(t)
+	cert := ca.issue(t, 2, ocsp.Good, time.Now().Add(time.Hour))
+	srv := startProxy(t, proxytest.NewHTTPSServer, proxytest.WithCertificate(cert))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTPS
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HTTPConfig.SkipVerify = true
+	cfg.HTTPConfig.RequireOCSPStaple = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("跳过验证且没有指纹时要求 OCSP 装订应验证失败")
+	}
+
+	other := sha256.Sum256([]byte("other"))
+	cfg.HTTPConfig.PinnedSHA256 = []string{base64.StdEncoding.EncodeToString(other[:])}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrCertPinMismatch) {
+		t.Errorf("指纹不匹配时应返回 ErrCertPinMismatch, 实际: %v", err)
+	}
+
+	cfg.HTTPConfig.PinnedSHA256 = append(cfg.HTTPConfig.PinnedSHA256, spkiPin(t, cert))
+	pm, err = PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("任意一个指纹匹配时拨号应成功: %v", err)
+	}
+	conn.Close()
+
+	cfg.HTTPConfig.PinnedSHA256 = []string{"not-base64"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("无效的指纹应验证失败")
+	}
+}
@@ -0,0 +1,218 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// otlpRequest Collector 收到的一次推送
+type otlpRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// startOTLPCollector 启动返回 status 的 OTLP/HTTP 接收端，收到的推送写入返回的 channel
+func startOTLPCollector(t *testing.T, status int) (string, chan otlpRequest) {
+	requests := make(chan otlpRequest, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case requests <- otlpRequest{header: r.Header, body: body}:
+		default:
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/v1/metrics", requests
+}
+
+// otlpAttributes 解码 KeyValue 列表中的字符串属性
+func otlpAttributes(t *testing.T, kvs []interface{}) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range kvs {
+		f := pbFields(t, kv.([]byte))
+		value := pbFields(t, f[2][0].([]byte))
+		attrs[string(f[1][0].([]byte))] = string(value[1][0].([]byte))
+	}
+	return attrs
+}
+
+// TestOTLPExport 测试关闭时推送最后一次指标，计数器、路由决策和带 trace_id 的直方图都按 OTLP 编码
+func TestOTLPExport(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	endpoint, requests := startOTLPCollector(t, http.StatusOK)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	cfg.Rules = []C.Rule{{Pattern: "localhost", Action: "direct"}}
+	cfg.OTLP = &C.OTLPConfig{
+		Endpoint: endpoint,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Resource: map[string]string{"service.name": "otlp-test"},
+		Interval: time.Hour,
+	}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	_, port, _ := net.SplitHostPort(echoAddr)
+	for _, addr := range []string{echoAddr, net.JoinHostPort("localhost", port)} {
+		conn, err := h.DialContext(PM.WithTraceID(context.Background(), traceID), "tcp", addr)
+		if err != nil {
+			t.Fatalf("拨号 %s 失败: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	// Prometheus 输出同一组指标族
+	var text bytes.Buffer
+	(&metrics.PrometheusExporter{W: &text}).Export(context.Background(), pm.Metrics)
+	if !strings.Contains(text.String(), `gohookproxy_hook_decisions_total{action="direct"} 1`) {
+		t.Errorf("OpenMetrics 输出缺少路由决策计数:\n%s", text.String())
+	}
+
+	select {
+	case <-requests:
+		t.Fatal("推送间隔未到时不应推送")
+	default:
+	}
+	pm.UpdateConfig(nil)
+
+	var req otlpRequest
+	select {
+	case req = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("关闭时应推送最后一次指标")
+	}
+	if req.header.Get("Content-Type") != metrics.OTLPContentType || req.header.Get("Authorization") != "Bearer token" {
+		t.Errorf("推送的请求头不符: %v", req.header)
+	}
+
+	rm := pbFields(t, pbFields(t, req.body)[1][0].([]byte))
+	resource := otlpAttributes(t, pbFields(t, rm[1][0].([]byte))[1])
+	if resource["service.name"] != "otlp-test" {
+		t.Errorf("资源属性应包含 service.name, 实际: %v", resource)
+	}
+	sm := pbFields(t, rm[2][0].([]byte))
+	if scope := pbFields(t, sm[1][0].([]byte)); string(scope[1][0].([]byte)) != metrics.OTLPScope {
+		t.Errorf("InstrumentationScope 不符: %s", scope[1][0])
+	}
+	byName := make(map[string]map[int][]interface{})
+	for _, m := range sm[2] {
+		metric := pbFields(t, m.([]byte))
+		byName[string(metric[1][0].([]byte))] = metric
+	}
+
+	// 计数器为累计单调的 Sum
+	decisions := byName["gohookproxy_hook_decisions"]
+	if decisions == nil || decisions[7] == nil {
+		t.Fatalf("路由决策应导出为 Sum")
+	}
+	sum := pbFields(t, decisions[7][0].([]byte))
+	if sum[2][0].(uint64) != 2 || sum[3][0].(uint64) != 1 {
+		t.Errorf("Sum 应为累计单调, 实际: %v %v", sum[2], sum[3])
+	}
+	counts := make(map[string]float64)
+	for _, dp := range sum[1] {
+		p := pbFields(t, dp.([]byte))
+		counts[otlpAttributes(t, p[7])["action"]] = math.Float64frombits(binary.LittleEndian.Uint64(p[4][0].([]byte)))
+	}
+	if counts["proxy"] != 1 || counts["direct"] != 1 {
+		t.Errorf("路由决策计数不符: %v", counts)
+	}
+
+	// 拨号延迟为固定分桶的 Histogram，exemplar 关联 trace_id
+	dial := byName["gohookproxy_dial_duration_seconds"]
+	if dial == nil || dial[9] == nil || string(dial[3][0].([]byte)) != "s" {
+		t.Fatalf("拨号延迟应导出为单位为 s 的 Histogram")
+	}
+	found := false
+	for _, dp := range pbFields(t, dial[9][0].([]byte))[1] {
+		p := pbFields(t, dp.([]byte))
+		if otlpAttributes(t, p[9])["stage"] != string(metrics.StageTargetReady) {
+			continue
+		}
+		if binary.LittleEndian.Uint64(p[4][0].([]byte)) != 1 {
+			t.Errorf("目标就绪延迟应有 1 次观测")
+		}
+		if len(p[6][0].([]byte)) != 8*(len(metrics.DefaultLatencyBuckets)+1) || len(p[7][0].([]byte)) != 8*len(metrics.DefaultLatencyBuckets) {
+			t.Errorf("分桶计数应比分桶上界多一个 +Inf 桶")
+		}
+		for _, e := range p[8] {
+			exemplar := pbFields(t, e.([]byte))
+			if hex.EncodeToString(exemplar[5][0].([]byte)) == traceID {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("目标就绪延迟缺少带 trace_id 的 exemplar")
+	}
+}
+
+// TestOTLPExportError 测试定期推送和推送失败回调
+func TestOTLPExportError(t *testing.T) {
+	endpoint, requests := startOTLPCollector(t, http.StatusServiceUnavailable)
+
+	cfg := C.DefaultConfig()
+	cfg.MetricsEnable = true
+	cfg.OTLP = &C.OTLPConfig{Endpoint: endpoint, Interval: 20 * time.Millisecond}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	errs := make(chan error, 16)
+	pm.OnMetricsExportError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	defer pm.UpdateConfig(nil)
+
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("应按间隔推送指标")
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "503") {
+			t.Errorf("错误应包含 Collector 返回的状态, 实际: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("推送失败应调用回调")
+	}
+
+	cfg = C.DefaultConfig()
+	cfg.OTLP = &C.OTLPConfig{Endpoint: "collector:4318"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少协议的接收地址应验证失败")
+	}
+}
@@ -0,0 +1,308 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/pac"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// TestPACScript 测试 PAC 脚本的语法子集和内置函数
+func TestPACScript(t *testing.T) {
+	script, err := pac.Parse(`
+		/* 公司网络的典型脚本 */
+		var corp = "corp.example.com";
+		function isCorp(host) {
+			return dnsDomainIs(host, "." + corp) || host == corp;
+		}
+		function FindProxyForURL(url, host) {
+			host = host.toLowerCase();
+			if (isPlainHostName(host) || shExpMatch(host, "*.local"))
+				return "DIRECT";
+			if (isCorp(host)) {
+				return "PROXY proxy.corp:8080; DIRECT";
+			}
+			if (isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0"))
+				return "DIRECT";
+			if (url.substring(0, 6) == "https:" && weekdayRange("MON", "FRI", "GMT") && timeRange(9, 17, "GMT"))
+				return "SOCKS5 socks.corp:1080";
+			return "PROXY proxy.corp:8080";
+		}
+	`)
+	if err != nil {
+		t.Fatalf("解析脚本失败: %v", err)
+	}
+
+	env := &pac.Env{
+		Resolve: func(ctx context.Context, host string) ([]net.IP, error) {
+			if host == "intranet.example.org" {
+				return []net.IP{net.ParseIP("10.1.2.3")}, nil
+			}
+			return nil, fmt.Errorf("no such host %s", host)
+		},
+		// 2024-01-03 是星期三
+		Now: func() time.Time { return time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC) },
+	}
+	tests := []struct {
+		url, host, want string
+	}{
+		{"http://wiki/", "wiki", "DIRECT"},
+		{"http://printer.local/", "printer.local", "DIRECT"},
+		{"https://git.corp.example.com/", "Git.Corp.Example.com", "PROXY proxy.corp:8080; DIRECT"},
+		{"http://intranet.example.org/", "intranet.example.org", "DIRECT"},
+		{"https://example.com/", "example.com", "SOCKS5 socks.corp:1080"},
+		{"http://example.com/", "example.com", "PROXY proxy.corp:8080"},
+	}
+	for _, tt := range tests {
+		got, err := script.FindProxyForURL(context.Background(), env, tt.url, tt.host)
+		if err != nil {
+			t.Errorf("执行 %s 失败: %v", tt.host, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s 的结果应为 %q, 实际: %q", tt.host, tt.want, got)
+		}
+	}
+
+	// 工作时间之外不走 SOCKS
+	env.Now = func() time.Time { return time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC) }
+	if got, _ := script.FindProxyForURL(context.Background(), env, "https://example.com/", "example.com"); got != "PROXY proxy.corp:8080" {
+		t.Errorf("周六不应命中 weekdayRange, 实际: %q", got)
+	}
+
+	proxies, err := pac.ParseResult("PROXY a:8080; socks5 b:1080;DIRECT")
+	if err != nil {
+		t.Fatalf("解析结果失败: %v", err)
+	}
+	if len(proxies) != 3 || proxies[0].String() != "PROXY a:8080" || proxies[1].Type != "SOCKS5" || proxies[2].Type != "DIRECT" {
+		t.Errorf("结果解析错误: %v", proxies)
+	}
+	for _, bad := range []string{"QUIC a:443", "PROXY a", "DIRECT a:1"} {
+		if _, err := pac.ParseResult(bad); err == nil {
+			t.Errorf("%q 应解析失败", bad)
+		}
+	}
+
+	for _, src := range []string{
+		`function f(url, host) { return "DIRECT"; }`,
+		`function FindProxyForURL(url, host) { return (; }`,
+	} {
+		if _, err := pac.Parse(src); err == nil {
+			t.Errorf("脚本应解析失败: %s", src)
+		}
+	}
+
+	// 无限递归在调用深度上限处失败
+	loop, err := pac.Parse(`function f() { return f(); } function FindProxyForURL(url, host) { return f(); }`)
+	if err != nil {
+		t.Fatalf("解析脚本失败: %v", err)
+	}
+	if _, err := loop.FindProxyForURL(context.Background(), nil, "http://a/", "a"); err == nil {
+		t.Error("无限递归应返回错误")
+	}
+}
+
+// TestPACRouting 测试管理器按 PAC 脚本直连、走脚本给出的代理或回退到下一个路径
+func TestPACRouting(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+	_, fallbackPort, _ := net.SplitHostPort(startEchoServer(t))
+	_, rulePort, _ := net.SplitHostPort(startEchoServer(t))
+	main := startProxy(t, proxytest.NewHTTPServer)
+	socks := startProxy(t, proxytest.NewSOCKSServer)
+
+	// 127.0.0.1:1 上没有监听，发往 fallbackPort 的连接在第一个路径失败后使用 SOCKS 代理
+	src := fmt.Sprintf(`
+		function FindProxyForURL(url, host) {
+			if (host == "127.0.0.1") return "DIRECT";
+			if (shExpMatch(url, "*:%[2]s/")) return "PROXY 127.0.0.1:1; SOCKS %[1]s";
+			if (dnsDomainIs(host, "localhost")) return "SOCKS5 %[1]s";
+			return "DIRECT";
+		}`, socks.Addr(), fallbackPort)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		fmt.Fprint(w, src)
+	}))
+	defer server.Close()
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = main.Host()
+	cfg.ProxyPort = main.Port()
+	cfg.PACURL = server.URL + "/proxy.pac"
+	cfg.Rules = []C.Rule{{Type: C.RuleDstPort, Pattern: rulePort, Action: C.ActionProxy}}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	dial := func(addr string) {
+		t.Helper()
+		conn, err := pm.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("连接 %s 失败: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	if d := pm.Explain("tcp", echoAddr); d.Action != rules.Direct || d.Reason != "pac DIRECT" {
+		t.Errorf("脚本返回 DIRECT 的目标应直连, 实际: %v", d)
+	}
+	dial(echoAddr)
+	if n := len(main.Targets()) + len(socks.Targets()); n != 0 {
+		t.Errorf("直连的目标不应经过代理, 实际代理收到 %d 个请求", n)
+	}
+
+	dial(net.JoinHostPort("localhost", echoPort))
+	if targets := socks.Targets(); len(targets) != 1 || !strings.HasPrefix(targets[0], "localhost:") {
+		t.Errorf("应通过脚本给出的 SOCKS 代理连接且由代理解析主机名, 实际: %v", targets)
+	}
+
+	dial(net.JoinHostPort("localhost", fallbackPort))
+	if targets := socks.Targets(); len(targets) != 2 || targets[1] != net.JoinHostPort("localhost", fallbackPort) {
+		t.Errorf("第一个代理不可用时应回退到下一个, 实际: %v", targets)
+	}
+
+	// 命中规则的目标不执行脚本
+	dial(net.JoinHostPort("localhost", rulePort))
+	if targets := main.Targets(); len(targets) != 1 || targets[0] != net.JoinHostPort("localhost", rulePort) {
+		t.Errorf("命中规则的目标应走配置的代理, 实际: %v", targets)
+	}
+
+	if d := pm.Explain("tcp", socks.Addr()); d.Action != rules.Direct || d.Reason != "proxy address" {
+		t.Errorf("发往脚本给出的代理的连接应直连, 实际: %v", d)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("PAC 脚本应只下载一次, 实际 %d 次", n)
+	}
+
+	// 关闭 pac 功能后按默认规则走代理
+	cfg.Features = map[C.Feature]bool{C.FeaturePAC: false}
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if d := pm.Explain("tcp", echoAddr); d.Action != rules.Proxy || d.Reason != "default" {
+		t.Errorf("关闭 pac 功能后应使用默认决策, 实际: %v", d)
+	}
+}
+
+// TestPACFile 测试本地 PAC 文件在创建管理器时加载，脚本错误时创建失败
+func TestPACFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.pac")
+	if err := os.WriteFile(path, []byte(`function FindProxyForURL(url, host) { return url; }`), 0o644); err != nil {
+		t.Fatalf("写入 PAC 文件失败: %v", err)
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 8080
+	cfg.PACFile = path
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	// 脚本返回的 URL 不是合法的结果，保持默认决策
+	if d := pm.Explain("tcp", "example.com:443"); d.Reason != "default" {
+		t.Errorf("脚本结果无效时应保持默认决策, 实际: %v", d)
+	}
+
+	if err := os.WriteFile(path, []byte(`function FindProxyForURL(url, host) {`), 0o644); err != nil {
+		t.Fatalf("写入 PAC 文件失败: %v", err)
+	}
+	if _, err := PM.New(cfg); !errors.Is(err, E.ErrPACScript) {
+		t.Errorf("脚本语法错误应返回 ErrPACScript, 实际: %v", err)
+	}
+
+	cfg.PACURL = "ftp://example.com/proxy.pac"
+	cfg.PACFile = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("非 http 的 pac_url 应验证失败")
+	}
+	cfg.PACURL = "http://example.com/proxy.pac"
+	cfg.PACFile = path
+	if err := cfg.Validate(); err == nil {
+		t.Error("同时设置 pac_url 和 pac_file 应验证失败")
+	}
+}
+
+// TestPACRefresh 测试刷新 PAC 脚本时在后台下载，下载期间继续使用旧脚本
+func TestPACRefresh(t *testing.T) {
+	var result atomic.Value
+	result.Store("DIRECT")
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一次之后的下载等待 release
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, `function FindProxyForURL(url, host) { return %q; }`, result.Load())
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 8080
+	cfg.PACURL = server.URL + "/proxy.pac"
+	fake := clock.NewFake(time.Now())
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	if d := pm.Explain("tcp", "example.com:443"); d.Reason != "pac DIRECT" {
+		t.Fatalf("第一次路由应等待脚本下载, 实际: %v", d)
+	}
+
+	result.Store("PROXY 127.0.0.1:3128")
+	fake.Advance(2 * time.Hour)
+	done := make(chan rules.Decision, 1)
+	go func() { done <- pm.Explain("tcp", "example.com:443") }()
+	select {
+	case d := <-done:
+		if d.Reason != "pac DIRECT" {
+			t.Errorf("下载期间应使用旧脚本, 实际: %v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("刷新脚本时路由不应等待下载")
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for pm.Explain("tcp", "example.com:443").Reason != "pac PROXY 127.0.0.1:3128" {
+		if time.Now().After(deadline) {
+			t.Fatal("下载完成后应使用新脚本")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("刷新期间应只下载一次, 实际 %d 次", n)
+	}
+}
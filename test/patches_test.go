@@ -0,0 +1,79 @@
+//go:build !nohook
+
+package test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// callerTarget 调用方自己替换的函数
+//
+//go:noinline
+func callerTarget(s string) string {
+	return "original:" + s
+}
+
+// newDNSHook 创建只替换 DNS 解析的 hook，patches 为 nil 时使用 hook 自己的补丁集合
+func newDNSHook(t *testing.T, patches *gomonkey.Patches) *hook.Hook {
+	t.Helper()
+	cfg := C.DefaultConfig()
+	cfg.DNSHook = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+	if patches != nil {
+		h = hook.NewWithPatches(pm, patches)
+	}
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	return h
+}
+
+// TestHookSharedPatches 测试与调用方的补丁共存时 Disable 只还原 hook 自己的补丁
+func TestHookSharedPatches(t *testing.T) {
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(callerTarget, func(s string) string { return "caller:" + s })
+	patches.ApplyFunc(net.ResolveIPAddr, func(network, address string) (*net.IPAddr, error) {
+		return &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}, nil
+	})
+
+	h := newDNSHook(t, nil)
+	if addr, err := net.ResolveIPAddr("ip", "127.0.0.1"); err != nil || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("启用期间应使用 hook 的解析, 实际: %v, %v", addr, err)
+	}
+	if err := h.Disable(); err != nil {
+		t.Fatalf("禁用 hook 失败: %v", err)
+	}
+
+	if addr, _ := net.ResolveIPAddr("ip", "127.0.0.1"); addr == nil || !addr.IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("Disable 后同一函数应恢复为调用方的补丁, 实际: %v", addr)
+	}
+	if got := callerTarget("x"); got != "caller:x" {
+		t.Errorf("Disable 不应还原调用方的其他补丁, 实际: %s", got)
+	}
+}
+
+// TestHookOwnPatches 测试补丁集合交给 hook 时 Disable 还原其中的全部补丁
+func TestHookOwnPatches(t *testing.T) {
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(callerTarget, func(s string) string { return "caller:" + s })
+
+	h := newDNSHook(t, patches)
+	if err := h.Disable(); err != nil {
+		t.Fatalf("禁用 hook 失败: %v", err)
+	}
+	if got := callerTarget("x"); got != "original:x" {
+		t.Errorf("hook 独占补丁集合时 Disable 应还原全部补丁, 实际: %s", got)
+	}
+}
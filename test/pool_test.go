@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// echoOnce 写入一次数据并读取回显
+func echoOnce(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("回显不符: %q, %v", buf, err)
+	}
+}
+
+// TestPoolReuse 测试连接池只把隧道复用于同一代理端点、目标和凭证
+func TestPoolReuse(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pool := PM.NewPool(pm, PM.PoolConfig{})
+	defer pool.Close()
+	ctx := context.Background()
+
+	get := func(ctx context.Context, addr string) net.Conn {
+		t.Helper()
+		conn, err := pool.Get(ctx, "tcp", addr)
+		if err != nil {
+			t.Fatalf("获取到 %s 的连接失败: %v", addr, err)
+		}
+		return conn
+	}
+
+	conn := get(ctx, echoAddr)
+	echoOnce(t, conn, "first")
+	pool.Put(conn)
+	if n := pool.Idle(); n != 1 {
+		t.Fatalf("归还后预期 1 个空闲连接, 实际: %d", n)
+	}
+
+	conn = get(ctx, echoAddr)
+	echoOnce(t, conn, "second")
+	if got := srv.Accepted(); got != 1 {
+		t.Errorf("同一目标应复用隧道, 代理收到 %d 个连接", got)
+	}
+	pool.Put(conn)
+
+	// 其他目标和其他凭证不能使用已有的隧道
+	conn = get(ctx, net.JoinHostPort("localhost", echoPort))
+	conn.Close()
+	tenant := PM.WithCredentials(ctx, PM.Credentials{User: "tenant-a", Pass: "circuit-1"})
+	conn = get(tenant, echoAddr)
+	pool.Put(conn)
+	if got := srv.Accepted(); got != 3 {
+		t.Errorf("不同目标或凭证应建立新隧道, 代理收到 %d 个连接", got)
+	}
+
+	// 用户名相同、密码不同的凭证(如 Tor 流隔离)也不能共用隧道
+	conn = get(PM.WithCredentials(ctx, PM.Credentials{User: "tenant-a", Pass: "circuit-2"}), echoAddr)
+	conn.Close()
+	if got := srv.Accepted(); got != 4 {
+		t.Errorf("密码不同应建立新隧道, 代理收到 %d 个连接", got)
+	}
+	get(tenant, echoAddr).Close()
+	if got := srv.Accepted(); got != 4 {
+		t.Errorf("相同凭证应复用隧道, 代理收到 %d 个连接", got)
+	}
+
+	// 更新配置后旧拨号器建立的隧道不再复用
+	next := *cfg
+	if err := pm.UpdateConfig(&next); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	conn = get(ctx, echoAddr)
+	echoOnce(t, conn, "third")
+	if got := srv.Accepted(); got != 5 {
+		t.Errorf("更新配置后应建立新隧道, 代理收到 %d 个连接", got)
+	}
+	if n := pool.Idle(); n != 0 {
+		t.Errorf("失效的空闲连接应被丢弃, 实际: %d", n)
+	}
+	pool.Put(conn)
+
+	// 被对端关闭的连接不再复用
+	srv.Close()
+	if _, err := pool.Get(ctx, "tcp", echoAddr); err == nil {
+		t.Errorf("代理关闭后不应复用已断开的隧道")
+	}
+}
+
+// TestHTTPProxyReusesRefusedConnection 测试代理拒绝 CONNECT 但保持连接时，已认证的连接用于之后的 CONNECT
+func TestHTTPProxyReusesRefusedConnection(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+	port, _ := strconv.Atoi(echoPort)
+
+	tests := []struct {
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.HTTP, proxytest.NewHTTPServer},
+		{C.HTTPS, proxytest.NewHTTPSServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, proxytest.WithConnectPorts(port), proxytest.WithAuth("user", "pass"))
+
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = tt.proxyType
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+			cfg.HTTPConfig.User, cfg.HTTPConfig.Pass = "user", "pass"
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			if _, err := pm.Dial("tcp", "127.0.0.1:1"); !errors.Is(err, E.ErrProxyForbidden) {
+				t.Fatalf("预期代理拒绝目标, 实际: %v", err)
+			}
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("连接 %s 失败: %v", echoAddr, err)
+			}
+			defer conn.Close()
+			echoOnce(t, conn, "ping")
+			if got := srv.Accepted(); got != 1 {
+				t.Errorf("应复用被拒绝后保持的连接, 代理收到 %d 个连接", got)
+			}
+
+			// 建立了隧道的连接绑定在目标上，之后的 CONNECT 使用新连接(换一个目标，避开负缓存)
+			if _, err := pm.Dial("tcp", "127.0.0.1:2"); !errors.Is(err, E.ErrProxyForbidden) {
+				t.Fatalf("预期代理拒绝目标, 实际: %v", err)
+			}
+			if got := srv.Accepted(); got != 2 {
+				t.Errorf("建立隧道后的连接不能再用于 CONNECT, 代理收到 %d 个连接", got)
+			}
+
+			// 其他凭证不能使用上面被拒绝后保持的连接
+			creds := PM.WithCredentials(context.Background(), PM.Credentials{User: "other", Pass: "pass"})
+			if _, err := pm.DialContext(creds, "tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+				t.Errorf("预期其他凭证认证失败, 实际: %v", err)
+			}
+			if got := srv.Accepted(); got != 3 {
+				t.Errorf("其他凭证应使用新连接, 代理收到 %d 个连接", got)
+			}
+		})
+	}
+}
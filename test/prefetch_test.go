@@ -0,0 +1,176 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// countingResolver 记录每个主机名的解析次数，每次解析返回不同的地址
+type countingResolver struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = make(map[string]int)
+	}
+	r.calls[host]++
+	return []net.IPAddr{{IP: net.IPv4(192, 0, 2, byte(r.calls[host]))}}, nil
+}
+
+func (r *countingResolver) count(host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[host]
+}
+
+func TestPrefetchResolver(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	upstream := &countingResolver{}
+	r := PM.NewPrefetchResolver(upstream, fake)
+	r.TTL = time.Minute
+	r.RefreshAhead = 10 * time.Second
+	ctx := context.Background()
+
+	first, err := r.LookupIPAddr(ctx, "hot.test")
+	if err != nil || len(first) != 1 {
+		t.Fatalf("首次解析失败: %v %v", first, err)
+	}
+	if again, _ := r.LookupIPAddr(ctx, "HOT.test"); !again[0].IP.Equal(first[0].IP) || upstream.count("hot.test") != 1 {
+		t.Errorf("命中缓存时不应查询上游: %v calls=%d", again, upstream.count("hot.test"))
+	}
+
+	// 过期前 RefreshAhead 内命中时返回旧结果并在后台刷新
+	fake.Advance(55 * time.Second)
+	if stale, _ := r.LookupIPAddr(ctx, "hot.test"); !stale[0].IP.Equal(first[0].IP) {
+		t.Errorf("刷新期间应返回缓存的结果: %v", stale)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Stats().Prefetches == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if upstream.count("hot.test") != 2 {
+		t.Fatalf("应在后台刷新一次: calls=%d", upstream.count("hot.test"))
+	}
+
+	// 刷新后的结果从刷新时起重新计算过期时间
+	fake.Advance(30 * time.Second)
+	if fresh, _ := r.LookupIPAddr(ctx, "hot.test"); fresh[0].IP.Equal(first[0].IP) || upstream.count("hot.test") != 2 {
+		t.Errorf("应使用刷新后的结果且不再查询上游: %v calls=%d", fresh, upstream.count("hot.test"))
+	}
+
+	stats := r.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Hosts != 1 || stats.HitRate != 0.75 {
+		t.Errorf("缓存统计不符: %+v", stats)
+	}
+}
+
+func TestPrefetchResolverRun(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	upstream := &countingResolver{}
+	r := PM.NewPrefetchResolver(upstream, fake)
+	r.TTL = time.Minute
+	r.RefreshAhead = 10 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.LookupIPAddr(ctx, "hot.test")
+	r.LookupIPAddr(ctx, "cold.test")
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	fake.BlockUntil(1)
+
+	// 两个条目都用过，即将过期时都被刷新；之后没有再解析的 cold.test 过期后被删除
+	fake.Advance(55 * time.Second)
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Stats().Prefetches < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if upstream.count("hot.test") != 2 || upstream.count("cold.test") != 2 {
+		t.Fatalf("即将过期的条目应被刷新: hot=%d cold=%d", upstream.count("hot.test"), upstream.count("cold.test"))
+	}
+
+	r.LookupIPAddr(ctx, "hot.test")
+	fake.Advance(65 * time.Second)
+	deadline = time.Now().Add(2 * time.Second)
+	for r.Stats().Prefetches < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := r.Stats(); stats.Hosts != 1 || upstream.count("hot.test") != 3 || upstream.count("cold.test") != 2 {
+		t.Errorf("没有再解析的条目不应刷新且过期后应删除: %+v cold=%d", stats, upstream.count("cold.test"))
+	}
+
+	cancel()
+	<-done
+}
+
+func TestPrefetchResolverLimits(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	upstream := &countingResolver{}
+	r := PM.NewPrefetchResolver(upstream, fake)
+	r.MaxHosts = 2
+	ctx := context.Background()
+
+	r.LookupIPAddr(ctx, "a.test")
+	r.LookupIPAddr(ctx, "a.test")
+	r.LookupIPAddr(ctx, "b.test")
+	r.LookupIPAddr(ctx, "c.test")
+	if r.Stats().Hosts != 2 {
+		t.Errorf("缓存不应超过 MaxHosts: %+v", r.Stats())
+	}
+	r.LookupIPAddr(ctx, "a.test")
+	if upstream.count("a.test") != 1 {
+		t.Errorf("解析次数最多的条目不应被替换: calls=%d", upstream.count("a.test"))
+	}
+
+	// 上游拒绝本地解析时不缓存，每次都返回错误
+	remote := PM.NewPrefetchResolver(PM.RemoteResolver{}, fake)
+	for i := 0; i < 2; i++ {
+		if _, err := remote.LookupIPAddr(ctx, "remote.test"); !errors.Is(err, E.ErrLocalDNSBlocked) {
+			t.Errorf("预期 ErrLocalDNSBlocked, 实际: %v", err)
+		}
+	}
+	if stats := remote.Stats(); stats.Hosts != 0 || stats.Misses != 2 {
+		t.Errorf("错误结果不应缓存: %+v", stats)
+	}
+}
+
+func TestPrefetchResolverMetrics(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.MetricsEnable = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	r := PM.NewPrefetchResolver(&countingResolver{}, nil)
+	pm.SetResolver(r)
+	pm.Resolver().LookupIPAddr(context.Background(), "metrics.test")
+	pm.Resolver().LookupIPAddr(context.Background(), "metrics.test")
+
+	snapshot := pm.Metrics.GetSnapshot()
+	if snapshot.DNSCache.Hits != 1 || snapshot.DNSCache.Misses != 1 || snapshot.DNSCache.Hosts != 1 {
+		t.Errorf("快照中的缓存统计不符: %+v", snapshot.DNSCache)
+	}
+
+	pm.SetResolver(nil)
+	if snapshot := pm.Metrics.GetSnapshot(); snapshot.DNSCache.Hits != 0 {
+		t.Errorf("换掉解析器后不应再报告缓存统计: %+v", snapshot.DNSCache)
+	}
+}
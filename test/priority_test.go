@@ -0,0 +1,146 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// withScheduler 设置拨号调度和路由规则
+func withScheduler(sched *C.SchedulerConfig, rules ...C.Rule) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.Scheduler = sched
+		cfg.Rules = rules
+	}
+}
+
+// TestPriorityDialQueue 测试并发拨号受限时 interactive 拨号先于排队中的 bulk 拨号
+func TestPriorityDialQueue(t *testing.T) {
+	first, bulk, interactive := startEchoServer(t), startEchoServer(t), startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer, proxytest.WithFault(proxytest.SlowHandshake), proxytest.WithDelay(5*time.Millisecond))
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withScheduler(&C.SchedulerConfig{MaxConcurrentDials: 1}))
+	bulkCtx := PM.WithPriority(context.Background(), C.PriorityBulk)
+
+	var wg sync.WaitGroup
+	dial := func(ctx context.Context, addr string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pm.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				t.Errorf("拨号 %s 失败: %v", addr, err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+
+	// 第一个拨号占用唯一的名额，之后的拨号排队
+	dial(bulkCtx, first)
+	for deadline := time.Now().Add(2 * time.Second); len(srv.Targets()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("代理没有收到第一个拨号")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	dial(bulkCtx, bulk)
+	time.Sleep(20 * time.Millisecond)
+	dial(context.Background(), interactive)
+
+	ctx, cancel := context.WithTimeout(bulkCtx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pm.DialContext(ctx, "tcp", first); !errors.Is(err, E.ErrContextDeadlineExceeded) {
+		t.Errorf("排队时 ctx 超时应返回 ErrContextDeadlineExceeded, 实际: %v", err)
+	}
+
+	wg.Wait()
+	want := []string{first, interactive, bulk}
+	if got := srv.Targets(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("拨号顺序应为 %v, 实际: %v", want, got)
+	}
+}
+
+// TestPriorityRateLimit 测试共享限速时 interactive 的流量抢占 bulk
+func TestPriorityRateLimit(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, port, _ := strings.Cut(echoAddr, ":")
+	srv := startProxy(t, proxytest.NewHTTPServer)
+	// bulk 由规则指定，访问 localhost 的连接为 bulk
+	pm := newTestManager(t, C.HTTP, srv.Host(), srv.Port(), withScheduler(&C.SchedulerConfig{Rate: 256 << 10, Burst: 16 << 10},
+		C.Rule{Pattern: "localhost", Priority: C.PriorityBulk}))
+
+	bulkConn, err := pm.Dial("tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("bulk 拨号失败: %v", err)
+	}
+	defer bulkConn.Close()
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("interactive 拨号失败: %v", err)
+	}
+	defer conn.Close()
+
+	chunk := make([]byte, 8<<10)
+	var bulkBytes int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, err := bulkConn.Write(chunk)
+			atomic.AddInt64(&bulkBytes, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			if _, err := bulkConn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 只有 bulk 时它使用全部带宽
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt64(&bulkBytes); n < 32<<10 {
+		t.Errorf("只有 bulk 流量时应能使用带宽, 实际只写入 %d 字节", n)
+	}
+
+	// interactive 发送 128KB 期间 bulk 几乎得不到带宽
+	before := atomic.LoadInt64(&bulkBytes)
+	start := time.Now()
+	for sent := 0; sent < 128<<10; sent += len(chunk) {
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatalf("interactive 写入失败: %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	during := atomic.LoadInt64(&bulkBytes) - before
+	close(stop)
+	bulkConn.Close()
+	<-done
+
+	if elapsed > 2*time.Second {
+		t.Errorf("interactive 应按整个速率发送, 实际耗时 %v", elapsed)
+	}
+	if during > 32<<10 {
+		t.Errorf("interactive 发送期间 bulk 不应得到带宽, 实际写入 %d 字节", during)
+	}
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// pbFields 解析一层 protobuf 消息，返回字段号对应的原始值，varint 以 uint64 保存
+func pbFields(t *testing.T, b []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("protobuf 字段头无效")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[field] = append(fields[field], v)
+		case 1:
+			fields[field] = append(fields[field], b[:8])
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[field] = append(fields[field], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf("不支持的 protobuf 类型: %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestPrometheusExemplars(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.DialContext(PM.WithTraceID(context.Background(), "trace-1"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 HTTP 代理连接失败: %v", err)
+	}
+	conn.Close()
+
+	ready := pm.GetMetrics().StageLatency[metrics.StageTargetReady]
+	var exemplar *metrics.Exemplar
+	for _, b := range ready.Buckets {
+		if b.Exemplar != nil {
+			exemplar = b.Exemplar
+		}
+	}
+	if exemplar == nil || exemplar.Labels["trace_id"] != "trace-1" || exemplar.Labels["conn_id"] == "" {
+		t.Fatalf("目标就绪延迟缺少 exemplar: %+v", exemplar)
+	}
+	var native int64
+	for _, n := range ready.NativeBuckets {
+		native += n
+	}
+	if native+ready.ZeroCount != ready.Count {
+		t.Errorf("原生分桶计数 %d 与总数 %d 不符", native+ready.ZeroCount, ready.Count)
+	}
+
+	srv := httptest.NewServer(pm.Metrics.PrometheusHandler())
+	defer srv.Close()
+	scrape := func(accept string) (string, []byte) {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("抓取失败: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Type"), body
+	}
+
+	// OpenMetrics 文本: 固定分桶带 exemplar
+	ct, body := scrape("application/openmetrics-text; version=1.0.0")
+	text := string(body)
+	if ct != metrics.OpenMetricsType || !strings.HasSuffix(text, "# EOF\n") {
+		t.Errorf("OpenMetrics 输出格式不符: %s", ct)
+	}
+	if !strings.Contains(text, `stage="target_ready",le=`) || !strings.Contains(text, `trace_id="trace-1"`) {
+		t.Errorf("OpenMetrics 输出缺少带 exemplar 的分桶:\n%s", text)
+	}
+
+	// protobuf: 原生直方图
+	ct, body = scrape(metrics.PrometheusProtobufType)
+	if ct != metrics.PrometheusProtobufType {
+		t.Fatalf("protobuf 输出格式不符: %s", ct)
+	}
+	found := false
+	for len(body) > 0 {
+		l, n := binary.Uvarint(body)
+		family := pbFields(t, body[n:n+int(l)])
+		body = body[n+int(l):]
+		if string(family[1][0].([]byte)) != "gohookproxy_dial_duration_seconds" {
+			continue
+		}
+		for _, m := range family[4] {
+			metric := pbFields(t, m.([]byte))
+			label := pbFields(t, metric[1][0].([]byte))
+			if string(label[2][0].([]byte)) != string(metrics.StageTargetReady) {
+				continue
+			}
+			h := pbFields(t, metric[7][0].([]byte))
+			if schema := h[5][0].(uint64); schema != metrics.NativeSchema*2 { // zigzag
+				t.Errorf("原生直方图 schema 不符: %d", schema)
+			}
+			if len(h[12]) == 0 || len(h[13]) == 0 {
+				t.Error("原生直方图缺少分桶")
+			}
+			if len(h[16]) == 0 || !strings.Contains(string(h[16][0].([]byte)), "trace-1") {
+				t.Error("原生直方图缺少 exemplar")
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("protobuf 输出缺少拨号延迟直方图")
+	}
+}
+
+func TestNativeHistogramBuckets(t *testing.T) {
+	h := metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+	h.Observe(0)
+	h.Observe(time.Second)
+	h.Observe(1100 * time.Millisecond)
+
+	snap := h.Snapshot()
+	if snap.ZeroCount != 1 {
+		t.Errorf("零桶计数不符: %d", snap.ZeroCount)
+	}
+	for i, n := range snap.NativeBuckets {
+		lower, upper := metrics.NativeBucketBound(i-1), metrics.NativeBucketBound(i)
+		if n != 1 || lower >= upper {
+			t.Errorf("原生分桶 %d (%v, %v] 计数不符: %d", i, lower, upper, n)
+		}
+	}
+	if len(snap.NativeBuckets) != 2 {
+		t.Errorf("1s 和 1.1s 应落在不同的原生分桶: %v", snap.NativeBuckets)
+	}
+}
@@ -1,13 +1,16 @@
 package test
 
 import (
+	"errors"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
 	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
 	PM "github.com/ba0gu0/GoHookProxy/proxy"
 )
 
@@ -35,7 +38,7 @@ func TestProxyConnections(t *testing.T) {
 		{"HTTP", "127.0.0.1", 9001, C.HTTP},
 		{"HTTPS", "127.0.0.1", 9002, C.HTTPS},
 		{"HTTP2", "127.0.0.1", 9003, C.HTTP2},
-		{"SOCKS4a", "127.0.0.1", 9004, C.SOCKS4},
+		{"SOCKS4a", "127.0.0.1", 9004, C.SOCKS4A},
 		{"SOCKS5", "127.0.0.1", 9005, C.SOCKS5},
 	}
 
@@ -127,3 +130,109 @@ func TestProxyConnections(t *testing.T) {
 		})
 	}
 }
+
+// TestAdaptiveHandshakeTimeout 测试本地代理握手卡住时按 RTT 快速失败
+func TestAdaptiveHandshakeTimeout(t *testing.T) {
+	proxyIP, proxyPort := startBlackhole(t)
+
+	for _, proxyType := range []C.ProxyType{C.SOCKS5, C.HTTP} {
+		t.Run(string(proxyType), func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = proxyType
+			cfg.ProxyIP = proxyIP
+			cfg.ProxyPort = proxyPort
+			cfg.SOCKSConfig.Timeout = 10 * time.Second
+			cfg.SOCKSConfig.AdaptiveTimeout = true
+			cfg.SOCKSConfig.MinHandshakeTimeout = 100 * time.Millisecond
+			cfg.HTTPConfig.Timeout = 10 * time.Second
+			cfg.HTTPConfig.AdaptiveTimeout = true
+			cfg.HTTPConfig.MinHandshakeTimeout = 100 * time.Millisecond
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			start := time.Now()
+			if _, err := pm.Dial("tcp", "example.com:80"); err == nil {
+				t.Fatal("预期握手超时，但连接成功")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("预期握手快速失败, 实际耗时: %v", elapsed)
+			}
+		})
+	}
+}
+
+// TestFixedHandshakeTimeout 测试未启用自适应超时时按配置的 Timeout 限制握手
+func TestFixedHandshakeTimeout(t *testing.T) {
+	proxyIP, proxyPort := startBlackhole(t)
+
+	for _, proxyType := range []C.ProxyType{C.SOCKS5, C.HTTP} {
+		t.Run(string(proxyType), func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = proxyType
+			cfg.ProxyIP = proxyIP
+			cfg.ProxyPort = proxyPort
+			cfg.SOCKSConfig.Timeout = 200 * time.Millisecond
+			cfg.HTTPConfig.Timeout = 200 * time.Millisecond
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			start := time.Now()
+			if _, err := pm.Dial("tcp", "example.com:80"); err == nil {
+				t.Fatal("预期握手超时，但连接成功")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("预期在 Timeout 后失败, 实际耗时: %v", elapsed)
+			}
+		})
+	}
+}
+
+// TestSOCKS5UnknownAddressType 测试 SOCKS5 响应中未知的地址类型返回错误
+func TestSOCKS5UnknownAddressType(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动服务失败: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 512)
+		conn.Read(buf) // 方法协商
+		conn.Write([]byte{0x05, 0x00})
+		conn.Read(buf) // CONNECT 请求
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x05, 0, 0, 0, 0, 0, 0})
+		io.Copy(io.Discard, conn)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = host
+	cfg.ProxyPort, _ = strconv.Atoi(port)
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", "127.0.0.1:80")
+	if err == nil {
+		conn.Close()
+		t.Fatal("预期地址类型错误，但连接成功")
+	}
+	if !errors.Is(err, E.ErrSOCKS5AddressTypeNotSupported) {
+		t.Errorf("预期 ErrSOCKS5AddressTypeNotSupported, 实际: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestProxyAuthorizationContext 测试 ctx 指定的 Proxy-Authorization 头和令牌提供者
+func TestProxyAuthorizationContext(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer,
+		proxytest.WithAuth("user", "pass"),
+		proxytest.WithAuthorization("Bearer static"),
+		proxytest.WithAuthorization("Bearer signed-1"),
+		proxytest.WithAuthorization("Bearer signed-2"))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HTTPConfig.User = "user"
+	cfg.HTTPConfig.Pass = "wrong"
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	if _, err := pm.DialContext(context.Background(), "tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Fatalf("错误的凭证预期 ErrHTTPProxyAuth, 实际: %v", err)
+	}
+
+	// 预先生成的头优先于配置中的凭证
+	ctx := PM.WithProxyAuthorization(context.Background(), "Bearer static")
+	conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("使用 ctx 中的头拨号失败: %v", err)
+	}
+	conn.Close()
+
+	// 令牌提供者在每次拨号时生成新的令牌
+	var calls int32
+	authorizer := PM.ProxyAuthorizerFunc(func(ctx context.Context, proxy string) (string, error) {
+		if proxy != srv.Addr() {
+			t.Errorf("代理地址预期 %s, 实际: %s", srv.Addr(), proxy)
+		}
+		return fmt.Sprintf("Bearer signed-%d", atomic.AddInt32(&calls, 1)), nil
+	})
+	ctx = PM.WithProxyAuthorizer(context.Background(), authorizer)
+	for i := 0; i < 2; i++ {
+		conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("第 %d 次使用令牌提供者拨号失败: %v", i, err)
+		}
+		conn.Close()
+	}
+	want := []string{"Bearer static", "Bearer signed-1", "Bearer signed-2"}
+	if got := srv.Authorizations(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("代理收到的头预期 %v, 实际: %v", want, got)
+	}
+
+	// 令牌提供者失败或令牌被拒绝时返回 ErrHTTPProxyAuth
+	failing := PM.ProxyAuthorizerFunc(func(context.Context, string) (string, error) {
+		return "", errors.New("token issuer unavailable")
+	})
+	if _, err := pm.DialContext(PM.WithProxyAuthorizer(context.Background(), failing), "tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Errorf("令牌提供者失败预期 ErrHTTPProxyAuth, 实际: %v", err)
+	}
+	ctx = PM.WithProxyAuthorization(context.Background(), "Bearer expired")
+	if _, err := pm.DialContext(ctx, "tcp", echoAddr); !errors.Is(err, E.ErrHTTPProxyAuth) {
+		t.Errorf("被拒绝的令牌预期 ErrHTTPProxyAuth, 实际: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// withQuotas 设置配额
+func withQuotas(quotas ...C.Quota) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.Quotas = quotas
+	}
+}
+
+func tenant(name string) context.Context {
+	return PM.WithLabels(context.Background(), map[string]string{"tenant": name})
+}
+
+func TestQuotaBlock(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newTestManager(t, C.HTTP, proxyIP, proxyPort, withQuotas(
+		C.Quota{Label: "tenant", Value: "acme", MaxConns: 1, Action: C.QuotaBlock},
+		C.Quota{Label: "tenant", MaxBytes: 4, Action: C.QuotaBlock},
+	))
+
+	conn, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("第一个连接失败: %v", err)
+	}
+	if _, err := pm.DialContext(tenant("acme"), "tcp", echoAddr); !errors.Is(err, E.ErrQuotaExceeded) {
+		t.Errorf("预期连接数超出配额, 实际: %v", err)
+	}
+
+	// 其他租户不受 acme 的连接数配额影响，但有各自的流量配额
+	other, err := pm.DialContext(tenant("other"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("其他租户连接失败: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Write([]byte("hello")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if _, err := other.Write([]byte("again")); !errors.Is(err, E.ErrQuotaExceeded) {
+		t.Errorf("预期流量超出配额, 实际: %v", err)
+	}
+
+	conn.Close()
+	conn, err = pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("释放后连接失败: %v", err)
+	}
+	conn.Close()
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newTestManager(t, C.HTTP, proxyIP, proxyPort, withQuotas(C.Quota{Label: "tenant", MaxConns: 1, Action: C.QuotaThrottle}))
+
+	conn, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("第一个连接失败: %v", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { conn.Close() })
+
+	start := time.Now()
+	second, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("等待后连接失败: %v", err)
+	}
+	second.Close()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("预期等待连接释放, 实际耗时: %v", elapsed)
+	}
+
+	// 一直没有空位时按 ctx 超时
+	conn, _ = pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(tenant("acme"), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pm.DialContext(ctx, "tcp", echoAddr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("预期 ctx 超时, 实际: %v", err)
+	}
+}
+
+// TestQuotaThrottleBytes 测试流量超出后的限速等待在 deadline 到期或连接关闭时结束
+func TestQuotaThrottleBytes(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newTestManager(t, C.HTTP, proxyIP, proxyPort, withQuotas(C.Quota{Label: "tenant", MaxBytes: 1, Action: C.QuotaThrottle, ThrottleRate: 1}))
+
+	// 超出配额后写入 64 字节需要等待 64 秒
+	payload := make([]byte, 64)
+	conn, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.Write(payload); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("预期限速等待按 deadline 结束, 实际: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("限速等待应在 deadline 到期时结束, 实际耗时: %v", elapsed)
+	}
+
+	other, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { other.Close() })
+	if _, err := other.Write(payload); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("预期限速等待在连接关闭时结束, 实际: %v", err)
+	}
+}
+
+func TestQuotaWarn(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	pm := newTestManager(t, C.HTTP, proxyIP, proxyPort, withQuotas(C.Quota{Label: "tenant", MaxConns: 1, MaxBytes: 1, Action: C.QuotaWarn}))
+
+	var events []PM.QuotaEvent
+	pm.OnQuotaExceeded(func(e PM.QuotaEvent) { events = append(events, e) })
+
+	for i := 0; i < 2; i++ {
+		conn, err := pm.DialContext(tenant("acme"), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("warn 模式不应拒绝连接: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("hi")); err != nil {
+			t.Fatalf("warn 模式不应中断写入: %v", err)
+		}
+	}
+
+	if len(events) != 2 || events[0].Kind != "bytes" || events[1].Kind != "conns" || events[1].Value != "acme" {
+		t.Errorf("预期一次流量和一次连接数通知, 实际: %+v", events)
+	}
+}
+
+func TestQuotaInvalid(t *testing.T) {
+	cfg := C.DefaultConfig()
+	for _, q := range []C.Quota{
+		{Action: C.QuotaBlock},
+		{Label: "tenant", Action: "drop"},
+		{Label: "tenant", MaxBytes: 1, Action: C.QuotaThrottle},
+	} {
+		cfg.Quotas = []C.Quota{q}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("预期配额 %+v 无效", q)
+		}
+	}
+}
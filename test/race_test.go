@@ -0,0 +1,146 @@
+package test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// withRace 设置竞速，主代理的握手超时为 1 秒
+func withRace(race *C.RaceConfig) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.SOCKSConfig.Timeout = time.Second
+		cfg.Race = race
+	}
+}
+
+func TestRaceDial(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	backup := startProxy(t, proxytest.NewSOCKSServer)
+
+	tests := []struct {
+		name string
+		race *C.RaceConfig
+	}{
+		{"direct", &C.RaceConfig{Mode: C.RaceDirect, Delay: 50 * time.Millisecond}},
+		{"proxy", &C.RaceConfig{Mode: C.RaceProxy, Delay: 50 * time.Millisecond, ProxyType: C.SOCKS5, ProxyIP: backup.Host(), ProxyPort: backup.Port()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := startBlackhole(t)
+			pm := newTestManager(t, C.SOCKS5, host, port, withRace(tt.race))
+
+			start := time.Now()
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("竞速拨号失败: %v", err)
+			}
+			defer conn.Close()
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("竞速拨号等待了卡住的主代理: %v", elapsed)
+			}
+
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("回显失败: %q, %v", buf, err)
+			}
+		})
+	}
+
+	if targets := backup.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("备用代理收到的目标地址不符: %v", targets)
+	}
+}
+
+func TestRaceDialPolicy(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	// 不匹配 Patterns 的目标只走主代理
+	host, port := startBlackhole(t)
+	pm := newTestManager(t, C.SOCKS5, host, port, withRace(&C.RaceConfig{Mode: C.RaceDirect, Patterns: []string{"*.example.com"}}))
+	if conn, err := pm.Dial("tcp", echoAddr); err == nil {
+		conn.Close()
+		t.Fatal("不匹配 Patterns 的目标不应竞速")
+	}
+
+	// 直连竞速不用于规则明确要求代理的目标
+	host, port = startBlackhole(t)
+	pm = newTestManager(t, C.SOCKS5, host, port, withRace(&C.RaceConfig{Mode: C.RaceDirect}))
+	pm.Config.Rules = []C.Rule{{Pattern: "127.0.0.1", Action: "proxy"}}
+	if err := pm.UpdateConfig(pm.Config); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if conn, err := pm.Dial("tcp", echoAddr); err == nil {
+		conn.Close()
+		t.Fatal("命中 proxy 规则的目标不应直连竞速")
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.Race = &C.RaceConfig{Mode: "fastest"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("应拒绝未知的竞速模式")
+	}
+	cfg.Race = &C.RaceConfig{Mode: C.RaceProxy, ProxyType: C.SOCKS5, ProxyIP: "127.0.0.1"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("应拒绝缺少端口的备用代理")
+	}
+}
+
+// TestRaceDialHTTP2 测试竞速返回的 HTTP/2 隧道在落败的路径被取消后仍然可用
+func TestRaceDialHTTP2(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	h2IP, h2Port := startHTTP2Proxy(t)
+	stallIP, stallPort := startBlackhole(t)
+
+	tests := []struct {
+		name string
+		cfg  func(cfg *C.Config)
+	}{
+		// 主路径是 HTTP/2 代理，在直连启动前胜出
+		{"primary", func(cfg *C.Config) {
+			cfg.ProxyType, cfg.ProxyIP, cfg.ProxyPort = C.HTTP2, h2IP, h2Port
+			cfg.Race = &C.RaceConfig{Mode: C.RaceDirect, Delay: time.Second}
+		}},
+		// 主代理卡住，第二条路径的 HTTP/2 代理胜出
+		{"secondary", func(cfg *C.Config) {
+			cfg.ProxyType, cfg.ProxyIP, cfg.ProxyPort = C.SOCKS5, stallIP, stallPort
+			cfg.Race = &C.RaceConfig{Mode: C.RaceProxy, Delay: 50 * time.Millisecond, ProxyType: C.HTTP2, ProxyIP: h2IP, ProxyPort: h2Port}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.SOCKSConfig.Timeout = time.Second
+			tt.cfg(cfg)
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+			defer pm.UpdateConfig(nil)
+
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("竞速拨号失败: %v", err)
+			}
+			defer conn.Close()
+			for i := 0; i < 2; i++ {
+				conn.Write([]byte("ping"))
+				buf := make([]byte, 4)
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+					t.Fatalf("第 %d 次回显失败: %q, %v", i, buf, err)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
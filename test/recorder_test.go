@@ -0,0 +1,135 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// countingRecorder 记录收到的调用次数的 Recorder
+type countingRecorder struct {
+	mu       sync.Mutex
+	dials    int
+	failures int
+	sent     int64
+	received int64
+	stages   map[metrics.DialStage]int
+	active   int
+}
+
+func (r *countingRecorder) RecordDial(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dials++
+}
+
+func (r *countingRecorder) RecordFailure(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+}
+
+func (r *countingRecorder) RecordBytes(sent, received int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent += sent
+	r.received += received
+}
+
+func (r *countingRecorder) RecordStage(stage metrics.DialStage, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stages == nil {
+		r.stages = make(map[metrics.DialStage]int)
+	}
+	r.stages[stage]++
+}
+
+func (r *countingRecorder) IncrementActiveConnections() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active++
+}
+
+func (r *countingRecorder) DecrementActiveConnections() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active--
+}
+
+// TestSetRecorder 测试自定义 Recorder 收到拨号、阶段耗时、字节数和活动连接数，MultiRecorder 同时保留内置收集器
+func TestSetRecorder(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	rec := &countingRecorder{}
+	pm.SetRecorder(metrics.MultiRecorder(pm.Metrics, rec))
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	echoOnce(t, conn, "hello")
+	rec.mu.Lock()
+	if rec.dials != 2 || rec.active != 1 {
+		t.Errorf("预期开始和建立各记录一次拨号且活动连接为 1, 实际: dials=%d active=%d", rec.dials, rec.active)
+	}
+	if rec.stages[metrics.StageTCPConnect] != 1 || rec.stages[metrics.StageTargetReady] != 1 {
+		t.Errorf("未记录拨号阶段耗时: %v", rec.stages)
+	}
+	if rec.sent != 5 || rec.received != 5 {
+		t.Errorf("字节数不符: sent=%d received=%d", rec.sent, rec.received)
+	}
+	rec.mu.Unlock()
+
+	conn.Close()
+	conn.Close()
+	rec.mu.Lock()
+	if rec.active != 0 {
+		t.Errorf("关闭后活动连接应为 0, 实际: %d", rec.active)
+	}
+	rec.mu.Unlock()
+
+	if m := pm.GetMetrics(); m.TotalConnections == 0 || m.BytesSent != 5 {
+		t.Errorf("MultiRecorder 应同时记录到内置收集器: total=%d sent=%d", m.TotalConnections, m.BytesSent)
+	}
+
+	// 代理无法连接目标时记录失败
+	if _, err := pm.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Fatal("预期拨号失败")
+	}
+	rec.mu.Lock()
+	if rec.failures == 0 {
+		t.Errorf("失败的拨号应调用 RecordFailure")
+	}
+	dials := rec.dials
+	rec.mu.Unlock()
+
+	// 恢复后不再通知自定义 Recorder
+	pm.SetRecorder(nil)
+	conn, err = pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	conn.Close()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.dials != dials {
+		t.Errorf("SetRecorder(nil) 后不应再通知自定义 Recorder, 实际: %d 次拨号", rec.dials-dials)
+	}
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	"github.com/ba0gu0/GoHookProxy/report"
+)
+
+func TestReportRender(t *testing.T) {
+	m := &metrics.Metrics{TotalConnections: 4, FailedConnections: 1, ConnectionDuration: 8 * time.Millisecond}
+	s := report.FromMetrics(m, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if s.AvgDurationMs != 2 {
+		t.Errorf("平均耗时不符: %v", s.AvgDurationMs)
+	}
+	// 没有连接时不应除零
+	if empty := report.FromMetrics(&metrics.Metrics{}, time.Now()); empty.AvgDurationMs != 0 {
+		t.Errorf("没有连接时平均耗时应为 0: %v", empty.AvgDurationMs)
+	}
+
+	data, err := report.Render(s, report.JSON)
+	if err != nil {
+		t.Fatalf("渲染 JSON 失败: %v", err)
+	}
+	var decoded report.Snapshot
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.TotalConnections != 4 {
+		t.Errorf("JSON 报告无法解析: %s, %v", data, err)
+	}
+
+	data, _ = report.Render(s, report.Logfmt)
+	if line := string(data); !strings.HasPrefix(line, "time=2024-01-01T00:00:00Z ") || !strings.Contains(line, " failed_connections=1 ") {
+		t.Errorf("logfmt 报告不符: %q", line)
+	}
+
+	data, _ = report.Render(s, report.Table)
+	if !strings.Contains(string(data), "total_connections   4") {
+		t.Errorf("表格报告不符:\n%s", data)
+	}
+
+	if _, err := report.Render(s, "xml"); err == nil {
+		t.Error("应拒绝未知格式")
+	}
+}
+
+func TestReportSinks(t *testing.T) {
+	source := func() *metrics.Metrics { return &metrics.Metrics{TotalConnections: 1} }
+
+	var posted []byte
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		posted, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "report.log")
+	var buf bytes.Buffer
+	r := report.New(source, report.JSON, report.Writer(&buf), report.File(path), report.HTTPPost(srv.URL, nil))
+	if err := r.Report(context.Background()); err != nil {
+		t.Fatalf("发送报告失败: %v", err)
+	}
+	r.Report(context.Background())
+
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("Writer 应收到 2 份报告, 实际: %d", n)
+	}
+	if data, _ := os.ReadFile(path); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("文件应追加 2 份报告:\n%s", data)
+	}
+	if contentType != "application/json" || !bytes.Contains(posted, []byte(`"total_connections":1`)) {
+		t.Errorf("HTTP 报告不符: %s %s", contentType, posted)
+	}
+
+	// 一个目的地失败不影响其他目的地
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	var errs int
+	buf.Reset()
+	r = report.New(source, report.Logfmt, report.HTTPPost(failing.URL, nil), report.Writer(&buf))
+	r.OnError = func(error) { errs++ }
+	if err := r.Report(context.Background()); err == nil || errs != 1 || buf.Len() == 0 {
+		t.Errorf("失败的目的地应报告错误且不影响其他目的地: %v, %d", err, errs)
+	}
+}
+
+func TestReportRun(t *testing.T) {
+	var buf bytes.Buffer
+	r := report.New(func() *metrics.Metrics { return &metrics.Metrics{} }, report.Logfmt, report.Writer(&buf))
+	r.Interval = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Millisecond)
+	defer cancel()
+	r.Run(ctx)
+
+	if n := strings.Count(buf.String(), "\n"); n < 3 {
+		t.Errorf("应定期发送报告, 实际: %d", n)
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestHostsResolver(t *testing.T) {
+	pm, err := PM.New(C.DefaultConfig())
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pm.SetResolver(PM.HostsResolver{
+		Hosts:    map[string][]netip.Addr{"Echo.Test": {netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}},
+		Fallback: PM.RemoteResolver{},
+	})
+
+	addr, err := pm.ResolveTCPAddr(context.Background(), "tcp", "echo.test:80")
+	if err != nil || addr.String() != "127.0.0.1:80" {
+		t.Errorf("tcp 应优先返回 IPv4 地址: %v, %v", addr, err)
+	}
+	addr, err = pm.ResolveTCPAddr(context.Background(), "tcp6", "echo.test:http")
+	if err != nil || addr.String() != "[::1]:80" {
+		t.Errorf("tcp6 应返回 IPv6 地址: %v, %v", addr, err)
+	}
+	if _, err := pm.LookupIPAddr(context.Background(), "other.test"); !errors.Is(err, E.ErrLocalDNSBlocked) {
+		t.Errorf("未命中的主机名应交给 Fallback, 实际: %v", err)
+	}
+
+	pm.SetResolver(nil)
+	if _, ok := pm.Resolver().(PM.SystemResolver); !ok {
+		t.Errorf("SetResolver(nil) 应恢复 SystemResolver, 实际: %T", pm.Resolver())
+	}
+}
+
+func TestFakeIPResolver(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, port, _ := net.SplitHostPort(echoAddr)
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.Rules = []C.Rule{{Pattern: "direct.test", Action: "direct"}}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	fake, err := PM.NewFakeIPResolver("198.18.0.0/30")
+	if err != nil {
+		t.Fatalf("创建假 IP 解析器失败: %v", err)
+	}
+	fake.Upstream = PM.HostsResolver{Hosts: map[string][]netip.Addr{"direct.test": {netip.MustParseAddr("127.0.0.1")}}}
+	pm.SetResolver(fake)
+
+	ips, err := pm.LookupIPAddr(context.Background(), "localhost")
+	if err != nil || len(ips) != 1 || ips[0].String() != "198.18.0.1" {
+		t.Fatalf("预期分配 198.18.0.1, 实际: %v, %v", ips, err)
+	}
+	if again, _ := pm.LookupIPAddr(context.Background(), "LOCALHOST."); again[0].String() != "198.18.0.1" {
+		t.Errorf("同一主机名应返回同一地址: %v", again)
+	}
+	direct, _ := pm.LookupIPAddr(context.Background(), "direct.test")
+	pm.LookupIPAddr(context.Background(), "last.test")
+	if _, err := pm.LookupIPAddr(context.Background(), "full.test"); !errors.Is(err, E.ErrFakeIPExhausted) {
+		t.Errorf("地址池用完时预期 ErrFakeIPExhausted, 实际: %v", err)
+	}
+
+	// 连接假 IP 时代理收到主机名
+	conn, err := pm.Dial("tcp", net.JoinHostPort("198.18.0.1", port))
+	if err != nil {
+		t.Fatalf("通过假 IP 拨号失败: %v", err)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("回显失败: %q, %v", buf, err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != net.JoinHostPort("localhost", port) {
+		t.Errorf("代理收到的目标应为主机名: %v", targets)
+	}
+
+	// 规则按还原后的主机名匹配，直连时用 Upstream 解析
+	directAddr := net.JoinHostPort(direct[0].String(), "80")
+	if d := pm.Explain("tcp", directAddr); d.Rule == nil || d.Rule.Pattern != "direct.test" {
+		t.Errorf("假 IP 应按主机名匹配规则: %v", d)
+	}
+	if addr, err := pm.ResolveTCPAddr(context.Background(), "tcp", directAddr); err != nil || addr.String() != "127.0.0.1:80" {
+		t.Errorf("直连目标应由 Upstream 解析: %v, %v", addr, err)
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || len(query.Questions) != 1 {
+			http.Error(w, "bad message", http.StatusBadRequest)
+			return
+		}
+		q := query.Questions[0]
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+			Questions: query.Questions,
+		}
+		switch {
+		case q.Name.String() != "doh.test.":
+			reply.RCode = dnsmessage.RCodeNameError
+		case q.Type == dnsmessage.TypeA:
+			reply.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 1, 2, 3}},
+			}}
+		}
+		packed, _ := reply.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer srv.Close()
+
+	r := PM.NewDoHResolver(srv.URL)
+	ips, err := r.LookupIPAddr(context.Background(), "doh.test")
+	if err != nil || len(ips) != 1 || ips[0].String() != "10.1.2.3" {
+		t.Errorf("DoH 解析结果不符: %v, %v", ips, err)
+	}
+
+	_, err = r.LookupIPAddr(context.Background(), "missing.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("NXDOMAIN 应返回 IsNotFound 的 DNSError, 实际: %v", err)
+	}
+
+	srv.Config.Handler = http.NotFoundHandler()
+	if _, err := r.LookupIPAddr(context.Background(), "doh.test"); !errors.Is(err, E.ErrDoHQuery) {
+		t.Errorf("服务器返回错误状态时预期 ErrDoHQuery, 实际: %v", err)
+	}
+}
+
+// TestDNSHookPreferGo 测试 DNSHook 让默认解析器使用 Go 解析器，Disable 后恢复
+func TestDNSHookPreferGo(t *testing.T) {
+	if !hook.Patched {
+		t.Skip("nohook 构建不修改默认解析器")
+	}
+	prev := net.DefaultResolver.PreferGo
+	net.DefaultResolver.PreferGo = false
+	defer func() { net.DefaultResolver.PreferGo = prev }()
+
+	cfg := C.DefaultConfig()
+	cfg.DNSHook = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	if !net.DefaultResolver.PreferGo {
+		t.Error("DNSHook 启用后默认解析器应使用 Go 解析器")
+	}
+	h.Disable()
+	if net.DefaultResolver.PreferGo {
+		t.Error("Disable 后应恢复默认解析器的 PreferGo 设置")
+	}
+}
+
+// TestDirectDialUDPPacketConn 测试直连的 UDP 连接保留 PacketConn 接口，Go 解析器据此按数据报收发 DNS 报文
+func TestDirectDialUDPPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听 UDP 失败: %v", err)
+	}
+	defer pc.Close()
+
+	pm, err := PM.New(C.DefaultConfig())
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := hook.New(pm).DialContext(ctx, "udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("直连 UDP 失败: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(net.PacketConn); !ok {
+		t.Errorf("直连的 UDP 连接应实现 net.PacketConn: %T", conn)
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+type tenantKey struct{}
+
+// TestRouteFunc 测试路由回调按 ctx 中的租户决定直连、拒绝或使用指定凭证走代理，不做决定时回落到规则
+func TestRouteFunc(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAuth("tenant-b", "b-pass"), proxytest.WithAuth("default", "d-pass"))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.SOCKSConfig.User, cfg.SOCKSConfig.Pass = "default", "d-pass"
+	cfg.Rules = []C.Rule{{Type: C.RuleDomainKeyword, Pattern: "blocked", Action: C.ActionReject}}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	var calls atomic.Int32
+	pm.SetRouteFunc(func(ctx context.Context, network, addr string) rules.Decision {
+		calls.Add(1)
+		switch ctx.Value(tenantKey{}) {
+		case "a":
+			return rules.Decision{Action: rules.Direct, Reason: "tenant a"}
+		case "b":
+			return rules.Decision{Action: rules.Proxy, Rule: &rules.Rule{User: "tenant-b", Pass: "b-pass"}}
+		case "banned":
+			return rules.Decision{Action: rules.Reject}
+		}
+		return rules.Decision{}
+	})
+
+	ctxA := context.WithValue(context.Background(), tenantKey{}, "a")
+	ctxB := context.WithValue(context.Background(), tenantKey{}, "b")
+	ctxBanned := context.WithValue(context.Background(), tenantKey{}, "banned")
+
+	if d := pm.ExplainContext(ctxA, "tcp", echoAddr); d.Action != rules.Direct || d.Reason != "tenant a" {
+		t.Errorf("预期回调决定直连, 实际: %s", d)
+	}
+	if d := pm.ExplainContext(ctxBanned, "tcp", echoAddr); d.Action != rules.Reject || d.Reason != "route func" {
+		t.Errorf("回调未给出原因时预期为 route func, 实际: %s", d)
+	}
+
+	conn, err := pm.DialContext(ctxA, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连 %s 失败: %v", echoAddr, err)
+	}
+	conn.Close()
+	if n := len(srv.Targets()); n != 0 {
+		t.Errorf("回调选择直连的目标不应经过代理, 实际代理收到 %d 个请求", n)
+	}
+
+	if _, err := pm.DialContext(ctxBanned, "tcp", echoAddr); !errors.Is(err, E.ErrRuleRejected) {
+		t.Errorf("回调拒绝时应返回 ErrRuleRejected, 实际: %v", err)
+	}
+
+	// 回调决策中的凭证优先于配置的凭证，回调不做决定时按配置
+	for _, ctx := range []context.Context{ctxB, context.Background()} {
+		conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("通过代理连接失败: %v", err)
+		}
+		conn.Close()
+	}
+	if users := srv.Users(); len(users) != 2 || users[0] != "tenant-b" || users[1] != "default" {
+		t.Errorf("预期依次使用 tenant-b 和 default 凭证, 实际: %v", users)
+	}
+	if _, err := pm.Dial("tcp", net.JoinHostPort("blocked.localhost", echoPort)); !errors.Is(err, E.ErrRuleRejected) {
+		t.Errorf("回调不做决定时应按规则拒绝, 实际: %v", err)
+	}
+
+	// 到代理本身的连接不经过回调
+	before := calls.Load()
+	if d := pm.ExplainContext(ctxBanned, "tcp", cfg.GetProxyAddr()); d.Action != rules.Direct || !strings.Contains(d.Reason, "proxy address") {
+		t.Errorf("代理地址预期直连, 实际: %s", d)
+	}
+	if calls.Load() != before {
+		t.Errorf("代理地址不应调用路由回调")
+	}
+
+	pm.SetRouteFunc(nil)
+	if d := pm.ExplainContext(ctxA, "tcp", echoAddr); d.Action != rules.Proxy {
+		t.Errorf("移除回调后预期按默认走代理, 实际: %s", d)
+	}
+}
@@ -0,0 +1,482 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+func TestRulesEvaluate(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 只测试配置的规则
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+
+	engine := rules.FromConfig(cfg)
+	engine.Rules = []rules.Rule{
+		{Pattern: "*.corp.local", Action: rules.Direct},
+		{Pattern: "api.corp.local", Action: rules.Proxy},
+	}
+
+	tests := []struct {
+		network string
+		addr    string
+		want    rules.Action
+	}{
+		{"tcp", "example.com:443", rules.Proxy},
+		{"tcp", "127.0.0.1:1080", rules.Direct},
+		{"udp", "8.8.8.8:53", rules.Direct},
+		{"unix", "/tmp/app.sock", rules.Direct},
+		{"tcp", "git.corp.local:22", rules.Direct},
+		// 靠前的规则优先
+		{"tcp", "api.corp.local:443", rules.Direct},
+		{"tcp", "corp.local:443", rules.Proxy},
+	}
+
+	for _, tt := range tests {
+		if got := engine.Evaluate(tt.network, tt.addr); got != tt.want {
+			t.Errorf("%s %s: 预期 %s, 实际 %s (%s)", tt.network, tt.addr, tt.want, got, engine.Explain(tt.network, tt.addr))
+		}
+	}
+
+	cfg.Enable = false
+	if got := rules.FromConfig(cfg).Evaluate("tcp", "example.com:443"); got != rules.Direct {
+		t.Errorf("未启用代理时预期直连, 实际: %s", got)
+	}
+}
+
+// TestRulesBypassList 测试 BypassList 中的主机名、通配后缀、网段和端口直连，且先于 Rules
+func TestRulesBypassList(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 只测试配置的规则
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.BypassList = []string{"localhost", "*.corp.local", ".intra", "10.0.0.0/8", "::1", "build.example.com:8443", ":6443"}
+	cfg.Rules = []C.Rule{{Pattern: "*.corp.local", Action: "proxy"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	engine := rules.FromConfig(cfg)
+
+	tests := []struct {
+		addr string
+		want rules.Action
+	}{
+		{"localhost:80", rules.Direct},
+		{"LOCALHOST:80", rules.Direct},
+		{"git.corp.local:22", rules.Direct}, // 先于规则
+		{"corp.local:22", rules.Proxy},
+		{"wiki.intra:80", rules.Direct},
+		{"10.1.2.3:443", rules.Direct},
+		{"[::ffff:10.1.2.3]:443", rules.Direct},
+		{"11.0.0.1:443", rules.Proxy},
+		{"[::1]:80", rules.Direct},
+		{"build.example.com:8443", rules.Direct},
+		{"build.example.com:443", rules.Proxy},
+		{"example.com:6443", rules.Direct},
+		{"example.com:443", rules.Proxy},
+	}
+	for _, tt := range tests {
+		if d := engine.Explain("tcp", tt.addr); d.Action != tt.want {
+			t.Errorf("%s: 预期 %s, 实际 %s", tt.addr, tt.want, d)
+		}
+	}
+	if d := engine.Explain("tcp", "10.1.2.3:443"); d.Reason != "bypass 10.0.0.0/8" {
+		t.Errorf("直连原因应包含命中的条目, 实际: %s", d.Reason)
+	}
+
+	for _, entry := range []string{"", "a*b.com", "10.0.0.0/8:0", "host:http", "[::1"} {
+		cfg.BypassList = []string{entry}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("无效的条目 %q 应验证失败", entry)
+		}
+	}
+}
+
+// TestRulesPrivateBypass 测试默认直连本机和内网地址，规则可以覆盖，关闭 BypassPrivate 后走代理
+func TestRulesPrivateBypass(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.Rules = []C.Rule{{Type: C.RuleIPCIDR, Pattern: "10.8.0.0/16", Action: "proxy"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	engine := rules.FromConfig(cfg)
+
+	tests := []struct {
+		addr string
+		want rules.Action
+	}{
+		{"localhost:8080", rules.Direct},
+		{"127.0.0.53:53", rules.Direct},
+		{"[::1]:80", rules.Direct},
+		{"10.1.2.3:443", rules.Direct},
+		{"172.31.255.1:80", rules.Direct},
+		{"192.168.1.1:80", rules.Direct},
+		{"169.254.169.254:80", rules.Direct},
+		{"[fe80::1]:80", rules.Direct},
+		{"printer.local:631", rules.Direct},
+		{"10.8.1.1:443", rules.Proxy}, // 规则覆盖
+		{"172.32.0.1:80", rules.Proxy},
+		{"8.8.8.8:53", rules.Proxy},
+		{"example.com:443", rules.Proxy},
+		{"local.example.com:443", rules.Proxy},
+	}
+	for _, tt := range tests {
+		if d := engine.Explain("tcp", tt.addr); d.Action != tt.want {
+			t.Errorf("%s: 预期 %s, 实际 %s", tt.addr, tt.want, d)
+		}
+	}
+	if d := engine.Explain("tcp", "192.168.1.1:80"); d.Reason != "private 192.168.0.0/16" || !d.IsPrivate() {
+		t.Errorf("直连原因应包含命中的网段, 实际: %s", d.Reason)
+	}
+
+	cfg.BypassPrivate = false
+	if d := rules.FromConfig(cfg).Explain("tcp", "127.0.0.1:8080"); d.Action != rules.Proxy {
+		t.Errorf("关闭 BypassPrivate 后应走代理, 实际: %s", d)
+	}
+}
+
+func TestRulesMerge(t *testing.T) {
+	base := &rules.Engine{Enabled: true, ProxyAddr: "127.0.0.1:1080", Rules: []rules.Rule{{Pattern: "a.com", Action: rules.Direct}}}
+	extra := &rules.Engine{Rules: []rules.Rule{{Pattern: "a.com", Action: rules.Proxy}, {Pattern: "b.com", Action: rules.Direct}}}
+
+	merged := rules.Merge(base, nil, extra)
+	if !merged.Enabled || merged.ProxyAddr != base.ProxyAddr {
+		t.Fatal("预期基础设置来自第一个引擎")
+	}
+	if len(merged.Rules) != 3 {
+		t.Fatalf("预期 3 条规则, 实际: %d", len(merged.Rules))
+	}
+
+	d := merged.Explain("tcp", "a.com:443")
+	if d.Action != rules.Direct || d.Rule == nil || d.Rule.Pattern != "a.com" {
+		t.Errorf("预期命中第一个引擎的规则, 实际: %s", d)
+	}
+	if got := merged.Evaluate("tcp", "b.com:443"); got != rules.Direct {
+		t.Errorf("预期 b.com 直连, 实际: %s", got)
+	}
+}
+
+// TestRulesTypes 测试各种匹配方式和 Clash 风格的规则行
+func TestRulesTypes(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	for _, line := range []string{
+		"DOMAIN-SUFFIX,corp.local,DIRECT",
+		"domain-keyword,ads,reject",
+		"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+		"IP-CIDR6,2001:db8::/32,DIRECT",
+		"DST-PORT,25,REJECT",
+		"DST-PORT,8000-8999,DIRECT",
+		"MATCH,PROXY",
+	} {
+		r, err := C.ParseRule(line)
+		if err != nil {
+			t.Fatalf("解析规则 %q 失败: %v", line, err)
+		}
+		cfg.Rules = append(cfg.Rules, r)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	engine := rules.FromConfig(cfg)
+
+	tests := []struct {
+		addr string
+		want rules.Action
+	}{
+		{"corp.local:443", rules.Direct},
+		{"git.corp.local:22", rules.Direct},
+		{"notcorp.local:443", rules.Proxy},
+		{"ADS.example.com:443", rules.Reject},
+		{"10.1.2.3:443", rules.Direct},
+		{"[2001:db8::1]:443", rules.Direct},
+		{"mail.example.com:25", rules.Reject},
+		{"example.com:8080", rules.Direct},
+		{"example.com:9000", rules.Proxy},
+	}
+	for _, tt := range tests {
+		if d := engine.Explain("tcp", tt.addr); d.Action != tt.want {
+			t.Errorf("%s: 预期 %s, 实际 %s", tt.addr, tt.want, d)
+		}
+	}
+	if d := engine.Explain("tcp", "example.com:443"); d.Reason != "rule final" {
+		t.Errorf("预期命中 final 规则, 实际: %s", d)
+	}
+
+	for _, line := range []string{"GEOIP,CN,DIRECT", "DOMAIN,example.com", "IP-CIDR,corp.local,DIRECT", "DST-PORT,0,DIRECT", "DOMAIN,a.com,other-proxy"} {
+		if _, err := C.ParseRule(line); err == nil {
+			t.Errorf("无效的规则 %q 应解析失败", line)
+		}
+	}
+	cfg.Rules = []C.Rule{{Type: C.RuleFinal, Pattern: "x", Action: C.ActionDirect}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("带 pattern 的 final 规则应验证失败")
+	}
+}
+
+// TestRulesDstPortList 测试按端口列表路由，只有网页流量走代理
+func TestRulesDstPortList(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	for _, line := range []string{
+		"DST-PORT,22/3306,DIRECT",
+		"DST-PORT,80/443/8080-8443,PROXY",
+		"MATCH,DIRECT",
+	} {
+		r, err := C.ParseRule(line)
+		if err != nil {
+			t.Fatalf("解析规则 %q 失败: %v", line, err)
+		}
+		cfg.Rules = append(cfg.Rules, r)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	engine := rules.FromConfig(cfg)
+
+	tests := []struct {
+		addr string
+		want rules.Action
+	}{
+		{"git.example.com:22", rules.Direct},
+		{"db.example.com:3306", rules.Direct},
+		{"example.com:80", rules.Proxy},
+		{"example.com:443", rules.Proxy},
+		{"example.com:8443", rules.Proxy},
+		{"example.com:8444", rules.Direct},
+		{"example.com:5432", rules.Direct},
+	}
+	for _, tt := range tests {
+		if d := engine.Explain("tcp", tt.addr); d.Action != tt.want {
+			t.Errorf("%s: 预期 %s, 实际 %s", tt.addr, tt.want, d)
+		}
+	}
+	if d := engine.Explain("tcp", "example.com:443"); d.Reason != "rule dst-port 80/443/8080-8443" {
+		t.Errorf("决策原因应包含端口列表, 实际: %s", d.Reason)
+	}
+
+	for _, pattern := range []string{"22/", "/443", "22,3306", "80/0", "443/80-20"} {
+		if _, err := C.ParsePortList(pattern); err == nil {
+			t.Errorf("无效的端口列表 %q 应解析失败", pattern)
+		}
+	}
+}
+
+// TestRulesDomainRegex 测试 domain-regex 规则按正则表达式匹配小写的主机名，表达式可以包含逗号
+func TestRulesDomainRegex(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	for _, line := range []string{
+		`DOMAIN-REGEX,^ad[0-9]{1,3}\.,REJECT`,
+		`domain-regex,(^|\.)corp\.(local|lan)$,direct`,
+	} {
+		r, err := C.ParseRule(line)
+		if err != nil {
+			t.Fatalf("解析规则 %q 失败: %v", line, err)
+		}
+		cfg.Rules = append(cfg.Rules, r)
+	}
+	if cfg.Rules[0].Pattern != `^ad[0-9]{1,3}\.` || cfg.Rules[0].Action != C.ActionReject {
+		t.Fatalf("含逗号的表达式解析不符: %+v", cfg.Rules[0])
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	engine := rules.FromConfig(cfg)
+
+	tests := []struct {
+		addr string
+		want rules.Action
+	}{
+		{"ad12.example.com:443", rules.Reject},
+		{"AD7.example.com:443", rules.Reject},
+		{"ad1234.example.com:443", rules.Proxy},
+		{"bad1.example.com:443", rules.Proxy},
+		{"git.corp.local:22", rules.Direct},
+		{"corp.lan:80", rules.Direct},
+		{"corp.local.example.com:443", rules.Proxy},
+	}
+	for _, tt := range tests {
+		if d := engine.Explain("tcp", tt.addr); d.Action != tt.want {
+			t.Errorf("%s: 预期 %s, 实际 %s", tt.addr, tt.want, d)
+		}
+	}
+	if d := engine.Explain("tcp", "corp.lan:80"); d.Reason != `rule domain-regex (^|\.)corp\.(local|lan)$` {
+		t.Errorf("决策原因应包含表达式, 实际: %s", d.Reason)
+	}
+
+	for _, line := range []string{"DOMAIN-REGEX,(unclosed,DIRECT", "DOMAIN-REGEX,DIRECT"} {
+		if _, err := C.ParseRule(line); err == nil {
+			t.Errorf("无效的规则 %q 应解析失败", line)
+		}
+	}
+	cfg.Rules = []C.Rule{{Type: C.RuleDomainRegex, Pattern: "[a-", Action: C.ActionDirect}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("无效的表达式应验证失败")
+	}
+}
+
+// TestRulesDialActions 测试 DialContext 按规则拒绝、直连或走代理
+func TestRulesDialActions(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.Rules = []C.Rule{
+		{Type: C.RuleDomainKeyword, Pattern: "blocked", Action: C.ActionReject},
+		{Type: C.RuleIPCIDR, Pattern: "127.0.0.0/8", Action: C.ActionDirect},
+		{Type: C.RuleFinal, Action: C.ActionProxy},
+	}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	if _, err := pm.Dial("tcp", net.JoinHostPort("blocked.localhost", echoPort)); !errors.Is(err, E.ErrRuleRejected) {
+		t.Errorf("命中 reject 规则应返回 ErrRuleRejected, 实际: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连 %s 失败: %v", echoAddr, err)
+	}
+	conn.Close()
+	if n := len(srv.Targets()); n != 0 {
+		t.Errorf("直连的目标不应经过代理, 实际代理收到 %d 个请求", n)
+	}
+
+	conn, err = pm.Dial("tcp", net.JoinHostPort("localhost", echoPort))
+	if err != nil {
+		t.Fatalf("通过代理连接失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || !strings.HasPrefix(targets[0], "localhost:") {
+		t.Errorf("final 规则应走代理, 实际代理收到: %v", targets)
+	}
+}
+
+func TestRuleCredentials(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echoAddr)
+
+	tests := []struct {
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.SOCKS5, proxytest.NewSOCKSServer},
+		{C.HTTP, proxytest.NewHTTPServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, proxytest.WithAuth("vendor", "v-pass"), proxytest.WithAuth("default", "d-pass"))
+
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = tt.proxyType
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+			cfg.SOCKSConfig.User, cfg.SOCKSConfig.Pass = "default", "d-pass"
+			cfg.HTTPConfig.User, cfg.HTTPConfig.Pass = "default", "d-pass"
+			cfg.Rules = []C.Rule{{Pattern: "127.0.0.1", User: "vendor", Pass: "v-pass"}}
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			for _, addr := range []string{echoAddr, net.JoinHostPort("localhost", echoPort)} {
+				conn, err := pm.Dial("tcp", addr)
+				if err != nil {
+					t.Fatalf("连接 %s 失败: %v", addr, err)
+				}
+				conn.Close()
+			}
+
+			if users := srv.Users(); len(users) != 2 || users[0] != "vendor" || users[1] != "default" {
+				t.Errorf("预期依次使用 vendor 和 default 凭证, 实际: %v", users)
+			}
+		})
+	}
+}
+
+func TestContextCredentials(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer,
+		proxytest.WithAuth("vendor", "v-pass"), proxytest.WithAuth("tenant-a", "a-pass"), proxytest.WithAuth("tenant-b", "b-pass"))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HookUDP = true
+	cfg.Rules = []C.Rule{{Pattern: "127.0.0.1", User: "vendor", Pass: "v-pass"}}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	// ctx 中的凭证优先于路由规则
+	ctxA := PM.WithCredentials(context.Background(), PM.Credentials{User: "tenant-a", Pass: "a-pass"})
+	ctxB := PM.WithCredentials(context.Background(), PM.Credentials{User: "tenant-b", Pass: "b-pass"})
+	for _, ctx := range []context.Context{ctxA, ctxB, context.Background()} {
+		conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("连接 %s 失败: %v", echoAddr, err)
+		}
+		conn.Close()
+	}
+
+	// UDP ASSOCIATE 同样使用 ctx 中的凭证
+	pc, err := pm.ListenPacket(ctxB, "udp")
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	pc.Close()
+
+	want := []string{"tenant-a", "tenant-b", "vendor", "tenant-b"}
+	if users := srv.Users(); strings.Join(users, ",") != strings.Join(want, ",") {
+		t.Errorf("预期依次使用 %v 凭证, 实际: %v", want, users)
+	}
+	if cfg.SOCKSConfig.User != "" {
+		t.Errorf("按连接覆盖凭证不应修改共享配置: %q", cfg.SOCKSConfig.User)
+	}
+
+	long := PM.WithCredentials(context.Background(), PM.Credentials{User: strings.Repeat("u", 256), Pass: "x"})
+	if _, err := pm.DialContext(long, "tcp", echoAddr); !errors.Is(err, E.ErrSOCKS5CredentialsTooLong) {
+		t.Errorf("预期用户名过长错误, 实际: %v", err)
+	}
+}
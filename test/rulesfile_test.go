@@ -0,0 +1,122 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+// TestRulesFileReload 测试 RulesFile 修改后重新编译路由规则，内容无效时保留原来的规则
+func TestRulesFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRulesFile(t, path, "bypass_list: [\"*.corp.example\"]\n")
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+	cfg.Rules = []C.Rule{{Pattern: "*.vendor.example", Action: "direct"}}
+	cfg.RulesFile = path
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	defer pm.UpdateConfig(nil)
+
+	reloads := make(chan error, 8)
+	pm.OnRulesReload(func(err error) { reloads <- err })
+
+	if d := pm.Explain("tcp", "git.corp.example:22"); d.Action != rules.Direct {
+		t.Errorf("规则文件中的条目应直连, 实际: %s", d)
+	}
+	if d := pm.Explain("tcp", "api.vendor.example:443"); d.Action != rules.Direct {
+		t.Errorf("配置中的规则应保留, 实际: %s", d)
+	}
+
+	// 文件中的规则先于配置的规则匹配
+	writeRulesFile(t, path, "rules:\n  - {pattern: \"api.vendor.example\", action: proxy}\n")
+	if err := waitRulesReload(t, reloads); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if d := pm.Explain("tcp", "git.corp.example:22"); d.Action != rules.Proxy {
+		t.Errorf("删除的条目应不再直连, 实际: %s", d)
+	}
+	if d := pm.Explain("tcp", "api.vendor.example:443"); d.Action != rules.Proxy {
+		t.Errorf("文件中的规则应先于配置的规则, 实际: %s", d)
+	}
+	if !pm.Rules().Enabled {
+		t.Error("重新加载后路由引擎应保持启用")
+	}
+
+	// 无效内容报告错误并保留原来的规则
+	writeRulesFile(t, path, "rules:\n  - {pattern: \"*.example\", action: tunnel}\n")
+	if err := waitRulesReload(t, reloads); !errors.Is(err, E.ErrRulesFile) {
+		t.Fatalf("预期 ErrRulesFile, 实际: %v", err)
+	}
+	if d := pm.Explain("tcp", "api.vendor.example:443"); d.Action != rules.Proxy {
+		t.Errorf("无效的文件不应替换规则, 实际: %s", d)
+	}
+	if err := pm.ReloadRules(); !errors.Is(err, E.ErrRulesFile) {
+		t.Errorf("ReloadRules 预期 ErrRulesFile, 实际: %v", err)
+	}
+	<-reloads
+
+	// 以重命名方式替换文件
+	tmp := path + ".tmp"
+	writeRulesFile(t, tmp, "bypass_list: [\"*.corp.example\"]\n")
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("替换规则文件失败: %v", err)
+	}
+	if err := waitRulesReload(t, reloads); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if d := pm.Explain("tcp", "git.corp.example:22"); d.Action != rules.Direct {
+		t.Errorf("替换后的文件应生效, 实际: %s", d)
+	}
+}
+
+// TestRulesFileInvalid 测试初始的规则文件无效或不存在时创建失败
+func TestRulesFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	writeRulesFile(t, path, "bypass_list: [\"10.0.0.0/33\"]\n")
+
+	cfg := C.DefaultConfig()
+	cfg.RulesFile = path
+	if _, err := PM.New(cfg); !errors.Is(err, E.ErrRulesFile) {
+		t.Errorf("无效的规则文件预期 ErrRulesFile, 实际: %v", err)
+	}
+	cfg.RulesFile = filepath.Join(dir, "missing.yaml")
+	if _, err := PM.New(cfg); !errors.Is(err, E.ErrRulesFile) {
+		t.Errorf("不存在的规则文件预期 ErrRulesFile, 实际: %v", err)
+	}
+	if _, err := C.ParseRuleSet([]byte("unknown: 1\n")); err == nil {
+		t.Error("未知字段应返回错误")
+	}
+}
+
+func writeRulesFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("写入规则文件失败: %v", err)
+	}
+}
+
+func waitRulesReload(t *testing.T, reloads <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-reloads:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待重新加载超时")
+		return nil
+	}
+}
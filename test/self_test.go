@@ -0,0 +1,204 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"github.com/ba0gu0/GoHookProxy/rules"
+)
+
+func TestExcludeSelfConnections(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	// 一个本进程未监听的本地端口
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	unusedAddr := ln.Addr().String()
+	ln.Close()
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.ExcludeSelf = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	tests := []struct {
+		addr   string
+		action rules.Action
+	}{
+		{echoAddr, rules.Direct},
+		{unusedAddr, rules.Proxy},
+		{"example.com:80", rules.Proxy},
+	}
+	for _, tt := range tests {
+		if d := pm.Explain("tcp", tt.addr); d.Action != tt.action {
+			t.Errorf("%s 预期 %s, 实际: %s", tt.addr, tt.action, d)
+		}
+	}
+
+	h := hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	defer h.Disable()
+
+	// 自连接直连，服务端接受的连接读写不受 hook 影响
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("连接本进程监听端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	msg := []byte("self")
+	conn.Write(msg)
+	buf := make([]byte, len(msg))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(buf); err != nil || string(buf) != "self" {
+		t.Fatalf("回显失败: %q, %v", buf, err)
+	}
+
+	if targets := srv.Targets(); len(targets) != 0 {
+		t.Errorf("自连接不应经过代理, 代理收到: %v", targets)
+	}
+}
+
+func TestSelfPipe(t *testing.T) {
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	ln := PM.WrapListener(raw)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.SelfPipe = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	echo := func(conn net.Conn) {
+		t.Helper()
+		msg := []byte("pipe")
+		conn.Write(msg)
+		buf := make([]byte, len(msg))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pipe" {
+			t.Fatalf("回显失败: %q, %v", buf, err)
+		}
+	}
+
+	conn, err := pm.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("管道连接失败: %v", err)
+	}
+	defer conn.Close()
+	if conn.LocalAddr().Network() != "pipe" || conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Errorf("预期管道连接, 实际: %s -> %s", conn.LocalAddr(), conn.RemoteAddr())
+	}
+	echo(conn)
+
+	// 真实网络连接仍然可以被接受
+	direct, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("直接连接失败: %v", err)
+	}
+	defer direct.Close()
+	echo(direct)
+
+	if targets := srv.Targets(); len(targets) != 0 {
+		t.Errorf("管道连接不应经过代理, 代理收到: %v", targets)
+	}
+}
+
+// flakyListener 第一次 Accept 返回临时错误的监听器
+type flakyListener struct {
+	net.Listener
+	failed atomic.Bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if !l.failed.Swap(true) {
+		return nil, errors.New("accept: too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+// TestPipeListenerAcceptError 测试 Accept 的临时错误交给调用方后继续接受连接，关闭后返回 net.ErrClosed
+func TestPipeListenerAcceptError(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	ln := PM.WrapListener(&flakyListener{Listener: raw})
+	defer ln.Close()
+
+	if _, err := ln.Accept(); err == nil || errors.Is(err, net.ErrClosed) {
+		t.Fatalf("预期返回临时错误, 实际: %v", err)
+	}
+
+	client, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer client.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("临时错误后应继续接受连接, 实际: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("临时错误后没有继续接受连接")
+	}
+
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("关闭后应返回 net.ErrClosed, 实际: %v", err)
+	}
+}
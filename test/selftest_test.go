@@ -0,0 +1,38 @@
+package test
+
+import (
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestHookSelfTest(t *testing.T) {
+	srv := startProxy(t, proxytest.NewHTTPServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.MetricsEnable = true
+	cfg.SelfTest = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	h := hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("hook 自检失败: %v", err)
+	}
+	defer h.Disable()
+
+	// 自检连接直连本地监听器，不经过代理
+	if targets := srv.Targets(); len(targets) != 0 {
+		t.Errorf("自检连接不应经过代理, 代理收到: %v", targets)
+	}
+}
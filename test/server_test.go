@@ -0,0 +1,173 @@
+package test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// startEchoServer 启动一个本地回显服务
+func startEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动回显服务失败: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startUDPEchoServer 启动一个本地 UDP 回显服务
+func startUDPEchoServer(t *testing.T) string {
+	pc, err := proxytest.StartUDPEcho()
+	if err != nil {
+		t.Fatalf("启动 UDP 回显服务失败: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc.LocalAddr().String()
+}
+
+// startHTTP2Proxy 启动一个支持 CONNECT 的本地 HTTP2 代理
+func startHTTP2Proxy(t *testing.T) (string, int) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		go io.Copy(target, r.Body)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
+
+// startProxy 启动一个本地测试代理
+func startProxy(t *testing.T, newServer func(...proxytest.Option) (*proxytest.Server, error), opts ...proxytest.Option) *proxytest.Server {
+	srv, err := newServer(opts...)
+	if err != nil {
+		t.Fatalf("启动测试代理失败: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// startConnectProxy 启动一个本地 HTTP CONNECT 代理
+func startConnectProxy(t *testing.T) (string, int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动 HTTP 代理失败: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConnect(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
+
+// serveConnect 处理单个 CONNECT 请求并转发数据
+func serveConnect(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, br)
+	io.Copy(conn, target)
+}
+
+// startBlackhole 启动一个接受连接但从不响应的服务，模拟卡住的代理
+func startBlackhole(t *testing.T) (string, int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动服务失败: %v", err)
+	}
+
+	var conns []net.Conn
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		ln.Close()
+		<-done
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+type shutdownKey struct{}
+
+// TestHookShutdown 测试 Shutdown 推送最后一次指标、调用注册的函数、汇总失败并让之后的拨号失败
+func TestHookShutdown(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyIP, proxyPort := startConnectProxy(t)
+	endpoint, requests := startOTLPCollector(t, http.StatusOK)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = proxyIP
+	cfg.ProxyPort = proxyPort
+	cfg.MetricsEnable = true
+	cfg.OTLP = &C.OTLPConfig{Endpoint: endpoint, Interval: time.Hour}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+
+	conn, err := h.DialContext(context.Background(), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	conn.Close()
+
+	flushErr := errors.New("audit log flush failed")
+	var order []string
+	pm.OnShutdown(func(ctx context.Context) error {
+		if ctx.Value(shutdownKey{}) != "svc" {
+			t.Errorf("注册的函数应收到 Shutdown 的 ctx")
+		}
+		order = append(order, "report")
+		return nil
+	})
+	pm.OnShutdown(func(ctx context.Context) error {
+		order = append(order, "audit")
+		return flushErr
+	})
+
+	ctx := context.WithValue(context.Background(), shutdownKey{}, "svc")
+	if err := h.Shutdown(ctx); !errors.Is(err, flushErr) {
+		t.Errorf("Shutdown 应返回注册函数的错误, 实际: %v", err)
+	}
+	if len(order) != 2 || order[0] != "report" || order[1] != "audit" {
+		t.Errorf("注册的函数应按顺序调用, 实际: %v", order)
+	}
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown 时应推送最后一次指标")
+	}
+
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrManagerShutdown) {
+		t.Errorf("关闭后拨号应返回 ErrManagerShutdown, 实际: %v", err)
+	}
+	if _, err := pm.ListenPacket(context.Background(), "udp"); !errors.Is(err, E.ErrManagerShutdown) {
+		t.Errorf("关闭后 ListenPacket 应返回 ErrManagerShutdown, 实际: %v", err)
+	}
+	if err := pm.UpdateConfig(cfg); !errors.Is(err, E.ErrManagerShutdown) {
+		t.Errorf("关闭后 UpdateConfig 应返回 ErrManagerShutdown, 实际: %v", err)
+	}
+	if err := h.Enable(); !errors.Is(err, E.ErrManagerShutdown) {
+		t.Errorf("关闭后 Enable 应返回 ErrManagerShutdown, 实际: %v", err)
+	}
+	if err := h.Shutdown(ctx); err != nil {
+		t.Errorf("重复 Shutdown 应返回 nil, 实际: %v", err)
+	}
+	if len(order) != 2 {
+		t.Errorf("重复 Shutdown 不应再次调用注册的函数")
+	}
+}
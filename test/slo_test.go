@@ -0,0 +1,141 @@
+package test
+
+import (
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ba0gu0/GoHookProxy/clock"
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/metrics"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestSLOTrackerBurnRate(t *testing.T) {
+	fake := clock.NewFake(time.Time{})
+	tracker := metrics.NewSLOTracker(metrics.SLOObjective{
+		SuccessTarget:     0.9,
+		LatencyTarget:     100 * time.Millisecond,
+		LatencyObjective:  0.9,
+		Windows:           []time.Duration{10 * time.Minute, time.Minute},
+		BurnRateThreshold: 2,
+		MinSamples:        4,
+	}, fake)
+
+	var events []metrics.SLOEvent
+	tracker.OnAtRisk(func(e metrics.SLOEvent) { events = append(events, e) })
+
+	// 样本不足时不计算消耗速率
+	for i := 0; i < 3; i++ {
+		tracker.Record("proxy:1080", "a.test", time.Millisecond, true)
+	}
+	if len(events) != 0 {
+		t.Fatalf("样本不足时不应触发回调: %v", events)
+	}
+
+	// 4 次中 3 次失败: 错误率 0.75，预算 0.1，消耗速率 7.5
+	tracker.Record("proxy:1080", "a.test", time.Millisecond, false)
+	if len(events) != 1 || events[0].Objective != metrics.SLOSuccess || events[0].Scope != metrics.SLOScopeProxy {
+		t.Fatalf("预期成功率预算风险事件: %v", events)
+	}
+	if rate := events[0].BurnRates[time.Minute]; math.Abs(rate-7.5) > 1e-9 {
+		t.Errorf("消耗速率不符: %v", rate)
+	}
+	tracker.Record("proxy:1080", "a.test", time.Millisecond, true)
+	if len(events) != 1 {
+		t.Errorf("仍处于风险状态时不应重复通知: %v", events)
+	}
+
+	// 短窗口过期后恢复，再次恶化时重新通知
+	fake.Advance(2 * time.Minute)
+	for i := 0; i < 4; i++ {
+		tracker.Record("proxy:1080", "a.test", time.Millisecond, false)
+	}
+	for _, s := range tracker.Status() {
+		if s.Objective == metrics.SLOSuccess && s.Window == time.Minute && (s.Total != 4 || s.Bad != 0 || s.AtRisk) {
+			t.Errorf("短窗口状态不符: %+v", s)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		tracker.Record("proxy:1080", "a.test", 200*time.Millisecond, true)
+	}
+	if len(events) != 2 {
+		t.Errorf("恢复后再次恶化应重新通知: %v", events)
+	}
+
+	// 慢速拨号只计入延迟目标
+	for i := 0; i < 8; i++ {
+		tracker.Record("proxy:1080", "a.test", 200*time.Millisecond, false)
+	}
+	last := events[len(events)-1]
+	if last.Objective != metrics.SLOLatency {
+		t.Errorf("预期延迟预算风险事件: %+v", last)
+	}
+}
+
+func TestSLOProxyManager(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyConnectionRefused))
+	fake := clock.NewFake(time.Time{})
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.MetricsEnable = true
+	cfg.SLO = &C.SLOConfig{SuccessTarget: 0.99, PerDestination: true, MaxDestinations: 1}
+
+	pm, err := PM.NewWithClock(cfg, fake)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	var events []metrics.SLOEvent
+	pm.OnSLOBudgetAtRisk(func(e metrics.SLOEvent) { events = append(events, e) })
+
+	for i := 0; i < C.DefaultSLOMinSamples; i++ {
+		pm.Dial("tcp", "a.test:80")
+	}
+	pm.Dial("tcp", "b.test:80")
+
+	if len(events) != 2 {
+		t.Fatalf("预期代理和目标各一个风险事件, 实际: %+v", events)
+	}
+	if events[0].Target != srv.Addr() || events[1].Scope != metrics.SLOScopeDestination || events[1].Target != "a.test" {
+		t.Errorf("风险事件不符: %+v", events)
+	}
+
+	// 超出目标数上限的主机汇总统计
+	var overflow bool
+	for _, s := range pm.SLOStatus() {
+		overflow = overflow || s.Target == metrics.OverflowLabelKey
+	}
+	if !overflow {
+		t.Errorf("超出上限的目标应汇总到 %s: %+v", metrics.OverflowLabelKey, pm.SLOStatus())
+	}
+
+	// 配置未变化时 UpdateConfig 保留统计
+	if err := pm.UpdateConfig(cfg); err != nil {
+		t.Fatalf("更新配置失败: %v", err)
+	}
+	if len(pm.SLOStatus()) == 0 || pm.SLOStatus()[0].Total == 0 {
+		t.Error("配置未变化时应保留 SLO 统计")
+	}
+
+	hs := httptest.NewServer(pm.Metrics.PrometheusHandler())
+	defer hs.Close()
+	resp, err := hs.Client().Get(hs.URL)
+	if err != nil {
+		t.Fatalf("抓取指标失败: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want := `gohookproxy_slo_budget_at_risk{scope="proxy",target="` + srv.Addr() + `",objective="success"} 1`
+	if !strings.Contains(string(body), want) || !strings.Contains(string(body), `gohookproxy_slo_burn_rate{scope="destination",target="a.test",objective="success",window="5m0s"}`) {
+		t.Errorf("Prometheus 输出缺少 SLO 指标:\n%s", body)
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestSNIRouting 测试目标为 IP 的 TLS 连接按 ClientHello 中的 SNI 匹配路由规则
+func TestSNIRouting(t *testing.T) {
+	origin := httptest.NewTLSServer(http.NotFoundHandler())
+	defer origin.Close()
+	originAddr := strings.TrimPrefix(origin.URL, "https://")
+
+	// 服务端先发送数据的明文服务
+	greeter, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动服务失败: %v", err)
+	}
+	defer greeter.Close()
+	go func() {
+		for {
+			conn, err := greeter.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("220 ready\r\n"))
+			conn.Close()
+		}
+	}()
+
+	srv := startProxy(t, proxytest.NewHTTPServer)
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.BypassPrivate = false // 测试目标都在本机
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.MetricsEnable = true
+	cfg.SNIRouting = true
+	cfg.Rules = []C.Rule{{Pattern: "*.internal", Action: "direct"}}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+
+	handshake := func(serverName string) {
+		t.Helper()
+		conn, err := h.DialContext(context.Background(), "tcp", originAddr)
+		if err != nil {
+			t.Fatalf("拨号失败: %v", err)
+		}
+		defer conn.Close()
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			t.Fatalf("SNI 为 %s 的 TLS 握手失败: %v", serverName, err)
+		}
+	}
+
+	handshake("db.internal")
+	if targets := srv.Targets(); len(targets) != 0 {
+		t.Errorf("SNI 命中直连规则时不应经过代理, 实际: %v", targets)
+	}
+	handshake("example.com")
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != originAddr {
+		t.Errorf("其他 SNI 应经过代理并使用原来的目标 IP, 实际: %v", targets)
+	}
+
+	// 客户端先读取时等待后按目标 IP 的路由拨号
+	conn, err := h.DialContext(context.Background(), "tcp", greeter.Addr().String())
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "220 ready\r\n" {
+		t.Fatalf("服务端先发送的数据应能读到, 实际: %q, %v", buf[:n], err)
+	}
+	if targets := srv.Targets(); len(targets) != 2 {
+		t.Errorf("没有 ClientHello 时应按目标 IP 的路由经过代理, 实际: %v", targets)
+	}
+
+	decisions := pm.GetMetrics().Decisions
+	if decisions["direct"] != 1 || decisions["proxy"] != 2 {
+		t.Errorf("路由决策计数不符: %v", decisions)
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestSOCKS5HRemoteDNS 测试 socks5h 模式下主机名交给代理解析
+func TestSOCKS5HRemoteDNS(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	_, udpPort, _ := net.SplitHostPort(startUDPEchoServer(t))
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+	pm := newTestManager(t, C.SOCKS5H, srv.Host(), srv.Port(), withHookUDP)
+
+	target := net.JoinHostPort("localhost", echoPort)
+	conn, err := pm.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("通过 socks5h 连接失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) == 0 || targets[0] != target {
+		t.Errorf("代理应收到主机名 %s, 实际: %v", target, targets)
+	}
+
+	// UDP 头中同样使用主机名
+	udp, err := pm.Dial("udp", net.JoinHostPort("localhost", udpPort))
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer udp.Close()
+	if _, err := udp.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 64)
+	udp.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := udp.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("UDP 回显失败: %q, %v", buf[:n], err)
+	}
+}
+
+// TestSOCKS5HBlocksLocalResolve 测试 socks5h 模式下 hook 阻止走代理的主机名在本地解析
+func TestSOCKS5HBlocksLocalResolve(t *testing.T) {
+	if !hook.Patched {
+		t.Skip("nohook 构建不替换标准库函数")
+	}
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+	h := hook.New(newTestManager(t, C.SOCKS5H, srv.Host(), srv.Port(), withHookUDP))
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	defer h.Disable()
+
+	if _, err := net.ResolveTCPAddr("tcp", "example.com:443"); !errors.Is(err, E.ErrLocalDNSBlocked) {
+		t.Errorf("预期 ErrLocalDNSBlocked, 实际: %v", err)
+	}
+	if _, err := net.ResolveIPAddr("ip", "example.com"); !errors.Is(err, E.ErrLocalDNSBlocked) {
+		t.Errorf("预期 ErrLocalDNSBlocked, 实际: %v", err)
+	}
+
+	// IP 字面量不需要解析
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:53")
+	if err != nil || addr.Port != 53 || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("IP 字面量解析失败: %v, %v", addr, err)
+	}
+}
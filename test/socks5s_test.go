@@ -0,0 +1,144 @@
+package test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// newSOCKSTLSConfig 返回连接 srv 的 TLS 上的 SOCKS 配置
+func newSOCKSTLSConfig(srv *proxytest.Server, proxyType C.ProxyType) *C.Config {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = proxyType
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.SOCKSConfig.TLS = true
+	cfg.SOCKSConfig.SkipVerify = true
+	return cfg
+}
+
+// TestSOCKSTLSDial 测试 socks5s 和开启 TLS 的 socks4a/socks5h，认证和隧道数据都在 TLS 中
+func TestSOCKSTLSDial(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	target := net.JoinHostPort("localhost", echoPort)
+
+	for _, proxyType := range []C.ProxyType{C.SOCKS5S, C.SOCKS5H, C.SOCKS4A} {
+		t.Run(string(proxyType), func(t *testing.T) {
+			var opts []proxytest.Option
+			if proxyType != C.SOCKS4A { // SOCKS4 没有密码认证
+				opts = append(opts, proxytest.WithAuth("user", "secret"))
+			}
+			srv := startProxy(t, proxytest.NewSOCKSSServer, opts...)
+			cfg := newSOCKSTLSConfig(srv, proxyType)
+			cfg.SOCKSConfig.TLS = proxyType != C.SOCKS5S
+			cfg.SOCKSConfig.User = "user"
+			if proxyType != C.SOCKS4A {
+				cfg.SOCKSConfig.Pass = "secret"
+			}
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			conn, err := pm.Dial("tcp", target)
+			if err != nil {
+				t.Fatalf("通过 TLS 上的 SOCKS 连接失败: %v", err)
+			}
+			defer conn.Close()
+			sshEcho(t, conn)
+			if tlsConn, ok := PM.Unwrap[*tls.Conn](conn); !ok || !tlsConn.ConnectionState().HandshakeComplete {
+				t.Error("隧道应承载在到代理的 TLS 连接上")
+			}
+			if targets := srv.Targets(); len(targets) != 1 || targets[0] != target {
+				t.Errorf("代理应收到主机名 %s, 实际: %v", target, targets)
+			}
+		})
+	}
+}
+
+// TestSOCKS5SUDP 测试 UDP 关联的控制连接使用 TLS，数据报经明文中继转发
+func TestSOCKS5SUDP(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSSServer)
+	cfg := newSOCKSTLSConfig(srv, C.SOCKS5S)
+	cfg.HookUDP = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("数据报回显失败: %q, %v", buf[:n], err)
+	}
+}
+
+// TestSOCKS5SVerify 测试默认校验代理证书，自签名证书握手失败
+func TestSOCKS5SVerify(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSSServer)
+	cfg := newSOCKSTLSConfig(srv, C.SOCKS5S)
+	cfg.SOCKSConfig.SkipVerify = false
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if _, err := pm.Dial("tcp", startEchoServer(t)); !errors.Is(err, E.ErrTLSHandshake) {
+		t.Errorf("不受信任的证书应返回 ErrTLSHandshake, 实际: %v", err)
+	}
+
+	// 明文 SOCKS 代理不会完成 TLS 握手
+	plain := startProxy(t, proxytest.NewSOCKSServer)
+	cfg = newSOCKSTLSConfig(plain, C.SOCKS5S)
+	cfg.SOCKSConfig.Timeout = time.Second
+	if pm, err = PM.New(cfg); err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if _, err := pm.Dial("tcp", startEchoServer(t)); err == nil {
+		t.Error("明文代理上的 TLS 握手应失败")
+	}
+}
+
+// TestSOCKSTLSConfig 测试 TLS 设置的校验
+func TestSOCKSTLSConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5S
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 1080
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+
+	cfg.SOCKSConfig.CertFile = "client.pem"
+	if err := cfg.Validate(); err == nil {
+		t.Error("只设置证书没有私钥应校验失败")
+	}
+	cfg.SOCKSConfig.CertFile = ""
+
+	cfg.ProxyIP = "unix:/run/socks.sock"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Unix 域套接字上的 TLS 缺少 server_name 应校验失败")
+	}
+	cfg.SOCKSConfig.ServerName = "socks.internal"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("设置 server_name 后应校验通过: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/socks"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestSOCKSAddrRoundTrip(t *testing.T) {
+	tests := []struct {
+		addr string
+		atyp socks.AddrType
+	}{
+		{"127.0.0.1:1080", socks.AtypIPv4},
+		{"[2001:db8::1]:443", socks.AtypIPv6},
+		{"example.com:80", socks.AtypDomain},
+	}
+
+	for _, tt := range tests {
+		a, err := socks.ParseAddr(tt.addr)
+		if err != nil {
+			t.Fatalf("解析 %s 失败: %v", tt.addr, err)
+		}
+		if a.Type() != tt.atyp {
+			t.Errorf("%s 的地址类型错误: 预期 %s, 实际 %s", tt.addr, tt.atyp, a.Type())
+		}
+
+		b, err := a.Append(nil)
+		if err != nil {
+			t.Fatalf("编码 %s 失败: %v", tt.addr, err)
+		}
+		decoded, n, err := socks.DecodeAddr(append(b, 0xAA))
+		if err != nil || n != len(b) || decoded.String() != tt.addr {
+			t.Errorf("解码 %s 失败: %s, %d, %v", tt.addr, decoded, n, err)
+		}
+		read, err := socks.ReadAddr(bytes.NewReader(b))
+		if err != nil || read.String() != tt.addr {
+			t.Errorf("读取 %s 失败: %s, %v", tt.addr, read, err)
+		}
+	}
+
+	if _, err := (socks.Addr{Name: strings.Repeat("a", 256)}).Append(nil); !errors.Is(err, E.ErrSOCKS5HostnameTooLong) {
+		t.Errorf("预期 ErrSOCKS5HostnameTooLong, 实际: %v", err)
+	}
+	if _, _, err := socks.DecodeAddr([]byte{0x09, 0, 0}); !errors.Is(err, E.ErrSOCKS5AddressTypeNotSupported) {
+		t.Errorf("预期 ErrSOCKS5AddressTypeNotSupported, 实际: %v", err)
+	}
+}
+
+func TestSOCKSUDPHeader(t *testing.T) {
+	a := socks.Addr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	b, err := socks.AppendUDPHeader(nil, a)
+	if err != nil {
+		t.Fatalf("编码 UDP 头失败: %v", err)
+	}
+	b = append(b, "payload"...)
+
+	src, n, err := socks.ParseUDPHeader(b)
+	if err != nil || src.String() != "10.0.0.1:53" || string(b[n:]) != "payload" {
+		t.Errorf("解析 UDP 头失败: %s, %q, %v", src, b[n:], err)
+	}
+
+	// 分片的数据报不支持
+	b[2] = 0x01
+	if _, _, err := socks.ParseUDPHeader(b); err == nil {
+		t.Error("预期分片数据报解析失败")
+	}
+}
+
+func TestSOCKSReplyErrors(t *testing.T) {
+	if socks.ReplySucceeded.Err() != nil || socks.Reply4Granted.Err() != nil {
+		t.Error("成功响应不应返回错误")
+	}
+	if got := socks.ReplyHostUnreachable.String(); got != "host unreachable" {
+		t.Errorf("响应码描述错误: %s", got)
+	}
+	if got := socks.Reply(0x42).String(); !strings.Contains(got, "0x42") {
+		t.Errorf("未分配的响应码应包含数值: %s", got)
+	}
+
+	err := socks.ReplyConnectionRefused.Err()
+	if !errors.Is(err, E.ErrSOCKSConnectFailed) || !errors.Is(err, E.ErrSOCKS5ConnectionRefused) {
+		t.Errorf("REP 错误应同时匹配连接失败和具体原因: %v", err)
+	}
+	err = socks.Reply4IdentdMismatch.Err()
+	if !errors.Is(err, E.ErrSOCKSAuthFailed) || !errors.Is(err, E.ErrSOCKS4IdentdMismatch) {
+		t.Errorf("SOCKS4 identd 错误应匹配认证失败: %v", err)
+	}
+}
+
+// TestSOCKS5ReplyCodeError 测试代理返回的 REP 转换为可读的错误
+func TestSOCKS5ReplyCodeError(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyNotAllowed))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	_, err = pm.Dial("tcp", "example.com:80")
+	if !errors.Is(err, E.ErrSOCKS5NotAllowed) {
+		t.Fatalf("预期 ErrSOCKS5NotAllowed, 实际: %v", err)
+	}
+	if !strings.Contains(err.Error(), "not allowed by ruleset") {
+		t.Errorf("错误信息应包含响应码描述: %v", err)
+	}
+}
+
+// TestSOCKS4AProxyType 测试 SOCKS4 在本地解析主机名，SOCKS4A 把主机名交给代理
+func TestSOCKS4AProxyType(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	_, port, _ := strings.Cut(echoAddr, ":")
+
+	tests := []struct {
+		proxyType C.ProxyType
+		want      string
+	}{
+		{C.SOCKS4, "127.0.0.1:" + port},
+		{C.SOCKS4A, "localhost:" + port},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, proxytest.NewSOCKSServer)
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = tt.proxyType
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+			conn, err := pm.Dial("tcp", "localhost:"+port)
+			if err != nil {
+				t.Fatalf("拨号失败: %v", err)
+			}
+			conn.Close()
+			if got := srv.Targets(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("代理收到的目标应为 %s, 实际: %v", tt.want, got)
+			}
+
+			if _, err := pm.Dial("tcp", "[::1]:"+port); !errors.Is(err, E.ErrSOCKSAddressTypeNotSupported) {
+				t.Errorf("IPv6 目标应返回 ErrSOCKSAddressTypeNotSupported, 实际: %v", err)
+			}
+			if cfg.RemoteDNS() != (tt.proxyType == C.SOCKS4A) {
+				t.Errorf("只有 SOCKS4A 由代理解析主机名, RemoteDNS: %v", cfg.RemoteDNS())
+			}
+		})
+	}
+
+	// 代理拒绝请求时返回 SOCKS4 的错误
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithReplyCode(socks.ReplyNotAllowed))
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS4A
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if _, err := pm.Dial("tcp", "example.com:80"); !errors.Is(err, E.ErrSOCKSConnectFailed) || !errors.Is(err, E.ErrSOCKS4RequestRejected) {
+		t.Errorf("预期 ErrSOCKS4RequestRejected, 实际: %v", err)
+	}
+
+	// SOCKS4 没有密码认证
+	cfg.SOCKSConfig.User, cfg.SOCKSConfig.Pass = "user", "pass"
+	if err := cfg.Validate(); err == nil {
+		t.Error("SOCKS4A 设置密码时应验证失败")
+	}
+}
+
+// TestSOCKS5BoundAddr 测试 BoundAddr 返回 CONNECT 响应中代理连接目标使用的地址，穿过包装连接仍能找到
+func TestSOCKS5BoundAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(conn.RemoteAddr().String() + "\n"))
+			conn.Close()
+		}
+	}()
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	defer conn.Close()
+	peer, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	bound, ok := PM.BoundAddr(&auditConn{Conn: conn})
+	if !ok {
+		t.Fatal("SOCKS5 连接应有 BND 地址")
+	}
+	if bound.String() != strings.TrimSpace(peer) {
+		t.Errorf("BND 地址应为代理连接目标的地址 %s, 实际: %s", strings.TrimSpace(peer), bound)
+	}
+
+	direct, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("直连失败: %v", err)
+	}
+	defer direct.Close()
+	if _, ok := PM.BoundAddr(direct); ok {
+		t.Error("不经过 SOCKS5 代理的连接没有 BND 地址")
+	}
+}
@@ -0,0 +1,228 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshEcho 通过连接发送并读回一段数据
+func sshEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("回显失败: %q, %v", buf, err)
+	}
+}
+
+// TestSSHDial 测试通过 SSH 跳板机的 direct-tcpip 通道连接，多个连接共用一个会话
+func TestSSHDial(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	srv := startProxy(t, proxytest.NewSSHServer, proxytest.WithAuth("jump", "secret"))
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SSH
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.SSHConfig.User = "jump"
+	cfg.SSHConfig.Password = "secret"
+	cfg.SSHConfig.HostKeySHA256 = ssh.FingerprintSHA256(srv.HostKey())
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	target := net.JoinHostPort("localhost", echoPort)
+	for i := 0; i < 3; i++ {
+		conn, err := pm.Dial("tcp", target)
+		if err != nil {
+			t.Fatalf("通过 SSH 连接失败: %v", err)
+		}
+		sshEcho(t, conn)
+		conn.Close()
+	}
+	if n := srv.Accepted(); n != 1 {
+		t.Errorf("多个连接应共用一个 SSH 会话, 实际建立: %d", n)
+	}
+	if targets := srv.Targets(); len(targets) != 3 || targets[0] != target {
+		t.Errorf("跳板机应收到主机名 %s, 实际: %v", target, targets)
+	}
+
+	if _, err := pm.Dial("udp", target); !errors.Is(err, E.ErrSSHNetworkNotSupported) {
+		t.Errorf("SSH 不支持 UDP, 实际: %v", err)
+	}
+
+	// 关闭会话后下一次拨号重新建立
+	pm.GetDialer().(*PM.SSHDialer).Close()
+	conn, err := pm.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("会话关闭后重连失败: %v", err)
+	}
+	sshEcho(t, conn)
+	conn.Close()
+	if n := srv.Accepted(); n != 2 {
+		t.Errorf("会话关闭后应重新建立, 实际建立: %d", n)
+	}
+}
+
+// TestSSHSessionConcurrent 测试并发拨号只建立一个会话，建立会话不持有锁等待网络
+func TestSSHSessionConcurrent(t *testing.T) {
+	echo := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewSSHServer, proxytest.WithAuth("jump", "secret"))
+
+	config := C.DefaultSSHConfig()
+	config.User = "jump"
+	config.Password = "secret"
+	config.HostKeySHA256 = ssh.FingerprintSHA256(srv.HostKey())
+	dialer, err := PM.NewSSHDialer(srv.Addr(), config, nil)
+	if err != nil {
+		t.Fatalf("创建 SSH 拨号器失败: %v", err)
+	}
+	defer dialer.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dialer.Dial("tcp", echo)
+			if err != nil {
+				t.Errorf("并发拨号失败: %v", err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	if n := srv.Accepted(); n != 1 {
+		t.Errorf("并发拨号应共用一个 SSH 会话, 实际建立: %d", n)
+	}
+
+	// 跳板机不可达时保留底层错误
+	unreachable, err := PM.NewSSHDialer(unusedAddr(t), config, nil)
+	if err != nil {
+		t.Fatalf("创建 SSH 拨号器失败: %v", err)
+	}
+	_, err = unreachable.Dial("tcp", echo)
+	if !errors.Is(err, E.ErrSSHProxyUnreachable) || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("预期包含原因的 ErrSSHProxyUnreachable, 实际: %v", err)
+	}
+
+	// 会话建立卡住时，等待的拨号按自己的 ctx 返回
+	host, port := startBlackhole(t)
+	config.Timeout = 5 * time.Second
+	stuck, err := PM.NewSSHDialer(net.JoinHostPort(host, strconv.Itoa(port)), config, nil)
+	if err != nil {
+		t.Fatalf("创建 SSH 拨号器失败: %v", err)
+	}
+	defer stuck.Close()
+	leaderCtx, cancelLeader := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelLeader()
+	go stuck.DialContext(leaderCtx, "tcp", echo)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := stuck.DialContext(ctx, "tcp", echo); err == nil {
+		t.Error("预期会话建立卡住时拨号失败")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("等待的拨号应按自己的 ctx 返回, 耗时: %v", elapsed)
+	}
+}
+
+// TestSSHKeyAuth 测试私钥认证和 known_hosts 校验
+func TestSSHKeyAuth(t *testing.T) {
+	echo := startEchoServer(t)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	sshPub, _ := ssh.NewPublicKey(pub)
+	srv := startProxy(t, proxytest.NewSSHServer, proxytest.WithAuthorizedKey(sshPub))
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.Addr())}, srv.HostKey())
+	os.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+
+	config := C.DefaultSSHConfig()
+	config.User = "jump"
+	config.KeyFile = keyFile
+	config.KnownHostsFile = knownHosts
+	dialer, err := PM.NewSSHDialer(srv.Addr(), config, nil)
+	if err != nil {
+		t.Fatalf("创建 SSH 拨号器失败: %v", err)
+	}
+	defer dialer.Close()
+
+	conn, err := dialer.Dial("tcp", echo)
+	if err != nil {
+		t.Fatalf("私钥认证连接失败: %v", err)
+	}
+	sshEcho(t, conn)
+	conn.Close()
+
+	// 主机密钥指纹不匹配时握手失败
+	config.KnownHostsFile = ""
+	config.HostKeySHA256 = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	wrong, err := PM.NewSSHDialer(srv.Addr(), config, nil)
+	if err != nil {
+		t.Fatalf("创建 SSH 拨号器失败: %v", err)
+	}
+	if _, err := wrong.Dial("tcp", echo); !errors.Is(err, E.ErrSSHHandshakeFailed) {
+		t.Errorf("主机密钥不匹配时应握手失败, 实际: %v", err)
+	}
+}
+
+// TestSSHConfigValidate 测试 SSH 配置必须指定认证方式和主机密钥校验方式
+func TestSSHConfigValidate(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SSH
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 22
+	cfg.SSHConfig.User = "jump"
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少认证方式时验证应失败")
+	}
+	cfg.SSHConfig.Password = "secret"
+	if err := cfg.Validate(); err == nil {
+		t.Error("未配置主机密钥校验时验证应失败")
+	}
+	cfg.SSHConfig.InsecureIgnoreHostKey = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("有效配置验证失败: %v", err)
+	}
+}
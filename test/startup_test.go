@@ -0,0 +1,100 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestStartupFailFast(t *testing.T) {
+	addr := unusedAddr(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = host
+	cfg.ProxyPort, _ = strconv.Atoi(port)
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	// 默认 lazy 不检查代理
+	if err := pm.Startup(context.Background()); err != nil {
+		t.Fatalf("lazy 模式不应检查代理: %v", err)
+	}
+
+	cfg.StartupPolicy = C.StartupFailFast
+	h := hook.New(pm)
+	err = h.Enable()
+	if err == nil {
+		h.Disable()
+		t.Fatal("代理不可用时 Enable 应失败")
+	}
+	if !errors.Is(err, E.ErrProxyDialFailed) || !strings.Contains(err.Error(), addr) {
+		t.Errorf("错误应包含代理地址: %v", err)
+	}
+}
+
+func TestStartupDirectUntilHealthy(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	path := filepath.Join(t.TempDir(), "socks.sock")
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = C.UnixSocketPrefix + path
+	cfg.StartupPolicy = C.StartupDirectUntilHealthy
+	cfg.StartupProbeInterval = 20 * time.Millisecond
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pm.Startup(ctx); err != nil {
+		t.Fatalf("direct_until_healthy 不应返回错误: %v", err)
+	}
+	if !pm.WaitingForProxy() || pm.ShouldProxy("tcp", echoAddr) {
+		t.Fatal("代理不可用时应先直连")
+	}
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连阶段拨号失败: %v", err)
+	}
+	conn.Close()
+
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithUnix(path))
+	deadline := time.Now().Add(2 * time.Second)
+	for pm.WaitingForProxy() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pm.WaitingForProxy() {
+		t.Fatal("代理可用后应结束直连阶段")
+	}
+
+	conn, err = pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("代理阶段拨号失败: %v", err)
+	}
+	conn.Close()
+	if targets := srv.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("代理收到的目标地址不符: %v", targets)
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+// fixedResolver 把所有主机名解析为同一个地址
+type fixedResolver struct {
+	ip net.IP
+}
+
+func (r fixedResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: r.ip}}, nil
+}
+
+// TestHookStatus 测试 DNSHook 和 TLSHook 不依赖 Config.Enable，Status 报告实际替换的函数
+func TestHookStatus(t *testing.T) {
+	pm, err := PM.New(C.DefaultConfig())
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	h := hook.New(pm)
+	if s := h.Status(); s.Enabled || s.Patched != hook.Patched {
+		t.Errorf("Enable 之前的状态不符: %+v", s)
+	}
+	if err := h.Enable(); err != nil {
+		t.Fatalf("没有需要替换的函数时 Enable 也应成功: %v", err)
+	}
+	if s := h.Status(); s != (hook.Status{Enabled: true, Patched: hook.Patched}) {
+		t.Errorf("默认配置不应替换任何函数: %+v", s)
+	}
+	h.Disable()
+
+	// 未启用代理时只替换解析
+	cfg := C.DefaultConfig()
+	cfg.DNSHook = true
+	pm, err = PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pm.SetResolver(fixedResolver{ip: net.IPv4(192, 0, 2, 7)})
+	h = hook.New(pm)
+	if err := h.Enable(); err != nil {
+		t.Fatalf("启用 hook 失败: %v", err)
+	}
+	if s := h.Status(); !s.Enabled || s.Dial || s.DNS != hook.Patched || s.TLS {
+		t.Errorf("应只替换解析: %+v", s)
+	}
+	if hook.Patched {
+		if addr, err := net.ResolveIPAddr("ip", "status.test"); err != nil || !addr.IP.Equal(net.IPv4(192, 0, 2, 7)) {
+			t.Errorf("未启用代理时 DNSHook 仍应使用管理器的解析器, 实际: %v, %v", addr, err)
+		}
+	}
+	if err := h.Disable(); err != nil {
+		t.Fatalf("禁用 hook 失败: %v", err)
+	}
+	if h.Status().Enabled {
+		t.Error("Disable 后 Enabled 应为 false")
+	}
+
+	// 后面的步骤失败时还原已替换的函数
+	cfg.TLSHook = true
+	cfg.TLSRules = []C.TLSRule{{Pattern: "api.example.com", RootCAFile: filepath.Join(t.TempDir(), "missing.pem")}}
+	pm, err = PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	pm.SetResolver(fixedResolver{ip: net.IPv4(192, 0, 2, 7)})
+	h = hook.New(pm)
+	if err := h.Enable(); err == nil {
+		h.Disable()
+		t.Fatal("根证书文件不存在时 Enable 应失败")
+	}
+	if h.Status().Enabled {
+		t.Error("Enable 失败后 Enabled 应为 false")
+	}
+	if addr, err := net.ResolveIPAddr("ip", "127.0.0.1"); err != nil || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Enable 失败后应还原解析函数, 实际: %v, %v", addr, err)
+	}
+	if err := h.Disable(); err != nil {
+		t.Errorf("Enable 失败后 Disable 不应报错: %v", err)
+	}
+
+	// 管理器没有配置时返回错误而不是崩溃
+	pm.UpdateConfig(nil)
+	if err := hook.New(pm).Enable(); !errors.Is(err, E.ErrInvalidConfig) {
+		t.Errorf("没有配置时预期 ErrInvalidConfig, 实际: %v", err)
+	}
+}
+
+// TestHookImpossibleCombinations 测试不会生效的 hook 组合验证失败
+func TestHookImpossibleCombinations(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.SelfTest = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("未启用代理时 SelfTest 没有可以验证的拨号补丁, 应验证失败")
+	}
+
+	cfg = C.DefaultConfig()
+	cfg.TLSRules = []C.TLSRule{{Pattern: "api.example.com", SkipVerify: true}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("未启用 TLSHook 时 TLSRules 不会生效, 应验证失败")
+	}
+	cfg.TLSHook = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("TLSHook 不需要启用代理: %v", err)
+	}
+}
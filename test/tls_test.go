@@ -0,0 +1,110 @@
+package test
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	"github.com/ba0gu0/GoHookProxy/hook"
+	"github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestTLSRules(t *testing.T) {
+	if !hook.Patched {
+		t.Skip("nohook 构建不替换 tls.Config.Clone")
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// 写出测试服务的根证书
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("写入证书失败: %v", err)
+	}
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	wrongPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	tests := []struct {
+		name       string
+		rules      []C.TLSRule
+		serverName string         // 非空时 Clone 时已知目标主机
+		rootCAs    *x509.CertPool // 客户端自己的根证书
+		shouldWork bool
+	}{
+		{"无规则", nil, "", nil, false},
+		{"自定义根证书", []C.TLSRule{{Pattern: "127.0.0.1", RootCAFile: caFile}}, "", nil, true},
+		{"根证书和指纹", []C.TLSRule{{Pattern: "127.0.0.1", RootCAFile: caFile, PinnedSHA256: []string{pin}}}, "", nil, true},
+		{"指纹不匹配", []C.TLSRule{{Pattern: "127.0.0.1", SkipVerify: true, PinnedSHA256: []string{wrongPin}}}, "", nil, false},
+		{"规则不匹配", []C.TLSRule{{Pattern: "*.internal", RootCAFile: caFile}}, "", nil, false},
+		{"已知主机的根证书", []C.TLSRule{{Pattern: "127.0.0.1", RootCAFile: caFile}}, "127.0.0.1", nil, true},
+		{"已知主机的指纹不匹配", []C.TLSRule{{Pattern: "127.0.0.1", RootCAFile: caFile, PinnedSHA256: []string{wrongPin}}}, "127.0.0.1", nil, false},
+		{"已知主机不匹配规则", []C.TLSRule{{Pattern: "*.internal", RootCAFile: caFile}}, "127.0.0.1", nil, false},
+		{"不匹配规则时使用客户端的根证书", []C.TLSRule{{Pattern: "*.internal", PinnedSHA256: []string{wrongPin}}}, "127.0.0.1", pool, true},
+		{"不匹配规则时使用客户端的根证书(未知主机)", []C.TLSRule{{Pattern: "*.internal", RootCAFile: caFile}}, "", pool, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := C.DefaultConfig()
+			cfg.TLSHook = true
+			cfg.TLSRules = tt.rules
+
+			pm, err := proxy.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+
+			h := hook.New(pm)
+			if err := h.Enable(); err != nil {
+				t.Fatalf("启用hook失败: %v", err)
+			}
+			defer h.Disable()
+
+			client := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{ServerName: tt.serverName, RootCAs: tt.rootCAs}},
+				Timeout:   5 * time.Second,
+			}
+			resp, err := client.Get(srv.URL)
+			if tt.shouldWork {
+				if err != nil {
+					t.Fatalf("请求失败: %v", err)
+				}
+				resp.Body.Close()
+				// 证书链仍由标准库验证
+				if len(resp.TLS.VerifiedChains) == 0 {
+					t.Error("预期保留标准库验证的证书链, 实际为空")
+				}
+			} else if err == nil {
+				resp.Body.Close()
+				t.Fatal("预期请求应该失败，但成功了")
+			}
+		})
+	}
+}
+
+func TestTLSRulesInvalidPin(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.TLSHook = true
+	cfg.TLSRules = []C.TLSRule{{Pattern: "api.example.com", PinnedSHA256: []string{"not-a-pin"}}}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("预期无效指纹应该返回错误")
+	}
+}
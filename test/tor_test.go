@@ -0,0 +1,124 @@
+package test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func startTorControl(t *testing.T, password string) *proxytest.TorControlServer {
+	srv, err := proxytest.NewTorControlServer(password)
+	if err != nil {
+		t.Fatalf("启动 Tor 控制端口失败: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestTorRotateCircuit(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAnyAuth())
+	control := startTorControl(t, `pa"ss`)
+
+	tor := C.DefaultTorConfig()
+	tor.ControlAddr = control.Addr()
+	tor.ControlPassword = `pa"ss`
+	pm := newTestManager(t, C.TOR, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.TorConfig = tor })
+
+	dial := func() {
+		t.Helper()
+		conn, err := pm.Dial("tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("通过 Tor 连接失败: %v", err)
+		}
+		conn.Close()
+	}
+
+	// 切换前不发送凭证，切换后每次使用新的流隔离凭证
+	dial()
+	for i := 0; i < 2; i++ {
+		if err := pm.RotateCircuit(); err != nil {
+			t.Fatalf("切换线路失败: %v", err)
+		}
+		dial()
+	}
+
+	want := "gohookproxy:circuit-1,gohookproxy:circuit-2"
+	if got := strings.Join(srv.Logins(), ","); got != want {
+		t.Errorf("流隔离凭证预期 %s, 实际: %s", want, got)
+	}
+	if got := control.Newnym(); got != 2 {
+		t.Errorf("预期发送 2 次 NEWNYM, 实际: %d", got)
+	}
+	if targets := srv.Targets(); len(targets) != 3 || targets[0] != echoAddr {
+		t.Errorf("目标地址应原样交给 Tor: %v", targets)
+	}
+}
+
+func TestTorIsolateDestination(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAnyAuth())
+
+	tor := C.DefaultTorConfig()
+	tor.IsolateDestination = true
+	pm := newTestManager(t, C.TOR, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.TorConfig = tor })
+
+	_, port, _ := strings.Cut(echoAddr, ":")
+	for _, addr := range []string{echoAddr, "localhost:" + port} {
+		conn, err := pm.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("连接 %s 失败: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	want := "127.0.0.1:circuit-0,localhost:circuit-0"
+	if got := strings.Join(srv.Logins(), ","); got != want {
+		t.Errorf("按目标隔离的凭证预期 %s, 实际: %s", want, got)
+	}
+	// 没有控制端口时只更换凭证
+	if err := pm.RotateCircuit(); err != nil {
+		t.Errorf("没有控制端口时切换线路不应失败: %v", err)
+	}
+}
+
+func TestTorControlErrors(t *testing.T) {
+	srv := startProxy(t, proxytest.NewSOCKSServer, proxytest.WithAnyAuth())
+	control := startTorControl(t, "secret")
+
+	tor := C.DefaultTorConfig()
+	tor.ControlAddr = control.Addr()
+	tor.ControlPassword = "wrong"
+	pm := newTestManager(t, C.TOR, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.TorConfig = tor })
+
+	if err := pm.RotateCircuit(); !errors.Is(err, E.ErrTorControl) {
+		t.Errorf("预期控制端口认证失败, 实际: %v", err)
+	}
+	if control.Newnym() != 0 {
+		t.Error("认证失败时不应发送 NEWNYM")
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	socks, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if err := socks.RotateCircuit(); !errors.Is(err, E.ErrTorNotConfigured) {
+		t.Errorf("非 Tor 代理预期 ErrTorNotConfigured, 实际: %v", err)
+	}
+
+	cfg.ProxyType = C.TOR
+	cfg.TorConfig.ControlAddr = "no-port"
+	if err := cfg.Validate(); err == nil {
+		t.Error("预期无效的控制端口地址验证失败")
+	}
+}
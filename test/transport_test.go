@@ -0,0 +1,171 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxy/transport"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// xorMagic 客户端在混淆前发送的前导，服务端据此识别插件
+var xorMagic = []byte("XOR1")
+
+func init() {
+	transport.Register("test-xor", func(options map[string]string) (transport.Transport, error) {
+		key, err := strconv.ParseUint(options["key"], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %q", options["key"])
+		}
+		return xorTransport{key: byte(key)}, nil
+	})
+}
+
+// xorTransport 测试用的传输插件，发送前导后对所有字节异或 key
+type xorTransport struct {
+	key byte
+}
+
+func (x xorTransport) Wrap(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if _, err := conn.Write(xorMagic); err != nil {
+		return nil, err
+	}
+	return &xorConn{Conn: conn, key: x.key}, nil
+}
+
+func (x xorTransport) Unwrap(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	magic := make([]byte, len(xorMagic))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, magic); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if !bytes.Equal(magic, xorMagic) {
+		return nil, fmt.Errorf("unexpected preamble: %q", magic)
+	}
+	return &xorConn{Conn: conn, key: x.key}, nil
+}
+
+type xorConn struct {
+	net.Conn
+	key byte
+}
+
+func (c *xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := range b[:n] {
+		b[i] ^= c.key
+	}
+	return n, err
+}
+
+func (c *xorConn) Write(b []byte) (int, error) {
+	buf := make([]byte, len(b))
+	for i := range b {
+		buf[i] = b[i] ^ c.key
+	}
+	return c.Conn.Write(buf)
+}
+
+func (c *xorConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// TestTransportPlugin 测试到代理的连接经过传输插件，代理协议在插件之上运行
+func TestTransportPlugin(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		proxyType C.ProxyType
+		newServer func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.SOCKS5, proxytest.NewSOCKSServer},
+		{C.HTTP, proxytest.NewHTTPServer},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tt.newServer, proxytest.WithTransport(xorTransport{key: 0x5a}))
+			cfg := C.DefaultConfig()
+			cfg.Enable = true
+			cfg.ProxyType = tt.proxyType
+			cfg.ProxyIP = srv.Host()
+			cfg.ProxyPort = srv.Port()
+			cfg.SOCKSConfig.Timeout = time.Second
+			cfg.HTTPConfig.Timeout = time.Second
+
+			// 没有插件时代理无法识别连接
+			pm, err := PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+			if conn, err := pm.Dial("tcp", echoAddr); err == nil {
+				conn.Close()
+				t.Fatal("没有传输插件时拨号应失败")
+			}
+
+			cfg.Transport = &C.TransportConfig{Name: "test-xor", Options: map[string]string{"key": "0x5a"}}
+			pm, err = PM.New(cfg)
+			if err != nil {
+				t.Fatalf("创建代理管理器失败: %v", err)
+			}
+			conn, err := pm.Dial("tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("经过传输插件拨号失败: %v", err)
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("回显失败: %q, %v", buf, err)
+			}
+			if _, ok := PM.Unwrap[*xorConn](conn); !ok {
+				t.Error("Unwrap 应能找到插件包装的连接")
+			}
+		})
+	}
+}
+
+// TestTransportConfig 测试插件的注册检查、选项错误和不支持插件的代理类型
+func TestTransportConfig(t *testing.T) {
+	if !slices.Contains(transport.Names(), "test-xor") {
+		t.Errorf("已注册的插件应出现在 Names 中: %v", transport.Names())
+	}
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 1080
+
+	cfg.Transport = &C.TransportConfig{Name: "missing"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("未注册的插件应验证失败")
+	}
+
+	cfg.Transport = &C.TransportConfig{Name: "test-xor", Options: map[string]string{"key": "bad"}}
+	if _, err := PM.New(cfg); err == nil {
+		t.Error("插件选项无效时创建代理管理器应失败")
+	}
+	if redacted := cfg.Redacted(); redacted.Transport.Options["key"] != C.RedactedSecret || cfg.Transport.Options["key"] != "bad" {
+		t.Errorf("插件选项应在副本中脱敏, 实际: %v, 原配置: %v", redacted.Transport.Options, cfg.Transport.Options)
+	}
+
+	cfg.ProxyType = C.HTTP3
+	cfg.Transport.Options["key"] = "1"
+	if _, err := PM.New(cfg); !errors.Is(err, E.ErrTransportUnsupported) {
+		t.Errorf("HTTP3 代理应返回 ErrTransportUnsupported, 实际: %v", err)
+	}
+}
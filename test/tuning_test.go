@@ -0,0 +1,83 @@
+//go:build linux
+
+package test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// socketOpt 读取连接最内层套接字的选项
+func socketOpt(t *testing.T, conn net.Conn, level, opt int) int {
+	sc, ok := PM.Unwrap[syscall.Conn](conn)
+	if !ok {
+		t.Fatalf("连接中没有套接字: %T", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		t.Fatalf("获取套接字失败: %v", err)
+	}
+	var v int
+	rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		t.Fatalf("读取套接字选项失败: %v", err)
+	}
+	return v
+}
+
+// TestRuleSocketTuning 测试规则的调优预设设置到代理连接的 TCP_NODELAY 和缓冲区
+func TestRuleSocketTuning(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.Rules = []C.Rule{
+		{Pattern: "localhost", Tuning: C.TuningThroughput},
+		{Type: C.RuleIPCIDR, Pattern: "127.0.0.1", Tuning: C.TuningLowLatency},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置验证失败: %v", err)
+	}
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", net.JoinHostPort("localhost", echoPort))
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	if nodelay := socketOpt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); nodelay != 0 {
+		t.Errorf("throughput 预设应关闭 TCP_NODELAY")
+	}
+	throughputBuf := socketOpt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	conn.Close()
+
+	conn, err = pm.Dial("tcp", net.JoinHostPort("127.0.0.1", echoPort))
+	if err != nil {
+		t.Fatalf("拨号失败: %v", err)
+	}
+	defer conn.Close()
+	if nodelay := socketOpt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); nodelay == 0 {
+		t.Errorf("low-latency 预设应开启 TCP_NODELAY")
+	}
+	if buf := socketOpt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF); buf >= throughputBuf {
+		t.Errorf("low-latency 的发送缓冲区应小于 throughput, 实际: %d >= %d", buf, throughputBuf)
+	}
+
+	cfg.Rules = []C.Rule{{Pattern: "localhost", Tuning: "fast"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("未知的调优预设应验证失败")
+	}
+}
@@ -0,0 +1,308 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+func TestSOCKS5UDPKeepAlive(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HookUDP = true
+	cfg.SOCKSConfig.UDPKeepAlive = 50 * time.Millisecond
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("UDP 回显失败: %q, %v", buf[:n], err)
+	}
+
+	// 空闲期间应定期发送零长度保活数据报
+	time.Sleep(300 * time.Millisecond)
+	if got := srv.EmptyDatagrams(); got < 2 {
+		t.Errorf("空闲期间保活数据报过少: %d", got)
+	}
+
+	// 关闭后不再发送
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	before := srv.EmptyDatagrams()
+	time.Sleep(200 * time.Millisecond)
+	if after := srv.EmptyDatagrams(); after != before {
+		t.Errorf("关闭后仍在发送保活数据报: %d -> %d", before, after)
+	}
+}
+
+func TestSOCKS5UDPMetrics(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HookUDP = true
+	cfg.MetricsEnable = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("udp", echoAddr)
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer conn.Close()
+	udpConn, ok := conn.(*PM.SocksUDPConn)
+	if !ok {
+		t.Fatalf("UDP 连接类型不符: %T", conn)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 64)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("UDP 回显失败: %v", err)
+	}
+
+	// 超过读缓冲区的数据报
+	conn.Write(make([]byte, 100))
+	if n, err := conn.Read(make([]byte, 10)); n != 10 || err != io.ErrShortBuffer {
+		t.Fatalf("超过缓冲区的数据报应返回 io.ErrShortBuffer: %d, %v", n, err)
+	}
+
+	stats := udpConn.Stats()
+	if stats.Associations != 1 || stats.PacketsSent != 2 || stats.PacketsReceived != 2 || stats.OversizedDrops != 1 {
+		t.Errorf("关联统计不符: %+v", stats)
+	}
+
+	// 代理关闭控制连接后关联失效
+	srv.Close()
+	deadline := time.Now().Add(time.Second)
+	for udpConn.Stats().RelayResets == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	total := pm.GetMetrics().UDP
+	if total.Associations != 1 || total.PacketsSent != 2 || total.RelayResets != 1 {
+		t.Errorf("汇总 UDP 指标不符: %+v", total)
+	}
+	if _, err := conn.Write([]byte("ping")); err == nil {
+		t.Error("中继关闭后写入应失败")
+	}
+}
+
+func TestSOCKS5UDPDatagramSize(t *testing.T) {
+	// 目标对每个请求先回复一个超长数据报，再回复一个正常数据报
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动 UDP 服务失败: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, from, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target.WriteTo(make([]byte, 1000), from)
+			target.WriteTo([]byte("ok"), from)
+		}
+	}()
+
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HookUDP = true
+	cfg.SOCKSConfig.MaxDatagramSize = 512
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	conn, err := pm.Dial("udp", target.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(make([]byte, 600)); !errors.Is(err, E.ErrSOCKS5DatagramTooLarge) {
+		t.Fatalf("超过最大负载的写入应失败: %v", err)
+	}
+
+	conn.Write([]byte("hi"))
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ok" {
+		t.Fatalf("应丢弃超长数据报并返回下一个: %q, %v", buf[:n], err)
+	}
+
+	if stats := conn.(*PM.SocksUDPConn).Stats(); stats.OversizedDrops != 2 || stats.PacketsReceived != 1 {
+		t.Errorf("超长数据报统计不符: %+v", stats)
+	}
+
+	cfg.SOCKSConfig.MaxDatagramSize = C.MaxDatagramSize + 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("应拒绝超过理论上限的最大负载")
+	}
+}
+
+// TestSOCKS5ListenPacket 测试不固定目标的 UDP 关联通过 WriteTo/ReadFrom 收发
+func TestSOCKS5ListenPacket(t *testing.T) {
+	echoAddr := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.SOCKS5
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.HookUDP = true
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	if _, ok := pm.GetDialer().(PM.PacketDialer); !ok {
+		t.Fatal("SOCKS5 拨号器应实现 PacketDialer")
+	}
+
+	pc, err := pm.ListenPacket(context.Background(), "udp")
+	if err != nil {
+		t.Fatalf("建立 UDP 关联失败: %v", err)
+	}
+	defer pc.Close()
+
+	// 没有默认目标时 Write 失败
+	if _, err := pc.(net.Conn).Write([]byte("ping")); !errors.Is(err, E.ErrSOCKS5NoTarget) {
+		t.Errorf("预期 ErrSOCKS5NoTarget, 实际: %v", err)
+	}
+
+	raddr, _ := net.ResolveUDPAddr("udp", echoAddr)
+	if _, err := pc.WriteTo([]byte("ping"), raddr); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 64)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("UDP 回显失败: %q, %v", buf[:n], err)
+	}
+	if from.String() != raddr.String() {
+		t.Errorf("来源地址错误: 预期 %s, 实际 %s", raddr, from)
+	}
+}
+
+// TestUDPProxySeparate 测试 TCP 走 HTTP 代理、UDP 走单独配置的 SOCKS5 代理
+func TestUDPProxySeparate(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	udpEchoAddr := startUDPEchoServer(t)
+	httpSrv := startProxy(t, proxytest.NewHTTPServer)
+	socksSrv := startProxy(t, proxytest.NewSOCKSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.HookUDP = true
+	cfg.ProxyType = C.HTTP
+	cfg.ProxyIP = httpSrv.Host()
+	cfg.ProxyPort = httpSrv.Port()
+	cfg.UDPProxy = &C.UDPProxyConfig{ProxyType: C.SOCKS5, ProxyIP: socksSrv.Host(), ProxyPort: socksSrv.Port()}
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	conn, err := pm.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("经过 HTTP 代理拨号失败: %v", err)
+	}
+	conn.Close()
+
+	uc, err := pm.Dial("udp", udpEchoAddr)
+	if err != nil {
+		t.Fatalf("经过 UDP 代理建立关联失败: %v", err)
+	}
+	defer uc.Close()
+	if _, err := uc.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, 64)
+	uc.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := uc.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("UDP 回显失败: %q, %v", buf[:n], err)
+	}
+
+	if targets := httpSrv.Targets(); len(targets) != 1 || targets[0] != echoAddr {
+		t.Errorf("HTTP 代理只应收到 TCP 目标, 实际: %v", targets)
+	}
+	if targets := socksSrv.Targets(); len(targets) != 1 {
+		t.Errorf("SOCKS5 代理应收到一次 UDP 关联, 实际: %v", targets)
+	}
+
+	pc, err := pm.ListenPacket(context.Background(), "udp")
+	if err != nil {
+		t.Fatalf("经过 UDP 代理创建套接字失败: %v", err)
+	}
+	pc.Close()
+
+	// 到 UDP 代理本身的连接直连
+	if d := pm.Explain("tcp", socksSrv.Addr()); d.Action != "direct" {
+		t.Errorf("到 UDP 代理的连接应直连, 实际: %s", d)
+	}
+
+	for _, typ := range []C.ProxyType{C.SOCKS4, C.Direct} {
+		bad := *cfg
+		bad.UDPProxy = &C.UDPProxyConfig{ProxyType: typ, ProxyIP: socksSrv.Host(), ProxyPort: socksSrv.Port()}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%s 不能作为 UDP 代理", typ)
+		}
+	}
+	bad := *cfg
+	bad.UDPProxy = &C.UDPProxyConfig{ProxyType: C.SSH, ProxyIP: socksSrv.Host(), ProxyPort: socksSrv.Port()}
+	bad.SSHConfig = C.DefaultSSHConfig()
+	bad.SSHConfig.User = "user"
+	bad.SSHConfig.Password = "pass"
+	bad.SSHConfig.InsecureIgnoreHostKey = true
+	if _, err := PM.New(&bad); !errors.Is(err, E.ErrUnsupportedProxy) {
+		t.Errorf("不能转发 UDP 的代理应创建失败, 实际: %v", err)
+	}
+}
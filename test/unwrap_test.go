@@ -0,0 +1,73 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// auditConn 调用方自己的包装连接
+type auditConn struct {
+	net.Conn
+}
+
+func (c *auditConn) Unwrap() net.Conn { return c.Conn }
+
+// TestUnwrap 测试沿包装链穿过计数连接、WebSocket 隧道和调用方的包装找到 TLS 和 TCP 连接
+func TestUnwrap(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewWSSServer)
+
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.WSS
+	cfg.ProxyIP = srv.Host()
+	cfg.ProxyPort = srv.Port()
+	cfg.WSConfig.SkipVerify = true
+	cfg.MetricsEnable = true
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+
+	ctx := PM.WithLabels(context.Background(), map[string]string{"app": "audit"})
+	conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("通过 wss 隧道连接失败: %v", err)
+	}
+	defer conn.Close()
+	var wrapped net.Conn = &auditConn{Conn: conn}
+	sshEcho(t, wrapped)
+
+	audit, ok := PM.Unwrap[*auditConn](wrapped)
+	if !ok || audit != wrapped {
+		t.Error("最外层的连接本身应匹配")
+	}
+	tlsConn, ok := PM.Unwrap[*tls.Conn](wrapped)
+	if !ok || !tlsConn.ConnectionState().HandshakeComplete {
+		t.Fatal("应找到到服务器的 TLS 连接")
+	}
+	if tcp, ok := PM.UnwrapAll(wrapped).(*net.TCPConn); !ok || tcp.RemoteAddr().String() != srv.Addr() {
+		t.Errorf("最内层应为到服务器的 TCP 连接, 实际: %T", PM.UnwrapAll(wrapped))
+	}
+	if _, ok := PM.Unwrap[*net.UDPConn](wrapped); ok {
+		t.Error("包装链中没有 UDP 连接")
+	}
+}
+
+// TestUnwrapCycle 测试实现错误形成环的包装链不会死循环
+func TestUnwrapCycle(t *testing.T) {
+	c := &auditConn{}
+	c.Conn = c
+	if _, ok := PM.Unwrap[*net.TCPConn](c); ok {
+		t.Error("环形包装链中没有 TCP 连接")
+	}
+	if PM.UnwrapAll(c) == nil {
+		t.Error("UnwrapAll 应返回非 nil 连接")
+	}
+}
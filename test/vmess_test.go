@@ -0,0 +1,147 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	"github.com/ba0gu0/GoHookProxy/proxy/vmess"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+const testVMessUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// withVMess 使用测试的 UUID 和 security 加密，同时代理 UDP
+func withVMess(security string) func(*C.Config) {
+	return func(cfg *C.Config) {
+		cfg.HookUDP = true
+		cfg.VMessConfig.UUID = testVMessUUID
+		cfg.VMessConfig.Security = security
+	}
+}
+
+// TestVMessDial 测试通过 VMess 代理转发 TCP 和 UDP，数据跨越多个块
+func TestVMessDial(t *testing.T) {
+	echo := startEchoServer(t)
+	udpEcho := startUDPEchoServer(t)
+	srv := startProxy(t, proxytest.NewVMessServer, proxytest.WithUUID(testVMessUUID))
+
+	for _, security := range []string{"auto", "none"} {
+		pm := newTestManager(t, C.VMESS, srv.Host(), srv.Port(), withVMess(security))
+
+		conn, err := pm.Dial("tcp", echo)
+		if err != nil {
+			t.Fatalf("%s: 通过 VMess 连接失败: %v", security, err)
+		}
+		payload := bytes.Repeat([]byte("vmess"), 5000)
+		go conn.Write(payload)
+		got := make([]byte, len(payload))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("%s: 回显数据不一致: %v", security, err)
+		}
+		conn.Close()
+
+		udp, err := pm.Dial("udp", udpEcho)
+		if err != nil {
+			t.Fatalf("%s: 建立 VMess UDP 连接失败: %v", security, err)
+		}
+		for _, msg := range []string{"ping", "pong"} {
+			if _, err := udp.Write([]byte(msg)); err != nil {
+				t.Fatalf("%s: 发送数据报失败: %v", security, err)
+			}
+			buf := make([]byte, 64)
+			udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := udp.Read(buf)
+			if err != nil || string(buf[:n]) != msg {
+				t.Fatalf("%s: UDP 回显失败: %q, %v", security, buf[:n], err)
+			}
+		}
+		udp.Close()
+	}
+
+	if targets := srv.Targets(); len(targets) != 4 || targets[0] != echo || targets[1] != udpEcho {
+		t.Errorf("服务端收到的目标不正确: %v", targets)
+	}
+}
+
+// TestVMessWrongUUID 测试 UUID 不匹配时服务端拒绝请求头，读取失败
+func TestVMessWrongUUID(t *testing.T) {
+	echo := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewVMessServer, proxytest.WithUUID("00000000-0000-0000-0000-000000000001"))
+	pm := newTestManager(t, C.VMESS, srv.Host(), srv.Port(), withVMess("aes-128-gcm"))
+
+	conn, err := pm.Dial("tcp", echo)
+	if err != nil {
+		t.Fatalf("请求头随拨号发出，拨号本身应成功: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Error("UUID 错误时读取应失败")
+	}
+	if len(srv.Targets()) != 0 {
+		t.Errorf("认证失败的请求不应被转发: %v", srv.Targets())
+	}
+}
+
+// TestVMessConfig 测试 VMess 配置验证和请求头编解码
+func TestVMessConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.VMESS
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 10086
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少 UUID 时验证应失败")
+	}
+	cfg.VMessConfig.UUID = testVMessUUID
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("有效配置验证失败: %v", err)
+	}
+	cfg.VMessConfig.Security = "chacha20-poly1305"
+	if err := cfg.Validate(); err == nil {
+		t.Error("不支持的加密方式应验证失败")
+	}
+
+	if _, err := vmess.ParseUUID("not-a-uuid"); !errors.Is(err, E.ErrVMessInvalidUUID) {
+		t.Errorf("预期 ErrVMessInvalidUUID, 实际: %v", err)
+	}
+
+	id, _ := vmess.ParseUUID(testVMessUUID)
+	if id.String() != testVMessUUID {
+		t.Errorf("UUID 格式化不一致: %s", id)
+	}
+	for _, addr := range []string{"example.com:443", "10.0.0.1:53", "[2001:db8::1]:8080"} {
+		h, err := vmess.NewRequestHeader(vmess.CmdTCP, vmess.SecurityAES128GCM, addr)
+		if err != nil {
+			t.Fatalf("创建请求头失败: %v", err)
+		}
+		plain, err := h.Marshal()
+		if err != nil {
+			t.Fatalf("编码请求头失败: %v", err)
+		}
+		sealed, err := vmess.SealHeader(id.CmdKey(), plain, time.Now())
+		if err != nil {
+			t.Fatalf("加密请求头失败: %v", err)
+		}
+		opened, err := vmess.OpenHeader(bytes.NewReader(sealed), id.CmdKey(), time.Now())
+		if err != nil {
+			t.Fatalf("解密请求头失败: %v", err)
+		}
+		parsed, err := vmess.ParseRequestHeader(opened)
+		if err != nil || parsed.Target() != addr || parsed.BodyKey != h.BodyKey {
+			t.Errorf("请求头往返不一致: %v, %v", parsed, err)
+		}
+
+		// 时间戳偏差过大时拒绝
+		if _, err := vmess.OpenHeader(bytes.NewReader(sealed), id.CmdKey(), time.Now().Add(time.Hour)); !errors.Is(err, E.ErrVMessAuthFailed) {
+			t.Errorf("过期的请求头应被拒绝: %v", err)
+		}
+	}
+}
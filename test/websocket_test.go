@@ -0,0 +1,108 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"github.com/ba0gu0/GoHookProxy/proxytest"
+)
+
+// TestWSDial 测试通过 ws 和 wss 隧道连接，目标主机名交给服务器
+func TestWSDial(t *testing.T) {
+	_, echoPort, _ := net.SplitHostPort(startEchoServer(t))
+	target := net.JoinHostPort("localhost", echoPort)
+
+	for _, tc := range []struct {
+		proxyType C.ProxyType
+		start     func(...proxytest.Option) (*proxytest.Server, error)
+	}{
+		{C.WS, proxytest.NewWSServer},
+		{C.WSS, proxytest.NewWSSServer},
+	} {
+		t.Run(string(tc.proxyType), func(t *testing.T) {
+			srv := startProxy(t, tc.start, proxytest.WithAuth("ws", "secret"))
+			ws := C.DefaultWSConfig()
+			ws.User = "ws"
+			ws.Pass = "secret"
+			ws.SkipVerify = true
+			pm := newTestManager(t, tc.proxyType, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.WSConfig = ws })
+
+			conn, err := pm.Dial("tcp", target)
+			if err != nil {
+				t.Fatalf("通过 WebSocket 连接失败: %v", err)
+			}
+			defer conn.Close()
+			sshEcho(t, conn)
+			if _, ok := conn.RemoteAddr().(*net.TCPAddr); !ok {
+				t.Errorf("远端地址应为到服务器的 TCP 地址, 实际: %T", conn.RemoteAddr())
+			}
+			if targets := srv.Targets(); len(targets) != 1 || targets[0] != target {
+				t.Errorf("服务器应收到主机名 %s, 实际: %v", target, targets)
+			}
+
+			if _, err := pm.Dial("udp", target); !errors.Is(err, E.ErrWSNetworkNotSupported) {
+				t.Errorf("WebSocket 不支持 UDP, 实际: %v", err)
+			}
+		})
+	}
+}
+
+// TestWSAuth 测试认证失败和路由规则指定的凭证
+func TestWSAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	srv := startProxy(t, proxytest.NewWSServer, proxytest.WithAuth("rule", "pass"))
+
+	ws := C.DefaultWSConfig()
+	ws.Headers = map[string]string{"Authorization": "Basic d3Jvbmc6d3Jvbmc="}
+	pm := newTestManager(t, C.WS, srv.Host(), srv.Port(), func(cfg *C.Config) { cfg.WSConfig = ws })
+	if _, err := pm.Dial("tcp", echoAddr); !errors.Is(err, E.ErrWSHandshakeFailed) {
+		t.Errorf("认证失败应返回 ErrWSHandshakeFailed, 实际: %v", err)
+	}
+
+	ctx := PM.WithCredentials(context.Background(), PM.Credentials{User: "rule", Pass: "pass"})
+	conn, err := pm.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("规则凭证应覆盖附加头: %v", err)
+	}
+	sshEcho(t, conn)
+	conn.Close()
+	if logins := srv.Logins(); len(logins) != 1 || logins[0] != "rule:pass" {
+		t.Errorf("服务器应收到规则凭证, 实际: %v", logins)
+	}
+}
+
+// TestWSConfig 测试 WebSocket 配置的校验和脱敏
+func TestWSConfig(t *testing.T) {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.WSS
+	cfg.ProxyIP = "192.0.2.1"
+	cfg.ProxyPort = 443
+	cfg.WSConfig.Path = "/tunnel/{host}/{port}"
+	cfg.WSConfig.Headers = map[string]string{"Cookie": "session=secret", "X-Client": "gohookproxy"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+
+	redacted := cfg.Redacted()
+	if redacted.WSConfig.Headers["Cookie"] != C.RedactedSecret || redacted.WSConfig.Headers["X-Client"] != "gohookproxy" {
+		t.Errorf("只有携带凭证的头应脱敏: %v", redacted.WSConfig.Headers)
+	}
+	if cfg.WSConfig.Headers["Cookie"] != "session=secret" {
+		t.Error("脱敏不应修改原配置")
+	}
+
+	cfg.WSConfig.Path = "tunnel"
+	if err := cfg.Validate(); err == nil {
+		t.Error("不以 / 开头的路径应校验失败")
+	}
+	cfg.WSConfig = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少 WebSocket 配置应校验失败")
+	}
+}
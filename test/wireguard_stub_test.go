@@ -0,0 +1,17 @@
+//go:build !wireguard
+
+package test
+
+import (
+	"errors"
+	"testing"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+)
+
+func TestWireGuardNotBuilt(t *testing.T) {
+	if _, err := PM.New(newWireGuardConfig(t)); !errors.Is(err, E.ErrWireGuardNotBuilt) {
+		t.Errorf("未使用 wireguard 标签构建时预期 ErrWireGuardNotBuilt, 实际: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	C "github.com/ba0gu0/GoHookProxy/config"
+)
+
+func wireGuardKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newWireGuardConfig(t *testing.T) *C.Config {
+	cfg := C.DefaultConfig()
+	cfg.Enable = true
+	cfg.ProxyType = C.WIREGUARD
+	cfg.ProxyIP = "127.0.0.1"
+	cfg.ProxyPort = 51820
+	cfg.WGConfig.PrivateKey = wireGuardKey(t)
+	cfg.WGConfig.PublicKey = wireGuardKey(t)
+	cfg.WGConfig.Address = []string{"10.0.0.2/32", "fd00::2"}
+	return cfg
+}
+
+func TestWireGuardConfigValidate(t *testing.T) {
+	if err := newWireGuardConfig(t).Validate(); err != nil {
+		t.Fatalf("有效的 WireGuard 配置验证失败: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(w *C.WGConfig)
+	}{
+		{"私钥不是 base64", func(w *C.WGConfig) { w.PrivateKey = "not-a-key" }},
+		{"公钥长度错误", func(w *C.WGConfig) { w.PublicKey = base64.StdEncoding.EncodeToString(make([]byte, 16)) }},
+		{"预共享密钥无效", func(w *C.WGConfig) { w.PresharedKey = "short" }},
+		{"缺少接口地址", func(w *C.WGConfig) { w.Address = nil }},
+		{"接口地址无效", func(w *C.WGConfig) { w.Address = []string{"10.0.0.300"} }},
+		{"DNS 无效", func(w *C.WGConfig) { w.DNS = []string{"dns.example"} }},
+		{"AllowedIPs 无效", func(w *C.WGConfig) { w.AllowedIPs = []string{"10.0.0.0"} }},
+		{"MTU 过小", func(w *C.WGConfig) { w.MTU = 100 }},
+		{"保活间隔为负", func(w *C.WGConfig) { w.PersistentKeepalive = -time.Second }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newWireGuardConfig(t)
+			tt.modify(cfg.WGConfig)
+			if err := cfg.Validate(); err == nil {
+				t.Error("预期验证失败")
+			}
+		})
+	}
+
+	cfg := newWireGuardConfig(t)
+	cfg.WGConfig = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("缺少 WireGuard 配置时预期验证失败")
+	}
+}
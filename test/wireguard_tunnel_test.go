@@ -0,0 +1,121 @@
+//go:build wireguard
+
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	E "github.com/ba0gu0/GoHookProxy/errors"
+	PM "github.com/ba0gu0/GoHookProxy/proxy"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// startWireGuardPeer 启动进程内的 WireGuard 对端，隧道地址 10.0.0.1，在 10.0.0.1:7 上回显
+func startWireGuardPeer(t *testing.T, clientPublic []byte) (publicKey string, port int) {
+	private := make([]byte, 32)
+	copy(private, []byte("gohookproxy-wireguard-test-peer!"))
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.0.0.1")}, nil, 1420)
+	if err != nil {
+		t.Fatalf("创建对端接口失败: %v", err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	uapi := fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=10.0.0.2/32\n",
+		hex.EncodeToString(private), hex.EncodeToString(clientPublic))
+	if err := dev.IpcSet(uapi); err != nil {
+		t.Fatalf("配置对端失败: %v", err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatalf("启动对端失败: %v", err)
+	}
+	if _, err := fmt.Sscanf(ipcGet(t, dev, "listen_port"), "%d", &port); err != nil {
+		t.Fatalf("读取对端端口失败: %v", err)
+	}
+
+	ln, err := tnet.ListenTCP(&net.TCPAddr{Port: 7})
+	if err != nil {
+		t.Fatalf("对端监听失败: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return base64.StdEncoding.EncodeToString(public), port
+}
+
+func ipcGet(t *testing.T, dev *device.Device, key string) string {
+	out, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok && k == key {
+			value = v
+		}
+	}
+	return value
+}
+
+func TestWireGuardTunnel(t *testing.T) {
+	cfg := newWireGuardConfig(t)
+	cfg.WGConfig.Address = []string{"10.0.0.2/32"}
+	cfg.WGConfig.AllowedIPs = []string{"10.0.0.0/24"}
+
+	private, _ := base64.StdEncoding.DecodeString(cfg.WGConfig.PrivateKey)
+	clientPublic, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.WGConfig.PublicKey, cfg.ProxyPort = startWireGuardPeer(t, clientPublic)
+
+	pm, err := PM.New(cfg)
+	if err != nil {
+		t.Fatalf("创建代理管理器失败: %v", err)
+	}
+	t.Cleanup(func() { pm.GetDialer().(*PM.WireGuardDialer).Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pm.DialContext(ctx, "tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("经隧道连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("回显失败: %q, %v", buf, err)
+	}
+
+	if _, err := pm.Dial("tcp", "192.168.1.1:80"); !errors.Is(err, E.ErrWireGuardNoRoute) {
+		t.Errorf("AllowedIPs 之外的目标预期 ErrWireGuardNoRoute, 实际: %v", err)
+	}
+}